@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Actor recorded on events caused by admin operations
+const adminActor = "admin"
+
+// SeatChange describes one seat affected by an admin operation
+type SeatChange struct {
+	SeatID     string            `json:"seat_id"`
+	FromStatus shared.SeatStatus `json:"from_status"`
+	ToStatus   shared.SeatStatus `json:"to_status"`
+	HeldBy     string            `json:"held_by,omitempty"` // holder of an active hold or booking that the change breaks
+}
+
+// AdminChangeReport summarizes what an admin operation changed, or would change in dry-run mode
+type AdminChangeReport struct {
+	DryRun          bool         `json:"dry_run"`
+	SeatsAffected   int          `json:"seats_affected"`
+	HoldsBroken     int          `json:"holds_broken"`
+	BookingsCleared int          `json:"bookings_cleared"`
+	Changes         []SeatChange `json:"changes"`
+}
+
+// ResetVenue returns every held or booked seat to available. With dryRun set it
+// only reports what would change.
+func ResetVenue(dryRun bool) (*AdminChangeReport, error) {
+	seats, err := GetAllSeats()
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []shared.Seat
+	for _, seat := range seats {
+		if seat.Status != shared.SeatAvailable {
+			targets = append(targets, seat)
+		}
+	}
+
+	return applyAdminChanges(targets, shared.SeatAvailable, dryRun)
+}
+
+// BulkUpdateSeats sets the listed seats to status (available or booked). Unknown
+// seat IDs fail the whole operation. With dryRun set it only reports what would change.
+func BulkUpdateSeats(seatIDs []string, status shared.SeatStatus, dryRun bool) (*AdminChangeReport, error) {
+	if status != shared.SeatAvailable && status != shared.SeatBooked {
+		return nil, fmt.Errorf("status must be %s or %s", shared.SeatAvailable, shared.SeatBooked)
+	}
+	if len(seatIDs) == 0 {
+		return nil, fmt.Errorf("seat_ids is required")
+	}
+
+	var targets []shared.Seat
+	var unknown []string
+	for _, seatID := range seatIDs {
+		seat, err := seatStore.GetSeat(seatID)
+		if err == errSeatNotFound {
+			unknown = append(unknown, seatID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seat.Status != status {
+			targets = append(targets, *seat)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown seats: %s", strings.Join(unknown, ", "))
+	}
+
+	return applyAdminChanges(targets, status, dryRun)
+}
+
+// applyAdminChanges moves each seat to status, breaking any active holds, and
+// reports the changes. Nothing is written in dry-run mode.
+func applyAdminChanges(seats []shared.Seat, status shared.SeatStatus, dryRun bool) (*AdminChangeReport, error) {
+	sort.Slice(seats, func(i, j int) bool {
+		return seats[i].ID < seats[j].ID
+	})
+
+	report := &AdminChangeReport{
+		DryRun:  dryRun,
+		Changes: make([]SeatChange, 0, len(seats)),
+	}
+
+	for i := range seats {
+		seat := &seats[i]
+		change := SeatChange{
+			SeatID:     seat.ID,
+			FromStatus: seat.Status,
+			ToStatus:   status,
+			HeldBy:     seat.HeldBy,
+		}
+
+		if !dryRun {
+			if err := applyAdminStatus(seat, status); err != nil {
+				return nil, fmt.Errorf("failed to update seat %s: %w", seat.ID, err)
+			}
+		}
+
+		report.Changes = append(report.Changes, change)
+		report.SeatsAffected++
+		switch change.FromStatus {
+		case shared.SeatHeld:
+			report.HoldsBroken++
+		case shared.SeatBooked:
+			report.BookingsCleared++
+		}
+	}
+
+	if !dryRun {
+		slog.Info("Moved seats", shared.LogKeyComponent, "admin", "status", status,
+			"seats", report.SeatsAffected, "holds_broken", report.HoldsBroken, "bookings_cleared", report.BookingsCleared)
+	}
+	return report, nil
+}
+
+// applyAdminStatus writes a single seat's new status and notifies clients
+func applyAdminStatus(seat *shared.Seat, status shared.SeatStatus) error {
+	previousHolder := seat.HeldBy
+	seat.Status = status
+	seat.ExpiresAt = 0
+	seat.HeldAt = 0
+	if status == shared.SeatBooked {
+		seat.HeldBy = adminActor
+	} else {
+		seat.HeldBy = ""
+	}
+
+	eventType := "released"
+	if status == shared.SeatBooked {
+		eventType = "booked"
+	}
+
+	// Break any hold on the seat: its lock goes with the update, unless
+	// someone else has taken it since the seat was read
+	if err := casEndHold(seatStore, seat, seat.Version, eventType, adminActor, previousHolder, sourceAdmin); err != nil {
+		return err
+	}
+	seatStore.UnindexHold(seat.ID)
+	cancelHoldExpiry(seat.ID)
+
+	if status == shared.SeatBooked {
+		recordBooking(seat, adminActor, nil)
+	} else {
+		forgetBooking(seat.ID)
+	}
+	return nil
+}
+
+// Limits on how long a debug trace may run
+const (
+	defaultDebugTraceDuration = 15 * time.Minute
+	maxDebugTraceDuration     = 24 * time.Hour
+)
+
+var errTracingUnavailable = errors.New("debug traces need NATS to reach the edges")
+
+var errEdgesUnreachable = errors.New("disconnecting users needs NATS to reach the edges")
+
+// DisconnectUser tells every edge to close all of userID's connections with
+// reason, e.g. to cut off a bot mid-sale, and returns what it published
+func DisconnectUser(nc *nats.Conn, userID, reason string) (*shared.KickUser, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+	if nc == nil {
+		return nil, errEdgesUnreachable
+	}
+
+	kick := shared.KickUser{UserID: userID, Reason: reason, Timestamp: time.Now()}
+	kickJSON, err := json.Marshal(kick)
+	if err != nil {
+		return nil, err
+	}
+	if err := nc.Publish(shared.NATSTopicKickUser, kickJSON); err != nil {
+		return nil, err
+	}
+	slog.Warn("Disconnecting user from every edge", shared.LogKeyComponent, "admin", shared.LogKeyUserID, userID, "reason", reason)
+	return &kick, nil
+}
+
+// DebugTraceRequest is the body of PUT and DELETE /api/admin/debug/trace
+type DebugTraceRequest struct {
+	UserID   string `json:"user_id"`
+	ClientID string `json:"client_id"`
+	Duration string `json:"duration"` // e.g. "30m"; defaults to 15m
+}
+
+// SetDebugTrace tells every edge to trace (or, with enable false, stop tracing)
+// the user or connection the request names, and returns what it published
+func SetDebugTrace(nc *nats.Conn, req DebugTraceRequest, enable bool) (*shared.DebugTrace, error) {
+	if req.UserID == "" && req.ClientID == "" {
+		return nil, errors.New("user_id or client_id is required")
+	}
+
+	trace := shared.DebugTrace{UserID: req.UserID, ClientID: req.ClientID}
+	if enable {
+		duration := defaultDebugTraceDuration
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 || d > maxDebugTraceDuration {
+				return nil, fmt.Errorf("duration must be between 0 and %s", maxDebugTraceDuration)
+			}
+			duration = d
+		}
+		trace.Until = time.Now().Add(duration)
+	}
+
+	if nc == nil {
+		return nil, errTracingUnavailable
+	}
+	traceJSON, err := json.Marshal(trace)
+	if err != nil {
+		return nil, err
+	}
+	if err := nc.Publish(shared.NATSTopicDebugTrace, traceJSON); err != nil {
+		return nil, err
+	}
+
+	if enable {
+		slog.Info("Tracing connections", shared.LogKeyComponent, "admin", shared.LogKeyUserID, trace.UserID, shared.LogKeyClientID, trace.ClientID, "until", trace.Until)
+	} else {
+		slog.Info("Stopped tracing connections", shared.LogKeyComponent, "admin", shared.LogKeyUserID, trace.UserID, shared.LogKeyClientID, trace.ClientID)
+	}
+	return &trace, nil
+}
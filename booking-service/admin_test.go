@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestDryRunReportsChangesWithoutWriting(t *testing.T) {
+	// redisClient is nil: a dry run must not touch Redis
+	seats := []shared.Seat{
+		{ID: "B2", Status: shared.SeatBooked, HeldBy: "bob"},
+		{ID: "A1", Status: shared.SeatHeld, HeldBy: "alice"},
+		{ID: "A3", Status: shared.SeatHeld, HeldBy: "carol"},
+	}
+
+	report, err := applyAdminChanges(seats, shared.SeatAvailable, true)
+	if err != nil {
+		t.Fatalf("applyAdminChanges: %v", err)
+	}
+	if !report.DryRun || report.SeatsAffected != 3 || report.HoldsBroken != 2 || report.BookingsCleared != 1 {
+		t.Fatalf("report = %+v, want a dry run of 3 seats with 2 holds broken and 1 booking cleared", report)
+	}
+	for i, want := range []string{"A1", "A3", "B2"} {
+		if change := report.Changes[i]; change.SeatID != want || change.ToStatus != shared.SeatAvailable {
+			t.Errorf("change %d = %+v, want %s made available", i, change, want)
+		}
+	}
+	if report.Changes[0].HeldBy != "alice" || report.Changes[2].FromStatus != shared.SeatBooked {
+		t.Errorf("changes = %+v, want the broken holder and previous status", report.Changes)
+	}
+}
+
+func TestBulkUpdateSeatsValidatesBeforeReading(t *testing.T) {
+	if _, err := BulkUpdateSeats([]string{"A1"}, shared.SeatHeld, true); err == nil {
+		t.Error("held status: want an error")
+	}
+	if _, err := BulkUpdateSeats(nil, shared.SeatBooked, true); err == nil {
+		t.Error("no seats: want an error")
+	}
+}
+
+func TestSetDebugTraceValidatesBeforePublishing(t *testing.T) {
+	for name, req := range map[string]DebugTraceRequest{
+		"no target":     {Duration: "5m"},
+		"bad duration":  {UserID: "user-1", Duration: "soon"},
+		"too long":      {UserID: "user-1", Duration: "48h"},
+		"zero duration": {UserID: "user-1", Duration: "0s"},
+	} {
+		if _, err := SetDebugTrace(nil, req, true); err == nil || err == errTracingUnavailable {
+			t.Errorf("%s: got %v, want a validation error", name, err)
+		}
+	}
+
+	if _, err := SetDebugTrace(nil, DebugTraceRequest{UserID: "user-1"}, true); err != errTracingUnavailable {
+		t.Fatalf("without NATS: got %v, want errTracingUnavailable", err)
+	}
+}
+
+func TestDisconnectUserValidatesBeforePublishing(t *testing.T) {
+	if _, err := DisconnectUser(nil, "", "bot"); err == nil || err == errEdgesUnreachable {
+		t.Errorf("no user: got %v, want a validation error", err)
+	}
+	if _, err := DisconnectUser(nil, "user-1", ""); err != errEdgesUnreachable {
+		t.Errorf("without NATS: got %v, want errEdgesUnreachable", err)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"concert-booking/shared"
+)
+
+// deprecatedAPI marks responses to the unversioned /api routes as deprecated
+// and links the same route under /api/v1, so clients still using them can be
+// found in logs and moved before the alias is removed
+func deprecatedAPI() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		successor := shared.APIPrefix + strings.TrimPrefix(c.Request.URL.Path, shared.APIPrefixLegacy)
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestAPIIsServedUnderBothPrefixes(t *testing.T) {
+	newTestRedis(t)
+	router := adminRouter(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, shared.APIEndpointSeats+"/A1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s/A1 = %d: %s", shared.APIEndpointSeats, w.Code, w.Body)
+	}
+	if w.Header().Get("Deprecation") != "" {
+		t.Errorf("versioned route is marked deprecated")
+	}
+	versioned := w.Body.String()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats/A1", nil))
+	if w.Code != http.StatusOK || w.Body.String() != versioned {
+		t.Fatalf("GET /api/seats/A1 = %d: %s, want what /api/v1 serves", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Deprecation"); got != "true" {
+		t.Errorf("Deprecation = %q, want true", got)
+	}
+	if got, want := w.Header().Get("Link"), `</api/v1/seats/A1>; rel="successor-version"`; got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+
+	// Admin routes moved with the rest
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, shared.APIPrefix+"/admin/flags", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET %s/admin/flags = %d: %s", shared.APIPrefix, w.Code, w.Body)
+	}
+}
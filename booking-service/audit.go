@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources recorded on seat events, saying how a transition came in
+const (
+	sourceAPI     = "api"     // the HTTP API
+	sourceNATS    = "nats"    // a seat command from an edge over NATS
+	sourceExpiry  = "expiry"  // a hold timing out
+	sourceAdmin   = "admin"   // an admin override
+	sourceDrift   = "drift"   // the drift check repairing Redis from Postgres
+	sourceRestore = "restore" // restoring bookings from Postgres at startup
+	sourceResale  = "resale"  // a resale partner's sale or a block's return
+)
+
+// Entries kept in the audit log across all seats, and per user in its index
+const (
+	auditLogMaxLen       = 100000
+	auditUserIndexMaxLen = 10000
+)
+
+// Result sizes of an audit search
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type sourceKey struct{}
+
+// withSource returns ctx recording where the seat operations made with it
+// came from
+func withSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// sourceOf returns the source recorded by withSource, or sourceAPI
+func sourceOf(ctx context.Context) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok {
+		return source
+	}
+	return sourceAPI
+}
+
+// AuditFilter matches one field of a transition. A value matches when it is
+// one of Include (or Include is empty) and none of Exclude.
+type AuditFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Matches reports whether value passes the filter
+func (f AuditFilter) Matches(value string) bool {
+	for _, excluded := range f.Exclude {
+		if value == excluded {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, included := range f.Include {
+		if value == included {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAuditFilter reads a filter from query values such as "a,b" (a or b)
+// and "!c" (anything but c); repeated parameters are combined
+func parseAuditFilter(values []string) AuditFilter {
+	var f AuditFilter
+	for _, value := range values {
+		for _, term := range strings.Split(value, ",") {
+			term = strings.TrimSpace(term)
+			switch {
+			case term == "" || term == "!":
+			case strings.HasPrefix(term, "!"):
+				f.Exclude = append(f.Exclude, term[1:])
+			default:
+				f.Include = append(f.Include, term)
+			}
+		}
+	}
+	return f
+}
+
+// AuditQuery selects transitions from the audit log. Every field filter must
+// match, and At must fall within [From, To] where those are set.
+type AuditQuery struct {
+	Users   AuditFilter
+	Seats   AuditFilter
+	Actions AuditFilter
+	Sources AuditFilter
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+// Matches reports whether a transition is selected by the query
+func (q AuditQuery) Matches(t SeatTransition) bool {
+	if !q.From.IsZero() && t.At.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && t.At.After(q.To) {
+		return false
+	}
+	return q.Users.Matches(t.UserID) &&
+		q.Seats.Matches(t.SeatID) &&
+		q.Actions.Matches(t.Type) &&
+		q.Sources.Matches(t.Source)
+}
+
+// Filter returns the transitions the query selects, oldest first and at most
+// q.Limit of them
+func (q AuditQuery) Filter(transitions []SeatTransition) []SeatTransition {
+	var matches []SeatTransition
+	for _, t := range transitions {
+		if q.Matches(t) {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Seq < matches[j].Seq })
+	return limitTransitions(matches, q.Limit)
+}
+
+// limitTransitions keeps the first limit transitions
+func limitTransitions(transitions []SeatTransition, limit int) []SeatTransition {
+	if transitions == nil {
+		return []SeatTransition{}
+	}
+	if len(transitions) > limit {
+		return transitions[:limit]
+	}
+	return transitions
+}
+
+// parseAuditQuery reads the query of GET /api/admin/audit
+func parseAuditQuery(values url.Values) (AuditQuery, error) {
+	q := AuditQuery{
+		Users:   parseAuditFilter(values["user"]),
+		Seats:   parseAuditFilter(values["seat"]),
+		Actions: parseAuditFilter(values["action"]),
+		Sources: parseAuditFilter(values["source"]),
+		Limit:   defaultAuditLimit,
+	}
+
+	for name, into := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if s := values.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return AuditQuery{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*into = t
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return AuditQuery{}, fmt.Errorf("to must not be before from")
+	}
+
+	if s := values.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return AuditQuery{}, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// AuditSearch is the response of GET /api/admin/audit
+type AuditSearch struct {
+	Transitions []SeatTransition `json:"transitions"`
+
+	// Truncated is set when more transitions matched than the limit allowed;
+	// narrow the time range or raise the limit to see the rest
+	Truncated bool `json:"truncated"`
+}
+
+// SearchAudit runs an audit query against the seat store
+func SearchAudit(ctx context.Context, q AuditQuery) (*AuditSearch, error) {
+	// Ask for one more than the limit to tell whether there were more
+	limit := q.Limit
+	q.Limit++
+	transitions, err := seatStoreFor(ctx).SearchAudit(q)
+	if err != nil {
+		return nil, err
+	}
+	search := &AuditSearch{Transitions: transitions}
+	if len(transitions) > limit {
+		search.Transitions = transitions[:limit]
+		search.Truncated = true
+	}
+	return search, nil
+}
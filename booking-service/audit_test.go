@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// recordAuditTrail makes a few transitions over three seats and two users,
+// through the API, NATS and the hold timer
+func recordAuditTrail(t *testing.T) (seatA, seatB, seatC string) {
+	t.Helper()
+	seatA, seatB, seatC = shared.GetSeatID(1, 1), shared.GetSeatID(1, 2), shared.GetSeatID(1, 3)
+	natsCtx := withSource(ctx, sourceNATS)
+
+	if err := SelectSeat(ctx, seatA, "alice", 0); err != nil {
+		t.Fatalf("SelectSeat A: %v", err)
+	}
+	if _, err := BookSeat(ctx, seatA, "alice", 0); err != nil {
+		t.Fatalf("BookSeat A: %v", err)
+	}
+	if err := SelectSeat(natsCtx, seatB, "bob", 0); err != nil {
+		t.Fatalf("SelectSeat B: %v", err)
+	}
+	if err := ReleaseSeat(natsCtx, seatB, "bob", 0); err != nil {
+		t.Fatalf("ReleaseSeat B: %v", err)
+	}
+	if err := SelectSeat(ctx, seatC, "alice", 0); err != nil {
+		t.Fatalf("SelectSeat C: %v", err)
+	}
+	seat := loadSeat(t, seatC)
+	if err := autoReleaseSeat(seatStore, &seat); err != nil {
+		t.Fatalf("autoReleaseSeat C: %v", err)
+	}
+	return seatA, seatB, seatC
+}
+
+func searchTypes(t *testing.T, query string) []string {
+	t.Helper()
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("parse %q: %v", query, err)
+	}
+	q, err := parseAuditQuery(values)
+	if err != nil {
+		t.Fatalf("parseAuditQuery(%q): %v", query, err)
+	}
+	search, err := SearchAudit(context.Background(), q)
+	if err != nil {
+		t.Fatalf("SearchAudit(%q): %v", query, err)
+	}
+	types := make([]string, len(search.Transitions))
+	for i, transition := range search.Transitions {
+		types[i] = transition.SeatID + " " + transition.Type
+	}
+	return types
+}
+
+func TestSearchAuditFilters(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatA, seatB, seatC := recordAuditTrail(t)
+
+		tests := []struct {
+			query string
+			want  []string
+		}{
+			{"", []string{seatA + " held", seatA + " booked", seatB + " held", seatB + " released", seatC + " held", seatC + " auto_released"}},
+			{"user=alice", []string{seatA + " held", seatA + " booked", seatC + " held", seatC + " auto_released"}},
+			{"user=alice&action=held", []string{seatA + " held", seatC + " held"}},
+			{"seat=" + seatB + "," + seatC + "&action=!held", []string{seatB + " released", seatC + " auto_released"}},
+			{"source=nats", []string{seatB + " held", seatB + " released"}},
+			{"source=expiry", []string{seatC + " auto_released"}},
+			{"user=alice&source=!api", []string{seatC + " auto_released"}},
+			{"user=carol", []string{}},
+			{"limit=2", []string{seatA + " held", seatA + " booked"}},
+		}
+		for _, tt := range tests {
+			got := searchTypes(t, tt.query)
+			if len(got) != len(tt.want) {
+				t.Errorf("%q = %v, want %v", tt.query, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("%q = %v, want %v", tt.query, got, tt.want)
+					break
+				}
+			}
+		}
+	})
+}
+
+func TestSearchAuditTimeRange(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(4, 4)
+		if err := SelectSeat(ctx, seatID, "alice", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		after := time.Now().Add(time.Second)
+
+		for _, query := range []string{"to=" + after.Add(-time.Hour).Format(time.RFC3339), "from=" + after.Format(time.RFC3339)} {
+			if got := searchTypes(t, query); len(got) != 0 {
+				t.Errorf("%q = %v, want nothing", query, got)
+			}
+		}
+		for _, query := range []string{"", "user=alice&", "seat=" + seatID + "&"} {
+			query += "from=" + after.Add(-time.Hour).Format(time.RFC3339) + "&to=" + after.Format(time.RFC3339)
+			if got := searchTypes(t, query); len(got) != 1 {
+				t.Errorf("%q = %v, want the hold", query, got)
+			}
+		}
+	})
+}
+
+func TestAuditEndpoint(t *testing.T) {
+	newTestRedis(t)
+	recordAuditTrail(t)
+
+	w := httptest.NewRecorder()
+	adminRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/audit?user=alice&limit=3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var search AuditSearch
+	if err := json.Unmarshal(w.Body.Bytes(), &search); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(search.Transitions) != 3 || !search.Truncated {
+		t.Errorf("got %d transitions, truncated %v; want 3, truncated", len(search.Transitions), search.Truncated)
+	}
+	if first := search.Transitions[0]; first.UserID != "alice" || first.Source != sourceAPI {
+		t.Errorf("first transition = %+v, want alice's via the API", first)
+	}
+
+	for _, query := range []string{"from=yesterday", "limit=0", "limit=5000", "from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z"} {
+		w := httptest.NewRecorder()
+		adminRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/audit?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	errUserBanned = shared.NewError(shared.ErrorCodeUserBanned, "you are banned from seat operations")
+	errNotBanned  = errors.New("user is not banned")
+)
+
+// UserBan keeps a user from every seat operation until it ends, or for good
+// when Until is 0
+type UserBan struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
+	Since  int64  `json:"since"`
+	Until  int64  `json:"until,omitempty"`
+}
+
+// BanReport is what banning a user did
+type BanReport struct {
+	Ban           UserBan  `json:"ban"`
+	HoldsReleased []string `json:"holds_released"`
+	Disconnected  bool     `json:"disconnected"` // false without NATS to reach the edges
+}
+
+// BanUser bans userID for duration, or for good when it is 0, releases the
+// seats they hold and disconnects them from every edge
+func BanUser(nc *nats.Conn, userID, reason string, duration time.Duration) (*BanReport, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+	if duration < 0 {
+		return nil, errors.New("duration must not be negative")
+	}
+
+	now := time.Now()
+	ban := UserBan{UserID: userID, Reason: reason, Since: now.Unix()}
+	if duration > 0 {
+		ban.Until = now.Add(duration).Unix()
+	}
+	banJSON, err := json.Marshal(ban)
+	if err != nil {
+		return nil, err
+	}
+	// Banned first, so the user cannot take seats while their holds go
+	if err := redisClient.Set(ctx, fmt.Sprintf(shared.RedisKeyUserBan, userID), banJSON, duration).Err(); err != nil {
+		return nil, err
+	}
+	slog.Warn("User banned", shared.LogKeyComponent, "admin", shared.LogKeyUserID, userID, "reason", reason, "duration", duration)
+
+	report := &BanReport{Ban: ban, HoldsReleased: []string{}}
+	seats, err := GetAllSeats()
+	if err != nil {
+		return nil, err
+	}
+	var held []shared.Seat
+	for _, seat := range seats {
+		if seat.Status == shared.SeatHeld && seat.HeldBy == userID {
+			held = append(held, seat)
+		}
+	}
+	changes, err := applyAdminChanges(held, shared.SeatAvailable, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes.Changes {
+		report.HoldsReleased = append(report.HoldsReleased, change.SeatID)
+	}
+
+	kickReason := "banned"
+	if reason != "" {
+		kickReason += ": " + reason
+	}
+	_, err = DisconnectUser(nc, userID, kickReason)
+	if err != nil && err != errEdgesUnreachable {
+		return nil, err
+	}
+	report.Disconnected = err == nil
+	return report, nil
+}
+
+// UnbanUser lifts userID's ban
+func UnbanUser(userID string) error {
+	lifted, err := redisClient.Del(ctx, fmt.Sprintf(shared.RedisKeyUserBan, userID)).Result()
+	if err != nil {
+		return err
+	}
+	if lifted == 0 {
+		return errNotBanned
+	}
+	slog.Info("User ban lifted", shared.LogKeyComponent, "admin", shared.LogKeyUserID, userID)
+	return nil
+}
+
+// ListBans returns the bans in effect
+func ListBans() ([]UserBan, error) {
+	bans := []UserBan{}
+	iter := redisClient.Scan(ctx, 0, fmt.Sprintf(shared.RedisKeyUserBan, "*"), 1000).Iterator()
+	for iter.Next(ctx) {
+		banJSON, err := redisClient.Get(ctx, iter.Val()).Bytes()
+		if err == redis.Nil {
+			continue // expired since the scan
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ban UserBan
+		if err := json.Unmarshal(banJSON, &ban); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, iter.Err()
+}
+
+// checkNotBanned fails with errUserBanned while userID is banned. If Redis
+// cannot tell, the operation goes ahead.
+func checkNotBanned(userID string) error {
+	banned, err := redisClient.Exists(ctx, fmt.Sprintf(shared.RedisKeyUserBan, userID)).Result()
+	if err != nil {
+		slog.Warn("Failed to check user ban", shared.LogKeyUserID, userID, shared.ErrAttr(err))
+		return nil
+	}
+	if banned > 0 {
+		return errUserBanned
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestBanReleasesHoldsAndBlocksSeatOperations(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		bg := context.Background()
+		for _, seatID := range []string{"A1", "A2"} {
+			if err := SelectSeat(bg, seatID, "mallory", 0); err != nil {
+				t.Fatalf("SelectSeat %s: %v", seatID, err)
+			}
+		}
+		if err := SelectSeat(bg, "A3", "alice", 0); err != nil {
+			t.Fatalf("SelectSeat A3: %v", err)
+		}
+
+		router := adminRouter(t)
+		do := func(method, path, body string) *httptest.ResponseRecorder {
+			t.Helper()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+			return w
+		}
+
+		w := do(http.MethodPost, "/api/admin/users/mallory/ban", `{"reason":"bot","duration":"1h"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("ban = %d %s", w.Code, w.Body)
+		}
+		var report BanReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		if !reflect.DeepEqual(report.HoldsReleased, []string{"A1", "A2"}) || report.Disconnected || report.Ban.Until == 0 {
+			t.Errorf("ban report = %+v, want A1 and A2 released, a temporary ban and no NATS to disconnect through", report)
+		}
+		if seat := loadSeat(t, "A1"); seat.Status != shared.SeatAvailable {
+			t.Errorf("banned user's seat = %+v, want available", seat)
+		}
+		if seat := loadSeat(t, "A3"); seat.HeldBy != "alice" {
+			t.Errorf("another user's seat = %+v, want still held by alice", seat)
+		}
+
+		if w := do(http.MethodPost, "/api/seats/select", `{"seat_id":"A4","user_id":"mallory"}`); w.Code != http.StatusForbidden {
+			t.Errorf("select while banned = %d, want 403", w.Code)
+		}
+		if _, err := BookSeat(bg, "A3", "mallory", 0); err != errUserBanned {
+			t.Errorf("book while banned = %v, want errUserBanned", err)
+		}
+
+		var list struct {
+			Bans []UserBan `json:"bans"`
+		}
+		json.Unmarshal(do(http.MethodGet, "/api/admin/bans", "").Body.Bytes(), &list)
+		if len(list.Bans) != 1 || list.Bans[0].UserID != "mallory" || list.Bans[0].Reason != "bot" {
+			t.Errorf("bans = %+v, want mallory's", list.Bans)
+		}
+
+		if w := do(http.MethodDelete, "/api/admin/users/mallory/ban", ""); w.Code != http.StatusOK {
+			t.Fatalf("unban = %d %s", w.Code, w.Body)
+		}
+		if w := do(http.MethodDelete, "/api/admin/users/mallory/ban", ""); w.Code != http.StatusNotFound {
+			t.Errorf("unbanning again = %d, want 404", w.Code)
+		}
+		if err := SelectSeat(bg, "A4", "mallory", 0); err != nil {
+			t.Errorf("select after the ban was lifted = %v", err)
+		}
+	})
+}
+
+func TestTemporaryBansExpire(t *testing.T) {
+	mr := newTestRedis(t)
+	if _, err := BanUser(nil, "mallory", "", time.Minute); err != nil {
+		t.Fatalf("BanUser: %v", err)
+	}
+	if err := checkNotBanned("mallory"); err != errUserBanned {
+		t.Fatalf("check while banned = %v, want errUserBanned", err)
+	}
+	mr.FastForward(time.Minute)
+	if err := checkNotBanned("mallory"); err != nil {
+		t.Errorf("check once the ban ran out = %v", err)
+	}
+}
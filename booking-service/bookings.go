@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// bookingStore keeps confirmed bookings outside Redis so they survive a flush.
+// It is nil when DATABASE_URL is not set, and bookings then live only in Redis.
+var bookingStore BookingStore
+
+var errBookingsDisabled = errors.New("booking persistence is not configured")
+
+// Booking is a confirmed seat as recorded in the bookings table
+type Booking struct {
+	EventID    string    `json:"event_id"`
+	SeatID     string    `json:"seat_id"`
+	UserID     string    `json:"user_id"`
+	OrderID    string    `json:"order_id,omitempty"`
+	Section    string    `json:"section,omitempty"`
+	Tier       string    `json:"tier,omitempty"`
+	PriceCents int64     `json:"price_cents"`
+	FeesCents  int64     `json:"fees_cents"`
+	TaxCents   int64     `json:"tax_cents"`
+	BookedAt   time.Time `json:"booked_at"`
+}
+
+// BookingQuery filters GET /api/bookings. Empty fields match everything.
+type BookingQuery struct {
+	UserID  string
+	Section string
+	Since   time.Time
+	Limit   int // 0 means no limit
+}
+
+// Maximum bookings returned by one GET /api/bookings request
+const maxBookingsPerQuery = 1000
+
+// BookingStore persists confirmed bookings
+type BookingStore interface {
+	// Save records a booking, replacing any earlier booking of the same seat
+	Save(booking *Booking) error
+
+	// Delete forgets a seat's booking, if any
+	Delete(eventID, seatID string) error
+
+	// List returns matching bookings, oldest first
+	List(query BookingQuery) ([]Booking, error)
+}
+
+// recordBooking persists a just-booked seat, with the fees and taxes of its
+// order if it has one. Failures are logged rather than returned: the booking
+// stands in Redis either way.
+func recordBooking(seat *shared.Seat, userID string, order *Order) {
+	if bookingStore == nil {
+		return
+	}
+
+	booking := &Booking{
+		EventID:    shared.DefaultEventID,
+		SeatID:     seat.ID,
+		UserID:     userID,
+		Section:    seat.Section,
+		Tier:       seat.Tier,
+		PriceCents: seat.PriceCents,
+		BookedAt:   time.Now(),
+	}
+	if order != nil {
+		booking.OrderID = order.ID
+		booking.FeesCents, booking.TaxCents = chargeTotals(order.Charges)
+	}
+	err := bookingStore.Save(booking)
+	if err != nil {
+		slog.Error("Failed to persist booking", shared.LogKeySeatID, seat.ID, shared.LogKeyUserID, userID, shared.ErrAttr(err))
+	}
+}
+
+// forgetBooking removes a seat's persisted booking once it is no longer booked
+func forgetBooking(seatID string) {
+	if bookingStore == nil {
+		return
+	}
+	if err := bookingStore.Delete(shared.DefaultEventID, seatID); err != nil {
+		slog.Error("Failed to delete persisted booking", shared.LogKeySeatID, seatID, shared.ErrAttr(err))
+	}
+}
+
+// reconcileBookings re-marks every persisted booking as booked in the seat
+// store, e.g. after Redis was flushed and the venue regenerated. It returns how
+// many seats it had to change.
+func reconcileBookings(store SeatStore, bookings BookingStore) (int, error) {
+	persisted, err := bookings.List(BookingQuery{})
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for _, booking := range persisted {
+		if booking.EventID != shared.DefaultEventID {
+			continue
+		}
+
+		seat, err := store.GetSeat(booking.SeatID)
+		if err == errSeatNotFound {
+			slog.Warn("Persisted booking has no seat in the venue", shared.LogKeySeatID, booking.SeatID, shared.LogKeyUserID, booking.UserID)
+			continue
+		}
+		if err != nil {
+			return restored, err
+		}
+		if seat.Status == shared.SeatBooked && seat.HeldBy == booking.UserID {
+			continue
+		}
+
+		seat.Status = shared.SeatBooked
+		seat.HeldBy = booking.UserID
+		seat.ExpiresAt = 0
+		if err := casUpdateSeat(store, seat, seat.Version, "booked", booking.UserID, sourceRestore); err != nil {
+			return restored, err
+		}
+		store.ReleaseLock(seat.ID)
+		store.UnindexHold(seat.ID)
+		cancelHoldExpiry(seat.ID)
+		restored++
+	}
+
+	return restored, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// bookingsSchema creates the bookings table on first start
+const bookingsSchema = `
+CREATE TABLE IF NOT EXISTS bookings (
+	event_id    TEXT        NOT NULL,
+	seat_id     TEXT        NOT NULL,
+	user_id     TEXT        NOT NULL,
+	order_id    TEXT        NOT NULL DEFAULT '',
+	section     TEXT        NOT NULL DEFAULT '',
+	tier        TEXT        NOT NULL DEFAULT '',
+	price_cents BIGINT      NOT NULL DEFAULT 0,
+	booked_at   TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (event_id, seat_id)
+);
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS fees_cents BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS tax_cents BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS bookings_user_id ON bookings (user_id);
+CREATE INDEX IF NOT EXISTS bookings_booked_at ON bookings (booked_at);
+`
+
+// postgresBookingStore keeps bookings in a Postgres table, one row per booked seat
+type postgresBookingStore struct {
+	db *sql.DB
+}
+
+// NewPostgresBookingStore connects to databaseURL and creates the bookings
+// table if it does not exist
+func NewPostgresBookingStore(databaseURL string) (BookingStore, error) {
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(10)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, bookingsSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bookings table: %w", err)
+	}
+	return &postgresBookingStore{db: db}, nil
+}
+
+func (s *postgresBookingStore) Save(booking *Booking) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO bookings (event_id, seat_id, user_id, order_id, section, tier, price_cents, fees_cents, tax_cents, booked_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (event_id, seat_id) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			order_id = EXCLUDED.order_id,
+			section = EXCLUDED.section,
+			tier = EXCLUDED.tier,
+			price_cents = EXCLUDED.price_cents,
+			fees_cents = EXCLUDED.fees_cents,
+			tax_cents = EXCLUDED.tax_cents,
+			booked_at = EXCLUDED.booked_at`,
+		booking.EventID, booking.SeatID, booking.UserID, booking.OrderID,
+		booking.Section, booking.Tier, booking.PriceCents, booking.FeesCents, booking.TaxCents, booking.BookedAt)
+	return err
+}
+
+func (s *postgresBookingStore) Delete(eventID, seatID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM bookings WHERE event_id = $1 AND seat_id = $2`, eventID, seatID)
+	return err
+}
+
+func (s *postgresBookingStore) List(query BookingQuery) ([]Booking, error) {
+	var where []string
+	var args []interface{}
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	if query.UserID != "" {
+		add("user_id = $%d", query.UserID)
+	}
+	if query.Section != "" {
+		add("section = $%d", query.Section)
+	}
+	if !query.Since.IsZero() {
+		add("booked_at >= $%d", query.Since)
+	}
+
+	sqlQuery := `SELECT event_id, seat_id, user_id, order_id, section, tier, price_cents, fees_cents, tax_cents, booked_at FROM bookings`
+	if len(where) > 0 {
+		sqlQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlQuery += " ORDER BY booked_at, seat_id"
+	if query.Limit > 0 {
+		args = append(args, query.Limit)
+		sqlQuery += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookings := []Booking{}
+	for rows.Next() {
+		var b Booking
+		if err := rows.Scan(&b.EventID, &b.SeatID, &b.UserID, &b.OrderID, &b.Section, &b.Tier, &b.PriceCents, &b.FeesCents, &b.TaxCents, &b.BookedAt); err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, b)
+	}
+	return bookings, rows.Err()
+}
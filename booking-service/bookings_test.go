@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+// fakeBookingStore keeps bookings in a map in place of Postgres
+type fakeBookingStore struct {
+	mu       sync.Mutex
+	bookings map[string]Booking
+}
+
+func useFakeBookingStore(t *testing.T) *fakeBookingStore {
+	t.Helper()
+
+	store := &fakeBookingStore{bookings: make(map[string]Booking)}
+	bookingStore = store
+	t.Cleanup(func() { bookingStore = nil })
+	return store
+}
+
+func (s *fakeBookingStore) Save(booking *Booking) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bookings[booking.EventID+"/"+booking.SeatID] = *booking
+	return nil
+}
+
+func (s *fakeBookingStore) Delete(eventID, seatID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bookings, eventID+"/"+seatID)
+	return nil
+}
+
+func (s *fakeBookingStore) List(query BookingQuery) ([]Booking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bookings []Booking
+	for _, b := range s.bookings {
+		if query.UserID != "" && b.UserID != query.UserID {
+			continue
+		}
+		bookings = append(bookings, b)
+	}
+	sort.Slice(bookings, func(i, j int) bool { return bookings[i].SeatID < bookings[j].SeatID })
+	return bookings, nil
+}
+
+func TestBookingIsPersistedAndSurvivesRedisFlush(t *testing.T) {
+	mr := newTestRedis(t)
+	persisted := useFakeBookingStore(t)
+
+	seatID := shared.GetSeatID(0, 0)
+	if err := SelectSeat(ctx, seatID, "user-1", 0); err != nil {
+		t.Fatalf("SelectSeat: %v", err)
+	}
+	order, err := BookSeat(ctx, seatID, "user-1", 0)
+	if err != nil {
+		t.Fatalf("BookSeat: %v", err)
+	}
+
+	bookings, _ := persisted.List(BookingQuery{UserID: "user-1"})
+	if len(bookings) != 1 || bookings[0].SeatID != seatID || bookings[0].OrderID != order.ID {
+		t.Fatalf("persisted bookings = %+v, want seat %s with order %s", bookings, seatID, order.ID)
+	}
+
+	// Lose everything in Redis and start over from a fresh venue
+	mr.FlushAll()
+	if err := initializeVenue(); err != nil {
+		t.Fatalf("initializeVenue: %v", err)
+	}
+	if seat := loadSeat(t, seatID); seat.Status != shared.SeatAvailable {
+		t.Fatalf("seat is %s after the flush, want available", seat.Status)
+	}
+
+	restored, err := reconcileBookings(seatStore, bookingStore)
+	if err != nil || restored != 1 {
+		t.Fatalf("reconcileBookings = %d, %v; want 1, nil", restored, err)
+	}
+	if seat := loadSeat(t, seatID); seat.Status != shared.SeatBooked || seat.HeldBy != "user-1" {
+		t.Fatalf("seat = %s by %q after reconciliation, want booked by user-1", seat.Status, seat.HeldBy)
+	}
+
+	// Already in step, so a second pass changes nothing
+	if restored, _ := reconcileBookings(seatStore, bookingStore); restored != 0 {
+		t.Errorf("second reconciliation restored %d seats", restored)
+	}
+}
+
+func TestAdminReleaseForgetsPersistedBooking(t *testing.T) {
+	newTestRedis(t)
+	persisted := useFakeBookingStore(t)
+
+	seatID := shared.GetSeatID(0, 1)
+	if _, err := BulkUpdateSeats([]string{seatID}, shared.SeatBooked, false); err != nil {
+		t.Fatalf("BulkUpdateSeats booked: %v", err)
+	}
+	if bookings, _ := persisted.List(BookingQuery{UserID: adminActor}); len(bookings) != 1 {
+		t.Fatalf("admin booking not persisted: %+v", bookings)
+	}
+
+	if _, err := ResetVenue(false); err != nil {
+		t.Fatalf("ResetVenue: %v", err)
+	}
+	if bookings, _ := persisted.List(BookingQuery{}); len(bookings) != 0 {
+		t.Fatalf("bookings left after reset: %+v", bookings)
+	}
+}
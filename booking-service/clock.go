@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Clock is the time holds run on: when they are taken and expire, how long
+// their locks last, and when the timers that warn holders and release holds
+// fire. It is the system clock, except in tests and in simulation mode
+// (SIMULATED_CLOCK), where a FakeClock only moves when advanced.
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc calls f once d has passed on this clock
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a pending AfterFunc
+type ClockTimer interface {
+	// Stop cancels the call, reporting false if it already happened or was
+	// cancelled
+	Stop() bool
+}
+
+// clock is the Clock the hold logic reads
+var clock Clock = systemClock{}
+
+// simulatingClock is set when SIMULATED_CLOCK put the service on a FakeClock
+var simulatingClock bool
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer { return time.AfterFunc(d, f) }
+
+// everyTick calls f every d on the clock, the next call counted from the end
+// of the last
+func everyTick(d time.Duration, f func()) {
+	var tick func()
+	tick = func() {
+		f()
+		clock.AfterFunc(d, tick)
+	}
+	clock.AfterFunc(d, tick)
+}
+
+// FakeClock is a Clock that stands still until Advance moves it. Timers fire
+// inside Advance, one at a time in deadline order, so whatever they do is done
+// when it returns.
+type FakeClock struct {
+	// Serializes Advance
+	advancing sync.Mutex
+
+	mu        sync.Mutex
+	now       time.Time
+	timers    []*fakeTimer
+	scheduled int64 // timers ever set, to fire those due together in order
+	onAdvance []func(d time.Duration)
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	seq   int64
+	f     func()
+}
+
+// NewFakeClock returns a FakeClock reading now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scheduled++
+	t := &fakeTimer{clock: c, at: c.now.Add(d), seq: c.scheduled, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// OnAdvance calls f with how far the clock moved each time it is advanced,
+// before its timers fire, e.g. to move the TTLs of a test Redis along with it
+func (c *FakeClock) OnAdvance(f func(d time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onAdvance = append(c.onAdvance, f)
+}
+
+// Advance moves the clock d ahead, firing every timer due by then, including
+// those set by the timers it fires
+func (c *FakeClock) Advance(d time.Duration) {
+	c.advancing.Lock()
+	defer c.advancing.Unlock()
+
+	c.mu.Lock()
+	target := c.now.Add(d)
+	hooks := c.onAdvance
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		hook(d)
+	}
+
+	for {
+		c.mu.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			if !c.timers[i].at.Equal(c.timers[j].at) {
+				return c.timers[i].at.Before(c.timers[j].at)
+			}
+			return c.timers[i].seq < c.timers[j].seq
+		})
+		if len(c.timers) == 0 || c.timers[0].at.After(target) {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+		next := c.timers[0]
+		c.timers = c.timers[1:]
+		if next.at.After(c.now) {
+			c.now = next.at
+		}
+		c.mu.Unlock()
+		next.f()
+	}
+}
+
+// simulatedClockFromEnv reads SIMULATED_CLOCK: when true, holds run on a
+// FakeClock starting at the current time, advanced through the admin API
+func simulatedClockFromEnv() (bool, error) {
+	env := os.Getenv("SIMULATED_CLOCK")
+	if env == "" {
+		return false, nil
+	}
+	simulated, err := strconv.ParseBool(env)
+	if err != nil {
+		return false, fmt.Errorf("SIMULATED_CLOCK must be true or false, got %q", env)
+	}
+	return simulated, nil
+}
+
+// Redis keeps its own time, so under a simulated clock lock keys are kept
+// this long instead of their TTL, and holds end only when the timers release
+// them on the simulated clock
+const simulatedLockTTL = 24 * time.Hour
+
+// lockTTL is the TTL to give a Redis lock that should last ttl on the clock
+func lockTTL(ttl time.Duration) time.Duration {
+	if simulatingClock {
+		return simulatedLockTTL
+	}
+	return ttl
+}
+
+// Longest the simulated clock moves in one request. Every tick of the hold
+// wheel and the sweep on the way runs inside it.
+const maxClockAdvance = time.Hour
+
+var errClockNotSimulated = errors.New("the clock is only simulated with SIMULATED_CLOCK=true")
+
+// ClockAdvanceRequest is the body of POST /api/admin/clock/advance
+type ClockAdvanceRequest struct {
+	By string `json:"by"` // e.g. "25s"
+}
+
+// AdvanceClock moves the simulated clock on by req.By, warning and releasing
+// the holds that expire on the way, and returns the time it then reads
+func AdvanceClock(req ClockAdvanceRequest) (time.Time, error) {
+	fake, ok := clock.(*FakeClock)
+	if !ok {
+		return time.Time{}, errClockNotSimulated
+	}
+	by, err := time.ParseDuration(req.By)
+	if err != nil || by <= 0 || by > maxClockAdvance {
+		return time.Time{}, fmt.Errorf("by must be a duration between 0 and %s", maxClockAdvance)
+	}
+
+	fake.Advance(by)
+	now := fake.Now()
+	slog.Info("Advanced the simulated clock", shared.LogKeyComponent, "admin", "by", by, "now", now)
+	return now, nil
+}
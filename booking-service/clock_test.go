@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// simulateClock runs the test on a FakeClock, as SIMULATED_CLOCK does
+func simulateClock(t *testing.T) *FakeClock {
+	t.Helper()
+
+	fake := NewFakeClock(time.Date(2026, 3, 14, 19, 0, 0, 0, time.UTC))
+	clock, simulatingClock = fake, true
+	t.Cleanup(func() { clock, simulatingClock = systemClock{}, false })
+	return fake
+}
+
+func TestFakeClockFiresTimersInDeadlineOrder(t *testing.T) {
+	start := time.Date(2026, 3, 14, 19, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	var fired []string
+	at := func(name string) func() {
+		return func() { fired = append(fired, fmt.Sprintf("%s@%s", name, c.Now().Sub(start))) }
+	}
+	c.AfterFunc(3*time.Second, at("late"))
+	c.AfterFunc(time.Second, func() {
+		at("first")()
+		c.AfterFunc(time.Second, at("chained"))
+	})
+	c.AfterFunc(2*time.Second, at("second"))
+	c.AfterFunc(time.Second, at("tied"))
+	c.AfterFunc(time.Second, at("stopped")).Stop()
+
+	var advanced []time.Duration
+	c.OnAdvance(func(d time.Duration) { advanced = append(advanced, d) })
+	c.Advance(2 * time.Second)
+
+	want := "[first@1s tied@1s second@2s chained@2s]"
+	if got := fmt.Sprint(fired); got != want {
+		t.Errorf("fired %s, want %s", got, want)
+	}
+	if !c.Now().Equal(start.Add(2*time.Second)) || len(advanced) != 1 || advanced[0] != 2*time.Second {
+		t.Errorf("clock at %v, advance hooks called with %v; want 2s past the start, [2s]", c.Now().Sub(start), advanced)
+	}
+
+	c.Advance(time.Second)
+	if len(fired) != 5 || fired[4] != "late@3s" {
+		t.Errorf("fired %v, want the late timer last", fired)
+	}
+}
+
+func TestHoldExpiresOnTheSimulatedClock(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		fake := simulateClock(t)
+		SetNotificationPrefs("holder", shared.NotificationPrefs{HoldExpiryWarnings: true, Channel: shared.NotificationChannelWebhook})
+
+		StartTimerService(seatStore)
+		t.Cleanup(func() {
+			holdWheel.Stop()
+			holdWheel = nil
+		})
+
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		if seat := loadSeat(t, seatID); seat.ExpiresAt != fake.Now().Add(holdDuration()).Unix() {
+			t.Fatalf("hold expires at %d, want one hold from the simulated now", seat.ExpiresAt)
+		}
+
+		fake.Advance(holdDuration() - holdExpiryWarning() - time.Second)
+		if events := webhookEvents(t); len(events) != 0 {
+			t.Fatalf("warned early: %+v", events)
+		}
+		fake.Advance(time.Second)
+		if events := webhookEvents(t); len(events) != 1 || events[0].Event.Notification.Type != shared.MessageTypeHoldExpiring {
+			t.Fatalf("webhook stream = %+v, want one hold expiry warning", events)
+		}
+		if holder, _ := seatStore.LockHolder(seatID); holder != "holder" || loadSeat(t, seatID).Status != shared.SeatHeld {
+			t.Fatalf("seat lock holder %q, want the hold to last until it expires", holder)
+		}
+
+		// The timers release the seat and its lock; Redis's own clock has not moved
+		fake.Advance(holdExpiryWarning())
+		seat := loadSeat(t, seatID)
+		if seat.Status != shared.SeatAvailable || seat.HeldBy != "" {
+			t.Fatalf("seat after expiry = %+v, want it released", seat)
+		}
+		if holder, _ := seatStore.LockHolder(seatID); holder != "" {
+			t.Fatalf("seat lock held by %q after expiry", holder)
+		}
+		if topics := queuedTopics(t); !strings.HasSuffix(topics[len(topics)-1], ".auto_released") {
+			t.Fatalf("last event topic = %q, want an auto_released event", topics[len(topics)-1])
+		}
+	})
+}
+
+func TestAdvanceClockOnlyWhenSimulated(t *testing.T) {
+	if _, err := AdvanceClock(ClockAdvanceRequest{By: "10s"}); err != errClockNotSimulated {
+		t.Fatalf("AdvanceClock on the system clock = %v, want %v", err, errClockNotSimulated)
+	}
+
+	fake := simulateClock(t)
+	start := fake.Now()
+	for _, by := range []string{"", "soon", "-5s", "2h"} {
+		if _, err := AdvanceClock(ClockAdvanceRequest{By: by}); err == nil {
+			t.Errorf("AdvanceClock by %q succeeded", by)
+		}
+	}
+	if now, err := AdvanceClock(ClockAdvanceRequest{By: "25s"}); err != nil || !now.Equal(start.Add(25*time.Second)) {
+		t.Fatalf("AdvanceClock = %v, %v; want 25s on", now, err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// seatCommands maps each seats.cmd.* subject to the operation it runs. Each
+// returns the HTTP status the equivalent API call would have, which is what
+// idempotency records store.
+var seatCommands = map[string]func(ctx context.Context, cmd shared.SeatCommand) (int, shared.SeatCommandReply){
+	shared.NATSSubjectCmdSelect: func(ctx context.Context, cmd shared.SeatCommand) (int, shared.SeatCommandReply) {
+		if err := SelectSeat(ctx, cmd.SeatID, cmd.UserID, cmd.Version); err != nil {
+			return seatCommandError(err)
+		}
+		return http.StatusOK, shared.SeatCommandReply{Message: "Seat selected successfully"}
+	},
+	shared.NATSSubjectCmdBook: func(ctx context.Context, cmd shared.SeatCommand) (int, shared.SeatCommandReply) {
+		order, err := BookSeat(ctx, cmd.SeatID, cmd.UserID, cmd.Version)
+		if err != nil {
+			return seatCommandError(err)
+		}
+		reply := shared.SeatCommandReply{Message: "Seat booked successfully"}
+		if order != nil {
+			reply.OrderID = order.ID
+		}
+		return http.StatusOK, reply
+	},
+	shared.NATSSubjectCmdRelease: func(ctx context.Context, cmd shared.SeatCommand) (int, shared.SeatCommandReply) {
+		if err := ReleaseSeat(ctx, cmd.SeatID, cmd.UserID, cmd.Version); err != nil {
+			return seatCommandError(err)
+		}
+		return http.StatusOK, shared.SeatCommandReply{Message: "Seat released successfully"}
+	},
+	shared.NATSSubjectCmdKeepalive: func(ctx context.Context, cmd shared.SeatCommand) (int, shared.SeatCommandReply) {
+		expiresAt, err := KeepHold(ctx, cmd.SeatID, cmd.UserID)
+		if err != nil {
+			return seatCommandError(err)
+		}
+		return http.StatusOK, shared.SeatCommandReply{Message: "Hold kept alive", ExpiresAt: expiresAt}
+	},
+}
+
+// StartCommandHandlers answers seat commands sent by edges over NATS
+// request-reply, the same operations as the HTTP API without the HTTP hop.
+// Instances share a queue group so each command runs once.
+func StartCommandHandlers(natsConn *nats.Conn) error {
+	for subject := range seatCommands {
+		subject := subject
+		if _, err := natsConn.QueueSubscribe(subject, shared.NATSQueueBookingService, func(msg *nats.Msg) {
+			ctx, span := startCommandSpan(msg)
+			defer span.End()
+			respond(msg, handleSeatCommand(withSource(ctx, sourceNATS), subject, msg.Data))
+		}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := natsConn.QueueSubscribe(shared.NATSSubjectCmdSnapshot, shared.NATSQueueBookingService, func(msg *nats.Msg) {
+		respond(msg, handleSnapshotCommand())
+	}); err != nil {
+		return err
+	}
+
+	if _, err := natsConn.QueueSubscribe(shared.NATSSubjectCmdChanges, shared.NATSQueueBookingService, func(msg *nats.Msg) {
+		respond(msg, handleChangesCommand(string(msg.Data)))
+	}); err != nil {
+		return err
+	}
+
+	if _, err := natsConn.QueueSubscribe(shared.NATSSubjectCmdEvent, shared.NATSQueueBookingService, func(msg *nats.Msg) {
+		respond(msg, handleEventCommand())
+	}); err != nil {
+		return err
+	}
+
+	if _, err := natsConn.QueueSubscribe(shared.NATSSubjectCmdSeat, shared.NATSQueueBookingService, func(msg *nats.Msg) {
+		respond(msg, handleSeatLookupCommand(string(msg.Data)))
+	}); err != nil {
+		return err
+	}
+
+	slog.Info("Seat command handlers started")
+	return nil
+}
+
+func respond(msg *nats.Msg, reply []byte) {
+	if err := msg.Respond(reply); err != nil {
+		slog.Warn("Failed to reply to command", shared.LogKeyComponent, "nats", "subject", msg.Subject, shared.ErrAttr(err))
+	}
+}
+
+// handleSeatCommand runs one select/book/release command and returns the JSON
+// reply. Commands carrying an idempotency key are answered from the recorded
+// reply when retried.
+func handleSeatCommand(ctx context.Context, subject string, data []byte) []byte {
+	run, ok := seatCommands[subject]
+	if !ok {
+		return commandError(shared.ErrorCodeInvalidRequest, "unknown command: "+subject)
+	}
+
+	var cmd shared.SeatCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return commandError(shared.ErrorCodeInvalidRequest, "Invalid request")
+	}
+	if cmd.SeatID == "" || cmd.UserID == "" {
+		return commandError(shared.ErrorCodeInvalidRequest, "seat_id and user_id are required")
+	}
+
+	execute := func() (int, []byte) {
+		status, reply := run(ctx, cmd)
+		replyJSON, _ := json.Marshal(reply)
+		return status, replyJSON
+	}
+	if cmd.IdempotencyKey == "" {
+		_, reply := execute()
+		return reply
+	}
+	if len(cmd.IdempotencyKey) > maxIdempotencyKeyLength {
+		return commandError(shared.ErrorCodeInvalidRequest, "Idempotency-Key is too long")
+	}
+
+	// Fingerprint the command without its key, as HTTP does with the header
+	key := cmd.IdempotencyKey
+	cmd.IdempotencyKey = ""
+	body, _ := json.Marshal(cmd)
+	_, reply, _, err := runIdempotent(key, requestFingerprint("NATS", subject, body), execute)
+	if err == errIdempotencyMismatch || err == errIdempotencyPending || err == errIdempotencyRetrying {
+		return commandError(shared.CodeOf(err, shared.ErrorCodeConflict), err.Error())
+	}
+	if err != nil {
+		return commandError(shared.ErrorCodeInternal, "Failed to read idempotency record")
+	}
+	return reply
+}
+
+// handleSnapshotCommand returns every seat and the event sequence they reflect
+func handleSnapshotCommand() []byte {
+	var reply shared.VenueSnapshotReply
+	if snapshot, err := GetVenueSnapshot(); err != nil {
+		reply.Error = "Failed to get seats"
+	} else {
+		reply.Seats, reply.Seq = snapshot.Seats, snapshot.Seq
+	}
+
+	replyJSON, _ := json.Marshal(reply)
+	return replyJSON
+}
+
+// handleChangesCommand returns the seats changed after a sequence number
+func handleChangesCommand(since string) []byte {
+	var reply shared.VenueChangesReply
+	seq, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		reply.Error = "Invalid sequence number"
+	} else if changes, err := GetVenueChanges(seq); err == errVenueChangesGone {
+		reply.Gone, reply.Error = true, err.Error()
+	} else if err != nil {
+		reply.Error = "Failed to get seat changes"
+	} else {
+		reply.Changes = changes
+	}
+
+	replyJSON, _ := json.Marshal(reply)
+	return replyJSON
+}
+
+// handleEventCommand returns the event's organizer metadata
+func handleEventCommand() []byte {
+	var reply shared.EventInfoReply
+	if event, err := GetEventInfo(); err != nil {
+		reply.Error = "Failed to get event"
+	} else {
+		reply.Event = event
+	}
+
+	replyJSON, _ := json.Marshal(reply)
+	return replyJSON
+}
+
+// handleSeatLookupCommand returns one seat
+func handleSeatLookupCommand(seatID string) []byte {
+	var reply shared.SeatReply
+	seat, err := seatStore.GetSeat(seatID)
+	switch {
+	case err == errSeatNotFound:
+		reply.NotFound, reply.Error = true, err.Error()
+	case err != nil:
+		reply.Error = "Failed to get seat"
+	default:
+		reply.Seat = seat
+	}
+
+	replyJSON, _ := json.Marshal(reply)
+	return replyJSON
+}
+
+// seatCommandError is the status and reply a failed seat command is answered
+// with, as for the HTTP API
+func seatCommandError(err error) (int, shared.SeatCommandReply) {
+	status, resp := seatError(err)
+	return status, shared.SeatCommandReply{Error: resp.Error, Code: resp.Code}
+}
+
+func commandError(code shared.ErrorCode, message string) []byte {
+	replyJSON, _ := json.Marshal(shared.SeatCommandReply{Error: message, Code: code})
+	return replyJSON
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func seatCommand(t *testing.T, subject string, cmd shared.SeatCommand) shared.SeatCommandReply {
+	t.Helper()
+
+	data, _ := json.Marshal(cmd)
+	var reply shared.SeatCommandReply
+	if err := json.Unmarshal(handleSeatCommand(ctx, subject, data), &reply); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	return reply
+}
+
+func TestSeatCommands(t *testing.T) {
+	newTestRedis(t)
+	seatID := shared.GetSeatID(0, 0)
+
+	if reply := seatCommand(t, shared.NATSSubjectCmdSelect, shared.SeatCommand{SeatID: seatID, UserID: "holder"}); reply.Error != "" {
+		t.Fatalf("select: %s", reply.Error)
+	}
+	if reply := seatCommand(t, shared.NATSSubjectCmdSelect, shared.SeatCommand{SeatID: seatID, UserID: "other"}); reply.Code != shared.ErrorCodeSeatHeldByOther {
+		t.Fatalf("second select of a held seat = %+v, want SEAT_HELD_BY_OTHER", reply)
+	}
+	if reply := seatCommand(t, shared.NATSSubjectCmdBook, shared.SeatCommand{SeatID: seatID, UserID: "holder"}); reply.Error != "" || reply.OrderID == "" {
+		t.Fatalf("book = %+v, want success with an order", reply)
+	}
+	if reply := seatCommand(t, shared.NATSSubjectCmdRelease, shared.SeatCommand{UserID: "holder"}); reply.Code != shared.ErrorCodeInvalidRequest {
+		t.Fatalf("command without seat_id = %+v, want INVALID_REQUEST", reply)
+	}
+
+	var snapshot shared.VenueSnapshotReply
+	if err := json.Unmarshal(handleSnapshotCommand(), &snapshot); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if snapshot.Error != "" || len(snapshot.Seats) == 0 || snapshot.Seq != 2 {
+		t.Fatalf("snapshot = %d seats at seq %d (%q), want every seat at seq 2", len(snapshot.Seats), snapshot.Seq, snapshot.Error)
+	}
+}
+
+func TestSeatLookupCommand(t *testing.T) {
+	newTestRedis(t)
+	seatID := shared.GetSeatID(0, 0)
+
+	var reply shared.SeatReply
+	if err := json.Unmarshal(handleSeatLookupCommand(seatID), &reply); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	if reply.Error != "" || reply.Seat == nil || reply.Seat.ID != seatID {
+		t.Fatalf("lookup = %+v, want seat %s", reply, seatID)
+	}
+
+	reply = shared.SeatReply{}
+	json.Unmarshal(handleSeatLookupCommand("NOPE"), &reply)
+	if !reply.NotFound || reply.Seat != nil {
+		t.Fatalf("lookup of an unknown seat = %+v, want not found", reply)
+	}
+}
+
+func TestSeatCommandIdempotencyKeyReplaysReply(t *testing.T) {
+	newTestRedis(t)
+	seatID := shared.GetSeatID(0, 0)
+	cmd := shared.SeatCommand{SeatID: seatID, UserID: "holder", IdempotencyKey: "ws:holder:req-1"}
+
+	first := seatCommand(t, shared.NATSSubjectCmdSelect, cmd)
+	retry := seatCommand(t, shared.NATSSubjectCmdSelect, cmd)
+	if first.Error != "" || retry != first {
+		t.Fatalf("retry = %+v, want the first reply %+v", retry, first)
+	}
+	if seat := loadSeat(t, seatID); seat.Version != 2 {
+		t.Fatalf("seat version = %d, want 2 (the retry must not run again)", seat.Version)
+	}
+
+	cmd.SeatID = shared.GetSeatID(0, 1)
+	if reply := seatCommand(t, shared.NATSSubjectCmdSelect, cmd); reply.Error != errIdempotencyMismatch.Error() {
+		t.Fatalf("reused key for another seat = %+v, want mismatch error", reply)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Fastest DEMO_SPEED accepted; at 30x a hold lasts one second
+const maxDemoSpeed = 30
+
+// demoSpeed shortens holds and the timers around them by this factor, so hold
+// expiry and auto-release can be shown in seconds. Always 1 in production.
+var demoSpeed = 1
+
+// demoSpeedFromEnv reads DEMO_SPEED, a whole factor from 1 to maxDemoSpeed
+func demoSpeedFromEnv() (int, error) {
+	env := os.Getenv("DEMO_SPEED")
+	if env == "" {
+		return 1, nil
+	}
+	speed, err := strconv.Atoi(env)
+	if err != nil || speed < 1 || speed > maxDemoSpeed {
+		return 0, fmt.Errorf("DEMO_SPEED must be a whole number from 1 to %d, got %q", maxDemoSpeed, env)
+	}
+	return speed, nil
+}
+
+// atDemoSpeed returns how long d lasts at the configured demo speed
+func atDemoSpeed(d time.Duration) time.Duration {
+	return d / time.Duration(demoSpeed)
+}
+
+// holdDuration is how long a selected seat stays held
+func holdDuration() time.Duration {
+	return atDemoSpeed(shared.HoldDuration)
+}
+
+// holdExpiryWarning is how long before expiry holders are warned
+func holdExpiryWarning() time.Duration {
+	return atDemoSpeed(shared.HoldExpiryWarning)
+}
+
+// timerCheckInterval is how often the expiry index is swept
+func timerCheckInterval() time.Duration {
+	return atDemoSpeed(shared.TimerCheckInterval)
+}
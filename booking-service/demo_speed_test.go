@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestDemoSpeedShortensHolds(t *testing.T) {
+	mr := newTestRedis(t)
+	demoSpeed = 10
+	t.Cleanup(func() { demoSpeed = 1 })
+
+	seatID := shared.GetSeatID(0, 1)
+	before := time.Now()
+	if err := SelectSeat(context.Background(), seatID, "user-1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl := mr.TTL(fmt.Sprintf(shared.RedisKeySeatLock, seatID)); ttl != 3*time.Second {
+		t.Fatalf("lock TTL = %v, want 3s", ttl)
+	}
+	expiresAt := time.Unix(loadSeat(t, seatID).ExpiresAt, 0)
+	if expiresAt.Before(before.Add(2*time.Second)) || expiresAt.After(before.Add(4*time.Second)) {
+		t.Fatalf("hold expires at %v, want about 3s after %v", expiresAt, before)
+	}
+	if holdExpiryWarning() != time.Second || timerCheckInterval() != 200*time.Millisecond {
+		t.Fatalf("warning %v and sweep %v not scaled", holdExpiryWarning(), timerCheckInterval())
+	}
+}
+
+func TestDemoSpeedFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", 1, false},
+		{"10", 10, false},
+		{"0", 0, true},
+		{"31", 0, true},
+		{"fast", 0, true},
+	} {
+		t.Setenv("DEMO_SPEED", tc.env)
+		got, err := demoSpeedFromEnv()
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("DEMO_SPEED=%q: got %d, %v", tc.env, got, err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Actor recorded on transitions made by drift repair
+const reconcilerActor = "reconciler"
+
+// Kinds of drift between Redis, the seat history and Postgres
+const (
+	// The seat is booked in Redis but Postgres has no booking for it.
+	// Repaired by saving the booking.
+	DriftBookedWithoutBooking = "booked_without_booking"
+
+	// Postgres has a booking but the seat is not booked in Redis. Repaired by
+	// re-marking the seat booked.
+	DriftBookingWithoutSeat = "booking_without_seat"
+
+	// Both sides have the seat booked, by different users. Reported only: which
+	// one is right needs a person.
+	DriftHolderMismatch = "holder_mismatch"
+
+	// Postgres has a booking for a seat the venue does not have. Reported only.
+	DriftBookingForUnknownSeat = "booking_for_unknown_seat"
+
+	// The seat's latest history entry does not describe its current state.
+	// Reported only.
+	DriftHistoryMismatch = "history_mismatch"
+)
+
+// SeatDrift is one seat on which the stores disagree
+type SeatDrift struct {
+	SeatID      string             `json:"seat_id"`
+	Kind        string             `json:"kind"`
+	Status      *shared.SeatStatus `json:"status,omitempty"`       // in Redis; nil if the seat does not exist
+	HeldBy      string             `json:"held_by,omitempty"`      // in Redis
+	BookingUser string             `json:"booking_user,omitempty"` // in Postgres
+	LastVersion int64              `json:"last_version,omitempty"` // of the latest history entry
+	Repaired    bool               `json:"repaired"`
+	RepairError string             `json:"repair_error,omitempty"`
+}
+
+// DriftReport is the outcome of one reconciliation
+type DriftReport struct {
+	CheckedAt       time.Time   `json:"checked_at"`
+	Repair          bool        `json:"repair"`
+	SeatsChecked    int         `json:"seats_checked"`
+	BookingsChecked int         `json:"bookings_checked"`
+	Repaired        int         `json:"repaired"`
+	Drift           []SeatDrift `json:"drift"`
+}
+
+// CheckDrift compares every seat with its persisted booking and its latest
+// history entry. With repair set it fixes what it can, recording the repairs in
+// the seat history under the reconciler actor.
+func CheckDrift(store SeatStore, bookings BookingStore, repair bool) (*DriftReport, error) {
+	seats, err := store.AllSeats()
+	if err != nil {
+		return nil, err
+	}
+	persisted, err := bookings.List(BookingQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	bookingBySeat := make(map[string]Booking, len(persisted))
+	for _, booking := range persisted {
+		if booking.EventID == shared.DefaultEventID {
+			bookingBySeat[booking.SeatID] = booking
+		}
+	}
+
+	report := &DriftReport{
+		CheckedAt:       time.Now(),
+		Repair:          repair,
+		SeatsChecked:    len(seats),
+		BookingsChecked: len(bookingBySeat),
+		Drift:           []SeatDrift{},
+	}
+
+	sort.Slice(seats, func(i, j int) bool { return seats[i].ID < seats[j].ID })
+	for i := range seats {
+		seat := &seats[i]
+		booking, hasBooking := bookingBySeat[seat.ID]
+		delete(bookingBySeat, seat.ID)
+
+		status := seat.Status
+		drift := SeatDrift{SeatID: seat.ID, Status: &status, HeldBy: seat.HeldBy, BookingUser: booking.UserID}
+		switch {
+		case seat.Status == shared.SeatBooked && !hasBooking && strings.HasPrefix(seat.HeldBy, resaleHolderPrefix):
+			// Consigned to a resale partner, not booked by anyone yet
+			continue
+		case seat.Status == shared.SeatBooked && !hasBooking:
+			drift.Kind = DriftBookedWithoutBooking
+			if repair {
+				drift.RepairError = errorString(bookings.Save(&Booking{
+					EventID:    shared.DefaultEventID,
+					SeatID:     seat.ID,
+					UserID:     seat.HeldBy,
+					Section:    seat.Section,
+					Tier:       seat.Tier,
+					PriceCents: seat.PriceCents,
+					BookedAt:   time.Now(),
+				}))
+			}
+		case seat.Status != shared.SeatBooked && hasBooking:
+			drift.Kind = DriftBookingWithoutSeat
+			if repair {
+				drift.RepairError = errorString(rebookSeat(store, seat, booking.UserID))
+			}
+		case seat.Status == shared.SeatBooked && seat.HeldBy != booking.UserID:
+			drift.Kind = DriftHolderMismatch
+		default:
+			history, err := store.SeatHistory(seat.ID)
+			if err != nil {
+				return nil, err
+			}
+			if len(history) == 0 {
+				continue
+			}
+			last := history[len(history)-1]
+			if last.Version == seat.Version && last.ToStatus == seat.Status && last.ToHeldBy == seat.HeldBy {
+				continue
+			}
+			drift.Kind = DriftHistoryMismatch
+			drift.LastVersion = last.Version
+		}
+
+		drift.Repaired = repair && drift.RepairError == "" &&
+			(drift.Kind == DriftBookedWithoutBooking || drift.Kind == DriftBookingWithoutSeat)
+		if drift.Repaired {
+			report.Repaired++
+			slog.Info("Repaired drift", shared.LogKeyComponent, "audit", "kind", drift.Kind, shared.LogKeySeatID, seat.ID,
+				"redis_status", seat.Status, "redis_held_by", seat.HeldBy, "postgres_user_id", booking.UserID)
+		}
+		report.Drift = append(report.Drift, drift)
+	}
+
+	// Bookings of seats the venue does not have cannot be repaired from here
+	for seatID, booking := range bookingBySeat {
+		report.Drift = append(report.Drift, SeatDrift{SeatID: seatID, Kind: DriftBookingForUnknownSeat, BookingUser: booking.UserID})
+	}
+
+	return report, nil
+}
+
+// rebookSeat marks a seat booked by userID again, breaking any hold on it
+func rebookSeat(store SeatStore, seat *shared.Seat, userID string) error {
+	seat.Status = shared.SeatBooked
+	seat.HeldBy = userID
+	seat.ExpiresAt = 0
+	seat.HeldAt = 0
+	if err := casUpdateSeatAs(store, seat, seat.Version, "booked", userID, reconcilerActor, sourceDrift); err != nil {
+		return err
+	}
+	store.ReleaseLock(seat.ID)
+	store.UnindexHold(seat.ID)
+	cancelHoldExpiry(seat.ID)
+	return nil
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// RunDriftCheck reconciles the live stores and keeps the report for
+// GET /api/admin/drift
+func RunDriftCheck(repair bool) (*DriftReport, error) {
+	if bookingStore == nil {
+		return nil, errBookingsDisabled
+	}
+
+	report, err := CheckDrift(seatStore, bookingStore, repair)
+	if err != nil {
+		return nil, err
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if err := redisClient.Set(ctx, shared.RedisKeyDriftReport, reportJSON, 0).Err(); err != nil {
+		slog.Warn("Failed to store drift report", shared.ErrAttr(err))
+	}
+
+	slog.Info("Drift check finished", "seats_checked", report.SeatsChecked, "bookings_checked", report.BookingsChecked,
+		"discrepancies", len(report.Drift), "repaired", report.Repaired)
+	return report, nil
+}
+
+// LastDriftReport returns the report of the latest drift check, or nil if none ran
+func LastDriftReport() (*DriftReport, error) {
+	reportJSON, err := redisClient.Get(ctx, shared.RedisKeyDriftReport).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report DriftReport
+	if err := json.Unmarshal(reportJSON, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// driftScheduleFromEnv reads DRIFT_CHECK_AT (local "HH:MM", empty to disable)
+// and DRIFT_AUTO_REPAIR
+func driftScheduleFromEnv() (hour, minute int, enabled, repair bool, err error) {
+	at := os.Getenv("DRIFT_CHECK_AT")
+	if at == "" {
+		return 0, 0, false, false, nil
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, 0, false, false, fmt.Errorf("DRIFT_CHECK_AT must be HH:MM, got %q", at)
+	}
+	repair = strings.EqualFold(os.Getenv("DRIFT_AUTO_REPAIR"), "true")
+	return t.Hour(), t.Minute(), true, repair, nil
+}
+
+// nextDailyRun returns the first hour:minute strictly after now, in now's zone
+func nextDailyRun(now time.Time, hour, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// StartDriftChecks runs a drift check every day at hour:minute local time
+func StartDriftChecks(hour, minute int, repair bool) {
+	go func() {
+		for {
+			next := nextDailyRun(time.Now(), hour, minute)
+			time.Sleep(time.Until(next))
+			if _, err := RunDriftCheck(repair); err != nil {
+				slog.Error("Drift check failed", shared.ErrAttr(err))
+			}
+		}
+	}()
+	slog.Info("Drift checks scheduled", "daily_at", fmt.Sprintf("%02d:%02d", hour, minute), "auto_repair", repair)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func driftKinds(report *DriftReport) map[string]string {
+	kinds := make(map[string]string, len(report.Drift))
+	for _, d := range report.Drift {
+		kinds[d.SeatID] = d.Kind
+	}
+	return kinds
+}
+
+func TestDriftCheckReportsAndRepairs(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		persisted := useFakeBookingStore(t)
+		inStep, lostRow, lostSeat, mismatch := shared.GetSeatID(1, 0), shared.GetSeatID(1, 1), shared.GetSeatID(1, 2), shared.GetSeatID(1, 3)
+
+		for _, seatID := range []string{inStep, lostRow, mismatch} {
+			if err := SelectSeat(ctx, seatID, "user-1", 0); err != nil {
+				t.Fatalf("SelectSeat %s: %v", seatID, err)
+			}
+			if _, err := BookSeat(ctx, seatID, "user-1", 0); err != nil {
+				t.Fatalf("BookSeat %s: %v", seatID, err)
+			}
+		}
+		persisted.Delete(shared.DefaultEventID, lostRow)
+		persisted.Save(&Booking{EventID: shared.DefaultEventID, SeatID: lostSeat, UserID: "user-2"})
+		persisted.Save(&Booking{EventID: shared.DefaultEventID, SeatID: mismatch, UserID: "user-3"})
+		persisted.Save(&Booking{EventID: shared.DefaultEventID, SeatID: "no-such-seat", UserID: "user-4"})
+
+		report, err := CheckDrift(seatStore, bookingStore, false)
+		if err != nil {
+			t.Fatalf("CheckDrift: %v", err)
+		}
+		want := map[string]string{
+			lostRow:        DriftBookedWithoutBooking,
+			lostSeat:       DriftBookingWithoutSeat,
+			mismatch:       DriftHolderMismatch,
+			"no-such-seat": DriftBookingForUnknownSeat,
+		}
+		if got := driftKinds(report); len(got) != len(want) {
+			t.Fatalf("drift = %v, want %v", got, want)
+		} else {
+			for seatID, kind := range want {
+				if got[seatID] != kind {
+					t.Errorf("seat %s: drift %q, want %q", seatID, got[seatID], kind)
+				}
+			}
+		}
+		if report.Repaired != 0 || loadSeat(t, lostSeat).Status != shared.SeatAvailable {
+			t.Fatal("report-only check changed something")
+		}
+
+		report, err = CheckDrift(seatStore, bookingStore, true)
+		if err != nil {
+			t.Fatalf("CheckDrift repair: %v", err)
+		}
+		if report.Repaired != 2 {
+			t.Fatalf("repaired %d seats, want 2: %+v", report.Repaired, report.Drift)
+		}
+		if seat := loadSeat(t, lostSeat); seat.Status != shared.SeatBooked || seat.HeldBy != "user-2" {
+			t.Errorf("lost seat = %s by %q after repair, want booked by user-2", seat.Status, seat.HeldBy)
+		}
+		history, _ := seatStore.SeatHistory(lostSeat)
+		if last := history[len(history)-1]; last.Actor != reconcilerActor {
+			t.Errorf("repair recorded as %q, want %q", last.Actor, reconcilerActor)
+		}
+		if bookings, _ := persisted.List(BookingQuery{UserID: "user-1"}); len(bookings) != 2 {
+			t.Errorf("user-1 has %d persisted bookings after repair, want 2", len(bookings))
+		}
+
+		// Only what needs a person is left
+		report, _ = CheckDrift(seatStore, bookingStore, false)
+		if got := driftKinds(report); len(got) != 2 || got[mismatch] != DriftHolderMismatch || got["no-such-seat"] != DriftBookingForUnknownSeat {
+			t.Fatalf("drift after repair = %v", got)
+		}
+	})
+}
+
+func TestNextDailyRun(t *testing.T) {
+	loc := time.FixedZone("venue", 2*60*60)
+	now := time.Date(2026, 3, 1, 3, 30, 0, 0, loc)
+
+	if got := nextDailyRun(now, 4, 0); !got.Equal(time.Date(2026, 3, 1, 4, 0, 0, 0, loc)) {
+		t.Errorf("later today: got %v", got)
+	}
+	if got := nextDailyRun(now, 3, 30); !got.Equal(time.Date(2026, 3, 2, 3, 30, 0, 0, loc)) {
+		t.Errorf("right now: got %v", got)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Defaults for an event whose metadata was never configured
+const (
+	defaultEventCurrency = "USD"
+	defaultEventTimezone = "UTC"
+)
+
+// eventInfoFromEnv reads EVENT_NAME, EVENT_ORGANIZER, EVENT_IMAGE_URL,
+// EVENT_CURRENCY and EVENT_TIMEZONE
+func eventInfoFromEnv() shared.EventInfo {
+	info := shared.EventInfo{
+		ID:        shared.DefaultEventID,
+		Name:      os.Getenv("EVENT_NAME"),
+		Organizer: os.Getenv("EVENT_ORGANIZER"),
+		ImageURL:  os.Getenv("EVENT_IMAGE_URL"),
+		Currency:  os.Getenv("EVENT_CURRENCY"),
+		Timezone:  os.Getenv("EVENT_TIMEZONE"),
+	}
+	if info.Currency == "" {
+		info.Currency = defaultEventCurrency
+	}
+	if info.Timezone == "" {
+		info.Timezone = defaultEventTimezone
+	}
+	return info
+}
+
+// salesWindowFromEnv reads EVENT_SALES_OPEN and EVENT_SALES_CLOSE, RFC 3339
+// times, as Unix seconds; an unset end is 0
+func salesWindowFromEnv() (opensAt, closesAt int64, err error) {
+	parse := func(name string) (int64, error) {
+		v := os.Getenv(name)
+		if v == "" {
+			return 0, nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q, want an RFC 3339 time", name, v)
+		}
+		return t.Unix(), nil
+	}
+	if opensAt, err = parse("EVENT_SALES_OPEN"); err != nil {
+		return 0, 0, err
+	}
+	if closesAt, err = parse("EVENT_SALES_CLOSE"); err != nil {
+		return 0, 0, err
+	}
+	return opensAt, closesAt, nil
+}
+
+// validateEventInfo checks the fields clients rely on to render prices and times
+func validateEventInfo(info *shared.EventInfo) error {
+	if len(info.Currency) != 3 {
+		return fmt.Errorf("currency must be a 3-letter ISO 4217 code, got %q", info.Currency)
+	}
+	for _, r := range info.Currency {
+		if r < 'A' || r > 'Z' {
+			return fmt.Errorf("currency must be a 3-letter ISO 4217 code, got %q", info.Currency)
+		}
+	}
+	if _, err := time.LoadLocation(info.Timezone); err != nil || info.Timezone == "" {
+		return fmt.Errorf("unknown timezone %q", info.Timezone)
+	}
+	if info.ImageURL != "" {
+		u, err := url.Parse(info.ImageURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("image_url must be an absolute http(s) URL")
+		}
+	}
+	if info.SalesOpenAt < 0 || info.SalesCloseAt < 0 {
+		return errors.New("sales window times must be Unix seconds")
+	}
+	if info.SalesOpenAt != 0 && info.SalesCloseAt != 0 && info.SalesCloseAt <= info.SalesOpenAt {
+		return errors.New("sales must close after they open")
+	}
+	if err := validateHoldKeepalive(info.HoldKeepalive); err != nil {
+		return err
+	}
+	return validateFees(info.Fees)
+}
+
+// initEventInfo stores the metadata from the environment the first time the
+// event starts. Later edits go through the admin API and are kept on restart.
+func initEventInfo() error {
+	info := eventInfoFromEnv()
+	fees, err := feesFromEnv()
+	if err != nil {
+		return err
+	}
+	info.Fees = fees
+	if info.SalesOpenAt, info.SalesCloseAt, err = salesWindowFromEnv(); err != nil {
+		return err
+	}
+	if err := validateEventInfo(&info); err != nil {
+		return err
+	}
+
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	created, err := redisClient.SetNX(ctx, fmt.Sprintf(shared.RedisKeyEventInfo, info.ID), infoJSON, 0).Result()
+	if err != nil {
+		return err
+	}
+	if created {
+		slog.Info("Stored event metadata", "event_id", info.ID, "currency", info.Currency, "timezone", info.Timezone, "fees", info.Fees != nil)
+	}
+	return nil
+}
+
+// GetEventInfo returns the event's metadata, falling back to the defaults if
+// none is stored
+func GetEventInfo() (*shared.EventInfo, error) {
+	infoJSON, err := redisClient.Get(ctx, fmt.Sprintf(shared.RedisKeyEventInfo, shared.DefaultEventID)).Bytes()
+	if err == redis.Nil {
+		info := shared.EventInfo{ID: shared.DefaultEventID, Currency: defaultEventCurrency, Timezone: defaultEventTimezone}
+		return &info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info shared.EventInfo
+	if err := json.Unmarshal(infoJSON, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// UpdateEventInfo replaces the event's metadata. A cancelled event stays
+// cancelled whatever the new metadata says.
+func UpdateEventInfo(info shared.EventInfo) (*shared.EventInfo, error) {
+	info.ID = shared.DefaultEventID
+	if err := validateEventInfo(&info); err != nil {
+		return nil, err
+	}
+	current, err := GetEventInfo()
+	if err != nil {
+		return nil, err
+	}
+	info.CancelledAt = current.CancelledAt
+	if err := saveEventInfo(&info); err != nil {
+		return nil, err
+	}
+	slog.Info("Updated event metadata", shared.LogKeyComponent, "admin", "event_id", info.ID)
+	return &info, nil
+}
+
+// saveEventInfo stores the event's metadata and publishes it to the edges
+func saveEventInfo(info *shared.EventInfo) error {
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := redisClient.Set(ctx, fmt.Sprintf(shared.RedisKeyEventInfo, info.ID), infoJSON, 0).Err(); err != nil {
+		return err
+	}
+	publishConfig(shared.ConfigKeyEvent(info.ID), info)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestEventInfoSeededFromEnvAndKeptAcrossRestarts(t *testing.T) {
+	newTestRedis(t)
+	t.Setenv("EVENT_NAME", "Summer Tour")
+	t.Setenv("EVENT_ORGANIZER", "Acme Live")
+	t.Setenv("EVENT_CURRENCY", "EUR")
+	t.Setenv("EVENT_TIMEZONE", "Europe/Berlin")
+
+	if err := initEventInfo(); err != nil {
+		t.Fatalf("initEventInfo: %v", err)
+	}
+	if _, err := UpdateEventInfo(shared.EventInfo{Name: "Summer Tour (extra date)", Currency: "EUR", Timezone: "Europe/Berlin"}); err != nil {
+		t.Fatalf("UpdateEventInfo: %v", err)
+	}
+
+	// A restart must not overwrite the admin's edit with the environment
+	if err := initEventInfo(); err != nil {
+		t.Fatalf("initEventInfo after restart: %v", err)
+	}
+	snapshot, err := GetVenueSnapshot()
+	if err != nil {
+		t.Fatalf("GetVenueSnapshot: %v", err)
+	}
+	if snapshot.Event == nil || snapshot.Event.ID != shared.DefaultEventID || snapshot.Event.Name != "Summer Tour (extra date)" {
+		t.Fatalf("snapshot event = %+v", snapshot.Event)
+	}
+}
+
+func TestEventInfoSalesWindowFromEnv(t *testing.T) {
+	newTestRedis(t)
+	t.Setenv("EVENT_SALES_OPEN", "2026-03-01T10:00:00+01:00")
+
+	if err := initEventInfo(); err != nil {
+		t.Fatalf("initEventInfo: %v", err)
+	}
+	info, err := GetEventInfo()
+	if err != nil {
+		t.Fatalf("GetEventInfo: %v", err)
+	}
+	if info.SalesOpenAt != 1772355600 || info.SalesCloseAt != 0 {
+		t.Fatalf("sales window = %d-%d, want opening 2026-03-01 09:00 UTC", info.SalesOpenAt, info.SalesCloseAt)
+	}
+
+	t.Setenv("EVENT_SALES_CLOSE", "tomorrow")
+	if err := initEventInfo(); err == nil {
+		t.Error("an invalid EVENT_SALES_CLOSE was accepted")
+	}
+}
+
+func TestEventInfoDefaultsWhenUnset(t *testing.T) {
+	newTestRedis(t)
+
+	info, err := GetEventInfo()
+	if err != nil {
+		t.Fatalf("GetEventInfo: %v", err)
+	}
+	if info.Currency != defaultEventCurrency || info.Timezone != defaultEventTimezone {
+		t.Fatalf("default event = %+v", info)
+	}
+}
+
+func TestUpdateEventInfoValidates(t *testing.T) {
+	newTestRedis(t)
+
+	for name, info := range map[string]shared.EventInfo{
+		"currency":  {Currency: "euro", Timezone: "UTC"},
+		"timezone":  {Currency: "EUR", Timezone: "Mars/Olympus"},
+		"image url": {Currency: "EUR", Timezone: "UTC", ImageURL: "/logo.png"},
+		"sales":     {Currency: "EUR", Timezone: "UTC", SalesOpenAt: 1700000000, SalesCloseAt: 1700000000},
+		"keepalive": {Currency: "EUR", Timezone: "UTC", HoldKeepalive: &shared.HoldKeepalive{IdleSeconds: 120, MaxSeconds: 60}},
+	} {
+		if _, err := UpdateEventInfo(info); err == nil {
+			t.Errorf("%s: invalid event %+v accepted", name, info)
+		}
+	}
+}
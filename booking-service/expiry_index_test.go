@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestRedis points the package at a fresh miniredis holding the default venue
+func newTestRedis(t testing.TB) *miniredis.Miniredis {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr(), PoolSize: 64})
+	t.Cleanup(func() { redisClient.Close() })
+	seatStore = NewRedisSeatStore(redisClient)
+
+	if err := initializeVenue(); err != nil {
+		t.Fatalf("initializeVenue: %v", err)
+	}
+	return mr
+}
+
+// forEachSeatStore runs fn once against the Redis seat store and once against
+// the in-memory one. Orders and idempotency records stay in miniredis for both.
+func forEachSeatStore(t *testing.T, fn func(t *testing.T)) {
+	t.Run("redis", func(t *testing.T) {
+		newTestRedis(t)
+		fn(t)
+	})
+	t.Run("memory", func(t *testing.T) {
+		newTestRedis(t)
+		seats, err := GenerateVenue("grid", nil)
+		if err != nil {
+			t.Fatalf("GenerateVenue: %v", err)
+		}
+		seatStore = NewMemorySeatStore(seats)
+		fn(t)
+	})
+}
+
+func loadSeat(t *testing.T, seatID string) shared.Seat {
+	t.Helper()
+
+	seat, err := seatStore.GetSeat(seatID)
+	if err != nil {
+		t.Fatalf("load seat %s: %v", seatID, err)
+	}
+	return *seat
+}
+
+// queuedTopics returns the subject of every seat event the store has queued, in order
+func queuedTopics(t *testing.T) []string {
+	t.Helper()
+
+	var topics []string
+	if store, ok := seatStore.(*memorySeatStore); ok {
+		for _, event := range store.queuedEvents() {
+			topics = append(topics, event.Topic)
+		}
+		return topics
+	}
+	for _, entry := range redisClient.XRange(ctx, shared.RedisKeyOutbox, "-", "+").Val() {
+		topic, _ := entry.Values["topic"].(string)
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// expiryIndexed returns when a seat's indexed hold expires, if it is indexed
+func expiryIndexed(t *testing.T, seatID string) (time.Time, bool) {
+	t.Helper()
+
+	expiresAt, ok, err := seatStore.HoldExpiry(seatID)
+	if err != nil {
+		t.Fatalf("hold expiry of %s: %v", seatID, err)
+	}
+	return expiresAt, ok
+}
+
+// backdateHold makes a seat's hold look as if it ran out a minute ago
+func backdateHold(t *testing.T, seatID string) {
+	t.Helper()
+
+	expiresAt := time.Now().Add(-time.Minute)
+	seat := loadSeat(t, seatID)
+	seat.ExpiresAt = expiresAt.Unix()
+	if err := casUpdateSeat(seatStore, &seat, seat.Version, "held", seat.HeldBy, ""); err != nil {
+		t.Fatalf("backdate %s: %v", seatID, err)
+	}
+	if err := seatStore.IndexHold(seatID, expiresAt); err != nil {
+		t.Fatalf("index %s: %v", seatID, err)
+	}
+}
+
+func TestHoldsAreIndexedUntilBookedOrReleased(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		booked, released := shared.GetSeatID(0, 0), shared.GetSeatID(0, 1)
+
+		for _, seatID := range []string{booked, released} {
+			if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+				t.Fatalf("SelectSeat %s: %v", seatID, err)
+			}
+			if expiresAt, ok := expiryIndexed(t, seatID); !ok || expiresAt.Unix() != loadSeat(t, seatID).ExpiresAt {
+				t.Fatalf("%s indexed at %v (%v), want its hold's expiry", seatID, expiresAt, ok)
+			}
+		}
+
+		if _, err := BookSeat(ctx, booked, "holder", 0); err != nil {
+			t.Fatalf("BookSeat: %v", err)
+		}
+		if err := ReleaseSeat(ctx, released, "holder", 0); err != nil {
+			t.Fatalf("ReleaseSeat: %v", err)
+		}
+		for _, seatID := range []string{booked, released} {
+			if _, ok := expiryIndexed(t, seatID); ok {
+				t.Errorf("%s still indexed once its hold ended", seatID)
+			}
+		}
+	})
+}
+
+func TestExpiryCheckReleasesOnlyExpiredHolds(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		expired, live, stale := shared.GetSeatID(0, 0), shared.GetSeatID(0, 1), shared.GetSeatID(0, 2)
+
+		for _, seatID := range []string{expired, live} {
+			if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+				t.Fatalf("SelectSeat %s: %v", seatID, err)
+			}
+		}
+		backdateHold(t, expired)
+		// An entry left behind for a seat that is no longer held
+		if err := seatStore.IndexHold(stale, time.UnixMilli(1)); err != nil {
+			t.Fatalf("IndexHold: %v", err)
+		}
+
+		checkExpiredHolds(seatStore)
+
+		if seat := loadSeat(t, expired); seat.Status != shared.SeatAvailable || seat.HeldBy != "" {
+			t.Errorf("expired hold = %+v, want it released", seat)
+		}
+		if seat := loadSeat(t, live); seat.Status != shared.SeatHeld {
+			t.Errorf("live hold = %+v, want it kept", seat)
+		}
+		if _, ok := expiryIndexed(t, live); !ok {
+			t.Error("live hold dropped from the index")
+		}
+		for _, seatID := range []string{expired, stale} {
+			if _, ok := expiryIndexed(t, seatID); ok {
+				t.Errorf("%s still indexed after the check", seatID)
+			}
+		}
+		if seat := loadSeat(t, stale); seat.Status != shared.SeatAvailable {
+			t.Errorf("seat behind a stale entry = %+v, want it untouched", seat)
+		}
+	})
+}
+
+func TestRebuildExpiryIndexIndexesExistingHolds(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		// As if the hold was made before the index existed
+		if err := seatStore.UnindexHold(seatID); err != nil {
+			t.Fatalf("UnindexHold: %v", err)
+		}
+
+		if err := rebuildExpiryIndex(seatStore); err != nil {
+			t.Fatalf("rebuildExpiryIndex: %v", err)
+		}
+		if expiresAt, ok := expiryIndexed(t, seatID); !ok || expiresAt.Unix() != loadSeat(t, seatID).ExpiresAt {
+			t.Errorf("%s indexed at %v (%v), want its hold's expiry", seatID, expiresAt, ok)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Hold expiry modes selectable with HOLD_EXPIRY_MODE
+const (
+	ExpiryModeSweep    = "sweep"    // periodic timer sweep only
+	ExpiryModeKeyspace = "keyspace" // release as soon as the lock TTL fires, sweep as a backstop
+)
+
+// StartExpiryListener subscribes to Redis key-expired notifications when keyspace
+// mode is enabled, so seats are released the moment their lock expires instead of
+// on the next timer sweep
+func StartExpiryListener(redisClient *redis.Client) error {
+	mode := os.Getenv("HOLD_EXPIRY_MODE")
+	if mode == "" || mode == ExpiryModeSweep {
+		return nil
+	}
+	if mode != ExpiryModeKeyspace {
+		return fmt.Errorf("unknown HOLD_EXPIRY_MODE: %s", mode)
+	}
+
+	ctx := context.Background()
+
+	// Expired-key events are off by default; managed Redis may forbid CONFIG SET,
+	// in which case notify-keyspace-events must already include "Ex"
+	if err := redisClient.ConfigSet(ctx, "notify-keyspace-events", "Ex").Err(); err != nil {
+		slog.Warn("Could not enable keyspace notifications (set notify-keyspace-events=Ex manually)", shared.ErrAttr(err))
+	}
+
+	channel := fmt.Sprintf("__keyevent@%d__:expired", redisClient.Options().DB)
+	pubsub := redisClient.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			seatID, ok := seatIDFromLockKey(msg.Payload)
+			if !ok {
+				continue
+			}
+			if err := releaseExpiredLock(seatStore, seatID); err != nil {
+				slog.Error("Failed to release seat on lock expiry", shared.LogKeySeatID, seatID, shared.ErrAttr(err))
+			}
+		}
+	}()
+
+	slog.Info("Expiry listener subscribed", "channel", channel)
+	return nil
+}
+
+// seatIDFromLockKey extracts the seat ID from a "seat:<id>:lock" key
+func seatIDFromLockKey(key string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(shared.RedisKeySeatLock, "%s")
+	if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+		return "", false
+	}
+	seatID := strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix)
+	return seatID, seatID != ""
+}
+
+// releaseExpiredLock releases a seat whose lock just expired, unless it was booked,
+// released, or re-held in the meantime
+func releaseExpiredLock(store SeatStore, seatID string) error {
+	seat, err := store.GetSeat(seatID)
+	if err == errSeatNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if seat.Status != shared.SeatHeld {
+		return nil
+	}
+
+	// Another user may have acquired a fresh lock since the notification fired
+	if holder, err := store.LockHolder(seatID); err != nil || holder != "" {
+		return err
+	}
+
+	previousHolder := seat.HeldBy
+	if err := autoReleaseSeat(store, seat); err != nil {
+		return err
+	}
+
+	slog.Info("Auto-released seat on lock expiry", shared.LogKeySeatID, seatID, shared.LogKeyUserID, previousHolder)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestSeatIDFromLockKey(t *testing.T) {
+	for _, tc := range []struct {
+		key    string
+		seatID string
+		ok     bool
+	}{
+		{"seat:A1:lock", "A1", true},
+		{"seat:101-B12:lock", "101-B12", true},
+		{"seat::lock", "", false},
+		{"seat:A1", "", false},
+		{"seat:A1:lockx", "", false},
+		{"seats:A1:lock", "", false},
+		{"idempotency:A1:lock", "", false},
+		{"", "", false},
+	} {
+		seatID, ok := seatIDFromLockKey(tc.key)
+		if seatID != tc.seatID || ok != tc.ok {
+			t.Errorf("seatIDFromLockKey(%q) = %q, %v; want %q, %v", tc.key, seatID, ok, tc.seatID, tc.ok)
+		}
+	}
+}
+
+func TestReleaseExpiredLock(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		expired, reheld, booked := shared.GetSeatID(0, 0), shared.GetSeatID(0, 1), shared.GetSeatID(0, 2)
+		for _, seatID := range []string{expired, reheld, booked} {
+			if err := SelectSeat(ctx, seatID, "alice", 0); err != nil {
+				t.Fatalf("SelectSeat %s: %v", seatID, err)
+			}
+		}
+		if _, err := BookSeat(ctx, booked, "alice", 0); err != nil {
+			t.Fatalf("BookSeat: %v", err)
+		}
+
+		// Every lock expires; bob takes a fresh one on the re-held seat before
+		// its notification is handled
+		for _, seatID := range []string{expired, reheld, booked} {
+			if err := seatStore.ReleaseLock(seatID); err != nil {
+				t.Fatalf("ReleaseLock %s: %v", seatID, err)
+			}
+		}
+		if ok, err := seatStore.AcquireLock(reheld, "bob", time.Minute); err != nil || !ok {
+			t.Fatalf("AcquireLock = %v, %v", ok, err)
+		}
+
+		for _, seatID := range []string{expired, reheld, booked, "no-such-seat"} {
+			if err := releaseExpiredLock(seatStore, seatID); err != nil {
+				t.Fatalf("releaseExpiredLock %s: %v", seatID, err)
+			}
+		}
+
+		for seatID, want := range map[string]shared.SeatStatus{expired: shared.SeatAvailable, reheld: shared.SeatHeld, booked: shared.SeatBooked} {
+			seat, err := seatStore.GetSeat(seatID)
+			if err != nil {
+				t.Fatalf("GetSeat %s: %v", seatID, err)
+			}
+			if seat.Status != want {
+				t.Errorf("seat %s status %v, want %v", seatID, seat.Status, want)
+			}
+		}
+		if holder, _ := seatStore.LockHolder(reheld); holder != "bob" {
+			t.Errorf("re-held seat's lock holder = %q, want bob's lock kept", holder)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+)
+
+// configBucket is where event metadata and feature flags are published for
+// edges. It is nil without NATS or JetStream; edges then fetch the event
+// metadata from the API and see every flag off.
+var configBucket nats.KeyValue
+
+// startConfigPublishing opens the config bucket and publishes the current
+// event metadata and flags into it, so a new or emptied bucket is complete
+func startConfigPublishing(nc *nats.Conn) {
+	kv, err := shared.OpenConfigBucket(nc)
+	if err != nil {
+		slog.Warn("Config bucket unavailable, edges will poll for event metadata", shared.ErrAttr(err))
+		return
+	}
+	configBucket = kv
+
+	if event, err := GetEventInfo(); err == nil {
+		publishConfig(shared.ConfigKeyEvent(event.ID), event)
+	}
+	if flags, err := GetFeatureFlags(); err == nil {
+		publishConfig(shared.ConfigKeyFlags, flags)
+	}
+	slog.Info("Publishing config to NATS KV", "bucket", shared.ConfigBucket)
+}
+
+// publishConfig puts value in the config bucket. Failures are logged: Redis
+// stays the source of truth and the next change or restart republishes.
+func publishConfig(key string, value interface{}) {
+	if configBucket == nil {
+		return
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		slog.Error("Failed to encode config", "key", key, shared.ErrAttr(err))
+		return
+	}
+	if _, err := configBucket.Put(key, valueJSON); err != nil {
+		slog.Error("Failed to publish config", "key", key, shared.ErrAttr(err))
+	}
+}
+
+var errSalesPaused = shared.NewError(shared.ErrorCodeSalesPaused, "sales are paused, try again shortly")
+
+// salesPaused reports whether an operator has paused sales
+func salesPaused() (bool, error) {
+	value, err := redisClient.HGet(ctx, shared.RedisKeyFeatureFlags, shared.FlagSalesPaused).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return value == "1", err
+}
+
+// checkSalesNotPaused fails while sales are paused. Like the ban check, it
+// lets the hold through if the flag cannot be read.
+func checkSalesNotPaused() error {
+	paused, err := salesPaused()
+	if err != nil {
+		slog.Warn("Failed to check whether sales are paused", shared.ErrAttr(err))
+		return nil
+	}
+	if paused {
+		return errSalesPaused
+	}
+	return nil
+}
+
+// GetFeatureFlags returns every flag that has been set
+func GetFeatureFlags() (shared.FeatureFlags, error) {
+	stored, err := redisClient.HGetAll(ctx, shared.RedisKeyFeatureFlags).Result()
+	if err != nil {
+		return nil, err
+	}
+	flags := make(shared.FeatureFlags, len(stored))
+	for name, value := range stored {
+		flags[name] = value == "1"
+	}
+	return flags, nil
+}
+
+// SetFeatureFlag turns a flag on or off and publishes the new set
+func SetFeatureFlag(name string, enabled bool) (shared.FeatureFlags, error) {
+	if err := shared.ValidateFlagName(name); err != nil {
+		return nil, err
+	}
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	if err := redisClient.HSet(ctx, shared.RedisKeyFeatureFlags, name, value).Err(); err != nil {
+		return nil, err
+	}
+	slog.Info("Set feature flag", shared.LogKeyComponent, "admin", "flag", name, "enabled", enabled)
+	return publishFeatureFlags()
+}
+
+// DeleteFeatureFlag forgets a flag, which turns it off
+func DeleteFeatureFlag(name string) (shared.FeatureFlags, error) {
+	if err := redisClient.HDel(ctx, shared.RedisKeyFeatureFlags, name).Err(); err != nil {
+		return nil, err
+	}
+	slog.Info("Deleted feature flag", shared.LogKeyComponent, "admin", "flag", name)
+	return publishFeatureFlags()
+}
+
+func publishFeatureFlags() (shared.FeatureFlags, error) {
+	flags, err := GetFeatureFlags()
+	if err != nil {
+		return nil, err
+	}
+	publishConfig(shared.ConfigKeyFlags, flags)
+	return flags, nil
+}
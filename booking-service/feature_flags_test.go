@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestFeatureFlagsAdminAPI(t *testing.T) {
+	newTestRedis(t)
+	router := adminRouter(t)
+	do := func(method, path, body string) (int, shared.FeatureFlags) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var flags shared.FeatureFlags
+		json.Unmarshal(w.Body.Bytes(), &flags)
+		return w.Code, flags
+	}
+
+	if code, flags := do(http.MethodPut, "/api/admin/flags/seat-map-v2", `{"enabled":true}`); code != http.StatusOK || !flags["seat-map-v2"] {
+		t.Fatalf("enable flag = %d %v", code, flags)
+	}
+	do(http.MethodPut, "/api/admin/flags/resale", `{"enabled":false}`)
+	if code, flags := do(http.MethodGet, "/api/admin/flags", ""); code != http.StatusOK || len(flags) != 2 || flags["resale"] {
+		t.Errorf("flags = %d %v, want seat-map-v2 on and resale off", code, flags)
+	}
+	if _, flags := do(http.MethodDelete, "/api/admin/flags/seat-map-v2", ""); len(flags) != 1 {
+		t.Errorf("flags after delete = %v", flags)
+	}
+
+	for _, req := range [][2]string{{"/api/admin/flags/Not.Valid", `{"enabled":true}`}, {"/api/admin/flags/resale", `{}`}} {
+		if code, _ := do(http.MethodPut, req[0], req[1]); code != http.StatusBadRequest {
+			t.Errorf("PUT %s %s = %d, want 400", req[0], req[1], code)
+		}
+	}
+}
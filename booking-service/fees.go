@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"concert-booking/shared"
+)
+
+// Charge is a fee or tax added to an order's ticket price at checkout
+type Charge struct {
+	Kind        string `json:"kind"` // fee or tax, as on receipt lines
+	Name        string `json:"name"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// Names of the fee charges
+const (
+	chargeFacilityFee   = "Facility fee"
+	chargeServiceCharge = "Service charge"
+)
+
+// feesFromEnv reads EVENT_FACILITY_FEE_CENTS, EVENT_SERVICE_CHARGE (a
+// percentage) and EVENT_TAXES (e.g. "VAT=20,City tax=1.5", in percent). It
+// returns nil when none is set.
+func feesFromEnv() (*shared.FeeSchedule, error) {
+	var fees shared.FeeSchedule
+	if v := os.Getenv("EVENT_FACILITY_FEE_CENTS"); v != "" {
+		cents, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EVENT_FACILITY_FEE_CENTS %q", v)
+		}
+		fees.FacilityFeeCents = cents
+	}
+	if v := os.Getenv("EVENT_SERVICE_CHARGE"); v != "" {
+		bps, err := parsePercent(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EVENT_SERVICE_CHARGE: %w", err)
+		}
+		fees.ServiceChargeBps = bps
+	}
+	if v := os.Getenv("EVENT_TAXES"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			name, rate, ok := strings.Cut(part, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid EVENT_TAXES entry %q, want name=percent", part)
+			}
+			bps, err := parsePercent(rate)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EVENT_TAXES entry %q: %w", part, err)
+			}
+			fees.Taxes = append(fees.Taxes, shared.TaxRate{Name: strings.TrimSpace(name), RateBps: bps})
+		}
+	}
+
+	if fees.FacilityFeeCents == 0 && fees.ServiceChargeBps == 0 && len(fees.Taxes) == 0 {
+		return nil, nil
+	}
+	return &fees, nil
+}
+
+// parsePercent converts a percentage such as "7.5" to basis points
+func parsePercent(s string) (int64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a percentage", s)
+	}
+	return int64(math.Round(pct * 100)), nil
+}
+
+// validateFees rejects negative amounts and rates outside 0-100%
+func validateFees(fees *shared.FeeSchedule) error {
+	if fees == nil {
+		return nil
+	}
+	if fees.FacilityFeeCents < 0 {
+		return fmt.Errorf("facility fee must not be negative, got %d", fees.FacilityFeeCents)
+	}
+	if fees.ServiceChargeBps < 0 || fees.ServiceChargeBps > 10000 {
+		return fmt.Errorf("service charge must be 0-10000 basis points, got %d", fees.ServiceChargeBps)
+	}
+	for _, tax := range fees.Taxes {
+		if tax.Name == "" {
+			return fmt.Errorf("every tax needs a name")
+		}
+		if tax.RateBps < 0 || tax.RateBps > 10000 {
+			return fmt.Errorf("tax %q must be 0-10000 basis points, got %d", tax.Name, tax.RateBps)
+		}
+	}
+	return nil
+}
+
+// computeCharges itemizes the fees and taxes on a ticket of priceCents. Fees
+// come first; each tax is levied on the price plus those fees. Amounts are
+// rounded half up to the currency's minor unit.
+func computeCharges(priceCents int64, fees *shared.FeeSchedule) []Charge {
+	if fees == nil {
+		return nil
+	}
+
+	var charges []Charge
+	taxable := priceCents
+	if fees.FacilityFeeCents > 0 {
+		charges = append(charges, Charge{Kind: LineFee, Name: chargeFacilityFee, AmountCents: fees.FacilityFeeCents})
+		taxable += fees.FacilityFeeCents
+	}
+	if amount := applyRate(priceCents, fees.ServiceChargeBps); amount > 0 {
+		charges = append(charges, Charge{Kind: LineFee, Name: chargeServiceCharge, AmountCents: amount})
+		taxable += amount
+	}
+	for _, tax := range fees.Taxes {
+		if amount := applyRate(taxable, tax.RateBps); amount > 0 {
+			charges = append(charges, Charge{Kind: LineTax, Name: tax.Name, AmountCents: amount})
+		}
+	}
+	return charges
+}
+
+// applyRate returns bps basis points of cents, rounded half up
+func applyRate(cents, bps int64) int64 {
+	return (cents*bps + 5000) / 10000
+}
+
+// chargeTotals sums charges into fees and taxes
+func chargeTotals(charges []Charge) (feesCents, taxCents int64) {
+	for _, charge := range charges {
+		if charge.Kind == LineTax {
+			taxCents += charge.AmountCents
+		} else {
+			feesCents += charge.AmountCents
+		}
+	}
+	return feesCents, taxCents
+}
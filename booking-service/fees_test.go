@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestComputeChargesTaxesThePriceAndFees(t *testing.T) {
+	fees := &shared.FeeSchedule{
+		FacilityFeeCents: 150,
+		ServiceChargeBps: 1000,
+		Taxes:            []shared.TaxRate{{Name: "VAT", RateBps: 1900}, {Name: "City tax", RateBps: 0}},
+	}
+	got := computeCharges(5005, fees)
+	want := []Charge{
+		{Kind: LineFee, Name: chargeFacilityFee, AmountCents: 150},
+		{Kind: LineFee, Name: chargeServiceCharge, AmountCents: 501}, // 500.5 rounds up
+		{Kind: LineTax, Name: "VAT", AmountCents: 1075},              // 19% of 5656
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("charges = %+v, want %+v", got, want)
+	}
+	if feesCents, taxCents := chargeTotals(got); feesCents != 651 || taxCents != 1075 {
+		t.Errorf("totals = %d fees, %d tax", feesCents, taxCents)
+	}
+	if charges := computeCharges(5000, nil); charges != nil {
+		t.Errorf("charges without a fee schedule = %+v", charges)
+	}
+}
+
+func TestFeesFromEnv(t *testing.T) {
+	t.Setenv("EVENT_FACILITY_FEE_CENTS", "200")
+	t.Setenv("EVENT_SERVICE_CHARGE", "7.5%")
+	t.Setenv("EVENT_TAXES", "VAT=20, City tax=1.5")
+
+	fees, err := feesFromEnv()
+	if err != nil {
+		t.Fatalf("feesFromEnv: %v", err)
+	}
+	want := &shared.FeeSchedule{
+		FacilityFeeCents: 200,
+		ServiceChargeBps: 750,
+		Taxes:            []shared.TaxRate{{Name: "VAT", RateBps: 2000}, {Name: "City tax", RateBps: 150}},
+	}
+	if !reflect.DeepEqual(fees, want) {
+		t.Errorf("fees = %+v, want %+v", fees, want)
+	}
+
+	t.Setenv("EVENT_TAXES", "VAT")
+	if _, err := feesFromEnv(); err == nil {
+		t.Error("a tax without a rate was accepted")
+	}
+}
+
+func TestOrdersAreItemizedWithTheEventsFees(t *testing.T) {
+	newTestRedis(t)
+	if _, err := UpdateEventInfo(shared.EventInfo{Currency: "EUR", Timezone: "UTC", Fees: &shared.FeeSchedule{
+		FacilityFeeCents: 100,
+		Taxes:            []shared.TaxRate{{Name: "VAT", RateBps: 2000}},
+	}}); err != nil {
+		t.Fatalf("UpdateEventInfo: %v", err)
+	}
+
+	order, err := createOrder(&shared.Seat{ID: "A1", PriceCents: 4900}, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Charges) != 2 || order.TotalCents != 6000 {
+		t.Fatalf("order charges = %+v, total %d, want a fee and VAT totalling 6000", order.Charges, order.TotalCents)
+	}
+
+	receipt, err := BuildReceipt(order.ID)
+	if err != nil {
+		t.Fatalf("BuildReceipt: %v", err)
+	}
+	if receipt.SubtotalCents != 4900 || receipt.TotalCents != 6000 || len(receipt.Lines) != 3 {
+		t.Errorf("receipt = %d subtotal, %d total, lines %+v", receipt.SubtotalCents, receipt.TotalCents, receipt.Lines)
+	}
+
+	if err := (analyticsStep{}).Run(order); err != nil {
+		t.Fatalf("analytics: %v", err)
+	}
+	analytics, _ := redisClient.HGetAll(ctx, shared.RedisKeyAnalytics).Result()
+	if analytics["fees_cents"] != "100" || analytics["tax_cents"] != "1000" {
+		t.Errorf("analytics = %v, want fees and tax counted", analytics)
+	}
+
+	if _, err := UpdateEventInfo(shared.EventInfo{Currency: "EUR", Timezone: "UTC", Fees: &shared.FeeSchedule{ServiceChargeBps: 12000}}); err == nil {
+		t.Error("a service charge over 100% was accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+	"concert-booking/shared/fixtures"
+)
+
+// createFixtureOrder records orders for seats a fixtures.Scenario books
+func createFixtureOrder(seat shared.Seat, userID string) (string, error) {
+	order, err := createOrder(&seat, userID)
+	if err != nil {
+		return "", err
+	}
+	return order.ID, nil
+}
+
+func TestFixturesDriveTheBookingService(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		now := time.Now()
+		expired, held, booked := shared.GetSeatID(0, 0), shared.GetSeatID(0, 1), shared.GetSeatID(0, 2)
+		scenario := fixtures.On(seatStore).WithOrders(createFixtureOrder).
+			Hold(expired, "alice", now.Add(-time.Second)).
+			Hold(held, "bob", now.Add(time.Minute)).
+			Book(booked, "carol").
+			MustApply(t)
+
+		if err := SelectSeat(ctx, held, "alice", 0); err == nil {
+			t.Error("selected a seat the fixtures hold for someone else")
+		}
+
+		checkExpiredHolds(seatStore)
+		if seat := loadSeat(t, expired); seat.Status != shared.SeatAvailable {
+			t.Errorf("expired fixture hold is %s after the sweep, want available", seat.Status)
+		}
+		if seat := loadSeat(t, held); seat.Status != shared.SeatHeld || seat.HeldBy != "bob" {
+			t.Errorf("live fixture hold = %+v, want held by bob", seat)
+		}
+
+		order, err := GetOrder(scenario.OrderID(booked))
+		if err != nil {
+			t.Fatalf("GetOrder: %v", err)
+		}
+		if order.SeatID != booked || order.UserID != "carol" {
+			t.Errorf("order = %+v, want carol's order for %s", order, booked)
+		}
+	})
+}
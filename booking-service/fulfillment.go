@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Order and fulfillment step states
+const (
+	OrderPending   = "pending"
+	OrderFulfilled = "fulfilled"
+	OrderFailed    = "failed"
+
+	StepPending   = "pending"
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+)
+
+const (
+	// Attempts per step before the order is marked failed
+	fulfillmentStepAttempts = 3
+
+	// How long the worker blocks waiting for queued orders
+	fulfillmentPoll = 5 * time.Second
+)
+
+var errOrderNotFound = errors.New("order not found")
+
+// Delay before the first retry of a step, doubled on each retry
+var fulfillmentRetryDelay = 1 * time.Second
+
+// Order is a confirmed booking and the progress of its fulfillment
+type Order struct {
+	ID         string       `json:"id"`
+	SeatID     string       `json:"seat_id"`
+	UserID     string       `json:"user_id"`
+	Section    string       `json:"section,omitempty"`
+	Tier       string       `json:"tier,omitempty"`
+	PriceCents int64        `json:"price_cents,omitempty"`
+	Charges    []Charge     `json:"charges,omitempty"` // fees and taxes on top of the price
+	TotalCents int64        `json:"total_cents,omitempty"`
+	Status     string       `json:"status"`
+	TicketCode string       `json:"ticket_code,omitempty"`
+	Steps      []StepStatus `json:"steps"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+
+	// Payment and Refund are filled in from the payment callbacks and the
+	// refund worker when the order is loaded; they are never saved with the order
+	Payment *PaymentStatus `json:"payment,omitempty"`
+	Refund  *RefundStatus  `json:"refund,omitempty"`
+}
+
+// StepStatus tracks one fulfillment step of an order
+type StepStatus struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// FulfillmentStep is one stage of post-booking fulfillment. Steps run in order;
+// a step that keeps failing stops the pipeline until the order is retried, and
+// steps that already succeeded are not run again.
+type FulfillmentStep interface {
+	Name() string
+	Run(order *Order) error
+}
+
+// fulfillmentPipeline lists the steps every order goes through
+var fulfillmentPipeline = []FulfillmentStep{
+	ticketStep{},
+	notificationStep{},
+	webhookStep{},
+	analyticsStep{},
+}
+
+// createOrder records an order for a just-booked seat, with the event's fees
+// and taxes at the time, and queues its fulfillment
+func createOrder(seat *shared.Seat, userID string) (*Order, error) {
+	event, err := GetEventInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	order := &Order{
+		ID:         uuid.NewString(),
+		SeatID:     seat.ID,
+		UserID:     userID,
+		Section:    seat.Section,
+		Tier:       seat.Tier,
+		PriceCents: seat.PriceCents,
+		Status:     OrderPending,
+		Charges:    computeCharges(seat.PriceCents, event.Fees),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	order.TotalCents = order.PriceCents
+	for _, charge := range order.Charges {
+		order.TotalCents += charge.AmountCents
+	}
+	for _, step := range fulfillmentPipeline {
+		order.Steps = append(order.Steps, StepStatus{Name: step.Name(), Status: StepPending})
+	}
+
+	if err := saveOrder(order); err != nil {
+		return nil, err
+	}
+	if err := redisClient.RPush(ctx, shared.RedisKeyFulfillment, order.ID).Err(); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetOrder loads an order by ID
+func GetOrder(orderID string) (*Order, error) {
+	orderJSON, err := redisClient.HGet(ctx, shared.RedisKeyOrders, orderID).Result()
+	if err == redis.Nil {
+		return nil, errOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var order Order
+	if err := json.Unmarshal([]byte(orderJSON), &order); err != nil {
+		return nil, err
+	}
+	if order.Payment, err = loadPaymentStatus(redisClient, orderID); err != nil {
+		return nil, err
+	}
+	if order.Refund, err = loadRefundStatus(redisClient, orderID); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// RetryFulfillment re-queues a failed order; steps that already succeeded are skipped
+func RetryFulfillment(orderID string) (*Order, error) {
+	order, err := GetOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != OrderFailed {
+		return nil, fmt.Errorf("order is %s, only failed orders can be retried", order.Status)
+	}
+
+	order.Status = OrderPending
+	order.UpdatedAt = time.Now()
+	if err := saveOrder(order); err != nil {
+		return nil, err
+	}
+	if err := redisClient.RPush(ctx, shared.RedisKeyFulfillment, order.ID).Err(); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func saveOrder(order *Order) error {
+	stored := *order
+	stored.Payment = nil
+	stored.Refund = nil
+	orderJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(ctx, shared.RedisKeyOrders, order.ID, orderJSON).Err()
+}
+
+// StartFulfillmentWorker processes queued orders in the background
+func StartFulfillmentWorker() {
+	go func() {
+		for {
+			result, err := redisClient.BLPop(context.Background(), fulfillmentPoll, shared.RedisKeyFulfillment).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				slog.Error("Failed to read fulfillment queue", shared.ErrAttr(err))
+				time.Sleep(fulfillmentRetryDelay)
+				continue
+			}
+
+			order, err := GetOrder(result[1])
+			if err != nil {
+				slog.Error("Failed to load order for fulfillment", "order_id", result[1], shared.ErrAttr(err))
+				continue
+			}
+			fulfillOrder(order)
+		}
+	}()
+	slog.Info("Fulfillment worker started")
+}
+
+// fulfillOrder runs every pending step of an order, saving progress after each step
+func fulfillOrder(order *Order) {
+	// Orders created before a step was added to the pipeline get it too
+	for i := len(order.Steps); i < len(fulfillmentPipeline); i++ {
+		order.Steps = append(order.Steps, StepStatus{Name: fulfillmentPipeline[i].Name(), Status: StepPending})
+	}
+
+	for i, step := range fulfillmentPipeline {
+		status := &order.Steps[i]
+		if status.Status == StepSucceeded {
+			continue
+		}
+
+		delay := fulfillmentRetryDelay
+		for attempt := 1; attempt <= fulfillmentStepAttempts; attempt++ {
+			status.Attempts++
+			err := step.Run(order)
+			order.UpdatedAt = time.Now()
+			if err == nil {
+				completedAt := order.UpdatedAt
+				status.Status = StepSucceeded
+				status.LastError = ""
+				status.CompletedAt = &completedAt
+				break
+			}
+
+			status.Status = StepFailed
+			status.LastError = err.Error()
+			slog.Warn("Fulfillment step failed", "order_id", order.ID, "step", step.Name(), "attempt", attempt, "max_attempts", fulfillmentStepAttempts, shared.ErrAttr(err))
+			if attempt < fulfillmentStepAttempts {
+				time.Sleep(delay)
+				delay *= 2
+			}
+		}
+
+		if status.Status != StepSucceeded {
+			order.Status = OrderFailed
+			if err := saveOrder(order); err != nil {
+				slog.Error("Failed to save order", "order_id", order.ID, shared.ErrAttr(err))
+			}
+			slog.Error("Order fulfillment stopped", "order_id", order.ID, "step", step.Name())
+			return
+		}
+		if err := saveOrder(order); err != nil {
+			slog.Error("Failed to save order", "order_id", order.ID, shared.ErrAttr(err))
+		}
+	}
+
+	order.Status = OrderFulfilled
+	if err := saveOrder(order); err != nil {
+		slog.Error("Failed to save order", "order_id", order.ID, shared.ErrAttr(err))
+		return
+	}
+	slog.Info("Order fulfilled", "order_id", order.ID, shared.LogKeySeatID, order.SeatID, shared.LogKeyUserID, order.UserID)
+}
+
+// ticketStep issues the ticket code the buyer presents at the venue
+type ticketStep struct{}
+
+func (ticketStep) Name() string { return "generate_ticket" }
+
+func (ticketStep) Run(order *Order) error {
+	if order.TicketCode != "" {
+		return nil
+	}
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	order.TicketCode = strings.ToUpper(hex.EncodeToString(b))
+	return nil
+}
+
+// notificationStep tells the buyer, on the channel they prefer, that the booking is confirmed
+type notificationStep struct{}
+
+func (notificationStep) Name() string { return "send_notification" }
+
+func (notificationStep) Run(order *Order) error {
+	err := notifyUser(order.UserID, shared.MessageTypeBookingConfirmed, map[string]interface{}{
+		"order_id":    order.ID,
+		"seat_id":     order.SeatID,
+		"ticket_code": order.TicketCode,
+		"receipt_url": shared.APIPrefix + "/orders/" + order.ID + "/receipt",
+	})
+	if err == errUserOffline {
+		// Nobody to notify live; the order remains available from the API
+		return nil
+	}
+	return err
+}
+
+// webhookStep tells webhook consumers the order is ready
+type webhookStep struct{}
+
+func (webhookStep) Name() string { return "emit_webhook" }
+
+func (webhookStep) Run(order *Order) error {
+	webhooks.enqueue(shared.SeatEvent{
+		Type:      "order_fulfilled",
+		SeatID:    order.SeatID,
+		UserID:    order.UserID,
+		Status:    shared.SeatBooked,
+		Timestamp: time.Now(),
+	})
+	return nil
+}
+
+// analyticsStep counts the booking, its ticket revenue and what was collected
+// in fees and taxes
+type analyticsStep struct{}
+
+func (analyticsStep) Name() string { return "record_analytics" }
+
+func (analyticsStep) Run(order *Order) error {
+	pipe := redisClient.TxPipeline()
+	pipe.HIncrBy(ctx, shared.RedisKeyAnalytics, "bookings", 1)
+	pipe.HIncrBy(ctx, shared.RedisKeyAnalytics, "revenue_cents", order.PriceCents)
+	if feesCents, taxCents := chargeTotals(order.Charges); feesCents != 0 || taxCents != 0 {
+		pipe.HIncrBy(ctx, shared.RedisKeyAnalytics, "fees_cents", feesCents)
+		pipe.HIncrBy(ctx, shared.RedisKeyAnalytics, "tax_cents", taxCents)
+	}
+	if order.Tier != "" {
+		pipe.HIncrBy(ctx, shared.RedisKeyAnalytics, "tier:"+order.Tier, 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
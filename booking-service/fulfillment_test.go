@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// flakyStep fails until failures reaches zero
+type flakyStep struct {
+	failures *int
+	runs     *int
+}
+
+func (flakyStep) Name() string { return "flaky" }
+
+func (s flakyStep) Run(order *Order) error {
+	*s.runs++
+	if *s.failures > 0 {
+		*s.failures--
+		return errors.New("downstream unavailable")
+	}
+	return nil
+}
+
+func TestBookingCreatesQueuedOrderThatFulfills(t *testing.T) {
+	newTestRedis(t)
+
+	seatID := shared.GetSeatID(0, 0)
+	if err := SelectSeat(ctx, seatID, "user-1", 0); err != nil {
+		t.Fatalf("SelectSeat: %v", err)
+	}
+	order, err := BookSeat(ctx, seatID, "user-1", 0)
+	if err != nil || order == nil {
+		t.Fatalf("BookSeat = %v, %v", order, err)
+	}
+
+	queued, _ := redisClient.LRange(ctx, shared.RedisKeyFulfillment, 0, -1).Result()
+	if len(queued) != 1 || queued[0] != order.ID {
+		t.Fatalf("fulfillment queue = %v, want [%s]", queued, order.ID)
+	}
+
+	fulfillOrder(order)
+
+	stored, err := GetOrder(order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != OrderFulfilled || stored.TicketCode == "" {
+		t.Fatalf("order = %s with ticket %q, want fulfilled with a ticket", stored.Status, stored.TicketCode)
+	}
+	for _, step := range stored.Steps {
+		if step.Status != StepSucceeded || step.Attempts != 1 {
+			t.Errorf("step %s = %s after %d attempts", step.Name, step.Status, step.Attempts)
+		}
+	}
+	if n, _ := redisClient.HGet(ctx, shared.RedisKeyAnalytics, "bookings").Int(); n != 1 {
+		t.Errorf("analytics bookings = %d, want 1", n)
+	}
+}
+
+func TestFailedFulfillmentResumesAtFailedStep(t *testing.T) {
+	newTestRedis(t)
+
+	failures, flakyRuns := fulfillmentStepAttempts, 0
+	originalPipeline, originalDelay := fulfillmentPipeline, fulfillmentRetryDelay
+	fulfillmentPipeline = []FulfillmentStep{ticketStep{}, flakyStep{&failures, &flakyRuns}}
+	fulfillmentRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		fulfillmentPipeline, fulfillmentRetryDelay = originalPipeline, originalDelay
+	})
+
+	order, err := createOrder(&shared.Seat{ID: "A1"}, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fulfillOrder(order)
+
+	stored, _ := GetOrder(order.ID)
+	if stored.Status != OrderFailed || stored.Steps[1].Status != StepFailed || stored.Steps[1].LastError == "" {
+		t.Fatalf("order = %+v, want failed at the flaky step with its error", stored)
+	}
+	ticket := stored.TicketCode
+
+	if _, err := RetryFulfillment(order.ID); err != nil {
+		t.Fatalf("RetryFulfillment: %v", err)
+	}
+	retried, _ := GetOrder(order.ID)
+	fulfillOrder(retried)
+
+	stored, _ = GetOrder(order.ID)
+	if stored.Status != OrderFulfilled {
+		t.Fatalf("order status after retry = %s, want fulfilled", stored.Status)
+	}
+	if stored.TicketCode != ticket || stored.Steps[0].Attempts != 1 {
+		t.Error("retry re-ran a step that had already succeeded")
+	}
+	if flakyRuns != fulfillmentStepAttempts+1 {
+		t.Errorf("flaky step ran %d times, want %d", flakyRuns, fulfillmentStepAttempts+1)
+	}
+}
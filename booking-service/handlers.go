@@ -1,37 +1,168 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"concert-booking/shared"
 
 	"github.com/gin-gonic/gin"
 )
 
+// handleGetSeats returns the seats, optionally filtered by ?status, ?row,
+// ?section, ?tier and ?max_price and paged with ?limit and ?cursor
 func handleGetSeats(c *gin.Context) {
-	seats, err := GetAllSeats()
+	snapshot, err := GetVenueSnapshot()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get seats"})
+		return
+	}
+
+	currency := defaultEventCurrency
+	if snapshot.Event != nil {
+		currency = snapshot.Event.Currency
+	}
+	query, err := parseSeatQuery(c.Request.URL.Query(), currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+
+	page, next := paginate(filterSeats(snapshot.Seats, query), query)
+	if next != "" {
+		c.Header(shared.HeaderNextCursor, next)
+	}
+	c.Header(shared.HeaderEventSeq, strconv.FormatInt(snapshot.Seq, 10))
+	c.JSON(http.StatusOK, page)
+}
+
+// handleSeatSummary returns seat counts by status, section and tier, for
+// clients that only need availability
+func handleSeatSummary(c *gin.Context) {
+	summary, err := GetSeatSummary()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get seat summary"})
+		return
+	}
+	c.Header(shared.HeaderEventSeq, strconv.FormatInt(summary.Seq, 10))
+	c.JSON(http.StatusOK, summary)
+}
+
+// handleGetSeatChanges returns the seats changed after ?since, an event
+// sequence number, for clients whose copy of the venue is only a little behind.
+// 410 means the changes are no longer kept and GET /api/seats is needed.
+func handleGetSeatChanges(c *gin.Context) {
+	since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+	if err != nil || since < 0 {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "since must be a seat event sequence number"})
+		return
+	}
+
+	changes, err := GetVenueChanges(since)
+	if err == errVenueChangesGone {
+		c.JSON(http.StatusGone, errorResponse(http.StatusGone, err))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Error: "Failed to get seats"})
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get seat changes"})
 		return
 	}
-	c.JSON(http.StatusOK, seats)
+	c.Header(shared.HeaderEventSeq, strconv.FormatInt(changes.Seq, 10))
+	c.JSON(http.StatusOK, changes)
+}
+
+// handleGetSeat returns one seat, for clients refreshing a single seat
+func handleGetSeat(c *gin.Context) {
+	seat, err := seatStoreFor(c.Request.Context()).GetSeat(c.Param("id"))
+	if err == errSeatNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get seat"})
+		return
+	}
+	c.JSON(http.StatusOK, seat)
+}
+
+// handleBatchGetSeats returns the seats a client is tracking, such as its
+// cart, without the rest of the venue
+func handleBatchGetSeats(c *gin.Context) {
+	var req shared.SeatBatchGetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+	if len(req.SeatIDs) == 0 || len(req.SeatIDs) > maxSeatBatchGet {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: fmt.Sprintf("seat_ids must list 1 to %d seats", maxSeatBatchGet)})
+		return
+	}
+
+	batch, err := GetSeatBatch(c.Request.Context(), req.SeatIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get seats"})
+		return
+	}
+	c.Header(shared.HeaderEventSeq, strconv.FormatInt(batch.Seq, 10))
+	c.JSON(http.StatusOK, batch)
+}
+
+// seatErrorStatus is the status a failed seat operation is answered with
+func seatErrorStatus(err error) int {
+	var cooldown *holdCooldownError
+	switch {
+	case errors.Is(err, errUserBanned):
+		return http.StatusForbidden
+	case errors.As(err, &cooldown):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusConflict
+}
+
+// seatError is the status and body a failed seat operation is answered with
+func seatError(err error) (int, shared.ErrorResponse) {
+	status := seatErrorStatus(err)
+	return status, errorResponse(status, err)
+}
+
+// errorResponse is the body of an error answered with status, coded by err
+// or, if err has no code, by status
+func errorResponse(status int, err error) shared.ErrorResponse {
+	return shared.ErrorResponse{Code: errorCode(status, err), Error: err.Error()}
+}
+
+// errorCode is the ErrorCode of err, answered with status
+func errorCode(status int, err error) shared.ErrorCode {
+	var cooldown *holdCooldownError
+	if errors.As(err, &cooldown) {
+		return shared.ErrorCodeHoldCooldown
+	}
+	return shared.CodeOf(err, shared.ErrorCodeForStatus(status))
 }
 
 func handleSelectSeat(c *gin.Context) {
 	var req shared.SeatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Error: "Invalid request"})
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
 		return
 	}
 
 	if req.SeatID == "" || req.UserID == "" {
-		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Error: "seat_id and user_id are required"})
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "seat_id and user_id are required"})
 		return
 	}
 
-	err := SelectSeat(req.SeatID, req.UserID)
+	err := SelectSeat(c.Request.Context(), req.SeatID, req.UserID, req.Version)
+	var cooldown *holdCooldownError
+	if errors.As(err, &cooldown) {
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(cooldown.until)))
+	}
 	if err != nil {
-		c.JSON(http.StatusConflict, shared.ErrorResponse{Error: err.Error()})
+		c.JSON(seatError(err))
 		return
 	}
 
@@ -41,41 +172,820 @@ func handleSelectSeat(c *gin.Context) {
 func handleBookSeat(c *gin.Context) {
 	var req shared.SeatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Error: "Invalid request"})
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
 		return
 	}
 
 	if req.SeatID == "" || req.UserID == "" {
-		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Error: "seat_id and user_id are required"})
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "seat_id and user_id are required"})
 		return
 	}
 
-	err := BookSeat(req.SeatID, req.UserID)
+	order, err := BookSeat(c.Request.Context(), req.SeatID, req.UserID, req.Version)
 	if err != nil {
-		c.JSON(http.StatusConflict, shared.ErrorResponse{Error: err.Error()})
+		c.JSON(seatError(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Seat booked successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Seat booked successfully", "order": order})
 }
 
 func handleReleaseSeat(c *gin.Context) {
 	var req shared.SeatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Error: "Invalid request"})
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
 		return
 	}
 
 	if req.SeatID == "" || req.UserID == "" {
-		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Error: "seat_id and user_id are required"})
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "seat_id and user_id are required"})
 		return
 	}
 
-	err := ReleaseSeat(req.SeatID, req.UserID)
+	err := ReleaseSeat(c.Request.Context(), req.SeatID, req.UserID, req.Version)
 	if err != nil {
-		c.JSON(http.StatusConflict, shared.ErrorResponse{Error: err.Error()})
+		c.JSON(seatError(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Seat released successfully"})
-}
\ No newline at end of file
+}
+
+// handleKeepHold is a holder's ping keeping their hold alive, for events
+// whose holds are kept alive on activity
+func handleKeepHold(c *gin.Context) {
+	var req shared.SeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	if req.SeatID == "" || req.UserID == "" {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "seat_id and user_id are required"})
+		return
+	}
+
+	expiresAt, err := KeepHold(c.Request.Context(), req.SeatID, req.UserID)
+	if err != nil {
+		c.JSON(seatError(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Hold kept alive", "expires_at": expiresAt})
+}
+
+func handleGetEvent(c *gin.Context) {
+	event, err := GetEventInfo()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get event"})
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+func handleUpdateEvent(c *gin.Context) {
+	var req shared.EventInfo
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	event, err := UpdateEventInfo(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// handleCancelEvent cancels the event and starts refunding its orders under
+// the posted policy
+func handleCancelEvent(c *gin.Context) {
+	var req struct {
+		Policy string `json:"policy"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	cancellation, err := CancelEvent(req.Policy, req.Reason)
+	if err == errEventCancelled {
+		c.JSON(http.StatusConflict, errorResponse(http.StatusConflict, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, cancellation)
+}
+
+func handleGetCancellation(c *gin.Context) {
+	cancellation, err := GetCancellation()
+	if err == errEventNotCancelled {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get cancellation"})
+		return
+	}
+	c.JSON(http.StatusOK, cancellation)
+}
+
+func handleRetryRefunds(c *gin.Context) {
+	retried, err := RetryFailedRefunds()
+	if err == errEventNotCancelled {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to retry refunds"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"retried": retried})
+}
+
+func handleListFlags(c *gin.Context) {
+	flags, err := GetFeatureFlags()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get feature flags"})
+		return
+	}
+	c.JSON(http.StatusOK, flags)
+}
+
+// FeatureFlagRequest is the body for setting a feature flag
+type FeatureFlagRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// handleSetFlag turns a feature flag on or off (PUT) or deletes it (DELETE),
+// returning every flag
+func handleSetFlag(c *gin.Context) {
+	name := c.Param("name")
+	if err := shared.ValidateFlagName(name); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+
+	var flags shared.FeatureFlags
+	var err error
+	if c.Request.Method == http.MethodDelete {
+		flags, err = DeleteFeatureFlag(name)
+	} else {
+		var req FeatureFlagRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+			c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "enabled is required"})
+			return
+		}
+		flags, err = SetFeatureFlag(name, *req.Enabled)
+	}
+	if err != nil {
+		shared.Logger(c.Request.Context()).Error("Failed to update feature flag", "flag", name, shared.ErrAttr(err))
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to update feature flag"})
+		return
+	}
+	c.JSON(http.StatusOK, flags)
+}
+
+func handleListVenueTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"templates": ListVenueTemplates()})
+}
+
+// WebhookRequest is the body for registering a webhook
+type WebhookRequest struct {
+	URL           string `json:"url"`
+	BatchSize     int    `json:"batch_size"`
+	BatchWindowMs int    `json:"batch_window_ms"`
+}
+
+func handleCreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	reg, err := RegisterWebhook(req.URL, req.BatchSize, req.BatchWindowMs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, reg)
+}
+
+func handleListWebhooks(c *gin.Context) {
+	regs, err := ListWebhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to list webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": regs})
+}
+
+func handleDeleteWebhook(c *gin.Context) {
+	err := DeleteWebhook(c.Param("id"))
+	switch {
+	case errors.Is(err, errWebhookNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to delete webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+func handleMemoryReport(c *gin.Context) {
+	report, err := GetMemoryReport()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to measure memory usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// BulkSeatUpdateRequest is the body for bulk seat status updates
+type BulkSeatUpdateRequest struct {
+	SeatIDs []string          `json:"seat_ids"`
+	Status  shared.SeatStatus `json:"status"` // name, or the legacy integer
+}
+
+func handleResetVenue(c *gin.Context) {
+	report, err := ResetVenue(c.Query("dry_run") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse(http.StatusInternalServerError, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func handleBulkUpdateSeats(c *gin.Context) {
+	var req BulkSeatUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	report, err := BulkUpdateSeats(req.SeatIDs, req.Status, c.Query("dry_run") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func handleGetOrder(c *gin.Context) {
+	order, err := GetOrder(c.Param("id"))
+	if err == errOrderNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get order"})
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+// handleGetReceipt returns an order's receipt as JSON, or as a PDF with
+// ?format=pdf or an Accept header asking for one
+func handleGetReceipt(c *gin.Context) {
+	receipt, err := BuildReceipt(c.Param("id"))
+	if err == errOrderNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to build receipt"})
+		return
+	}
+
+	if c.Query("format") == "pdf" || c.NegotiateFormat(gin.MIMEJSON, "application/pdf") == "application/pdf" {
+		c.Header("Content-Disposition", `inline; filename="receipt-`+receipt.Number+`.pdf"`)
+		c.Data(http.StatusOK, "application/pdf", renderReceiptPDF(receipt))
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// handlePaymentCallback records a payment status change reported by the
+// payment provider and pushes it to the buyer
+func handlePaymentCallback(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+	if err := verifyPaymentSignature(body, c.GetHeader(HeaderPaymentSignature)); err != nil {
+		c.JSON(http.StatusUnauthorized, errorResponse(http.StatusUnauthorized, err))
+		return
+	}
+
+	var cb PaymentCallback
+	if err := json.Unmarshal(body, &cb); err != nil || cb.OrderID == "" {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+	if _, ok := paymentMessageTypes[cb.Status]; !ok {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "status must be pending, succeeded or failed"})
+		return
+	}
+
+	changed, err := RecordPayment(cb)
+	if err == errOrderNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		shared.Logger(c.Request.Context()).Error("Failed to record payment", "order_id", cb.OrderID, shared.ErrAttr(err))
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to record payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"changed": changed})
+}
+
+// handleListBookings returns persisted bookings, filtered by ?user_id, ?section
+// and ?since (RFC 3339), oldest first and at most ?limit of them
+func handleListBookings(c *gin.Context) {
+	if bookingStore == nil {
+		c.JSON(http.StatusServiceUnavailable, errorResponse(http.StatusServiceUnavailable, errBookingsDisabled))
+		return
+	}
+
+	query := BookingQuery{
+		UserID:  c.Query("user_id"),
+		Section: c.Query("section"),
+		Limit:   maxBookingsPerQuery,
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "since must be an RFC 3339 time"})
+			return
+		}
+		query.Since = t
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "limit must be a positive integer"})
+			return
+		}
+		query.Limit = min(n, maxBookingsPerQuery)
+	}
+
+	bookings, err := bookingStore.List(query)
+	if err != nil {
+		shared.Logger(c.Request.Context()).Error("Failed to list bookings", shared.ErrAttr(err))
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to list bookings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bookings)
+}
+
+// handleSeatHistory returns a seat's transitions; ?at (RFC 3339) also picks out
+// the one in effect at that time
+func handleSeatHistory(c *gin.Context) {
+	var at time.Time
+	if s := c.Query("at"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "at must be an RFC 3339 time"})
+			return
+		}
+		at = t
+	}
+
+	history, err := GetSeatHistory(c.Param("id"), at)
+	if err == errSeatNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		seatLog(c.Request.Context(), c.Param("id"), "").Error("Failed to read seat history", shared.ErrAttr(err))
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to read seat history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// handleSearchAudit finds transitions across all seats by user, seat, action
+// and source, each a comma-separated list where a leading ! excludes, between
+// the RFC 3339 times from and to
+func handleSearchAudit(c *gin.Context) {
+	q, err := parseAuditQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+
+	search, err := SearchAudit(c.Request.Context(), q)
+	if err != nil {
+		shared.Logger(c.Request.Context()).Error("Failed to search audit log", shared.ErrAttr(err))
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to search audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, search)
+}
+
+// handleDebugTrace turns on (PUT) or off (DELETE) full message tracing on the
+// edges for one user or connection
+func handleDebugTrace(c *gin.Context) {
+	var req DebugTraceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	trace, err := SetDebugTrace(natsConn, req, c.Request.Method == http.MethodPut)
+	if err == errTracingUnavailable {
+		c.JSON(http.StatusServiceUnavailable, errorResponse(http.StatusServiceUnavailable, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, trace)
+}
+
+// handleDisconnectUser closes a user's connections on every edge; the
+// optional body {reason} is sent to their clients in the close frame
+func handleDisconnectUser(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+			return
+		}
+	}
+
+	kick, err := DisconnectUser(natsConn, c.Param("id"), req.Reason)
+	if err == errEdgesUnreachable {
+		c.JSON(http.StatusServiceUnavailable, errorResponse(http.StatusServiceUnavailable, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to disconnect user"})
+		return
+	}
+	c.JSON(http.StatusAccepted, kick)
+}
+
+// handleBanUser bans a user with the optional body {reason, duration},
+// duration being e.g. "24h" or left out for a permanent ban
+func handleBanUser(c *gin.Context) {
+	var req struct {
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+			return
+		}
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+			c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "duration must be a positive duration, e.g. 24h"})
+			return
+		}
+	}
+
+	report, err := BanUser(natsConn, c.Param("id"), req.Reason, duration)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to ban user"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func handleUnbanUser(c *gin.Context) {
+	err := UnbanUser(c.Param("id"))
+	if err == errNotBanned {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to lift ban"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Ban lifted"})
+}
+
+func handleListBans(c *gin.Context) {
+	bans, err := ListBans()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to list bans"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bans": bans})
+}
+
+// handleListHoldPenalties lists the users on cooldown for churning holds
+func handleListHoldPenalties(c *gin.Context) {
+	penalties, err := ListHoldPenalties()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to list hold penalties"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"penalties": penalties})
+}
+
+// handleGetHoldChurn reports a user's holds given up and penalty
+func handleGetHoldChurn(c *gin.Context) {
+	churn, err := GetHoldChurn(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to read hold churn"})
+		return
+	}
+	c.JSON(http.StatusOK, churn)
+}
+
+// handleClearHoldPenalty lifts a user's hold cooldown
+func handleClearHoldPenalty(c *gin.Context) {
+	err := ClearHoldPenalty(c.Param("id"))
+	if err == errNoHoldPenalty {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to clear hold penalty"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Hold penalty cleared"})
+}
+
+// handleDriftCheck reconciles Redis with Postgres now; ?repair=true also fixes
+// what it can
+func handleDriftCheck(c *gin.Context) {
+	report, err := RunDriftCheck(c.Query("repair") == "true")
+	if err == errBookingsDisabled {
+		c.JSON(http.StatusServiceUnavailable, errorResponse(http.StatusServiceUnavailable, err))
+		return
+	}
+	if err != nil {
+		shared.Logger(c.Request.Context()).Error("Drift check failed", shared.ErrAttr(err))
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Drift check failed"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func handleLastDriftReport(c *gin.Context) {
+	report, err := LastDriftReport()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to read drift report"})
+		return
+	}
+	if report == nil {
+		c.JSON(http.StatusNotFound, shared.ErrorResponse{Code: shared.ErrorCodeNotFound, Error: "no drift check has run"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func handleRetryFulfillment(c *gin.Context) {
+	order, err := RetryFulfillment(c.Param("id"))
+	if err == errOrderNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, errorResponse(http.StatusConflict, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+func handleVenueSnapshot(c *gin.Context) {
+	snapshot, err := GetVenueSnapshot()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get seats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// handleVenueDiff compares a posted snapshot (from) with the live venue (to)
+func handleVenueDiff(c *gin.Context) {
+	var snapshot shared.VenueSnapshot
+	if err := c.ShouldBindJSON(&snapshot); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	seats, err := GetAllSeats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get seats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, shared.DiffVenues(snapshot.Seats, seats, c.Query("state") == "true"))
+}
+
+// handleAdvanceClock moves the simulated clock on, with SIMULATED_CLOCK set
+func handleAdvanceClock(c *gin.Context) {
+	var req ClockAdvanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	now, err := AdvanceClock(req)
+	if err == errClockNotSimulated {
+		c.JSON(http.StatusConflict, errorResponse(http.StatusConflict, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"now": now})
+}
+
+func handleGetNotificationPrefs(c *gin.Context) {
+	prefs, err := GetNotificationPrefs(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to read notification preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// handleSetNotificationPrefs updates the posted preferences; those left out
+// keep their current values
+func handleSetNotificationPrefs(c *gin.Context) {
+	userID := c.Param("id")
+	prefs, err := GetNotificationPrefs(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to read notification preferences"})
+		return
+	}
+	if err := c.ShouldBindJSON(&prefs); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	prefs, err = SetNotificationPrefs(userID, prefs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// handleAnnounce sends a message to every connected client
+func handleAnnounce(c *gin.Context) {
+	var req struct {
+		Message   string `json:"message"`
+		Marketing bool   `json:"marketing"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	announcement, err := PublishAnnouncement(natsConn, req.Message, req.Marketing)
+	if err == errAnnouncementsUnavailable {
+		c.JSON(http.StatusServiceUnavailable, errorResponse(http.StatusServiceUnavailable, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, announcement)
+}
+
+// handleAllocateResaleBlock consigns seats to a resale partner
+func handleAllocateResaleBlock(c *gin.Context) {
+	var req struct {
+		PartnerID   string             `json:"partner_id"`
+		SeatIDs     []string           `json:"seat_ids"`
+		ReturnRules []ResaleReturnRule `json:"return_rules"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+
+	block, err := AllocateResaleBlock(req.PartnerID, req.SeatIDs, req.ReturnRules)
+	if errors.Is(err, errResaleSeatsTaken) {
+		c.JSON(http.StatusConflict, errorResponse(http.StatusConflict, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusCreated, block)
+}
+
+func handleListResaleBlocks(c *gin.Context) {
+	blocks, err := ListResaleBlocks(c.Query("partner_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to list resale blocks"})
+		return
+	}
+	c.JSON(http.StatusOK, blocks)
+}
+
+func handleGetResaleBlock(c *gin.Context) {
+	block, err := GetResaleBlock(c.Param("id"))
+	if err == errResaleBlockNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to get resale block"})
+		return
+	}
+	c.JSON(http.StatusOK, block)
+}
+
+// handleReturnResaleBlock puts a block's unsold seats back on sale now
+func handleReturnResaleBlock(c *gin.Context) {
+	report, err := ReturnResaleBlock(c.Param("id"), c.Query("dry_run") == "true")
+	if err == errResaleBlockNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to return resale block"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func handlePartnerReport(c *gin.Context) {
+	report, err := GetPartnerReport(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to build partner report"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// handleResaleSale records seats a partner sold from one of their blocks. The
+// partner is the one X-Partner-Key belongs to.
+func handleResaleSale(c *gin.Context) {
+	partnerID, err := resalePartner(c.GetHeader(HeaderPartnerKey))
+	if err == errPartnerKeysUnset {
+		c.JSON(http.StatusServiceUnavailable, errorResponse(http.StatusServiceUnavailable, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errorResponse(http.StatusUnauthorized, err))
+		return
+	}
+
+	var req struct {
+		PartnerID string   `json:"partner_id"` // optional, must be the key's partner
+		SeatIDs   []string `json:"seat_ids"`
+		UserID    string   `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+		return
+	}
+	if req.PartnerID != "" && req.PartnerID != partnerID {
+		c.JSON(http.StatusForbidden, shared.ErrorResponse{Code: shared.ErrorCodeForbidden, Error: "partner_id does not match the partner key"})
+		return
+	}
+
+	block, err := SellResaleSeats(c.Param("id"), partnerID, req.SeatIDs, req.UserID)
+	if err == errResaleBlockNotFound {
+		c.JSON(http.StatusNotFound, errorResponse(http.StatusNotFound, err))
+		return
+	}
+	if errors.Is(err, errSeatVersionStale) {
+		c.JSON(http.StatusConflict, errorResponse(http.StatusConflict, err))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, block)
+}
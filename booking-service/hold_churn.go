@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Cooldown of a user caught churning holds, unless HOLD_CHURN_COOLDOWN says otherwise
+const defaultHoldChurnCooldown = 15 * time.Minute
+
+var (
+	// Holds a user may give up, releasing them or letting them expire, per
+	// window before being put on cooldown; zero requests disables detection
+	holdChurnLimit rateLimit
+
+	// How long a user caught churning holds may not take new ones
+	holdChurnCooldown = defaultHoldChurnCooldown
+)
+
+// HoldPenalty is a cooldown put on a user who gave up too many holds, a way of
+// keeping seats off sale without buying them
+type HoldPenalty struct {
+	UserID       string `json:"user_id"`
+	HoldsGivenUp int64  `json:"holds_given_up"`
+	Since        int64  `json:"since"`
+	Until        int64  `json:"until"`
+}
+
+// HoldChurn is a user's standing: holds given up in the current window and any
+// penalty in effect
+type HoldChurn struct {
+	UserID       string       `json:"user_id"`
+	HoldsGivenUp int64        `json:"holds_given_up"`
+	Penalty      *HoldPenalty `json:"penalty,omitempty"`
+}
+
+// holdCooldownError refuses a hold to a user on cooldown
+type holdCooldownError struct {
+	until time.Time
+}
+
+func (e *holdCooldownError) Error() string {
+	wait := time.Until(e.until).Round(time.Second)
+	return fmt.Sprintf("too many holds given up, you can hold seats again in %s", max(wait, time.Second))
+}
+
+// holdChurnFromEnv reads HOLD_CHURN_LIMIT, as <holds>/<window>, and
+// HOLD_CHURN_COOLDOWN
+func holdChurnFromEnv() (rateLimit, time.Duration, error) {
+	var limit rateLimit
+	cooldown := defaultHoldChurnCooldown
+	if env := os.Getenv("HOLD_CHURN_LIMIT"); env != "" {
+		var err error
+		if limit, err = parseRateLimit(env); err != nil {
+			return rateLimit{}, 0, fmt.Errorf("HOLD_CHURN_LIMIT: %w", err)
+		}
+	}
+	if env := os.Getenv("HOLD_CHURN_COOLDOWN"); env != "" {
+		d, err := time.ParseDuration(env)
+		if err != nil || d <= 0 {
+			return rateLimit{}, 0, fmt.Errorf("HOLD_CHURN_COOLDOWN must be a positive duration, got %q", env)
+		}
+		cooldown = d
+	}
+	return limit, cooldown, nil
+}
+
+// countHoldGivenUpScript counts a hold given up in the user's window, starting
+// the window with the first, and swaps the count for the penalty at the limit.
+// Returns the count.
+var countHoldGivenUpScript = redis.NewScript(`
+local given_up = redis.call('INCR', KEYS[1])
+if given_up == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if given_up >= tonumber(ARGV[2]) then
+	redis.call('SET', KEYS[2], ARGV[3], 'PX', ARGV[4])
+	redis.call('DEL', KEYS[1])
+end
+return given_up
+`)
+
+// countHoldGivenUp records that userID released a hold or let it expire, and
+// puts them on cooldown once they reach holdChurnLimit within its window.
+// Failures are logged; they never fail the release.
+func countHoldGivenUp(userID string) {
+	if holdChurnLimit.requests == 0 || userID == "" {
+		return
+	}
+	now := time.Now()
+	until := now.Add(holdChurnCooldown)
+	penalty, _ := json.Marshal(HoldPenalty{
+		UserID:       userID,
+		HoldsGivenUp: int64(holdChurnLimit.requests),
+		Since:        now.Unix(),
+		Until:        until.Unix(),
+	})
+	keys := []string{fmt.Sprintf(shared.RedisKeyHoldChurn, userID), fmt.Sprintf(shared.RedisKeyHoldPenalty, userID)}
+	givenUp, err := countHoldGivenUpScript.Run(ctx, redisClient, keys,
+		holdChurnLimit.period.Milliseconds(), holdChurnLimit.requests, penalty, holdChurnCooldown.Milliseconds()).Int64()
+	if err != nil {
+		slog.Warn("Failed to count a hold given up", shared.LogKeyUserID, userID, shared.ErrAttr(err))
+		return
+	}
+	if givenUp >= int64(holdChurnLimit.requests) {
+		slog.Warn("User put on hold cooldown for churning holds", shared.LogKeyUserID, userID,
+			"holds_given_up", givenUp, "window", holdChurnLimit.period, "until", until)
+	}
+}
+
+// checkHoldCooldown fails with a *holdCooldownError while userID is on
+// cooldown. If Redis cannot tell, the hold goes ahead.
+func checkHoldCooldown(userID string) error {
+	if holdChurnLimit.requests == 0 {
+		return nil
+	}
+	penalty, err := loadHoldPenalty(userID)
+	if err != nil {
+		slog.Warn("Failed to check hold cooldown", shared.LogKeyUserID, userID, shared.ErrAttr(err))
+		return nil
+	}
+	if penalty == nil {
+		return nil
+	}
+	return &holdCooldownError{until: time.Unix(penalty.Until, 0)}
+}
+
+// loadHoldPenalty returns userID's penalty, or nil without one
+func loadHoldPenalty(userID string) (*HoldPenalty, error) {
+	penaltyJSON, err := redisClient.Get(ctx, fmt.Sprintf(shared.RedisKeyHoldPenalty, userID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var penalty HoldPenalty
+	if err := json.Unmarshal(penaltyJSON, &penalty); err != nil {
+		return nil, err
+	}
+	return &penalty, nil
+}
+
+// GetHoldChurn returns userID's holds given up in the current window and
+// their penalty, if any
+func GetHoldChurn(userID string) (*HoldChurn, error) {
+	givenUp, err := redisClient.Get(ctx, fmt.Sprintf(shared.RedisKeyHoldChurn, userID)).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	penalty, err := loadHoldPenalty(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &HoldChurn{UserID: userID, HoldsGivenUp: givenUp, Penalty: penalty}, nil
+}
+
+// ListHoldPenalties returns the penalties in effect
+func ListHoldPenalties() ([]HoldPenalty, error) {
+	penalties := []HoldPenalty{}
+	iter := redisClient.Scan(ctx, 0, fmt.Sprintf(shared.RedisKeyHoldPenalty, "*"), 1000).Iterator()
+	for iter.Next(ctx) {
+		penaltyJSON, err := redisClient.Get(ctx, iter.Val()).Bytes()
+		if err == redis.Nil {
+			continue // expired since the scan
+		}
+		if err != nil {
+			return nil, err
+		}
+		var penalty HoldPenalty
+		if err := json.Unmarshal(penaltyJSON, &penalty); err != nil {
+			return nil, err
+		}
+		penalties = append(penalties, penalty)
+	}
+	return penalties, iter.Err()
+}
+
+var errNoHoldPenalty = errors.New("user has no hold penalty")
+
+// ClearHoldPenalty lifts userID's cooldown and forgets the holds they gave up
+// in the current window
+func ClearHoldPenalty(userID string) error {
+	cleared, err := redisClient.Del(ctx, fmt.Sprintf(shared.RedisKeyHoldPenalty, userID), fmt.Sprintf(shared.RedisKeyHoldChurn, userID)).Result()
+	if err != nil {
+		return err
+	}
+	if cleared == 0 {
+		return errNoHoldPenalty
+	}
+	slog.Info("Hold penalty cleared", shared.LogKeyUserID, userID)
+	return nil
+}
+
+// retryAfterSeconds is a Retry-After header value for waiting until t
+func retryAfterSeconds(t time.Time) int {
+	return max(1, int(math.Ceil(time.Until(t).Seconds())))
+}
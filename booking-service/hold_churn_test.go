@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHoldChurnPutsUsersOnCooldown(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		holdChurnLimit, holdChurnCooldown = rateLimit{requests: 3, period: time.Minute}, 10*time.Minute
+		t.Cleanup(func() { holdChurnLimit, holdChurnCooldown = rateLimit{}, defaultHoldChurnCooldown })
+		bg := context.Background()
+
+		// Two holds released and one left to expire
+		for _, seatID := range []string{"A1", "A2"} {
+			if err := SelectSeat(bg, seatID, "mallory", 0); err != nil {
+				t.Fatalf("SelectSeat %s: %v", seatID, err)
+			}
+			if err := ReleaseSeat(bg, seatID, "mallory", 0); err != nil {
+				t.Fatalf("ReleaseSeat %s: %v", seatID, err)
+			}
+		}
+		if err := SelectSeat(bg, "A3", "mallory", 0); err != nil {
+			t.Fatalf("SelectSeat A3: %v", err)
+		}
+		held := loadSeat(t, "A3")
+		if err := autoReleaseSeat(seatStore, &held); err != nil {
+			t.Fatalf("autoReleaseSeat: %v", err)
+		}
+
+		var cooldown *holdCooldownError
+		if err := SelectSeat(bg, "A4", "mallory", 0); !errors.As(err, &cooldown) {
+			t.Fatalf("hold on cooldown = %v, want a holdCooldownError", err)
+		}
+		if err := SelectSeat(bg, "A4", "alice", 0); err != nil {
+			t.Errorf("another user's hold = %v", err)
+		}
+
+		router := adminRouter(t)
+		do := func(method, path, body string) *httptest.ResponseRecorder {
+			t.Helper()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+			return w
+		}
+		w := do(http.MethodPost, "/api/seats/select", `{"seat_id":"A5","user_id":"mallory"}`)
+		if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); w.Code != http.StatusTooManyRequests || retry < 590 || retry > 600 {
+			t.Errorf("select on cooldown = %d, Retry-After %q, want 429 after about 600s", w.Code, w.Header().Get("Retry-After"))
+		}
+
+		var list struct {
+			Penalties []HoldPenalty `json:"penalties"`
+		}
+		json.Unmarshal(do(http.MethodGet, "/api/admin/penalties", "").Body.Bytes(), &list)
+		if len(list.Penalties) != 1 || list.Penalties[0].UserID != "mallory" || list.Penalties[0].HoldsGivenUp != 3 {
+			t.Errorf("penalties = %+v, want mallory's", list.Penalties)
+		}
+		var churn HoldChurn
+		json.Unmarshal(do(http.MethodGet, "/api/admin/users/mallory/penalty", "").Body.Bytes(), &churn)
+		if churn.Penalty == nil || churn.HoldsGivenUp != 0 {
+			t.Errorf("mallory's churn = %+v, want a penalty and a fresh window", churn)
+		}
+
+		if w := do(http.MethodDelete, "/api/admin/users/mallory/penalty", ""); w.Code != http.StatusOK {
+			t.Fatalf("clearing the penalty = %d %s", w.Code, w.Body)
+		}
+		if w := do(http.MethodDelete, "/api/admin/users/mallory/penalty", ""); w.Code != http.StatusNotFound {
+			t.Errorf("clearing it again = %d, want 404", w.Code)
+		}
+		if err := SelectSeat(bg, "A5", "mallory", 0); err != nil {
+			t.Errorf("hold after the penalty was cleared = %v", err)
+		}
+	})
+}
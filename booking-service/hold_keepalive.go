@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"concert-booking/shared"
+)
+
+var (
+	errHoldKeepaliveOff = shared.NewError(shared.ErrorCodeKeepaliveOff, "holds for this event are not kept alive")
+	errHoldNotYours     = shared.NewError(shared.ErrorCodeNotHolder, "seat is not held by you")
+)
+
+// holdKeepalive returns how long a hold outlives its holder's last ping and
+// how long it may last in all, at demo speed. ok is false if the event's
+// holds last a fixed time, or its metadata cannot be read.
+func holdKeepalive() (idle, maxHold time.Duration, ok bool) {
+	event, err := GetEventInfo()
+	if err != nil {
+		slog.Warn("Failed to read event metadata, holding for the fixed duration", shared.ErrAttr(err))
+		return 0, 0, false
+	}
+	if event.HoldKeepalive == nil {
+		return 0, 0, false
+	}
+	return atDemoSpeed(time.Duration(event.HoldKeepalive.IdleSeconds) * time.Second),
+		atDemoSpeed(time.Duration(event.HoldKeepalive.MaxSeconds) * time.Second), true
+}
+
+// validateHoldKeepalive rejects windows that are empty or where the hard
+// maximum is shorter than one idle window
+func validateHoldKeepalive(keepalive *shared.HoldKeepalive) error {
+	if keepalive == nil {
+		return nil
+	}
+	if keepalive.IdleSeconds <= 0 || keepalive.MaxSeconds <= 0 {
+		return errors.New("hold_keepalive idle_seconds and max_seconds must be positive")
+	}
+	if keepalive.MaxSeconds < keepalive.IdleSeconds {
+		return fmt.Errorf("hold_keepalive max_seconds (%d) must be at least idle_seconds (%d)",
+			keepalive.MaxSeconds, keepalive.IdleSeconds)
+	}
+	return nil
+}
+
+// KeepHold is the holder's ping for a hold in keepalive mode: it pushes the
+// hold's expiry out to one idle window from now, but never past the event's
+// maximum from when the hold was taken. It returns the hold's expiry, in Unix
+// seconds. Pings closer together than a quarter of the idle window leave the
+// hold as it is, so a chatty client does not flood the venue with seat events.
+func KeepHold(ctx context.Context, seatID, userID string) (int64, error) {
+	idle, maxHold, ok := holdKeepalive()
+	if !ok {
+		return 0, errHoldKeepaliveOff
+	}
+	if err := checkNotBanned(userID); err != nil {
+		return 0, err
+	}
+	store := seatStoreFor(ctx)
+
+	seat, err := store.GetSeat(seatID)
+	if err != nil {
+		return 0, err
+	}
+	if seat.Status != shared.SeatHeld || seat.HeldBy != userID {
+		return 0, errHoldNotYours
+	}
+
+	now := clock.Now()
+	if seat.HeldAt == 0 {
+		// Held before keepalive was turned on: the maximum counts from the first ping
+		seat.HeldAt = now.Unix()
+	}
+	expiresAt := now.Add(idle)
+	if limit := time.Unix(seat.HeldAt, 0).Add(maxHold); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	if expiresAt.Sub(time.Unix(seat.ExpiresAt, 0)) < max(idle/4, time.Second) {
+		return seat.ExpiresAt, nil
+	}
+
+	// The lock decides who holds the seat, so it moves first
+	extended, err := store.ExtendLock(seatID, userID, expiresAt.Sub(now))
+	if err != nil {
+		return 0, err
+	}
+	if !extended {
+		// The seat still names the user, so their lock ran out
+		return 0, errHoldExpired
+	}
+
+	seat.ExpiresAt = expiresAt.Unix()
+	if err := casUpdateSeat(store, seat, seat.Version, "renewed", userID, sourceOf(ctx)); err != nil {
+		return 0, err
+	}
+
+	if err := store.IndexHold(seatID, expiresAt); err != nil {
+		seatLog(ctx, seatID, userID).Warn("Failed to index hold expiry", shared.ErrAttr(err))
+	}
+	scheduleHoldExpiry(seatID, expiresAt)
+
+	seatLog(ctx, seatID, userID).Debug("Hold kept alive", "expires_at", seat.ExpiresAt)
+	return seat.ExpiresAt, nil
+}
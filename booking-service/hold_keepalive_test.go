@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// keepHoldsAlive turns on keepalive mode for the test's event
+func keepHoldsAlive(t *testing.T, idleSeconds, maxSeconds int64) {
+	t.Helper()
+
+	_, err := UpdateEventInfo(shared.EventInfo{Currency: "USD", Timezone: "UTC",
+		HoldKeepalive: &shared.HoldKeepalive{IdleSeconds: idleSeconds, MaxSeconds: maxSeconds}})
+	if err != nil {
+		t.Fatalf("UpdateEventInfo: %v", err)
+	}
+}
+
+// ageHold rewrites a hold as if it was taken heldFor ago and expires in expiresIn
+func ageHold(t *testing.T, seatID string, heldFor, expiresIn time.Duration) {
+	t.Helper()
+
+	seat := loadSeat(t, seatID)
+	seat.HeldAt = time.Now().Add(-heldFor).Unix()
+	seat.ExpiresAt = time.Now().Add(expiresIn).Unix()
+	if err := casUpdateSeat(seatStore, &seat, seat.Version, "held", seat.HeldBy, sourceAdmin); err != nil {
+		t.Fatalf("age hold: %v", err)
+	}
+}
+
+func TestExtendLockOnlyForTheHolder(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		if ok, _ := seatStore.AcquireLock("A1", "holder", 50*time.Millisecond); !ok {
+			t.Fatal("AcquireLock failed")
+		}
+		if ok, err := seatStore.ExtendLock("A1", "other", time.Minute); ok || err != nil {
+			t.Fatalf("ExtendLock by another user = %v, %v, want refused", ok, err)
+		}
+		if ok, err := seatStore.ExtendLock("A1", "holder", time.Minute); !ok || err != nil {
+			t.Fatalf("ExtendLock by the holder = %v, %v, want extended", ok, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if holder, _ := seatStore.LockHolder("A1"); holder != "holder" {
+			t.Fatalf("LockHolder after the first TTL = %q, want holder", holder)
+		}
+		if ok, _ := seatStore.ExtendLock("A2", "holder", time.Minute); ok {
+			t.Fatal("ExtendLock of an unlocked seat succeeded")
+		}
+	})
+}
+
+func TestKeepHoldExtendsUpToTheMaximum(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		keepHoldsAlive(t, 60, 90)
+		seatID := shared.GetSeatID(0, 0)
+
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		seat := loadSeat(t, seatID)
+		if seat.HeldAt == 0 || seat.ExpiresAt-seat.HeldAt != 60 {
+			t.Fatalf("hold = held at %d until %d, want one 60s idle window", seat.HeldAt, seat.ExpiresAt)
+		}
+
+		// A ping right after the hold was taken changes nothing
+		if expiresAt, err := KeepHold(ctx, seatID, "holder"); err != nil || expiresAt != seat.ExpiresAt {
+			t.Fatalf("KeepHold = %d, %v, want the unchanged expiry %d", expiresAt, err, seat.ExpiresAt)
+		}
+		if got := loadSeat(t, seatID); got.Version != seat.Version {
+			t.Fatalf("seat version = %d, want %d (no write for an early ping)", got.Version, seat.Version)
+		}
+
+		ageHold(t, seatID, 20*time.Second, 10*time.Second)
+		expiresAt, err := KeepHold(ctx, seatID, "holder")
+		if want := time.Now().Add(60 * time.Second).Unix(); err != nil || expiresAt < want-1 || expiresAt > want {
+			t.Fatalf("KeepHold = %d, %v, want one idle window from now (%d)", expiresAt, err, want)
+		}
+		if got := loadSeat(t, seatID); got.ExpiresAt != expiresAt {
+			t.Fatalf("stored expiry = %d, want %d", got.ExpiresAt, expiresAt)
+		}
+		if topics := queuedTopics(t); !strings.HasSuffix(topics[len(topics)-1], ".renewed") {
+			t.Fatalf("last event topic = %q, want a renewed event", topics[len(topics)-1])
+		}
+
+		// Near the maximum the hold only lasts until it
+		ageHold(t, seatID, 60*time.Second, 5*time.Second)
+		held := loadSeat(t, seatID)
+		if expiresAt, err := KeepHold(ctx, seatID, "holder"); err != nil || expiresAt != held.HeldAt+90 {
+			t.Fatalf("KeepHold = %d, %v, want the maximum %d", expiresAt, err, held.HeldAt+90)
+		}
+		if expiresAt, ok, _ := seatStore.HoldExpiry(seatID); !ok || expiresAt.Unix() != held.HeldAt+90 {
+			t.Fatalf("indexed expiry = %v, %v, want the maximum", expiresAt, ok)
+		}
+	})
+}
+
+func TestKeepHoldRefusals(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		if _, err := KeepHold(ctx, seatID, "holder"); err != errHoldKeepaliveOff {
+			t.Fatalf("KeepHold without keepalive = %v, want %v", err, errHoldKeepaliveOff)
+		}
+
+		keepHoldsAlive(t, 60, 600)
+		if _, err := KeepHold(ctx, seatID, "other"); err != errHoldNotYours {
+			t.Fatalf("KeepHold by another user = %v, want %v", err, errHoldNotYours)
+		}
+		if _, err := KeepHold(ctx, shared.GetSeatID(0, 1), "holder"); err != errHoldNotYours {
+			t.Fatalf("KeepHold of an available seat = %v, want %v", err, errHoldNotYours)
+		}
+		if err := ReleaseSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("ReleaseSeat: %v", err)
+		}
+		if seat := loadSeat(t, seatID); seat.HeldAt != 0 {
+			t.Fatalf("released seat held_at = %d, want 0", seat.HeldAt)
+		}
+		if _, err := KeepHold(ctx, seatID, "holder"); err != errHoldNotYours {
+			t.Fatalf("KeepHold of a released hold = %v, want %v", err, errHoldNotYours)
+		}
+	})
+}
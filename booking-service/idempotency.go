@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"concert-booking/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// Longest Idempotency-Key accepted; clients are expected to send UUIDs
+const maxIdempotencyKeyLength = 255
+
+var (
+	errIdempotencyMismatch = shared.NewError(shared.ErrorCodeIdempotencyReused, "Idempotency-Key was already used for a different request")
+	errIdempotencyPending  = shared.NewError(shared.ErrorCodeIdempotencyPending, "request with this Idempotency-Key is still in progress")
+	errIdempotencyRetrying = shared.NewError(shared.ErrorCodeIdempotencyPending, "request with this Idempotency-Key is being retried, try again")
+)
+
+// idempotencyRecord is what Redis stores under an Idempotency-Key. A record with
+// Status 0 marks a request that is still being processed.
+type idempotencyRecord struct {
+	Fingerprint string          `json:"fingerprint"`
+	Status      int             `json:"status"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// idempotencyWriter captures the response body so it can be stored for replay
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// idempotent makes a mutation safe to retry. When the request carries an
+// Idempotency-Key, the first response is recorded in Redis and any retry with
+// the same key and body gets that response back instead of being applied again.
+func idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(shared.HeaderIdempotencyKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Invalid request"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(c.Request.Method, c.FullPath(), body)
+		status, response, replayed, err := runIdempotent(key, fingerprint, func() (int, []byte) {
+			writer := &idempotencyWriter{ResponseWriter: c.Writer}
+			c.Writer = writer
+			c.Next()
+			return writer.Status(), writer.body.Bytes()
+		})
+		switch {
+		case err == errIdempotencyMismatch:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, errorResponse(http.StatusUnprocessableEntity, err))
+		case err == errIdempotencyPending || err == errIdempotencyRetrying:
+			c.AbortWithStatusJSON(http.StatusConflict, errorResponse(http.StatusConflict, err))
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, shared.ErrorResponse{Code: shared.ErrorCodeInternal, Error: "Failed to read idempotency record"})
+		case replayed:
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(status, "application/json; charset=utf-8", response)
+			c.Abort()
+		}
+	}
+}
+
+// runIdempotent runs fn, which returns a status and response body, at most once
+// per Idempotency-Key. A retry with the same fingerprint gets the recorded status
+// and body back with replayed set. If Redis is unavailable fn simply runs.
+func runIdempotent(key, fingerprint string, fn func() (int, []byte)) (status int, body []byte, replayed bool, err error) {
+	redisKey := fmt.Sprintf(shared.RedisKeyIdempotency, key)
+
+	// Reserve the key; only the request that wins the reservation runs
+	pending, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+	reserved, err := redisClient.SetNX(ctx, redisKey, pending, shared.IdempotencyKeyTTL).Result()
+	if err != nil {
+		slog.Warn("Idempotency store unavailable, processing request without it", shared.ErrAttr(err))
+		status, body = fn()
+		return status, body, false, nil
+	}
+	if !reserved {
+		record, err := loadIdempotencyRecord(redisKey, fingerprint)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		return record.Status, record.Body, true, nil
+	}
+
+	status, body = fn()
+
+	// Server errors are not final; let the client retry them for real
+	if status >= http.StatusInternalServerError {
+		redisClient.Del(ctx, redisKey)
+		return status, body, false, nil
+	}
+
+	record, err := json.Marshal(idempotencyRecord{
+		Fingerprint: fingerprint,
+		Status:      status,
+		Body:        body,
+	})
+	if err == nil {
+		err = redisClient.Set(ctx, redisKey, record, shared.IdempotencyKeyTTL).Err()
+	}
+	if err != nil {
+		slog.Warn("Failed to record response for Idempotency-Key", "idempotency_key", key, shared.ErrAttr(err))
+		redisClient.Del(ctx, redisKey)
+	}
+	return status, body, false, nil
+}
+
+// loadIdempotencyRecord reads the finished record a retry should be answered from
+func loadIdempotencyRecord(redisKey, fingerprint string) (*idempotencyRecord, error) {
+	recordJSON, err := redisClient.Get(ctx, redisKey).Result()
+	if err == redis.Nil {
+		// Expired or cleared between SETNX and GET
+		return nil, errIdempotencyRetrying
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(recordJSON), &record); err != nil {
+		return nil, err
+	}
+
+	if record.Fingerprint != fingerprint {
+		return nil, errIdempotencyMismatch
+	}
+	if record.Status == 0 {
+		return nil, errIdempotencyPending
+	}
+	return &record, nil
+}
+
+// requestFingerprint identifies a request so a key reused for a different one is rejected
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestLogging tags each request with the correlation ID the edge sent in
+// X-Request-ID, or a new one, echoes it in the response and logs the request
+// once it completes
+func requestLogging() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(shared.HeaderCorrelationID)
+		if id == "" {
+			id = shared.NewCorrelationID()
+		}
+		c.Request = c.Request.WithContext(shared.WithCorrelationID(c.Request.Context(), id))
+		c.Header(shared.HeaderCorrelationID, id)
+
+		start := time.Now()
+		c.Next()
+
+		shared.Logger(c.Request.Context()).Info("Request handled",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+		)
+	}
+}
+
+// seatLog returns ctx's logger tagged with the seat and, when known, the user
+// an operation is for
+func seatLog(ctx context.Context, seatID, userID string) *slog.Logger {
+	logger := shared.Logger(ctx).With(shared.LogKeySeatID, seatID)
+	if userID != "" {
+		logger = logger.With(shared.LogKeyUserID, userID)
+	}
+	return logger
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"concert-booking/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+)
+
+func TestRequestsLogTheEdgeCorrelationID(t *testing.T) {
+	newTestRedis(t)
+	gin.SetMode(gin.TestMode)
+
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	seatID := shared.GetSeatID(0, 2)
+	req := httptest.NewRequest(http.MethodPost, "/api/seats/select",
+		bytes.NewBufferString(`{"seat_id":"`+seatID+`","user_id":"user-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(shared.HeaderCorrelationID, "corr-1")
+
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("select returned %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get(shared.HeaderCorrelationID); got != "corr-1" {
+		t.Fatalf("%s = %q, want the request's", shared.HeaderCorrelationID, got)
+	}
+
+	selected := false
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var record map[string]interface{}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] == "Seat selected" {
+			selected = true
+			if record[shared.LogKeyCorrelationID] != "corr-1" || record[shared.LogKeySeatID] != seatID || record[shared.LogKeyUserID] != "user-1" {
+				t.Fatalf("selection logged as %v", record)
+			}
+		}
+	}
+	if !selected {
+		t.Fatalf("selection not logged:\n%s", logs.String())
+	}
+}
+
+func TestRequestsWithoutCorrelationIDGetOne(t *testing.T) {
+	newTestRedis(t)
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats", nil))
+	if w.Header().Get(shared.HeaderCorrelationID) == "" {
+		t.Fatalf("no %s in the response", shared.HeaderCorrelationID)
+	}
+}
+
+func TestSeatCommandsKeepTheEdgeCorrelationID(t *testing.T) {
+	msg := nats.NewMsg(shared.NATSSubjectCmdSelect)
+	msg.Header.Set(shared.HeaderCorrelationID, "corr-2")
+
+	ctx, span := startCommandSpan(msg)
+	defer span.End()
+	if got := shared.CorrelationID(ctx); got != "corr-2" {
+		t.Fatalf("correlation ID = %q, want corr-2", got)
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"concert-booking/shared"
 
@@ -22,57 +24,239 @@ var (
 )
 
 func main() {
-	log.Println("Starting booking service...")
+	if err := shared.InitLogging("booking-service"); err != nil {
+		shared.Fatal("Failed to set up logging", shared.ErrAttr(err))
+	}
+	if err := shared.InitInvariantChecks("booking-service"); err != nil {
+		shared.Fatal("Invalid invariant checks", shared.ErrAttr(err))
+	}
+	if err := shared.InitFaults(); err != nil {
+		shared.Fatal("Invalid fault injection", shared.ErrAttr(err))
+	}
+	slog.Info("Starting booking service...")
+	venueReady := readiness.Gate("venue")
+	subscribed := readiness.Gate("subscriptions")
+
+	// Continue traces started by the edges when an OTLP endpoint is set
+	shutdownTracing, err := shared.InitTracing("booking-service")
+	if err != nil {
+		shared.Fatal("Failed to set up tracing", shared.ErrAttr(err))
+	}
+
+	// Shorten holds for presentations when DEMO_SPEED is set
+	if demoSpeed, err = demoSpeedFromEnv(); err != nil {
+		shared.Fatal("Invalid demo speed", shared.ErrAttr(err))
+	}
+	if demoSpeed > 1 {
+		slog.Warn("Demo speed enabled: holds and their timers run faster than real time",
+			"speed", demoSpeed, "hold", holdDuration())
+	}
+
+	// Run holds on a clock moved through the admin API when SIMULATED_CLOCK is set
+	if simulatingClock, err = simulatedClockFromEnv(); err != nil {
+		shared.Fatal("Invalid simulated clock", shared.ErrAttr(err))
+	}
+	if simulatingClock {
+		clock = NewFakeClock(time.Now())
+		slog.Warn("Simulated clock enabled: holds expire only as POST /api/admin/clock/advance moves time on")
+	}
+
+	// Limit direct callers of the seat API when RATE_LIMIT_PER_* is set
+	if err := rateLimitsFromEnv(); err != nil {
+		shared.Fatal("Invalid rate limit", shared.ErrAttr(err))
+	}
+
+	// Put users who keep giving up holds on cooldown when HOLD_CHURN_LIMIT is set
+	if holdChurnLimit, holdChurnCooldown, err = holdChurnFromEnv(); err != nil {
+		shared.Fatal("Invalid hold churn detection", shared.ErrAttr(err))
+	}
 
 	// Connect to Redis
 	if err := connectRedis(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		shared.Fatal("Failed to connect to Redis", shared.ErrAttr(err))
 	}
 	defer redisClient.Close()
-	log.Println("Connected to Redis")
+	slog.Info("Connected to Redis")
+	seatStore = NewRedisSeatStore(redisClient)
 
-	// Connect to NATS
-	if err := connectNATS(); err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+	// Connect to NATS, which the Redis event bus does without
+	if shared.EventBusKind() != shared.EventBusRedis {
+		if err := connectNATS(); err != nil {
+			shared.Fatal("Failed to connect to NATS", shared.ErrAttr(err))
+		}
+		defer natsConn.Close()
+		slog.Info("Connected to NATS")
 	}
-	defer natsConn.Close()
-	log.Println("Connected to NATS")
+
+	// Seat events go over NATS unless EVENT_BUS selects another bus
+	eventBus, err := shared.OpenEventBus(natsConn, "booking-service")
+	if err != nil {
+		shared.Fatal("Failed to open event bus", shared.ErrAttr(err))
+	}
+	defer eventBus.Close()
+	// Lose seat events at the CHAOS_DROP_PUBLISH chance, for edges to reconcile
+	eventBus = shared.WithFaults(eventBus)
 
 	// Initialize venue with 100 seats
 	if err := initializeVenue(); err != nil {
-		log.Fatalf("Failed to initialize venue: %v", err)
+		shared.Fatal("Failed to initialize venue", shared.ErrAttr(err))
+	}
+	slog.Info("Venue initialized")
+	venueReady()
+
+	if err := initEventInfo(); err != nil {
+		shared.Fatal("Failed to store event metadata", shared.ErrAttr(err))
+	}
+
+	// Push event metadata and feature flags to edges through NATS KV
+	if natsConn != nil {
+		startConfigPublishing(natsConn)
+	}
+
+	// Keep confirmed bookings in Postgres when configured, and restore any
+	// that Redis lost
+	if databaseURL := os.Getenv("DATABASE_URL"); databaseURL != "" {
+		bookingStore, err = NewPostgresBookingStore(databaseURL)
+		if err != nil {
+			shared.Fatal("Failed to connect to Postgres", shared.ErrAttr(err))
+		}
+		slog.Info("Connected to Postgres")
+
+		restored, err := reconcileBookings(seatStore, bookingStore)
+		if err != nil {
+			shared.Fatal("Failed to reconcile bookings", shared.ErrAttr(err))
+		}
+		if restored > 0 {
+			slog.Warn("Re-marked persisted bookings that Redis had lost", "count", restored)
+		}
+
+		// Catch drift that builds up while running, e.g. from failed Postgres writes
+		hour, minute, enabled, repair, err := driftScheduleFromEnv()
+		if err != nil {
+			shared.Fatal("Invalid drift check schedule", shared.ErrAttr(err))
+		}
+		if enabled {
+			StartDriftChecks(hour, minute, repair)
+		}
 	}
-	log.Println("Venue initialized with", shared.TotalSeats, "seats")
 
 	// Setup Gin router
 	router := setupRoutes()
 
+	// Start relaying seat events from the outbox to the event bus
+	if err := StartOutboxRelay(redisClient, eventBus); err != nil {
+		shared.Fatal("Failed to start outbox relay", shared.ErrAttr(err))
+	}
+
+	if natsConn != nil {
+		// Answer seat commands from edges over NATS request-reply
+		if err := StartCommandHandlers(natsConn); err != nil {
+			shared.Fatal("Failed to start seat command handlers", shared.ErrAttr(err))
+		}
+
+		// Track which edges host each user for directed messages
+		if err := StartUserRouter(natsConn); err != nil {
+			shared.Fatal("Failed to start user router", shared.ErrAttr(err))
+		}
+	} else {
+		slog.Warn("Running without NATS: seat commands and messages to individual users are disabled")
+	}
+	subscribed()
+
 	// Start timer service for auto-releasing held seats
-	StartTimerService(redisClient, natsConn)
-	log.Println("Timer service started")
+	StartTimerService(seatStore)
+	slog.Info("Timer service started")
 
-	// Handle graceful shutdown
+	// Optionally release holds as soon as their lock expires
+	if err := StartExpiryListener(redisClient); err != nil {
+		shared.Fatal("Failed to start expiry listener", shared.ErrAttr(err))
+	}
+
+	// Start delivering seat events to registered webhooks
+	if err := StartWebhookDispatcher(eventBus); err != nil {
+		shared.Fatal("Failed to start webhook dispatcher", shared.ErrAttr(err))
+	}
+
+	// Stream seat events to server-side integrators over gRPC
+	if addr := os.Getenv("GRPC_ADDR"); addr != "" {
+		if err := StartSeatFeed(eventBus, addr); err != nil {
+			shared.Fatal("Failed to start seat feed", shared.ErrAttr(err))
+		}
+	}
+
+	// Run post-booking fulfillment for new orders
+	StartFulfillmentWorker()
+
+	// Refund the orders of a cancelled event, a batch at a time
+	refundBatchSize, refundBatchInterval, err := refundBatchFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid refund batching", shared.ErrAttr(err))
+	}
+	if refundProvider = refundProviderFromEnv(); refundProvider == nil {
+		slog.Info("REFUND_URL is not set: refunds of a cancelled event are recorded without reaching a payment provider")
+	}
+	StartRefundWorker(refundBatchSize, refundBatchInterval)
+
+	// Put unsold resale seats back on sale as their blocks' return rules fall due
+	StartResaleReturns()
+
+	// Handle graceful shutdown: leave the load balancer's rotation, then
+	// finish in-flight requests and publishes
+	drain, err := shared.ShutdownDrainFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid shutdown drain", shared.ErrAttr(err))
+	}
+	addr := shared.BookingServicePort
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	server := &http.Server{Addr: addr, Handler: router}
+	stopped := make(chan struct{})
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
-		log.Println("Shutting down booking service...")
-		os.Exit(0)
+		slog.Info("Shutting down booking service...", "drain", drain)
+		readiness.Drain()
+		time.Sleep(drain)
+
+		shutdownCtx, cancel := context.WithTimeout(ctx, shared.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("HTTP requests still in flight at shutdown", shared.ErrAttr(err))
+		}
+		if seatFeedServer != nil {
+			// Watchers reconnect to another instance
+			seatFeedServer.Stop()
+		}
+		if natsConn != nil {
+			if err := drainNATS(shutdownCtx); err != nil {
+				slog.Warn("Failed to drain NATS", shared.ErrAttr(err))
+			}
+		}
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Warn("Failed to flush traces", shared.ErrAttr(err))
+		}
+		close(stopped)
 	}()
 
 	// Start server
-	log.Printf("Booking service started on %s\n", shared.BookingServicePort)
-	if err := router.Run(shared.BookingServicePort); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	slog.Info("Booking service started", "addr", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		shared.Fatal("Failed to start server", shared.ErrAttr(err))
 	}
+	<-stopped
+	slog.Info("Booking service stopped")
 }
 
 func connectRedis() error {
 	redisClient = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
+		Addr:     shared.RedisAddr(),
 		Password: "",
 		DB:       0,
 	})
+	redisClient.AddHook(redisTracingHook{})
+	redisClient.AddHook(shared.RedisFaultHook{})
 
 	// Test connection
 	_, err := redisClient.Ping(ctx).Result()
@@ -81,10 +265,29 @@ func connectRedis() error {
 
 func connectNATS() error {
 	var err error
-	natsConn, err = nats.Connect(nats.DefaultURL)
+	natsConn, err = nats.Connect(shared.NATSURL())
 	return err
 }
 
+// drainNATS answers the seat commands already received, flushes pending
+// publishes and closes the connection, waiting until ctx is done at most.
+// Outbox events the relay could not publish stay queued for the next instance.
+func drainNATS(ctx context.Context) error {
+	if err := natsConn.Drain(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for !natsConn.IsClosed() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 func initializeVenue() error {
 	// Check if venue already initialized
 	exists, err := redisClient.Exists(ctx, shared.RedisKeyVenueSeats).Result()
@@ -93,52 +296,121 @@ func initializeVenue() error {
 	}
 
 	if exists > 0 {
-		log.Println("Venue already initialized, skipping...")
+		slog.Info("Venue already initialized, skipping...")
 		return nil
 	}
 
-	// Create all seats
-	for row := 0; row < shared.VenueRows; row++ {
-		for col := 0; col < shared.VenueCols; col++ {
-			seatID := shared.GetSeatID(row, col)
-			seat := shared.Seat{
-				ID:     seatID,
-				Row:    row,
-				Col:    col,
-				Status: shared.SeatAvailable,
-			}
+	// Generate the layout from the configured template
+	templateName, params, err := venueTemplateFromEnv()
+	if err != nil {
+		return err
+	}
 
-			seatJSON, err := json.Marshal(seat)
-			if err != nil {
-				return err
-			}
+	seats, err := GenerateVenue(templateName, params)
+	if err != nil {
+		return err
+	}
 
-			if err := redisClient.HSet(ctx, shared.RedisKeyVenueSeats, seatID, seatJSON).Err(); err != nil {
-				return err
-			}
+	seatFields := make(map[string]interface{}, len(seats))
+	for _, seat := range seats {
+		seatJSON, err := json.Marshal(seat)
+		if err != nil {
+			return err
 		}
+		seatFields[seat.ID] = seatJSON
 	}
 
-	log.Printf("Initialized %d seats (A1 to J10)\n", shared.TotalSeats)
+	if err := redisClient.HSet(ctx, shared.RedisKeyVenueSeats, seatFields).Err(); err != nil {
+		return err
+	}
+
+	slog.Info("Initialized seats", "count", len(seats), "template", templateName)
 	return nil
 }
 
 func setupRoutes() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), tracing(), requestLogging())
 
-	// API routes
-	api := router.Group("/api")
-	{
-		api.GET("/seats", handleGetSeats)
-		api.POST("/seats/select", handleSelectSeat)
-		api.POST("/seats/book", handleBookSeat)
-		api.POST("/seats/release", handleReleaseSeat)
-	}
+	// The API is served under /api/v1, and under /api as before versioning
+	// for clients that have not moved yet
+	apiRoutes(router.Group(shared.APIPrefix))
+	apiRoutes(router.Group(shared.APIPrefixLegacy, deprecatedAPI()))
+	router.GET("/api/openapi.json", handleOpenAPI)
 
 	// Health check
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
-	})
+	router.GET("/health", handleLive)
+	router.GET("/live", handleLive)
+	router.GET("/ready", handleReady)
+
+	// Public status page (unauthenticated, cacheable)
+	router.GET("/status", handleStatus)
 
 	return router
-}
\ No newline at end of file
+}
+
+// apiRoutes registers the API's routes on api, the group of one API prefix
+func apiRoutes(api *gin.RouterGroup) {
+	// Bodies are checked against openapi.json before any route reads them
+	api.Use(validRequest())
+
+	// Seat routes are rate limited, apart from the edges' requests
+	seats := api.Group("/seats", rateLimited())
+	seats.GET("", handleGetSeats)
+	seats.GET("/summary", handleSeatSummary)
+	seats.GET("/changes", handleGetSeatChanges)
+	seats.GET("/:id", handleGetSeat)
+	seats.POST("/batch-get", handleBatchGetSeats)
+	seats.POST("/select", idempotent(), handleSelectSeat)
+	seats.POST("/book", idempotent(), handleBookSeat)
+	seats.POST("/release", idempotent(), handleReleaseSeat)
+	seats.POST("/keepalive", handleKeepHold)
+	api.GET("/venue/templates", handleListVenueTemplates)
+	api.GET("/event", handleGetEvent)
+	api.GET("/orders/:id", handleGetOrder)
+	api.GET("/orders/:id/receipt", handleGetReceipt)
+	api.POST("/payments/callback", handlePaymentCallback)
+	api.GET("/bookings", handleListBookings)
+	api.GET("/users/:id/notifications", sameUser(), handleGetNotificationPrefs)
+	api.PUT("/users/:id/notifications", sameUser(), handleSetNotificationPrefs)
+	api.POST("/resale/blocks/:id/sales", handleResaleSale)
+
+	// Admin routes, for admin session tokens only
+	admin := api.Group("/admin", adminOnly())
+	admin.GET("/memory", handleMemoryReport)
+	admin.POST("/venue/reset", handleResetVenue)
+	admin.PUT("/event", handleUpdateEvent)
+	admin.POST("/event/cancel", handleCancelEvent)
+	admin.GET("/event/cancellation", handleGetCancellation)
+	admin.POST("/event/cancellation/retry", handleRetryRefunds)
+	admin.GET("/flags", handleListFlags)
+	admin.PUT("/flags/:name", handleSetFlag)
+	admin.DELETE("/flags/:name", handleSetFlag)
+	admin.POST("/seats/bulk", handleBulkUpdateSeats)
+	admin.GET("/seats/:id/history", handleSeatHistory)
+	admin.GET("/audit", handleSearchAudit)
+	admin.PUT("/debug/trace", handleDebugTrace)
+	admin.DELETE("/debug/trace", handleDebugTrace)
+	admin.POST("/users/:id/disconnect", handleDisconnectUser)
+	admin.GET("/users/:id/penalty", handleGetHoldChurn)
+	admin.DELETE("/users/:id/penalty", handleClearHoldPenalty)
+	admin.GET("/penalties", handleListHoldPenalties)
+	admin.POST("/users/:id/ban", handleBanUser)
+	admin.DELETE("/users/:id/ban", handleUnbanUser)
+	admin.GET("/bans", handleListBans)
+	admin.POST("/announcements", handleAnnounce)
+	admin.POST("/webhooks", handleCreateWebhook)
+	admin.GET("/webhooks", handleListWebhooks)
+	admin.DELETE("/webhooks/:id", handleDeleteWebhook)
+	admin.POST("/resale/blocks", handleAllocateResaleBlock)
+	admin.GET("/resale/blocks", handleListResaleBlocks)
+	admin.GET("/resale/blocks/:id", handleGetResaleBlock)
+	admin.POST("/resale/blocks/:id/return", handleReturnResaleBlock)
+	admin.GET("/resale/partners/:id/report", handlePartnerReport)
+	admin.POST("/drift/check", handleDriftCheck)
+	admin.GET("/drift", handleLastDriftReport)
+	admin.POST("/orders/:id/retry", handleRetryFulfillment)
+	admin.GET("/venue/snapshot", handleVenueSnapshot)
+	admin.POST("/venue/diff", handleVenueDiff)
+	admin.POST("/clock/advance", handleAdvanceClock)
+}
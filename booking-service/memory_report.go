@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Keys sampled with MEMORY USAGE when estimating a pattern of many keys
+const memorySampleKeys = 50
+
+// Nested values MEMORY USAGE samples inside large hashes and sorted sets
+const memorySampleNested = 100
+
+// MemoryComponent is the approximate Redis memory held by one kind of venue data
+type MemoryComponent struct {
+	Name    string `json:"name"`
+	Keys    int64  `json:"keys"`
+	Bytes   int64  `json:"bytes"`
+	Sampled bool   `json:"sampled"` // true when Bytes is extrapolated from a sample of keys
+}
+
+// EventMemory is the approximate Redis memory held by one event's data
+type EventMemory struct {
+	TotalBytes int64             `json:"total_bytes"`
+	Components []MemoryComponent `json:"components"`
+}
+
+// MemoryReport is the approximate Redis memory consumed by each event, and by
+// the data the service keeps for all of them
+type MemoryReport struct {
+	TotalBytes int64                   `json:"total_bytes"`
+	Events     map[string]*EventMemory `json:"events"`
+	Shared     []MemoryComponent       `json:"shared"`
+}
+
+// GetMemoryReport estimates Redis memory per event and component using
+// MEMORY USAGE sampling
+func GetMemoryReport() (*MemoryReport, error) {
+	return measureMemory(memorySampleNested)
+}
+
+// measureMemory builds the memory report, having MEMORY USAGE sample nested
+// values of large keys; 0 leaves that to Redis' default
+func measureMemory(nested int) (*MemoryReport, error) {
+	// Every seat, hold and log is the default event's until the service
+	// keeps more than one
+	event, err := measureComponents(nested, []memoryKeys{
+		{"event_info", fmt.Sprintf(shared.RedisKeyEventInfo, shared.DefaultEventID)},
+		{"seats", shared.RedisKeyVenueSeats},
+		{"hold_expiry_index", shared.RedisKeyHoldExpiry},
+		{"locks", shared.RedisKeySeatLock},
+		{"seat_history", shared.RedisKeySeatHistory},
+		{"orders", shared.RedisKeyOrders},
+		{"fulfillment_queue", shared.RedisKeyFulfillment},
+		{"audit_log", shared.RedisKeyAuditLog},
+		{"audit_user_index", shared.RedisKeyAuditUser},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sharedData, err := measureComponents(nested, []memoryKeys{
+		{"webhooks", shared.RedisKeyWebhooks},
+		{"webhook_events", shared.RedisKeyWebhookEvents},
+		{"outbox", shared.RedisKeyOutbox},
+		{"idempotency_keys", shared.RedisKeyIdempotency},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MemoryReport{
+		Events: map[string]*EventMemory{shared.DefaultEventID: {Components: event}},
+		Shared: sharedData,
+	}
+	for _, e := range report.Events {
+		for _, c := range e.Components {
+			e.TotalBytes += c.Bytes
+		}
+		report.TotalBytes += e.TotalBytes
+	}
+	for _, c := range report.Shared {
+		report.TotalBytes += c.Bytes
+	}
+	return report, nil
+}
+
+// memoryKeys names the Redis data of one component: a key, or a key format
+// with one %s, every key of which is measured
+type memoryKeys struct {
+	name string
+	key  string
+}
+
+func measureComponents(nested int, keys []memoryKeys) ([]MemoryComponent, error) {
+	components := make([]MemoryComponent, 0, len(keys))
+	for _, k := range keys {
+		var component MemoryComponent
+		var err error
+		if strings.Contains(k.key, "%s") {
+			component, err = measurePattern(k.name, strings.Replace(k.key, "%s", "*", 1))
+		} else {
+			component, err = measureKey(k.name, k.key, nested)
+		}
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, component)
+	}
+	return components, nil
+}
+
+func measureKey(name, key string, nested int) (MemoryComponent, error) {
+	component := MemoryComponent{Name: name}
+
+	var samples []int
+	if nested > 0 {
+		samples = append(samples, nested)
+	}
+	bytes, err := redisClient.MemoryUsage(ctx, key, samples...).Result()
+	if err == redis.Nil {
+		return component, nil
+	}
+	if err != nil {
+		return component, err
+	}
+
+	component.Keys = 1
+	component.Bytes = bytes
+	return component, nil
+}
+
+// measurePattern counts every key matching pattern and extrapolates their memory
+// from the first memorySampleKeys of them
+func measurePattern(name, pattern string) (MemoryComponent, error) {
+	component := MemoryComponent{Name: name}
+
+	var sampled, sampledBytes int64
+	iter := redisClient.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		component.Keys++
+		if sampled >= memorySampleKeys {
+			continue
+		}
+
+		bytes, err := redisClient.MemoryUsage(ctx, iter.Val()).Result()
+		if err == redis.Nil {
+			// Key expired between SCAN and MEMORY USAGE
+			continue
+		}
+		if err != nil {
+			return component, err
+		}
+		sampled++
+		sampledBytes += bytes
+	}
+	if err := iter.Err(); err != nil {
+		return component, err
+	}
+
+	if sampled > 0 {
+		component.Bytes = sampledBytes * component.Keys / sampled
+		component.Sampled = sampled < component.Keys
+	}
+	return component, nil
+}
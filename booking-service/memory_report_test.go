@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestMemoryReportTotalsItsComponents(t *testing.T) {
+	newTestRedis(t)
+
+	// More locks than are sampled, so the lock total is extrapolated
+	locks := memorySampleKeys + 10
+	for i := 0; i < locks; i++ {
+		seatID := shared.GetSeatID(i/shared.VenueCols, i%shared.VenueCols)
+		if ok, err := seatStore.AcquireLock(seatID, "alice", time.Minute); err != nil || !ok {
+			t.Fatalf("AcquireLock %s = %v, %v", seatID, ok, err)
+		}
+	}
+
+	// miniredis does not take SAMPLES
+	report, err := measureMemory(0)
+	if err != nil {
+		t.Fatalf("measureMemory: %v", err)
+	}
+
+	event := report.Events[shared.DefaultEventID]
+	if event == nil || len(report.Events) != 1 {
+		t.Fatalf("events = %v, want the default event's", report.Events)
+	}
+	components := make(map[string]MemoryComponent)
+	var eventTotal int64
+	for _, c := range event.Components {
+		components[c.Name] = c
+		eventTotal += c.Bytes
+	}
+	total := eventTotal
+	for _, c := range report.Shared {
+		components[c.Name] = c
+		total += c.Bytes
+	}
+	if event.TotalBytes != eventTotal || eventTotal <= 0 {
+		t.Errorf("event total %d bytes, want its components' %d", event.TotalBytes, eventTotal)
+	}
+	if report.TotalBytes != total {
+		t.Errorf("total %d bytes, want every component's %d", report.TotalBytes, total)
+	}
+
+	if seats := components["seats"]; seats.Keys != 1 || seats.Bytes <= 0 || seats.Sampled {
+		t.Errorf("seats = %+v, want one measured key", seats)
+	}
+	if c := components["locks"]; c.Keys != int64(locks) || c.Bytes <= 0 || !c.Sampled {
+		t.Errorf("locks = %+v, want %d sampled keys", c, locks)
+	}
+	if c := components["webhooks"]; c.Keys != 0 || c.Bytes != 0 {
+		t.Errorf("webhooks = %+v, want nothing for a missing key", c)
+	}
+}
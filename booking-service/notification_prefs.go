@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+)
+
+var errAnnouncementsUnavailable = errors.New("announcements need NATS")
+
+// maxAnnouncementLength bounds an announcement, which every client receives
+const maxAnnouncementLength = 1000
+
+// GetNotificationPrefs returns a user's notification preferences, or the
+// defaults if they never set any
+func GetNotificationPrefs(userID string) (shared.NotificationPrefs, error) {
+	prefsJSON, err := redisClient.Get(ctx, fmt.Sprintf(shared.RedisKeyNotificationPrefs, userID)).Bytes()
+	if err == redis.Nil {
+		return shared.DefaultNotificationPrefs(), nil
+	}
+	if err != nil {
+		return shared.NotificationPrefs{}, err
+	}
+	var prefs shared.NotificationPrefs
+	if err := json.Unmarshal(prefsJSON, &prefs); err != nil {
+		return shared.NotificationPrefs{}, err
+	}
+	return prefs, nil
+}
+
+// SetNotificationPrefs replaces a user's notification preferences. An empty
+// channel means websocket.
+func SetNotificationPrefs(userID string, prefs shared.NotificationPrefs) (shared.NotificationPrefs, error) {
+	if userID == "" {
+		return prefs, errors.New("user ID is required")
+	}
+	switch prefs.Channel {
+	case "":
+		prefs.Channel = shared.NotificationChannelWebSocket
+	case shared.NotificationChannelWebSocket, shared.NotificationChannelWebhook:
+	default:
+		return prefs, fmt.Errorf("channel must be %s or %s", shared.NotificationChannelWebSocket, shared.NotificationChannelWebhook)
+	}
+
+	prefsJSON, err := json.Marshal(prefs)
+	if err != nil {
+		return prefs, err
+	}
+	// The opt-out set lets announcements find who to leave out without
+	// reading every user's preferences
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf(shared.RedisKeyNotificationPrefs, userID), prefsJSON, 0)
+	if prefs.Marketing {
+		pipe.SRem(ctx, shared.RedisKeyMarketingOptOut, userID)
+	} else {
+		pipe.SAdd(ctx, shared.RedisKeyMarketingOptOut, userID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return prefs, err
+	}
+	slog.Info("Set notification preferences", shared.LogKeyUserID, userID, "hold_expiry_warnings", prefs.HoldExpiryWarnings, "marketing", prefs.Marketing, "channel", prefs.Channel)
+	return prefs, nil
+}
+
+// notifyUser sends a message meant only for userID over the channel they
+// prefer, dropping hold expiry warnings for users who turned them off. It
+// returns errUserOffline when a WebSocket message has no client to go to.
+func notifyUser(userID, msgType string, data interface{}) error {
+	prefs, err := GetNotificationPrefs(userID)
+	if err != nil {
+		// Better a notification they may not want than none at all
+		slog.Warn("Failed to read notification preferences, using the defaults", shared.LogKeyUserID, userID, shared.ErrAttr(err))
+		prefs = shared.DefaultNotificationPrefs()
+	}
+
+	if msgType == shared.MessageTypeHoldExpiring && !prefs.HoldExpiryWarnings {
+		return nil
+	}
+
+	if prefs.Channel == shared.NotificationChannelWebhook {
+		webhooks.enqueue(shared.SeatEvent{
+			Type:         "user_notification",
+			UserID:       userID,
+			Timestamp:    time.Now(),
+			Notification: &shared.DirectedMessage{UserID: userID, Type: msgType, Data: data},
+		})
+		return nil
+	}
+
+	if userRouter == nil {
+		return errUserOffline
+	}
+	return userRouter.SendToUser(userID, msgType, data)
+}
+
+// PublishAnnouncement sends a message to every connected client. Marketing
+// announcements leave out the users who opted out of them.
+func PublishAnnouncement(nc *nats.Conn, message string, marketing bool) (*shared.Announcement, error) {
+	if message == "" || len(message) > maxAnnouncementLength {
+		return nil, fmt.Errorf("message must be 1-%d bytes", maxAnnouncementLength)
+	}
+	if nc == nil {
+		return nil, errAnnouncementsUnavailable
+	}
+
+	announcement := shared.Announcement{Message: message, Marketing: marketing, Timestamp: time.Now()}
+	if marketing {
+		optedOut, err := redisClient.SMembers(ctx, shared.RedisKeyMarketingOptOut).Result()
+		if err != nil {
+			return nil, err
+		}
+		// Only users who are connected can receive it, so the others need
+		// not be named to every edge
+		for _, userID := range optedOut {
+			if userRouter == nil || userRouter.Online(userID) {
+				announcement.ExcludeUsers = append(announcement.ExcludeUsers, userID)
+			}
+		}
+	}
+
+	announcementJSON, err := json.Marshal(announcement)
+	if err != nil {
+		return nil, err
+	}
+	if err := nc.Publish(shared.NATSTopicAnnouncements, announcementJSON); err != nil {
+		return nil, err
+	}
+	slog.Info("Published announcement", shared.LogKeyComponent, "admin", "marketing", marketing, "excluded_users", len(announcement.ExcludeUsers))
+	return &announcement, nil
+}
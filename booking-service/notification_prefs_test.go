@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// bearerToken signs a session token for user with AUTH_SECRET
+func bearerToken(user, role string) string {
+	claims := shared.SessionClaims{UserID: user, Role: role, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	return "Bearer " + shared.SignSessionToken([]byte(os.Getenv("AUTH_SECRET")), claims)
+}
+
+func TestNotificationPrefsAPI(t *testing.T) {
+	newTestRedis(t)
+	t.Setenv("AUTH_SECRET", "test-secret")
+	router := setupRoutes()
+	do := func(method, path, body string) (int, shared.NotificationPrefs) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", bearerToken("alice", ""))
+		router.ServeHTTP(w, req)
+		var prefs shared.NotificationPrefs
+		json.Unmarshal(w.Body.Bytes(), &prefs)
+		return w.Code, prefs
+	}
+
+	if code, prefs := do(http.MethodGet, "/api/users/alice/notifications", ""); code != http.StatusOK || prefs != shared.DefaultNotificationPrefs() {
+		t.Errorf("unset prefs = %d %+v, want the defaults", code, prefs)
+	}
+
+	// Preferences left out of a PUT keep their values
+	code, prefs := do(http.MethodPut, "/api/users/alice/notifications", `{"marketing":false}`)
+	want := shared.NotificationPrefs{HoldExpiryWarnings: true, Marketing: false, Channel: shared.NotificationChannelWebSocket}
+	if code != http.StatusOK || prefs != want {
+		t.Errorf("PUT marketing off = %d %+v, want %+v", code, prefs, want)
+	}
+	if _, prefs := do(http.MethodGet, "/api/users/alice/notifications", ""); prefs != want {
+		t.Errorf("stored prefs = %+v, want %+v", prefs, want)
+	}
+	if optedOut, _ := redisClient.SIsMember(ctx, shared.RedisKeyMarketingOptOut, "alice").Result(); !optedOut {
+		t.Error("alice is not in the marketing opt-out set")
+	}
+
+	do(http.MethodPut, "/api/users/alice/notifications", `{"marketing":true}`)
+	if optedOut, _ := redisClient.SIsMember(ctx, shared.RedisKeyMarketingOptOut, "alice").Result(); optedOut {
+		t.Error("alice is still opted out after opting back in")
+	}
+
+	if code, _ := do(http.MethodPut, "/api/users/alice/notifications", `{"channel":"sms"}`); code != http.StatusBadRequest {
+		t.Errorf("PUT unknown channel = %d, want 400", code)
+	}
+}
+
+func TestNotificationPrefsNeedTheUsersToken(t *testing.T) {
+	newTestRedis(t)
+	router := setupRoutes()
+	put := func(authorization string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/users/alice/notifications", strings.NewReader(`{"marketing":false}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := put(""); code != http.StatusServiceUnavailable {
+		t.Errorf("without AUTH_SECRET = %d, want 503", code)
+	}
+
+	t.Setenv("AUTH_SECRET", "test-secret")
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"forged token", "Bearer " + shared.SignSessionToken([]byte("other-secret"), shared.SessionClaims{UserID: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()}), http.StatusUnauthorized},
+		{"another user", bearerToken("mallory", ""), http.StatusForbidden},
+		{"the user", bearerToken("alice", ""), http.StatusOK},
+		{"an admin", bearerToken("ops", shared.RoleAdmin), http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := put(tt.authorization); code != tt.want {
+			t.Errorf("%s: PUT = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
+
+func TestNotificationsFollowUserPrefs(t *testing.T) {
+	newTestRedis(t)
+
+	// Without an edge to reach them, WebSocket users are offline
+	if err := notifyUser("alice", shared.MessageTypeHoldExpiring, nil); err != errUserOffline {
+		t.Errorf("websocket notification = %v, want errUserOffline", err)
+	}
+
+	SetNotificationPrefs("alice", shared.NotificationPrefs{HoldExpiryWarnings: false, Marketing: true, Channel: shared.NotificationChannelWebhook})
+	if err := notifyUser("alice", shared.MessageTypeHoldExpiring, map[string]interface{}{"seat_id": "A1"}); err != nil {
+		t.Errorf("muted hold warning = %v", err)
+	}
+	if events := webhookEvents(t); len(events) != 0 {
+		t.Fatalf("muted hold warning went to the webhooks: %+v", events)
+	}
+
+	if err := notifyUser("alice", shared.MessageTypeBookingConfirmed, map[string]interface{}{"seat_id": "A1"}); err != nil {
+		t.Errorf("webhook notification = %v", err)
+	}
+	events := webhookEvents(t)
+	if len(events) != 1 {
+		t.Fatalf("webhook stream = %+v, want one notification", events)
+	}
+	event := events[0].Event
+	if event.Type != "user_notification" || event.UserID != "alice" || event.Notification == nil || event.Notification.Type != shared.MessageTypeBookingConfirmed {
+		t.Errorf("webhook event = %+v, want alice's BOOKING_CONFIRMED", event)
+	}
+}
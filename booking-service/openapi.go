@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"concert-booking/shared"
+)
+
+// openAPIDocument describes the API under /api/v1. Keep it in step with
+// apiRoutes: a test fails for routes it leaves out.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// bodySchemas are the request body schemas of openapi.json by "METHOD path",
+// the path as in the document ("/seats/{id}")
+var bodySchemas = mustLoadBodySchemas(openAPIDocument)
+
+// requestBody is what an operation of the document takes as its body
+type requestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"content"`
+}
+
+// schema is the part of an OpenAPI schema object requests are checked against
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Properties map[string]*schema `json:"properties"`
+	Required   []string           `json:"required"`
+	Items      *schema            `json:"items"`
+	Enum       []string           `json:"enum"`
+	OneOf      []*schema          `json:"oneOf"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	MinLength  int                `json:"minLength"`
+	MinItems   int                `json:"minItems"`
+	MaxItems   *int               `json:"maxItems"`
+
+	target *schema // what Ref names
+}
+
+// mustLoadBodySchemas reads the request body schemas out of an OpenAPI
+// document, resolving their references. The document is built in, so one
+// that does not load is a bug.
+func mustLoadBodySchemas(document []byte) map[string]*requestBody {
+	var doc struct {
+		Paths map[string]map[string]struct {
+			RequestBody *requestBody `json:"requestBody"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		panic(fmt.Sprintf("openapi.json: %v", err))
+	}
+
+	bodies := make(map[string]*requestBody)
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			if op.RequestBody == nil {
+				continue
+			}
+			content, ok := op.RequestBody.Content[gin.MIMEJSON]
+			if !ok || content.Schema == nil {
+				panic(fmt.Sprintf("openapi.json: %s %s has no JSON body schema", method, path))
+			}
+			if err := content.Schema.link(doc.Components.Schemas); err != nil {
+				panic(fmt.Sprintf("openapi.json: %s %s: %v", method, path, err))
+			}
+			bodies[strings.ToUpper(method)+" "+path] = op.RequestBody
+		}
+	}
+	return bodies
+}
+
+// link points every reference in s at the component it names
+func (s *schema) link(components map[string]*schema) error {
+	if s.Ref != "" {
+		if s.target != nil {
+			return nil
+		}
+		s.target = components[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if s.target == nil {
+			return fmt.Errorf("no schema %s", s.Ref)
+		}
+		return s.target.link(components)
+	}
+	children := append([]*schema{s.Items}, s.OneOf...)
+	for _, child := range s.Properties {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if err := child.link(components); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validRequest answers 400 to requests whose body does not fit their route's
+// schema in openapi.json, naming the field, before a handler reads it. Fields
+// the schema does not list are let through.
+func validRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, ok := bodySchemas[c.Request.Method+" "+documentPath(c.FullPath())]
+		if !ok || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		raw, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(raw))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: "Failed to read request body"})
+			return
+		}
+		if err := body.validate(raw); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, shared.ErrorResponse{Code: shared.ErrorCodeInvalidRequest, Error: err.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// documentPath is the path of a route in openapi.json: without the API
+// prefix, and with {id} for :id
+func documentPath(route string) string {
+	if rest, ok := strings.CutPrefix(route, shared.APIPrefix); ok {
+		route = rest
+	} else {
+		route = strings.TrimPrefix(route, shared.APIPrefixLegacy)
+	}
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// validate checks a request body against the operation's schema
+func (b *requestBody) validate(raw []byte) error {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if b.Required {
+			return errors.New("body is required")
+		}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return errors.New("body is not valid JSON")
+	}
+	return b.Content[gin.MIMEJSON].Schema.validate(v, "")
+}
+
+// validate checks a decoded JSON value against s; path names the value in
+// errors, "" being the whole body
+func (s *schema) validate(v interface{}, path string) error {
+	if s.target != nil {
+		return s.target.validate(v, path)
+	}
+	name := path
+	if name == "" {
+		name = "body"
+	}
+	if len(s.OneOf) > 0 {
+		return s.validateOneOf(v, path, name)
+	}
+	if s.Type != "" && !hasType(v, s.Type) {
+		return fmt.Errorf("%s must be %s, got %s", name, typeName(s.Type), jsonType(v))
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, field := range s.Required {
+			if v[field] == nil {
+				return fmt.Errorf("%s is required", fieldPath(path, field))
+			}
+		}
+		for _, field := range slices.Sorted(maps.Keys(v)) {
+			if prop, ok := s.Properties[field]; ok && v[field] != nil {
+				if err := prop.validate(v[field], fieldPath(path, field)); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if len(v) < s.MinItems {
+			return fmt.Errorf("%s must list at least %d", name, s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s must list at most %d", name, *s.MaxItems)
+		}
+		for _, item := range v {
+			if s.Items == nil || item == nil {
+				continue
+			}
+			if err := s.Items.validate(item, path); err != nil {
+				return err
+			}
+		}
+	case string:
+		if len(v) < s.MinLength {
+			return fmt.Errorf("%s must not be empty", name)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+			return fmt.Errorf("%s must be one of %s, got %q", name, strings.Join(s.Enum, ", "), v)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				return fmt.Errorf("%s must be an RFC 3339 time, got %q", name, v)
+			}
+		}
+	case json.Number:
+		n, _ := v.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v", name, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("%s must be at most %v", name, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+// validateOneOf checks v against the alternative of its type
+func (s *schema) validateOneOf(v interface{}, path, name string) error {
+	var types []string
+	for _, alt := range s.OneOf {
+		if alt.target != nil {
+			alt = alt.target
+		}
+		if hasType(v, alt.Type) {
+			return alt.validate(v, path)
+		}
+		types = append(types, typeName(alt.Type))
+	}
+	return fmt.Errorf("%s must be %s, got %s", name, strings.Join(types, " or "), jsonType(v))
+}
+
+// hasType reports whether a decoded JSON value is of an OpenAPI type
+func hasType(v interface{}, t string) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case json.Number:
+		if t == "integer" {
+			_, err := v.Int64()
+			return err == nil
+		}
+		return t == "number"
+	}
+	return false
+}
+
+// typeName describes the values of an OpenAPI type, as DecodePayload does
+func typeName(t string) string {
+	switch t {
+	case "string":
+		return "a string"
+	case "integer":
+		return "an integer"
+	case "number":
+		return "a number"
+	case "boolean":
+		return "true or false"
+	case "array":
+		return "a list"
+	}
+	return "an object"
+}
+
+// jsonType names the JSON type of a decoded value, as encoding/json does
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	}
+	return "null"
+}
+
+// fieldPath is the dotted name of field within the value at path; list
+// indexes are left out, as in DecodePayload's errors
+func fieldPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// handleOpenAPI serves the API's OpenAPI document
+func handleOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, gin.MIMEJSON, openAPIDocument)
+}
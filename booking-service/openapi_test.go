@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/openapi.json = %d", w.Code)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || doc.OpenAPI == "" {
+		t.Fatalf("openapi.json is not an OpenAPI document: %v", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	for _, route := range setupRoutes().Routes() {
+		if !strings.HasPrefix(route.Path, shared.APIPrefix+"/") {
+			continue
+		}
+		key := route.Method + " " + documentPath(route.Path)
+		if !documented[key] {
+			t.Errorf("%s %s is not in openapi.json", route.Method, route.Path)
+		}
+		delete(documented, key)
+	}
+	for key := range documented {
+		t.Errorf("openapi.json documents %s, which has no route", key)
+	}
+}
+
+func TestRequestBodiesAreValidatedAgainstTheSchema(t *testing.T) {
+	newTestRedis(t)
+	router := setupRoutes()
+
+	tests := []struct {
+		method, path, body string
+		want               string
+	}{
+		{http.MethodPost, "/seats/select", `{"seat_id": 5, "user_id": "user-1"}`, "seat_id must be a string, got number"},
+		{http.MethodPost, "/seats/select", `{"seat_id": "A1"}`, "user_id is required"},
+		{http.MethodPost, "/seats/book", `{"seat_id": "A1", "user_id": "user-1", "version": 1.5}`, "version must be an integer, got number"},
+		{http.MethodPost, "/seats/release", `{"seat_id": "", "user_id": "user-1"}`, "seat_id must not be empty"},
+		{http.MethodPost, "/seats/select", `["A1"]`, "body must be an object, got array"},
+		{http.MethodPost, "/seats/select", `{"seat_id": "A1",`, "body is not valid JSON"},
+		{http.MethodPost, "/seats/select", ``, "body is required"},
+		{http.MethodPost, "/seats/batch-get", `{"seat_ids": []}`, "seat_ids must list at least 1"},
+		{http.MethodPut, "/admin/flags/new_checkout", `{"enabled": "yes"}`, "enabled must be true or false, got string"},
+		{http.MethodPost, "/admin/seats/bulk", `{"seat_ids": ["A1"], "status": "sold"}`, `status must be one of available, held, booked, got "sold"`},
+		{http.MethodPost, "/admin/seats/bulk", `{"seat_ids": ["A1"], "status": true}`, "status must be a string or an integer, got bool"},
+		{http.MethodPost, "/admin/resale/blocks", `{"partner_id": "p1", "seat_ids": ["A1"], "return_rules": [{"at": "tomorrow", "keep": 0}]}`,
+			`return_rules.at must be an RFC 3339 time, got "tomorrow"`},
+	}
+	for _, tt := range tests {
+		for _, prefix := range []string{shared.APIPrefix, shared.APIPrefixLegacy} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, prefix+tt.path, strings.NewReader(tt.body)))
+			var resp shared.ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != http.StatusBadRequest || resp.Code != shared.ErrorCodeInvalidRequest || resp.Error != tt.want {
+				t.Errorf("%s %s%s %s = %d %+v, want 400 %q", tt.method, prefix, tt.path, tt.body, w.Code, resp, tt.want)
+			}
+		}
+	}
+
+	// Valid bodies reach the handler whole, fields the schema does not list
+	// included
+	w := httptest.NewRecorder()
+	body := `{"seat_id": "A1", "user_id": "user-1", "client_note": "aisle"}`
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, shared.APIEndpointSelectSeat, strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("valid select = %d: %s", w.Code, w.Body)
+	}
+	if seat := loadSeat(t, "A1"); seat.HeldBy != "user-1" {
+		t.Errorf("A1 held by %q, want user-1", seat.HeldBy)
+	}
+}
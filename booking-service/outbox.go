@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Consumer group the relay reads the outbox with
+	outboxGroup = "relay"
+
+	// Approximate cap on undelivered events kept while the bus is unavailable
+	outboxMaxLen = 100000
+
+	// Entries published per round trip
+	outboxBatchSize = 100
+
+	// How long a read waits for new entries
+	outboxBlock = 1 * time.Second
+
+	// How long to wait for the bus to confirm a published batch
+	outboxFlushTimeout = 5 * time.Second
+
+	// Backoff between failed publish attempts
+	outboxRetryMin = 100 * time.Millisecond
+	outboxRetryMax = 5 * time.Second
+)
+
+// Longest RELEASE_BATCH_WINDOW accepted; releases must still reach clients promptly
+const maxReleaseBatchWindow = time.Second
+
+// Most releases grouped into one released_batch event
+const maxReleaseBatch = 500
+
+// releaseBatchWindowFromEnv reads RELEASE_BATCH_WINDOW, a duration such as
+// 100ms. Zero, the default, publishes every release on its own.
+func releaseBatchWindowFromEnv() (time.Duration, error) {
+	env := os.Getenv("RELEASE_BATCH_WINDOW")
+	if env == "" {
+		return 0, nil
+	}
+	window, err := time.ParseDuration(env)
+	if err != nil || window < 0 || window > maxReleaseBatchWindow {
+		return 0, fmt.Errorf("RELEASE_BATCH_WINDOW must be a duration from 0 to %s, got %q", maxReleaseBatchWindow, env)
+	}
+	return window, nil
+}
+
+// StartOutboxRelay drains seat events written by the seat scripts to the event
+// bus. Entries are acknowledged only after the bus has confirmed them, so a crash
+// or bus outage delays events instead of losing them.
+func StartOutboxRelay(redisClient *redis.Client, bus shared.EventBus) error {
+	ctx := context.Background()
+
+	window, err := releaseBatchWindowFromEnv()
+	if err != nil {
+		return err
+	}
+	format, err := shared.EventBusFormatFromEnv()
+	if err != nil {
+		return err
+	}
+
+	err = redisClient.XGroupCreateMkStream(ctx, shared.RedisKeyOutbox, outboxGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	consumer, err := os.Hostname()
+	if err != nil {
+		consumer = "booking-service"
+	}
+
+	go relayOutbox(redisClient, &outboxPublisher{bus: bus, window: window, format: format}, consumer)
+	slog.Info("Outbox relay started", "consumer", consumer, "release_batch_window", window, "format", format)
+	return nil
+}
+
+func relayOutbox(redisClient *redis.Client, publisher *outboxPublisher, consumer string) {
+	ctx := context.Background()
+	backoff := outboxRetryMin
+
+	// "0" re-reads entries delivered to this consumer but never acknowledged,
+	// ">" reads new ones
+	lastID := "0"
+
+	for {
+		// Wake up in time to publish releases held for batching
+		block := outboxBlock
+		if publisher.holding() {
+			block = max(time.Until(publisher.due), time.Millisecond)
+		}
+
+		streams, err := redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    outboxGroup,
+			Consumer: consumer,
+			Streams:  []string{shared.RedisKeyOutbox, lastID},
+			Count:    outboxBatchSize,
+			Block:    block,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			slog.Error("Outbox read failed", shared.ErrAttr(err))
+			time.Sleep(backoff)
+			backoff = min(backoff*2, outboxRetryMax)
+			continue
+		}
+
+		var messages []redis.XMessage
+		if len(streams) > 0 {
+			messages = streams[0].Messages
+		}
+		if lastID != ">" {
+			if len(messages) == 0 {
+				// Pending backlog drained, switch to new entries
+				lastID = ">"
+				continue
+			}
+			// Held releases stay pending, so page past them
+			lastID = messages[len(messages)-1].ID
+		}
+		if len(messages) == 0 && !publisher.holding() {
+			continue
+		}
+
+		ids, err := publisher.publish(messages)
+		if err != nil {
+			slog.Warn("Outbox publish failed, retrying", "backoff", backoff, shared.ErrAttr(err))
+			time.Sleep(backoff)
+			backoff = min(backoff*2, outboxRetryMax)
+			publisher.drop()
+			lastID = "0"
+			continue
+		}
+		backoff = outboxRetryMin
+		if len(ids) == 0 {
+			continue
+		}
+
+		if err := redisClient.XAck(ctx, shared.RedisKeyOutbox, outboxGroup, ids...).Err(); err != nil {
+			slog.Warn("Failed to acknowledge outbox entries", "count", len(ids), shared.ErrAttr(err))
+			continue
+		}
+		redisClient.XDel(ctx, shared.RedisKeyOutbox, ids...)
+	}
+}
+
+// outboxEntry is a decoded outbox entry
+type outboxEntry struct {
+	id          string
+	topic       string
+	traceparent string
+	event       shared.SeatEvent
+}
+
+// outboxPublisher publishes outbox entries in stream order. With a window, it
+// holds releases back and publishes those made within the window as one
+// released_batch event. Any other event first publishes the releases held
+// before it, so holds and bookings are never delayed and sequence numbers stay
+// in order on the bus.
+type outboxPublisher struct {
+	bus    shared.EventBus
+	window time.Duration
+	format string // wire format of published events, JSON unless msgpack
+
+	held []outboxEntry // releases waiting for the window to end
+	due  time.Time     // when the held releases are published
+}
+
+// holding reports whether releases are waiting to be published
+func (p *outboxPublisher) holding() bool {
+	return len(p.held) > 0
+}
+
+// drop forgets held releases after a failure; they are still pending in the
+// outbox and are read again
+func (p *outboxPublisher) drop() {
+	p.held = nil
+}
+
+// publish publishes messages, holding back releases when batching, and waits
+// for the bus to confirm them. It returns the IDs of the entries that are done
+// with, including malformed ones that can never be published.
+func (p *outboxPublisher) publish(messages []redis.XMessage) ([]string, error) {
+	var done []string
+	published := false
+
+	for _, msg := range messages {
+		entry, err := decodeOutboxEntry(msg)
+		if err != nil {
+			// A malformed entry can never be published; skip it rather than block the outbox
+			slog.Error("Dropping malformed outbox entry", "entry_id", msg.ID, shared.ErrAttr(err))
+			done = append(done, msg.ID)
+			continue
+		}
+
+		if p.window > 0 && isRelease(entry.event.Type) {
+			if len(p.held) == 0 {
+				p.due = time.Now().Add(p.window)
+			}
+			p.held = append(p.held, entry)
+			if len(p.held) < maxReleaseBatch {
+				continue
+			}
+			ids, err := p.publishHeld()
+			if err != nil {
+				return nil, err
+			}
+			done, published = append(done, ids...), true
+			continue
+		}
+
+		ids, err := p.publishHeld()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.publishEntry(entry); err != nil {
+			return nil, err
+		}
+		done, published = append(append(done, ids...), entry.id), true
+	}
+
+	if p.holding() && !time.Now().Before(p.due) {
+		ids, err := p.publishHeld()
+		if err != nil {
+			return nil, err
+		}
+		done, published = append(done, ids...), true
+	}
+
+	if published {
+		if err := p.bus.Flush(outboxFlushTimeout); err != nil {
+			return nil, err
+		}
+	}
+	return done, nil
+}
+
+// publishHeld publishes the held releases, as one released_batch event if
+// there is more than one, and returns their entry IDs
+func (p *outboxPublisher) publishHeld() ([]string, error) {
+	if len(p.held) == 0 {
+		return nil, nil
+	}
+	held := p.held
+	p.held = nil
+
+	ids := make([]string, len(held))
+	for i, entry := range held {
+		ids[i] = entry.id
+	}
+	if len(held) == 1 {
+		return ids, p.publishEntry(held[0])
+	}
+
+	batch := outboxEntry{
+		topic:       shared.SeatBatchSubject(shared.DefaultEventID),
+		traceparent: held[0].traceparent,
+		event: shared.SeatEvent{
+			Type:      shared.SeatEventReleasedBatch,
+			Seq:       held[0].event.Seq,
+			Timestamp: time.Now(),
+			Events:    make([]shared.SeatEvent, len(held)),
+		},
+	}
+	for i, entry := range held {
+		batch.event.Events[i] = entry.event
+	}
+	return ids, p.publishEntry(batch)
+}
+
+// publishEntry publishes one event on its topic
+func (p *outboxPublisher) publishEntry(entry outboxEntry) error {
+	event := entry.event
+	payload, err := shared.MarshalEvent(event, p.format)
+	if err != nil {
+		slog.Error("Dropping outbox entry", "entry_id", entry.id, shared.ErrAttr(err))
+		return nil
+	}
+
+	span := publishSpan(entry.traceparent, entry.topic, event.SeatID)
+	err = p.bus.Publish(entry.topic, payload)
+	span.End()
+	if err != nil {
+		return fmt.Errorf("publish %s event for seat %s: %w", event.Type, event.SeatID, err)
+	}
+	slog.Debug("Published seat event", "event_type", event.Type, shared.LogKeySeatID, event.SeatID,
+		"topic", entry.topic, "seq", event.Seq, shared.LogKeyUserID, event.UserID, "batched", len(event.Events))
+	return nil
+}
+
+// decodeOutboxEntry reads an outbox entry and stamps its sequence number
+func decodeOutboxEntry(msg redis.XMessage) (outboxEntry, error) {
+	topic, _ := msg.Values["topic"].(string)
+	seqStr, _ := msg.Values["seq"].(string)
+	eventStr, _ := msg.Values["event"].(string)
+	traceparent, _ := msg.Values["traceparent"].(string)
+
+	entry := outboxEntry{id: msg.ID, topic: topic, traceparent: traceparent}
+	if err := json.Unmarshal([]byte(eventStr), &entry.event); err != nil {
+		return entry, err
+	}
+	entry.event.Seq, _ = strconv.ParseInt(seqStr, 10, 64)
+	return entry, nil
+}
+
+// isRelease reports whether a seat event type frees the seat
+func isRelease(eventType string) bool {
+	return eventType == "released" || eventType == "auto_released" || eventType == "returned"
+}
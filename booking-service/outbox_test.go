@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// recordingBus keeps what is published to it
+type recordingBus struct {
+	shared.EventBus
+	subjects []string
+	events   []shared.SeatEvent
+}
+
+func (b *recordingBus) Publish(subject string, data []byte) error {
+	var event shared.SeatEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	b.subjects = append(b.subjects, subject)
+	b.events = append(b.events, event)
+	return nil
+}
+
+func (b *recordingBus) Flush(time.Duration) error { return nil }
+
+// failingBus records like recordingBus until the failAt'th publish, which fails
+type failingBus struct {
+	recordingBus
+	failAt int
+}
+
+func (b *failingBus) Publish(subject string, data []byte) error {
+	if len(b.events)+1 >= b.failAt {
+		return errors.New("bus: connection closed")
+	}
+	return b.recordingBus.Publish(subject, data)
+}
+
+func outboxMessage(seq int64, eventType, seatID string) redis.XMessage {
+	eventJSON, _ := json.Marshal(shared.SeatEvent{Type: eventType, SeatID: seatID})
+	return redis.XMessage{
+		ID: strconv.FormatInt(seq, 10) + "-0",
+		Values: map[string]interface{}{
+			"topic": shared.SeatSubject(shared.DefaultEventID, "main", seatID, eventType),
+			"seq":   strconv.FormatInt(seq, 10),
+			"event": string(eventJSON),
+		},
+	}
+}
+
+func TestOutboxBatchesReleasesWithoutDelayingHolds(t *testing.T) {
+	bus := &recordingBus{}
+	p := &outboxPublisher{bus: bus, window: time.Hour}
+
+	done, err := p.publish([]redis.XMessage{
+		outboxMessage(1, "released", "A1"),
+		outboxMessage(2, "auto_released", "A2"),
+		outboxMessage(3, "held", "A3"),
+		outboxMessage(4, "released", "A4"),
+		outboxMessage(5, "released", "A5"),
+	})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if want := []string{"1-0", "2-0", "3-0"}; !reflect.DeepEqual(done, want) {
+		t.Fatalf("done = %v, want %v with the last releases held", done, want)
+	}
+	if len(bus.events) != 2 || bus.events[0].Type != shared.SeatEventReleasedBatch || bus.events[1].Type != "held" {
+		t.Fatalf("published %+v, want the first releases batched ahead of the hold", bus.events)
+	}
+	if batch := bus.events[0]; batch.Seq != 1 || batch.LastSeq() != 2 || bus.subjects[0] != shared.SeatBatchSubject(shared.DefaultEventID) {
+		t.Errorf("batch = %+v on %s, want seqs 1-2 on the batch subject", batch, bus.subjects[0])
+	}
+
+	// Once the window ends the held releases go out together
+	p.due = time.Now()
+	done, err = p.publish(nil)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if want := []string{"4-0", "5-0"}; !reflect.DeepEqual(done, want) {
+		t.Fatalf("done = %v, want %v", done, want)
+	}
+	if last := bus.events[len(bus.events)-1]; len(last.Events) != 2 || last.Events[0].SeatID != "A4" || last.Events[1].Seq != 5 {
+		t.Errorf("last batch = %+v, want A4 and A5", last)
+	}
+}
+
+func TestOutboxPublishesReleasesSinglyWithoutAWindow(t *testing.T) {
+	bus := &recordingBus{}
+	p := &outboxPublisher{bus: bus}
+
+	done, err := p.publish([]redis.XMessage{outboxMessage(1, "released", "A1"), outboxMessage(2, "released", "A2")})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(done) != 2 || len(bus.events) != 2 || bus.events[0].Type != "released" || bus.events[1].Seq != 2 {
+		t.Errorf("published %+v, want two release events", bus.events)
+	}
+}
+
+func TestOutboxPublishesInOrderWithSequenceNumbers(t *testing.T) {
+	bus := &recordingBus{}
+	p := &outboxPublisher{bus: bus}
+	malformed := redis.XMessage{ID: "2-0", Values: map[string]interface{}{"seq": "2", "event": "{"}}
+
+	done, err := p.publish([]redis.XMessage{outboxMessage(1, "held", "A1"), malformed, outboxMessage(3, "booked", "A3")})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if want := []string{"1-0", "2-0", "3-0"}; !reflect.DeepEqual(done, want) {
+		t.Fatalf("done = %v, want %v with the malformed entry done with", done, want)
+	}
+
+	var got []string
+	for i, event := range bus.events {
+		got = append(got, event.SeatID+"@"+strconv.FormatInt(event.Seq, 10)+" on "+bus.subjects[i])
+	}
+	want := []string{
+		"A1@1 on " + shared.SeatSubject(shared.DefaultEventID, "main", "A1", "held"),
+		"A3@3 on " + shared.SeatSubject(shared.DefaultEventID, "main", "A3", "booked"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+}
+
+func TestOutboxStopsAtTheFirstFailedPublish(t *testing.T) {
+	bus := &failingBus{failAt: 2}
+	p := &outboxPublisher{bus: bus}
+
+	done, err := p.publish([]redis.XMessage{outboxMessage(1, "held", "A1"), outboxMessage(2, "held", "A2"), outboxMessage(3, "held", "A3")})
+	if err == nil || done != nil {
+		t.Fatalf("publish = %v, %v; want an error and nothing done so the batch is read again", done, err)
+	}
+	if len(bus.events) != 1 {
+		t.Fatalf("published %d events, want 1", len(bus.events))
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Payment states reported by the payment provider
+const (
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+)
+
+// HeaderPaymentSignature carries the hex HMAC-SHA256 of a callback body, keyed
+// with PAYMENT_WEBHOOK_SECRET
+const HeaderPaymentSignature = "X-Payment-Signature"
+
+var errPaymentSignature = errors.New("invalid payment signature")
+
+// paymentMessageTypes maps each payment state to the message pushed to the buyer
+var paymentMessageTypes = map[string]string{
+	PaymentPending:   shared.MessageTypePaymentPending,
+	PaymentSucceeded: shared.MessageTypePaymentSucceeded,
+	PaymentFailed:    shared.MessageTypePaymentFailed,
+}
+
+// PaymentCallback is what the payment provider POSTs to /api/payments/callback
+// as a payment for an order progresses
+type PaymentCallback struct {
+	OrderID   string `json:"order_id"`
+	Status    string `json:"status"`              // pending, succeeded or failed
+	Reference string `json:"reference,omitempty"` // the provider's ID for the payment
+	Reason    string `json:"reason,omitempty"`    // why a payment failed
+}
+
+// PaymentStatus is the latest payment state of an order. It is kept apart from
+// the order so callbacks never race the fulfillment worker's saves.
+type PaymentStatus struct {
+	Status    string    `json:"status"`
+	Reference string    `json:"reference,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// verifyPaymentSignature checks a callback body against its signature header.
+// Without PAYMENT_WEBHOOK_SECRET every callback is accepted, which only suits
+// development against a fake provider.
+func verifyPaymentSignature(body []byte, signature string) error {
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return errPaymentSignature
+	}
+	return nil
+}
+
+// RecordPayment stores a provider callback as its order's payment status and
+// pushes the change to the buyer. Callbacks may arrive late or more than once:
+// a repeat of the current state changes nothing, and pending never replaces a
+// final state. It reports whether the status changed.
+func RecordPayment(cb PaymentCallback) (bool, error) {
+	if _, ok := paymentMessageTypes[cb.Status]; !ok {
+		return false, fmt.Errorf("unknown payment status %q", cb.Status)
+	}
+	order, err := GetOrder(cb.OrderID)
+	if err != nil {
+		return false, err
+	}
+
+	status := PaymentStatus{
+		Status:    cb.Status,
+		Reference: cb.Reference,
+		Reason:    cb.Reason,
+		UpdatedAt: time.Now(),
+	}
+	changed := false
+	err = redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := loadPaymentStatus(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		if current != nil && (current.Status == cb.Status || cb.Status == PaymentPending) {
+			return nil
+		}
+
+		statusJSON, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, shared.RedisKeyPayments, order.ID, statusJSON)
+			return nil
+		})
+		changed = err == nil
+		return err
+	}, shared.RedisKeyPayments)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	slog.Info("Payment status changed", "order_id", order.ID, shared.LogKeyUserID, order.UserID, "status", status.Status)
+	notifyPayment(order, status)
+	return true, nil
+}
+
+// loadPaymentStatus returns an order's payment status, or nil before the
+// first callback
+func loadPaymentStatus(client redis.Cmdable, orderID string) (*PaymentStatus, error) {
+	statusJSON, err := client.HGet(ctx, shared.RedisKeyPayments, orderID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var status PaymentStatus
+	if err := json.Unmarshal([]byte(statusJSON), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// notifyPayment tells the buyer, on the channel they prefer, how their payment
+// is going. Buyers who are offline see it on the order instead.
+func notifyPayment(order *Order, status PaymentStatus) {
+	data := map[string]interface{}{
+		"order_id": order.ID,
+		"seat_id":  order.SeatID,
+		"status":   status.Status,
+	}
+	if status.Reason != "" {
+		data["reason"] = status.Reason
+	}
+	err := notifyUser(order.UserID, paymentMessageTypes[status.Status], data)
+	if err != nil && err != errUserOffline {
+		slog.Warn("Failed to push payment status", "order_id", order.ID, shared.LogKeyUserID, order.UserID, shared.ErrAttr(err))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func bookTestOrder(t *testing.T) *Order {
+	t.Helper()
+
+	seatID := shared.GetSeatID(0, 0)
+	if err := SelectSeat(ctx, seatID, "user-1", 0); err != nil {
+		t.Fatalf("SelectSeat: %v", err)
+	}
+	order, err := BookSeat(ctx, seatID, "user-1", 0)
+	if err != nil || order == nil {
+		t.Fatalf("BookSeat = %v, %v", order, err)
+	}
+	return order
+}
+
+func TestPaymentStatusOnlyMovesForward(t *testing.T) {
+	newTestRedis(t)
+	order := bookTestOrder(t)
+
+	steps := []struct {
+		status      string
+		wantChanged bool
+		wantStatus  string
+	}{
+		{PaymentPending, true, PaymentPending},
+		{PaymentPending, false, PaymentPending},
+		{PaymentFailed, true, PaymentFailed},
+		{PaymentPending, false, PaymentFailed}, // a late pending after the failure
+		{PaymentSucceeded, true, PaymentSucceeded},
+		{PaymentSucceeded, false, PaymentSucceeded},
+	}
+	for i, step := range steps {
+		changed, err := RecordPayment(PaymentCallback{OrderID: order.ID, Status: step.status, Reference: "pay_1"})
+		if err != nil {
+			t.Fatalf("step %d: RecordPayment(%s): %v", i, step.status, err)
+		}
+		if changed != step.wantChanged {
+			t.Errorf("step %d: RecordPayment(%s) changed = %v, want %v", i, step.status, changed, step.wantChanged)
+		}
+
+		stored, err := GetOrder(order.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Payment == nil || stored.Payment.Status != step.wantStatus {
+			t.Errorf("step %d: order payment = %+v, want %s", i, stored.Payment, step.wantStatus)
+		}
+	}
+
+	// Fulfillment saving the order must not drop or freeze its payment status
+	stored, _ := GetOrder(order.ID)
+	fulfillOrder(stored)
+	if raw, _ := redisClient.HGet(ctx, shared.RedisKeyOrders, order.ID).Result(); strings.Contains(raw, `"payment"`) {
+		t.Errorf("saved order carries its payment status: %s", raw)
+	}
+	if stored, _ = GetOrder(order.ID); stored.Payment == nil || stored.Payment.Status != PaymentSucceeded {
+		t.Errorf("payment after fulfillment = %+v, want succeeded", stored.Payment)
+	}
+}
+
+func TestPaymentCallbackEndpoint(t *testing.T) {
+	newTestRedis(t)
+	order := bookTestOrder(t)
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "s3cret")
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	post := func(body, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/payments/callback", strings.NewReader(body))
+		req.Header.Set(HeaderPaymentSignature, signature)
+		w := httptest.NewRecorder()
+		setupRoutes().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	succeeded := `{"order_id":"` + order.ID + `","status":"succeeded"}`
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		want      int
+	}{
+		{"unsigned", succeeded, "", http.StatusUnauthorized},
+		{"wrong signature", succeeded, sign(`{"order_id":"other"}`), http.StatusUnauthorized},
+		{"unknown status", `{"order_id":"` + order.ID + `","status":"refunded"}`, sign(`{"order_id":"` + order.ID + `","status":"refunded"}`), http.StatusBadRequest},
+		{"unknown order", `{"order_id":"nope","status":"failed"}`, sign(`{"order_id":"nope","status":"failed"}`), http.StatusNotFound},
+		{"signed", succeeded, sign(succeeded), http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := post(tt.body, tt.signature); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	stored, err := GetOrder(order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Payment == nil || stored.Payment.Status != PaymentSucceeded {
+		t.Errorf("order payment = %+v, want succeeded", stored.Payment)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"concert-booking/shared"
+)
+
+// rateLimit lets a caller make requests per period, all at once if it likes
+type rateLimit struct {
+	requests int
+	period   time.Duration
+}
+
+// parseRateLimit reads "<requests>/<period>", e.g. "20/1s"
+func parseRateLimit(raw string) (rateLimit, error) {
+	requests, period, ok := strings.Cut(raw, "/")
+	n, err := strconv.Atoi(requests)
+	if !ok || err != nil || n < 1 {
+		return rateLimit{}, fmt.Errorf("rate limit must be <requests>/<period>, e.g. 20/1s, got %q", raw)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return rateLimit{}, fmt.Errorf("rate limit must be <requests>/<period>, e.g. 20/1s, got %q", raw)
+	}
+	return rateLimit{requests: n, period: d}, nil
+}
+
+// rateLimiter keeps a token bucket per key, holding up to limit.requests tokens
+// and refilled at limit.requests per limit.period. Buckets live in this
+// instance only, so each booking service instance allows the full limit.
+type rateLimiter struct {
+	limit rateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(limit rateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// take spends one of key's tokens. With none left it reports how long until
+// the next one. A nil limiter allows everything.
+func (l *rateLimiter) take(key string, now time.Time) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	capacity := float64(l.limit.requests)
+	perToken := l.limit.period / time.Duration(l.limit.requests)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+float64(now.Sub(bucket.updated))/float64(perToken))
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) * float64(perToken)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+// sweep forgets, once a period, the buckets idle long enough to be full again
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.limit.period {
+		return
+	}
+	l.swept = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= l.limit.period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+var (
+	// Limits on requests to the seat API from one address and for one user;
+	// nil leaves them unlimited
+	ipRateLimiter, userRateLimiter *rateLimiter
+
+	// Addresses exempt from rate limits, i.e. the edges
+	rateLimitExempt []*net.IPNet
+
+	// Header a trusted proxy puts the client's address in; "" uses the
+	// connection's own address
+	clientIPHeader string
+)
+
+// rateLimitsFromEnv reads RATE_LIMIT_PER_IP, RATE_LIMIT_PER_USER,
+// RATE_LIMIT_EXEMPT and CLIENT_IP_HEADER
+func rateLimitsFromEnv() error {
+	for _, setting := range []struct {
+		name    string
+		limiter **rateLimiter
+	}{
+		{"RATE_LIMIT_PER_IP", &ipRateLimiter},
+		{"RATE_LIMIT_PER_USER", &userRateLimiter},
+	} {
+		*setting.limiter = nil
+		if env := os.Getenv(setting.name); env != "" {
+			limit, err := parseRateLimit(env)
+			if err != nil {
+				return fmt.Errorf("%s: %w", setting.name, err)
+			}
+			*setting.limiter = newRateLimiter(limit)
+		}
+	}
+
+	rateLimitExempt = nil
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMIT_EXEMPT"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("RATE_LIMIT_EXEMPT must list addresses or CIDR ranges, got %q", entry)
+		}
+		rateLimitExempt = append(rateLimitExempt, network)
+	}
+
+	clientIPHeader = os.Getenv("CLIENT_IP_HEADER")
+	return nil
+}
+
+// requestIP returns the address a request came from. With clientIPHeader set,
+// the service sits behind a proxy, which appends the address it saw last.
+func requestIP(r *http.Request) string {
+	if clientIPHeader != "" {
+		if forwarded := r.Header.Get(clientIPHeader); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitedUser names the user a request is made for: its session token's,
+// or else the user_id of its query or JSON body
+func rateLimitedUser(c *gin.Context) string {
+	if claims, err := sessionClaims(c); err == nil {
+		return claims.UserID
+	}
+	if user := c.Query("user_id"); user != "" {
+		return user
+	}
+	if c.Request.Body == nil || c.Request.Method == http.MethodGet {
+		return ""
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	json.Unmarshal(body, &req)
+	return req.UserID
+}
+
+// rateLimited answers 429 with Retry-After to callers over the per-address or
+// per-user limit, so scripts cannot hammer Redis through the API. Exempt
+// addresses, the edges, carry every user's traffic and are not limited.
+func rateLimited() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ipRateLimiter == nil && userRateLimiter == nil {
+			c.Next()
+			return
+		}
+		addr := requestIP(c.Request)
+		if ip := net.ParseIP(addr); ip != nil {
+			for _, network := range rateLimitExempt {
+				if network.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		now := time.Now()
+		wait, ok := ipRateLimiter.take(addr, now)
+		if ok && userRateLimiter != nil {
+			if user := rateLimitedUser(c); user != "" {
+				wait, ok = userRateLimiter.take(user, now)
+			}
+		}
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, shared.ErrorResponse{Code: shared.ErrorCodeRateLimited, Error: fmt.Sprintf("Too many requests, retry in %ds", seconds)})
+			return
+		}
+		c.Next()
+	}
+}
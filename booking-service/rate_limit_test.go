@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsOverItsPeriod(t *testing.T) {
+	limiter := newRateLimiter(rateLimit{requests: 2, period: time.Second})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, ok := limiter.take("a", now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	if wait, ok := limiter.take("a", now); ok || wait != 500*time.Millisecond {
+		t.Errorf("request over the burst = %v %v, want refused for 500ms", wait, ok)
+	}
+	if _, ok := limiter.take("b", now); !ok {
+		t.Error("another key shares the first key's bucket")
+	}
+	if _, ok := limiter.take("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("request once a token refilled was refused")
+	}
+
+	// Idle buckets are full again, so they are forgotten
+	limiter.take("c", now.Add(3*time.Second))
+	if _, kept := limiter.buckets["a"]; kept || len(limiter.buckets) != 1 {
+		t.Errorf("buckets after a sweep = %v, want only c", limiter.buckets)
+	}
+}
+
+func TestSeatAPIAnswersTooManyRequests(t *testing.T) {
+	newTestRedis(t)
+	t.Setenv("RATE_LIMIT_PER_IP", "3/1m")
+	t.Setenv("RATE_LIMIT_PER_USER", "1/1m")
+	t.Setenv("RATE_LIMIT_EXEMPT", "10.0.0.0/8")
+	if err := rateLimitsFromEnv(); err != nil {
+		t.Fatalf("rateLimitsFromEnv: %v", err)
+	}
+	t.Cleanup(func() { ipRateLimiter, userRateLimiter, rateLimitExempt = nil, nil, nil })
+	router := setupRoutes()
+	do := func(addr, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = addr + ":5000"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// One hold for bob, then he is over his limit whatever address he uses
+	if w := do("192.0.2.1", http.MethodPost, "/api/seats/select", `{"seat_id":"A1","user_id":"bob"}`); w.Code != http.StatusOK {
+		t.Fatalf("first select = %d %s", w.Code, w.Body)
+	}
+	w := do("192.0.2.2", http.MethodPost, "/api/seats/select", `{"seat_id":"A2","user_id":"bob"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("select over the user limit = %d, Retry-After %q, want 429 after 60s", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// The first address has two requests left
+	for i := 0; i < 2; i++ {
+		if w := do("192.0.2.1", http.MethodGet, "/api/seats/A1", ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the address limit = %d", i+1, w.Code)
+		}
+	}
+	if w := do("192.0.2.1", http.MethodGet, "/api/seats/A1", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the address limit = %d, Retry-After %q, want 429", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Edges are exempt, and routes outside the seat API are not limited
+	for i := 0; i < 5; i++ {
+		if w := do("10.1.2.3", http.MethodPost, "/api/seats/select", `{"seat_id":"B1","user_id":"bob"}`); w.Code == http.StatusTooManyRequests {
+			t.Fatal("exempt address was rate limited")
+		}
+	}
+	if w := do("192.0.2.1", http.MethodGet, "/api/event", ""); w.Code == http.StatusTooManyRequests {
+		t.Error("event route was rate limited")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long a readiness probe waits for Redis
+const readinessRedisTimeout = 1 * time.Second
+
+// readiness gates /ready on Redis and NATS answering, the venue existing and
+// the seat command subscriptions being in place
+var readiness = shared.NewReadiness()
+
+var errNATSDisconnected = errors.New("disconnected")
+
+func init() {
+	readiness.Check("redis", func() error {
+		probeCtx, cancel := context.WithTimeout(ctx, readinessRedisTimeout)
+		defer cancel()
+		return redisClient.Ping(probeCtx).Err()
+	})
+	readiness.Check("nats", func() error {
+		// EVENT_BUS=redis runs without NATS
+		if natsConn != nil && !natsConn.IsConnected() {
+			return errNATSDisconnected
+		}
+		return nil
+	})
+}
+
+// handleLive reports that the process is up, whatever its dependencies are doing
+func handleLive(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReady reports whether the service should be sent requests, with the
+// state of each check
+func handleReady(c *gin.Context) {
+	report := readiness.Report()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
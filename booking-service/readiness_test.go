@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestReadyFollowsRedisWhileLiveDoesNot(t *testing.T) {
+	mr := newTestRedis(t)
+	router := setupRoutes()
+
+	probe := func(path string) (int, shared.ReadinessReport) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report shared.ReadinessReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	if code, report := probe("/ready"); code != http.StatusOK || !report.Ready {
+		t.Fatalf("/ready = %d %+v, want 200 and ready", code, report)
+	}
+
+	mr.Close()
+	if code, report := probe("/ready"); code != http.StatusServiceUnavailable || report.Checks["redis"] == "ok" {
+		t.Errorf("/ready with Redis down = %d %+v, want 503 failing on redis", code, report)
+	}
+	if code, _ := probe("/live"); code != http.StatusOK {
+		t.Errorf("/live with Redis down = %d, want 200", code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A4 page size and margins in PDF points
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+	pdfAmountX    = 430 // where the amount column starts
+)
+
+// pdfText is one run of text placed on the page
+type pdfText struct {
+	x, y int
+	size int
+	bold bool
+	text string
+}
+
+// renderReceiptPDF lays a receipt out as a one-page PDF, with times in the
+// event's timezone
+func renderReceiptPDF(r *Receipt) []byte {
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	var texts []pdfText
+	y := pdfPageHeight - pdfMargin
+	line := func(size int, bold bool, left, right string) {
+		y -= size + 6
+		texts = append(texts, pdfText{x: pdfMargin, y: y, size: size, bold: bold, text: left})
+		if right != "" {
+			texts = append(texts, pdfText{x: pdfAmountX, y: y, size: size, bold: bold, text: right})
+		}
+	}
+
+	title := "Receipt"
+	if r.EventName != "" {
+		title = r.EventName + " - receipt"
+	}
+	line(18, true, title, "")
+	if r.Organizer != "" {
+		line(11, false, r.Organizer, "")
+	}
+	y -= 10
+	line(10, false, "Receipt number: "+r.Number, "")
+	line(10, false, "Order: "+r.OrderID, "")
+	line(10, false, "Ordered: "+r.OrderedAt.In(loc).Format("2 Jan 2006 15:04 MST"), "")
+	line(10, false, "Issued: "+r.IssuedAt.In(loc).Format("2 Jan 2006 15:04 MST"), "")
+	if r.TicketCode != "" {
+		line(10, false, "Ticket code: "+r.TicketCode, "")
+	}
+
+	y -= 14
+	line(11, true, "Item", "Amount")
+	for _, item := range r.Lines {
+		line(11, false, item.Description, formatAmount(item.AmountCents, r.Currency))
+	}
+	y -= 8
+	line(11, false, "Subtotal", formatAmount(r.SubtotalCents, r.Currency))
+	line(12, true, "Total", formatAmount(r.TotalCents, r.Currency))
+
+	y -= 14
+	payment := "Payment: not yet received"
+	if r.Payment != nil {
+		payment = "Payment: " + r.Payment.Status
+		if r.Payment.Reference != "" {
+			payment += ", reference " + r.Payment.Reference
+		}
+	}
+	line(10, false, payment, "")
+
+	return buildPDF(texts)
+}
+
+// buildPDF writes a single-page PDF showing texts in Helvetica
+func buildPDF(texts []pdfText) []byte {
+	var content bytes.Buffer
+	for _, t := range texts {
+		font := "F1"
+		if t.bold {
+			font = "F2"
+		}
+		fmt.Fprintf(&content, "BT /%s %d Tf %d %d Td (%s) Tj ET\n", font, t.size, t.x, t.y, pdfEscape(t.text))
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>",
+			pdfPageWidth, pdfPageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// pdfEscape makes s safe inside a PDF string literal. The standard fonts only
+// cover Latin-1 reliably, so anything else is replaced.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0xff || (r >= 0x7f && r < 0xa0):
+			b.WriteByte('?')
+		case r > 0x7e:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
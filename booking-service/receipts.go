@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Receipt line item kinds. Amounts of discount lines are negative.
+const (
+	LineSeat     = "seat"
+	LineDiscount = "discount"
+	LineTax      = "tax"
+	LineFee      = "fee"
+)
+
+// Receipt is what a buyer is sent for an order: every charge as a line item
+// that adds up to the total, and the payment that settled it
+type Receipt struct {
+	Number        string         `json:"number"`
+	OrderID       string         `json:"order_id"`
+	UserID        string         `json:"user_id"`
+	EventName     string         `json:"event_name,omitempty"`
+	Organizer     string         `json:"organizer,omitempty"`
+	Currency      string         `json:"currency"`
+	Timezone      string         `json:"timezone"`
+	Lines         []LineItem     `json:"lines"`
+	SubtotalCents int64          `json:"subtotal_cents"` // seat lines less discounts
+	TotalCents    int64          `json:"total_cents"`    // everything, including taxes and fees
+	TicketCode    string         `json:"ticket_code,omitempty"`
+	Status        string         `json:"status"` // the order's fulfillment status
+	Payment       *PaymentStatus `json:"payment,omitempty"`
+	OrderedAt     time.Time      `json:"ordered_at"`
+	IssuedAt      time.Time      `json:"issued_at"`
+}
+
+// LineItem is one charge on a receipt
+type LineItem struct {
+	Kind        string `json:"kind"` // seat, discount, tax or fee
+	Description string `json:"description"`
+	SeatID      string `json:"seat_id,omitempty"`
+	Section     string `json:"section,omitempty"`
+	Tier        string `json:"tier,omitempty"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// BuildReceipt loads an order and the event it is for into a receipt
+func BuildReceipt(orderID string) (*Receipt, error) {
+	order, err := GetOrder(orderID)
+	if err != nil {
+		return nil, err
+	}
+	event, err := GetEventInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &Receipt{
+		Number:     receiptNumber(order.ID),
+		OrderID:    order.ID,
+		UserID:     order.UserID,
+		EventName:  event.Name,
+		Organizer:  event.Organizer,
+		Currency:   event.Currency,
+		Timezone:   event.Timezone,
+		TicketCode: order.TicketCode,
+		Status:     order.Status,
+		Payment:    order.Payment,
+		OrderedAt:  order.CreatedAt,
+		IssuedAt:   time.Now(),
+	}
+	receipt.add(LineItem{
+		Kind:        LineSeat,
+		Description: seatDescription(order),
+		SeatID:      order.SeatID,
+		Section:     order.Section,
+		Tier:        order.Tier,
+		AmountCents: order.PriceCents,
+	})
+	for _, charge := range order.Charges {
+		receipt.add(LineItem{Kind: charge.Kind, Description: charge.Name, AmountCents: charge.AmountCents})
+	}
+	return receipt, nil
+}
+
+// add appends a line item and keeps the subtotal and total in step
+func (r *Receipt) add(line LineItem) {
+	r.Lines = append(r.Lines, line)
+	switch line.Kind {
+	case LineSeat, LineDiscount:
+		r.SubtotalCents += line.AmountCents
+	}
+	r.TotalCents += line.AmountCents
+}
+
+// receiptNumber derives a short, stable receipt number from the order ID
+func receiptNumber(orderID string) string {
+	id := strings.ToUpper(strings.ReplaceAll(orderID, "-", ""))
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return "R-" + id
+}
+
+func seatDescription(order *Order) string {
+	desc := "Seat " + order.SeatID
+	if order.Section != "" {
+		desc += ", section " + order.Section
+	}
+	if order.Tier != "" {
+		desc += " (" + order.Tier + ")"
+	}
+	return desc
+}
+
+// Currencies without minor units; prices in them are whole amounts
+var zeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true, "VND": true, "CLP": true, "ISK": true}
+
+// formatAmount renders minor units of a currency for people, e.g. "EUR 12.50"
+func formatAmount(cents int64, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	if zeroDecimalCurrencies[currency] {
+		return fmt.Sprintf("%s %s%d", currency, sign, cents)
+	}
+	return fmt.Sprintf("%s %s%d.%02d", currency, sign, cents/100, cents%100)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestReceiptListsChargesAndPayment(t *testing.T) {
+	newTestRedis(t)
+	order := bookTestOrder(t)
+	if _, err := RecordPayment(PaymentCallback{OrderID: order.ID, Status: PaymentSucceeded, Reference: "pay_123"}); err != nil {
+		t.Fatalf("RecordPayment: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/"+order.ID+"/receipt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET receipt = %d: %s", w.Code, w.Body)
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(w.Body.Bytes(), &receipt); err != nil {
+		t.Fatalf("decode receipt: %v", err)
+	}
+	if len(receipt.Lines) != 1 || receipt.Lines[0].Kind != LineSeat || receipt.Lines[0].SeatID != order.SeatID {
+		t.Fatalf("lines = %+v, want the booked seat", receipt.Lines)
+	}
+	if receipt.TotalCents != order.PriceCents || receipt.SubtotalCents != order.PriceCents {
+		t.Errorf("totals = %d/%d, want %d", receipt.SubtotalCents, receipt.TotalCents, order.PriceCents)
+	}
+	if receipt.Payment == nil || receipt.Payment.Reference != "pay_123" {
+		t.Errorf("payment = %+v, want reference pay_123", receipt.Payment)
+	}
+	if receipt.Currency == "" || receipt.Number == "" {
+		t.Errorf("receipt = %+v, want a currency and number", receipt)
+	}
+
+	w = httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/missing/receipt", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("receipt for an unknown order = %d, want 404", w.Code)
+	}
+}
+
+func TestReceiptRendersAsPDF(t *testing.T) {
+	newTestRedis(t)
+	order := bookTestOrder(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/"+order.ID+"/receipt", nil)
+	req.Header.Set("Accept", "application/pdf")
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/pdf" {
+		t.Fatalf("GET receipt as PDF = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	pdf := w.Body.Bytes()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.Contains(pdf, []byte(receiptNumber(order.ID))) {
+		t.Fatal("response is not a PDF showing the receipt number")
+	}
+
+	// startxref must point at the cross-reference table
+	i := bytes.LastIndex(pdf, []byte("startxref\n"))
+	end := bytes.IndexByte(pdf[i+len("startxref\n"):], '\n')
+	offset, err := strconv.Atoi(string(pdf[i+len("startxref\n") : i+len("startxref\n")+end]))
+	if err != nil || !bytes.HasPrefix(pdf[offset:], []byte("xref")) {
+		t.Errorf("startxref offset %d does not point at the xref table", offset)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	for _, tc := range []struct {
+		cents    int64
+		currency string
+		want     string
+	}{
+		{1250, "EUR", "EUR 12.50"},
+		{-500, "USD", "USD -5.00"},
+		{3000, "JPY", "JPY 3000"},
+	} {
+		if got := formatAmount(tc.cents, tc.currency); got != tc.want {
+			t.Errorf("formatAmount(%d, %s) = %q, want %q", tc.cents, tc.currency, got, tc.want)
+		}
+	}
+}
+
+func TestPDFEscape(t *testing.T) {
+	if got := pdfEscape(`Café (VIP) \ 東京`); got != `Caf\351 \(VIP\) \\ ??` {
+		t.Errorf("pdfEscape = %q", got)
+	}
+}
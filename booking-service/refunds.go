@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Refund policies an event can be cancelled under
+const (
+	RefundPolicyFull      = "full"       // the order total, fees and taxes included
+	RefundPolicyFaceValue = "face_value" // the ticket price only
+)
+
+// Refund states of an order of a cancelled event
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+	RefundSkipped   = "skipped" // the payment failed, so there is nothing to give back
+)
+
+const (
+	// Attempts per refund before it is marked failed
+	refundAttempts = 3
+
+	// How long the worker blocks waiting for queued refunds
+	refundPoll = 5 * time.Second
+
+	// Defaults for REFUND_BATCH_SIZE and REFUND_BATCH_INTERVAL
+	defaultRefundBatchSize     = 25
+	defaultRefundBatchInterval = time.Second
+	maxRefundBatchSize         = 1000
+)
+
+var (
+	errEventCancelled    = shared.NewError(shared.ErrorCodeEventCancelled, "event is cancelled")
+	errEventNotCancelled = errors.New("event is not cancelled")
+)
+
+// Delay before the first retry of a refund, doubled on each retry
+var refundRetryDelay = 1 * time.Second
+
+// refundProvider is the payment provider's refund hook. It is nil when
+// REFUND_URL is not set, and refunds are then recorded without contacting
+// anyone, which only suits development against a fake provider.
+var refundProvider RefundProvider
+
+// RefundStatus is the refund of one order. Like PaymentStatus it is kept apart
+// from the order so the refund worker never races the fulfillment worker's saves.
+type RefundStatus struct {
+	Status      string    `json:"status"`
+	AmountCents int64     `json:"amount_cents"`
+	Reference   string    `json:"reference,omitempty"` // the provider's ID for the refund
+	Attempts    int       `json:"attempts,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Cancellation describes a cancelled event and how its refunds are going
+type Cancellation struct {
+	EventID       string    `json:"event_id"`
+	Policy        string    `json:"policy"`
+	Reason        string    `json:"reason,omitempty"`
+	CancelledAt   time.Time `json:"cancelled_at"`
+	Orders        int       `json:"orders"` // orders of the event when it was cancelled
+	Pending       int       `json:"pending"`
+	Refunded      int       `json:"refunded"`
+	Failed        int       `json:"failed"`
+	Skipped       int       `json:"skipped"`
+	RefundedCents int64     `json:"refunded_cents"`
+	Done          bool      `json:"done"` // no refund is pending; failed ones can be retried
+}
+
+// RefundRequest is what the refund hook is asked to give back
+type RefundRequest struct {
+	OrderID          string `json:"order_id"`
+	PaymentReference string `json:"payment_reference,omitempty"`
+	AmountCents      int64  `json:"amount_cents"`
+	Currency         string `json:"currency"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// RefundProvider gives a buyer's money back
+type RefundProvider interface {
+	// Refund returns the provider's reference for the refund
+	Refund(req RefundRequest) (string, error)
+}
+
+// httpRefundProvider POSTs refund requests to the payment provider, signed like
+// its payment callbacks
+type httpRefundProvider struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (p *httpRefundProvider) Refund(req RefundRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.secret != "" {
+		mac := hmac.New(sha256.New, []byte(p.secret))
+		mac.Write(body)
+		httpReq.Header.Set(HeaderPaymentSignature, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("refund provider returned %s", resp.Status)
+	}
+
+	var result struct {
+		Reference string `json:"reference"`
+	}
+	// The reference is informational; a 2xx without one is still a refund
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result.Reference, nil
+}
+
+// refundProviderFromEnv returns the refund hook at REFUND_URL, or nil without one
+func refundProviderFromEnv() RefundProvider {
+	url := os.Getenv("REFUND_URL")
+	if url == "" {
+		return nil
+	}
+	return &httpRefundProvider{
+		url:    url,
+		secret: os.Getenv("PAYMENT_WEBHOOK_SECRET"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// refundBatchFromEnv reads REFUND_BATCH_SIZE, the refunds issued per batch, and
+// REFUND_BATCH_INTERVAL, a duration such as 500ms to wait between batches
+func refundBatchFromEnv() (int, time.Duration, error) {
+	size, interval := defaultRefundBatchSize, defaultRefundBatchInterval
+	if env := os.Getenv("REFUND_BATCH_SIZE"); env != "" {
+		n, err := strconv.Atoi(env)
+		if err != nil || n < 1 || n > maxRefundBatchSize {
+			return 0, 0, fmt.Errorf("REFUND_BATCH_SIZE must be from 1 to %d, got %q", maxRefundBatchSize, env)
+		}
+		size = n
+	}
+	if env := os.Getenv("REFUND_BATCH_INTERVAL"); env != "" {
+		d, err := time.ParseDuration(env)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("REFUND_BATCH_INTERVAL must be a non-negative duration, got %q", env)
+		}
+		interval = d
+	}
+	return size, interval, nil
+}
+
+// refundAmount is what policy gives back for an order
+func refundAmount(order *Order, policy string) int64 {
+	if policy == RefundPolicyFaceValue || order.TotalCents == 0 {
+		return order.PriceCents
+	}
+	return order.TotalCents
+}
+
+// CancelEvent calls the event off: no more seats can be booked, every
+// order moves to refund-pending and is queued for the refund worker, and the
+// buyers are told. An event can only be cancelled once.
+func CancelEvent(policy, reason string) (*Cancellation, error) {
+	if policy == "" {
+		policy = RefundPolicyFull
+	}
+	if policy != RefundPolicyFull && policy != RefundPolicyFaceValue {
+		return nil, fmt.Errorf("policy must be %s or %s", RefundPolicyFull, RefundPolicyFaceValue)
+	}
+
+	event, err := GetEventInfo()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	key := fmt.Sprintf(shared.RedisKeyCancellation, event.ID)
+	claimed, err := redisClient.HSetNX(ctx, key, "cancelled_at", now.Unix()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, errEventCancelled
+	}
+
+	// Stop bookings before listing the orders, so none are missed
+	event.CancelledAt = now.Unix()
+	if err := saveEventInfo(event); err != nil {
+		return nil, err
+	}
+
+	orders, err := listOrders()
+	if err != nil {
+		return nil, err
+	}
+	payments, err := redisClient.HGetAll(ctx, shared.RedisKeyPayments).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := 0
+	queued := make([]interface{}, 0, len(orders))
+	pipe := redisClient.TxPipeline()
+	for _, order := range orders {
+		status := RefundStatus{Status: RefundPending, AmountCents: refundAmount(order, policy), UpdatedAt: now}
+		var payment PaymentStatus
+		if json.Unmarshal([]byte(payments[order.ID]), &payment) == nil && payment.Status == PaymentFailed {
+			status.Status, status.AmountCents = RefundSkipped, 0
+			skipped++
+		} else {
+			queued = append(queued, order.ID)
+		}
+		statusJSON, err := json.Marshal(status)
+		if err != nil {
+			return nil, err
+		}
+		pipe.HSet(ctx, shared.RedisKeyRefunds, order.ID, statusJSON)
+	}
+	pipe.HSet(ctx, key, "policy", policy, "reason", reason, "orders", len(orders), "skipped", skipped)
+	if len(queued) > 0 {
+		pipe.RPush(ctx, shared.RedisKeyRefundQueue, queued...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	slog.Warn("Event cancelled", shared.LogKeyComponent, "admin", "event_id", event.ID, "policy", policy, "orders", len(orders), "refunds_queued", len(queued))
+	notifyCancellation(orders, policy, reason)
+	return GetCancellation()
+}
+
+// GetCancellation reports on the event's cancellation and refunds
+func GetCancellation() (*Cancellation, error) {
+	fields, err := redisClient.HGetAll(ctx, fmt.Sprintf(shared.RedisKeyCancellation, shared.DefaultEventID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errEventNotCancelled
+	}
+
+	count := func(name string) int {
+		n, _ := strconv.Atoi(fields[name])
+		return n
+	}
+	cancelledAt, _ := strconv.ParseInt(fields["cancelled_at"], 10, 64)
+	refundedCents, _ := strconv.ParseInt(fields["refunded_cents"], 10, 64)
+	c := &Cancellation{
+		EventID:       shared.DefaultEventID,
+		Policy:        fields["policy"],
+		Reason:        fields["reason"],
+		CancelledAt:   time.Unix(cancelledAt, 0).UTC(),
+		Orders:        count("orders"),
+		Refunded:      count("refunded"),
+		Failed:        count("failed"),
+		Skipped:       count("skipped"),
+		RefundedCents: refundedCents,
+	}
+	c.Pending = max(c.Orders-c.Refunded-c.Failed-c.Skipped, 0)
+	c.Done = c.Pending == 0
+	return c, nil
+}
+
+// RetryFailedRefunds queues every failed refund again and returns how many
+func RetryFailedRefunds() (int, error) {
+	if _, err := GetCancellation(); err != nil {
+		return 0, err
+	}
+	refunds, err := redisClient.HGetAll(ctx, shared.RedisKeyRefunds).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	retried := 0
+	key := fmt.Sprintf(shared.RedisKeyCancellation, shared.DefaultEventID)
+	for orderID, statusJSON := range refunds {
+		var status RefundStatus
+		if err := json.Unmarshal([]byte(statusJSON), &status); err != nil || status.Status != RefundFailed {
+			continue
+		}
+		status.Status = RefundPending
+		status.UpdatedAt = time.Now()
+		updated, err := json.Marshal(status)
+		if err != nil {
+			return retried, err
+		}
+		pipe := redisClient.TxPipeline()
+		pipe.HSet(ctx, shared.RedisKeyRefunds, orderID, updated)
+		pipe.HIncrBy(ctx, key, "failed", -1)
+		pipe.RPush(ctx, shared.RedisKeyRefundQueue, orderID)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return retried, err
+		}
+		retried++
+	}
+	slog.Info("Retrying failed refunds", shared.LogKeyComponent, "admin", "refunds", retried)
+	return retried, nil
+}
+
+// listOrders loads every order
+func listOrders() ([]*Order, error) {
+	stored, err := redisClient.HGetAll(ctx, shared.RedisKeyOrders).Result()
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]*Order, 0, len(stored))
+	for id, orderJSON := range stored {
+		var order Order
+		if err := json.Unmarshal([]byte(orderJSON), &order); err != nil {
+			slog.Warn("Skipping unreadable order", "order_id", id, shared.ErrAttr(err))
+			continue
+		}
+		orders = append(orders, &order)
+	}
+	return orders, nil
+}
+
+// loadRefundStatus returns an order's refund status, or nil if its event was
+// never cancelled
+func loadRefundStatus(client redis.Cmdable, orderID string) (*RefundStatus, error) {
+	statusJSON, err := client.HGet(ctx, shared.RedisKeyRefunds, orderID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var status RefundStatus
+	if err := json.Unmarshal([]byte(statusJSON), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StartRefundWorker issues queued refunds in batches of batchSize, pausing for
+// interval between batches so a large cancellation does not flood the provider
+func StartRefundWorker(batchSize int, interval time.Duration) {
+	go func() {
+		for {
+			result, err := redisClient.BLPop(context.Background(), refundPoll, shared.RedisKeyRefundQueue).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				slog.Error("Failed to read refund queue", shared.ErrAttr(err))
+				time.Sleep(refundRetryDelay)
+				continue
+			}
+
+			batch := []string{result[1]}
+			if batchSize > 1 {
+				more, err := redisClient.LPopCount(context.Background(), shared.RedisKeyRefundQueue, batchSize-1).Result()
+				if err != nil && err != redis.Nil {
+					slog.Error("Failed to read refund queue", shared.ErrAttr(err))
+				}
+				batch = append(batch, more...)
+			}
+			for _, orderID := range batch {
+				refundOrder(orderID)
+			}
+			time.Sleep(interval)
+		}
+	}()
+	slog.Info("Refund worker started", "batch_size", batchSize, "batch_interval", interval)
+}
+
+// refundOrder issues a pending refund, retrying failures, then records the
+// outcome and tells the buyer. Orders whose refund is no longer pending, such
+// as ones queued twice, are left alone.
+func refundOrder(orderID string) {
+	order, err := GetOrder(orderID)
+	if err != nil {
+		slog.Error("Failed to load order for refund", "order_id", orderID, shared.ErrAttr(err))
+		return
+	}
+	if order.Refund == nil || order.Refund.Status != RefundPending {
+		return
+	}
+	event, err := GetEventInfo()
+	if err != nil {
+		slog.Error("Failed to read event for refund", "order_id", orderID, shared.ErrAttr(err))
+		return
+	}
+	cancellation, err := GetCancellation()
+	if err != nil {
+		slog.Error("Failed to read cancellation for refund", "order_id", orderID, shared.ErrAttr(err))
+		return
+	}
+
+	req := RefundRequest{
+		OrderID:     order.ID,
+		AmountCents: order.Refund.AmountCents,
+		Currency:    event.Currency,
+		Reason:      cancellation.Reason,
+	}
+	if order.Payment != nil {
+		req.PaymentReference = order.Payment.Reference
+	}
+
+	status := *order.Refund
+	delay := refundRetryDelay
+	for attempt := 1; attempt <= refundAttempts; attempt++ {
+		status.Attempts++
+		err := issueRefund(req, &status)
+		if err == nil {
+			status.Status = RefundSucceeded
+			status.LastError = ""
+			break
+		}
+
+		status.Status = RefundFailed
+		status.LastError = err.Error()
+		slog.Warn("Refund failed", "order_id", order.ID, "attempt", attempt, "max_attempts", refundAttempts, shared.ErrAttr(err))
+		if attempt < refundAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	status.UpdatedAt = time.Now()
+
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		slog.Error("Failed to encode refund", "order_id", order.ID, shared.ErrAttr(err))
+		return
+	}
+	key := fmt.Sprintf(shared.RedisKeyCancellation, event.ID)
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, shared.RedisKeyRefunds, order.ID, statusJSON)
+	if status.Status == RefundSucceeded {
+		pipe.HIncrBy(ctx, key, "refunded", 1)
+		pipe.HIncrBy(ctx, key, "refunded_cents", status.AmountCents)
+	} else {
+		pipe.HIncrBy(ctx, key, "failed", 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("Failed to record refund", "order_id", order.ID, shared.ErrAttr(err))
+		return
+	}
+
+	slog.Info("Refund settled", "order_id", order.ID, shared.LogKeyUserID, order.UserID, "status", status.Status, "amount_cents", status.AmountCents)
+	notifyRefund(order, status)
+}
+
+// issueRefund asks the provider for the refund, noting its reference
+func issueRefund(req RefundRequest, status *RefundStatus) error {
+	if refundProvider == nil || req.AmountCents == 0 {
+		return nil
+	}
+	reference, err := refundProvider.Refund(req)
+	if err != nil {
+		return err
+	}
+	status.Reference = reference
+	return nil
+}
+
+// notifyCancellation tells each buyer, live and through the webhooks, that the
+// event is off and what they will get back
+func notifyCancellation(orders []*Order, policy, reason string) {
+	byUser := make(map[string][]map[string]interface{})
+	for _, order := range orders {
+		byUser[order.UserID] = append(byUser[order.UserID], map[string]interface{}{
+			"order_id":     order.ID,
+			"seat_id":      order.SeatID,
+			"refund_cents": refundAmount(order, policy),
+		})
+		webhooks.enqueue(shared.SeatEvent{
+			Type:      "event_cancelled",
+			SeatID:    order.SeatID,
+			UserID:    order.UserID,
+			Status:    shared.SeatBooked,
+			Timestamp: time.Now(),
+		})
+	}
+
+	for userID, userOrders := range byUser {
+		err := notifyUser(userID, shared.MessageTypeEventCancelled, map[string]interface{}{
+			"event_id":      shared.DefaultEventID,
+			"reason":        reason,
+			"refund_policy": policy,
+			"orders":        userOrders,
+		})
+		if err != nil && err != errUserOffline {
+			slog.Warn("Failed to push event cancellation", shared.LogKeyUserID, userID, shared.ErrAttr(err))
+		}
+	}
+}
+
+// notifyRefund tells the buyer, live and through the webhooks, how their refund went
+func notifyRefund(order *Order, status RefundStatus) {
+	eventType, msgType := "order_refunded", shared.MessageTypeRefundSucceeded
+	if status.Status != RefundSucceeded {
+		eventType, msgType = "order_refund_failed", shared.MessageTypeRefundFailed
+	}
+	webhooks.enqueue(shared.SeatEvent{
+		Type:      eventType,
+		SeatID:    order.SeatID,
+		UserID:    order.UserID,
+		Status:    shared.SeatBooked,
+		Timestamp: status.UpdatedAt,
+	})
+
+	// The buyer gets the outcome, not the provider's error
+	err := notifyUser(order.UserID, msgType, map[string]interface{}{
+		"order_id":     order.ID,
+		"seat_id":      order.SeatID,
+		"status":       status.Status,
+		"amount_cents": status.AmountCents,
+	})
+	if err != nil && err != errUserOffline {
+		slog.Warn("Failed to push refund status", "order_id", order.ID, shared.LogKeyUserID, order.UserID, shared.ErrAttr(err))
+	}
+}
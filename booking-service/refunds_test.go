@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// fakeRefunds refunds every order except those listed in failing
+type fakeRefunds struct {
+	failing  map[string]bool
+	refunded []RefundRequest
+}
+
+func (f *fakeRefunds) Refund(req RefundRequest) (string, error) {
+	if f.failing[req.OrderID] {
+		return "", errors.New("card expired")
+	}
+	f.refunded = append(f.refunded, req)
+	return "re_" + req.OrderID, nil
+}
+
+// drainRefunds issues every queued refund, as the worker would
+func drainRefunds(t *testing.T) {
+	t.Helper()
+	for {
+		orderID, err := redisClient.LPop(ctx, shared.RedisKeyRefundQueue).Result()
+		if err != nil {
+			return
+		}
+		refundOrder(orderID)
+	}
+}
+
+func TestCancelEventRefundsEveryOrder(t *testing.T) {
+	newTestRedis(t)
+	provider := &fakeRefunds{failing: map[string]bool{}}
+	originalProvider, originalDelay := refundProvider, refundRetryDelay
+	refundProvider, refundRetryDelay = provider, time.Millisecond
+	t.Cleanup(func() { refundProvider, refundRetryDelay = originalProvider, originalDelay })
+
+	paid := bookTestOrder(t)
+	unlucky, err := createOrder(&shared.Seat{ID: "B1", PriceCents: 4000}, "user-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	declined, err := createOrder(&shared.Seat{ID: "C1", PriceCents: 4000}, "user-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecordPayment(PaymentCallback{OrderID: declined.ID, Status: PaymentFailed}); err != nil {
+		t.Fatal(err)
+	}
+	provider.failing[unlucky.ID] = true
+
+	cancellation, err := CancelEvent(RefundPolicyFaceValue, "storm")
+	if err != nil {
+		t.Fatalf("CancelEvent: %v", err)
+	}
+	if cancellation.Orders != 3 || cancellation.Pending != 2 || cancellation.Skipped != 1 || cancellation.Done {
+		t.Fatalf("cancellation = %+v, want 2 of 3 orders pending and the declined one skipped", cancellation)
+	}
+	if order, _ := GetOrder(paid.ID); order.Refund == nil || order.Refund.Status != RefundPending {
+		t.Errorf("refund before the worker ran = %+v, want pending", order.Refund)
+	}
+
+	if _, err := CancelEvent(RefundPolicyFull, ""); err != errEventCancelled {
+		t.Errorf("second CancelEvent = %v, want %v", err, errEventCancelled)
+	}
+	seatID := shared.GetSeatID(1, 1)
+	if err := SelectSeat(ctx, seatID, "user-4", 0); err != nil {
+		t.Fatalf("SelectSeat: %v", err)
+	}
+	if _, err := BookSeat(ctx, seatID, "user-4", 0); err != errEventCancelled {
+		t.Errorf("BookSeat after cancellation = %v, want %v", err, errEventCancelled)
+	}
+	if event, err := UpdateEventInfo(shared.EventInfo{Currency: "EUR", Timezone: "UTC"}); err != nil || event.CancelledAt == 0 {
+		t.Errorf("editing the event's metadata uncancelled it: %+v, %v", event, err)
+	}
+
+	drainRefunds(t)
+	cancellation, _ = GetCancellation()
+	if cancellation.Refunded != 1 || cancellation.Failed != 1 || cancellation.RefundedCents != paid.PriceCents || !cancellation.Done {
+		t.Fatalf("cancellation after refunds = %+v, want one refunded and one failed", cancellation)
+	}
+	if order, _ := GetOrder(unlucky.ID); order.Refund.Status != RefundFailed || order.Refund.Attempts != refundAttempts {
+		t.Errorf("failed refund = %+v, want failed after %d attempts", order.Refund, refundAttempts)
+	}
+
+	delete(provider.failing, unlucky.ID)
+	if retried, err := RetryFailedRefunds(); err != nil || retried != 1 {
+		t.Fatalf("RetryFailedRefunds = %d, %v, want 1", retried, err)
+	}
+	drainRefunds(t)
+	cancellation, _ = GetCancellation()
+	if cancellation.Refunded != 2 || cancellation.Failed != 0 || cancellation.RefundedCents != paid.PriceCents+4000 {
+		t.Errorf("cancellation after retry = %+v, want both refunded", cancellation)
+	}
+	if order, _ := GetOrder(unlucky.ID); order.Refund.Reference != "re_"+unlucky.ID {
+		t.Errorf("refund = %+v, want the provider's reference", order.Refund)
+	}
+	if len(provider.refunded) != 2 {
+		t.Errorf("provider refunded %d orders, want 2 and never the declined one", len(provider.refunded))
+	}
+}
+
+func TestHTTPRefundProviderSignsRequests(t *testing.T) {
+	var got RefundRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		if r.Header.Get(HeaderPaymentSignature) != hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &got)
+		w.Write([]byte(`{"reference":"re_1"}`))
+	}))
+	defer server.Close()
+	t.Setenv("REFUND_URL", server.URL)
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "s3cret")
+
+	reference, err := refundProviderFromEnv().Refund(RefundRequest{OrderID: "o1", AmountCents: 5000, Currency: "EUR"})
+	if err != nil || reference != "re_1" {
+		t.Fatalf("Refund = %q, %v", reference, err)
+	}
+	if got.OrderID != "o1" || got.AmountCents != 5000 {
+		t.Errorf("provider got %+v", got)
+	}
+
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "wrong")
+	if _, err := refundProviderFromEnv().Refund(RefundRequest{OrderID: "o1"}); err == nil {
+		t.Error("a rejected refund was reported as issued")
+	}
+}
+
+func TestCancelEventEndpoints(t *testing.T) {
+	newTestRedis(t)
+	router := adminRouter(t)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := do(http.MethodGet, "/api/admin/event/cancellation", ""); w.Code != http.StatusNotFound {
+		t.Errorf("progress before cancelling = %d, want 404", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/admin/event/cancel", `{"policy":"half"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("cancel with an unknown policy = %d, want 400", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/admin/event/cancel", `{"reason":"venue flooded"}`); w.Code != http.StatusOK {
+		t.Fatalf("cancel = %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/api/admin/event/cancel", `{}`); w.Code != http.StatusConflict {
+		t.Errorf("cancelling twice = %d, want 409", w.Code)
+	}
+
+	w := do(http.MethodGet, "/api/admin/event/cancellation", "")
+	var cancellation Cancellation
+	if err := json.Unmarshal(w.Body.Bytes(), &cancellation); err != nil {
+		t.Fatalf("decode progress: %v", err)
+	}
+	if cancellation.Policy != RefundPolicyFull || cancellation.Reason != "venue flooded" || !cancellation.Done {
+		t.Errorf("progress = %+v, want a full refund with nothing to do", cancellation)
+	}
+	if w := do(http.MethodGet, "/api/event", ""); !strings.Contains(w.Body.String(), `"cancelled_at"`) {
+		t.Errorf("event after cancelling: %s", w.Body)
+	}
+}
+
+func TestRefundBatchFromEnv(t *testing.T) {
+	t.Setenv("REFUND_BATCH_SIZE", "10")
+	t.Setenv("REFUND_BATCH_INTERVAL", "250ms")
+	size, interval, err := refundBatchFromEnv()
+	if err != nil || size != 10 || interval != 250*time.Millisecond {
+		t.Errorf("refundBatchFromEnv = %d, %s, %v", size, interval, err)
+	}
+
+	t.Setenv("REFUND_BATCH_SIZE", "0")
+	if _, _, err := refundBatchFromEnv(); err == nil {
+		t.Error("a batch size of 0 was accepted")
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Resale blocks consign seats to resale partners for a while. An allocated
+// seat is booked by its block, as "resale:<block ID>", so the public cannot
+// take it. Partners report the seats they sell, which pass to the buyer, and
+// the block's return rules put the unsold ones back on sale. Allocations and
+// returns are seat events like any other, so edges update availability live.
+
+const resaleHolderPrefix = "resale:"
+
+// How often due return rules are applied
+const resaleReturnInterval = 15 * time.Second
+
+// HeaderPartnerKey carries the key a resale partner authenticates with, one of
+// RESALE_PARTNER_KEYS
+const HeaderPartnerKey = "X-Partner-Key"
+
+var (
+	errResaleBlockNotFound = errors.New("resale block not found")
+	errResaleSeatsTaken    = errors.New("seats are not available")
+	errPartnerKey          = errors.New("invalid partner key")
+	errPartnerKeysUnset    = errors.New("resale partner keys are not configured")
+)
+
+// ResaleReturnRule returns a block's unsold seats from At on, leaving the
+// partner at most Keep of them
+type ResaleReturnRule struct {
+	At   time.Time `json:"at"`
+	Keep int       `json:"keep"`
+}
+
+// ResaleBlock is a set of seats consigned to a resale partner
+type ResaleBlock struct {
+	ID          string             `json:"id"`
+	PartnerID   string             `json:"partner_id"`
+	SeatIDs     []string           `json:"seat_ids"`
+	ReturnRules []ResaleReturnRule `json:"return_rules"` // by time, keeping fewer seats each
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+// ResaleBlockReport is a block with what became of its seats
+type ResaleBlockReport struct {
+	ResaleBlock
+	Sold        []string          `json:"sold"`
+	Outstanding []string          `json:"outstanding"` // allocated, unsold and not yet returned
+	Returned    []string          `json:"returned"`
+	SoldCents   int64             `json:"sold_cents"` // face value of the sold seats
+	NextReturn  *ResaleReturnRule `json:"next_return,omitempty"`
+}
+
+// PartnerReport totals a resale partner's blocks
+type PartnerReport struct {
+	PartnerID   string              `json:"partner_id"`
+	Allocated   int                 `json:"allocated"`
+	Sold        int                 `json:"sold"`
+	Outstanding int                 `json:"outstanding"`
+	Returned    int                 `json:"returned"`
+	SoldCents   int64               `json:"sold_cents"`
+	Blocks      []ResaleBlockReport `json:"blocks"`
+}
+
+// holder is who the block's unsold seats are booked by
+func (b *ResaleBlock) holder() string {
+	return resaleHolderPrefix + b.ID
+}
+
+// keepAt returns how many unsold seats the partner may keep at now, or -1 if
+// no return rule is due yet
+func (b *ResaleBlock) keepAt(now time.Time) int {
+	keep := -1
+	for _, rule := range b.ReturnRules {
+		if !rule.At.After(now) {
+			keep = rule.Keep
+		}
+	}
+	return keep
+}
+
+func validateReturnRules(rules []ResaleReturnRule, seats int) error {
+	if len(rules) == 0 {
+		return errors.New("return_rules is required")
+	}
+	for i, rule := range rules {
+		if rule.At.IsZero() {
+			return errors.New("every return rule needs a time")
+		}
+		if rule.Keep < 0 || rule.Keep >= seats {
+			return fmt.Errorf("keep must be between 0 and %d", seats-1)
+		}
+		if i > 0 && (!rule.At.After(rules[i-1].At) || rule.Keep >= rules[i-1].Keep) {
+			return errors.New("return rules must be in time order, each keeping fewer seats")
+		}
+	}
+	return nil
+}
+
+// AllocateResaleBlock books the seats for a new block of partnerID's. Every
+// seat must be available; if any is not, none is allocated.
+func AllocateResaleBlock(partnerID string, seatIDs []string, rules []ResaleReturnRule) (*ResaleBlock, error) {
+	if partnerID == "" {
+		return nil, errors.New("partner_id is required")
+	}
+	seatIDs = dedupe(seatIDs)
+	if len(seatIDs) == 0 {
+		return nil, errors.New("seat_ids is required")
+	}
+	if err := validateReturnRules(rules, len(seatIDs)); err != nil {
+		return nil, err
+	}
+
+	seats, err := seatStore.GetSeats(seatIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(seats) != len(seatIDs) {
+		return nil, errSeatNotFound
+	}
+	var taken []string
+	for _, seat := range seats {
+		if seat.Status != shared.SeatAvailable {
+			taken = append(taken, seat.ID)
+		}
+	}
+	if len(taken) > 0 {
+		return nil, fmt.Errorf("%w: %s", errResaleSeatsTaken, strings.Join(taken, ", "))
+	}
+
+	block := &ResaleBlock{
+		ID:          uuid.NewString(),
+		PartnerID:   partnerID,
+		SeatIDs:     seatIDs,
+		ReturnRules: rules,
+		CreatedAt:   time.Now(),
+	}
+	if err := saveResaleBlock(block); err != nil {
+		return nil, err
+	}
+
+	for i := range seats {
+		seat := &seats[i]
+		seat.Status = shared.SeatBooked
+		seat.HeldBy = block.holder()
+		seat.ExpiresAt = 0
+		if err := casUpdateSeatAs(seatStore, seat, seat.Version, "allocated", block.holder(), adminActor, sourceAdmin); err != nil {
+			// Someone took the seat since it was read: undo the rest
+			returnResaleSeats(block, seats[:i])
+			redisClient.HDel(ctx, shared.RedisKeyResaleBlocks, block.ID)
+			if err == errSeatVersionStale {
+				return nil, fmt.Errorf("%w: %s", errResaleSeatsTaken, seat.ID)
+			}
+			return nil, err
+		}
+	}
+
+	slog.Info("Allocated resale block", shared.LogKeyComponent, "resale", "block_id", block.ID, "partner_id", partnerID, "seats", len(seatIDs))
+	return block, nil
+}
+
+func saveResaleBlock(block *ResaleBlock) error {
+	blockJSON, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(ctx, shared.RedisKeyResaleBlocks, block.ID, blockJSON).Err()
+}
+
+// GetResaleBlock returns a block with what became of its seats
+func GetResaleBlock(blockID string) (*ResaleBlockReport, error) {
+	blockJSON, err := redisClient.HGet(ctx, shared.RedisKeyResaleBlocks, blockID).Bytes()
+	if err == redis.Nil {
+		return nil, errResaleBlockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var block ResaleBlock
+	if err := json.Unmarshal(blockJSON, &block); err != nil {
+		return nil, err
+	}
+	return reportResaleBlock(block, time.Now())
+}
+
+// ListResaleBlocks returns every block, or partnerID's if set, oldest first
+func ListResaleBlocks(partnerID string) ([]ResaleBlockReport, error) {
+	stored, err := redisClient.HGetAll(ctx, shared.RedisKeyResaleBlocks).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []ResaleBlockReport{}
+	now := time.Now()
+	for id, blockJSON := range stored {
+		var block ResaleBlock
+		if err := json.Unmarshal([]byte(blockJSON), &block); err != nil {
+			slog.Warn("Skipping invalid resale block", shared.LogKeyComponent, "resale", "block_id", id, shared.ErrAttr(err))
+			continue
+		}
+		if partnerID != "" && block.PartnerID != partnerID {
+			continue
+		}
+		report, err := reportResaleBlock(block, now)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].CreatedAt.Before(reports[j].CreatedAt) })
+	return reports, nil
+}
+
+// GetPartnerReport totals partnerID's blocks
+func GetPartnerReport(partnerID string) (*PartnerReport, error) {
+	blocks, err := ListResaleBlocks(partnerID)
+	if err != nil {
+		return nil, err
+	}
+	report := &PartnerReport{PartnerID: partnerID, Blocks: blocks}
+	for _, block := range blocks {
+		report.Allocated += len(block.SeatIDs)
+		report.Sold += len(block.Sold)
+		report.Outstanding += len(block.Outstanding)
+		report.Returned += len(block.Returned)
+		report.SoldCents += block.SoldCents
+	}
+	return report, nil
+}
+
+// reportResaleBlock works out what became of a block's seats. Seats still
+// booked by the block are outstanding, sold ones were recorded as they were
+// sold, and the rest were returned.
+func reportResaleBlock(block ResaleBlock, now time.Time) (*ResaleBlockReport, error) {
+	seats, err := seatStore.GetSeats(block.SeatIDs)
+	if err != nil {
+		return nil, err
+	}
+	sold, err := redisClient.SMembers(ctx, fmt.Sprintf(shared.RedisKeyResaleSold, block.ID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	isSold := make(map[string]bool, len(sold))
+	for _, seatID := range sold {
+		isSold[seatID] = true
+	}
+
+	report := &ResaleBlockReport{ResaleBlock: block, Sold: []string{}, Outstanding: []string{}, Returned: []string{}}
+	for _, seat := range seats {
+		switch {
+		case isSold[seat.ID]:
+			report.Sold = append(report.Sold, seat.ID)
+			report.SoldCents += seat.PriceCents
+		case seat.Status == shared.SeatBooked && seat.HeldBy == block.holder():
+			report.Outstanding = append(report.Outstanding, seat.ID)
+		default:
+			report.Returned = append(report.Returned, seat.ID)
+		}
+	}
+	if len(report.Outstanding) > 0 {
+		for _, rule := range block.ReturnRules {
+			if rule.At.After(now) {
+				rule := rule
+				report.NextReturn = &rule
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+// resalePartner returns the partner a key belongs to. RESALE_PARTNER_KEYS lists
+// each partner's key, e.g. "ticketco=s3cret,resellr=0ther"; without it no
+// partner can authenticate.
+func resalePartner(key string) (string, error) {
+	keys := os.Getenv("RESALE_PARTNER_KEYS")
+	if keys == "" {
+		return "", errPartnerKeysUnset
+	}
+	partner := ""
+	for _, entry := range strings.Split(keys, ",") {
+		id, want, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && want != "" && subtle.ConstantTimeCompare([]byte(key), []byte(want)) == 1 {
+			partner = id
+		}
+	}
+	if partner == "" {
+		return "", errPartnerKey
+	}
+	return partner, nil
+}
+
+// SellResaleSeats records that the block's partner sold seats to userID, who
+// then holds the booking with an order for each seat, as if they had booked it.
+// Only outstanding seats can be sold, and every seat is checked before any is
+// sold.
+func SellResaleSeats(blockID, partnerID string, seatIDs []string, userID string) (*ResaleBlockReport, error) {
+	report, err := GetResaleBlock(blockID)
+	if err != nil {
+		return nil, err
+	}
+	if report.PartnerID != partnerID {
+		return nil, errResaleBlockNotFound
+	}
+	if userID == "" || strings.HasPrefix(userID, resaleHolderPrefix) {
+		return nil, errors.New("user_id of the buyer is required")
+	}
+
+	outstanding := make(map[string]bool, len(report.Outstanding))
+	for _, seatID := range report.Outstanding {
+		outstanding[seatID] = true
+	}
+	seatIDs = dedupe(seatIDs)
+	for _, seatID := range seatIDs {
+		if !outstanding[seatID] {
+			return nil, fmt.Errorf("seat %s is not outstanding in this block", seatID)
+		}
+	}
+
+	seats, err := seatStore.GetSeats(seatIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, seat := range seats {
+		if seat.Status != shared.SeatBooked || seat.HeldBy != report.holder() {
+			return nil, fmt.Errorf("seat %s is not outstanding in this block", seat.ID)
+		}
+	}
+
+	var sold []string
+	for i := range seats {
+		seat := &seats[i]
+		seat.HeldBy = userID
+		// Fails only if the seat was returned since it was checked
+		if err := casUpdateSeatAs(seatStore, seat, seat.Version, "booked", userID, partnerID, sourceResale); err != nil {
+			if len(sold) > 0 {
+				return nil, fmt.Errorf("failed to sell seat %s after selling %s: %w", seat.ID, strings.Join(sold, ", "), err)
+			}
+			return nil, fmt.Errorf("failed to sell seat %s: %w", seat.ID, err)
+		}
+		sold = append(sold, seat.ID)
+		if err := redisClient.SAdd(ctx, fmt.Sprintf(shared.RedisKeyResaleSold, blockID), seat.ID).Err(); err != nil {
+			slog.Error("Failed to record resale seat as sold", shared.LogKeyComponent, "resale", "block_id", blockID,
+				shared.LogKeySeatID, seat.ID, shared.ErrAttr(err))
+		}
+
+		// The sale stands even if its order cannot be created, as for a booking
+		order, err := createOrder(seat, userID)
+		if err != nil {
+			slog.Error("Failed to create order for resale seat", shared.LogKeyComponent, "resale", "block_id", blockID,
+				shared.LogKeySeatID, seat.ID, shared.LogKeyUserID, userID, shared.ErrAttr(err))
+		}
+		recordBooking(seat, userID, order)
+	}
+
+	slog.Info("Sold resale seats", shared.LogKeyComponent, "resale", "block_id", blockID, "partner_id", partnerID, "seats", len(seats))
+	return GetResaleBlock(blockID)
+}
+
+// ReturnResaleBlock returns every unsold seat of a block now and reports the
+// seats it put back on sale. With dryRun set it only reports what would change.
+func ReturnResaleBlock(blockID string, dryRun bool) (*AdminChangeReport, error) {
+	report, err := GetResaleBlock(blockID)
+	if err != nil {
+		return nil, err
+	}
+
+	returned := report.Outstanding
+	if !dryRun {
+		if returned, err = returnOutstanding(report, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := &AdminChangeReport{DryRun: dryRun, Changes: make([]SeatChange, 0, len(returned))}
+	for _, seatID := range returned {
+		changes.Changes = append(changes.Changes, SeatChange{
+			SeatID:     seatID,
+			FromStatus: shared.SeatBooked,
+			ToStatus:   shared.SeatAvailable,
+			HeldBy:     report.holder(),
+		})
+	}
+	changes.SeatsAffected = len(changes.Changes)
+	changes.BookingsCleared = len(changes.Changes)
+	return changes, nil
+}
+
+// returnOutstanding returns a block's outstanding seats beyond the first keep
+// and reports the ones it returned
+func returnOutstanding(report *ResaleBlockReport, keep int) ([]string, error) {
+	if len(report.Outstanding) <= keep {
+		return nil, nil
+	}
+	seats, err := seatStore.GetSeats(report.Outstanding[keep:])
+	if err != nil {
+		return nil, err
+	}
+	returned := returnResaleSeats(&report.ResaleBlock, seats)
+	slog.Info("Returned resale seats", shared.LogKeyComponent, "resale", "block_id", report.ID, "partner_id", report.PartnerID, "seats", len(returned), "kept", keep)
+	return returned, nil
+}
+
+// returnResaleSeats puts the seats still booked by the block back on sale and
+// returns the ones it did. A seat sold meanwhile fails its version check and
+// stays sold.
+func returnResaleSeats(block *ResaleBlock, seats []shared.Seat) []string {
+	var returned []string
+	for i := range seats {
+		seat := &seats[i]
+		if seat.Status != shared.SeatBooked || seat.HeldBy != block.holder() {
+			continue
+		}
+		seat.Status = shared.SeatAvailable
+		seat.HeldBy = ""
+		if err := casUpdateSeat(seatStore, seat, seat.Version, "returned", block.holder(), sourceResale); err != nil {
+			if err != errSeatVersionStale {
+				slog.Error("Failed to return resale seat", shared.LogKeyComponent, "resale", "block_id", block.ID, shared.LogKeySeatID, seat.ID, shared.ErrAttr(err))
+			}
+			continue
+		}
+		returned = append(returned, seat.ID)
+	}
+	return returned
+}
+
+// applyReturnRules returns the seats due back from every block by now
+func applyReturnRules(now time.Time) error {
+	blocks, err := ListResaleBlocks("")
+	if err != nil {
+		return err
+	}
+	for i := range blocks {
+		keep := blocks[i].keepAt(now)
+		if keep < 0 {
+			continue
+		}
+		if _, err := returnOutstanding(&blocks[i], keep); err != nil {
+			slog.Error("Failed to apply resale return rule", shared.LogKeyComponent, "resale", "block_id", blocks[i].ID, shared.ErrAttr(err))
+		}
+	}
+	return nil
+}
+
+// StartResaleReturns applies the blocks' return rules as they fall due. Every
+// instance may run it: a seat returned twice fails the second version check.
+func StartResaleReturns() {
+	go func() {
+		ticker := time.NewTicker(resaleReturnInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := applyReturnRules(now); err != nil {
+				slog.Error("Failed to apply resale return rules", shared.LogKeyComponent, "resale", shared.ErrAttr(err))
+			}
+		}
+	}()
+}
+
+// dedupe returns ids without repeats or empty strings, in their first order
+func dedupe(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestResaleBlocksReturnUnsoldSeatsByTheirRules(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		now := time.Now()
+		block, err := AllocateResaleBlock("ticketco", []string{"A1", "A2", "A3"}, []ResaleReturnRule{
+			{At: now.Add(time.Hour), Keep: 1},
+			{At: now.Add(2 * time.Hour), Keep: 0},
+		})
+		if err != nil {
+			t.Fatalf("AllocateResaleBlock: %v", err)
+		}
+		if seat := loadSeat(t, "A2"); seat.Status != shared.SeatBooked || seat.HeldBy != "resale:"+block.ID {
+			t.Fatalf("allocated seat = %+v, want booked by the block", seat)
+		}
+		if _, err := AllocateResaleBlock("other", []string{"A3", "A4"}, []ResaleReturnRule{{At: now, Keep: 0}}); !errors.Is(err, errResaleSeatsTaken) {
+			t.Errorf("allocating a consigned seat = %v, want errResaleSeatsTaken", err)
+		}
+		if seat := loadSeat(t, "A4"); seat.Status != shared.SeatAvailable {
+			t.Errorf("seat of a failed allocation = %+v, want available", seat)
+		}
+
+		if _, err := SellResaleSeats(block.ID, "ticketco", []string{"A1"}, "bob"); err != nil {
+			t.Fatalf("SellResaleSeats: %v", err)
+		}
+		if seat := loadSeat(t, "A1"); seat.Status != shared.SeatBooked || seat.HeldBy != "bob" {
+			t.Errorf("sold seat = %+v, want booked by bob", seat)
+		}
+		if _, err := SellResaleSeats(block.ID, "other", []string{"A2"}, "carol"); err != errResaleBlockNotFound {
+			t.Errorf("selling from another partner's block = %v, want errResaleBlockNotFound", err)
+		}
+
+		// The first rule leaves the partner one unsold seat
+		applyReturnRules(now.Add(time.Hour))
+		report, err := GetResaleBlock(block.ID)
+		if err != nil {
+			t.Fatalf("GetResaleBlock: %v", err)
+		}
+		if !reflect.DeepEqual(report.Sold, []string{"A1"}) || !reflect.DeepEqual(report.Outstanding, []string{"A2"}) || !reflect.DeepEqual(report.Returned, []string{"A3"}) {
+			t.Errorf("after the first rule: sold %v, outstanding %v, returned %v", report.Sold, report.Outstanding, report.Returned)
+		}
+		if later, _ := reportResaleBlock(report.ResaleBlock, now.Add(time.Hour)); later.NextReturn == nil || later.NextReturn.Keep != 0 {
+			t.Errorf("next return = %+v, want the second rule", later.NextReturn)
+		}
+		if seat := loadSeat(t, "A3"); seat.Status != shared.SeatAvailable {
+			t.Errorf("returned seat = %+v, want available", seat)
+		}
+
+		applyReturnRules(now.Add(2 * time.Hour))
+		partner, err := GetPartnerReport("ticketco")
+		if err != nil {
+			t.Fatalf("GetPartnerReport: %v", err)
+		}
+		if partner.Allocated != 3 || partner.Sold != 1 || partner.Outstanding != 0 || partner.Returned != 2 || len(partner.Blocks) != 1 {
+			t.Errorf("partner report = %+v, want 3 allocated, 1 sold and 2 returned", partner)
+		}
+		if partner.SoldCents != loadSeat(t, "A1").PriceCents {
+			t.Errorf("sold_cents = %d, want the price of A1", partner.SoldCents)
+		}
+
+		history, err := seatStore.SeatHistory("A3")
+		if err != nil {
+			t.Fatalf("SeatHistory: %v", err)
+		}
+		if len(history) < 2 || history[len(history)-2].Type != "allocated" || history[len(history)-1].Type != "returned" {
+			t.Errorf("A3 history = %+v, want allocated then returned", history)
+		}
+	})
+}
+
+func TestResaleBlockEndpoints(t *testing.T) {
+	newTestRedis(t)
+	router := adminRouter(t)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	at := time.Now().Add(time.Hour).Format(time.RFC3339)
+	if w := do(http.MethodPost, "/api/admin/resale/blocks", `{"partner_id":"ticketco","seat_ids":["A1","A2"],"return_rules":[{"at":"`+at+`","keep":0}]}`); w.Code != http.StatusCreated {
+		t.Fatalf("allocate = %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/api/admin/resale/blocks", `{"partner_id":"other","seat_ids":["A2"],"return_rules":[{"at":"`+at+`","keep":0}]}`); w.Code != http.StatusConflict {
+		t.Errorf("allocate a consigned seat = %d, want 409", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/admin/resale/blocks", `{"partner_id":"other","seat_ids":["A3"],"return_rules":[]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("allocate without return rules = %d, want 400", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/admin/resale/partners/ticketco/report", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"outstanding":2`) {
+		t.Errorf("partner report = %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/api/admin/resale/blocks/nope/return", ""); w.Code != http.StatusNotFound {
+		t.Errorf("return unknown block = %d, want 404", w.Code)
+	}
+}
+
+func TestResaleSalesNeedThePartnersKey(t *testing.T) {
+	newTestRedis(t)
+	block, err := AllocateResaleBlock("ticketco", []string{"A1", "A2"}, []ResaleReturnRule{{At: time.Now().Add(time.Hour)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := setupRoutes()
+	sell := func(key, body string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/resale/blocks/"+block.ID+"/sales", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(HeaderPartnerKey, key)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := sell("ticketco-key", `{"seat_ids":["A1"],"user_id":"alice"}`); code != http.StatusServiceUnavailable {
+		t.Errorf("without RESALE_PARTNER_KEYS = %d, want 503", code)
+	}
+
+	t.Setenv("RESALE_PARTNER_KEYS", "ticketco=ticketco-key, other=other-key")
+	tests := []struct {
+		name string
+		key  string
+		body string
+		want int
+	}{
+		{"no key", "", `{"seat_ids":["A1"],"user_id":"alice"}`, http.StatusUnauthorized},
+		{"unknown key", "guess", `{"seat_ids":["A1"],"user_id":"alice"}`, http.StatusUnauthorized},
+		{"another partner's key", "other-key", `{"seat_ids":["A1"],"user_id":"alice"}`, http.StatusNotFound},
+		{"claims another partner", "other-key", `{"partner_id":"ticketco","seat_ids":["A1"],"user_id":"alice"}`, http.StatusForbidden},
+		{"the partner", "ticketco-key", `{"partner_id":"ticketco","seat_ids":["A1"],"user_id":"alice"}`, http.StatusOK},
+		{"the partner, no partner_id", "ticketco-key", `{"seat_ids":["A2"],"user_id":"bob"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := sell(tt.key, tt.body); code != tt.want {
+			t.Errorf("%s: sale = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	for seatID, user := range map[string]string{"A1": "alice", "A2": "bob"} {
+		if seat := loadSeat(t, seatID); seat.Status != shared.SeatBooked || seat.HeldBy != user {
+			t.Errorf("sold seat %s = %+v, want booked by %s", seatID, seat, user)
+		}
+	}
+}
+
+func TestResaleSalesCheckEverySeatAndCreateOrders(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		block, err := AllocateResaleBlock("ticketco", []string{"A1", "A2", "A3"}, []ResaleReturnRule{{At: time.Now().Add(time.Hour)}})
+		if err != nil {
+			t.Fatalf("AllocateResaleBlock: %v", err)
+		}
+
+		// A2 was made available behind the block's back, so A1 must not be
+		// sold either
+		seat := loadSeat(t, "A2")
+		seat.Status, seat.HeldBy = shared.SeatAvailable, ""
+		if err := casUpdateSeat(seatStore, &seat, seat.Version, "released", "ops", sourceAdmin); err != nil {
+			t.Fatalf("release A2: %v", err)
+		}
+		if _, err := SellResaleSeats(block.ID, "ticketco", []string{"A1", "A2"}, "bob"); err == nil {
+			t.Fatal("selling a seat that is no longer outstanding succeeded")
+		}
+		if seat := loadSeat(t, "A1"); seat.HeldBy != "resale:"+block.ID {
+			t.Errorf("A1 after a refused sale = %+v, want still outstanding", seat)
+		}
+
+		if _, err := SellResaleSeats(block.ID, "ticketco", []string{"A1"}, "bob"); err != nil {
+			t.Fatalf("SellResaleSeats: %v", err)
+		}
+		orders, err := redisClient.HGetAll(ctx, shared.RedisKeyOrders).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var order Order
+		for _, raw := range orders {
+			json.Unmarshal([]byte(raw), &order)
+		}
+		if len(orders) != 1 || order.SeatID != "A1" || order.UserID != "bob" {
+			t.Errorf("orders = %v, want one for bob's A1", orders)
+		}
+
+		dryRun, err := ReturnResaleBlock(block.ID, true)
+		if err != nil {
+			t.Fatalf("dry-run ReturnResaleBlock: %v", err)
+		}
+		if !dryRun.DryRun || dryRun.SeatsAffected != 1 || dryRun.Changes[0].SeatID != "A3" {
+			t.Errorf("dry-run report = %+v, want A3", dryRun)
+		}
+		if seat := loadSeat(t, "A3"); seat.Status != shared.SeatBooked {
+			t.Errorf("A3 after a dry run = %+v, want still booked", seat)
+		}
+
+		returned, err := ReturnResaleBlock(block.ID, false)
+		if err != nil {
+			t.Fatalf("ReturnResaleBlock: %v", err)
+		}
+		if returned.DryRun || !reflect.DeepEqual(returned.Changes, dryRun.Changes) {
+			t.Errorf("return report = %+v, want what the dry run reported", returned)
+		}
+		if seat := loadSeat(t, "A3"); seat.Status != shared.SeatAvailable {
+			t.Errorf("A3 after the return = %+v, want available", seat)
+		}
+	})
+}
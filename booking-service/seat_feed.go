@@ -0,0 +1,161 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"concert-booking/shared"
+	"concert-booking/shared/seatfeed"
+)
+
+// seatFeedBuffer is how many seat events a WatchSeatEvents call may fall
+// behind by before it is ended
+const seatFeedBuffer = 1024
+
+// seatFeedServer serves the seat feed when GRPC_ADDR is set; stopped at shutdown
+var seatFeedServer *grpc.Server
+
+// seatFeed is the SeatFeed gRPC service: it fans the seat events on the event
+// bus out to every WatchSeatEvents call they match
+type seatFeed struct {
+	seatfeed.UnimplementedSeatFeedServer
+
+	mu       sync.Mutex
+	watchers map[*feedWatcher]bool
+}
+
+// feedWatcher is one WatchSeatEvents call. events is closed when the call fell
+// behind and was dropped from the feed.
+type feedWatcher struct {
+	eventID  string
+	sections map[string]bool
+	events   chan *seatfeed.SeatEvent
+}
+
+func newSeatFeed() *seatFeed {
+	return &seatFeed{watchers: make(map[*feedWatcher]bool)}
+}
+
+// StartSeatFeed serves the SeatFeed gRPC service on addr, fed from bus
+func StartSeatFeed(bus shared.EventBus, addr string) error {
+	feed := newSeatFeed()
+	if _, err := bus.Subscribe(shared.NATSTopicAllSeats, feed.publish); err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	seatFeedServer = grpc.NewServer()
+	seatfeed.RegisterSeatFeedServer(seatFeedServer, feed)
+	go func() {
+		if err := seatFeedServer.Serve(lis); err != nil {
+			slog.Error("Seat feed stopped", shared.ErrAttr(err))
+		}
+	}()
+	slog.Info("Seat feed started", "addr", lis.Addr().String())
+	return nil
+}
+
+func (f *seatFeed) WatchSeatEvents(req *seatfeed.WatchSeatEventsRequest, stream seatfeed.SeatFeed_WatchSeatEventsServer) error {
+	w := &feedWatcher{
+		eventID: req.GetEventId(),
+		events:  make(chan *seatfeed.SeatEvent, seatFeedBuffer),
+	}
+	if len(req.GetSections()) > 0 {
+		w.sections = make(map[string]bool, len(req.GetSections()))
+		for _, section := range req.GetSections() {
+			w.sections[section] = true
+		}
+	}
+
+	f.mu.Lock()
+	f.watchers[w] = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.watchers, w)
+		f.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-w.events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "fell behind the seat feed; watch again")
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publish hands a seat event from the bus to the watchers it matches,
+// dropping those whose buffer is full
+func (f *seatFeed) publish(subject string, data []byte) {
+	var event shared.SeatEvent
+	if err := shared.UnmarshalEvent(data, &event); err != nil {
+		slog.Error("Failed to parse seat event", shared.LogKeyComponent, "seat_feed", shared.ErrAttr(err))
+		return
+	}
+	eventID := event.EventID
+	if eventID == "" {
+		eventID = shared.SubjectEventID(subject)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range event.Unbatch() {
+		msg := feedEvent(eventID, e)
+		for w := range f.watchers {
+			if !w.matches(msg) {
+				continue
+			}
+			select {
+			case w.events <- msg:
+			default:
+				slog.Warn("Dropped a seat feed watcher that fell behind", "event_id", w.eventID)
+				delete(f.watchers, w)
+				close(w.events)
+			}
+		}
+	}
+}
+
+// matches reports whether the watcher asked for an event
+func (w *feedWatcher) matches(event *seatfeed.SeatEvent) bool {
+	if w.eventID != "" && w.eventID != event.EventId {
+		return false
+	}
+	return w.sections == nil || w.sections[event.Section]
+}
+
+// feedEvent is a seat event of eventID as the feed sends it
+func feedEvent(eventID string, e shared.SeatEvent) *seatfeed.SeatEvent {
+	msg := &seatfeed.SeatEvent{
+		EventId:     eventID,
+		Seq:         e.Seq,
+		Type:        e.Type,
+		SeatId:      e.SeatID,
+		Status:      e.Status.String(),
+		UserId:      e.UserID,
+		Actor:       e.Actor,
+		Source:      e.Source,
+		Version:     e.Version,
+		TimestampMs: e.Timestamp.UnixMilli(),
+		ExpiresAt:   e.ExpiresAt,
+	}
+	if e.Seat != nil {
+		msg.Section = e.Seat.Section
+	}
+	return msg
+}
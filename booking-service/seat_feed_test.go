@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"concert-booking/shared"
+	"concert-booking/shared/seatfeed"
+)
+
+// publishSeatEvent hands feed a seat event as the bus would deliver it
+func publishSeatEvent(t *testing.T, feed *seatFeed, subject string, event shared.SeatEvent) {
+	t.Helper()
+	data, err := shared.MarshalEvent(event, shared.WireFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.publish(subject, data)
+}
+
+func TestWatchSeatEventsFiltersByEventAndSection(t *testing.T) {
+	feed := newSeatFeed()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	seatfeed.RegisterSeatFeedServer(server, feed)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///seat-feed",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := seatfeed.NewSeatFeedClient(conn).WatchSeatEvents(ctx, &seatfeed.WatchSeatEventsRequest{
+		EventId:  shared.DefaultEventID,
+		Sections: []string{"floor"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		feed.mu.Lock()
+		watching := len(feed.watchers)
+		feed.mu.Unlock()
+		if watching == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the call never started watching")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	seat := func(id, section string) *shared.Seat { return &shared.Seat{ID: id, Section: section} }
+	held := func(id, section string) shared.SeatEvent {
+		return shared.SeatEvent{Type: "held", SeatID: id, UserID: "user-1", Status: shared.SeatHeld, Seat: seat(id, section)}
+	}
+	publishSeatEvent(t, feed, shared.SeatSubject(shared.DefaultEventID, "balcony", "B1", "held"), held("B1", "balcony"))
+	publishSeatEvent(t, feed, shared.SeatSubject("gala", "floor", "F9", "held"), held("F9", "floor"))
+	first := held("F1", "floor")
+	first.Seq = 7
+	publishSeatEvent(t, feed, shared.SeatSubject(shared.DefaultEventID, "floor", "F1", "held"), first)
+	publishSeatEvent(t, feed, shared.SeatBatchSubject(shared.DefaultEventID), shared.SeatEvent{
+		Type: shared.SeatEventReleasedBatch,
+		Events: []shared.SeatEvent{
+			{Type: "released", SeatID: "B2", Status: shared.SeatAvailable, Seat: seat("B2", "balcony")},
+			{Type: "released", SeatID: "F2", Status: shared.SeatAvailable, Seat: seat("F2", "floor")},
+		},
+	})
+
+	var got []string
+	for len(got) < 2 {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("after %v: %v", got, err)
+		}
+		if event.EventId != shared.DefaultEventID || event.Section != "floor" {
+			t.Errorf("got %v, which the call did not ask for", event)
+		}
+		got = append(got, event.Type+" "+event.SeatId+" "+event.Status)
+		if event.SeatId == "F1" && (event.Seq != 7 || event.UserId != "user-1") {
+			t.Errorf("F1 event = %v, want seq 7 for user-1", event)
+		}
+	}
+	if want := []string{"held F1 held", "released F2 available"}; got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestWatchersThatFallBehindAreDropped(t *testing.T) {
+	feed := newSeatFeed()
+	slow := &feedWatcher{events: make(chan *seatfeed.SeatEvent, 1)}
+	feed.watchers[slow] = true
+
+	event := shared.SeatEvent{Type: "held", SeatID: "A1", Status: shared.SeatHeld, Seat: &shared.Seat{ID: "A1", Section: "main"}}
+	subject := shared.SeatSubject(shared.DefaultEventID, "main", "A1", "held")
+	publishSeatEvent(t, feed, subject, event)
+	publishSeatEvent(t, feed, subject, event)
+
+	if feed.watchers[slow] {
+		t.Fatal("the watcher is still fed")
+	}
+	<-slow.events
+	if _, open := <-slow.events; open {
+		t.Error("the watcher's events were not closed")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Transitions kept per seat; older ones are trimmed
+const seatHistoryMaxLen = 1000
+
+// systemActor is the actor recorded for transitions nobody asked for, such as
+// a hold timing out
+const systemActor = "system"
+
+// SeatTransition is one entry of a seat's audit log: who moved it from which
+// state to which, and when
+type SeatTransition struct {
+	Seq        int64             `json:"seq"`
+	SeatID     string            `json:"seat_id"`
+	Type       string            `json:"type"` // held, booked, released, auto_released, allocated or returned
+	UserID     string            `json:"user_id,omitempty"`
+	Actor      string            `json:"actor"`
+	Source     string            `json:"source,omitempty"`
+	At         time.Time         `json:"at"`
+	Version    int64             `json:"version"`
+	FromStatus shared.SeatStatus `json:"from_status"`
+	FromHeldBy string            `json:"from_held_by,omitempty"`
+	ToStatus   shared.SeatStatus `json:"to_status"`
+	ToHeldBy   string            `json:"to_held_by,omitempty"`
+}
+
+// SeatHistory is the response of GET /api/admin/seats/:id/history
+type SeatHistory struct {
+	SeatID      string           `json:"seat_id"`
+	Transitions []SeatTransition `json:"transitions"`
+
+	// StateAt is the last transition at or before the ?at time, nil if the
+	// seat had not changed by then (or its history was trimmed)
+	StateAt *SeatTransition `json:"state_at,omitempty"`
+}
+
+// newSeatTransition builds an audit entry from the seat event queued with a
+// write and the seat as it was before the write
+func newSeatTransition(seq int64, eventJSON []byte, previous shared.Seat) (SeatTransition, error) {
+	var event shared.SeatEvent
+	if err := json.Unmarshal(eventJSON, &event); err != nil {
+		return SeatTransition{}, err
+	}
+
+	actor := event.UserID
+	switch {
+	case event.Actor != "":
+		actor = event.Actor
+	case event.Type == "auto_released":
+		actor = systemActor
+	}
+
+	transition := SeatTransition{
+		Seq:        seq,
+		SeatID:     event.SeatID,
+		Type:       event.Type,
+		UserID:     event.UserID,
+		Actor:      actor,
+		Source:     event.Source,
+		At:         event.Timestamp,
+		Version:    event.Version,
+		FromStatus: previous.Status,
+		FromHeldBy: previous.HeldBy,
+		ToStatus:   event.Status,
+	}
+	if event.Seat != nil {
+		transition.ToHeldBy = event.Seat.HeldBy
+	}
+	return transition, nil
+}
+
+// GetSeatHistory returns a seat's audit log. A non-zero at also resolves the
+// transition in effect at that moment.
+func GetSeatHistory(seatID string, at time.Time) (*SeatHistory, error) {
+	if _, err := seatStore.GetSeat(seatID); err != nil {
+		return nil, err
+	}
+
+	transitions, err := seatStore.SeatHistory(seatID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &SeatHistory{SeatID: seatID, Transitions: transitions}
+	if !at.IsZero() {
+		for i := range transitions {
+			if transitions[i].At.After(at) {
+				break
+			}
+			history.StateAt = &transitions[i]
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestSeatHistoryRecordsEveryTransition(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(2, 3)
+
+		if err := SelectSeat(ctx, seatID, "user-1", 0); err != nil {
+			t.Fatalf("SelectSeat user-1: %v", err)
+		}
+		if err := ReleaseSeat(ctx, seatID, "user-1", 0); err != nil {
+			t.Fatalf("ReleaseSeat: %v", err)
+		}
+		if err := SelectSeat(ctx, seatID, "user-2", 0); err != nil {
+			t.Fatalf("SelectSeat user-2: %v", err)
+		}
+		seat := loadSeat(t, seatID)
+		if err := autoReleaseSeat(seatStore, &seat); err != nil {
+			t.Fatalf("autoReleaseSeat: %v", err)
+		}
+		if err := SelectSeat(ctx, seatID, "user-3", 0); err != nil {
+			t.Fatalf("SelectSeat user-3: %v", err)
+		}
+		if _, err := BookSeat(ctx, seatID, "user-3", 0); err != nil {
+			t.Fatalf("BookSeat: %v", err)
+		}
+
+		history, err := GetSeatHistory(seatID, time.Time{})
+		if err != nil {
+			t.Fatalf("GetSeatHistory: %v", err)
+		}
+
+		want := []struct {
+			typ, actor, fromHeldBy, toHeldBy string
+			from, to                         shared.SeatStatus
+		}{
+			{"held", "user-1", "", "user-1", shared.SeatAvailable, shared.SeatHeld},
+			{"released", "user-1", "user-1", "", shared.SeatHeld, shared.SeatAvailable},
+			{"held", "user-2", "", "user-2", shared.SeatAvailable, shared.SeatHeld},
+			{"auto_released", systemActor, "user-2", "", shared.SeatHeld, shared.SeatAvailable},
+			{"held", "user-3", "", "user-3", shared.SeatAvailable, shared.SeatHeld},
+			{"booked", "user-3", "user-3", "user-3", shared.SeatHeld, shared.SeatBooked},
+		}
+		if len(history.Transitions) != len(want) {
+			t.Fatalf("got %d transitions, want %d: %+v", len(history.Transitions), len(want), history.Transitions)
+		}
+		for i, w := range want {
+			got := history.Transitions[i]
+			if got.Type != w.typ || got.Actor != w.actor || got.FromStatus != w.from || got.ToStatus != w.to ||
+				got.FromHeldBy != w.fromHeldBy || got.ToHeldBy != w.toHeldBy {
+				t.Errorf("transition %d = %+v, want %+v", i, got, w)
+			}
+			if i > 0 && got.Version != history.Transitions[i-1].Version+1 {
+				t.Errorf("transition %d has version %d after %d", i, got.Version, history.Transitions[i-1].Version)
+			}
+		}
+		if history.StateAt != nil {
+			t.Errorf("state_at set without ?at: %+v", history.StateAt)
+		}
+
+		// "Who had this seat at the time of the second hold?"
+		history, err = GetSeatHistory(seatID, history.Transitions[2].At)
+		if err != nil {
+			t.Fatalf("GetSeatHistory at: %v", err)
+		}
+		if history.StateAt == nil || history.StateAt.ToHeldBy != "user-2" {
+			t.Errorf("state_at = %+v, want held by user-2", history.StateAt)
+		}
+	})
+}
+
+func TestSeatHistoryOfUnknownSeat(t *testing.T) {
+	newTestRedis(t)
+
+	if _, err := GetSeatHistory("no-such-seat", time.Time{}); err != errSeatNotFound {
+		t.Fatalf("GetSeatHistory = %v, want errSeatNotFound", err)
+	}
+}
@@ -1,270 +1,323 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"time"
 
 	"concert-booking/shared"
+)
 
-	"github.com/go-redis/redis/v8"
+// Why a seat operation was refused, besides errHoldNotYours and
+// errSeatVersionStale
+var (
+	errSeatHeldByYou   = shared.NewError(shared.ErrorCodeSeatHeldByYou, "you already hold this seat")
+	errSeatHeldByOther = shared.NewError(shared.ErrorCodeSeatHeldByOther, "seat is already held by another user")
+	errSeatBooked      = shared.NewError(shared.ErrorCodeSeatBooked, "seat is already booked")
+	errSeatNotHeld     = shared.NewError(shared.ErrorCodeSeatNotHeld, "seat is not held")
+	errNotHolder       = shared.NewError(shared.ErrorCodeNotHolder, "you do not hold this seat")
+	errHoldExpired     = shared.NewError(shared.ErrorCodeHoldExpired, "your hold on this seat has expired")
 )
 
 func GetAllSeats() ([]shared.Seat, error) {
-	// Fetch all seats from Redis hash
-	seatMap, err := redisClient.HGetAll(ctx, shared.RedisKeyVenueSeats).Result()
+	return seatStore.AllSeats()
+}
+
+// GetVenueSnapshot returns every seat with the event sequence number they reflect
+func GetVenueSnapshot() (*shared.VenueSnapshot, error) {
+	// Read the sequence first: the listing reflects at least every event up to it
+	seq, err := seatStore.EventSeq()
+	if err != nil {
+		return nil, err
+	}
+
+	seats, err := GetAllSeats()
+	if err != nil {
+		return nil, err
+	}
+
+	// Branding is optional, so a snapshot without it beats no snapshot
+	event, err := GetEventInfo()
+	if err != nil {
+		slog.Warn("Failed to read event metadata for snapshot", shared.ErrAttr(err))
+	}
+	return &shared.VenueSnapshot{Seats: seats, Seq: seq, TakenAt: time.Now(), Event: event}, nil
+}
+
+// Most seats one batch-get may ask for
+const maxSeatBatchGet = 200
+
+// GetSeatBatch reads the seats with the given IDs in one round trip, noting
+// the IDs that match no seat. Repeated IDs are returned once.
+func GetSeatBatch(ctx context.Context, seatIDs []string) (*shared.SeatBatchGetResponse, error) {
+	unique := make([]string, 0, len(seatIDs))
+	seen := make(map[string]bool, len(seatIDs))
+	for _, id := range seatIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	store := seatStoreFor(ctx)
+	seq, err := store.EventSeq()
+	if err != nil {
+		return nil, err
+	}
+	seats, err := store.GetSeats(unique)
 	if err != nil {
 		return nil, err
 	}
 
-	seats := make([]shared.Seat, 0, len(seatMap))
-	for _, seatJSON := range seatMap {
-		var seat shared.Seat
-		if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
-			log.Printf("Error unmarshaling seat: %v", err)
-			continue
+	found := make(map[string]bool, len(seats))
+	for _, seat := range seats {
+		found[seat.ID] = true
+	}
+	batch := &shared.SeatBatchGetResponse{Seats: seats, Seq: seq}
+	for _, id := range unique {
+		if !found[id] {
+			batch.NotFound = append(batch.NotFound, id)
 		}
-		seats = append(seats, seat)
 	}
+	return batch, nil
+}
 
-	return seats, nil
+// checkEventOn fails once the event has been cancelled
+func checkEventOn() error {
+	event, err := GetEventInfo()
+	if err != nil {
+		return err
+	}
+	if event.CancelledAt != 0 {
+		return errEventCancelled
+	}
+	return nil
 }
 
-func SelectSeat(seatID, userID string) error {
-	// First, try to acquire atomic lock with 30 second TTL
-	lockKey := fmt.Sprintf(shared.RedisKeySeatLock, seatID)
-	success, err := redisClient.SetNX(ctx, lockKey, userID, shared.HoldDuration).Result()
+// SelectSeat holds a seat for userID. A non-zero expectedVersion rejects the
+// request if the seat has changed since the caller last saw it.
+func SelectSeat(ctx context.Context, seatID, userID string, expectedVersion int64) error {
+	store := seatStoreFor(ctx)
+
+	// Banned users may not hold seats, nor may users caught churning holds
+	// until their cooldown ends
+	if err := checkNotBanned(userID); err != nil {
+		return err
+	}
+	if err := checkHoldCooldown(userID); err != nil {
+		return err
+	}
+
+	// Holds already taken may still be booked while sales are paused
+	if err := checkSalesNotPaused(); err != nil {
+		return err
+	}
+
+	// Holds last a fixed time unless the event keeps them alive on activity
+	ttl := holdDuration()
+	if idle, maxHold, ok := holdKeepalive(); ok {
+		ttl = min(idle, maxHold)
+	}
+
+	// First, try to acquire atomic lock for the length of the hold
+	success, err := store.AcquireLock(seatID, userID, ttl)
 	if err != nil {
 		return err
 	}
 
 	if !success {
 		// Lock already exists, check who holds it
-		holder, _ := redisClient.Get(ctx, lockKey).Result()
+		holder, _ := store.LockHolder(seatID)
 		if holder == userID {
-			return errors.New("you already hold this seat")
+			return errSeatHeldByYou
 		}
-		return errors.New("seat is already held by another user")
+		return errSeatHeldByOther
 	}
 
 	// Lock acquired, now update seat status
-	seatJSON, err := redisClient.HGet(ctx, shared.RedisKeyVenueSeats, seatID).Result()
-	if err == redis.Nil {
-		// Seat doesn't exist, release lock
-		redisClient.Del(ctx, lockKey)
-		return errors.New("seat not found")
-	}
+	seat, err := store.GetSeat(seatID)
 	if err != nil {
-		// Error occurred, release lock
-		redisClient.Del(ctx, lockKey)
-		return err
-	}
-
-	var seat shared.Seat
-	if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
-		redisClient.Del(ctx, lockKey)
+		// Seat doesn't exist or can't be read, release lock
+		store.ReleaseLock(seatID)
 		return err
 	}
 
 	// Check if seat is already booked
 	if seat.Status == shared.SeatBooked {
-		redisClient.Del(ctx, lockKey)
-		return errors.New("seat is already booked")
+		store.ReleaseLock(seatID)
+		return errSeatBooked
+	}
+
+	if expectedVersion != 0 && seat.Version != expectedVersion {
+		store.ReleaseLock(seatID)
+		return errSeatVersionStale
 	}
 
 	// Update seat status to held
+	now := clock.Now()
+	expiresAt := now.Add(ttl)
 	seat.Status = shared.SeatHeld
 	seat.HeldBy = userID
-	seat.ExpiresAt = time.Now().Add(shared.HoldDuration).Unix()
+	seat.ExpiresAt = expiresAt.Unix()
+	seat.HeldAt = now.Unix()
 
-	updatedJSON, err := json.Marshal(seat)
-	if err != nil {
-		redisClient.Del(ctx, lockKey)
+	if err := casUpdateSeat(store, seat, seat.Version, "held", userID, sourceOf(ctx)); err != nil {
+		store.ReleaseLock(seatID)
 		return err
 	}
 
-	if err := redisClient.HSet(ctx, shared.RedisKeyVenueSeats, seatID, updatedJSON).Err(); err != nil {
-		redisClient.Del(ctx, lockKey)
-		return err
+	if shared.InvariantChecksEnabled() {
+		holder, err := store.LockHolder(seatID)
+		shared.Invariant(err != nil || holder == seat.HeldBy, "lock_owner_matches_held_by",
+			shared.LogKeySeatID, seatID, "held_by", seat.HeldBy, "lock_owner", holder)
 	}
 
-	// Publish event to NATS
-	publishSeatEvent("held", seatID, userID, seat.Status, seat.ExpiresAt)
+	// Index the hold so the timer only has to look at expired entries
+	if err := store.IndexHold(seatID, expiresAt); err != nil {
+		seatLog(ctx, seatID, userID).Warn("Failed to index hold expiry", shared.ErrAttr(err))
+	}
+	scheduleHoldExpiry(seatID, expiresAt)
 
-	log.Printf("Seat %s selected by user %s", seatID, userID)
+	seatLog(ctx, seatID, userID).Info("Seat selected")
 	return nil
 }
 
-func BookSeat(seatID, userID string) error {
-	// Check if user holds the lock
-	lockKey := fmt.Sprintf(shared.RedisKeySeatLock, seatID)
-	holder, err := redisClient.Get(ctx, lockKey).Result()
-	if err == redis.Nil {
-		return errors.New("seat is not held")
-	}
+// notHeldError says why a seat without a lock cannot be booked or released by
+// userID: their hold lapsed and awaits auto-release, or there is none
+func notHeldError(store SeatStore, seatID, userID string) error {
+	seat, err := store.GetSeat(seatID)
 	if err != nil {
 		return err
 	}
+	if seat.Status == shared.SeatHeld && seat.HeldBy == userID {
+		return errHoldExpired
+	}
+	return errSeatNotHeld
+}
+
+// BookSeat confirms a seat held by userID and creates the order to fulfill. A
+// non-zero expectedVersion rejects the request if the seat has changed since the
+// caller last saw it.
+func BookSeat(ctx context.Context, seatID, userID string, expectedVersion int64) (*Order, error) {
+	store := seatStoreFor(ctx)
+	if err := checkNotBanned(userID); err != nil {
+		return nil, err
+	}
+
+	// Check if user holds the lock
+	holder, err := store.LockHolder(seatID)
+	if err != nil {
+		return nil, err
+	}
+	if holder == "" {
+		return nil, notHeldError(store, seatID, userID)
+	}
 
 	if holder != userID {
-		return errors.New("you do not hold this seat")
+		return nil, errNotHolder
 	}
 
 	// Get current seat status
-	seatJSON, err := redisClient.HGet(ctx, shared.RedisKeyVenueSeats, seatID).Result()
+	seat, err := store.GetSeat(seatID)
 	if err != nil {
-		return err
-	}
-
-	var seat shared.Seat
-	if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Verify seat is held by this user
 	if seat.Status != shared.SeatHeld || seat.HeldBy != userID {
-		return errors.New("seat is not held by you")
+		return nil, errHoldNotYours
+	}
+
+	if expectedVersion != 0 && seat.Version != expectedVersion {
+		return nil, errSeatVersionStale
+	}
+
+	// Holds need no event metadata, but no ticket is sold for a cancelled event
+	if err := checkEventOn(); err != nil {
+		return nil, err
 	}
 
 	// Update seat to booked status
 	seat.Status = shared.SeatBooked
 	seat.ExpiresAt = 0 // Remove expiration
+	seat.HeldAt = 0
 
-	updatedJSON, err := json.Marshal(seat)
-	if err != nil {
-		return err
-	}
-
-	// Update seat in Redis
-	if err := redisClient.HSet(ctx, shared.RedisKeyVenueSeats, seatID, updatedJSON).Err(); err != nil {
-		return err
+	// Update the stored seat, unless it changed since we read it
+	if err := casUpdateSeat(store, seat, seat.Version, "booked", userID, sourceOf(ctx)); err != nil {
+		return nil, err
 	}
 
 	// Remove the lock (no longer needed for booked seats)
-	redisClient.Del(ctx, lockKey)
+	store.ReleaseLock(seatID)
+	store.UnindexHold(seatID)
+	cancelHoldExpiry(seatID)
 
-	// Publish event to NATS
-	publishSeatEvent("booked", seatID, userID, seat.Status, 0)
+	seatLog(ctx, seatID, userID).Info("Seat booked")
 
-	log.Printf("Seat %s booked by user %s", seatID, userID)
-	return nil
+	// The booking stands even if fulfillment cannot be queued; it can be redone from the seat
+	order, err := createOrder(seat, userID)
+	if err != nil {
+		seatLog(ctx, seatID, userID).Error("Failed to create order", shared.ErrAttr(err))
+		recordBooking(seat, userID, nil)
+		return nil, nil
+	}
+	recordBooking(seat, userID, order)
+	return order, nil
 }
 
-func ReleaseSeat(seatID, userID string) error {
-	// Check if user holds the lock
-	lockKey := fmt.Sprintf(shared.RedisKeySeatLock, seatID)
-	holder, err := redisClient.Get(ctx, lockKey).Result()
-	if err == redis.Nil {
-		return errors.New("seat is not held")
+// ReleaseSeat gives up a seat held by userID. A non-zero expectedVersion rejects
+// the request if the seat has changed since the caller last saw it.
+func ReleaseSeat(ctx context.Context, seatID, userID string, expectedVersion int64) error {
+	store := seatStoreFor(ctx)
+	if err := checkNotBanned(userID); err != nil {
+		return err
 	}
+
+	// Check if user holds the lock
+	holder, err := store.LockHolder(seatID)
 	if err != nil {
 		return err
 	}
+	if holder == "" {
+		return notHeldError(store, seatID, userID)
+	}
 
 	if holder != userID {
-		return errors.New("you do not hold this seat")
+		return errNotHolder
 	}
 
 	// Get current seat status
-	seatJSON, err := redisClient.HGet(ctx, shared.RedisKeyVenueSeats, seatID).Result()
+	seat, err := store.GetSeat(seatID)
 	if err != nil {
 		return err
 	}
 
-	var seat shared.Seat
-	if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
-		return err
-	}
-
 	// Verify seat is held by this user
 	if seat.Status != shared.SeatHeld || seat.HeldBy != userID {
-		return errors.New("seat is not held by you")
+		return errHoldNotYours
+	}
+
+	if expectedVersion != 0 && seat.Version != expectedVersion {
+		return errSeatVersionStale
 	}
 
 	// Reset seat to available
 	seat.Status = shared.SeatAvailable
 	seat.HeldBy = ""
 	seat.ExpiresAt = 0
+	seat.HeldAt = 0
 
-	updatedJSON, err := json.Marshal(seat)
-	if err != nil {
-		return err
-	}
-
-	// Update seat in Redis
-	if err := redisClient.HSet(ctx, shared.RedisKeyVenueSeats, seatID, updatedJSON).Err(); err != nil {
+	// Update the stored seat, unless it changed since we read it
+	if err := casUpdateSeat(store, seat, seat.Version, "released", userID, sourceOf(ctx)); err != nil {
 		return err
 	}
 
 	// Remove the lock
-	redisClient.Del(ctx, lockKey)
-
-	// Publish event to NATS
-	publishSeatEvent("released", seatID, userID, seat.Status, 0)
+	store.ReleaseLock(seatID)
+	store.UnindexHold(seatID)
+	cancelHoldExpiry(seatID)
+	countHoldGivenUp(userID)
 
-	log.Printf("Seat %s released by user %s", seatID, userID)
+	seatLog(ctx, seatID, userID).Info("Seat released")
 	return nil
 }
-
-func publishSeatEvent(eventType string, seatID string, userID string, status int, expiresAt int64) {
-	// Get full seat data for the event
-	seatJSON, err := redisClient.HGet(ctx, shared.RedisKeyVenueSeats, seatID).Result()
-	var seat *shared.Seat
-	if err == nil {
-		var s shared.Seat
-		if json.Unmarshal([]byte(seatJSON), &s) == nil {
-			seat = &s
-		}
-	}
-
-	event := shared.SeatEvent{
-		Type:      eventType,
-		SeatID:    seatID,
-		UserID:    userID,
-		Status:    status,
-		Timestamp: time.Now(),
-		ExpiresAt: expiresAt,
-		Seat:      seat,
-	}
-
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		log.Printf("[ERROR] Failed to marshal %s event for seat %s: %v", eventType, seatID, err)
-		return
-	}
-
-	// Determine topic based on event type
-	var topic string
-	switch eventType {
-	case "held":
-		topic = shared.NATSTopicSeatHeld
-	case "released", "auto_released":
-		topic = shared.NATSTopicSeatReleased
-	case "booked":
-		topic = shared.NATSTopicSeatBooked
-	default:
-		log.Printf("[ERROR] Unknown event type: %s", eventType)
-		return
-	}
-
-	// Publish with retry logic
-	maxRetries := 3
-	for i := 0; i < maxRetries; i++ {
-		if err := natsConn.Publish(topic, eventJSON); err != nil {
-			if i == maxRetries-1 {
-				log.Printf("[ERROR] Failed to publish %s event for seat %s after %d attempts: %v", 
-					eventType, seatID, maxRetries, err)
-			} else {
-				log.Printf("[WARN] Retry %d/%d: Failed to publish %s event: %v", 
-					i+1, maxRetries, eventType, err)
-				time.Sleep(100 * time.Millisecond)
-			}
-		} else {
-			log.Printf("[INFO] Published %s event for seat %s to topic %s (user: %s)", 
-				eventType, seatID, topic, userID)
-			break
-		}
-	}
-}
\ No newline at end of file
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"concert-booking/shared"
+)
+
+// Page sizes for GET /api/seats: the default once a cursor is given, and the most
+// one page may hold
+const (
+	defaultSeatPage = 1000
+	maxSeatPage     = 5000
+)
+
+// SeatQuery filters GET /api/seats. Empty fields match every seat.
+type SeatQuery struct {
+	Status        *shared.SeatStatus
+	Row           string // row label, e.g. C
+	Section       string
+	Tier          string
+	MaxPriceCents int64 // -1 means no limit
+
+	// Pagination: at most Limit seats, in seat ID order, after the seat ID
+	// After. A zero Limit returns every matching seat.
+	Limit int
+	After string
+}
+
+// parseSeatQuery reads ?status, ?row, ?section, ?tier and ?max_price, a price
+// in whole currency units such as 50 or 49.99, and the page ?limit and ?cursor
+func parseSeatQuery(values url.Values, currency string) (SeatQuery, error) {
+	query := SeatQuery{
+		Row:           strings.ToUpper(values.Get("row")),
+		Section:       values.Get("section"),
+		Tier:          values.Get("tier"),
+		MaxPriceCents: -1,
+	}
+	if name := values.Get("status"); name != "" {
+		status, err := shared.ParseSeatStatus(name)
+		if err != nil {
+			return query, err
+		}
+		query.Status = &status
+	}
+	if price := values.Get("max_price"); price != "" {
+		amount, err := strconv.ParseFloat(price, 64)
+		if err != nil || amount < 0 || math.IsInf(amount, 0) {
+			return query, fmt.Errorf("max_price must be a non-negative amount, got %q", price)
+		}
+		if !zeroDecimalCurrencies[currency] {
+			amount *= 100
+		}
+		query.MaxPriceCents = int64(math.Round(amount))
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxSeatPage {
+			return query, fmt.Errorf("limit must be 1 to %d, got %q", maxSeatPage, limit)
+		}
+		query.Limit = n
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			return query, fmt.Errorf("invalid cursor %q", cursor)
+		}
+		query.After = string(after)
+		if query.Limit == 0 {
+			query.Limit = defaultSeatPage
+		}
+	}
+	return query, nil
+}
+
+// filtered reports whether the query narrows the seats at all
+func (q SeatQuery) filtered() bool {
+	return q.Status != nil || q.Row != "" || q.Section != "" || q.Tier != "" || q.MaxPriceCents >= 0
+}
+
+// Matches reports whether a seat passes every filter of the query
+func (q SeatQuery) Matches(seat *shared.Seat) bool {
+	switch {
+	case q.Status != nil && seat.Status != *q.Status:
+		return false
+	case q.Row != "" && shared.GetRowLabel(seat.Row) != q.Row:
+		return false
+	case q.Section != "" && seat.Section != q.Section:
+		return false
+	case q.Tier != "" && seat.Tier != q.Tier:
+		return false
+	case q.MaxPriceCents >= 0 && seat.PriceCents > q.MaxPriceCents:
+		return false
+	}
+	return true
+}
+
+// paginate returns the page of seats query asks for, and the cursor of the
+// next page, "" on the last. Pages are in seat ID order, so a seat keeps its
+// page however its state changes between requests.
+func paginate(seats []shared.Seat, query SeatQuery) (page []shared.Seat, next string) {
+	if query.Limit == 0 {
+		return seats, ""
+	}
+	sort.Slice(seats, func(i, j int) bool { return seats[i].ID < seats[j].ID })
+	start := sort.Search(len(seats), func(i int) bool { return seats[i].ID > query.After })
+	end := min(start+query.Limit, len(seats))
+	page = seats[start:end]
+	if end < len(seats) {
+		next = base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1].ID))
+	}
+	return page, next
+}
+
+// filterSeats returns the seats matching query
+func filterSeats(seats []shared.Seat, query SeatQuery) []shared.Seat {
+	if !query.filtered() {
+		return seats
+	}
+	matched := []shared.Seat{}
+	for i := range seats {
+		if query.Matches(&seats[i]) {
+			matched = append(matched, seats[i])
+		}
+	}
+	return matched
+}
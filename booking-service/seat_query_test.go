@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+	"concert-booking/shared/fixtures"
+)
+
+func TestGetSeatsFilters(t *testing.T) {
+	newTestRedis(t)
+	seatStore = NewMemorySeatStore(fixtures.Venue().
+		Tier("premium", 9000).Section("floor", 2, 3).
+		Tier("standard", 4950).Section("balcony", 2, 3).
+		Seats())
+	fixtures.On(seatStore).Book(shared.GetSeatID(2, 0), "alice").MustApply(t)
+
+	get := func(query string) []shared.Seat {
+		t.Helper()
+		w := httptest.NewRecorder()
+		setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /api/seats?%s = %d: %s", query, w.Code, w.Body)
+		}
+		var seats []shared.Seat
+		if err := json.Unmarshal(w.Body.Bytes(), &seats); err != nil {
+			t.Fatalf("decode seats: %v", err)
+		}
+		return seats
+	}
+
+	for query, want := range map[string]int{
+		"":                                  12,
+		"section=balcony":                   6,
+		"section=balcony&status=available":  5,
+		"row=c":                             3,
+		"max_price=50":                      6,
+		"max_price=49.49":                   0,
+		"tier=premium&row=A&status=booked":  0,
+		"status=available&max_price=100.00": 11,
+	} {
+		if seats := get(query); len(seats) != want {
+			t.Errorf("?%s returned %d seats, want %d", query, len(seats), want)
+		}
+	}
+
+	for _, query := range []string{"status=sold", "max_price=cheap", "max_price=-1"} {
+		w := httptest.NewRecorder()
+		setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("?%s = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestGetOneSeat(t *testing.T) {
+	newTestRedis(t)
+	seatID := shared.GetSeatID(0, 1)
+	fixtures.On(seatStore).Hold(seatID, "alice", time.Now().Add(time.Minute)).MustApply(t)
+
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats/"+seatID, nil))
+	var seat shared.Seat
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &seat) != nil {
+		t.Fatalf("GET seat = %d: %s", w.Code, w.Body)
+	}
+	if seat.ID != seatID || seat.Status != shared.SeatHeld || seat.HeldBy != "alice" {
+		t.Errorf("seat = %+v, want %s held by alice", seat, seatID)
+	}
+
+	w = httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats/NOPE", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET unknown seat = %d, want 404", w.Code)
+	}
+}
+
+func TestBatchGetSeats(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		held, booked := shared.GetSeatID(0, 2), shared.GetSeatID(1, 0)
+		fixtures.On(seatStore).
+			Hold(held, "alice", time.Now().Add(time.Minute)).
+			Book(booked, "bob").
+			MustApply(t)
+
+		post := func(body string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/seats/batch-get", strings.NewReader(body)))
+			return w
+		}
+
+		w := post(`{"seat_ids":["` + booked + `","NOPE","` + held + `","` + booked + `"]}`)
+		var batch shared.SeatBatchGetResponse
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &batch) != nil {
+			t.Fatalf("batch-get = %d: %s", w.Code, w.Body)
+		}
+		if len(batch.Seats) != 2 || batch.Seats[0].ID != booked || batch.Seats[1].ID != held {
+			t.Fatalf("seats = %+v, want %s then %s, once each", batch.Seats, booked, held)
+		}
+		if batch.Seats[0].Status != shared.SeatBooked || batch.Seats[1].HeldBy != "alice" {
+			t.Errorf("seats = %+v, want their current states", batch.Seats)
+		}
+		if len(batch.NotFound) != 1 || batch.NotFound[0] != "NOPE" || batch.Seq == 0 {
+			t.Errorf("not found = %v at seq %d", batch.NotFound, batch.Seq)
+		}
+
+		if w := post(`{"seat_ids":[]}`); w.Code != http.StatusBadRequest {
+			t.Errorf("empty batch-get = %d, want 400", w.Code)
+		}
+	})
+}
+
+func TestGetSeatsPages(t *testing.T) {
+	newTestRedis(t)
+	all, _ := GetAllSeats()
+
+	seen := map[string]bool{}
+	cursor, pages := "", 0
+	for {
+		w := httptest.NewRecorder()
+		setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats?limit=7&cursor="+cursor, nil))
+		var page []shared.Seat
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+			t.Fatalf("page %d = %d: %s", pages, w.Code, w.Body)
+		}
+		if len(page) > 7 {
+			t.Fatalf("page %d has %d seats, want at most 7", pages, len(page))
+		}
+		for _, seat := range page {
+			if seen[seat.ID] {
+				t.Fatalf("seat %s on two pages", seat.ID)
+			}
+			seen[seat.ID] = true
+		}
+		pages++
+		if cursor = w.Header().Get(shared.HeaderNextCursor); cursor == "" {
+			break
+		}
+	}
+	if len(seen) != len(all) || pages != (len(all)+6)/7 {
+		t.Errorf("%d pages held %d seats, want all %d", pages, len(seen), len(all))
+	}
+
+	for _, query := range []string{"limit=0", "limit=5001", "cursor=not*base64"} {
+		w := httptest.NewRecorder()
+		setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /api/seats?%s = %d, want 400", query, w.Code)
+		}
+	}
+}
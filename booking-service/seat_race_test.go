@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+// race runs fn from n goroutines released at the same moment
+func race(n int, fn func(i int)) {
+	var start, done sync.WaitGroup
+	start.Add(1)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer done.Done()
+			start.Wait()
+			fn(i)
+		}(i)
+	}
+	start.Done()
+	done.Wait()
+}
+
+func TestConcurrentSeatOperationsKeepInvariants(t *testing.T) {
+	t.Setenv("INVARIANT_CHECKS", "true")
+	if err := shared.InitInvariantChecks("booking-service"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Unsetenv("INVARIANT_CHECKS")
+		shared.InitInvariantChecks("booking-service")
+	})
+
+	forEachSeatStore(t, func(t *testing.T) {
+		before := shared.InvariantViolations()
+		race(200, func(i int) {
+			seatID := shared.GetSeatID(0, i%5)
+			userID := fmt.Sprintf("user-%d", i%20)
+			switch i % 3 {
+			case 0:
+				SelectSeat(ctx, seatID, userID, 0)
+			case 1:
+				ReleaseSeat(ctx, seatID, userID, 0)
+			case 2:
+				BookSeat(ctx, seatID, userID, 0)
+			}
+		})
+		if n := shared.InvariantViolations() - before; n != 0 {
+			t.Errorf("%d invariant violations under concurrent seat operations", n)
+		}
+	})
+}
+
+func TestConcurrentSelectHasExactlyOneWinner(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		const seats, contenders = 10, 50
+		var winners [seats]atomic.Int32
+		var winner [seats]atomic.Value
+
+		race(seats*contenders, func(i int) {
+			seat := i % seats
+			userID := fmt.Sprintf("user-%d", i)
+			if err := SelectSeat(ctx, shared.GetSeatID(0, seat), userID, 0); err == nil {
+				winners[seat].Add(1)
+				winner[seat].Store(userID)
+			}
+		})
+
+		for col := 0; col < seats; col++ {
+			seatID := shared.GetSeatID(0, col)
+			if n := winners[col].Load(); n != 1 {
+				t.Fatalf("seat %s: %d winners, want exactly 1", seatID, n)
+			}
+
+			seat := loadSeat(t, seatID)
+			if seat.Status != shared.SeatHeld || seat.HeldBy != winner[col].Load() {
+				t.Errorf("seat %s = status %s held by %q, want held by %v", seatID, seat.Status, seat.HeldBy, winner[col].Load())
+			}
+			if seat.Version != 2 {
+				t.Errorf("seat %s version = %d, want 2", seatID, seat.Version)
+			}
+			lock, _ := seatStore.LockHolder(seatID)
+			if lock != seat.HeldBy {
+				t.Errorf("seat %s lock held by %q, seat held by %q", seatID, lock, seat.HeldBy)
+			}
+		}
+	})
+}
+
+func TestConcurrentBookOnlyHolderSucceeds(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+
+		var booked atomic.Int32
+		race(200, func(i int) {
+			userID := fmt.Sprintf("user-%d", i)
+			if i == 0 {
+				userID = "holder"
+			}
+			if _, err := BookSeat(ctx, seatID, userID, 0); err == nil {
+				if userID != "holder" {
+					t.Errorf("%s booked a seat held by someone else", userID)
+				}
+				booked.Add(1)
+			}
+		})
+
+		if n := booked.Load(); n != 1 {
+			t.Fatalf("%d successful bookings, want 1", n)
+		}
+		seat := loadSeat(t, seatID)
+		if seat.Status != shared.SeatBooked || seat.HeldBy != "holder" {
+			t.Fatalf("seat = status %s held by %q, want booked by holder", seat.Status, seat.HeldBy)
+		}
+	})
+}
+
+// TestConcurrentMixedOperationsKeepStateConsistent hammers a few seats with
+// select/book/release from many users and checks that seat state, locks, the
+// expiry index, versions and the outbox all agree afterwards
+func TestConcurrentMixedOperationsKeepStateConsistent(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		const seats, users, rounds = 5, 200, 5
+		var transitions [seats]atomic.Int64
+		var bookings [seats]atomic.Int32
+
+		race(users, func(i int) {
+			rng := rand.New(rand.NewSource(int64(i)))
+			userID := fmt.Sprintf("user-%d", i)
+
+			for r := 0; r < rounds; r++ {
+				col := rng.Intn(seats)
+				seatID := shared.GetSeatID(0, col)
+				if SelectSeat(ctx, seatID, userID, 0) != nil {
+					continue
+				}
+				transitions[col].Add(1)
+
+				if rng.Intn(4) == 0 {
+					if _, err := BookSeat(ctx, seatID, userID, 0); err != nil {
+						t.Errorf("%s could not book its own hold on %s: %v", userID, seatID, err)
+						continue
+					}
+					bookings[col].Add(1)
+				} else if err := ReleaseSeat(ctx, seatID, userID, 0); err != nil {
+					t.Errorf("%s could not release its own hold on %s: %v", userID, seatID, err)
+					continue
+				}
+				transitions[col].Add(1)
+			}
+		})
+
+		var total int64
+		for col := 0; col < seats; col++ {
+			seatID := shared.GetSeatID(0, col)
+			seat := loadSeat(t, seatID)
+
+			if n := bookings[col].Load(); n > 1 {
+				t.Errorf("seat %s booked %d times", seatID, n)
+			}
+			if want := transitions[col].Load() + 1; seat.Version != want {
+				t.Errorf("seat %s version = %d, want %d (one per successful transition)", seatID, seat.Version, want)
+			}
+
+			holder, _ := seatStore.LockHolder(seatID)
+			_, indexed, _ := seatStore.HoldExpiry(seatID)
+			switch seat.Status {
+			case shared.SeatBooked:
+				if holder != "" || indexed {
+					t.Errorf("booked seat %s still has a lock or expiry entry", seatID)
+				}
+			case shared.SeatAvailable:
+				if holder != "" || indexed || seat.HeldBy != "" {
+					t.Errorf("available seat %s still has a holder, lock or expiry entry", seatID)
+				}
+			default:
+				t.Errorf("seat %s left in status %s", seatID, seat.Status)
+			}
+			total += transitions[col].Load()
+		}
+
+		if n := int64(len(queuedTopics(t))); n != total {
+			t.Errorf("outbox has %d events, want %d", n, total)
+		}
+		if seq, _ := seatStore.EventSeq(); seq != total {
+			t.Errorf("event sequence = %d, want %d", seq, total)
+		}
+	})
+}
+
+func TestSeatEventsUseHierarchicalSubjects(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		if _, err := BookSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("BookSeat: %v", err)
+		}
+
+		section := loadSeat(t, seatID).Section
+		topics := queuedTopics(t)
+		want := []string{
+			shared.SeatSubject(shared.DefaultEventID, section, seatID, "held"),
+			shared.SeatSubject(shared.DefaultEventID, section, seatID, "booked"),
+		}
+		if len(topics) != len(want) {
+			t.Fatalf("outbox has %d events, want %d", len(topics), len(want))
+		}
+		for i, topic := range topics {
+			if topic != want[i] {
+				t.Errorf("event %d published on %s, want %s", i, topic, want[i])
+			}
+		}
+
+		if got := shared.SeatSubject("main", "", "A 1.*", "held"); got != "seats.main._.A_1__.held" {
+			t.Errorf("unsafe tokens not sanitized: %s", got)
+		}
+		if got := shared.SeatSubjectFilter("", "101", "", "booked"); got != "seats.*.101.*.booked" {
+			t.Errorf("SeatSubjectFilter = %s", got)
+		}
+	})
+}
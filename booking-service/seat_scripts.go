@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"concert-booking/shared"
+)
+
+var (
+	errSeatNotFound     = shared.NewError(shared.ErrorCodeNotFound, "seat not found")
+	errSeatVersionStale = shared.NewError(shared.ErrorCodeSeatVersionStale, "seat version is stale, refresh and retry")
+)
+
+// casUpdateSeat writes seat with its version bumped, provided the stored seat is
+// still at expectedVersion, and records the transition in the outbox for the relay
+// to publish. source says how the change came in, for the audit log. On success
+// seat.Version holds the new version.
+func casUpdateSeat(store SeatStore, seat *shared.Seat, expectedVersion int64, eventType, userID, source string) error {
+	return casUpdateSeatAs(store, seat, expectedVersion, eventType, userID, "", source)
+}
+
+// casUpdateSeatAs is casUpdateSeat for a transition caused by actor on userID's
+// behalf, such as a repair; the seat's history records actor
+func casUpdateSeatAs(store SeatStore, seat *shared.Seat, expectedVersion int64, eventType, userID, actor, source string) error {
+	return casWriteSeat(store, seat, expectedVersion, eventType, userID, actor, source, "")
+}
+
+// casEndHold is casUpdateSeat for a transition that ends holder's hold: the
+// seat's lock is dropped with the update if holder still holds it, and left
+// alone if it has since been taken by someone else
+func casEndHold(store SeatStore, seat *shared.Seat, expectedVersion int64, eventType, userID, holder, source string) error {
+	return casWriteSeat(store, seat, expectedVersion, eventType, userID, "", source, holder)
+}
+
+func casWriteSeat(store SeatStore, seat *shared.Seat, expectedVersion int64, eventType, userID, actor, source, lockHolder string) error {
+	topic, err := seatSubject(seat, eventType)
+	if err != nil {
+		return err
+	}
+
+	seat.Version = expectedVersion + 1
+
+	// The sequence number is assigned by the store and stamped by the relay
+	eventJSON, err := json.Marshal(shared.SeatEvent{
+		Type:      eventType,
+		SeatID:    seat.ID,
+		UserID:    userID,
+		Actor:     actor,
+		Source:    source,
+		Status:    seat.Status,
+		Version:   seat.Version,
+		Timestamp: time.Now(),
+		ExpiresAt: seat.ExpiresAt,
+		Seat:      seat,
+	})
+	if err != nil {
+		seat.Version = expectedVersion
+		return err
+	}
+
+	if lockHolder != "" {
+		err = store.UpdateSeatDroppingLock(seat, expectedVersion, topic, eventJSON, lockHolder)
+	} else {
+		err = store.UpdateSeat(seat, expectedVersion, topic, eventJSON)
+	}
+	if err != nil {
+		seat.Version = expectedVersion
+		return err
+	}
+	return nil
+}
+
+// seatSubject returns the NATS subject for a seat event
+func seatSubject(seat *shared.Seat, eventType string) (string, error) {
+	switch eventType {
+	case "held", "renewed", "released", "auto_released", "booked", "allocated", "returned":
+		return shared.SeatSubject(shared.DefaultEventID, seat.Section, seat.ID, eventType), nil
+	default:
+		return "", errors.New("unknown event type: " + eventType)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestCasUpdateSeatBumpsVersion(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seat := loadSeat(t, shared.GetSeatID(0, 0))
+		version := seat.Version
+		seat.Status = shared.SeatHeld
+		seat.HeldBy = "holder"
+
+		if err := casUpdateSeat(seatStore, &seat, version, "held", "holder", ""); err != nil {
+			t.Fatalf("casUpdateSeat: %v", err)
+		}
+		if seat.Version != version+1 {
+			t.Errorf("version = %d, want %d", seat.Version, version+1)
+		}
+		if stored := loadSeat(t, seat.ID); stored.Version != version+1 || stored.HeldBy != "holder" {
+			t.Errorf("stored seat = %+v, want it held at version %d", stored, version+1)
+		}
+	})
+}
+
+func TestCasUpdateSeatRejectsVersionMismatch(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+
+		// A writer that read the seat before the hold
+		seat := loadSeat(t, seatID)
+		held := seat.Version
+		seat.Status = shared.SeatBooked
+		err := casUpdateSeat(seatStore, &seat, held-1, "booked", "holder", "")
+		if !errors.Is(err, errSeatVersionStale) {
+			t.Fatalf("casUpdateSeat = %v, want errSeatVersionStale", err)
+		}
+		if seat.Version != held-1 {
+			t.Errorf("version after a failed write = %d, want the expected %d restored", seat.Version, held-1)
+		}
+		if stored := loadSeat(t, seatID); stored.Status != shared.SeatHeld || stored.Version != held {
+			t.Errorf("stored seat = %+v, want the hold untouched", stored)
+		}
+	})
+}
+
+func TestCasUpdateSeatMissingSeat(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seat := shared.Seat{ID: "Z-99"}
+
+		if err := casUpdateSeat(seatStore, &seat, 0, "held", "holder", ""); !errors.Is(err, errSeatNotFound) {
+			t.Fatalf("casUpdateSeat = %v, want errSeatNotFound", err)
+		}
+		if _, err := seatStore.GetSeat(seat.ID); !errors.Is(err, errSeatNotFound) {
+			t.Errorf("GetSeat after the write = %v, want the seat still missing", err)
+		}
+	})
+}
+
+func TestSelectSeatStaleVersionReleasesLock(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "first", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		version := loadSeat(t, seatID).Version
+		if err := ReleaseSeat(ctx, seatID, "first", version); err != nil {
+			t.Fatalf("ReleaseSeat: %v", err)
+		}
+
+		// Still holding the version seen before the release
+		if err := SelectSeat(ctx, seatID, "second", version); !errors.Is(err, errSeatVersionStale) {
+			t.Fatalf("SelectSeat with a stale version = %v, want errSeatVersionStale", err)
+		}
+		if holder, _ := seatStore.LockHolder(seatID); holder != "" {
+			t.Errorf("lock kept by %q after a stale select", holder)
+		}
+		if stored := loadSeat(t, seatID); stored.Status != shared.SeatAvailable {
+			t.Errorf("stored seat = %+v, want it still available", stored)
+		}
+	})
+}
+
+func TestAutoReleaseSkipsSeatChangedSinceRead(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		seatID := shared.GetSeatID(0, 0)
+		if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("SelectSeat: %v", err)
+		}
+		expired := loadSeat(t, seatID)
+
+		// Booked between the timer's read and its write
+		if _, err := BookSeat(ctx, seatID, "holder", 0); err != nil {
+			t.Fatalf("BookSeat: %v", err)
+		}
+
+		if err := autoReleaseSeat(seatStore, &expired); !errors.Is(err, errSeatVersionStale) {
+			t.Fatalf("autoReleaseSeat = %v, want errSeatVersionStale", err)
+		}
+		if stored := loadSeat(t, seatID); stored.Status != shared.SeatBooked || stored.HeldBy != "holder" {
+			t.Errorf("stored seat = %+v, want the booking kept", stored)
+		}
+	})
+}
+
+func TestEndingAHoldOnlyDropsThePreviousHoldersLock(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		ended := map[string]func(seat *shared.Seat) error{
+			"auto release": func(seat *shared.Seat) error { return autoReleaseSeat(seatStore, seat) },
+			"admin release": func(seat *shared.Seat) error {
+				return applyAdminStatus(seat, shared.SeatAvailable)
+			},
+		}
+		row := 0
+		for name, end := range ended {
+			ownSeat, takenSeat := shared.GetSeatID(row, 0), shared.GetSeatID(row, 1)
+			row++
+			for _, seatID := range []string{ownSeat, takenSeat} {
+				if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+					t.Fatalf("SelectSeat: %v", err)
+				}
+			}
+
+			// The hold's lock lapsed and another buyer took it before the hold ended
+			seatStore.ReleaseLock(takenSeat)
+			if ok, err := seatStore.AcquireLock(takenSeat, "next", time.Minute); !ok || err != nil {
+				t.Fatalf("AcquireLock = %v, %v", ok, err)
+			}
+
+			for seatID, want := range map[string]string{ownSeat: "", takenSeat: "next"} {
+				seat := loadSeat(t, seatID)
+				if err := end(&seat); err != nil {
+					t.Fatalf("%s of %s: %v", name, seatID, err)
+				}
+				if holder, _ := seatStore.LockHolder(seatID); holder != want {
+					t.Errorf("%s of %s left the lock with %q, want %q", name, seatID, holder, want)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"concert-booking/shared"
+)
+
+// seatStore holds the venue. Seat and timer logic reach storage only through it.
+var seatStore SeatStore
+
+// SeatStore is where seats, their hold locks and the hold expiry index live.
+// Implementations must make UpdateSeat and AcquireLock atomic; everything else
+// may be eventually consistent with them.
+type SeatStore interface {
+	// GetSeat returns one seat, or errSeatNotFound
+	GetSeat(seatID string) (*shared.Seat, error)
+
+	// GetSeats returns those of the seats that exist, in the order asked for
+	GetSeats(seatIDs []string) ([]shared.Seat, error)
+
+	// AllSeats returns every seat in no particular order
+	AllSeats() ([]shared.Seat, error)
+
+	// EventSeq returns the sequence number of the latest seat event
+	EventSeq() (int64, error)
+
+	// UpdateSeat replaces a seat, provided the stored one is still at
+	// expectedVersion, and in the same step queues event for publication on
+	// topic with the next sequence number. It returns errSeatNotFound or
+	// errSeatVersionStale when the write is refused.
+	UpdateSeat(seat *shared.Seat, expectedVersion int64, topic string, event []byte) error
+
+	// UpdateSeatDroppingLock is UpdateSeat that, in the same step, drops the
+	// seat's lock if holder still holds it, for updates that end holder's hold.
+	// A lock someone else took since is kept.
+	UpdateSeatDroppingLock(seat *shared.Seat, expectedVersion int64, topic string, event []byte, holder string) error
+
+	// AcquireLock takes a seat's hold lock for holder until ttl passes. It
+	// reports false if someone else already holds it.
+	AcquireLock(seatID, holder string, ttl time.Duration) (bool, error)
+
+	// LockHolder returns who holds a seat's lock, or "" if nobody does
+	LockHolder(seatID string) (string, error)
+
+	// ExtendLock moves a seat's lock to expire when ttl passes, provided holder
+	// still holds it. It reports false if they do not.
+	ExtendLock(seatID, holder string, ttl time.Duration) (bool, error)
+
+	// ReleaseLock drops a seat's lock, if any
+	ReleaseLock(seatID string) error
+
+	// IndexHold records when a seat's hold expires, replacing any earlier entry
+	IndexHold(seatID string, expiresAt time.Time) error
+
+	// UnindexHold removes a seat from the expiry index
+	UnindexHold(seatID string) error
+
+	// HoldExpiry returns a seat's indexed expiry; ok is false if it is not indexed
+	HoldExpiry(seatID string) (expiresAt time.Time, ok bool, err error)
+
+	// ExpiredHolds returns the seats whose indexed expiry is before now
+	ExpiredHolds(now time.Time) ([]string, error)
+
+	// IndexedHolds returns the whole expiry index
+	IndexedHolds() (map[string]time.Time, error)
+
+	// SeatHistory returns the seat's most recent transitions, oldest first.
+	// UpdateSeat appends to it in the same step as the write.
+	SeatHistory(seatID string) ([]SeatTransition, error)
+
+	// SearchAudit returns the transitions of every seat that match q, oldest
+	// first and at most q.Limit of them
+	SearchAudit(q AuditQuery) ([]SeatTransition, error)
+
+	// ChangedSince returns the IDs of the seats changed by events after seq,
+	// from the audit log. ok is false if the log no longer reaches back to seq.
+	ChangedSince(seq int64) (seatIDs []string, ok bool, err error)
+}
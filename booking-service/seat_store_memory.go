@@ -0,0 +1,258 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// memoryEvent is a seat event queued by the in-memory store
+type memoryEvent struct {
+	Topic string
+	Seq   int64
+	Event []byte
+}
+
+type memoryLock struct {
+	holder    string
+	expiresAt time.Time
+}
+
+// memorySeatStore keeps the venue in process memory. Nothing is shared with
+// other instances and events are only queued, never published, so it suits
+// tests and single-process tools rather than deployments.
+type memorySeatStore struct {
+	mu      sync.Mutex
+	seats   map[string]shared.Seat
+	locks   map[string]memoryLock
+	expiry  map[string]time.Time
+	seq     int64
+	events  []memoryEvent               // most recent outboxMaxLen events
+	history map[string][]SeatTransition // most recent seatHistoryMaxLen transitions per seat
+	audit   []SeatTransition            // most recent auditLogMaxLen transitions of any seat
+}
+
+// NewMemorySeatStore returns an in-memory SeatStore holding seats
+func NewMemorySeatStore(seats []shared.Seat) SeatStore {
+	s := &memorySeatStore{
+		seats:   make(map[string]shared.Seat, len(seats)),
+		locks:   make(map[string]memoryLock),
+		expiry:  make(map[string]time.Time),
+		history: make(map[string][]SeatTransition),
+	}
+	for _, seat := range seats {
+		s.seats[seat.ID] = seat
+	}
+	return s
+}
+
+func (s *memorySeatStore) GetSeat(seatID string) (*shared.Seat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seat, ok := s.seats[seatID]
+	if !ok {
+		return nil, errSeatNotFound
+	}
+	return &seat, nil
+}
+
+func (s *memorySeatStore) GetSeats(seatIDs []string) ([]shared.Seat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seats := make([]shared.Seat, 0, len(seatIDs))
+	for _, seatID := range seatIDs {
+		if seat, ok := s.seats[seatID]; ok {
+			seats = append(seats, seat)
+		}
+	}
+	return seats, nil
+}
+
+func (s *memorySeatStore) AllSeats() ([]shared.Seat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seats := make([]shared.Seat, 0, len(s.seats))
+	for _, seat := range s.seats {
+		seats = append(seats, seat)
+	}
+	return seats, nil
+}
+
+func (s *memorySeatStore) EventSeq() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq, nil
+}
+
+func (s *memorySeatStore) UpdateSeat(seat *shared.Seat, expectedVersion int64, topic string, event []byte) error {
+	return s.updateSeat(seat, expectedVersion, topic, event, "")
+}
+
+func (s *memorySeatStore) UpdateSeatDroppingLock(seat *shared.Seat, expectedVersion int64, topic string, event []byte, holder string) error {
+	return s.updateSeat(seat, expectedVersion, topic, event, holder)
+}
+
+func (s *memorySeatStore) updateSeat(seat *shared.Seat, expectedVersion int64, topic string, event []byte, lockHolder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.seats[seat.ID]
+	if !ok {
+		return errSeatNotFound
+	}
+	if current.Version != expectedVersion {
+		return errSeatVersionStale
+	}
+
+	transition, err := newSeatTransition(s.seq+1, event, current)
+	if err != nil {
+		return err
+	}
+
+	s.seats[seat.ID] = *seat
+	if lock, ok := s.locks[seat.ID]; ok && lockHolder != "" && lock.holder == lockHolder {
+		delete(s.locks, seat.ID)
+	}
+	s.seq++
+	s.events = append(s.events, memoryEvent{Topic: topic, Seq: s.seq, Event: event})
+	if len(s.events) > outboxMaxLen {
+		s.events = s.events[len(s.events)-outboxMaxLen:]
+	}
+
+	history := append(s.history[seat.ID], transition)
+	if len(history) > seatHistoryMaxLen {
+		history = history[len(history)-seatHistoryMaxLen:]
+	}
+	s.history[seat.ID] = history
+
+	s.audit = append(s.audit, transition)
+	if len(s.audit) > auditLogMaxLen {
+		s.audit = s.audit[len(s.audit)-auditLogMaxLen:]
+	}
+	return nil
+}
+
+func (s *memorySeatStore) AcquireLock(seatID, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := clock.Now()
+	if lock, ok := s.locks[seatID]; ok && now.Before(lock.expiresAt) {
+		return false, nil
+	}
+	s.locks[seatID] = memoryLock{holder: holder, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memorySeatStore) LockHolder(seatID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, ok := s.locks[seatID]
+	if !ok || !clock.Now().Before(lock.expiresAt) {
+		return "", nil
+	}
+	return lock.holder, nil
+}
+
+func (s *memorySeatStore) ExtendLock(seatID, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := clock.Now()
+	lock, ok := s.locks[seatID]
+	if !ok || lock.holder != holder || !now.Before(lock.expiresAt) {
+		return false, nil
+	}
+	s.locks[seatID] = memoryLock{holder: holder, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memorySeatStore) ReleaseLock(seatID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locks, seatID)
+	return nil
+}
+
+func (s *memorySeatStore) IndexHold(seatID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiry[seatID] = expiresAt
+	return nil
+}
+
+func (s *memorySeatStore) UnindexHold(seatID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expiry, seatID)
+	return nil
+}
+
+func (s *memorySeatStore) HoldExpiry(seatID string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.expiry[seatID]
+	return expiresAt, ok, nil
+}
+
+func (s *memorySeatStore) ExpiredHolds(now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []string
+	for seatID, expiresAt := range s.expiry {
+		if expiresAt.Before(now) {
+			expired = append(expired, seatID)
+		}
+	}
+	return expired, nil
+}
+
+func (s *memorySeatStore) IndexedHolds() (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	holds := make(map[string]time.Time, len(s.expiry))
+	for seatID, expiresAt := range s.expiry {
+		holds[seatID] = expiresAt
+	}
+	return holds, nil
+}
+
+func (s *memorySeatStore) SeatHistory(seatID string) ([]SeatTransition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SeatTransition{}, s.history[seatID]...), nil
+}
+
+func (s *memorySeatStore) SearchAudit(q AuditQuery) ([]SeatTransition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return q.Filter(s.audit), nil
+}
+
+func (s *memorySeatStore) ChangedSince(seq int64) ([]string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes changeSet
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if !changes.add(s.audit[i], seq) {
+			return changes.seatIDs, true, nil
+		}
+	}
+	return changes.seatIDs, changes.complete(seq), nil
+}
+
+// queuedEvents returns a copy of the events still held by the store
+func (s *memorySeatStore) queuedEvents() []memoryEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]memoryEvent(nil), s.events...)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func newMemoryTestStore(t *testing.T) *memorySeatStore {
+	t.Helper()
+
+	seats, err := GenerateVenue("grid", nil)
+	if err != nil {
+		t.Fatalf("GenerateVenue: %v", err)
+	}
+	return NewMemorySeatStore(seats).(*memorySeatStore)
+}
+
+func TestMemorySeatStoreUpdateSeatChecksVersion(t *testing.T) {
+	store := newMemoryTestStore(t)
+	seatID := shared.GetSeatID(0, 0)
+
+	seat, err := store.GetSeat(seatID)
+	if err != nil {
+		t.Fatalf("GetSeat: %v", err)
+	}
+	seat.Status = shared.SeatHeld
+	seat.HeldBy = "holder"
+	if err := casUpdateSeat(store, seat, seat.Version, "held", "holder", ""); err != nil {
+		t.Fatalf("casUpdateSeat: %v", err)
+	}
+
+	// Changing the returned copy must not change the stored seat
+	seat.HeldBy = "someone else"
+	if stored, _ := store.GetSeat(seatID); stored.HeldBy != "holder" || stored.Version != 2 {
+		t.Fatalf("stored seat = held by %q at version %d, want holder at version 2", stored.HeldBy, stored.Version)
+	}
+
+	stale := *seat
+	stale.Version = 1
+	if err := casUpdateSeat(store, &stale, 1, "released", "holder", ""); err != errSeatVersionStale {
+		t.Fatalf("update at stale version = %v, want errSeatVersionStale", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("refused update left version %d, want 1", stale.Version)
+	}
+
+	missing := shared.Seat{ID: "nope"}
+	if err := casUpdateSeat(store, &missing, 0, "released", "holder", ""); err != errSeatNotFound {
+		t.Fatalf("update of missing seat = %v, want errSeatNotFound", err)
+	}
+
+	if seq, _ := store.EventSeq(); seq != 1 {
+		t.Errorf("event sequence = %d, want 1", seq)
+	}
+	if events := store.queuedEvents(); len(events) != 1 || events[0].Seq != 1 {
+		t.Errorf("queued events = %+v, want one with sequence 1", events)
+	}
+}
+
+func TestMemorySeatStoreLocksExpire(t *testing.T) {
+	store := newMemoryTestStore(t)
+
+	if ok, _ := store.AcquireLock("A1", "first", 20*time.Millisecond); !ok {
+		t.Fatal("first lock not acquired")
+	}
+	if ok, _ := store.AcquireLock("A1", "second", time.Minute); ok {
+		t.Fatal("second lock acquired while the first is live")
+	}
+	if holder, _ := store.LockHolder("A1"); holder != "first" {
+		t.Fatalf("LockHolder = %q, want first", holder)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if holder, _ := store.LockHolder("A1"); holder != "" {
+		t.Fatalf("expired lock still held by %q", holder)
+	}
+	if ok, _ := store.AcquireLock("A1", "second", time.Minute); !ok {
+		t.Fatal("lock not acquired after the previous one expired")
+	}
+
+	store.ReleaseLock("A1")
+	if holder, _ := store.LockHolder("A1"); holder != "" {
+		t.Fatalf("released lock still held by %q", holder)
+	}
+}
+
+func TestMemorySeatStoreExpiryIndex(t *testing.T) {
+	store := newMemoryTestStore(t)
+	now := time.Now()
+
+	store.IndexHold("A1", now.Add(-time.Second))
+	store.IndexHold("A2", now.Add(time.Minute))
+	store.IndexHold("A3", now.Add(-time.Millisecond))
+	store.UnindexHold("A3")
+
+	expired, _ := store.ExpiredHolds(now)
+	if len(expired) != 1 || expired[0] != "A1" {
+		t.Fatalf("ExpiredHolds = %v, want [A1]", expired)
+	}
+	if expiresAt, ok, _ := store.HoldExpiry("A2"); !ok || !expiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("HoldExpiry(A2) = %v, %v", expiresAt, ok)
+	}
+	if holds, _ := store.IndexedHolds(); len(holds) != 2 {
+		t.Errorf("IndexedHolds has %d entries, want 2", len(holds))
+	}
+}
+
+// TestExpireHoldWithMemoryStore runs the hold lifecycle and the timer's expiry
+// path without Redis
+func TestExpireHoldWithMemoryStore(t *testing.T) {
+	store := newMemoryTestStore(t)
+	seatStore = store
+	seatID := shared.GetSeatID(0, 0)
+
+	if err := SelectSeat(ctx, seatID, "holder", 0); err != nil {
+		t.Fatalf("SelectSeat: %v", err)
+	}
+	if err := SelectSeat(ctx, seatID, "other", 0); err == nil {
+		t.Fatal("second SelectSeat succeeded")
+	}
+
+	expiresAt, ok, _ := store.HoldExpiry(seatID)
+	if !ok {
+		t.Fatal("hold not indexed")
+	}
+	if released, err := expireHold(store, seatID, time.Now()); err != nil || released {
+		t.Fatalf("expireHold before expiry = %v, %v; want false, nil", released, err)
+	}
+
+	released, err := expireHold(store, seatID, expiresAt.Add(time.Second))
+	if err != nil || !released {
+		t.Fatalf("expireHold after expiry = %v, %v; want true, nil", released, err)
+	}
+
+	seat, _ := store.GetSeat(seatID)
+	if seat.Status != shared.SeatAvailable || seat.HeldBy != "" {
+		t.Errorf("seat = status %s held by %q, want available", seat.Status, seat.HeldBy)
+	}
+	if holder, _ := store.LockHolder(seatID); holder != "" {
+		t.Errorf("lock still held by %q", holder)
+	}
+	if _, ok, _ := store.HoldExpiry(seatID); ok {
+		t.Error("seat still in the expiry index")
+	}
+	if err := SelectSeat(ctx, seatID, "other", 0); err != nil {
+		t.Errorf("SelectSeat after expiry: %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// casSeatScript replaces a seat's JSON only if its stored version matches the
+// expected one, and in the same step appends the matching event to the outbox
+// stream with the next event sequence number and, with the seat's previous
+// JSON, to the seat's history stream. It also appends the event to the audit
+// log, with just the previous status and holder, and indexes the entry under
+// the event's user. Given a lock holder, it also deletes the seat's lock if
+// it still holds that value, so a hold ended by the update takes its lock
+// with it while a lock taken since by someone else is kept. Returns the
+// sequence number on success, 0 on version mismatch, -1 if the seat is
+// missing.
+//
+// KEYS[1] = venue seats hash, KEYS[2] = outbox stream, KEYS[3] = event sequence counter,
+// KEYS[4] = seat history stream, KEYS[5] = audit log stream, KEYS[6] = user's audit index,
+// KEYS[7] = seat lock
+// ARGV[1] = seat ID, ARGV[2] = expected version, ARGV[3] = new seat JSON,
+// ARGV[4] = NATS topic, ARGV[5] = event JSON, ARGV[6] = outbox max length,
+// ARGV[7] = history max length, ARGV[8] = traceparent of the request, or "",
+// ARGV[9] = audit log max length, ARGV[10] = user index max length,
+// ARGV[11] = event's user ID, or "" to skip the index,
+// ARGV[12] = holder whose lock to delete, or "" to leave the lock alone
+var casSeatScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if not current then
+	return -1
+end
+local seat = cjson.decode(current)
+local version = tonumber(seat['version']) or 0
+if version ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+if ARGV[12] ~= '' and redis.call('GET', KEYS[7]) == ARGV[12] then
+	redis.call('DEL', KEYS[7])
+end
+local seq = redis.call('INCR', KEYS[3])
+if ARGV[8] ~= '' then
+	redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[6], '*', 'topic', ARGV[4], 'seq', seq, 'event', ARGV[5], 'traceparent', ARGV[8])
+else
+	redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[6], '*', 'topic', ARGV[4], 'seq', seq, 'event', ARGV[5])
+end
+redis.call('XADD', KEYS[4], 'MAXLEN', '~', ARGV[7], '*', 'seq', seq, 'event', ARGV[5], 'previous', current)
+local previous = cjson.encode({status = seat['status'], held_by = seat['held_by']})
+local id = redis.call('XADD', KEYS[5], 'MAXLEN', '~', ARGV[9], '*', 'seq', seq, 'event', ARGV[5], 'previous', previous)
+if ARGV[11] ~= '' then
+	redis.call('ZADD', KEYS[6], tonumber(string.match(id, '^%d+')), id)
+	redis.call('ZREMRANGEBYRANK', KEYS[6], 0, -tonumber(ARGV[10]) - 1)
+end
+return seq
+`)
+
+// extendLockScript moves a lock's expiry only if it is still held by the
+// expected holder. Returns 1 if it was moved, 0 otherwise.
+//
+// KEYS[1] = seat lock, ARGV[1] = holder, ARGV[2] = TTL in milliseconds
+var extendLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+`)
+
+// redisSeatStore keeps seats in a hash, hold locks in keys with a TTL and the
+// expiry index in a sorted set scored in milliseconds. Seat events go to the
+// outbox stream drained by the outbox relay.
+type redisSeatStore struct {
+	client *redis.Client
+	ctx    context.Context // carries the trace of the request using the store
+}
+
+// NewRedisSeatStore returns a SeatStore backed by client
+func NewRedisSeatStore(client *redis.Client) SeatStore {
+	return &redisSeatStore{client: client, ctx: ctx}
+}
+
+// WithContext returns the store making its calls with ctx, so they join the
+// trace of the request it came from
+func (s *redisSeatStore) WithContext(ctx context.Context) SeatStore {
+	return &redisSeatStore{client: s.client, ctx: ctx}
+}
+
+func (s *redisSeatStore) GetSeat(seatID string) (*shared.Seat, error) {
+	seatJSON, err := s.client.HGet(s.ctx, shared.RedisKeyVenueSeats, seatID).Result()
+	if err == redis.Nil {
+		return nil, errSeatNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var seat shared.Seat
+	if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
+		return nil, err
+	}
+	return &seat, nil
+}
+
+func (s *redisSeatStore) GetSeats(seatIDs []string) ([]shared.Seat, error) {
+	if len(seatIDs) == 0 {
+		return nil, nil
+	}
+	values, err := s.client.HMGet(s.ctx, shared.RedisKeyVenueSeats, seatIDs...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	seats := make([]shared.Seat, 0, len(values))
+	for _, value := range values {
+		seatJSON, ok := value.(string)
+		if !ok {
+			continue // no such seat
+		}
+		var seat shared.Seat
+		if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
+			return nil, err
+		}
+		seats = append(seats, seat)
+	}
+	return seats, nil
+}
+
+func (s *redisSeatStore) AllSeats() ([]shared.Seat, error) {
+	seatMap, err := s.client.HGetAll(s.ctx, shared.RedisKeyVenueSeats).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	seats := make([]shared.Seat, 0, len(seatMap))
+	for _, seatJSON := range seatMap {
+		var seat shared.Seat
+		if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
+			slog.Error("Failed to unmarshal seat", shared.ErrAttr(err))
+			continue
+		}
+		seats = append(seats, seat)
+	}
+	return seats, nil
+}
+
+func (s *redisSeatStore) EventSeq() (int64, error) {
+	seq, err := s.client.Get(s.ctx, shared.RedisKeyEventSeq).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return seq, err
+}
+
+func (s *redisSeatStore) UpdateSeat(seat *shared.Seat, expectedVersion int64, topic string, event []byte) error {
+	return s.updateSeat(seat, expectedVersion, topic, event, "")
+}
+
+func (s *redisSeatStore) UpdateSeatDroppingLock(seat *shared.Seat, expectedVersion int64, topic string, event []byte, holder string) error {
+	return s.updateSeat(seat, expectedVersion, topic, event, holder)
+}
+
+// updateSeat runs the CAS script, deleting the seat's lock too if lockHolder
+// is given and still holds it
+func (s *redisSeatStore) updateSeat(seat *shared.Seat, expectedVersion int64, topic string, event []byte, lockHolder string) error {
+	seatJSON, err := json.Marshal(seat)
+	if err != nil {
+		return err
+	}
+	var audited struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.Unmarshal(event, &audited); err != nil {
+		return err
+	}
+
+	result, err := casSeatScript.Run(s.ctx, s.client,
+		[]string{
+			shared.RedisKeyVenueSeats, shared.RedisKeyOutbox, shared.RedisKeyEventSeq, fmt.Sprintf(shared.RedisKeySeatHistory, seat.ID),
+			shared.RedisKeyAuditLog, fmt.Sprintf(shared.RedisKeyAuditUser, audited.UserID), fmt.Sprintf(shared.RedisKeySeatLock, seat.ID),
+		},
+		seat.ID, expectedVersion, seatJSON, topic, event, outboxMaxLen, seatHistoryMaxLen,
+		shared.InjectTraceparent(s.ctx), auditLogMaxLen, auditUserIndexMaxLen, audited.UserID, lockHolder).Int64()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result > 0:
+		return nil
+	case result == -1:
+		return errSeatNotFound
+	default:
+		return errSeatVersionStale
+	}
+}
+
+func (s *redisSeatStore) AcquireLock(seatID, holder string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(s.ctx, fmt.Sprintf(shared.RedisKeySeatLock, seatID), holder, lockTTL(ttl)).Result()
+}
+
+func (s *redisSeatStore) LockHolder(seatID string) (string, error) {
+	holder, err := s.client.Get(s.ctx, fmt.Sprintf(shared.RedisKeySeatLock, seatID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return holder, err
+}
+
+func (s *redisSeatStore) ExtendLock(seatID, holder string, ttl time.Duration) (bool, error) {
+	extended, err := extendLockScript.Run(s.ctx, s.client,
+		[]string{fmt.Sprintf(shared.RedisKeySeatLock, seatID)}, holder, lockTTL(ttl).Milliseconds()).Int()
+	return extended == 1, err
+}
+
+func (s *redisSeatStore) ReleaseLock(seatID string) error {
+	return s.client.Del(s.ctx, fmt.Sprintf(shared.RedisKeySeatLock, seatID)).Err()
+}
+
+func (s *redisSeatStore) IndexHold(seatID string, expiresAt time.Time) error {
+	return s.client.ZAdd(s.ctx, shared.RedisKeyHoldExpiry, &redis.Z{
+		Score:  float64(expiresAt.UnixMilli()),
+		Member: seatID,
+	}).Err()
+}
+
+func (s *redisSeatStore) UnindexHold(seatID string) error {
+	return s.client.ZRem(s.ctx, shared.RedisKeyHoldExpiry, seatID).Err()
+}
+
+func (s *redisSeatStore) HoldExpiry(seatID string) (time.Time, bool, error) {
+	score, err := s.client.ZScore(s.ctx, shared.RedisKeyHoldExpiry, seatID).Result()
+	if err == redis.Nil {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(int64(score)), true, nil
+}
+
+func (s *redisSeatStore) ExpiredHolds(now time.Time) ([]string, error) {
+	return s.client.ZRangeByScore(s.ctx, shared.RedisKeyHoldExpiry, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", now.UnixMilli()),
+	}).Result()
+}
+
+func (s *redisSeatStore) IndexedHolds() (map[string]time.Time, error) {
+	entries, err := s.client.ZRangeWithScores(s.ctx, shared.RedisKeyHoldExpiry, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	holds := make(map[string]time.Time, len(entries))
+	for _, entry := range entries {
+		seatID, _ := entry.Member.(string)
+		holds[seatID] = time.UnixMilli(int64(entry.Score))
+	}
+	return holds, nil
+}
+
+func (s *redisSeatStore) SeatHistory(seatID string) ([]SeatTransition, error) {
+	entries, err := s.client.XRange(s.ctx, fmt.Sprintf(shared.RedisKeySeatHistory, seatID), "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTransitions(entries), nil
+}
+
+// parseTransitions reads seat history or audit log entries, skipping any that
+// cannot be read
+func parseTransitions(entries []redis.XMessage) []SeatTransition {
+	transitions := make([]SeatTransition, 0, len(entries))
+	for _, entry := range entries {
+		seqStr, _ := entry.Values["seq"].(string)
+		eventJSON, _ := entry.Values["event"].(string)
+		previousJSON, _ := entry.Values["previous"].(string)
+
+		seq, _ := strconv.ParseInt(seqStr, 10, 64)
+		var previous shared.Seat
+		if err := json.Unmarshal([]byte(previousJSON), &previous); err != nil {
+			slog.Warn("Skipping unreadable history entry", "entry_id", entry.ID, shared.ErrAttr(err))
+			continue
+		}
+		transition, err := newSeatTransition(seq, []byte(eventJSON), previous)
+		if err != nil {
+			slog.Warn("Skipping unreadable history entry", "entry_id", entry.ID, shared.ErrAttr(err))
+			continue
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions
+}
+
+func (s *redisSeatStore) ChangedSince(seq int64) ([]string, bool, error) {
+	var changes changeSet
+	end := "+"
+	for {
+		entries, err := s.client.XRevRangeN(s.ctx, shared.RedisKeyAuditLog, end, "-", auditScanBatch).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, transition := range parseTransitions(entries) {
+			if !changes.add(transition, seq) {
+				return changes.seatIDs, true, nil
+			}
+		}
+		if len(entries) < auditScanBatch {
+			return changes.seatIDs, changes.complete(seq), nil
+		}
+		end = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// auditScanBatch is how many audit log entries a search reads at a time when
+// no index narrows it
+const auditScanBatch = 500
+
+// SearchAudit narrows the search with the user index or the seats' history
+// streams when the query names users or seats, else scans the audit log over
+// the time range until enough entries match
+func (s *redisSeatStore) SearchAudit(q AuditQuery) ([]SeatTransition, error) {
+	start, end := "-", "+"
+	if !q.From.IsZero() {
+		start = strconv.FormatInt(q.From.UnixMilli(), 10)
+	}
+	if !q.To.IsZero() {
+		end = strconv.FormatInt(q.To.UnixMilli(), 10)
+	}
+
+	var candidates []SeatTransition
+	switch {
+	case len(q.Users.Include) > 0:
+		min, max := "-inf", "+inf"
+		if start != "-" {
+			min = start
+		}
+		if end != "+" {
+			max = end
+		}
+		pipe := s.client.Pipeline()
+		var reads []*redis.XMessageSliceCmd
+		for _, userID := range q.Users.Include {
+			ids, err := s.client.ZRangeByScore(s.ctx, fmt.Sprintf(shared.RedisKeyAuditUser, userID), &redis.ZRangeBy{Min: min, Max: max}).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				reads = append(reads, pipe.XRange(s.ctx, shared.RedisKeyAuditLog, id, id))
+			}
+		}
+		if len(reads) > 0 {
+			if _, err := pipe.Exec(s.ctx); err != nil {
+				return nil, err
+			}
+		}
+		for _, read := range reads {
+			// Entries trimmed from the log since they were indexed come back empty
+			candidates = append(candidates, parseTransitions(read.Val())...)
+		}
+
+	case len(q.Seats.Include) > 0:
+		pipe := s.client.Pipeline()
+		reads := make([]*redis.XMessageSliceCmd, len(q.Seats.Include))
+		for i, seatID := range q.Seats.Include {
+			reads[i] = pipe.XRange(s.ctx, fmt.Sprintf(shared.RedisKeySeatHistory, seatID), start, end)
+		}
+		if _, err := pipe.Exec(s.ctx); err != nil {
+			return nil, err
+		}
+		for _, read := range reads {
+			candidates = append(candidates, parseTransitions(read.Val())...)
+		}
+
+	default:
+		var matches []SeatTransition
+		for {
+			entries, err := s.client.XRangeN(s.ctx, shared.RedisKeyAuditLog, start, end, auditScanBatch).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, transition := range parseTransitions(entries) {
+				if q.Matches(transition) {
+					matches = append(matches, transition)
+				}
+			}
+			if len(matches) >= q.Limit || len(entries) < auditScanBatch {
+				break
+			}
+			start = "(" + entries[len(entries)-1].ID
+		}
+		return limitTransitions(matches, q.Limit), nil
+	}
+
+	return q.Filter(candidates), nil
+}
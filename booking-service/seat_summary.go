@@ -0,0 +1,59 @@
+package main
+
+import (
+	"concert-booking/shared"
+)
+
+// TierCounts is shared.SeatCounts for a price tier, with its price range
+type TierCounts struct {
+	shared.SeatCounts
+	MinPriceCents int64 `json:"min_price_cents"`
+	MaxPriceCents int64 `json:"max_price_cents"`
+}
+
+// SeatSummary is the availability of a venue, without the seats themselves
+type SeatSummary struct {
+	shared.SeatCounts
+	Seq      int64                        `json:"seq"` // seat event sequence number the counts reflect
+	Sections map[string]shared.SeatCounts `json:"sections"`
+	Tiers    map[string]TierCounts        `json:"tiers"`
+}
+
+// GetSeatSummary counts the venue's seats by status, section and tier
+func GetSeatSummary() (*SeatSummary, error) {
+	// As with snapshots, the counts reflect at least every event up to seq
+	seq, err := seatStore.EventSeq()
+	if err != nil {
+		return nil, err
+	}
+	seats, err := seatStore.AllSeats()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &SeatSummary{
+		Seq:      seq,
+		Sections: make(map[string]shared.SeatCounts),
+		Tiers:    make(map[string]TierCounts),
+	}
+	for _, seat := range seats {
+		summary.Add(seat.Status)
+
+		if seat.Section != "" {
+			section := summary.Sections[seat.Section]
+			section.Add(seat.Status)
+			summary.Sections[seat.Section] = section
+		}
+
+		if seat.Tier != "" {
+			tier, seen := summary.Tiers[seat.Tier]
+			if !seen || seat.PriceCents < tier.MinPriceCents {
+				tier.MinPriceCents = seat.PriceCents
+			}
+			tier.MaxPriceCents = max(tier.MaxPriceCents, seat.PriceCents)
+			tier.Add(seat.Status)
+			summary.Tiers[seat.Tier] = tier
+		}
+	}
+	return summary, nil
+}
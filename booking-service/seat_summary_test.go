@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+	"concert-booking/shared/fixtures"
+)
+
+func TestSeatSummaryCountsByStatusSectionAndTier(t *testing.T) {
+	newTestRedis(t)
+	seatStore = NewMemorySeatStore(fixtures.Venue().
+		Tier("premium", 9000).Section("front", 1, 4).
+		Tier("standard", 5000).Section("back", 2, 3).
+		Seats())
+	fixtures.On(seatStore).
+		Hold(shared.GetSeatID(0, 0), "alice", time.Now().Add(time.Minute)).
+		Book(shared.GetSeatID(0, 1), "bob").
+		Book(shared.GetSeatID(2, 2), "carol").
+		MustApply(t)
+
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats/summary", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET summary = %d: %s", w.Code, w.Body)
+	}
+	var summary SeatSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("decode summary: %v", err)
+	}
+
+	if want := (shared.SeatCounts{Total: 10, Available: 7, Held: 1, Booked: 2}); summary.SeatCounts != want {
+		t.Errorf("overall = %+v, want %+v", summary.SeatCounts, want)
+	}
+	if want := (shared.SeatCounts{Total: 4, Available: 2, Held: 1, Booked: 1}); summary.Sections["front"] != want {
+		t.Errorf("front = %+v, want %+v", summary.Sections["front"], want)
+	}
+	if want := (shared.SeatCounts{Total: 6, Available: 5, Booked: 1}); summary.Sections["back"] != want {
+		t.Errorf("back = %+v, want %+v", summary.Sections["back"], want)
+	}
+	if tier := summary.Tiers["premium"]; tier.Available != 2 || tier.MinPriceCents != 9000 || tier.MaxPriceCents != 9000 {
+		t.Errorf("premium = %+v, want 2 available at 9000", tier)
+	}
+	if summary.Seq == 0 || w.Header().Get(shared.HeaderEventSeq) == "" {
+		t.Errorf("summary seq = %d, want the sequence of the fixture events", summary.Seq)
+	}
+}
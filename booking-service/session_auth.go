@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"concert-booking/shared"
+)
+
+// sessionClaims verifies the request's "Authorization: Bearer <token>" against
+// AUTH_SECRET, the secret the edges check session tokens with
+// (EDGE_AUTH_SECRET), and returns its claims
+func sessionClaims(c *gin.Context) (*shared.SessionClaims, error) {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return shared.ParseSessionToken([]byte(os.Getenv("AUTH_SECRET")), token, time.Now())
+}
+
+// sameUser lets a request through only when its session token is for the user
+// named by the :id parameter, or for an admin. Without AUTH_SECRET nobody can
+// be identified, so every request is refused.
+func sameUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv("AUTH_SECRET") == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, shared.ErrorResponse{Code: shared.ErrorCodeUnavailable, Error: "User authentication is not configured"})
+			return
+		}
+		claims, err := sessionClaims(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse(http.StatusUnauthorized, err))
+			return
+		}
+		if claims.UserID != c.Param("id") && claims.Role != shared.RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, shared.ErrorResponse{Code: shared.ErrorCodeForbidden, Error: "Token is for a different user"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminOnly lets a request through only with an admin's session token: 401
+// without a valid token, 403 for any other role. Without AUTH_SECRET nobody
+// can be identified, so every request is refused.
+func adminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if os.Getenv("AUTH_SECRET") == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, shared.ErrorResponse{Code: shared.ErrorCodeUnavailable, Error: "Admin authentication is not configured"})
+			return
+		}
+		claims, err := sessionClaims(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse(http.StatusUnauthorized, err))
+			return
+		}
+		if claims.Role != shared.RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, shared.ErrorResponse{Code: shared.ErrorCodeForbidden, Error: "Admin role required"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+// adminRouter serves the routes with AUTH_SECRET set, adding an admin's token
+// to every request that comes without one
+func adminRouter(t *testing.T) http.Handler {
+	t.Helper()
+
+	t.Setenv("AUTH_SECRET", "test-secret")
+	router := setupRoutes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", bearerToken("ops", shared.RoleAdmin))
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+func TestAdminRoutesNeedAnAdminToken(t *testing.T) {
+	newTestRedis(t)
+	router := setupRoutes()
+	get := func(path, authorization string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(shared.APIPrefix+"/admin/flags", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("without AUTH_SECRET = %d, want 503", code)
+	}
+
+	t.Setenv("AUTH_SECRET", "test-secret")
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"a buyer", bearerToken("alice", shared.RoleBuyer), http.StatusForbidden},
+		{"a viewer", bearerToken("alice", shared.RoleViewer), http.StatusForbidden},
+		{"an admin", bearerToken("ops", shared.RoleAdmin), http.StatusOK},
+	}
+	for _, tt := range tests {
+		// The legacy prefix is as public as the versioned one
+		for _, prefix := range []string{shared.APIPrefix, shared.APIPrefixLegacy} {
+			if code := get(prefix+"/admin/flags", tt.authorization); code != tt.want {
+				t.Errorf("%s: GET %s/admin/flags = %d, want %d", tt.name, prefix, code, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Overall system states reported on the public status page
+const (
+	SystemOperational = "operational"
+	SystemDegraded    = "degraded"
+	SystemOutage      = "outage"
+)
+
+// Sales states reported on the public status page besides those of
+// shared.EventInfo.SaleState
+const (
+	SalesPaused      = "paused" // open, but an operator turned on the sales_paused flag
+	SalesUnavailable = "unavailable"
+)
+
+// StatusResponse is the public, unauthenticated summary of system health
+type StatusResponse struct {
+	Status     string            `json:"status"`
+	Sales      string            `json:"sales"`
+	Degraded   []string          `json:"degraded,omitempty"`
+	Components map[string]string `json:"components"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+
+	// QueueActive is whether buyers are waiting in an edge's waiting room to
+	// be let in, and Waiting how many, as of the edges' latest heartbeats
+	QueueActive bool `json:"queue_active"`
+	Waiting     int  `json:"waiting,omitempty"`
+}
+
+var (
+	statusMu     sync.Mutex
+	cachedStatus *StatusResponse
+)
+
+func handleStatus(c *gin.Context) {
+	status := getStatus()
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(shared.StatusCacheTTL.Seconds())))
+	c.JSON(http.StatusOK, status)
+}
+
+// getStatus returns the cached status, recomputing it once the cache has gone stale
+func getStatus() StatusResponse {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	if cachedStatus != nil && time.Since(cachedStatus.UpdatedAt) < shared.StatusCacheTTL {
+		return *cachedStatus
+	}
+
+	status := computeStatus()
+	cachedStatus = &status
+	return status
+}
+
+func computeStatus() StatusResponse {
+	components := map[string]string{
+		"redis": SystemOperational,
+		"nats":  SystemOperational,
+		"venue": SystemOperational,
+	}
+
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		components["redis"] = SystemOutage
+		components["venue"] = SystemOutage
+	} else if exists, err := redisClient.Exists(ctx, shared.RedisKeyVenueSeats).Result(); err != nil || exists == 0 {
+		components["venue"] = SystemOutage
+	}
+
+	if shared.EventBusKind() == shared.EventBusRedis {
+		// Seat events travel through Redis; NATS is not used
+		delete(components, "nats")
+	} else if natsConn == nil || !natsConn.IsConnected() {
+		// Seat operations still work, but live updates stop reaching browsers
+		components["nats"] = SystemDegraded
+	}
+
+	status := StatusResponse{
+		Status:     SystemOperational,
+		Components: components,
+		UpdatedAt:  time.Now(),
+	}
+
+	for _, name := range []string{"redis", "nats", "venue"} {
+		switch components[name] {
+		case SystemOutage:
+			status.Status = SystemOutage
+			status.Degraded = append(status.Degraded, name)
+		case SystemDegraded:
+			if status.Status == SystemOperational {
+				status.Status = SystemDegraded
+			}
+			status.Degraded = append(status.Degraded, name)
+		}
+	}
+
+	status.Sales = SalesUnavailable
+	if components["redis"] != SystemOutage && components["venue"] != SystemOutage {
+		if event, err := GetEventInfo(); err == nil {
+			status.Sales = event.SaleState(time.Now())
+		}
+		if status.Sales == shared.SaleOpen {
+			if paused, err := salesPaused(); err == nil && paused {
+				status.Sales = SalesPaused
+			}
+		}
+	}
+
+	if userRouter != nil {
+		status.Waiting = userRouter.Waiting(shared.DefaultEventID)
+		status.QueueActive = status.Waiting > 0
+	}
+
+	return status
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestStatusReportsComponentsAndSales(t *testing.T) {
+	mr := newTestRedis(t)
+	t.Setenv("EVENT_BUS", "")
+
+	// Without NATS seats still sell, but live updates stop
+	status := computeStatus()
+	if status.Status != SystemDegraded || status.Sales != shared.SaleOpen || !reflect.DeepEqual(status.Degraded, []string{"nats"}) {
+		t.Errorf("without NATS = %+v, want degraded on nats with sales open", status)
+	}
+
+	t.Setenv("EVENT_BUS", shared.EventBusRedis)
+	if status := computeStatus(); status.Status != SystemOperational || len(status.Degraded) != 0 || status.Components["nats"] != "" {
+		t.Errorf("on the Redis bus = %+v, want operational without a nats component", status)
+	}
+
+	now := time.Now().Unix()
+	for _, tt := range []struct {
+		name  string
+		event shared.EventInfo
+		want  string
+	}{
+		{"before the sale", shared.EventInfo{SalesOpenAt: now + 3600}, shared.SaleUpcoming},
+		{"within the window", shared.EventInfo{SalesOpenAt: now - 3600, SalesCloseAt: now + 3600}, shared.SaleOpen},
+		{"after the sale", shared.EventInfo{SalesOpenAt: now - 7200, SalesCloseAt: now - 3600}, shared.SaleClosed},
+		{"a cancelled event", shared.EventInfo{SalesOpenAt: now + 3600, CancelledAt: now}, shared.SaleCancelled},
+	} {
+		tt.event.ID = shared.DefaultEventID
+		if err := saveEventInfo(&tt.event); err != nil {
+			t.Fatalf("saveEventInfo: %v", err)
+		}
+		if status := computeStatus(); status.Sales != tt.want {
+			t.Errorf("%s: sales = %s, want %s", tt.name, status.Sales, tt.want)
+		}
+	}
+
+	mr.Close()
+	status = computeStatus()
+	if status.Status != SystemOutage || status.Sales != SalesUnavailable || !reflect.DeepEqual(status.Degraded, []string{"redis", "venue"}) {
+		t.Errorf("with Redis down = %+v, want an outage of redis and venue with sales unavailable", status)
+	}
+}
+
+func TestStatusIsCachedAndCacheable(t *testing.T) {
+	mr := newTestRedis(t)
+	t.Setenv("EVENT_BUS", shared.EventBusRedis)
+	statusMu.Lock()
+	cachedStatus = nil
+	statusMu.Unlock()
+	router := setupRoutes()
+
+	get := func() (*httptest.ResponseRecorder, StatusResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		var status StatusResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("unmarshal %s: %v", w.Body, err)
+		}
+		return w, status
+	}
+
+	w, status := get()
+	if w.Code != http.StatusOK || status.Status != SystemOperational || w.Header().Get("Cache-Control") == "" {
+		t.Fatalf("/status = %d %+v (Cache-Control %q), want 200, operational and cacheable", w.Code, status, w.Header().Get("Cache-Control"))
+	}
+
+	// Within the cache TTL an outage is not noticed yet
+	mr.Close()
+	if _, cached := get(); cached.Status != SystemOperational || !cached.UpdatedAt.Equal(status.UpdatedAt) {
+		t.Errorf("cached /status = %+v, want the earlier report", cached)
+	}
+}
+
+func TestStatusReportsPausedSalesAndAnActiveQueue(t *testing.T) {
+	newTestRedis(t)
+	t.Setenv("EVENT_BUS", shared.EventBusRedis)
+	router := &UserRouter{
+		userEdges:   make(map[string]map[string]bool),
+		edgeSeen:    make(map[string]time.Time),
+		edgeWaiting: make(map[string]map[string]int),
+	}
+	userRouter = router
+	t.Cleanup(func() { userRouter = nil })
+
+	if status := computeStatus(); status.Sales != shared.SaleOpen || status.QueueActive {
+		t.Errorf("status = %+v, want sales open with no queue", status)
+	}
+
+	router.heartbeat(shared.EdgeHeartbeat{ID: "edge-1", Events: map[string]shared.EventConnStats{
+		shared.DefaultEventID: {Connected: 500, Cap: 500, Waiting: 40},
+	}})
+	router.heartbeat(shared.EdgeHeartbeat{ID: "edge-2", Events: map[string]shared.EventConnStats{
+		shared.DefaultEventID: {Connected: 500, Cap: 500, Waiting: 2},
+		"other":               {Waiting: 9},
+	}})
+	if _, err := SetFeatureFlag(shared.FlagSalesPaused, true); err != nil {
+		t.Fatalf("SetFeatureFlag: %v", err)
+	}
+	if status := computeStatus(); status.Sales != SalesPaused || !status.QueueActive || status.Waiting != 42 {
+		t.Errorf("status = %+v, want sales paused with 42 buyers queued", status)
+	}
+	if err := SelectSeat(ctx, shared.GetSeatID(0, 0), "alice", 0); !errors.Is(err, errSalesPaused) {
+		t.Errorf("SelectSeat while paused = %v, want errSalesPaused", err)
+	}
+
+	// Queues drain, and edges that stop sending heartbeats stop counting
+	router.heartbeat(shared.EdgeHeartbeat{ID: "edge-1"})
+	router.pruneEdges(time.Now().Add(2 * edgePresenceTimeout))
+	if status := computeStatus(); status.QueueActive || status.Waiting != 0 {
+		t.Errorf("status = %+v, want no queue", status)
+	}
+}
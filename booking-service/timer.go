@@ -1,135 +1,226 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
 
 	"concert-booking/shared"
+)
+
+const (
+	// Resolution of the in-process hold expiry wheel
+	holdWheelTick = 50 * time.Millisecond
 
-	"github.com/go-redis/redis/v8"
-	"github.com/nats-io/nats.go"
+	// Slots per wheel level; four levels cover far more than any hold duration
+	holdWheelSlots  = 64
+	holdWheelLevels = 4
 )
 
-func StartTimerService(redisClient *redis.Client, natsConn *nats.Conn) {
-	ticker := time.NewTicker(shared.TimerCheckInterval)
-	go func() {
-		for range ticker.C {
-			checkExpiredHolds(redisClient, natsConn)
+// holdWheel fires hold expirations with sub-second precision. The sorted-set
+// expiry index stays the durable record: the wheel is loaded from it at startup,
+// and the periodic sweep still catches holds scheduled by another instance.
+// With no holds pending the wheel stops ticking until the next one is placed.
+var holdWheel *TimingWheel
+
+func StartTimerService(store SeatStore) {
+	if err := rebuildExpiryIndex(store); err != nil {
+		slog.Warn("Failed to rebuild expiry index", shared.ErrAttr(err))
+	}
+
+	holdWheel = NewTimingWheel(holdWheelTick, holdWheelSlots, holdWheelLevels, func(key string) {
+		if seatID, ok := strings.CutPrefix(key, holdWarningPrefix); ok {
+			warnHoldExpiring(store, seatID)
+			return
 		}
-	}()
-	log.Println("Timer service started - checking every", shared.TimerCheckInterval)
+
+		seatID := key
+		released, err := expireHold(store, seatID, clock.Now())
+		if err != nil {
+			slog.Error("Failed to auto-release seat", shared.LogKeySeatID, seatID, shared.ErrAttr(err))
+		} else if released {
+			slog.Info("Auto-released expired seat", shared.LogKeySeatID, seatID)
+		}
+	})
+	if err := loadHoldWheel(store); err != nil {
+		slog.Warn("Failed to load hold timers, relying on the sweep", shared.ErrAttr(err))
+	}
+	holdWheel.Start()
+
+	everyTick(timerCheckInterval(), func() { checkExpiredHolds(store) })
+	slog.Info("Timer service started", "hold_wheel_tick", holdWheelTick, "sweep_interval", timerCheckInterval())
+}
+
+// Wheel keys with this prefix warn the holder instead of releasing the seat
+const holdWarningPrefix = "warn:"
+
+// scheduleHoldExpiry arms the in-process timers for a hold, if the timer service runs here
+func scheduleHoldExpiry(seatID string, expiresAt time.Time) {
+	if holdWheel != nil {
+		holdWheel.Schedule(seatID, expiresAt)
+		holdWheel.Schedule(holdWarningPrefix+seatID, expiresAt.Add(-holdExpiryWarning()))
+	}
+}
+
+// cancelHoldExpiry disarms a hold's in-process timers
+func cancelHoldExpiry(seatID string) {
+	if holdWheel != nil {
+		holdWheel.Cancel(seatID)
+		holdWheel.Cancel(holdWarningPrefix + seatID)
+	}
 }
 
-func checkExpiredHolds(redisClient *redis.Client, natsConn *nats.Conn) {
-	ctx := context.Background()
-	currentTime := time.Now().Unix()
+// warnHoldExpiring tells a seat's holder that their hold is about to expire,
+// unless they turned those warnings off
+func warnHoldExpiring(store SeatStore, seatID string) {
+	seat, err := store.GetSeat(seatID)
+	if err != nil || seat.Status != shared.SeatHeld {
+		return
+	}
+
+	err = notifyUser(seat.HeldBy, shared.MessageTypeHoldExpiring, map[string]interface{}{
+		"seat_id":    seat.ID,
+		"expires_at": seat.ExpiresAt,
+	})
+	if err != nil && err != errUserOffline {
+		slog.Warn("Failed to warn user about expiring hold", shared.LogKeySeatID, seatID, shared.LogKeyUserID, seat.HeldBy, shared.ErrAttr(err))
+	}
+}
+
+// loadHoldWheel schedules a timer for every hold in the expiry index
+func loadHoldWheel(store SeatStore) error {
+	holds, err := store.IndexedHolds()
+	if err != nil {
+		return err
+	}
+	for seatID, expiresAt := range holds {
+		scheduleHoldExpiry(seatID, expiresAt)
+	}
+
+	slog.Info("Hold wheel loaded", "timers", len(holds))
+	return nil
+}
+
+// checkExpiredHolds is the backstop sweep over the expiry index
+func checkExpiredHolds(store SeatStore) {
+	now := clock.Now()
 	expiredCount := 0
-	
-	// Get all seats from Redis
-	seatMap, err := redisClient.HGetAll(ctx, shared.RedisKeyVenueSeats).Result()
+
+	// Only fetch seats whose hold expired before now from the expiry index
+	expiredIDs, err := store.ExpiredHolds(now)
 	if err != nil {
-		log.Printf("Error fetching seats for timer check: %v", err)
+		slog.Error("Failed to fetch expired holds for timer check", shared.ErrAttr(err))
 		return
 	}
 
-	// Check each seat for expiration
-	for seatID, seatJSON := range seatMap {
-		var seat shared.Seat
-		if err := json.Unmarshal([]byte(seatJSON), &seat); err != nil {
-			log.Printf("Error unmarshaling seat %s: %v", seatID, err)
+	for _, seatID := range expiredIDs {
+		released, err := expireHold(store, seatID, now)
+		if err != nil {
+			slog.Error("Failed to auto-release seat", shared.LogKeySeatID, seatID, shared.ErrAttr(err))
 			continue
 		}
-
-		// Only check held seats with expiration times
-		if seat.Status == shared.SeatHeld && seat.ExpiresAt > 0 && seat.ExpiresAt < currentTime {
-			// This seat has expired, release it
-			if err := autoReleaseSeat(redisClient, natsConn, &seat); err != nil {
-				log.Printf("Error auto-releasing seat %s: %v", seatID, err)
-				continue
-			}
+		if released {
 			expiredCount++
-			log.Printf("Auto-released expired seat %s (was held by %s)", seat.ID, seat.HeldBy)
+			slog.Info("Auto-released expired seat", shared.LogKeySeatID, seatID)
 		}
 	}
-	
+
 	if expiredCount > 0 {
-		log.Printf("Timer: Released %d expired holds", expiredCount)
+		slog.Info("Timer released expired holds", "count", expiredCount)
 	}
 }
 
-func autoReleaseSeat(redisClient *redis.Client, natsConn *nats.Conn, seat *shared.Seat) error {
-	ctx := context.Background()
-	
-	// Check if lock still exists (it should have expired naturally)
-	lockKey := fmt.Sprintf(shared.RedisKeySeatLock, seat.ID)
-	lockExists, _ := redisClient.Exists(ctx, lockKey).Result()
-	
-	// If lock still exists somehow, delete it
-	if lockExists > 0 {
-		redisClient.Del(ctx, lockKey)
+// expireHold releases seatID if its hold has expired by now. It reports false
+// without error when there is nothing to release, e.g. the hold was booked,
+// released or renewed, or another instance released it first.
+func expireHold(store SeatStore, seatID string, now time.Time) (bool, error) {
+	expiresAt, ok, err := store.HoldExpiry(seatID)
+	if err != nil || !ok {
+		return false, err
+	}
+	if expiresAt.After(now) {
+		// Renewed since the timer was set
+		return false, nil
+	}
+
+	seat, err := store.GetSeat(seatID)
+	if err == errSeatNotFound {
+		// Seat no longer exists, drop the stale index entry
+		store.UnindexHold(seatID)
+		return false, nil
 	}
-	
-	// Reset seat to available status
-	previousHolder := seat.HeldBy
-	seat.Status = shared.SeatAvailable
-	seat.HeldBy = ""
-	seat.ExpiresAt = 0
-	
-	// Update seat in Redis
-	updatedJSON, err := json.Marshal(seat)
 	if err != nil {
-		return err
+		return false, err
 	}
-	
-	if err := redisClient.HSet(ctx, shared.RedisKeyVenueSeats, seat.ID, updatedJSON).Err(); err != nil {
-		return err
+
+	// The hold may have been booked or released since it was indexed
+	if seat.Status != shared.SeatHeld || seat.ExpiresAt == 0 {
+		store.UnindexHold(seatID)
+		return false, nil
 	}
-	
-	// Publish release event to NATS with full seat data
-	event := shared.SeatEvent{
-		Type:      "auto_released",
-		SeatID:    seat.ID,
-		UserID:    previousHolder,
-		Status:    seat.Status,
-		Timestamp: time.Now(),
-		ExpiresAt: 0,
-		Seat:      seat,
+	if seat.ExpiresAt > now.Unix() {
+		return false, nil
 	}
-	
-	eventJSON, err := json.Marshal(event)
+
+	err = autoReleaseSeat(store, seat)
+	if err == errSeatVersionStale {
+		// Changed under us, e.g. released by another instance's sweep
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// rebuildExpiryIndex indexes any held seats missing from the expiry index,
+// e.g. holds created before the index existed
+func rebuildExpiryIndex(store SeatStore) error {
+	seats, err := store.AllSeats()
 	if err != nil {
-		log.Printf("[ERROR] Failed to marshal auto-release event for seat %s: %v", seat.ID, err)
-		return nil // Don't fail the release just because of event publishing
+		return err
 	}
-	
-	// Publish with retry
-	maxRetries := 3
-	published := false
-	for i := 0; i < maxRetries; i++ {
-		if err := natsConn.Publish(shared.NATSTopicSeatReleased, eventJSON); err != nil {
-			if i == maxRetries-1 {
-				log.Printf("[ERROR] Failed to publish auto-release event for seat %s after %d attempts: %v", 
-					seat.ID, maxRetries, err)
-			} else {
-				log.Printf("[WARN] Retry %d/%d: Failed to publish auto-release event: %v", 
-					i+1, maxRetries, err)
-				time.Sleep(100 * time.Millisecond)
+
+	indexed := 0
+	for _, seat := range seats {
+		if seat.Status == shared.SeatHeld && seat.ExpiresAt > 0 {
+			// Already indexed with millisecond precision
+			if expiresAt, ok, err := store.HoldExpiry(seat.ID); err == nil && ok && expiresAt.UnixMilli() >= seat.ExpiresAt*1000 {
+				indexed++
+				continue
 			}
-		} else {
-			log.Printf("[INFO] Published auto-release event for seat %s (was held by %s)", 
-				seat.ID, previousHolder)
-			published = true
-			break
+
+			// The index is scored in milliseconds. Without the precise expiry, use
+			// the end of the second the hold expires in; this also migrates
+			// entries scored in seconds by older versions.
+			if err := store.IndexHold(seat.ID, time.UnixMilli(seat.ExpiresAt*1000+999)); err != nil {
+				return err
+			}
+			indexed++
 		}
 	}
+
+	slog.Info("Expiry index rebuilt", "active_holds", indexed)
+	return nil
+}
+
+func autoReleaseSeat(store SeatStore, seat *shared.Seat) error {
+	// Reset seat to available status
+	previousHolder := seat.HeldBy
+	seat.Status = shared.SeatAvailable
+	seat.HeldBy = ""
+	seat.ExpiresAt = 0
+	seat.HeldAt = 0
 	
-	if !published {
-		// Log failure but don't fail the operation
-		log.Printf("[WARN] Seat %s was released but event notification failed", seat.ID)
+	// Update the stored seat, unless it was booked or re-held since we read it.
+	// The lock normally expired with the hold; if it is still there and still
+	// the previous holder's it goes with the update, while a lock someone else
+	// has taken since is left to them.
+	if err := casEndHold(store, seat, seat.Version, "auto_released", previousHolder, previousHolder, sourceExpiry); err != nil {
+		return err
 	}
+
+	// Drop the seat from the expiry index
+	store.UnindexHold(seat.ID)
+	cancelHoldExpiry(seat.ID)
+	countHoldGivenUp(previousHolder)
 	
 	return nil
-}
\ No newline at end of file
+}
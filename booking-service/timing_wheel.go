@@ -0,0 +1,220 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// TimingWheel is a hierarchical timing wheel. Level 0 has one slot per tick;
+// each higher level has slots spanning a full turn of the level below, and its
+// timers cascade down as their turn approaches. Scheduling and cancelling are
+// O(1), so every hold can have its own timer without a sorted structure.
+type TimingWheel struct {
+	tick  time.Duration
+	slots int64
+	start time.Time
+	fire  func(key string)
+
+	mu sync.Mutex
+
+	// Ticks processed so far
+	now int64
+
+	// levels[l][s] maps key to deadline tick
+	levels [][]map[string]int64
+
+	// Where each scheduled key currently lives
+	timers map[string]wheelPos
+
+	// Between Start and Stop the wheel ticks on the clock, except while no
+	// timers are scheduled; Schedule wakes it
+	running  bool
+	sleeping bool
+	next     ClockTimer
+}
+
+type wheelPos struct {
+	level int
+	slot  int64
+}
+
+// NewTimingWheel creates a wheel with the given resolution. With slots per
+// level and levels levels it covers tick*slots^levels before clamping.
+func NewTimingWheel(tick time.Duration, slots, levels int, fire func(key string)) *TimingWheel {
+	w := &TimingWheel{
+		tick:   tick,
+		slots:  int64(slots),
+		start:  clock.Now(),
+		fire:   fire,
+		levels: make([][]map[string]int64, levels),
+		timers: make(map[string]wheelPos),
+	}
+	for l := range w.levels {
+		w.levels[l] = make([]map[string]int64, slots)
+		for s := range w.levels[l] {
+			w.levels[l][s] = make(map[string]int64)
+		}
+	}
+	return w
+}
+
+// Schedule fires key at or shortly after at, replacing any earlier schedule for it
+func (w *TimingWheel) Schedule(key string, at time.Time) {
+	// Round up so a timer never fires before its deadline
+	deadline := (at.Sub(w.start) + w.tick - 1) / w.tick
+
+	w.mu.Lock()
+	if w.sleeping {
+		// Every slot is empty, so the ticks slept through can be skipped
+		w.now = max(w.now, int64(clock.Now().Sub(w.start)/w.tick))
+		w.sleeping = false
+		w.next = clock.AfterFunc(w.tick, w.onTick)
+	}
+	w.remove(key)
+	w.place(key, int64(deadline), 1)
+	w.mu.Unlock()
+}
+
+// Cancel removes key's timer, if any
+func (w *TimingWheel) Cancel(key string) {
+	w.mu.Lock()
+	w.remove(key)
+	w.mu.Unlock()
+}
+
+// Len returns the number of pending timers
+func (w *TimingWheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.timers)
+}
+
+// Start advances the wheel on the clock until Stop. While no timers are
+// scheduled it stops ticking until the next Schedule.
+func (w *TimingWheel) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running {
+		return
+	}
+	w.running = true
+	w.next = clock.AfterFunc(w.tick, w.onTick)
+}
+
+// Stop stops the wheel ticking; timers still scheduled do not fire
+func (w *TimingWheel) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = false
+	w.sleeping = false
+	if w.next != nil {
+		w.next.Stop()
+		w.next = nil
+	}
+}
+
+// onTick fires the timers due by now and sets the next tick, or puts the
+// wheel to sleep if nothing is left scheduled
+func (w *TimingWheel) onTick() {
+	w.mu.Lock()
+	running := w.running
+	w.mu.Unlock()
+	if !running {
+		return
+	}
+
+	// Catch up on any ticks missed while callbacks ran
+	for _, key := range w.advanceTo(int64(clock.Now().Sub(w.start) / w.tick)) {
+		w.fire(key)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.running {
+		return
+	}
+	w.sleeping = len(w.timers) == 0
+	w.next = nil
+	if !w.sleeping {
+		w.next = clock.AfterFunc(w.tick, w.onTick)
+	}
+}
+
+// Sleeping reports whether the wheel has stopped ticking for lack of timers
+func (w *TimingWheel) Sleeping() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sleeping
+}
+
+// advanceTo processes every tick up to target and returns the keys that expired
+func (w *TimingWheel) advanceTo(target int64) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var expired []string
+	for w.now < target {
+		w.now++
+
+		// Bring down timers from higher levels whose turn has come, top first
+		span := int64(1)
+		for l := 1; l < len(w.levels); l++ {
+			span *= w.slots
+		}
+		for l := len(w.levels) - 1; l > 0; l-- {
+			if w.now%span == 0 {
+				w.cascade(l, (w.now/span)%w.slots)
+			}
+			span /= w.slots
+		}
+
+		slot := w.levels[0][w.now%w.slots]
+		for key, deadline := range slot {
+			if deadline <= w.now {
+				delete(slot, key)
+				delete(w.timers, key)
+				expired = append(expired, key)
+			}
+		}
+	}
+	return expired
+}
+
+// cascade re-places every timer in one slot of a higher level. It runs before
+// the current level 0 slot is scanned, so timers due now still fire this tick.
+func (w *TimingWheel) cascade(level int, slot int64) {
+	timers := w.levels[level][slot]
+	w.levels[level][slot] = make(map[string]int64)
+	for key, deadline := range timers {
+		w.place(key, deadline, 0)
+	}
+}
+
+// place puts key in the lowest level whose range covers its deadline, at least
+// minDelta ticks ahead of the current one
+func (w *TimingWheel) place(key string, deadline, minDelta int64) {
+	delta := max(deadline-w.now, minDelta)
+
+	span := int64(1)
+	for l := range w.levels {
+		if delta < span*w.slots || l == len(w.levels)-1 {
+			// Beyond the top level's range the timer parks in its furthest slot
+			// and is re-placed when that slot cascades
+			target := min(w.now+delta, w.now+span*w.slots-1)
+			slot := (target / span) % w.slots
+			w.levels[l][slot][key] = deadline
+			w.timers[key] = wheelPos{level: l, slot: slot}
+			return
+		}
+		span *= w.slots
+	}
+}
+
+func (w *TimingWheel) remove(key string) {
+	pos, ok := w.timers[key]
+	if !ok {
+		return
+	}
+	delete(w.levels[pos.level][pos.slot], key)
+	delete(w.timers, key)
+}
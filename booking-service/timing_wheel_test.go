@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimingWheelFiresEachTimerOnItsTick(t *testing.T) {
+	const slots, levels = 8, 3
+	w := NewTimingWheel(time.Millisecond, slots, levels, nil)
+
+	// Deadlines across every level, slot boundaries, and past the wheel's range
+	deadlines := []int64{1, 2, 7, 8, 9, 15, 16, 63, 64, 65, 100, 511, 512, 513, 1500}
+	for _, d := range deadlines {
+		w.Schedule(fmt.Sprint(d), w.start.Add(time.Duration(d)*time.Millisecond))
+	}
+
+	fired := make(map[string]int64)
+	for tick := int64(1); tick <= 1600; tick++ {
+		for _, key := range w.advanceTo(tick) {
+			fired[key] = tick
+		}
+	}
+
+	for _, d := range deadlines {
+		if got, ok := fired[fmt.Sprint(d)]; !ok || got != d {
+			t.Errorf("timer due at tick %d fired at %d (fired: %v)", d, got, ok)
+		}
+	}
+	if w.Len() != 0 {
+		t.Errorf("%d timers left in the wheel", w.Len())
+	}
+}
+
+func TestTimingWheelRescheduleAndCancel(t *testing.T) {
+	w := NewTimingWheel(time.Millisecond, 8, 3, nil)
+	at := func(d int64) time.Time { return w.start.Add(time.Duration(d) * time.Millisecond) }
+
+	w.Schedule("renewed", at(5))
+	w.Schedule("renewed", at(40))
+	w.Schedule("cancelled", at(10))
+	w.Cancel("cancelled")
+
+	if fired := w.advanceTo(39); len(fired) != 0 {
+		t.Fatalf("fired early: %v", fired)
+	}
+	if fired := w.advanceTo(40); len(fired) != 1 || fired[0] != "renewed" {
+		t.Fatalf("fired %v at tick 40, want [renewed]", fired)
+	}
+
+	// Overdue timers fire on the next tick
+	w.Schedule("overdue", at(3))
+	if fired := w.advanceTo(41); len(fired) != 1 || fired[0] != "overdue" {
+		t.Fatalf("fired %v, want [overdue]", fired)
+	}
+}
+
+func TestTimingWheelSleepsUntilScheduled(t *testing.T) {
+	fired := make(chan string, 1)
+	w := NewTimingWheel(time.Millisecond, 8, 3, func(key string) { fired <- key })
+
+	w.Start()
+	defer w.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for !w.Sleeping() {
+		if time.Now().After(deadline) {
+			t.Fatal("wheel kept ticking with nothing scheduled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Idle through many turns of the lowest level, then schedule a timer
+	time.Sleep(100 * time.Millisecond)
+	w.Schedule("hold", time.Now().Add(5*time.Millisecond))
+
+	select {
+	case key := <-fired:
+		if key != "hold" {
+			t.Fatalf("fired %q, want hold", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer scheduled while asleep never fired")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"concert-booking/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var otelTracer = otel.Tracer("concert-booking/booking-service")
+
+// tracing continues the trace of an incoming request from its traceparent
+// header, or starts one, and makes it the request context
+func tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parent := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		spanCtx, span := otelTracer.Start(parent, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(spanCtx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
+
+// startCommandSpan continues the trace and the correlation ID carried in a
+// NATS command's headers
+func startCommandSpan(msg *nats.Msg) (context.Context, trace.Span) {
+	parent := context.Background()
+	if msg.Header != nil {
+		parent = otel.GetTextMapPropagator().Extract(parent, propagation.HeaderCarrier(msg.Header))
+		parent = shared.WithCorrelationID(parent, msg.Header.Get(shared.HeaderCorrelationID))
+	}
+	return otelTracer.Start(parent, "nats "+msg.Subject, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// contextualSeatStore is implemented by stores whose calls can carry a
+// request's context, so their work shows up in its trace
+type contextualSeatStore interface {
+	WithContext(ctx context.Context) SeatStore
+}
+
+// seatStoreFor returns seatStore bound to ctx where the store supports it
+func seatStoreFor(ctx context.Context) SeatStore {
+	if store, ok := seatStore.(contextualSeatStore); ok {
+		return store.WithContext(ctx)
+	}
+	return seatStore
+}
+
+// redisSpanKey marks a context holding a span started by redisTracingHook, so
+// the hook never ends a span it did not start
+type redisSpanKey struct{}
+
+// redisTracingHook records a child span for every Redis command made within a
+// traced request. Commands outside one (timers, the outbox relay) are not traced.
+type redisTracingHook struct{}
+
+func (redisTracingHook) start(ctx context.Context, name string, attrs ...attribute.KeyValue) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, _ = otelTracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.system", "redis"))...))
+	return context.WithValue(ctx, redisSpanKey{}, true)
+}
+
+func (redisTracingHook) end(ctx context.Context, err error) {
+	if ctx.Value(redisSpanKey{}) == nil {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (h redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.start(ctx, "redis "+cmd.Name(), attribute.String("db.operation", cmd.Name())), nil
+}
+
+func (h redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.end(ctx, cmd.Err())
+	return nil
+}
+
+func (h redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+	return h.start(ctx, "redis pipeline", attribute.String("db.operation", strings.Join(names, " "))), nil
+}
+
+func (h redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+			err = cmdErr
+			break
+		}
+	}
+	h.end(ctx, err)
+	return nil
+}
+
+// publishSpan starts the span of relaying one outbox event to the bus, as a
+// child of the request that caused it. Entries without a traceparent were not
+// caused by a traced request and get a no-op span.
+func publishSpan(traceparent, topic, seatID string) trace.Span {
+	if traceparent == "" {
+		return trace.SpanFromContext(context.Background())
+	}
+	parent := shared.ExtractTraceparent(context.Background(), traceparent)
+	_, span := otelTracer.Start(parent, "publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", topic),
+			shared.AttrSeatID.String(seatID),
+		))
+	return span
+}
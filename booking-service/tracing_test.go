@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"concert-booking/shared"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestSelectSeatContinuesTheEdgeTrace(t *testing.T) {
+	newTestRedis(t)
+	redisClient.AddHook(redisTracingHook{})
+	recorder := recordSpans(t)
+	gin.SetMode(gin.TestMode)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	seatID := shared.GetSeatID(0, 4)
+	req := httptest.NewRequest(http.MethodPost, "/api/seats/select",
+		bytes.NewBufferString(`{"seat_id":"`+seatID+`","user_id":"user-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	w := httptest.NewRecorder()
+	setupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("select returned %d: %s", w.Code, w.Body)
+	}
+
+	var server sdktrace.ReadOnlySpan
+	redisSpans := 0
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %q is outside the edge's trace", span.Name())
+		}
+		switch {
+		case span.Name() == "POST /api/seats/select":
+			server = span
+		case strings.HasPrefix(span.Name(), "redis "):
+			redisSpans++
+		}
+	}
+	if server == nil || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("no server span continuing the edge's span")
+	}
+	if redisSpans == 0 {
+		t.Fatal("Redis calls made no child spans")
+	}
+
+	// The relay publishes the event as part of the same trace
+	entries := redisClient.XRange(ctx, shared.RedisKeyOutbox, "-", "+").Val()
+	if len(entries) != 1 {
+		t.Fatalf("outbox has %d entries, want 1", len(entries))
+	}
+	traceparent, _ := entries[0].Values["traceparent"].(string)
+	if len(traceparent) != 55 || traceparent[3:35] != traceID {
+		t.Fatalf("outbox traceparent = %q, want one in trace %s", traceparent, traceID)
+	}
+}
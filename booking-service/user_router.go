@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+var errUserOffline = errors.New("user is not connected to any edge")
+
+// Edges silent for this long are presumed gone along with their users
+const edgePresenceTimeout = 4 * shared.EdgeHeartbeatTimeout
+
+// userRouter tracks which edge servers host each user, so the booking service
+// knows who is connected
+var userRouter *UserRouter
+
+// UserRouter maps users to the edges they are connected to, from the presence
+// events and heartbeats edges publish
+type UserRouter struct {
+	nc *nats.Conn
+
+	mu          sync.Mutex
+	userEdges   map[string]map[string]bool // user ID -> edge IDs
+	edgeSeen    map[string]time.Time       // edge ID -> last heartbeat or presence event
+	edgeWaiting map[string]map[string]int  // edge ID -> event ID -> clients in its waiting room
+}
+
+// StartUserRouter subscribes to presence and asks edges to announce the users
+// they already host
+func StartUserRouter(natsConn *nats.Conn) error {
+	router := &UserRouter{
+		nc:          natsConn,
+		userEdges:   make(map[string]map[string]bool),
+		edgeSeen:    make(map[string]time.Time),
+		edgeWaiting: make(map[string]map[string]int),
+	}
+
+	if _, err := natsConn.Subscribe(shared.NATSTopicUserPresence, func(msg *nats.Msg) {
+		var presence shared.UserPresence
+		if err := json.Unmarshal(msg.Data, &presence); err != nil {
+			slog.Warn("Ignoring malformed presence event", shared.ErrAttr(err))
+			return
+		}
+		router.observe(presence)
+	}); err != nil {
+		return err
+	}
+
+	if _, err := natsConn.Subscribe(shared.NATSTopicEdgeHeartbeat, func(msg *nats.Msg) {
+		var hb shared.EdgeHeartbeat
+		if err := json.Unmarshal(msg.Data, &hb); err != nil {
+			return
+		}
+		router.heartbeat(hb)
+	}); err != nil {
+		return err
+	}
+
+	if err := natsConn.Publish(shared.NATSTopicPresenceSync, nil); err != nil {
+		slog.Warn("Failed to request presence sync", shared.ErrAttr(err))
+	}
+
+	go func() {
+		ticker := time.NewTicker(shared.EdgeHeartbeatTimeout)
+		defer ticker.Stop()
+		for range ticker.C {
+			router.pruneEdges(time.Now())
+		}
+	}()
+
+	userRouter = router
+	slog.Info("User router started")
+	return nil
+}
+
+// heartbeat notes an edge is alive and how many clients wait in its waiting rooms
+func (r *UserRouter) heartbeat(hb shared.EdgeHeartbeat) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.edgeSeen[hb.ID] = time.Now()
+	waiting := make(map[string]int, len(hb.Events))
+	for eventID, stats := range hb.Events {
+		if stats.Waiting > 0 {
+			waiting[eventID] = stats.Waiting
+		}
+	}
+	r.edgeWaiting[hb.ID] = waiting
+}
+
+func (r *UserRouter) observe(presence shared.UserPresence) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.edgeSeen[presence.EdgeID] = time.Now()
+
+	edges := r.userEdges[presence.UserID]
+	if presence.Online {
+		if edges == nil {
+			edges = make(map[string]bool)
+			r.userEdges[presence.UserID] = edges
+		}
+		edges[presence.EdgeID] = true
+		return
+	}
+
+	delete(edges, presence.EdgeID)
+	if len(edges) == 0 {
+		delete(r.userEdges, presence.UserID)
+	}
+}
+
+// pruneEdges forgets edges that stopped sending heartbeats, e.g. after a crash
+// that gave them no chance to announce their users leaving
+func (r *UserRouter) pruneEdges(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for edgeID, seen := range r.edgeSeen {
+		if now.Sub(seen) <= edgePresenceTimeout {
+			continue
+		}
+		delete(r.edgeSeen, edgeID)
+		delete(r.edgeWaiting, edgeID)
+		for userID, edges := range r.userEdges {
+			delete(edges, edgeID)
+			if len(edges) == 0 {
+				delete(r.userEdges, userID)
+			}
+		}
+		slog.Warn("Edge stopped sending heartbeats, dropped its users", "edge_id", edgeID)
+	}
+}
+
+// SendToUser delivers a WebSocket message to every client of userID on
+// users.<id>.msgs, which every edge subscribes to. It is published even when
+// no edge has announced the user, as one they just connected to may not have
+// yet, and then returns errUserOffline.
+func (r *UserRouter) SendToUser(userID, msgType string, data interface{}) error {
+	messageJSON, err := json.Marshal(shared.DirectedMessage{UserID: userID, Type: msgType, Data: data})
+	if err != nil {
+		return err
+	}
+	if err := r.nc.Publish(shared.UserMsgsSubject(userID), messageJSON); err != nil {
+		return err
+	}
+
+	if !r.Online(userID) {
+		return errUserOffline
+	}
+	return nil
+}
+
+// Waiting returns how many clients wait to be let in to eventID across the
+// edges, as of their latest heartbeats
+func (r *UserRouter) Waiting(eventID string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := 0
+	for _, waiting := range r.edgeWaiting {
+		total += waiting[eventID]
+	}
+	return total
+}
+
+// Online reports whether userID is connected to any edge
+func (r *UserRouter) Online(userID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.userEdges[userID]) > 0
+}
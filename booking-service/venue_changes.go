@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+
+	"concert-booking/shared"
+)
+
+// errVenueChangesGone is returned for a sequence number the audit log no
+// longer reaches back to, or one the booking service never reached; the
+// caller needs a full snapshot instead
+var errVenueChangesGone = errors.New("changes since that sequence number are no longer kept, fetch the whole venue")
+
+// changeSet collects the seats changed after a sequence number while the
+// audit log is read newest first
+type changeSet struct {
+	seatIDs []string
+	seen    map[string]bool
+	oldest  int64 // lowest sequence number read
+}
+
+// add records a transition newer than seq and reports whether older ones may
+// still follow
+func (c *changeSet) add(t SeatTransition, seq int64) bool {
+	if t.Seq <= seq {
+		return false
+	}
+	if c.seen == nil {
+		c.seen = make(map[string]bool)
+	}
+	if !c.seen[t.SeatID] {
+		c.seen[t.SeatID] = true
+		c.seatIDs = append(c.seatIDs, t.SeatID)
+	}
+	c.oldest = t.Seq
+	return true
+}
+
+// complete reports whether a log read to its start covered every event after
+// seq
+func (c *changeSet) complete(seq int64) bool {
+	if c.oldest == 0 {
+		return seq == 0
+	}
+	return c.oldest == seq+1
+}
+
+// GetVenueChanges returns the seats changed after the event sequence number
+// since, as they are now, so a client holding the venue as of since can catch
+// up without fetching every seat
+func GetVenueChanges(since int64) (*shared.VenueChanges, error) {
+	// Read the sequence first: the changes reflect at least every event up to it
+	seq, err := seatStore.EventSeq()
+	if err != nil {
+		return nil, err
+	}
+	if since < 0 || since > seq {
+		// The sequence was reset, e.g. Redis was emptied and the venue rebuilt
+		return nil, errVenueChangesGone
+	}
+
+	seatIDs, ok, err := seatStore.ChangedSince(since)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errVenueChangesGone
+	}
+
+	seats := []shared.Seat{}
+	if len(seatIDs) > 0 {
+		if seats, err = seatStore.GetSeats(seatIDs); err != nil {
+			return nil, err
+		}
+	}
+	return &shared.VenueChanges{Since: since, Seq: seq, Seats: seats}, nil
+}
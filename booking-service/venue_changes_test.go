@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestVenueChangesListSeatsChangedSinceASequence(t *testing.T) {
+	forEachSeatStore(t, func(t *testing.T) {
+		held, booked := shared.GetSeatID(0, 0), shared.GetSeatID(1, 1)
+		if err := SelectSeat(ctx, shared.GetSeatID(2, 2), "user-1", 0); err != nil {
+			t.Fatal(err)
+		}
+		before, err := seatStore.EventSeq()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := SelectSeat(ctx, held, "user-1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := SelectSeat(ctx, booked, "user-2", 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := BookSeat(ctx, booked, "user-2", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		changes, err := GetVenueChanges(before)
+		if err != nil {
+			t.Fatalf("GetVenueChanges: %v", err)
+		}
+		if changes.Seq != before+3 || len(changes.Seats) != 2 {
+			t.Fatalf("changes = seq %d, %d seats, want seq %d and 2 seats", changes.Seq, len(changes.Seats), before+3)
+		}
+		for _, seat := range changes.Seats {
+			if (seat.ID == held && seat.Status != shared.SeatHeld) || (seat.ID == booked && seat.Status != shared.SeatBooked) {
+				t.Errorf("seat %s is %s, want its current status", seat.ID, seat.Status)
+			}
+		}
+
+		if changes, err := GetVenueChanges(before + 3); err != nil || len(changes.Seats) != 0 {
+			t.Errorf("changes since the latest event = %+v, %v, want none", changes, err)
+		}
+		if _, err := GetVenueChanges(before + 4); err != errVenueChangesGone {
+			t.Errorf("changes since a sequence never reached = %v, want %v", err, errVenueChangesGone)
+		}
+	})
+}
+
+func TestVenueChangesBeyondTheAuditLogAreGone(t *testing.T) {
+	newTestRedis(t)
+	for row := 0; row < 3; row++ {
+		if err := SelectSeat(ctx, shared.GetSeatID(row, 0), "user-1", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := redisClient.XTrimMaxLen(ctx, shared.RedisKeyAuditLog, 1).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetVenueChanges(1); err != errVenueChangesGone {
+		t.Errorf("changes since a trimmed event = %v, want %v", err, errVenueChangesGone)
+	}
+	if changes, err := GetVenueChanges(2); err != nil || len(changes.Seats) != 1 {
+		t.Errorf("changes still in the log = %+v, %v, want one seat", changes, err)
+	}
+
+	router := setupRoutes()
+	for query, want := range map[string]int{"?since=2": http.StatusOK, "?since=1": http.StatusGone, "?since=x": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/seats/changes"+query, nil))
+		if w.Code != want {
+			t.Errorf("GET changes%s = %d, want %d", query, w.Code, want)
+		}
+	}
+}
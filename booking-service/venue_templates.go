@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"concert-booking/shared"
+)
+
+// Maximum number of seats a template may generate
+const maxTemplateSeats = 100000
+
+// PriceTier is a named price band assigned to generated seats
+type PriceTier struct {
+	Name       string `json:"name"`
+	PriceCents int64  `json:"price_cents"`
+}
+
+// Price tiers used by the built-in templates
+var (
+	TierStandard = PriceTier{Name: "standard", PriceCents: 5000}
+	TierPremium  = PriceTier{Name: "premium", PriceCents: 9000}
+	TierLower    = PriceTier{Name: "lower", PriceCents: 12000}
+	TierUpper    = PriceTier{Name: "upper", PriceCents: 6000}
+	TierGA       = PriceTier{Name: "ga", PriceCents: 4000}
+	TierBalcony  = PriceTier{Name: "balcony", PriceCents: 6500}
+)
+
+// VenueTemplate generates a venue layout programmatically from a few parameters
+type VenueTemplate struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Defaults    map[string]int `json:"defaults"`
+	Tiers       []PriceTier    `json:"tiers"`
+
+	generate func(p map[string]int) []shared.Seat
+}
+
+var venueTemplates = map[string]VenueTemplate{
+	"grid": {
+		Name:        "grid",
+		Description: "Rectangular block of seats with a single price tier",
+		Defaults:    map[string]int{"rows": shared.VenueRows, "cols": shared.VenueCols},
+		Tiers:       []PriceTier{TierStandard},
+		generate:    generateGrid,
+	},
+	"theater": {
+		Name:        "theater",
+		Description: "Theater rows split into sections by aisles, with premium front rows",
+		Defaults:    map[string]int{"rows": 15, "seats_per_row": 24, "aisles": 2, "premium_rows": 3},
+		Tiers:       []PriceTier{TierPremium, TierStandard},
+		generate:    generateTheater,
+	},
+	"arena": {
+		Name:        "arena",
+		Description: "Arena bowl of numbered sections with lower and upper rows",
+		Defaults:    map[string]int{"sections": 8, "rows": 12, "seats_per_row": 14},
+		Tiers:       []PriceTier{TierLower, TierUpper},
+		generate:    generateArena,
+	},
+	"ga_balcony": {
+		Name:        "ga_balcony",
+		Description: "General admission floor with a seated balcony",
+		Defaults:    map[string]int{"floor_capacity": 200, "balcony_rows": 6, "balcony_seats_per_row": 20},
+		Tiers:       []PriceTier{TierGA, TierBalcony},
+		generate:    generateGABalcony,
+	},
+}
+
+// ListVenueTemplates returns all built-in templates sorted by name
+func ListVenueTemplates() []VenueTemplate {
+	templates := make([]VenueTemplate, 0, len(venueTemplates))
+	for _, t := range venueTemplates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates
+}
+
+// GenerateVenue builds the seat layout for a template, overriding its defaults with params
+func GenerateVenue(name string, params map[string]int) ([]shared.Seat, error) {
+	tmpl, ok := venueTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown venue template: %s", name)
+	}
+
+	merged := make(map[string]int, len(tmpl.Defaults))
+	for k, v := range tmpl.Defaults {
+		merged[k] = v
+	}
+	for k, v := range params {
+		if _, known := tmpl.Defaults[k]; !known {
+			return nil, fmt.Errorf("template %s has no parameter %q", name, k)
+		}
+		if v < 0 || (v == 0 && k != "aisles" && k != "premium_rows") {
+			return nil, fmt.Errorf("invalid value %d for parameter %q", v, k)
+		}
+		merged[k] = v
+	}
+
+	seats := tmpl.generate(merged)
+	if len(seats) == 0 {
+		return nil, fmt.Errorf("template %s generated no seats", name)
+	}
+	if len(seats) > maxTemplateSeats {
+		return nil, fmt.Errorf("template %s generated %d seats (max %d)", name, len(seats), maxTemplateSeats)
+	}
+	return seats, nil
+}
+
+// venueTemplateFromEnv reads VENUE_TEMPLATE and VENUE_TEMPLATE_PARAMS (e.g. "rows=20,cols=30")
+func venueTemplateFromEnv() (string, map[string]int, error) {
+	name := os.Getenv("VENUE_TEMPLATE")
+	if name == "" {
+		name = shared.DefaultVenueTemplate
+	}
+
+	params := make(map[string]int)
+	raw := os.Getenv("VENUE_TEMPLATE_PARAMS")
+	if raw == "" {
+		return name, params, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return "", nil, fmt.Errorf("invalid template parameter %q", pair)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid value for template parameter %q: %w", key, err)
+		}
+		params[key] = n
+	}
+	return name, params, nil
+}
+
+func newSeat(id string, row, col int, section string, tier PriceTier) shared.Seat {
+	return shared.Seat{
+		ID:         id,
+		Row:        row,
+		Col:        col,
+		Section:    section,
+		Tier:       tier.Name,
+		PriceCents: tier.PriceCents,
+		Status:     shared.SeatAvailable,
+		Version:    1,
+	}
+}
+
+func generateGrid(p map[string]int) []shared.Seat {
+	seats := make([]shared.Seat, 0, p["rows"]*p["cols"])
+	for row := 0; row < p["rows"]; row++ {
+		for col := 0; col < p["cols"]; col++ {
+			seats = append(seats, newSeat(shared.GetSeatID(row, col), row, col, "main", TierStandard))
+		}
+	}
+	return seats
+}
+
+func generateTheater(p map[string]int) []shared.Seat {
+	perRow := p["seats_per_row"]
+	blocks := p["aisles"] + 1
+	if blocks > perRow {
+		blocks = perRow
+	}
+
+	sectionNames := make([]string, blocks)
+	for i := range sectionNames {
+		sectionNames[i] = fmt.Sprintf("block-%d", i+1)
+	}
+	if blocks == 3 {
+		sectionNames = []string{"left", "center", "right"}
+	}
+
+	seats := make([]shared.Seat, 0, p["rows"]*perRow)
+	for row := 0; row < p["rows"]; row++ {
+		tier := TierStandard
+		if row < p["premium_rows"] {
+			tier = TierPremium
+		}
+		for n := 0; n < perRow; n++ {
+			// Each aisle crossed leaves an empty column in the layout
+			block := n * blocks / perRow
+			seats = append(seats, newSeat(shared.GetSeatID(row, n), row, n+block, sectionNames[block], tier))
+		}
+	}
+	return seats
+}
+
+func generateArena(p map[string]int) []shared.Seat {
+	rows, perRow := p["rows"], p["seats_per_row"]
+
+	seats := make([]shared.Seat, 0, p["sections"]*rows*perRow)
+	for s := 0; s < p["sections"]; s++ {
+		section := strconv.Itoa(101 + s)
+		// Sections sit side by side, separated by a one-column gangway
+		offset := s * (perRow + 1)
+		for row := 0; row < rows; row++ {
+			tier := TierUpper
+			if row < (rows+1)/2 {
+				tier = TierLower
+			}
+			for n := 0; n < perRow; n++ {
+				id := section + "-" + shared.GetSeatID(row, n)
+				seats = append(seats, newSeat(id, row, offset+n, section, tier))
+			}
+		}
+	}
+	return seats
+}
+
+func generateGABalcony(p map[string]int) []shared.Seat {
+	capacity, width := p["floor_capacity"], p["balcony_seats_per_row"]
+
+	seats := make([]shared.Seat, 0, capacity+p["balcony_rows"]*width)
+
+	// General admission spots are laid out in rows as wide as the balcony
+	for i := 0; i < capacity; i++ {
+		id := "GA" + strconv.Itoa(i+1)
+		seats = append(seats, newSeat(id, i/width, i%width, "floor", TierGA))
+	}
+
+	// Leave one empty row between the floor and the balcony
+	firstRow := (capacity+width-1)/width + 1
+	for row := 0; row < p["balcony_rows"]; row++ {
+		for n := 0; n < width; n++ {
+			id := "BAL-" + shared.GetSeatID(row, n)
+			seats = append(seats, newSeat(id, firstRow+row, n, "balcony", TierBalcony))
+		}
+	}
+	return seats
+}
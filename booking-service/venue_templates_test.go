@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerateVenueSectionsAndSeatIDs(t *testing.T) {
+	for _, tc := range []struct {
+		template string
+		params   map[string]int
+		sections map[string]int
+		ids      []string // first and last seat
+	}{
+		{"grid", map[string]int{"rows": 3, "cols": 4}, map[string]int{"main": 12}, []string{"A1", "C4"}},
+		{"theater", map[string]int{"rows": 2, "seats_per_row": 6, "aisles": 2, "premium_rows": 1},
+			map[string]int{"left": 4, "center": 4, "right": 4}, []string{"A1", "B6"}},
+		{"arena", map[string]int{"sections": 2, "rows": 2, "seats_per_row": 3},
+			map[string]int{"101": 6, "102": 6}, []string{"101-A1", "102-B3"}},
+		{"ga_balcony", map[string]int{"floor_capacity": 5, "balcony_rows": 1, "balcony_seats_per_row": 4},
+			map[string]int{"floor": 5, "balcony": 4}, []string{"GA1", "BAL-A4"}},
+	} {
+		seats, err := GenerateVenue(tc.template, tc.params)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.template, err)
+		}
+
+		sections := make(map[string]int)
+		seen := make(map[string]bool)
+		for _, seat := range seats {
+			sections[seat.Section]++
+			if seen[seat.ID] {
+				t.Errorf("%s: seat %s generated twice", tc.template, seat.ID)
+			}
+			seen[seat.ID] = true
+		}
+		if !reflect.DeepEqual(sections, tc.sections) {
+			t.Errorf("%s: sections %v, want %v", tc.template, sections, tc.sections)
+		}
+		if ids := []string{seats[0].ID, seats[len(seats)-1].ID}; !reflect.DeepEqual(ids, tc.ids) {
+			t.Errorf("%s: first and last seats %v, want %v", tc.template, ids, tc.ids)
+		}
+	}
+}
+
+func TestGenerateVenueTiersAndLayout(t *testing.T) {
+	seats, err := GenerateVenue("theater", map[string]int{"rows": 2, "seats_per_row": 4, "aisles": 1, "premium_rows": 1})
+	if err != nil {
+		t.Fatalf("GenerateVenue: %v", err)
+	}
+	if seats[0].Tier != TierPremium.Name || seats[4].Tier != TierStandard.Name || seats[4].PriceCents != TierStandard.PriceCents {
+		t.Errorf("tiers %s and %s, want premium front row then standard", seats[0].Tier, seats[4].Tier)
+	}
+	// Crossing the aisle skips a column
+	if seats[1].Col != 1 || seats[2].Col != 3 {
+		t.Errorf("columns %d and %d either side of the aisle, want 1 and 3", seats[1].Col, seats[2].Col)
+	}
+
+	// GA spots wrap at the balcony's width and the balcony starts a row clear of them
+	seats, err = GenerateVenue("ga_balcony", map[string]int{"floor_capacity": 5, "balcony_rows": 1, "balcony_seats_per_row": 4})
+	if err != nil {
+		t.Fatalf("GenerateVenue: %v", err)
+	}
+	if ga5, bal := seats[4], seats[5]; ga5.Row != 1 || ga5.Col != 0 || bal.Row != 3 {
+		t.Errorf("GA5 at %d,%d and balcony at row %d, want 1,0 and row 3", ga5.Row, ga5.Col, bal.Row)
+	}
+}
+
+func TestGenerateVenueRejectsBadParams(t *testing.T) {
+	for name, tc := range map[string]struct {
+		template string
+		params   map[string]int
+	}{
+		"unknown template":  {"stadium", nil},
+		"unknown parameter": {"grid", map[string]int{"sections": 2}},
+		"zero rows":         {"grid", map[string]int{"rows": 0}},
+		"negative aisles":   {"theater", map[string]int{"aisles": -1}},
+		"too many seats":    {"grid", map[string]int{"rows": 1000, "cols": 1000}},
+	} {
+		if _, err := GenerateVenue(tc.template, tc.params); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+
+	// Aisles and premium rows may be zero
+	if _, err := GenerateVenue("theater", map[string]int{"aisles": 0, "premium_rows": 0}); err != nil {
+		t.Errorf("theater without aisles or premium rows: %v", err)
+	}
+}
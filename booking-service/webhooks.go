@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// Approximate cap on events kept in the webhook stream. A webhook further
+	// behind misses the oldest, which its consumer sees as a gap in the
+	// sequence numbers.
+	webhookStreamMaxLen = 100000
+
+	// How long a seat event is remembered as appended, so the copy every
+	// instance receives is appended once
+	webhookSeenTTL = 10 * time.Minute
+
+	// Limits for per-registration batching configuration
+	maxWebhookBatchSize   = 1000
+	maxWebhookBatchWindow = 60 * time.Second
+
+	// Redelivery backoff after a failed delivery
+	webhookRetryMin = 1 * time.Second
+	webhookRetryMax = 30 * time.Second
+
+	// The dispatcher lease lasts webhookLeaseTTL and is renewed, and the
+	// registrations re-read, every webhookLeaseRenew
+	webhookLeaseTTL   = 15 * time.Second
+	webhookLeaseRenew = 5 * time.Second
+
+	// How often a webhook looks for events appended by other instances
+	webhookPoll = time.Second
+)
+
+// WebhookRegistration is a consumer endpoint that receives seat events
+type WebhookRegistration struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	BatchSize     int       `json:"batch_size"`      // max events per delivery (1 = per-event delivery)
+	BatchWindowMs int       `json:"batch_window_ms"` // max time an event waits for its batch to fill
+	Cursor        int64     `json:"cursor"`          // sequence of the last acknowledged event
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WebhookEvent is a seat event stamped with its webhook sequence number, which
+// every webhook sees the same and in order
+type WebhookEvent struct {
+	Seq   int64            `json:"seq"`
+	Event shared.SeatEvent `json:"event"`
+}
+
+// WebhookBatch is the payload POSTed to a webhook consumer. Batches are delivered in
+// order; a failed batch is redelivered from the same cursor until it is acknowledged
+// with a 2xx response.
+type WebhookBatch struct {
+	WebhookID string         `json:"webhook_id"`
+	Cursor    int64          `json:"cursor"` // sequence of the last event in this batch
+	Events    []WebhookEvent `json:"events"`
+}
+
+// webhookQueue delivers the webhook stream to one registration in batches.
+// Only its delivery loop uses reg.
+type webhookQueue struct {
+	reg    WebhookRegistration
+	notify chan struct{}
+	stop   chan struct{}
+}
+
+// webhookDispatcher delivers the webhook stream to all registered webhooks
+// while this instance holds the dispatcher lease
+type webhookDispatcher struct {
+	mu         sync.RWMutex
+	queues     map[string]*webhookQueue
+	leading    bool
+	httpClient *http.Client
+}
+
+var webhooks = &webhookDispatcher{
+	queues: make(map[string]*webhookQueue),
+	httpClient: &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// Checked again on every connection, in case the name now resolves inside
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}).DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	},
+}
+
+// appendWebhookScript appends an event to the webhook stream with the next
+// webhook sequence number as its entry ID. Given a TTL, the event is a seat
+// event every instance receives, and it is appended only if its marker was
+// not already set. Returns the sequence number, or 0 if the event was
+// already appended.
+//
+// KEYS[1] = webhook stream, KEYS[2] = webhook sequence counter, KEYS[3] = seat event's marker
+// ARGV[1] = event JSON, ARGV[2] = stream max length, ARGV[3] = marker TTL in milliseconds, or 0
+var appendWebhookScript = redis.NewScript(`
+if ARGV[3] ~= '0' and not redis.call('SET', KEYS[3], 1, 'NX', 'PX', ARGV[3]) then
+	return 0
+end
+local seq = redis.call('INCR', KEYS[2])
+redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[2], '0-' .. seq, 'event', ARGV[1])
+return seq
+`)
+
+// saveCursorScript stores a registration with a new cursor, provided it still
+// exists and its stored cursor is behind, so a dispatcher that lost its
+// lease can neither move a cursor back nor restore a deleted webhook.
+// Returns 1 if it was stored.
+//
+// KEYS[1] = webhooks hash, ARGV[1] = webhook ID, ARGV[2] = cursor, ARGV[3] = registration JSON
+var saveCursorScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if not current or (tonumber(cjson.decode(current)['cursor']) or 0) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+return 1
+`)
+
+// webhookLeaseScript takes the dispatcher lease for an instance if nobody
+// holds it, or extends it if the instance already does. Returns 1 if the
+// instance holds the lease.
+//
+// KEYS[1] = lease, ARGV[1] = instance, ARGV[2] = TTL in milliseconds
+var webhookLeaseScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+var errWebhookTarget = errors.New("url must not point at a loopback, private or link-local address")
+
+// publicAddr reports whether addr may receive webhooks: anything but loopback,
+// private, link-local and unspecified addresses, so a webhook cannot reach
+// services behind the booking service
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() && !addr.IsUnspecified()
+}
+
+// dialPublicOnly refuses webhook connections to addresses publicAddr rejects
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return errWebhookTarget
+	}
+	return nil
+}
+
+// checkWebhookHost rejects a webhook host that is, or resolves to, an address
+// publicAddr rejects
+func checkWebhookHost(host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !publicAddr(addr) {
+			return errWebhookTarget
+		}
+		return nil
+	}
+
+	resolveCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(resolveCtx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("url host %s does not resolve: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return errWebhookTarget
+		}
+	}
+	return nil
+}
+
+// StartWebhookDispatcher appends the seat events to the webhook stream and
+// starts competing for the dispatcher lease. Whichever instance holds it
+// delivers the stream to every webhook from its persisted cursor, so after a
+// restart or a change of instance unacknowledged events are redelivered
+// under the same sequence numbers rather than lost or renumbered.
+func StartWebhookDispatcher(bus shared.EventBus) error {
+	_, err := bus.Subscribe(shared.NATSTopicAllSeats, func(subject string, data []byte) {
+		var event shared.SeatEvent
+		if err := shared.UnmarshalEvent(data, &event); err != nil {
+			slog.Error("Failed to parse seat event", shared.LogKeyComponent, "webhook", shared.ErrAttr(err))
+			return
+		}
+		for _, event := range event.Unbatch() {
+			webhooks.enqueue(event)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "booking-service"
+	}
+	instance += "-" + uuid.NewString()
+
+	go webhooks.holdLease(instance)
+	slog.Info("Webhook dispatcher started", "instance", instance)
+	return nil
+}
+
+// RegisterWebhook validates and stores a new webhook registration
+func RegisterWebhook(rawURL string, batchSize, batchWindowMs int) (*WebhookRegistration, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.New("url must be an absolute http or https URL")
+	}
+	if err := checkWebhookHost(parsed.Hostname()); err != nil {
+		return nil, err
+	}
+
+	if batchSize == 0 {
+		batchSize = 1
+	}
+	if batchSize < 1 || batchSize > maxWebhookBatchSize {
+		return nil, fmt.Errorf("batch_size must be between 1 and %d", maxWebhookBatchSize)
+	}
+	if batchWindowMs < 0 || time.Duration(batchWindowMs)*time.Millisecond > maxWebhookBatchWindow {
+		return nil, fmt.Errorf("batch_window_ms must be between 0 and %d", maxWebhookBatchWindow.Milliseconds())
+	}
+
+	// Delivery starts with the next event
+	cursor, err := redisClient.Get(ctx, shared.RedisKeyWebhookSeq).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	reg := WebhookRegistration{
+		ID:            uuid.NewString(),
+		URL:           rawURL,
+		BatchSize:     batchSize,
+		BatchWindowMs: batchWindowMs,
+		Cursor:        cursor,
+		CreatedAt:     time.Now(),
+	}
+
+	if err := saveWebhook(reg); err != nil {
+		return nil, err
+	}
+
+	// Otherwise the instance holding the lease picks it up when it next
+	// renews the lease
+	webhooks.mu.RLock()
+	leading := webhooks.leading
+	webhooks.mu.RUnlock()
+	if leading {
+		webhooks.start(reg)
+	}
+	slog.Info("Registered webhook", shared.LogKeyComponent, "webhook", "webhook_id", reg.ID, "url", reg.URL, "batch_size", reg.BatchSize, "batch_window_ms", reg.BatchWindowMs)
+	return &reg, nil
+}
+
+// ListWebhooks returns all registrations with their acknowledged cursors
+func ListWebhooks() ([]WebhookRegistration, error) {
+	return loadWebhooks()
+}
+
+// DeleteWebhook removes a webhook's registration and stops delivery to it
+func DeleteWebhook(id string) error {
+	deleted, err := redisClient.HDel(ctx, shared.RedisKeyWebhooks, id).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return errWebhookNotFound
+	}
+
+	// Elsewhere the instance holding the lease stops when it next renews it
+	webhooks.mu.Lock()
+	if q, ok := webhooks.queues[id]; ok {
+		delete(webhooks.queues, id)
+		close(q.stop)
+	}
+	webhooks.mu.Unlock()
+	return nil
+}
+
+func saveWebhook(reg WebhookRegistration) error {
+	regJSON, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(ctx, shared.RedisKeyWebhooks, reg.ID, regJSON).Err()
+}
+
+func loadWebhooks() ([]WebhookRegistration, error) {
+	regMap, err := redisClient.HGetAll(ctx, shared.RedisKeyWebhooks).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	regs := make([]WebhookRegistration, 0, len(regMap))
+	for id, regJSON := range regMap {
+		var reg WebhookRegistration
+		if err := json.Unmarshal([]byte(regJSON), &reg); err != nil {
+			slog.Warn("Skipping invalid webhook registration", shared.LogKeyComponent, "webhook", "webhook_id", id, shared.ErrAttr(err))
+			continue
+		}
+		regs = append(regs, reg)
+	}
+	return regs, nil
+}
+
+// holdLease keeps competing for the dispatcher lease, delivering the webhooks
+// while this instance holds it
+func (d *webhookDispatcher) holdLease(instance string) {
+	for {
+		leading, err := d.renewLease(instance)
+		if err != nil {
+			// Another instance takes over once the lease runs out
+			slog.Warn("Failed to renew the webhook dispatcher lease", shared.LogKeyComponent, "webhook", shared.ErrAttr(err))
+		}
+		if leading {
+			d.sync()
+		} else {
+			d.standDown()
+		}
+		time.Sleep(webhookLeaseRenew)
+	}
+}
+
+// renewLease takes or extends the dispatcher lease for instance and reports
+// whether it holds it
+func (d *webhookDispatcher) renewLease(instance string) (bool, error) {
+	held, err := webhookLeaseScript.Run(ctx, redisClient, []string{shared.RedisKeyWebhookLease},
+		instance, webhookLeaseTTL.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.Lock()
+	if held == 1 && !d.leading {
+		slog.Info("Took the webhook dispatcher lease", shared.LogKeyComponent, "webhook", "instance", instance)
+	}
+	d.leading = held == 1
+	d.mu.Unlock()
+	return held == 1, nil
+}
+
+// sync starts delivering to the webhooks registered since it last ran, from
+// their persisted cursors, and stops delivering to those deleted
+func (d *webhookDispatcher) sync() {
+	regs, err := loadWebhooks()
+	if err != nil {
+		slog.Warn("Failed to load webhook registrations", shared.LogKeyComponent, "webhook", shared.ErrAttr(err))
+		return
+	}
+
+	registered := make(map[string]bool, len(regs))
+	for _, reg := range regs {
+		registered[reg.ID] = true
+		d.mu.RLock()
+		_, ok := d.queues[reg.ID]
+		d.mu.RUnlock()
+		if !ok {
+			d.start(reg)
+		}
+	}
+
+	d.mu.Lock()
+	for id, q := range d.queues {
+		if !registered[id] {
+			delete(d.queues, id)
+			close(q.stop)
+		}
+	}
+	d.mu.Unlock()
+}
+
+// standDown stops all delivery, leaving the unacknowledged events in the
+// stream for whichever instance takes the lease
+func (d *webhookDispatcher) standDown() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id, q := range d.queues {
+		delete(d.queues, id)
+		close(q.stop)
+	}
+}
+
+func (d *webhookDispatcher) start(reg WebhookRegistration) {
+	q := &webhookQueue{
+		reg:    reg,
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	d.mu.Lock()
+	d.queues[reg.ID] = q
+	d.mu.Unlock()
+
+	go d.deliverLoop(q)
+}
+
+// enqueue appends an event to the webhook stream. Seat events carry the
+// sequence number the outbox gave them and are appended once between all
+// instances; other events are appended by the instance they happen on.
+func (d *webhookDispatcher) enqueue(event shared.SeatEvent) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode webhook event", shared.LogKeyComponent, "webhook", shared.ErrAttr(err))
+		return
+	}
+	seenTTL := int64(0)
+	if event.Seq > 0 {
+		seenTTL = webhookSeenTTL.Milliseconds()
+	}
+
+	err = appendWebhookScript.Run(ctx, redisClient,
+		[]string{shared.RedisKeyWebhookEvents, shared.RedisKeyWebhookSeq, fmt.Sprintf(shared.RedisKeyWebhookSeen, event.Seq)},
+		eventJSON, webhookStreamMaxLen, seenTTL).Err()
+	if err != nil {
+		slog.Error("Failed to append webhook event", shared.LogKeyComponent, "webhook", "type", event.Type,
+			shared.LogKeySeatID, event.SeatID, shared.ErrAttr(err))
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, q := range d.queues {
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// readWebhookEvents returns up to count events of the webhook stream after
+// the one numbered after
+func readWebhookEvents(after int64, count int) ([]WebhookEvent, error) {
+	entries, err := redisClient.XRangeN(ctx, shared.RedisKeyWebhookEvents, fmt.Sprintf("(0-%d", after), "+", int64(count)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]WebhookEvent, 0, len(entries))
+	for _, entry := range entries {
+		var event WebhookEvent
+		event.Seq, _ = strconv.ParseInt(strings.TrimPrefix(entry.ID, "0-"), 10, 64)
+		eventJSON, _ := entry.Values["event"].(string)
+		if err := json.Unmarshal([]byte(eventJSON), &event.Event); err != nil {
+			slog.Warn("Skipping unreadable webhook event", shared.LogKeyComponent, "webhook", "seq", event.Seq, shared.ErrAttr(err))
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// deliverLoop sends batches for one webhook strictly in order, retrying a failed
+// batch until it is acknowledged before moving on to the next one
+func (d *webhookDispatcher) deliverLoop(q *webhookQueue) {
+	for {
+		batch, ok := q.nextBatch()
+		if !ok {
+			return
+		}
+
+		backoff := webhookRetryMin
+		for {
+			err := d.deliver(q.reg, batch)
+			if err == nil {
+				break
+			}
+
+			slog.Warn("Webhook delivery failed, retrying", shared.LogKeyComponent, "webhook",
+				"webhook_id", q.reg.ID, "cursor", batch.Cursor, "backoff", backoff, shared.ErrAttr(err))
+			select {
+			case <-time.After(backoff):
+			case <-q.stop:
+				return
+			}
+			backoff = min(backoff*2, webhookRetryMax)
+		}
+
+		q.ack(batch)
+	}
+}
+
+func (d *webhookDispatcher) deliver(reg WebhookRegistration, batch WebhookBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.httpClient.Post(reg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("consumer returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// nextBatch waits for the events after the cursor, then gives the batch until
+// its window closes to fill up. It reports false once delivery stops.
+func (q *webhookQueue) nextBatch() (WebhookBatch, bool) {
+	var window <-chan time.Time
+	due := false
+	for {
+		events, err := readWebhookEvents(q.reg.Cursor, q.reg.BatchSize)
+		if err != nil {
+			slog.Warn("Failed to read webhook events", shared.LogKeyComponent, "webhook", "webhook_id", q.reg.ID, shared.ErrAttr(err))
+		}
+		if len(events) > 0 {
+			if events[0].Seq > q.reg.Cursor+1 {
+				// Consumers detect the gap from the skipped sequence numbers
+				slog.Warn("Webhook events trimmed before delivery", shared.LogKeyComponent, "webhook",
+					"webhook_id", q.reg.ID, "cursor", q.reg.Cursor, "next_seq", events[0].Seq)
+			}
+			if due || len(events) >= q.reg.BatchSize || q.reg.BatchWindowMs == 0 {
+				return WebhookBatch{WebhookID: q.reg.ID, Cursor: events[len(events)-1].Seq, Events: events}, true
+			}
+			if window == nil {
+				timer := time.NewTimer(time.Duration(q.reg.BatchWindowMs) * time.Millisecond)
+				defer timer.Stop()
+				window = timer.C
+			}
+		}
+
+		select {
+		case <-q.notify:
+		case <-time.After(webhookPoll):
+		case <-window:
+			due = true
+		case <-q.stop:
+			return WebhookBatch{}, false
+		}
+	}
+}
+
+// ack advances the cursor past a delivered batch and persists it
+func (q *webhookQueue) ack(batch WebhookBatch) {
+	q.reg.Cursor = batch.Cursor
+	reg := q.reg
+
+	regJSON, err := json.Marshal(reg)
+	if err == nil {
+		err = saveCursorScript.Run(ctx, redisClient, []string{shared.RedisKeyWebhooks}, reg.ID, reg.Cursor, regJSON).Err()
+	}
+	if err != nil {
+		slog.Warn("Failed to persist webhook cursor", shared.LogKeyComponent, "webhook", "webhook_id", reg.ID, shared.ErrAttr(err))
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// webhookConsumer records the batches POSTed to it, failing the first failures
+func webhookConsumer(t *testing.T, failures int) (*httptest.Server, chan WebhookBatch) {
+	t.Helper()
+	batches := make(chan WebhookBatch, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch WebhookBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("decode batch: %v", err)
+		}
+		batches <- batch
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, batches
+}
+
+// testDispatcher delivers to loopback consumers, which the real one refuses
+func testDispatcher(t *testing.T, srv *httptest.Server, reg WebhookRegistration) *webhookDispatcher {
+	t.Helper()
+	reg.URL = srv.URL
+	d := &webhookDispatcher{queues: make(map[string]*webhookQueue), httpClient: srv.Client()}
+	d.start(reg)
+	t.Cleanup(func() { close(d.queues[reg.ID].stop) })
+	return d
+}
+
+// webhookEvents returns the events appended to the webhook stream
+func webhookEvents(t *testing.T) []WebhookEvent {
+	t.Helper()
+	events, err := readWebhookEvents(0, 1000)
+	if err != nil {
+		t.Fatalf("read webhook events: %v", err)
+	}
+	return events
+}
+
+func nextBatch(t *testing.T, batches chan WebhookBatch) WebhookBatch {
+	t.Helper()
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch delivered")
+		return WebhookBatch{}
+	}
+}
+
+func TestWebhookBatchesAreDeliveredInOrder(t *testing.T) {
+	newTestRedis(t)
+	srv, batches := webhookConsumer(t, 0)
+	d := testDispatcher(t, srv, WebhookRegistration{ID: "wh-order", BatchSize: 2, BatchWindowMs: 50})
+
+	for i := 1; i <= 5; i++ {
+		d.enqueue(shared.SeatEvent{Type: "held", SeatID: fmt.Sprintf("A%d", i)})
+	}
+
+	var seats []string
+	for _, want := range []struct {
+		cursor int64
+		size   int
+	}{{2, 2}, {4, 2}, {5, 1}} {
+		batch := nextBatch(t, batches)
+		if batch.WebhookID != "wh-order" || batch.Cursor != want.cursor || len(batch.Events) != want.size {
+			t.Fatalf("batch = %+v, want %d events up to cursor %d", batch, want.size, want.cursor)
+		}
+		for _, event := range batch.Events {
+			if int(event.Seq) != len(seats)+1 {
+				t.Fatalf("event seq %d after %d events", event.Seq, len(seats))
+			}
+			seats = append(seats, event.Event.SeatID)
+		}
+	}
+	if fmt.Sprint(seats) != "[A1 A2 A3 A4 A5]" {
+		t.Errorf("delivered %v, want A1 to A5 in order", seats)
+	}
+}
+
+func TestWebhookRedeliversFromItsCursorAfterAFailure(t *testing.T) {
+	newTestRedis(t)
+	srv, batches := webhookConsumer(t, 1)
+	redisClient.Set(ctx, shared.RedisKeyWebhookSeq, 41, 0)
+	saveWebhook(WebhookRegistration{ID: "wh-retry", Cursor: 41})
+	d := testDispatcher(t, srv, WebhookRegistration{ID: "wh-retry", BatchSize: 10, Cursor: 41})
+
+	d.enqueue(shared.SeatEvent{Type: "booked", SeatID: "B2"})
+
+	failed := nextBatch(t, batches)
+	retried := nextBatch(t, batches)
+	if failed.Cursor != 42 || retried.Cursor != 42 || len(retried.Events) != 1 || retried.Events[0].Event.SeatID != "B2" {
+		t.Fatalf("batches %+v then %+v, want B2 at cursor 42 twice", failed, retried)
+	}
+
+	// The acknowledged cursor is persisted so a restart resumes after it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		regJSON, err := redisClient.HGet(ctx, shared.RedisKeyWebhooks, "wh-retry").Result()
+		var reg WebhookRegistration
+		if err == nil && json.Unmarshal([]byte(regJSON), &reg) == nil && reg.Cursor == 42 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("persisted registration %s (%v), want cursor 42", regJSON, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d.enqueue(shared.SeatEvent{Type: "released", SeatID: "B2"})
+	if next := nextBatch(t, batches); next.Cursor != 43 || next.Events[0].Event.Type != "released" {
+		t.Errorf("next batch = %+v, want the release at cursor 43", next)
+	}
+}
+
+func TestSeatEventsAreAppendedOnceForAllInstances(t *testing.T) {
+	newTestRedis(t)
+	held := shared.SeatEvent{Type: "held", SeatID: "C3", Seq: 7}
+
+	// Every instance receives each seat event; events of their own are theirs alone
+	webhooks.enqueue(held)
+	webhooks.enqueue(held)
+	webhooks.enqueue(shared.SeatEvent{Type: "order_fulfilled", SeatID: "C3"})
+	webhooks.enqueue(shared.SeatEvent{Type: "order_fulfilled", SeatID: "C3"})
+
+	var got []string
+	for _, event := range webhookEvents(t) {
+		got = append(got, fmt.Sprintf("%d:%s", event.Seq, event.Event.Type))
+	}
+	if fmt.Sprint(got) != "[1:held 2:order_fulfilled 3:order_fulfilled]" {
+		t.Errorf("webhook stream = %v, want the seat event once", got)
+	}
+}
+
+func TestWebhookDeliveryResumesOnTheNextLeaseHolder(t *testing.T) {
+	mr := newTestRedis(t)
+	var acks atomic.Int32
+	acks.Store(1)
+	batches := make(chan WebhookBatch, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch WebhookBatch
+		json.NewDecoder(r.Body).Decode(&batch)
+		batches <- batch
+		if acks.Add(-1) < 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	saveWebhook(WebhookRegistration{ID: "wh-lease", URL: srv.URL, BatchSize: 1})
+
+	first := &webhookDispatcher{queues: make(map[string]*webhookQueue), httpClient: srv.Client()}
+	second := &webhookDispatcher{queues: make(map[string]*webhookQueue), httpClient: srv.Client()}
+	t.Cleanup(first.standDown)
+	t.Cleanup(second.standDown)
+	if leading, err := first.renewLease("first"); !leading || err != nil {
+		t.Fatalf("first renewLease = %v, %v; want the lease", leading, err)
+	}
+	if leading, _ := second.renewLease("second"); leading {
+		t.Fatal("second instance took a held lease")
+	}
+	first.sync()
+
+	for _, seatID := range []string{"D1", "D2"} {
+		first.enqueue(shared.SeatEvent{Type: "held", SeatID: seatID})
+	}
+	if batch := nextBatch(t, batches); batch.Cursor != 1 {
+		t.Fatalf("first batch = %+v, want cursor 1", batch)
+	}
+	if batch := nextBatch(t, batches); batch.Cursor != 2 {
+		t.Fatalf("second batch = %+v, want cursor 2", batch)
+	}
+
+	// The first instance dies with D2 unacknowledged and its lease runs out
+	first.standDown()
+	mr.FastForward(webhookLeaseTTL)
+	acks.Store(1)
+	if leading, err := second.renewLease("second"); !leading || err != nil {
+		t.Fatalf("second renewLease = %v, %v; want the lease", leading, err)
+	}
+	second.sync()
+
+	// D2 is redelivered under its sequence number, and D1 is not
+	if batch := nextBatch(t, batches); batch.Cursor != 2 || batch.Events[0].Event.SeatID != "D2" {
+		t.Fatalf("batch after the takeover = %+v, want D2 at cursor 2", batch)
+	}
+	second.enqueue(shared.SeatEvent{Type: "held", SeatID: "D3"})
+	if batch := nextBatch(t, batches); batch.Cursor != 3 {
+		t.Fatalf("batch = %+v, want D3 at cursor 3", batch)
+	}
+}
+
+func TestRegisterWebhookRefusesInternalTargets(t *testing.T) {
+	newTestRedis(t)
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://10.0.0.5/hook",
+		"https://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+		"ftp://example.com/hook",
+	} {
+		if reg, err := RegisterWebhook(rawURL, 0, 0); err == nil {
+			DeleteWebhook(reg.ID)
+			t.Errorf("%s: registered, want refused", rawURL)
+		}
+	}
+
+	if err := dialPublicOnly("tcp", "10.1.2.3:443", nil); err != errWebhookTarget {
+		t.Errorf("dialing a private address: got %v, want errWebhookTarget", err)
+	}
+	if !publicAddr(netip.MustParseAddr("93.184.216.34")) {
+		t.Error("a public address was refused")
+	}
+}
@@ -0,0 +1,231 @@
+// Package capacity turns a load test into sizing figures for an on-sale: how
+// many connections one edge server sustains, how many seat commands a second
+// one booking service instance answers, and how much headroom Redis has left.
+//
+// A load test cuts its run into one-second windows with a Sampler; Plan works
+// out from the windows what one instance of each tier sustained within the
+// latency SLO, and WriteMarkdown formats the result for a sizing document.
+package capacity
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How often a Sampler cuts the run into windows
+const WindowLength = time.Second
+
+// Window is what happened during one WindowLength of a run
+type Window struct {
+	AtSec    float64 `json:"at_sec"`              // end of the window, from the start of the run
+	Active   int     `json:"active"`              // clients connected with their seat map at its end
+	SeatOps  int     `json:"seat_ops"`            // selects, bookings and releases answered
+	Errors   int     `json:"errors"`              // seat commands not answered properly or in time
+	P99      float64 `json:"p99_ms"`              // of the seat commands answered
+	RedisOps int64   `json:"redis_ops,omitempty"` // commands Redis processed, if sampled
+}
+
+// Config is what turns a run's windows into sizing figures: the deployment
+// the load went through and the latency it must keep
+type Config struct {
+	Edges            int           // edge servers behind the URL
+	BookingInstances int           // booking service instances behind the edges
+	SLO              time.Duration // p99 seat command latency a window must keep to count as sustained
+	RedisAddr        string        // Redis to sample INFO from; "" not to
+	RedisMaxOps      float64       // commands a second the Redis deployment is rated for
+}
+
+// Report is the capacity planner's report: how much of each tier the load
+// showed one instance sustains, within the SLO and without errors
+type Report struct {
+	Edges            int     `json:"edges"`
+	BookingInstances int     `json:"booking_instances"`
+	SLOMs            float64 `json:"slo_p99_ms"`
+
+	// Most clients connected to one edge during a sustained window
+	MaxConnectionsPerEdge int `json:"max_connections_per_edge"`
+
+	// Most seat commands a second one booking service instance answered
+	// during a sustained window
+	BookingOpsPerSecPerInstance float64 `json:"booking_ops_per_sec_per_instance"`
+
+	// Every window was sustained: the deployment was not pushed to its limit
+	// and the figures above are lower bounds
+	Unsaturated bool `json:"unsaturated"`
+
+	// Redis, if sampled: the busiest window's commands a second, commands
+	// per seat command, the share of RedisMaxOps left at the peak, and the
+	// seat commands a second Redis would take at that rate
+	RedisPeakOpsPerSec    float64 `json:"redis_peak_ops_per_sec,omitempty"`
+	RedisOpsPerSeatOp     float64 `json:"redis_ops_per_seat_op,omitempty"`
+	RedisMaxOpsPerSec     float64 `json:"redis_max_ops_per_sec,omitempty"`
+	RedisHeadroom         float64 `json:"redis_headroom,omitempty"`
+	RedisMaxSeatOpsPerSec float64 `json:"redis_max_seat_ops_per_sec,omitempty"`
+
+	Windows []Window `json:"windows"`
+}
+
+// Sampler cuts a run into windows as it goes
+type Sampler struct {
+	// Cut returns the seat commands answered and failed since the last cut,
+	// and the p99 latency of those answered
+	Cut func() (answered, errors int, p99 time.Duration)
+
+	// Active returns how many clients are connected with their seat map
+	Active func() int
+
+	// Redis returns Redis's total_commands_processed; nil not to sample it
+	Redis func() (int64, error)
+}
+
+// Sample records a window every WindowLength until ctx is done
+func (s *Sampler) Sample(ctx context.Context, start time.Time) []Window {
+	var windows []Window
+	lastRedis := int64(-1)
+	if s.Redis != nil {
+		if total, err := s.Redis(); err == nil {
+			lastRedis = total
+		}
+	}
+	ticker := time.NewTicker(WindowLength)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return windows
+		case now := <-ticker.C:
+			answered, errors, p99 := s.Cut()
+			w := Window{
+				AtSec:   now.Sub(start).Round(time.Millisecond).Seconds(),
+				Active:  s.Active(),
+				SeatOps: answered,
+				Errors:  errors,
+				P99:     milliseconds(p99),
+			}
+			if s.Redis != nil {
+				if total, err := s.Redis(); err == nil {
+					if lastRedis >= 0 {
+						w.RedisOps = total - lastRedis
+					}
+					lastRedis = total
+				}
+			}
+			windows = append(windows, w)
+		}
+	}
+}
+
+// RedisCommandCounter returns a func reading the total_commands_processed of
+// the Redis at addr, for Sampler.Redis
+func RedisCommandCounter(addr string) func() (int64, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	return func() (int64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), WindowLength/2)
+		defer cancel()
+		info, err := client.Info(ctx, "stats").Result()
+		if err != nil {
+			return 0, err
+		}
+		return parseCommandsProcessed(info)
+	}
+}
+
+// parseCommandsProcessed finds total_commands_processed in INFO output
+func parseCommandsProcessed(info string) (int64, error) {
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "total_commands_processed:"); ok {
+			return strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("INFO has no total_commands_processed")
+}
+
+// Plan works out what one instance of each tier sustained. A window is
+// sustained if its seat commands kept the SLO and none failed; the busiest
+// sustained windows give the figures.
+func Plan(cfg Config, windows []Window) *Report {
+	r := &Report{
+		Edges:            cfg.Edges,
+		BookingInstances: cfg.BookingInstances,
+		SLOMs:            milliseconds(cfg.SLO),
+		Unsaturated:      len(windows) > 0,
+		Windows:          windows,
+	}
+	var redisOps, seatOps int64
+	for _, w := range windows {
+		sustained := w.Errors == 0 && w.P99 <= r.SLOMs
+		if !sustained {
+			r.Unsaturated = false
+		}
+		if sustained {
+			r.MaxConnectionsPerEdge = max(r.MaxConnectionsPerEdge, w.Active/max(cfg.Edges, 1))
+			r.BookingOpsPerSecPerInstance = math.Max(r.BookingOpsPerSecPerInstance,
+				float64(w.SeatOps)/WindowLength.Seconds()/float64(max(cfg.BookingInstances, 1)))
+		}
+		if w.RedisOps > 0 {
+			r.RedisPeakOpsPerSec = math.Max(r.RedisPeakOpsPerSec, float64(w.RedisOps)/WindowLength.Seconds())
+			if w.SeatOps > 0 {
+				redisOps += w.RedisOps
+				seatOps += int64(w.SeatOps)
+			}
+		}
+	}
+
+	if seatOps > 0 {
+		r.RedisOpsPerSeatOp = float64(redisOps) / float64(seatOps)
+	}
+	if cfg.RedisMaxOps > 0 && r.RedisPeakOpsPerSec > 0 {
+		r.RedisMaxOpsPerSec = cfg.RedisMaxOps
+		r.RedisHeadroom = 1 - r.RedisPeakOpsPerSec/cfg.RedisMaxOps
+		if r.RedisOpsPerSeatOp > 0 {
+			r.RedisMaxSeatOpsPerSec = cfg.RedisMaxOps / r.RedisOpsPerSeatOp
+		}
+	}
+	return r
+}
+
+// WriteMarkdown writes the sizing table and the windows behind it, for a
+// sizing document or pull request
+func WriteMarkdown(w io.Writer, r *Report) {
+	bound := ""
+	if r.Unsaturated {
+		bound = "at least "
+	}
+	fmt.Fprintf(w, "Through %d edge(s) and %d booking service instance(s). "+
+		"A window of %s is sustained if its seat commands kept a p99 of %.0fms with no errors.\n\n",
+		r.Edges, r.BookingInstances, WindowLength, r.SLOMs)
+
+	fmt.Fprintf(w, "| Sizing | Sustained |\n|---|---|\n")
+	fmt.Fprintf(w, "| Connections per edge | %s%d |\n", bound, r.MaxConnectionsPerEdge)
+	fmt.Fprintf(w, "| Seat commands/s per booking service instance | %s%.1f |\n", bound, r.BookingOpsPerSecPerInstance)
+	if r.RedisPeakOpsPerSec > 0 {
+		fmt.Fprintf(w, "| Redis peak commands/s | %.0f |\n", r.RedisPeakOpsPerSec)
+		fmt.Fprintf(w, "| Redis commands per seat command | %.1f |\n", r.RedisOpsPerSeatOp)
+	}
+	if r.RedisMaxOpsPerSec > 0 {
+		fmt.Fprintf(w, "| Redis headroom at peak (of %.0f commands/s) | %.0f%% |\n", r.RedisMaxOpsPerSec, r.RedisHeadroom*100)
+		fmt.Fprintf(w, "| Seat commands/s Redis would take | %.0f |\n", r.RedisMaxSeatOpsPerSec)
+	}
+	fmt.Fprintln(w)
+	if r.Unsaturated {
+		fmt.Fprintf(w, "Every window was sustained, so the deployment was not pushed to its limit: "+
+			"run again with more load to find it.\n\n")
+	}
+
+	fmt.Fprintf(w, "## Windows\n\n| At s | Active | Seat ops | Errors | p99 ms | Redis ops |\n")
+	fmt.Fprintf(w, "|---:|---:|---:|---:|---:|---:|\n")
+	for _, win := range r.Windows {
+		fmt.Fprintf(w, "| %.0f | %d | %d | %d | %.1f | %d |\n", win.AtSec, win.Active, win.SeatOps, win.Errors, win.P99, win.RedisOps)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,79 @@
+package capacity
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestPlanFromTheBusiestSustainedWindows(t *testing.T) {
+	windows := []Window{
+		{AtSec: 1, Active: 100, SeatOps: 50, P99: 100, RedisOps: 500},
+		{AtSec: 2, Active: 300, SeatOps: 200, P99: 200, RedisOps: 2000},
+		{AtSec: 3, Active: 500, SeatOps: 260, P99: 400, RedisOps: 3000}, // over the SLO
+		{AtSec: 4, Active: 400, SeatOps: 220, P99: 120, Errors: 3},
+	}
+	r := Plan(Config{Edges: 2, BookingInstances: 2, SLO: 250 * time.Millisecond, RedisMaxOps: 10000}, windows)
+
+	if r.MaxConnectionsPerEdge != 150 || r.BookingOpsPerSecPerInstance != 100 || r.Unsaturated {
+		t.Errorf("connections per edge %d, booking ops per instance %v, unsaturated %v; want 150, 100, false",
+			r.MaxConnectionsPerEdge, r.BookingOpsPerSecPerInstance, r.Unsaturated)
+	}
+	if r.RedisPeakOpsPerSec != 3000 || math.Abs(r.RedisHeadroom-0.7) > 1e-9 {
+		t.Errorf("Redis peak %v, headroom %v; want 3000, 0.7", r.RedisPeakOpsPerSec, r.RedisHeadroom)
+	}
+	if want := 5500.0 / 510; math.Abs(r.RedisOpsPerSeatOp-want) > 1e-9 || math.Abs(r.RedisMaxSeatOpsPerSec-10000/want) > 1e-6 {
+		t.Errorf("Redis ops per seat op %v, seat ops Redis takes %v; want %v, %v", r.RedisOpsPerSeatOp, r.RedisMaxSeatOpsPerSec,
+			want, 10000/want)
+	}
+}
+
+func TestSamplerCutsWindowsWithRedisCommands(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var cuts atomic.Int64
+	s := &Sampler{
+		Cut: func() (int, int, time.Duration) {
+			cuts.Add(1)
+			return 10, 0, 20 * time.Millisecond
+		},
+		Active: func() int { return 4 },
+		Redis:  RedisCommandCounter(mr.Addr()),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*WindowLength+WindowLength/2)
+	defer cancel()
+	windows := s.Sample(ctx, time.Now())
+
+	if len(windows) != 2 || cuts.Load() != 2 {
+		t.Fatalf("windows = %+v after %d cuts, want two", windows, cuts.Load())
+	}
+	for _, w := range windows {
+		// Each window counts the INFO that ends it
+		if w.Active != 4 || w.SeatOps != 10 || w.P99 != 20 || w.RedisOps != 1 {
+			t.Errorf("window %+v, want 4 clients, 10 seat commands at 20ms and one Redis command", w)
+		}
+	}
+
+	r := Plan(Config{Edges: 1, BookingInstances: 1, SLO: time.Second, RedisMaxOps: 1000}, windows)
+	var out bytes.Buffer
+	WriteMarkdown(&out, r)
+	if !strings.Contains(out.String(), "| Connections per edge | at least 4 |") || !strings.Contains(out.String(), "| Redis headroom at peak (of 1000 commands/s) | 100% |") {
+		t.Errorf("Markdown report:\n%s", out.String())
+	}
+}
+
+func TestParseCommandsProcessed(t *testing.T) {
+	info := "# Stats\r\ntotal_connections_received:12\r\ntotal_commands_processed:4821\r\ninstantaneous_ops_per_sec:7\r\n"
+	if total, err := parseCommandsProcessed(info); err != nil || total != 4821 {
+		t.Errorf("parseCommandsProcessed = %d, %v; want 4821", total, err)
+	}
+	if _, err := parseCommandsProcessed("# Stats\r\n"); err == nil {
+		t.Error("INFO without the counter parsed")
+	}
+}
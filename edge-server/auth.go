@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+
+	"concert-booking/shared"
+)
+
+// authSecret signs session tokens. When it is empty (EDGE_AUTH_SECRET unset)
+// connections are not authenticated and choose their role with ?role.
+var authSecret []byte
+
+// tokenClaims is the identity a connection's session token carries
+type tokenClaims = shared.SessionClaims
+
+// rolePermission maps a token's role to a connection permission
+func rolePermission(role string) (Permission, error) {
+	switch role {
+	case "", shared.RoleBuyer:
+		return PermissionBuyer, nil
+	case shared.RoleViewer:
+		return PermissionViewer, nil
+	case shared.RoleAdmin:
+		return PermissionAdmin, nil
+	default:
+		return 0, errors.New("unknown role")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestConnectionPermissionRequiresTokenWhenSecretSet(t *testing.T) {
+	authSecret = []byte("test-secret")
+	t.Cleanup(func() { authSecret = nil })
+
+	if _, _, err := connectionPermission(httptest.NewRequest("GET", "/ws?role=buyer", nil)); err != shared.ErrTokenMissing {
+		t.Fatalf("connection without token: err = %v, want %v", err, shared.ErrTokenMissing)
+	}
+
+	token := shared.SignSessionToken(authSecret, tokenClaims{UserID: "ops", Role: "admin", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	permission, session, err := connectionPermission(httptest.NewRequest("GET", "/ws?token="+token, nil))
+	if err != nil || permission != PermissionAdmin || session.UserID != "ops" {
+		t.Fatalf("got %s, %+v, %v; want admin session for ops", permission, session, err)
+	}
+}
+
+func TestTokenRefreshRenewsExpiredSession(t *testing.T) {
+	authSecret = []byte("test-secret")
+	t.Cleanup(func() { authSecret = nil })
+
+	th := newTestHarness(t)
+	_, conn := th.connectWithToken("client-refresh", 16, tokenClaims{UserID: "user-1", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+
+	conn.sendJSON(t, shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": "A1"})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeError)
+		return err == nil
+	}, "expected ERROR for SELECT_SEAT with an expired token")
+
+	// A token for someone else must not take over the connection
+	conn.sendJSON(t, shared.MessageTypeTokenRefresh, map[string]interface{}{
+		"token": shared.SignSessionToken(authSecret, tokenClaims{UserID: "user-2", ExpiresAt: time.Now().Add(time.Hour).Unix()}),
+	})
+	conn.sendJSON(t, shared.MessageTypeTokenRefresh, map[string]interface{}{
+		"token": shared.SignSessionToken(authSecret, tokenClaims{UserID: "user-1", Role: "viewer", ExpiresAt: time.Now().Add(time.Hour).Unix()}),
+	})
+
+	var responses []map[string]interface{}
+	eventually(t, func() bool {
+		responses = responses[:0]
+		for _, msg := range conn.messages(t) {
+			if msg.Type == "TOKEN_REFRESH_RESPONSE" {
+				responses = append(responses, msg.Data.(map[string]interface{}))
+			}
+		}
+		return len(responses) == 2
+	}, "expected two TOKEN_REFRESH_RESPONSE messages")
+
+	if responses[0]["success"] != false || responses[0]["message"] != "token is for a different user" {
+		t.Fatalf("refresh with another user's token = %v", responses[0])
+	}
+	if responses[1]["success"] != true {
+		t.Fatalf("refresh = %v, want success", responses[1])
+	}
+
+	// The refreshed role applies right away
+	conn.sendJSON(t, shared.MessageTypeBookSeat, map[string]interface{}{"seat_id": "A1"})
+	eventually(t, func() bool {
+		for _, msg := range conn.messages(t) {
+			if msg.Type == shared.MessageTypeError && msg.Data.(map[string]interface{})["error"] == "Permission denied: BOOK_SEAT requires buyer" {
+				return true
+			}
+		}
+		return false
+	}, "expected the refreshed viewer role to be enforced")
+}
@@ -2,19 +2,76 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"time"
 
 	"concert-booking/shared"
+
+	"github.com/google/uuid"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
+// RetryPolicy says how BookingClient retries requests that failed because the
+// booking service could not be reached
+type RetryPolicy struct {
+	Attempts  int           // tries in all, including the first
+	BaseDelay time.Duration // backoff before the first retry, doubled for each one after
+	MaxDelay  time.Duration // cap on the backoff
+}
+
+// DefaultRetryPolicy rides out a booking service restart or a dropped
+// connection without holding a user's click for long
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// delay returns how long to wait before retry n, counting from 1: between half
+// and all of the exponential backoff, so edges retrying together spread out
+func (p RetryPolicy) delay(n int) time.Duration {
+	backoff := p.BaseDelay << (n - 1)
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	half := backoff / 2
+	return half + rand.N(backoff-half+1)
+}
+
+// retryPolicyFromEnv reads EDGE_BOOKING_RETRIES, the tries per request (1
+// disables retries), and EDGE_BOOKING_RETRY_DELAY, the first backoff
+func retryPolicyFromEnv() (RetryPolicy, error) {
+	policy := DefaultRetryPolicy
+	if raw := os.Getenv("EDGE_BOOKING_RETRIES"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return RetryPolicy{}, fmt.Errorf("invalid EDGE_BOOKING_RETRIES %q", raw)
+		}
+		policy.Attempts = n
+	}
+	if raw := os.Getenv("EDGE_BOOKING_RETRY_DELAY"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return RetryPolicy{}, fmt.Errorf("invalid EDGE_BOOKING_RETRY_DELAY %q", raw)
+		}
+		policy.BaseDelay = d
+		policy.MaxDelay = max(policy.MaxDelay, d)
+	}
+	return policy, nil
+}
+
 // BookingClient handles communication with the booking service
 type BookingClient struct {
 	baseURL    string
 	httpClient *http.Client
+	retry      RetryPolicy
 }
 
 // NewBookingClient creates a new booking service client
@@ -24,84 +81,182 @@ func NewBookingClient(baseURL string) *BookingClient {
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		retry: DefaultRetryPolicy,
+	}
+}
+
+// withRetry runs fn until it succeeds, fails for a reason other than the
+// booking service being unavailable, runs out of attempts or ctx is done
+func (bc *BookingClient) withRetry(ctx context.Context, op string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !errors.Is(err, errBookingUnavailable) || attempt >= bc.retry.Attempts {
+			return err
+		}
+
+		delay := bc.retry.delay(attempt)
+		shared.Logger(ctx).Warn("Booking service unavailable, retrying", "op", op, "attempt", attempt, "delay", delay, shared.ErrAttr(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
 	}
 }
 
 // GetAllSeats fetches all seats from the booking service
 func (bc *BookingClient) GetAllSeats() ([]shared.Seat, error) {
-	resp, err := bc.httpClient.Get(bc.baseURL + "/api/seats")
+	seats, _, err := bc.GetVenueSnapshot()
+	return seats, err
+}
+
+// GetVenueSnapshot fetches all seats along with the seat event sequence number
+// they reflect (0 if the booking service does not report one)
+func (bc *BookingClient) GetVenueSnapshot() ([]shared.Seat, int64, error) {
+	var seats []shared.Seat
+	var seq int64
+	err := bc.withRetry(context.Background(), "venue", func() (err error) {
+		seats, seq, err = bc.fetchVenueSnapshot()
+		return err
+	})
+	return seats, seq, err
+}
+
+func (bc *BookingClient) fetchVenueSnapshot() ([]shared.Seat, int64, error) {
+	resp, err := bc.httpClient.Get(bc.baseURL + shared.APIEndpointSeats)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch seats: %w", err)
+		return nil, 0, fmt.Errorf("failed to fetch seats: %w: %w", errBookingUnavailable, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
+		return nil, 0, statusError(resp)
 	}
 
 	var seats []shared.Seat
 	if err := json.NewDecoder(resp.Body).Decode(&seats); err != nil {
-		return nil, fmt.Errorf("failed to decode seats: %w", err)
+		return nil, 0, fmt.Errorf("failed to decode seats: %w", err)
 	}
 
-	return seats, nil
+	seq, _ := strconv.ParseInt(resp.Header.Get(shared.HeaderEventSeq), 10, 64)
+	return seats, seq, nil
 }
 
-// SelectSeat attempts to select a seat for a user
-func (bc *BookingClient) SelectSeat(seatID, userID string) error {
-	req := shared.SeatRequest{
-		SeatID: seatID,
-		UserID: userID,
-	}
+// GetVenueChanges fetches the seats changed after the event sequence number
+// since, along with the sequence number they reflect, or errVenueChangesGone
+func (bc *BookingClient) GetVenueChanges(since int64) ([]shared.Seat, int64, error) {
+	var changes shared.VenueChanges
+	err := bc.withRetry(context.Background(), "venue changes", func() error {
+		resp, err := bc.httpClient.Get(bc.baseURL + shared.APIEndpointSeats + "/changes?since=" + strconv.FormatInt(since, 10))
+		if err != nil {
+			return fmt.Errorf("failed to fetch seat changes: %w: %w", errBookingUnavailable, err)
+		}
+		defer resp.Body.Close()
 
-	return bc.postRequest("/api/seats/select", req)
+		if resp.StatusCode == http.StatusGone {
+			return errVenueChangesGone
+		}
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+			return fmt.Errorf("failed to decode seat changes: %w", err)
+		}
+		return nil
+	})
+	return changes.Seats, changes.Seq, err
 }
 
-// BookSeat attempts to book a seat for a user
-func (bc *BookingClient) BookSeat(seatID, userID string) error {
-	req := shared.SeatRequest{
-		SeatID: seatID,
-		UserID: userID,
+// GetEventInfo fetches the event's organizer metadata. A booking service too
+// old to serve it yields nil.
+func (bc *BookingClient) GetEventInfo() (*shared.EventInfo, error) {
+	resp, err := bc.httpClient.Get(bc.baseURL + shared.APIPrefix + "/event")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch event: %w: %w", errBookingUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var event shared.EventInfo
+	if err := json.NewDecoder(resp.Body).Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
 	}
+	return &event, nil
+}
 
-	return bc.postRequest("/api/seats/book", req)
+// SelectSeat attempts to select a seat for a user
+func (bc *BookingClient) SelectSeat(ctx context.Context, req shared.SeatRequest) error {
+	return bc.postRequest(ctx, shared.APIEndpointSelectSeat, req, req.IdempotencyKey)
+}
+
+// BookSeat attempts to book a seat for a user
+func (bc *BookingClient) BookSeat(ctx context.Context, req shared.SeatRequest) error {
+	return bc.postRequest(ctx, shared.APIEndpointBookSeat, req, req.IdempotencyKey)
 }
 
 // ReleaseSeat releases a seat held by a user
-func (bc *BookingClient) ReleaseSeat(seatID, userID string) error {
-	req := shared.SeatRequest{
-		SeatID: seatID,
-		UserID: userID,
-	}
+func (bc *BookingClient) ReleaseSeat(ctx context.Context, req shared.SeatRequest) error {
+	return bc.postRequest(ctx, shared.APIEndpointReleaseSeat, req, req.IdempotencyKey)
+}
 
-	return bc.postRequest("/api/seats/release", req)
+// KeepHold pings a hold kept alive on activity
+func (bc *BookingClient) KeepHold(ctx context.Context, req shared.SeatRequest) error {
+	return bc.postRequest(ctx, shared.APIEndpointSeats + "/keepalive", req, req.IdempotencyKey)
 }
 
-// postRequest makes a POST request to the booking service
-func (bc *BookingClient) postRequest(endpoint string, data interface{}) error {
+// postRequest makes a POST request to the booking service, forwarding ctx's
+// trace context as a traceparent header and its correlation ID as X-Request-ID. A non-empty idempotencyKey makes the
+// request safe to retry; without one, a key is generated if the request may be
+// retried, so a retry after a lost response is not applied twice.
+func (bc *BookingClient) postRequest(ctx context.Context, endpoint string, data interface{}, idempotencyKey string) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
+	if idempotencyKey == "" && bc.retry.Attempts > 1 {
+		idempotencyKey = "edge:" + uuid.NewString()
+	}
+
+	return bc.withRetry(ctx, endpoint, func() error {
+		return bc.post(ctx, endpoint, jsonData, idempotencyKey)
+	})
+}
 
-	req, err := http.NewRequest("POST", bc.baseURL+endpoint, bytes.NewBuffer(jsonData))
+// post makes one attempt at a postRequest
+func (bc *BookingClient) post(ctx context.Context, endpoint string, jsonData []byte, idempotencyKey string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", bc.baseURL+endpoint, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if id := shared.CorrelationID(ctx); id != "" {
+		req.Header.Set(shared.HeaderCorrelationID, id)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(shared.HeaderIdempotencyKey, idempotencyKey)
+	}
 
 	resp, err := bc.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return fmt.Errorf("request failed: %w: %w", errBookingUnavailable, err)
 	}
 	defer resp.Body.Close()
 
+	if unavailableStatus(resp.StatusCode) {
+		return statusError(resp)
+	}
 	if resp.StatusCode != http.StatusOK {
 		var errResp shared.ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil {
-			return fmt.Errorf(errResp.Error)
+			return shared.NewError(errResp.Code, errResp.Error)
 		}
 		body, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
@@ -110,21 +265,41 @@ func (bc *BookingClient) postRequest(endpoint string, data interface{}) error {
 	return nil
 }
 
-// GetSeat fetches a single seat by ID
+// unavailableStatus reports whether a status code means the booking service,
+// or the proxy in front of it, could not handle the request at all
+func unavailableStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// statusError describes a non-OK response, wrapping errBookingUnavailable if
+// its status says the booking service is down
+func statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	err := fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
+	if unavailableStatus(resp.StatusCode) {
+		return fmt.Errorf("%w: %w", errBookingUnavailable, err)
+	}
+	return err
+}
+
+// GetSeat fetches a single seat by ID, or errSeatNotFound
 func (bc *BookingClient) GetSeat(seatID string) (*shared.Seat, error) {
-	resp, err := bc.httpClient.Get(bc.baseURL + "/api/seats/" + seatID)
+	resp, err := bc.httpClient.Get(bc.baseURL + shared.APIEndpointSeats + "/" + url.PathEscape(seatID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch seat: %w", err)
+		return nil, fmt.Errorf("failed to fetch seat: %w: %w", errBookingUnavailable, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("seat not found")
+		return nil, errSeatNotFound
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError(resp)
 	}
 
 	var seat shared.Seat
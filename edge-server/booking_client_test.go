@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+var fastRetries = RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetryPolicyDelayBacksOffWithJitter(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 6: time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := p.delay(n); d < want/2 || d > want {
+				t.Fatalf("delay(%d) = %v, want between %v and %v", n, d, want/2, want)
+			}
+		}
+	}
+}
+
+func TestBookingClientRetriesUnavailableWithOneIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(shared.HeaderIdempotencyKey))
+		attempt := len(keys)
+		mu.Unlock()
+
+		if attempt < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	t.Cleanup(booking.Close)
+
+	client := NewBookingClient(booking.URL)
+	client.retry = fastRetries
+	if err := client.SelectSeat(context.Background(), shared.SeatRequest{SeatID: "A1", UserID: "alice"}); err != nil {
+		t.Fatalf("SelectSeat after two 503s: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(keys) != 3 {
+		t.Fatalf("made %d attempts, want 3", len(keys))
+	}
+	if keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("idempotency keys = %q, want one generated key on every attempt", keys)
+	}
+}
+
+func TestBookingClientDoesNotRetryRefusals(t *testing.T) {
+	attempts := 0
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(shared.ErrorResponse{Error: "seat is already held by another user"})
+	}))
+	t.Cleanup(booking.Close)
+
+	client := NewBookingClient(booking.URL)
+	client.retry = fastRetries
+	err := client.SelectSeat(context.Background(), shared.SeatRequest{SeatID: "A1", UserID: "alice"})
+	if err == nil || errors.Is(err, errBookingUnavailable) {
+		t.Fatalf("SelectSeat = %v, want the refusal", err)
+	}
+	if attempts != 1 {
+		t.Errorf("made %d attempts at a refused request, want 1", attempts)
+	}
+}
+
+func TestBookingClientGivesUpWhenTheServiceStaysDown(t *testing.T) {
+	booking := httptest.NewServer(http.NotFoundHandler())
+	booking.Close()
+
+	client := NewBookingClient(booking.URL)
+	client.retry = fastRetries
+	if _, err := client.GetAllSeats(); !errors.Is(err, errBookingUnavailable) {
+		t.Fatalf("GetAllSeats = %v, want errBookingUnavailable", err)
+	}
+}
+
+// BenchmarkBookingClientRoundTrip measures a seat command over HTTP to a
+// booking service that answers at once, so the client's own cost shows
+func BenchmarkBookingClientRoundTrip(b *testing.B) {
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message":"Seat selected successfully"}`))
+	}))
+	b.Cleanup(booking.Close)
+	client := NewBookingClient(booking.URL)
+	req := shared.SeatRequest{SeatID: "A1", UserID: "alice", IdempotencyKey: "ws:alice:req-1"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.SelectSeat(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// How long the edge waits for the challenge provider to check a token
+const challengeVerifyTimeout = 5 * time.Second
+
+var errChallengeFailed = shared.NewError(shared.ErrorCodeChallengeRequired, "bot challenge failed, solve it again")
+
+// ChallengeVerifier checks the token a client got for solving a bot challenge,
+// such as a CAPTCHA or Turnstile widget
+type ChallengeVerifier interface {
+	Verify(ctx context.Context, token, remoteIP string) error
+}
+
+// challengeVerifier checks the first SELECT_SEAT of each connection; nil
+// leaves seat selection unchallenged
+var challengeVerifier ChallengeVerifier
+
+// siteverifyVerifier checks tokens against a siteverify endpoint, the protocol
+// Turnstile, hCaptcha and reCAPTCHA share: a form POST of secret, response and
+// remoteip answered with {"success": bool, "error-codes": [...]}
+type siteverifyVerifier struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+}
+
+func newSiteverifyVerifier(verifyURL, secret string) *siteverifyVerifier {
+	return &siteverifyVerifier{
+		url:        verifyURL,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: challengeVerifyTimeout},
+	}
+}
+
+func (v *siteverifyVerifier) Verify(ctx context.Context, token, remoteIP string) error {
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challenge provider answered %s", resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%w (%s)", errChallengeFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
+
+// challengeVerifierFromEnv returns a verifier for EDGE_CHALLENGE_VERIFY_URL
+// with EDGE_CHALLENGE_SECRET, or nil when the URL is not set
+func challengeVerifierFromEnv() (ChallengeVerifier, error) {
+	verifyURL := os.Getenv("EDGE_CHALLENGE_VERIFY_URL")
+	if verifyURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(verifyURL); err != nil {
+		return nil, fmt.Errorf("EDGE_CHALLENGE_VERIFY_URL: %w", err)
+	}
+	secret := os.Getenv("EDGE_CHALLENGE_SECRET")
+	if secret == "" {
+		return nil, errors.New("EDGE_CHALLENGE_VERIFY_URL needs EDGE_CHALLENGE_SECRET")
+	}
+	return newSiteverifyVerifier(verifyURL, secret), nil
+}
+
+// passChallenge reports whether the client may go on selecting seats: it has
+// passed the bot challenge already, none is required, or token, the
+// challenge_token of msg, verifies. Otherwise it answers msg with responseType, flagged
+// challenge_required. Only the client's read pump calls it.
+func (c *Client) passChallenge(msg *shared.ClientMessage, responseType, token string) bool {
+	if challengeVerifier == nil || c.challengePassed.Load() {
+		return true
+	}
+	required := map[string]bool{"challenge_required": true}
+	if token == "" {
+		c.sendFailure(msg, responseType, shared.ErrorCodeChallengeRequired, "challenge_token is required: solve the bot challenge first", required)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), challengeVerifyTimeout)
+	defer cancel()
+	if err := challengeVerifier.Verify(ctx, token, c.remoteIP); err != nil {
+		if errors.Is(err, errChallengeFailed) {
+			c.logger(ctx).Warn("Bot challenge failed", shared.ErrAttr(err))
+			c.sendFailure(msg, responseType, shared.ErrorCodeChallengeRequired, errChallengeFailed.Error(), required)
+		} else {
+			c.logger(ctx).Error("Failed to verify bot challenge", shared.ErrAttr(err))
+			c.sendFailure(msg, responseType, shared.ErrorCodeUnavailable, "Could not verify the bot challenge, try again", required)
+		}
+		return false
+	}
+	c.challengePassed.Store(true)
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// fakeVerifier passes only the token valid
+type fakeVerifier struct {
+	valid string
+	calls int
+}
+
+func (v *fakeVerifier) Verify(ctx context.Context, token, remoteIP string) error {
+	v.calls++
+	if token != v.valid {
+		return errChallengeFailed
+	}
+	return nil
+}
+
+func TestFirstSelectNeedsAChallengeToken(t *testing.T) {
+	th := newTestHarness(t)
+	verifier := &fakeVerifier{valid: "solved"}
+	challengeVerifier = verifier
+	t.Cleanup(func() { challengeVerifier = nil })
+	c := &Client{hub: th.hub, send: make(chan []byte, 16), id: "client-1", permission: PermissionBuyer}
+
+	selectSeat := func(token string) (OperationResponse, map[string]interface{}) {
+		t.Helper()
+		data, _ := json.Marshal(shared.SelectSeatPayload{
+			SeatPayload:    shared.SeatPayload{SeatID: "A1", UserID: "user-1"},
+			ChallengeToken: token,
+		})
+		c.handleSelectSeat(&shared.ClientMessage{Type: shared.MessageTypeSelectSeat, Data: data})
+		select {
+		case raw := <-c.send:
+			var msg struct {
+				Data struct {
+					OperationResponse
+					Data map[string]interface{} `json:"data"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("response %s: %v", raw, err)
+			}
+			return msg.Data.OperationResponse, msg.Data.Data
+		case <-time.After(time.Second):
+			t.Fatal("no SELECT_SEAT_RESPONSE")
+			return OperationResponse{}, nil
+		}
+	}
+
+	if resp, data := selectSeat(""); resp.Success || data["challenge_required"] != true || verifier.calls != 0 {
+		t.Errorf("select without a token = %+v %v, want refused as challenge_required", resp, data)
+	}
+	if resp, data := selectSeat("guessed"); resp.Success || data["challenge_required"] != true || verifier.calls != 1 {
+		t.Errorf("select with a bad token = %+v %v, want refused after verifying", resp, data)
+	}
+	if resp, _ := selectSeat("solved"); !resp.Success {
+		t.Errorf("select with a solved challenge = %+v, want selected", resp)
+	}
+
+	// Once passed, the connection is not challenged again
+	if resp, _ := selectSeat(""); !resp.Success || verifier.calls != 2 {
+		t.Errorf("second select = %+v after %d verifications, want selected without one", resp, verifier.calls)
+	}
+}
+
+func TestSiteverifyVerifier(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("secret") != "s3cret" || r.PostForm.Get("remoteip") != "192.0.2.1" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("response") == "solved" {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer provider.Close()
+
+	verifier := newSiteverifyVerifier(provider.URL, "s3cret")
+	if err := verifier.Verify(context.Background(), "solved", "192.0.2.1"); err != nil {
+		t.Errorf("solved token = %v", err)
+	}
+	if err := verifier.Verify(context.Background(), "guessed", "192.0.2.1"); !errors.Is(err, errChallengeFailed) {
+		t.Errorf("bad token = %v, want errChallengeFailed", err)
+	}
+	if err := newSiteverifyVerifier(provider.URL, "wrong").Verify(context.Background(), "solved", "192.0.2.1"); err == nil || errors.Is(err, errChallengeFailed) {
+		t.Errorf("provider error = %v, want an error other than a failed challenge", err)
+	}
+}
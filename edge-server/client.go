@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"concert-booking/shared"
@@ -22,14 +26,31 @@ const (
 
 	// Maximum message size allowed from peer
 	maxMessageSize = 512 * 1024
+
+	// Queued messages are batched into one websocket message until it reaches
+	// this size, so chunked venue state is not rejoined into one large frame
+	maxBatchBytes = 64 * 1024
 )
 
+// wsConn is the subset of *websocket.Conn used by Client, so tests can
+// substitute an in-memory connection
+type wsConn interface {
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	NextWriter(messageType int) (io.WriteCloser, error)
+	Close() error
+}
+
 // Client is a middleman between the websocket connection and the hub
 type Client struct {
 	hub *Hub
 
 	// The websocket connection
-	conn *websocket.Conn
+	conn wsConn
 
 	// Buffered channel of outbound messages
 	send chan []byte
@@ -37,22 +58,138 @@ type Client struct {
 	// Client ID
 	id string
 
-	// User ID (set when client subscribes)
+	// User ID (set when client subscribes). Guarded by hub.usersMu.
 	userID string
 
+	// What the connection may do
+	permission Permission
+
+	// Identity from the connection's session token; nil when tokens are not required
+	session *tokenClaims
+
+	// The session token's user, which a token refresh cannot change; "" when
+	// tokens are not required. Set before the client is registered.
+	sessionUserID string
+
+	// The address the connection came from, and the user it is counted
+	// against for connection limits (see connCounter)
+	remoteIP      string
+	countedUserID string
+
+	// Whether the connection passed the bot challenge (see challengeVerifier)
+	challengePassed atomic.Bool
+
+	// Token the client can reconnect with to resume its session, and the
+	// user the resumed session had subscribed as (see resumableSession)
+	sessionToken  string
+	resumedUserID string
+
+	// Event the client came for, which its connection counts against
+	eventID string
+
+	// Events whose seat updates the client gets: its own, and any it JOINed.
+	// Changed by its shard's loop under the shard's mu.
+	rooms map[string]bool
+
+	// Whether the client is in its event's waiting room, and since when
+	waiting  atomic.Bool
+	queuedAt time.Time
+
+	// The admission token the client connected with, or was given when let in
+	// to a capped event; and whether it came back in time to be let in first
+	admission string
+	returning bool
+
+	// "<stream_id>:<broadcast_seq>" of the last seat update the client got
+	// before reconnecting, if it asked for the ones it missed to be replayed
+	lastEventID string
+
 	// Connection timestamp
 	connectedAt time.Time
 
-	// Last activity timestamp
-	lastActivity time.Time
+	// When the client last sent a message, in Unix nanoseconds
+	lastActivity atomic.Int64
+
+	// Whether the client subscribed, and so holds a seat map that can go stale
+	subscribed atomic.Bool
+
+	// Whether the client asked for venue state in VENUE_STATE_CHUNK messages
+	chunkedVenue atomic.Bool
+
+	// Whether the client negotiated the msgpack subprotocol: it is sent binary
+	// MessagePack frames, and may send them. Set before the pumps start.
+	msgpack bool
+
+	// The message format version spoken with the client, negotiated when it
+	// connected and again if it SUBSCRIBEs with protocol_version
+	protocolVersion atomic.Int32
+
+	// Set while the client counts against its event, from when the hub lets it
+	// in until it is removed
+	added atomic.Bool
+
+	// Set once the hub has closed send; nothing may be queued after that
+	unregistered atomic.Bool
+
+	// The layout the client has drawn, if it asked for VENUE_STATE_BITMASK;
+	// venue state for that layout is sent as a bitmask
+	bitmaskLayout atomic.Pointer[string]
+
+	// The part of the venue the client subscribed to; nil for all of it
+	filter atomic.Pointer[seatFilter]
+
+	// The hub's seat update count when the client last acted or was refreshed,
+	// and when it was last sent an idle refresh (Unix nanoseconds)
+	seenEvents  atomic.Int64
+	refreshedAt atomic.Int64
+
+	// The seat the client last sent FOCUS_SEAT for, and when. Only its read
+	// pump touches them.
+	focusedSeat string
+	focusedAt   time.Time
+
+	// Receives the close frame to end the connection with once queued
+	// messages are written, when the edge shuts down
+	restart chan []byte
+
+	// Closed when writePump exits
+	done chan struct{}
+}
+
+// newClient returns a client for conn that buffers up to sendBuffer outbound
+// messages. Register it with the hub before starting its pumps.
+func newClient(hub *Hub, conn wsConn, id string, sendBuffer int) *Client {
+	c := &Client{
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan []byte, sendBuffer),
+		id:          id,
+		eventID:     shared.DefaultEventID,
+		connectedAt: time.Now(),
+		restart:     make(chan []byte, 1),
+		done:        make(chan struct{}),
+	}
+	c.protocolVersion.Store(shared.ProtocolVersionMin)
+	c.touch()
+	return c
+}
+
+// touch records that the client just acted, and so has seen its seat map
+// as of now
+func (c *Client) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+	if c.hub != nil {
+		c.seenEvents.Store(c.hub.seatEvents.Load())
+	}
 }
 
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unregisterClient(c)
 		c.conn.Close()
-		log.Printf("Client %s disconnected", c.id)
+		c.releaseLimits()
+		c.logger(context.Background()).Info("Client disconnected")
 	}()
 
 	c.conn.SetReadLimit(maxMessageSize)
@@ -63,22 +200,32 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error for client %s: %v", c.id, err)
+				c.logger(context.Background()).Warn("WebSocket error", shared.ErrAttr(err))
 			}
 			break
 		}
 
+		// Binary frames carry the same messages as MessagePack
+		if messageType == websocket.BinaryMessage {
+			if message, err = shared.MsgpackToJSON(message); err != nil {
+				c.logger(context.Background()).Warn("Failed to parse MessagePack client message", shared.ErrAttr(err))
+				c.sendError(shared.ErrorCodeInvalidRequest, "Invalid message format")
+				continue
+			}
+		}
+
 		// Update last activity
-		c.lastActivity = time.Now()
+		c.touch()
+		c.trace("<-", message)
 
 		// Parse the message
 		var clientMsg shared.ClientMessage
 		if err := json.Unmarshal(message, &clientMsg); err != nil {
-			log.Printf("Error parsing message from client %s: %v", c.id, err)
-			c.sendError("Invalid message format")
+			c.logger(context.Background()).Warn("Failed to parse client message", shared.ErrAttr(err))
+			c.sendError(shared.ErrorCodeInvalidRequest, "Invalid message format")
 			continue
 		}
 
@@ -93,6 +240,9 @@ func (c *Client) writePump() {
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		if c.done != nil {
+			close(c.done)
+		}
 	}()
 
 	for {
@@ -104,23 +254,28 @@ func (c *Client) writePump() {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if shared.InjectFault(shared.FaultKillConn) {
+				// Drop the connection without a close frame, as a network would
+				slog.Debug("Injected fault: killed connection", shared.LogKeyClientID, c.id)
 				return
 			}
-			w.Write(message)
-
-			// Add queued messages to the current websocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
+			if err := c.writeBatch(message); err != nil {
+				return
 			}
 
-			if err := w.Close(); err != nil {
-				return
+		case frame := <-c.restart:
+			// Flush what is already queued, then tell the client why it is
+			// being disconnected
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			select {
+			case message, ok := <-c.send:
+				if ok && c.writeBatch(message) != nil {
+					return
+				}
+			default:
 			}
+			c.conn.WriteMessage(websocket.CloseMessage, frame)
+			return
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
@@ -131,46 +286,125 @@ func (c *Client) writePump() {
 	}
 }
 
-func (c *Client) handleMessage(msg *shared.ClientMessage) {
-	log.Printf("Client %s sent message type: %s", c.id, msg.Type)
-
-	switch msg.Type {
-	case shared.MessageTypeSubscribe:
-		c.handleSubscribe(msg.Data)
-	case shared.MessageTypeSelectSeat:
-		c.handleSelectSeat(msg.Data)
-	case shared.MessageTypeBookSeat:
-		c.handleBookSeat(msg.Data)
-	case shared.MessageTypeReleaseSeat:
-		c.handleReleaseSeat(msg.Data)
+// disconnectWith asks writePump to end the connection with a close frame once
+// queued messages are written
+func (c *Client) disconnectWith(frame []byte) {
+	select {
+	case c.restart <- frame:
 	default:
-		c.sendError("Unknown message type: " + msg.Type)
+		// Already disconnecting
+	}
+}
+
+// writeBatch writes message and what is queued behind it, up to maxBatchBytes,
+// as one websocket message, newline separated
+func (c *Client) writeBatch(message []byte) error {
+	if c.msgpack {
+		return c.writeMsgpackBatch(message)
+	}
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	w.Write(message)
+	c.trace("->", message)
+
+	// Add queued messages to the current websocket message
+	size := len(message)
+	n := len(c.send)
+	for i := 0; i < n && size < maxBatchBytes; i++ {
+		queued := <-c.send
+		w.Write([]byte{'\n'})
+		w.Write(queued)
+		c.trace("->", queued)
+		size += 1 + len(queued)
+	}
+
+	return w.Close()
+}
+
+func (c *Client) handleMessage(msg *shared.ClientMessage) {
+	c.logger(context.Background()).Debug("Client sent message", "type", msg.Type)
+
+	route, ok := messageRoutes[msg.Type]
+	if !ok {
+		c.replyError(msg, shared.ErrorCodeUnknownMessageType, "Unknown message type: "+msg.Type)
+		return
+	}
+
+	// Waiting clients have no slot yet; they may only keep their token fresh
+	if c.waiting.Load() && msg.Type != shared.MessageTypeTokenRefresh {
+		c.replyError(msg, shared.ErrorCodeWaiting, "Waiting for a free slot: wait for WELCOME before sending "+msg.Type)
+		return
+	}
+
+	// An expired session may only be renewed
+	if c.session != nil && c.session.Expired(time.Now()) && msg.Type != shared.MessageTypeTokenRefresh {
+		c.replyError(msg, shared.ErrorCodeTokenExpired, "Token expired: send TOKEN_REFRESH with a new token")
+		return
+	}
+
+	if !c.permission.Allows(route.permission) {
+		c.logger(context.Background()).Warn("Permission denied", "permission", c.permission.String(), "type", msg.Type)
+		c.replyError(msg, shared.ErrorCodeForbidden, fmt.Sprintf("Permission denied: %s requires %s", msg.Type, route.permission))
+		return
 	}
+
+	route.handle(c, msg)
 }
 
 func (c *Client) sendMessage(msgType string, data interface{}) {
-	msg := shared.ServerMessage{
+	c.queueMessage(shared.ServerMessage{
 		Type: msgType,
 		Data: data,
-	}
+	})
+}
 
+// reply sends the response to req, echoing its request_id so the client can
+// match it to the request
+func (c *Client) reply(req *shared.ClientMessage, msgType string, data interface{}) {
+	c.queueMessage(shared.ServerMessage{
+		Type:      msgType,
+		Data:      data,
+		RequestID: req.RequestID,
+	})
+}
+
+func (c *Client) queueMessage(msg shared.ServerMessage) {
 	jsonData, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+		c.logger(context.Background()).Error("Failed to marshal message", shared.ErrAttr(err))
 		return
 	}
 
-	select {
-	case c.send <- jsonData:
-		// Message queued successfully
-	default:
+	// The client's shard closes send under its mu once the client is
+	// unregistered, which may happen at any time, so check and send under it
+	s := c.hub.shardFor(c)
+	s.mu.RLock()
+	full := false
+	if c.canSend() {
+		select {
+		case c.send <- jsonData:
+			// Message queued successfully
+		default:
+			full = true
+		}
+	}
+	s.mu.RUnlock()
+
+	if full {
 		// Client send buffer is full
-		log.Printf("Failed to send message to client %s: buffer full", c.id)
+		c.logger(context.Background()).Warn("Failed to send message: buffer full")
 	}
 }
 
-func (c *Client) sendError(errorMsg string) {
-	c.sendMessage(shared.MessageTypeError, shared.ErrorResponse{Error: errorMsg})
+func (c *Client) sendError(code shared.ErrorCode, errorMsg string) {
+	c.sendMessage(shared.MessageTypeError, shared.ErrorResponse{Code: code, Error: errorMsg})
+}
+
+// replyError answers req with an ERROR carrying its request_id
+func (c *Client) replyError(req *shared.ClientMessage, code shared.ErrorCode, errorMsg string) {
+	c.reply(req, shared.MessageTypeError, shared.ErrorResponse{Code: code, Error: errorMsg})
 }
 
 // close cleanly shuts down the client connection
@@ -183,5 +417,18 @@ func (c *Client) close() {
 	
 	// Log connection duration
 	duration := time.Since(c.connectedAt)
-	log.Printf("Client %s (user: %s) disconnected after %v", c.id, c.userID, duration)
-}
\ No newline at end of file
+	c.logger(context.Background()).Info("Client disconnected", "duration", duration)
+}
+// user returns the user the client subscribed as, if it has
+func (c *Client) user() string {
+	c.hub.usersMu.RLock()
+	defer c.hub.usersMu.RUnlock()
+	return c.userID
+}
+
+// canSend reports whether messages may still be queued for the client. Its
+// send channel is closed once the hub unregisters it, so a send then would
+// panic; that it was tried at all is a bug the invariant checks report.
+func (c *Client) canSend() bool {
+	return shared.Invariant(!c.unregistered.Load(), "no_send_after_unregister", shared.LogKeyClientID, c.id)
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestClientReceivesWelcomeOnRegister(t *testing.T) {
+	th := newTestHarness(t)
+	_, conn := th.connect("client-welcome", 16)
+
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "WELCOME")
+		return err == nil
+	}, "expected WELCOME message")
+}
+
+func TestWelcomeCarriesEventSaleAndAvailability(t *testing.T) {
+	th := newTestHarness(t)
+	opens := time.Now().Add(time.Hour).Unix()
+	venueCache.SetEvent(&shared.EventInfo{ID: shared.DefaultEventID, Name: "Summer Tour", Currency: "EUR", Timezone: "UTC", SalesOpenAt: opens})
+	venueCache.Replace([]shared.Seat{
+		{ID: "A1", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A2", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A3", Status: shared.SeatBooked, Version: 2},
+	}, 2)
+	venueCache.Apply(shared.SeatEvent{SeatID: "A1", Status: shared.SeatHeld, UserID: "user-1", Version: 2, Seq: 3})
+
+	_, conn := th.connect("client-welcome-landing", 16)
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "WELCOME")
+		return err == nil
+	}, "expected WELCOME message")
+
+	welcome, _ := findMessage(conn.messages(t), "WELCOME")
+	data, _ := welcome.Data.(map[string]interface{})
+	event, _ := data["event"].(map[string]interface{})
+	sale, _ := data["sale"].(map[string]interface{})
+	availability, _ := data["availability"].(map[string]interface{})
+	if event["name"] != "Summer Tour" || sale["state"] != shared.SaleUpcoming || sale["opens_at"] != float64(opens) {
+		t.Errorf("WELCOME event = %v, sale = %v", event, sale)
+	}
+	if availability["total"] != float64(3) || availability["available"] != float64(1) || availability["held"] != float64(1) || availability["booked"] != float64(1) {
+		t.Errorf("WELCOME availability = %v", availability)
+	}
+	if ms, _ := data["server_time_ms"].(float64); ms < float64(time.Now().Add(-time.Minute).UnixMilli()) {
+		t.Errorf("WELCOME server_time_ms = %v", data["server_time_ms"])
+	}
+}
+
+func TestSubscribeSendsAckAndVenueState(t *testing.T) {
+	th := newTestHarness(t)
+	client, conn := th.connect("client-subscribe", 16)
+
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1"})
+
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+		return err == nil
+	}, "expected VENUE_STATE after SUBSCRIBE")
+
+	if _, err := findMessage(conn.messages(t), "SUBSCRIBE_ACK"); err != nil {
+		t.Fatal(err)
+	}
+	if client.userID != "user-1" {
+		t.Fatalf("userID = %q, want user-1", client.userID)
+	}
+}
+
+func TestSelectSeatWithoutSeatIDFails(t *testing.T) {
+	th := newTestHarness(t)
+	_, conn := th.connect("client-select", 16)
+
+	conn.sendJSON(t, shared.MessageTypeSelectSeat, map[string]interface{}{"user_id": "user-1"})
+
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "SELECT_SEAT_RESPONSE")
+		return err == nil
+	}, "expected SELECT_SEAT_RESPONSE")
+
+	msg, _ := findMessage(conn.messages(t), "SELECT_SEAT_RESPONSE")
+	resp := msg.Data.(map[string]interface{})
+	if resp["success"] != false || resp["message"] != "seat_id is required" {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+}
+
+func TestMalformedSeatMessagesNameTheField(t *testing.T) {
+	th := newTestHarness(t)
+	_, conn := th.connect("client-malformed", 16)
+
+	conn.sendText([]byte(`{"type": "BOOK_SEAT", "data": {"seat_id": 7, "user_id": "user-1"}, "request_id": "r1"}`))
+	conn.sendText([]byte(`{"type": "BOOK_SEAT", "data": {"seatId": "A1", "user_id": "user-1"}, "request_id": "r2"}`))
+
+	want := map[string]string{"r1": "seat_id must be a string, got number", "r2": `unknown field "seatId"`}
+	eventually(t, func() bool {
+		got := 0
+		for _, msg := range conn.messages(t) {
+			if msg.Type != "BOOK_SEAT_RESPONSE" {
+				continue
+			}
+			resp := msg.Data.(map[string]interface{})
+			if resp["success"] != false || resp["message"] != want[msg.RequestID] {
+				t.Fatalf("response to %s = %v, want %q", msg.RequestID, resp, want[msg.RequestID])
+			}
+			got++
+		}
+		return got == len(want)
+	}, "expected both BOOK_SEAT_RESPONSEs")
+}
+
+func TestFailedResponsesCarryErrorCodes(t *testing.T) {
+	th := newTestHarness(t)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"code":"SEAT_HELD_BY_OTHER","error":"seat is already held by another user"}`))
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connect("client-codes", 16)
+	conn.sendJSON(t, shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": "A1", "user_id": "user-1"})
+	conn.sendJSON(t, shared.MessageTypeBookSeat, map[string]interface{}{"user_id": "user-1"})
+	conn.sendJSON(t, "PING_UNKNOWN", nil)
+
+	want := map[string]shared.ErrorCode{
+		"SELECT_SEAT_RESPONSE":  shared.ErrorCodeSeatHeldByOther,
+		"BOOK_SEAT_RESPONSE":    shared.ErrorCodeInvalidRequest,
+		shared.MessageTypeError: shared.ErrorCodeUnknownMessageType,
+	}
+	eventually(t, func() bool {
+		for msgType := range want {
+			if _, err := findMessage(conn.messages(t), msgType); err != nil {
+				return false
+			}
+		}
+		return true
+	}, "expected every response")
+	for msgType, code := range want {
+		msg, _ := findMessage(conn.messages(t), msgType)
+		if got := msg.Data.(map[string]interface{})["code"]; got != string(code) {
+			t.Errorf("%s code = %v, want %s", msgType, got, code)
+		}
+	}
+}
+
+func TestSelectSeatForwardsRequestIDAsIdempotencyKey(t *testing.T) {
+	th := newTestHarness(t)
+	keys := make(chan string, 1)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get(shared.HeaderIdempotencyKey)
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connect("client-idempotent", 16)
+	conn.sendJSON(t, shared.MessageTypeSelectSeat, map[string]interface{}{
+		"seat_id":    "A1",
+		"user_id":    "user-1",
+		"request_id": "req-7",
+	})
+
+	select {
+	case key := <-keys:
+		if key != "ws:user-1:req-7" {
+			t.Fatalf("Idempotency-Key = %q, want ws:user-1:req-7", key)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("booking service was not called")
+	}
+}
+
+func TestResponsesEchoRequestID(t *testing.T) {
+	th := newTestHarness(t)
+	_, conn := th.connect("client-request-ids", 16)
+
+	for _, msg := range []shared.ClientMessage{
+		{Type: shared.MessageTypeSelectSeat, Data: json.RawMessage(`{"user_id": "user-1"}`), RequestID: "req-1"},
+		{Type: shared.MessageTypeSelectSeat, Data: json.RawMessage(`{"user_id": "user-1"}`), RequestID: "req-2"},
+		{Type: "PING_UNKNOWN", RequestID: "req-3"},
+	} {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.sendText(payload)
+	}
+
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeError)
+		return err == nil
+	}, "expected an ERROR for the unknown message")
+
+	var got []string
+	for _, msg := range conn.messages(t) {
+		if msg.Type == "SELECT_SEAT_RESPONSE" || msg.Type == shared.MessageTypeError {
+			got = append(got, msg.Type+":"+msg.RequestID)
+		}
+	}
+	want := []string{"SELECT_SEAT_RESPONSE:req-1", "SELECT_SEAT_RESPONSE:req-2", "ERROR:req-3"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("responses = %v, want %v", got, want)
+	}
+
+	welcome, _ := findMessage(conn.messages(t), "WELCOME")
+	if welcome.RequestID != "" {
+		t.Fatalf("unsolicited WELCOME carries request_id %q", welcome.RequestID)
+	}
+}
+
+func TestViewerCannotMutateSeats(t *testing.T) {
+	th := newTestHarness(t)
+	called := make(chan struct{}, 1)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			called <- struct{}{}
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connectAs("client-viewer", 16, PermissionViewer)
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "kiosk"})
+	conn.sendJSON(t, shared.MessageTypeBookSeat, map[string]interface{}{"seat_id": "A1", "user_id": "kiosk"})
+
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeError)
+		return err == nil
+	}, "expected ERROR for BOOK_SEAT from a viewer")
+
+	msg, _ := findMessage(conn.messages(t), shared.MessageTypeError)
+	if errText := msg.Data.(map[string]interface{})["error"]; errText != "Permission denied: BOOK_SEAT requires buyer" {
+		t.Fatalf("error = %v", errText)
+	}
+	if _, err := findMessage(conn.messages(t), "SUBSCRIBE_ACK"); err != nil {
+		t.Fatal("viewer should be able to subscribe")
+	}
+	select {
+	case <-called:
+		t.Fatal("booking service was called for a viewer")
+	default:
+	}
+}
+
+func TestInvalidJSONReturnsError(t *testing.T) {
+	th := newTestHarness(t)
+	client, conn := th.connect("client-badjson", 16)
+
+	conn.sendText([]byte("{not json"))
+
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeError)
+		return err == nil
+	}, "expected ERROR for malformed message")
+
+	if !th.isRegistered(client) {
+		t.Fatal("malformed message should not disconnect the client")
+	}
+}
+
+func TestAbruptCloseUnregistersClient(t *testing.T) {
+	th := newTestHarness(t)
+	client, conn := th.connect("client-abrupt", 16)
+
+	eventually(t, func() bool { return th.isRegistered(client) }, "client never registered")
+
+	conn.Close()
+
+	eventually(t, func() bool { return !th.isRegistered(client) }, "client still registered after abrupt close")
+}
+
+func TestOversizedFrameDisconnectsClient(t *testing.T) {
+	th := newTestHarness(t)
+	client, conn := th.connect("client-oversized", 16)
+
+	eventually(t, func() bool { return th.isRegistered(client) }, "client never registered")
+
+	conn.sendText(bytes.Repeat([]byte("x"), maxMessageSize+1))
+
+	eventually(t, func() bool { return !th.isRegistered(client) }, "client still registered after oversized frame")
+}
+
+func TestInterleavedPongsExtendDeadlineAndKeepOrder(t *testing.T) {
+	th := newTestHarness(t)
+	_, conn := th.connect("client-pongs", 16)
+
+	conn.mu.Lock()
+	initialDeadline := conn.readDeadline
+	conn.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+	for _, seat := range []string{"A1", "A2", "A3"} {
+		conn.sendPong()
+		conn.sendJSON(t, shared.MessageTypeReleaseSeat, map[string]interface{}{"seat_id": seat, "user_id": "user-1"})
+	}
+
+	eventually(t, func() bool {
+		count := 0
+		for _, m := range conn.messages(t) {
+			if m.Type == "RELEASE_SEAT_RESPONSE" {
+				count++
+			}
+		}
+		return count == 3
+	}, "expected three RELEASE_SEAT_RESPONSE messages")
+
+	var order []string
+	for _, m := range conn.messages(t) {
+		if m.Type == "RELEASE_SEAT_RESPONSE" {
+			data := m.Data.(map[string]interface{})["data"].(map[string]interface{})
+			order = append(order, data["seat_id"].(string))
+		}
+	}
+	if strings.Join(order, ",") != "A1,A2,A3" {
+		t.Fatalf("responses out of order: %v", order)
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.pongs != 3 {
+		t.Fatalf("pongs = %d, want 3", conn.pongs)
+	}
+	if !conn.readDeadline.After(initialDeadline) {
+		t.Fatal("pong did not extend the read deadline")
+	}
+}
+
+func TestSlowReaderIsDisconnectedOnBroadcast(t *testing.T) {
+	th := newTestHarness(t)
+
+	// A tiny send buffer plus a slow peer fills up quickly
+	client, conn := th.connect("client-slow", 1)
+	conn.mu.Lock()
+	conn.writeDelay = 200 * time.Millisecond
+	conn.mu.Unlock()
+
+	eventually(t, func() bool { return th.isRegistered(client) }, "client never registered")
+
+	for i := 0; i < 10; i++ {
+		th.hub.broadcastMessage([]byte(`{"type":"SEAT_UPDATE","data":{}}`))
+	}
+
+	eventually(t, func() bool { return !th.isRegistered(client) }, "slow client was not disconnected")
+}
+
+func TestWritePumpBatchesQueuedMessages(t *testing.T) {
+	conn := newFakeConn()
+	client := &Client{conn: conn, send: make(chan []byte, 8), id: "client-batch"}
+
+	client.send <- []byte(`{"type":"A"}`)
+	client.send <- []byte(`{"type":"B"}`)
+	client.send <- []byte(`{"type":"C"}`)
+	close(client.send)
+
+	client.writePump()
+
+	types, frames := conn.frames()
+	if len(frames) != 2 {
+		t.Fatalf("frames = %d, want batched text frame plus close frame", len(frames))
+	}
+	if got := string(frames[0]); got != "{\"type\":\"A\"}\n{\"type\":\"B\"}\n{\"type\":\"C\"}" {
+		t.Fatalf("batched frame = %q", got)
+	}
+	if types[1] != websocket.CloseMessage {
+		t.Fatalf("last frame type = %d, want close", types[1])
+	}
+}
+
+func TestVenueStateCarriesEventBranding(t *testing.T) {
+	th := newTestHarness(t)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/event":
+			w.Write([]byte(`{"id":"main","name":"Summer Tour","organizer":"Acme Live","currency":"EUR","timezone":"Europe/Berlin"}`))
+		default:
+			w.Write([]byte(`[{"id":"A1","row":0,"col":0,"status":"available","version":1}]`))
+		}
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connect("client-branding", 16)
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1"})
+
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+		return err == nil
+	}, "expected VENUE_STATE after SUBSCRIBE")
+
+	msg, _ := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+	event, _ := msg.Data.(map[string]interface{})["event"].(map[string]interface{})
+	if event["name"] != "Summer Tour" || event["currency"] != "EUR" || event["timezone"] != "Europe/Berlin" {
+		t.Fatalf("VENUE_STATE event = %v", event)
+	}
+}
+
+func TestSeatMessageForwardsTraceparent(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	th := newTestHarness(t)
+	traceparents := make(chan string, 1)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/seats/book" {
+			traceparents <- r.Header.Get("traceparent")
+		}
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connect("client-traced-book", 16)
+	conn.sendJSON(t, shared.MessageTypeBookSeat, map[string]interface{}{"seat_id": "A1", "user_id": "user-1"})
+
+	select {
+	case traceparent := <-traceparents:
+		if !strings.HasPrefix(traceparent, "00-") || len(traceparent) != 55 {
+			t.Fatalf("traceparent = %q, want a W3C trace context", traceparent)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("booking service was not called")
+	}
+}
+
+func TestSeatMessagesCarryTheirOwnCorrelationID(t *testing.T) {
+	th := newTestHarness(t)
+	ids := make(chan string, 2)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/seats/select" {
+			ids <- r.Header.Get(shared.HeaderCorrelationID)
+		}
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connect("client-correlated", 16)
+	conn.sendJSON(t, shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": "A1", "user_id": "user-1"})
+	conn.sendJSON(t, shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": "A2", "user_id": "user-1"})
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case id := <-ids:
+			got = append(got, id)
+		case <-time.After(2 * time.Second):
+			t.Fatal("booking service was not called twice")
+		}
+	}
+	if got[0] == "" || got[0] == got[1] {
+		t.Fatalf("correlation IDs = %q, want one per message", got)
+	}
+}
+
+func TestGetSeatRefreshesOneSeat(t *testing.T) {
+	th := newTestHarness(t)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/seats/A1" {
+			json.NewEncoder(w).Encode(shared.Seat{ID: "A1", Status: shared.SeatHeld, HeldBy: "user-2", Version: 3})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connectAs("client-get-seat", 16, PermissionViewer)
+	conn.sendJSON(t, shared.MessageTypeGetSeat, map[string]interface{}{"seat_id": "A1"})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "GET_SEAT_RESPONSE")
+		return err == nil
+	}, "expected GET_SEAT_RESPONSE")
+
+	msg, _ := findMessage(conn.messages(t), "GET_SEAT_RESPONSE")
+	data, _ := msg.Data.(map[string]interface{})
+	seat, _ := data["data"].(map[string]interface{})["seat"].(map[string]interface{})
+	if data["success"] != true || seat["status"] != shared.SeatHeld.String() || seat["version"] != float64(3) {
+		t.Fatalf("GET_SEAT_RESPONSE = %v", msg.Data)
+	}
+
+	conn.sendJSON(t, shared.MessageTypeGetSeat, map[string]interface{}{"seat_id": "Z9"})
+	eventually(t, func() bool { return len(conn.messages(t)) >= 3 }, "expected a second GET_SEAT_RESPONSE")
+	msgs := conn.messages(t)
+	if data, _ := msgs[len(msgs)-1].Data.(map[string]interface{}); data["success"] != false || data["message"] != "seat not found" {
+		t.Fatalf("GET_SEAT of an unknown seat = %v", data)
+	}
+}
+
+func TestGetSeatFallsBackToTheCacheWhileBookingIsDown(t *testing.T) {
+	th := newTestHarness(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	bookingClient = NewBookingClient(down.URL)
+	venueCache.Replace([]shared.Seat{{ID: "A1", Status: shared.SeatBooked, Version: 2}}, 5)
+
+	_, conn := th.connect("client-get-cached-seat", 16)
+	conn.sendJSON(t, shared.MessageTypeGetSeat, map[string]interface{}{"seat_id": "A1"})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "GET_SEAT_RESPONSE")
+		return err == nil
+	}, "expected GET_SEAT_RESPONSE")
+
+	msg, _ := findMessage(conn.messages(t), "GET_SEAT_RESPONSE")
+	data, _ := msg.Data.(map[string]interface{})["data"].(map[string]interface{})
+	seat, _ := data["seat"].(map[string]interface{})
+	if data["degraded"] != true || seat["status"] != shared.SeatBooked.String() {
+		t.Fatalf("GET_SEAT_RESPONSE while booking is down = %v", msg.Data)
+	}
+}
+
+func TestHoldKeepaliveIsForwardedToTheBookingService(t *testing.T) {
+	th := newTestHarness(t)
+	pinged := make(chan shared.SeatRequest, 1)
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/seats/keepalive" {
+			var req shared.SeatRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			pinged <- req
+		}
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	defer booking.Close()
+	bookingClient = NewBookingClient(booking.URL)
+
+	_, conn := th.connect("client-keepalive", 16)
+	conn.sendJSON(t, shared.MessageTypeHoldKeepalive, map[string]interface{}{"seat_id": "A1", "user_id": "user-1"})
+
+	select {
+	case req := <-pinged:
+		if req.SeatID != "A1" || req.UserID != "user-1" {
+			t.Fatalf("keepalive request = %+v, want A1 for user-1", req)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("booking service was not pinged")
+	}
+	eventually(t, func() bool {
+		msg, err := findMessage(conn.messages(t), "HOLD_KEEPALIVE_RESPONSE")
+		return err == nil && msg.Data.(map[string]interface{})["success"] == true
+	}, "expected a successful HOLD_KEEPALIVE_RESPONSE")
+}
+
+func TestRepliesRacingAnUnregisterAreDropped(t *testing.T) {
+	h := newHub()
+	for i := 0; i < 50; i++ {
+		client := &Client{hub: h, send: make(chan []byte, 128), id: "client-racing"}
+		addClient(h, client)
+
+		// The shard closes send while replies are still being queued
+		replied := make(chan struct{})
+		go func() {
+			defer close(replied)
+			for j := 0; j < 100; j++ {
+				client.queueMessage(shared.ServerMessage{Type: shared.MessageTypeError})
+			}
+		}()
+		h.unregisterClient(client)
+		<-replied
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Shortest time between seat update broadcasts for one event, unless
+// EDGE_COALESCE_WINDOW says otherwise
+const defaultCoalesceWindow = 50 * time.Millisecond
+
+// coalesceWindowFromEnv reads EDGE_COALESCE_WINDOW; 0 disables coalescing
+func coalesceWindowFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_COALESCE_WINDOW")
+	if raw == "" {
+		return defaultCoalesceWindow, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// seatCoalescer holds back seat events that arrive within window of the last
+// broadcast for their event, and sends them together once it is over as one
+// SEAT_UPDATE_BATCH carrying only the latest update for each seat. An event
+// arriving after a quiet window goes out at once, so coalescing adds no delay
+// until seats change faster than once a window, as they do at on-sale.
+type seatCoalescer struct {
+	hub    *Hub
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingSeatEvents // by event
+}
+
+// pendingSeatEvents are an event's seat events waiting for its window to end
+type pendingSeatEvents struct {
+	// The first event held back and how many were, so a lone one goes out as it came
+	first shared.SeatEvent
+	held  int
+
+	// The transitions held back in sequence order, and where the latest for
+	// each seat is; earlier ones for a seat are left out when sent
+	events []shared.SeatEvent
+	bySeat map[string]int
+
+	sentAt time.Time   // when the last broadcast for the event went out
+	timer  *time.Timer // sends what is held at the end of the window; nil if nothing is
+}
+
+func newSeatCoalescer(h *Hub, window time.Duration) *seatCoalescer {
+	if window > 0 {
+		slog.Info("Seat updates coalesced under load", "window", window)
+	}
+	return &seatCoalescer{hub: h, window: window, pending: make(map[string]*pendingSeatEvents)}
+}
+
+// add broadcasts a seat event, now or at the end of its event's window
+func (c *seatCoalescer) add(seatEvent shared.SeatEvent) {
+	if c.window <= 0 {
+		sendSeatEvent(c.hub, seatEvent)
+		return
+	}
+	eventID := eventOf(seatEvent)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[eventID]
+	if !ok {
+		p = &pendingSeatEvents{bySeat: make(map[string]int)}
+		c.pending[eventID] = p
+	}
+	if p.timer == nil && now.Sub(p.sentAt) >= c.window {
+		p.sentAt = now
+		sendSeatEvent(c.hub, seatEvent)
+		return
+	}
+
+	p.hold(seatEvent, c.hub)
+	if p.timer == nil {
+		p.timer = time.AfterFunc(p.sentAt.Add(c.window).Sub(now), func() { c.flush(eventID) })
+	}
+}
+
+// flush broadcasts what an event's window held back. Broadcasts are queued
+// under c.mu, so one sent straight after cannot overtake them.
+func (c *seatCoalescer) flush(eventID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pending[eventID]
+	p.timer = nil
+	p.sentAt = time.Now()
+	sendSeatEvent(c.hub, p.take(eventID))
+}
+
+// hold keeps a seat event for the end of the window, counting the updates it
+// makes redundant in h's stats
+func (p *pendingSeatEvents) hold(seatEvent shared.SeatEvent, h *Hub) {
+	if p.held == 0 {
+		p.first = seatEvent
+	}
+	p.held++
+	for _, event := range seatEvent.Unbatch() {
+		i, ok := p.bySeat[event.SeatID]
+		if ok {
+			h.coalesced.Add(1)
+			if event.Version < p.events[i].Version {
+				continue
+			}
+		}
+		p.bySeat[event.SeatID] = len(p.events)
+		p.events = append(p.events, event)
+	}
+}
+
+// take returns what was held back and forgets it: the one event as it came,
+// or a coalesced batch of the latest transition for each seat
+func (p *pendingSeatEvents) take(eventID string) shared.SeatEvent {
+	taken := p.first
+	if p.held > 1 {
+		latest := make([]shared.SeatEvent, 0, len(p.bySeat))
+		for i, event := range p.events {
+			if p.bySeat[event.SeatID] == i {
+				latest = append(latest, event)
+			}
+		}
+		taken = shared.SeatEvent{
+			Type:      shared.SeatEventCoalesced,
+			EventID:   eventID,
+			Seq:       latest[0].Seq,
+			Timestamp: time.Now(),
+			Events:    latest,
+		}
+	}
+	p.first, p.held, p.events = shared.SeatEvent{}, 0, nil
+	clear(p.bySeat)
+	return taken
+}
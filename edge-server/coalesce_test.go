@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// seatUpdates reads n seat update messages off a client's send channel
+func seatUpdates(t *testing.T, c *Client, n int) []shared.ServerMessage {
+	t.Helper()
+	var got []shared.ServerMessage
+	for len(got) < n {
+		select {
+		case raw := <-c.send:
+			var msg shared.ServerMessage
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("unmarshal %s: %v", raw, err)
+			}
+			got = append(got, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("%s got %d seat updates, want %d", c.id, len(got), n)
+		}
+	}
+	return got
+}
+
+func TestSeatUpdatesWithinAWindowGoOutAsOneBatch(t *testing.T) {
+	venueCache = NewVenueCache()
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-coalesce"}
+	addClient(h, client)
+	go h.run()
+	c := newSeatCoalescer(h, 50*time.Millisecond)
+
+	event := func(seq int64, seatID, eventType string, version int64) shared.SeatEvent {
+		return shared.SeatEvent{Type: eventType, Seq: seq, SeatID: seatID, Version: version,
+			Seat: &shared.Seat{ID: seatID, Version: version}}
+	}
+	c.add(event(1, "A1", "held", 2)) // after a quiet window: out at once
+	first := seatUpdates(t, client, 1)[0]
+	if first.Type != shared.MessageTypeSeatUpdate {
+		t.Fatalf("first update = %s, want it sent at once", first.Type)
+	}
+
+	c.add(event(2, "A1", "booked", 3))
+	c.add(event(3, "A2", "held", 2))
+	c.add(event(4, "A1", "returned", 4))
+	batch := seatUpdates(t, client, 1)[0]
+	data := batch.Data.(map[string]interface{})
+	updates, _ := data["updates"].([]interface{})
+	if batch.Type != shared.MessageTypeSeatUpdateBatch || data["event_type"] != shared.SeatEventCoalesced || len(updates) != 2 {
+		t.Fatalf("batch = %v, want A2 and A1 coalesced", batch)
+	}
+	for i, want := range []struct {
+		seatID  string
+		version float64
+	}{{"A2", 2}, {"A1", 4}} {
+		update := updates[i].(map[string]interface{})
+		if update["seat_id"] != want.seatID || update["version"] != want.version {
+			t.Errorf("update %d = %v, want %s at version %v", i, update, want.seatID, want.version)
+		}
+	}
+	if n := h.GetStats().CoalescedUpdates; n != 1 {
+		t.Errorf("CoalescedUpdates = %d, want 1", n)
+	}
+}
+
+func TestALoneHeldBackSeatEventGoesOutAsItCame(t *testing.T) {
+	venueCache = NewVenueCache()
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-lone"}
+	addClient(h, client)
+	go h.run()
+	c := newSeatCoalescer(h, 20*time.Millisecond)
+
+	c.add(shared.SeatEvent{Type: "held", Seq: 1, SeatID: "A1", Version: 2})
+	c.add(shared.SeatEvent{Type: shared.SeatEventReleasedBatch, Seq: 2, Events: []shared.SeatEvent{
+		{Type: "released", Seq: 2, SeatID: "B1", Version: 3},
+		{Type: "auto_released", Seq: 3, SeatID: "B2", Version: 5},
+	}})
+	got := seatUpdates(t, client, 2)
+	if data := got[1].Data.(map[string]interface{}); data["event_type"] != shared.SeatEventReleasedBatch {
+		t.Errorf("held back batch = %v, want it sent as the released_batch it was", got[1].Data)
+	}
+}
+
+func TestWithoutAWindowEverySeatEventGoesOutAtOnce(t *testing.T) {
+	venueCache = NewVenueCache()
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-uncoalesced"}
+	addClient(h, client)
+	go h.run()
+	c := newSeatCoalescer(h, 0)
+
+	for seq, seatID := range []string{"A1", "A1", "A2"} {
+		c.add(shared.SeatEvent{Type: "held", Seq: int64(seq + 1), SeatID: seatID, Version: int64(seq + 2)})
+	}
+	for _, msg := range seatUpdates(t, client, 3) {
+		if msg.Type != shared.MessageTypeSeatUpdate {
+			t.Errorf("got %s, want every update on its own", msg.Type)
+		}
+	}
+}
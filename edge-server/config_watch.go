@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// configWatched is set once the edge has loaded the config bucket. The venue
+// refresh then stops polling the booking service for event metadata, since
+// changes arrive through the watch.
+var configWatched atomic.Bool
+
+// featureFlags holds the flags last read from the config bucket
+var featureFlags atomic.Pointer[shared.FeatureFlags]
+
+// currentFlags returns the feature flags to send to clients, or nil if none
+// are set
+func currentFlags() shared.FeatureFlags {
+	if flags := featureFlags.Load(); flags != nil && len(*flags) > 0 {
+		return *flags
+	}
+	return nil
+}
+
+// startConfigWatch follows the booking service's config bucket, applying every
+// change to event metadata and feature flags as it is made
+func startConfigWatch(nc *nats.Conn) error {
+	kv, err := shared.OpenConfigBucket(nc)
+	if err != nil {
+		return err
+	}
+	watcher, err := kv.WatchAll()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for entry := range watcher.Updates() {
+			// A nil entry marks the end of the values stored before the watch
+			if entry == nil {
+				configWatched.Store(true)
+				slog.Info("Loaded config from NATS KV", "bucket", shared.ConfigBucket)
+				continue
+			}
+			applyConfigEntry(entry.Key(), entry.Value(), entry.Operation() != nats.KeyValuePut)
+		}
+		configWatched.Store(false)
+	}()
+	return nil
+}
+
+// applyConfigEntry caches one config bucket entry
+func applyConfigEntry(key string, value []byte, deleted bool) {
+	switch key {
+	case shared.ConfigKeyFlags:
+		flags := shared.FeatureFlags{}
+		if !deleted {
+			if err := json.Unmarshal(value, &flags); err != nil {
+				slog.Error("Failed to parse feature flags", shared.ErrAttr(err))
+				return
+			}
+		}
+		featureFlags.Store(&flags)
+		slog.Info("Feature flags changed", "flags", flags)
+
+	case shared.ConfigKeyEvent(shared.DefaultEventID):
+		if deleted {
+			return
+		}
+		var event shared.EventInfo
+		if err := json.Unmarshal(value, &event); err != nil {
+			slog.Error("Failed to parse event metadata", shared.ErrAttr(err))
+			return
+		}
+		venueCache.SetEvent(&event)
+		slog.Debug("Event metadata changed", "event_id", event.ID)
+	}
+}
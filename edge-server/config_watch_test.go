@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestConfigEntriesUpdateFlagsAndEventMetadata(t *testing.T) {
+	th := newTestHarness(t)
+	t.Cleanup(func() { featureFlags.Store(nil) })
+
+	applyConfigEntry(shared.ConfigKeyFlags, []byte(`{"seat-map-v2":true}`), false)
+	applyConfigEntry(shared.ConfigKeyEvent(shared.DefaultEventID), []byte(`{"id":"main","name":"Summer Tour","currency":"EUR","timezone":"UTC"}`), false)
+	applyConfigEntry("unrelated", []byte(`garbage`), false)
+
+	_, conn := th.connect("client-1", 16)
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "alice"})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+		return err == nil
+	}, "expected VENUE_STATE after SUBSCRIBE")
+
+	msg, _ := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+	raw, _ := json.Marshal(msg.Data)
+	var state shared.VenueState
+	json.Unmarshal(raw, &state)
+	if !state.Flags["seat-map-v2"] || state.Event == nil || state.Event.Name != "Summer Tour" {
+		t.Errorf("venue state flags %v, event %+v; want the values from the config bucket", state.Flags, state.Event)
+	}
+
+	// Deleting the flags key turns every flag off
+	applyConfigEntry(shared.ConfigKeyFlags, nil, true)
+	if flags := currentFlags(); flags != nil {
+		t.Errorf("flags after delete = %v", flags)
+	}
+}
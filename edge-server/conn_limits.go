@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// connCounter caps how many connections one source, an address or a user, may
+// hold on this edge at once. A nil counter, or one with a zero limit, lets
+// every connection in.
+type connCounter struct {
+	limit int
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newConnCounter(limit int) *connCounter {
+	return &connCounter{limit: limit, counts: make(map[string]int)}
+}
+
+// acquire counts a connection of key and reports whether it is within the
+// limit; one that is not is not counted
+func (cc *connCounter) acquire(key string) bool {
+	if cc == nil || cc.limit == 0 || key == "" {
+		return true
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.counts[key] >= cc.limit {
+		return false
+	}
+	cc.counts[key]++
+	return true
+}
+
+// release uncounts a connection acquire let in
+func (cc *connCounter) release(key string) {
+	if cc == nil || cc.limit == 0 || key == "" {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.counts[key] <= 1 {
+		delete(cc.counts, key)
+		return
+	}
+	cc.counts[key]--
+}
+
+// Header a trusted proxy puts the client's address in, e.g. X-Forwarded-For;
+// "" uses the connection's own address
+var clientIPHeader string
+
+// connLimitsFromEnv reads EDGE_MAX_CONNS_PER_IP and EDGE_MAX_CONNS_PER_USER;
+// unset or 0 leaves them uncapped
+func connLimitsFromEnv() (perIP, perUser *connCounter, err error) {
+	limit := func(name string) (int, error) {
+		raw := os.Getenv(name)
+		if raw == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s %q", name, raw)
+		}
+		return n, nil
+	}
+	ip, err := limit("EDGE_MAX_CONNS_PER_IP")
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := limit("EDGE_MAX_CONNS_PER_USER")
+	if err != nil {
+		return nil, nil, err
+	}
+	return newConnCounter(ip), newConnCounter(user), nil
+}
+
+// clientIP returns the address a request came from. With clientIPHeader set,
+// the edge sits behind a proxy, which appends the address it saw last.
+func clientIP(r *http.Request) string {
+	if clientIPHeader != "" {
+		if forwarded := r.Header.Get(clientIPHeader); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tooManyConnections is the close frame for a connection over a limit
+func tooManyConnections(source string) []byte {
+	return websocket.FormatCloseMessage(shared.CloseCodeTooManyConnections, "too many connections for this "+source)
+}
+
+// refuseConnection ends a just upgraded connection over a limit with
+// CloseCodeTooManyConnections
+func refuseConnection(conn *websocket.Conn, source string) {
+	conn.WriteControl(websocket.CloseMessage, tooManyConnections(source), time.Now().Add(writeWait))
+	conn.Close()
+}
+
+// countUser counts the client as a connection of userID, uncounting it from
+// the user it had, and reports whether userID was within its limit. Only the
+// client's read pump calls it once running.
+func (c *Client) countUser(userID string) bool {
+	if userID == c.countedUserID {
+		return true
+	}
+	if !c.hub.userConns.acquire(userID) {
+		slog.Warn("Refused connection over the per-user limit", shared.LogKeyClientID, c.id, shared.LogKeyUserID, userID)
+		return false
+	}
+	c.hub.userConns.release(c.countedUserID)
+	c.countedUserID = userID
+	return true
+}
+
+// releaseLimits uncounts the client's connection once it has ended
+func (c *Client) releaseLimits() {
+	c.hub.ipConns.release(c.remoteIP)
+	c.hub.userConns.release(c.countedUserID)
+}
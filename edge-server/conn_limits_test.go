@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "10.0.0.5:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	if ip := clientIP(r); ip != "10.0.0.5" {
+		t.Errorf("without a trusted header = %q, want the connection's address", ip)
+	}
+
+	clientIPHeader = "X-Forwarded-For"
+	t.Cleanup(func() { clientIPHeader = "" })
+	if ip := clientIP(r); ip != "198.51.100.7" {
+		t.Errorf("behind a proxy = %q, want the address the proxy appended", ip)
+	}
+}
+
+func TestConnectionsOverTheAddressLimitAreClosed(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.ipConns = newConnCounter(2)
+	previous := hub
+	hub = th.hub
+	t.Cleanup(func() { hub = previous })
+
+	srv := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	dial := func() *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	closeCode := func(conn *websocket.Conn) int {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			_, _, err := conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return closeErr.Code
+			}
+			if err != nil {
+				return 0
+			}
+		}
+	}
+
+	first := dial()
+	dial()
+	if code := closeCode(dial()); code != shared.CloseCodeTooManyConnections {
+		t.Fatalf("third connection closed with %d, want %d", code, shared.CloseCodeTooManyConnections)
+	}
+
+	// A connection ending frees its place
+	first.Close()
+	eventually(t, func() bool {
+		th.hub.ipConns.mu.Lock()
+		defer th.hub.ipConns.mu.Unlock()
+		return th.hub.ipConns.counts["127.0.0.1"] == 1
+	}, "the closed connection was not uncounted")
+	late := dial()
+	late.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := late.ReadMessage(); err != nil {
+		t.Fatalf("connection after one left: %v, want WELCOME", err)
+	}
+}
+
+func TestSubscribingOverTheUserLimitClosesTheConnection(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.userConns = newConnCounter(1)
+
+	_, firstConn := th.connect("client-1", 16)
+	firstConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1"})
+	eventually(t, func() bool {
+		_, err := findMessage(firstConn.messages(t), "SUBSCRIBE_ACK")
+		return err == nil
+	}, "the first connection did not subscribe")
+
+	_, secondConn := th.connect("client-2", 16)
+	secondConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1"})
+	eventually(t, func() bool { return closeFrame(secondConn) != nil }, "the second connection was not closed")
+	if got, want := closeFrame(secondConn), tooManyConnections("user"); string(got) != string(want) {
+		t.Errorf("close frame = %q, want %q", got, want)
+	}
+
+	// Another user is not affected, and the user may connect again once its
+	// first connection is gone
+	_, otherConn := th.connect("client-other", 16)
+	otherConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-2"})
+	firstConn.Close()
+	eventually(t, func() bool {
+		th.hub.userConns.mu.Lock()
+		defer th.hub.userConns.mu.Unlock()
+		return th.hub.userConns.counts["user-1"] == 0
+	}, "the closed connection was not uncounted")
+	_, thirdConn := th.connect("client-3", 16)
+	thirdConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1"})
+	eventually(t, func() bool {
+		_, err := findMessage(thirdConn.messages(t), "SUBSCRIBE_ACK")
+		return err == nil
+	}, "the user could not subscribe after its connection left")
+	if closeFrame(otherConn) != nil {
+		t.Error("another user's connection was closed")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// tracer holds the users and connections ops asked to trace. Traced
+// connections log every message they receive and send, so one user's problem
+// can be followed during a sale without turning up logging for everyone.
+var tracer = newDebugTracer()
+
+type debugTracer struct {
+	mu      sync.RWMutex
+	users   map[string]time.Time // user ID -> trace until
+	clients map[string]time.Time // client ID -> trace until
+}
+
+func newDebugTracer() *debugTracer {
+	return &debugTracer{
+		users:   make(map[string]time.Time),
+		clients: make(map[string]time.Time),
+	}
+}
+
+// Apply starts or, with a zero Until, stops tracing the request's user and client
+func (t *debugTracer) Apply(req shared.DebugTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	set := func(rules map[string]time.Time, id string) {
+		if id == "" {
+			return
+		}
+		if req.Until.IsZero() {
+			delete(rules, id)
+		} else {
+			rules[id] = req.Until
+		}
+	}
+	set(t.users, req.UserID)
+	set(t.clients, req.ClientID)
+
+	// Drop rules that ran out so the idle check stays cheap
+	now := time.Now()
+	for _, rules := range []map[string]time.Time{t.users, t.clients} {
+		for id, until := range rules {
+			if !now.Before(until) {
+				delete(rules, id)
+			}
+		}
+	}
+}
+
+// Idle reports whether nothing is being traced
+func (t *debugTracer) Idle() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.users) == 0 && len(t.clients) == 0
+}
+
+// Traced reports whether a connection should be traced at now
+func (t *debugTracer) Traced(clientID, userID string, now time.Time) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if until, ok := t.clients[clientID]; ok && now.Before(until) {
+		return true
+	}
+	if until, ok := t.users[userID]; ok && userID != "" && now.Before(until) {
+		return true
+	}
+	return false
+}
+
+// Rules returns the traces still in effect
+func (t *debugTracer) Rules() []shared.DebugTrace {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	rules := []shared.DebugTrace{}
+	for userID, until := range t.users {
+		if now.Before(until) {
+			rules = append(rules, shared.DebugTrace{UserID: userID, Until: until})
+		}
+	}
+	for clientID, until := range t.clients {
+		if now.Before(until) {
+			rules = append(rules, shared.DebugTrace{ClientID: clientID, Until: until})
+		}
+	}
+	return rules
+}
+
+// trace logs a message to or from c if its connection is being traced
+func (c *Client) trace(direction string, message []byte) {
+	if tracer.Idle() {
+		return
+	}
+
+	userID := c.user()
+	if tracer.Traced(c.id, userID, time.Now()) {
+		// Show broadcasts packed for msgpack clients as JSON too
+		if !isJSON(message) {
+			if readable, err := shared.MsgpackToJSON(message); err == nil {
+				message = readable
+			}
+		}
+		slog.Info("Traced message", shared.LogKeyComponent, "trace", shared.LogKeyClientID, c.id, shared.LogKeyUserID, userID,
+			"direction", direction, "message", string(message))
+	}
+}
+
+// startDebugTraces applies trace requests published by the booking service's
+// admin API
+func startDebugTraces(nc *nats.Conn) error {
+	_, err := nc.Subscribe(shared.NATSTopicDebugTrace, func(msg *nats.Msg) {
+		var req shared.DebugTrace
+		if err := json.Unmarshal(msg.Data, &req); err != nil {
+			slog.Error("Failed to parse debug trace request", shared.ErrAttr(err))
+			return
+		}
+		tracer.Apply(req)
+		if req.Until.IsZero() {
+			slog.Info("Stopped tracing", shared.LogKeyUserID, req.UserID, shared.LogKeyClientID, req.ClientID)
+		} else {
+			slog.Info("Tracing", shared.LogKeyUserID, req.UserID, shared.LogKeyClientID, req.ClientID, "until", req.Until)
+		}
+	})
+	return err
+}
+
+// handleDebugTraces lists the traces in effect on this edge
+func handleDebugTraces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tracer.Rules())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestDebugTracerMatchesUserOrClientUntilExpiry(t *testing.T) {
+	tr := newDebugTracer()
+	now := time.Now()
+
+	tr.Apply(shared.DebugTrace{UserID: "user-1", Until: now.Add(time.Minute)})
+	tr.Apply(shared.DebugTrace{ClientID: "client-9", Until: now.Add(time.Minute)})
+
+	if !tr.Traced("client-1", "user-1", now) || !tr.Traced("client-9", "", now) {
+		t.Fatal("traced user or client not matched")
+	}
+	if tr.Traced("client-2", "user-2", now) || tr.Traced("client-2", "", now) {
+		t.Fatal("untraced connection matched")
+	}
+	if tr.Traced("client-1", "user-1", now.Add(2*time.Minute)) {
+		t.Fatal("trace outlived its deadline")
+	}
+
+	tr.Apply(shared.DebugTrace{UserID: "user-1"})
+	tr.Apply(shared.DebugTrace{ClientID: "client-9"})
+	if !tr.Idle() {
+		t.Fatalf("traces left after stopping them: %+v", tr.Rules())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the logger and the test to share
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTracedUserMessagesAreLogged(t *testing.T) {
+	var logs syncBuffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	// Clients may still be running after the test, so clear the rule rather
+	// than replacing the tracer
+	t.Cleanup(func() { tracer.Apply(shared.DebugTrace{UserID: "traced-user"}) })
+	tracer.Apply(shared.DebugTrace{UserID: "traced-user", Until: time.Now().Add(time.Minute)})
+
+	th := newTestHarness(t)
+	_, traced := th.connect("client-traced", 16)
+	_, other := th.connect("client-other", 16)
+	traced.sendJSON(t, "PING_UNKNOWN", map[string]interface{}{"marker": "not-yet-traced"})
+	traced.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "traced-user"})
+	other.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "someone-else"})
+	traced.sendJSON(t, "PING_UNKNOWN", map[string]interface{}{"marker": "after-subscribe"})
+
+	// The reply to the first PING_UNKNOWN may be written, and traced, before
+	// the second is read, so wait for both
+	eventually(t, func() bool {
+		out := logs.String()
+		return strings.Contains(out, `direction=-> message="{\"type\":\"ERROR\",\"data\":{\"code\":\"UNKNOWN_MESSAGE_TYPE\",\"error\":\"Unknown message type: PING_UNKNOWN\"}}"`) &&
+			strings.Contains(out, "after-subscribe")
+	}, "expected the traced user's second message and its reply to be logged")
+
+	// Other log lines name both clients too; only trace lines matter here
+	var traceLines []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "component=trace") {
+			traceLines = append(traceLines, line)
+		}
+	}
+	out := strings.Join(traceLines, "\n")
+	if !strings.Contains(out, "component=trace client_id=client-traced user_id=traced-user direction=<- ") || !strings.Contains(out, "after-subscribe") {
+		t.Fatalf("inbound message of the traced user not logged:\n%s", out)
+	}
+	if strings.Contains(out, "not-yet-traced") || strings.Contains(out, "client_id=client-other") {
+		t.Fatalf("untraced traffic logged:\n%s", out)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// Region reported by edges that don't set EDGE_REGION
+const defaultEdgeRegion = "default"
+
+// Peers silent for this long are dropped from the discovery list entirely
+const edgeForgetAfter = 4 * shared.EdgeHeartbeatTimeout
+
+// EdgeInfo is one edge server as reported by the discovery endpoint
+type EdgeInfo struct {
+	shared.EdgeHeartbeat
+	Healthy  bool      `json:"healthy"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// DiscoveryResponse lists known edges, best candidate first
+type DiscoveryResponse struct {
+	Self  string     `json:"self"`
+	Edges []EdgeInfo `json:"edges"`
+}
+
+// EdgeRegistry tracks the edge servers in the cluster from the heartbeats they
+// publish on NATS, so clients can pick the closest, least-loaded edge and fail
+// over when theirs goes away
+type EdgeRegistry struct {
+	hub       *Hub
+	nc        *nats.Conn
+	id        string
+	publicURL string
+	region    string
+
+	mu    sync.Mutex
+	peers map[string]EdgeInfo
+}
+
+// NewEdgeRegistry creates a registry for this edge instance
+func NewEdgeRegistry(hub *Hub, publicURL, region string) *EdgeRegistry {
+	if region == "" {
+		region = defaultEdgeRegion
+	}
+	return &EdgeRegistry{
+		hub:       hub,
+		id:        "edge-" + uuid.NewString()[:8],
+		publicURL: publicURL,
+		region:    region,
+		peers:     make(map[string]EdgeInfo),
+	}
+}
+
+// Start subscribes to peer heartbeats and begins publishing this edge's own
+func (r *EdgeRegistry) Start(nc *nats.Conn) error {
+	r.nc = nc
+	_, err := nc.Subscribe(shared.NATSTopicEdgeHeartbeat, func(msg *nats.Msg) {
+		var hb shared.EdgeHeartbeat
+		if err := json.Unmarshal(msg.Data, &hb); err != nil {
+			slog.Warn("Ignoring malformed edge heartbeat", shared.ErrAttr(err))
+			return
+		}
+		r.observe(hb, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(shared.EdgeHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			r.publishHeartbeat()
+			<-ticker.C
+		}
+	}()
+
+	slog.Info("Edge discovery started", "edge_id", r.id, "region", r.region)
+	return nil
+}
+
+func (r *EdgeRegistry) publishHeartbeat() {
+	hbJSON, err := json.Marshal(r.self())
+	if err != nil {
+		slog.Error("Failed to marshal edge heartbeat", shared.ErrAttr(err))
+		return
+	}
+	if err := r.nc.Publish(shared.NATSTopicEdgeHeartbeat, hbJSON); err != nil {
+		slog.Warn("Failed to publish edge heartbeat", shared.ErrAttr(err))
+	}
+}
+
+// self describes this edge as of now
+func (r *EdgeRegistry) self() shared.EdgeHeartbeat {
+	return shared.EdgeHeartbeat{
+		ID:          r.id,
+		PublicURL:   r.publicURL,
+		Region:      r.region,
+		Clients:     r.hub.GetClientCount(),
+		NATSHealthy: r.nc != nil && r.nc.IsConnected(),
+		Timestamp:   time.Now(),
+		Events:      r.hub.EventStats(),
+		Viewers:     r.hub.viewerCounts(),
+	}
+}
+
+// observe records a heartbeat received at now
+func (r *EdgeRegistry) observe(hb shared.EdgeHeartbeat, now time.Time) {
+	if hb.ID == "" || hb.ID == r.id {
+		return
+	}
+
+	r.mu.Lock()
+	r.peers[hb.ID] = EdgeInfo{EdgeHeartbeat: hb, LastSeen: now}
+	r.mu.Unlock()
+}
+
+// List returns every known edge ordered for a client in region: healthy edges
+// first, then same-region edges, then by fewest connected clients
+func (r *EdgeRegistry) List(region string, now time.Time) []EdgeInfo {
+	self := r.self()
+	edges := []EdgeInfo{{EdgeHeartbeat: self, Healthy: self.NATSHealthy, LastSeen: now}}
+
+	r.mu.Lock()
+	for id, peer := range r.peers {
+		age := now.Sub(peer.LastSeen)
+		if age > edgeForgetAfter {
+			delete(r.peers, id)
+			continue
+		}
+		peer.Healthy = peer.NATSHealthy && age <= shared.EdgeHeartbeatTimeout
+		edges = append(edges, peer)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.Healthy != b.Healthy {
+			return a.Healthy
+		}
+		if region != "" && (a.Region == region) != (b.Region == region) {
+			return a.Region == region
+		}
+		if a.Clients != b.Clients {
+			return a.Clients < b.Clients
+		}
+		return a.ID < b.ID
+	})
+	return edges
+}
+
+// handleDiscovery lists edge servers with their health and load. Clients may pass
+// ?region= to prefer edges in their region.
+func handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	response := DiscoveryResponse{
+		Self:  edgeRegistry.id,
+		Edges: edgeRegistry.List(r.URL.Query().Get("region"), time.Now()),
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to list edges", http.StatusInternalServerError)
+		return
+	}
+
+	// Clients served from another origin fetch this to choose where to connect
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
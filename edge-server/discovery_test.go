@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestEdgeRegistryOrdersHealthyLeastLoadedFirst(t *testing.T) {
+	r := NewEdgeRegistry(newHub(), "ws://self/ws", "eu-west")
+	now := time.Now()
+
+	r.observe(shared.EdgeHeartbeat{ID: "busy", Region: "eu-west", Clients: 500, NATSHealthy: true}, now)
+	r.observe(shared.EdgeHeartbeat{ID: "idle", Region: "eu-west", Clients: 10, NATSHealthy: true}, now)
+	r.observe(shared.EdgeHeartbeat{ID: "far", Region: "us-east", Clients: 0, NATSHealthy: true}, now)
+	r.observe(shared.EdgeHeartbeat{ID: "silent", Region: "eu-west", NATSHealthy: true}, now.Add(-shared.EdgeHeartbeatTimeout-time.Second))
+	r.observe(shared.EdgeHeartbeat{ID: "gone", Region: "eu-west", NATSHealthy: true}, now.Add(-edgeForgetAfter-time.Second))
+
+	edges := r.List("eu-west", now)
+
+	// The registry itself has no NATS connection here, so it sorts as unhealthy
+	want := []string{"idle", "busy", "far", r.id, "silent"}
+	if len(edges) != len(want) {
+		t.Fatalf("got %d edges, want %d", len(edges), len(want))
+	}
+	for i, w := range want {
+		if edges[i].ID != w {
+			t.Errorf("edge %d = %s, want %s", i, edges[i].ID, w)
+		}
+	}
+	if edges[len(edges)-1].Healthy {
+		t.Error("edge silent past the heartbeat timeout reported healthy")
+	}
+}
+
+func TestEdgeRegistryIgnoresOwnHeartbeat(t *testing.T) {
+	r := NewEdgeRegistry(newHub(), "", "")
+	r.observe(shared.EdgeHeartbeat{ID: r.id, Clients: 99}, time.Now())
+
+	edges := r.List("", time.Now())
+	if len(edges) != 1 || edges[0].Region != defaultEdgeRegion {
+		t.Fatalf("edges = %+v, want only self in the default region", edges)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"concert-booking/shared"
+)
+
+const (
+	// How long an out-of-order event waits for the missing ones before the gap is skipped
+	eventGapTimeout = 500 * time.Millisecond
+
+	// Maximum out-of-order events buffered before the gap is skipped immediately
+	maxBufferedEvents = 1024
+
+	// Capacity of the queue between the NATS subscription and the sequencer
+	eventQueueSize = 4096
+)
+
+// EventSequencer takes seat events off the NATS subscription and applies them in
+// sequence-number order, buffering events that arrive ahead of a missing one
+type EventSequencer struct {
+	incoming chan shared.SeatEvent
+
+	// Next sequence number expected (0 until the first sequenced event arrives)
+	nextSeq int64
+
+	// Events received ahead of nextSeq, keyed by sequence number
+	pending map[int64]shared.SeatEvent
+
+	apply func(shared.SeatEvent)
+
+	// Requests to catch up after events may have been lost
+	resync chan struct{}
+
+	// Requests to forget the sequence, e.g. while the edge hibernates
+	reset chan struct{}
+
+	// Pushes authoritative venue state to clients and returns the event sequence
+	// number it reflects; nil disables catch-up
+	reconcile func() (int64, error)
+}
+
+// NewEventSequencer creates a sequencer that hands ordered events to apply and
+// calls reconcile whenever events may have been missed
+func NewEventSequencer(apply func(shared.SeatEvent), reconcile func() (int64, error)) *EventSequencer {
+	return &EventSequencer{
+		incoming:  make(chan shared.SeatEvent, eventQueueSize),
+		pending:   make(map[int64]shared.SeatEvent),
+		apply:     apply,
+		resync:    make(chan struct{}, 1),
+		reset:     make(chan struct{}, 1),
+		reconcile: reconcile,
+	}
+}
+
+// Resync asks the sequencer to reconcile with the booking service, e.g. after
+// the NATS connection dropped and events published meanwhile were lost
+func (s *EventSequencer) Resync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+		// A resync is already pending
+	}
+}
+
+// Reset drops buffered events and the expected sequence number, so the next
+// event starts a fresh sequence instead of looking like a gap
+func (s *EventSequencer) Reset() {
+	select {
+	case s.reset <- struct{}{}:
+	default:
+		// A reset is already pending
+	}
+}
+
+// Enqueue queues an event for ordered processing without blocking the NATS callback
+func (s *EventSequencer) Enqueue(event shared.SeatEvent) {
+	select {
+	case s.incoming <- event:
+	default:
+		slog.Warn("Event queue full, dropping event", "event_type", event.Type, shared.LogKeySeatID, event.SeatID, "seq", event.Seq)
+	}
+}
+
+// Run processes queued events until the incoming channel is closed
+func (s *EventSequencer) Run() {
+	gapTimer := time.NewTimer(eventGapTimeout)
+	gapTimer.Stop()
+	gapTimerRunning := false
+
+	for {
+		select {
+		case event, ok := <-s.incoming:
+			if !ok {
+				return
+			}
+			s.accept(event)
+
+			// Consume everything already queued as one batch
+		drain:
+			for {
+				select {
+				case event, ok := <-s.incoming:
+					if !ok {
+						return
+					}
+					s.accept(event)
+				default:
+					break drain
+				}
+			}
+
+			if len(s.pending) > maxBufferedEvents {
+				s.skipGap()
+			}
+
+		case <-gapTimer.C:
+			gapTimerRunning = false
+			s.skipGap()
+
+		case <-s.resync:
+			s.catchUp()
+
+		case <-s.reset:
+			s.pending = make(map[int64]shared.SeatEvent)
+			s.nextSeq = 0
+		}
+
+		// Wait a bounded time for missing events while anything is buffered
+		if len(s.pending) > 0 && !gapTimerRunning {
+			gapTimer.Reset(eventGapTimeout)
+			gapTimerRunning = true
+		} else if len(s.pending) == 0 && gapTimerRunning {
+			gapTimer.Stop()
+			gapTimerRunning = false
+		}
+	}
+}
+
+// accept applies an event if it is next in sequence, otherwise buffers it
+func (s *EventSequencer) accept(event shared.SeatEvent) {
+	// Unsequenced events (e.g. from an older booking service) are applied as-is
+	if event.Seq == 0 {
+		s.apply(event)
+		return
+	}
+
+	if s.nextSeq == 0 {
+		s.nextSeq = event.Seq
+	}
+
+	// A sequence far behind the expected one means the counter was reset
+	if s.nextSeq-event.Seq > maxBufferedEvents {
+		slog.Warn("Event sequence reset, resynchronizing", "seq", event.Seq, "expected_seq", s.nextSeq)
+		s.pending = make(map[int64]shared.SeatEvent)
+		s.nextSeq = event.Seq
+	}
+
+	if event.Seq < s.nextSeq {
+		slog.Warn("Dropping stale event", "seq", event.Seq, "expected_seq", s.nextSeq)
+		return
+	}
+
+	s.pending[event.Seq] = event
+	s.flush()
+}
+
+// flush applies buffered events for as long as the sequence is contiguous
+func (s *EventSequencer) flush() {
+	for {
+		event, ok := s.pending[s.nextSeq]
+		if !ok {
+			return
+		}
+		delete(s.pending, s.nextSeq)
+		s.nextSeq = event.LastSeq() + 1
+		s.apply(event)
+	}
+}
+
+// skipGap gives up on missing events and resumes from the oldest buffered one
+func (s *EventSequencer) skipGap() {
+	if len(s.pending) == 0 {
+		return
+	}
+
+	oldest := int64(0)
+	for seq := range s.pending {
+		if oldest == 0 || seq < oldest {
+			oldest = seq
+		}
+	}
+
+	slog.Warn("Event gap, skipping", "from_seq", s.nextSeq, "to_seq", oldest-1)
+	s.nextSeq = oldest
+	s.flush()
+
+	// Clients missed the skipped events; correct their state
+	s.Resync()
+}
+
+// catchUp replaces client state with a fresh snapshot and moves the expected
+// sequence past everything the snapshot already reflects. It runs on the
+// sequencer goroutine, so no event is applied while the snapshot is taken.
+func (s *EventSequencer) catchUp() {
+	if s.reconcile == nil {
+		return
+	}
+
+	snapshotSeq, err := s.reconcile()
+	if err != nil {
+		slog.Error("Failed to reconcile venue state", shared.ErrAttr(err))
+		return
+	}
+
+	// A zero sequence means the booking service did not report one
+	if snapshotSeq > 0 && snapshotSeq >= s.nextSeq {
+		for seq, event := range s.pending {
+			if seq > snapshotSeq {
+				continue
+			}
+			delete(s.pending, seq)
+			// Keep the releases of a batch the snapshot only partly reflects
+			if rest := eventsAfter(event, snapshotSeq); len(rest) > 0 {
+				event.Events = rest
+				event.Seq = rest[0].Seq
+				s.pending[event.Seq] = event
+			}
+		}
+		s.nextSeq = snapshotSeq + 1
+	}
+	s.flush()
+
+	slog.Info("Reconciled venue state", "seq", snapshotSeq)
+}
+
+// eventsAfter returns the members of a batch event with sequence numbers after seq
+func eventsAfter(event shared.SeatEvent, seq int64) []shared.SeatEvent {
+	var rest []shared.SeatEvent
+	for _, member := range event.Events {
+		if member.Seq > seq {
+			rest = append(rest, member)
+		}
+	}
+	return rest
+}
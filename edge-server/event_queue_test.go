@@ -0,0 +1,145 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestEventsAreAppliedInSequenceOrder(t *testing.T) {
+	var applied []int64
+	s := NewEventSequencer(func(e shared.SeatEvent) { applied = append(applied, e.Seq) }, nil)
+
+	for _, seq := range []int64{1, 3, 4, 2, 5} {
+		s.accept(shared.SeatEvent{Seq: seq})
+	}
+
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+	if len(s.pending) != 0 {
+		t.Fatalf("%d events left pending", len(s.pending))
+	}
+}
+
+func TestUnsequencedAndStaleEvents(t *testing.T) {
+	var applied []string
+	s := NewEventSequencer(func(e shared.SeatEvent) { applied = append(applied, e.SeatID) }, nil)
+
+	s.accept(shared.SeatEvent{Seq: 7, SeatID: "A1"})
+	s.accept(shared.SeatEvent{SeatID: "legacy"})
+	s.accept(shared.SeatEvent{Seq: 6, SeatID: "stale"})
+	s.accept(shared.SeatEvent{Seq: 7, SeatID: "duplicate"})
+
+	if want := []string{"A1", "legacy"}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+}
+
+func TestSkipGapResumesFromOldestBuffered(t *testing.T) {
+	var applied []int64
+	s := NewEventSequencer(func(e shared.SeatEvent) { applied = append(applied, e.Seq) }, nil)
+
+	s.accept(shared.SeatEvent{Seq: 1})
+	s.accept(shared.SeatEvent{Seq: 5})
+	s.accept(shared.SeatEvent{Seq: 4})
+	s.skipGap()
+
+	if want := []int64{1, 4, 5}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+	if s.nextSeq != 6 {
+		t.Fatalf("nextSeq = %d, want 6", s.nextSeq)
+	}
+}
+
+func TestSequenceResetStartsOver(t *testing.T) {
+	var applied []int64
+	s := NewEventSequencer(func(e shared.SeatEvent) { applied = append(applied, e.Seq) }, nil)
+
+	s.accept(shared.SeatEvent{Seq: 5000})
+	s.accept(shared.SeatEvent{Seq: 1})
+	s.accept(shared.SeatEvent{Seq: 2})
+
+	if want := []int64{5000, 1, 2}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+}
+
+func TestCatchUpSkipsEventsReflectedInSnapshot(t *testing.T) {
+	var applied []int64
+	reconciled := 0
+	s := NewEventSequencer(
+		func(e shared.SeatEvent) { applied = append(applied, e.Seq) },
+		func() (int64, error) {
+			reconciled++
+			return 10, nil
+		},
+	)
+
+	s.accept(shared.SeatEvent{Seq: 5})
+
+	// Connection dropped: 6-10 were lost, 12 arrives early
+	s.accept(shared.SeatEvent{Seq: 12})
+	s.catchUp()
+
+	// Late deliveries already covered by the snapshot are dropped
+	s.accept(shared.SeatEvent{Seq: 8})
+	s.accept(shared.SeatEvent{Seq: 11})
+
+	if reconciled != 1 {
+		t.Fatalf("reconcile called %d times, want 1", reconciled)
+	}
+	if want := []int64{5, 11, 12}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+	if len(s.pending) != 0 {
+		t.Fatalf("%d events left pending", len(s.pending))
+	}
+}
+
+func TestSkippedGapRequestsResync(t *testing.T) {
+	s := NewEventSequencer(func(shared.SeatEvent) {}, nil)
+
+	s.accept(shared.SeatEvent{Seq: 1})
+	s.accept(shared.SeatEvent{Seq: 3})
+	s.skipGap()
+
+	select {
+	case <-s.resync:
+	default:
+		t.Fatal("skipping a gap should request a resync")
+	}
+}
+
+func TestReleaseBatchCoversItsMembersSequenceNumbers(t *testing.T) {
+	var applied []int64
+	s := NewEventSequencer(func(e shared.SeatEvent) { applied = append(applied, e.Seq) }, func() (int64, error) { return 7, nil })
+	batch := func(seqs ...int64) shared.SeatEvent {
+		event := shared.SeatEvent{Type: shared.SeatEventReleasedBatch, Seq: seqs[0]}
+		for _, seq := range seqs {
+			event.Events = append(event.Events, shared.SeatEvent{Type: "released", Seq: seq})
+		}
+		return event
+	}
+
+	s.accept(shared.SeatEvent{Seq: 1})
+	s.accept(batch(2, 3, 4))
+	s.accept(shared.SeatEvent{Seq: 5})
+	if want := []int64{1, 2, 5}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+
+	// 6 was lost; the snapshot covers it and the start of the batch after it
+	s.accept(batch(7, 8, 9))
+	s.accept(shared.SeatEvent{Seq: 10})
+	applied = nil
+	s.catchUp()
+	if want := []int64{8, 10}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v after catch-up, want %v", applied, want)
+	}
+	if s.nextSeq != 11 {
+		t.Errorf("next seq = %d, want 11", s.nextSeq)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeFrame is a frame the simulated browser sends to the server
+type fakeFrame struct {
+	messageType int
+	data        []byte
+}
+
+// fakeConn is an in-memory stand-in for *websocket.Conn. Frames pushed with
+// sendText/sendPong are returned by ReadMessage; frames written by the server are
+// recorded and can be inspected with messages().
+type fakeConn struct {
+	mu sync.Mutex
+
+	inbound   chan fakeFrame
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	readLimit     int64
+	readDeadline  time.Time
+	writeDeadline time.Time
+	pongHandler   func(string) error
+
+	// Delay applied to every write to simulate a slow peer
+	writeDelay time.Duration
+
+	writtenTypes []int
+	written      [][]byte
+	pongs        int
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		inbound: make(chan fakeFrame, 64),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (f *fakeConn) SetReadLimit(limit int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readLimit = limit
+}
+
+func (f *fakeConn) SetReadDeadline(t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readDeadline = t
+	return nil
+}
+
+func (f *fakeConn) SetWriteDeadline(t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeDeadline = t
+	return nil
+}
+
+func (f *fakeConn) SetPongHandler(h func(string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pongHandler = h
+}
+
+// ReadMessage mirrors gorilla's behavior: control frames are handled internally
+// and only data frames are returned to the caller
+func (f *fakeConn) ReadMessage() (int, []byte, error) {
+	for {
+		select {
+		case frame := <-f.inbound:
+			f.mu.Lock()
+			limit, pongHandler := f.readLimit, f.pongHandler
+			f.mu.Unlock()
+
+			if frame.messageType == websocket.PongMessage {
+				f.mu.Lock()
+				f.pongs++
+				f.mu.Unlock()
+				if pongHandler != nil {
+					if err := pongHandler(string(frame.data)); err != nil {
+						return 0, nil, err
+					}
+				}
+				continue
+			}
+
+			if limit > 0 && int64(len(frame.data)) > limit {
+				f.Close()
+				return 0, nil, websocket.ErrReadLimit
+			}
+			return frame.messageType, frame.data, nil
+
+		case <-f.closed:
+			return 0, nil, &websocket.CloseError{Code: websocket.CloseAbnormalClosure, Text: "connection closed"}
+		}
+	}
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if f.isClosed() {
+		return websocket.ErrCloseSent
+	}
+	f.record(messageType, data)
+	return nil
+}
+
+func (f *fakeConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	if f.isClosed() {
+		return nil, websocket.ErrCloseSent
+	}
+	return &fakeWriter{conn: f, messageType: messageType}, nil
+}
+
+func (f *fakeConn) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeConn) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *fakeConn) record(messageType int, data []byte) {
+	f.mu.Lock()
+	delay := f.writeDelay
+	f.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writtenTypes = append(f.writtenTypes, messageType)
+	f.written = append(f.written, append([]byte(nil), data...))
+}
+
+// sendText simulates the browser sending a text frame
+func (f *fakeConn) sendText(data []byte) {
+	f.inbound <- fakeFrame{messageType: websocket.TextMessage, data: data}
+}
+
+// sendJSON simulates the browser sending a client message
+func (f *fakeConn) sendJSON(t *testing.T, msgType string, data map[string]interface{}) {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("marshal message data: %v", err)
+	}
+	payload, err := json.Marshal(shared.ClientMessage{Type: msgType, Data: raw})
+	if err != nil {
+		t.Fatalf("marshal client message: %v", err)
+	}
+	f.sendText(payload)
+}
+
+// sendPong simulates the browser answering a ping
+func (f *fakeConn) sendPong() {
+	f.inbound <- fakeFrame{messageType: websocket.PongMessage}
+}
+
+// frames returns every frame written by the server, including control frames
+func (f *fakeConn) frames() (types []int, data [][]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int(nil), f.writtenTypes...), append([][]byte(nil), f.written...)
+}
+
+// messages returns the server messages written so far, splitting frames that
+// batch several newline-separated messages
+func (f *fakeConn) messages(t *testing.T) []shared.ServerMessage {
+	t.Helper()
+	types, frames := f.frames()
+
+	var msgs []shared.ServerMessage
+	for i, frame := range frames {
+		if types[i] != websocket.TextMessage {
+			continue
+		}
+		for _, line := range bytes.Split(frame, []byte{'\n'}) {
+			var msg shared.ServerMessage
+			if err := json.Unmarshal(line, &msg); err != nil {
+				t.Fatalf("server wrote invalid JSON %q: %v", line, err)
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// fakeWriter buffers a frame until Close, like gorilla's NextWriter
+type fakeWriter struct {
+	conn        *fakeConn
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *fakeWriter) Close() error {
+	if w.conn.isClosed() {
+		return websocket.ErrCloseSent
+	}
+	w.conn.record(w.messageType, w.buf.Bytes())
+	return nil
+}
+
+// testHarness wires a running hub to a fake booking service
+type testHarness struct {
+	t       *testing.T
+	hub     *Hub
+	booking *httptest.Server
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v1/seats" {
+			json.NewEncoder(w).Encode([]shared.Seat{{ID: "A1", Status: shared.SeatAvailable}})
+			return
+		}
+		w.Write([]byte(`{"message":"ok"}`))
+	}))
+	t.Cleanup(booking.Close)
+
+	client := NewBookingClient(booking.URL)
+	client.retry = fastRetries
+	bookingClient = client
+	venueCache = NewVenueCache()
+
+	h := newHub()
+	go h.run()
+
+	return &testHarness{t: t, hub: h, booking: booking}
+}
+
+// connect registers a buyer client on a fake connection and starts its pumps
+func (th *testHarness) connect(id string, sendBuffer int) (*Client, *fakeConn) {
+	th.t.Helper()
+	return th.connectAs(id, sendBuffer, PermissionBuyer)
+}
+
+// connectAs is connect with the given connection permission
+func (th *testHarness) connectAs(id string, sendBuffer int, permission Permission) (*Client, *fakeConn) {
+	th.t.Helper()
+
+	client := newClient(th.hub, newFakeConn(), id, sendBuffer)
+	client.permission = permission
+	return client, th.start(client)
+}
+
+// connectWithToken connects as an authenticated client holding the given token
+func (th *testHarness) connectWithToken(id string, sendBuffer int, claims tokenClaims) (*Client, *fakeConn) {
+	th.t.Helper()
+
+	permission, err := rolePermission(claims.Role)
+	if err != nil {
+		th.t.Fatalf("token role: %v", err)
+	}
+	client := newClient(th.hub, newFakeConn(), id, sendBuffer)
+	client.permission = permission
+	client.session = &claims
+	client.sessionUserID = claims.UserID
+	return client, th.start(client)
+}
+
+// start registers a client on its fake connection and runs its pumps
+func (th *testHarness) start(client *Client) *fakeConn {
+	th.t.Helper()
+
+	conn := client.conn.(*fakeConn)
+	th.hub.registerClient(client)
+
+	go client.writePump()
+	read := make(chan struct{})
+	go func() {
+		client.readPump()
+		close(read)
+	}()
+
+	// Wait for the message being handled, so it cannot reach the globals
+	// the next test's harness sets
+	th.t.Cleanup(func() {
+		conn.Close()
+		select {
+		case <-read:
+		case <-time.After(2 * time.Second):
+		}
+	})
+	return conn
+}
+
+// isRegistered reports whether the client's shard still delivers to it
+func (th *testHarness) isRegistered(c *Client) bool {
+	s := th.hub.shardFor(c)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clients[c]
+}
+
+// eventually polls cond until it holds or the timeout expires
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+// findMessage returns the first message of the given type
+func findMessage(msgs []shared.ServerMessage, msgType string) (shared.ServerMessage, error) {
+	for _, m := range msgs {
+		if m.Type == msgType {
+			return m, nil
+		}
+	}
+	return shared.ServerMessage{}, errors.New("no " + msgType + " message")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+	"concert-booking/shared/fixtures"
+
+	"github.com/gorilla/websocket"
+)
+
+// injectFaults makes f's faults happen until the test ends
+func injectFaults(t *testing.T, f shared.Faults) {
+	shared.SetFaults(f)
+	t.Cleanup(func() { shared.SetFaults(shared.Faults{}) })
+}
+
+func TestStackReconcilesSeatEventsLostOnTheBus(t *testing.T) {
+	stack := newTestStack(t, fixtures.Venue().Section("floor", 1, 3).Seats())
+	seats := []string{shared.GetSeatID(0, 0), shared.GetSeatID(0, 1), shared.GetSeatID(0, 2)}
+	alice, bob := stack.connect("alice"), stack.connect("bob")
+
+	alice.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seats[0]})
+	bob.awaitSeat(seats[0])
+
+	injectFaults(t, shared.Faults{DropPublish: 1})
+	if resp := alice.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seats[1]}); !responseSucceeded(resp) {
+		t.Fatalf("alice's select: %+v", resp.Data)
+	}
+	shared.SetFaults(shared.Faults{})
+
+	// The next event shows the gap; once it is skipped, buyers are sent the
+	// seat map afresh with the hold they never heard about
+	alice.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seats[2]})
+	state := bob.await(shared.MessageTypeVenueState, nil)
+	data, _ := state.Data.(map[string]interface{})
+	venueSeats, _ := data["seats"].([]interface{})
+	for _, s := range venueSeats {
+		seat, _ := s.(map[string]interface{})
+		if seat["id"] != seats[1] {
+			continue
+		}
+		if seat["status"] != shared.SeatHeld.String() || seat["held_by"] != "alice" {
+			t.Fatalf("reconciled %s = %+v, want alice's hold", seats[1], seat)
+		}
+		return
+	}
+	t.Fatalf("%s missing from the reconciled seat map", seats[1])
+}
+
+func TestStackKillsConnectionsAtTheChaosChance(t *testing.T) {
+	stack := newTestStack(t, fixtures.Venue().Section("floor", 1, 1).Seats())
+	seatID := shared.GetSeatID(0, 0)
+	alice, bob := stack.connect("alice"), stack.connect("bob")
+
+	// Alice's hold is the next message either of them is sent
+	injectFaults(t, shared.Faults{KillConn: 1})
+	if err := alice.conn.WriteJSON(shared.ClientMessage{Type: shared.MessageTypeSelectSeat, Data: []byte(`{"seat_id":"` + seatID + `"}`)}); err != nil {
+		t.Fatalf("send alice's select: %v", err)
+	}
+	bob.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := bob.conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+		t.Fatalf("bob's read = %v, want the connection dropped without a close frame", err)
+	}
+
+	shared.SetFaults(shared.Faults{})
+	bob = stack.connect("bob")
+	resp := bob.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seatID})
+	if data, _ := resp.Data.(map[string]interface{}); data["code"] != string(shared.ErrorCodeSeatHeldByOther) {
+		t.Fatalf("bob's select after reconnecting: %+v, want alice's hold to have gone through", resp.Data)
+	}
+}
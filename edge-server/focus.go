@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Shortest time between two FOCUS_SEATs of one client that are passed on,
+// unless EDGE_FOCUS_INTERVAL says otherwise
+const defaultFocusInterval = 250 * time.Millisecond
+
+// focusInterval throttles each client's FOCUS_SEATs; 0 turns seat focus off
+var focusInterval = defaultFocusInterval
+
+// focusIntervalFromEnv reads EDGE_FOCUS_INTERVAL; 0 disables FOCUS_SEAT
+func focusIntervalFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_FOCUS_INTERVAL")
+	if raw == "" {
+		return defaultFocusInterval, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// focusMessage is the data of a FOCUS_SEAT sent to clients
+type focusMessage struct {
+	EventID        string `json:"event_id"`
+	SeatID         string `json:"seat_id"`
+	PreviousSeatID string `json:"previous_seat_id,omitempty"`
+}
+
+// handleFocusSeat passes on that the client is looking at a seat, or at none
+// with an empty seat_id, to the other clients following its event on every
+// edge. Focus is a hint for UIs: nothing is held or stored, and focus sent
+// faster than focusInterval is dropped without a reply.
+func (c *Client) handleFocusSeat(msg *shared.ClientMessage) {
+	if focusInterval == 0 {
+		c.sendFailure(msg, "FOCUS_SEAT_RESPONSE", shared.ErrorCodeUnavailable, "seat focus is disabled", nil)
+		return
+	}
+	var data shared.FocusSeatPayload
+	if !c.decodePayload(msg, "FOCUS_SEAT_RESPONSE", &data) {
+		return
+	}
+	seatID := data.SeatID
+	if seatID != "" {
+		if _, _, ok := venueCache.Seat(seatID); !ok {
+			c.sendFailure(msg, "FOCUS_SEAT_RESPONSE", shared.ErrorCodeNotFound, "unknown seat", nil)
+			return
+		}
+	}
+
+	now := time.Now()
+	if seatID == c.focusedSeat || now.Sub(c.focusedAt) < focusInterval {
+		return
+	}
+	focus := shared.SeatFocus{EventID: c.eventID, SeatID: seatID, PreviousSeatID: c.focusedSeat}
+	c.focusedSeat, c.focusedAt = seatID, now
+
+	c.hub.broadcastFocus(focus, c)
+	if c.hub.onFocus != nil {
+		c.hub.onFocus(focus)
+	}
+}
+
+// broadcastFocus queues a seat focus for the clients following its event,
+// except from, the client it came from
+func (h *Hub) broadcastFocus(focus shared.SeatFocus, from *Client) {
+	message, err := json.Marshal(shared.ServerMessage{
+		Type: shared.MessageTypeFocusSeat,
+		Data: focusMessage{EventID: focus.EventID, SeatID: focus.SeatID, PreviousSeatID: focus.PreviousSeatID},
+	})
+	if err != nil {
+		slog.Error("Failed to marshal seat focus", shared.ErrAttr(err))
+		return
+	}
+	h.enqueue(broadcast{room: focus.EventID, message: message, from: from}, PriorityLow)
+}
+
+// startSeatFocus publishes the seat focus of this edge's clients and delivers
+// that of other edges' clients here
+func startSeatFocus(nc *nats.Conn, edgeID string) error {
+	if _, err := nc.Subscribe(shared.NATSTopicSeatFocus, func(msg *nats.Msg) {
+		var focus shared.SeatFocus
+		if err := json.Unmarshal(msg.Data, &focus); err != nil {
+			slog.Error("Failed to parse seat focus", shared.ErrAttr(err))
+			return
+		}
+		if focus.EdgeID == edgeID {
+			// Its clients have it already
+			return
+		}
+		hub.broadcastFocus(focus, nil)
+	}); err != nil {
+		return err
+	}
+
+	hub.onFocus = func(focus shared.SeatFocus) {
+		focus.EdgeID = edgeID
+		focusJSON, err := json.Marshal(focus)
+		if err != nil {
+			slog.Error("Failed to marshal seat focus", shared.ErrAttr(err))
+			return
+		}
+		if err := nc.Publish(shared.NATSTopicSeatFocus, focusJSON); err != nil {
+			slog.Warn("Failed to publish seat focus", shared.ErrAttr(err))
+		}
+	}
+	return nil
+}
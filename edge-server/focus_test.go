@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestFocusSeatReachesTheEventsOtherClients(t *testing.T) {
+	venueCache = NewVenueCache()
+	venueCache.Replace([]shared.Seat{{ID: "A1"}, {ID: "A2"}}, 1)
+	h := newHub()
+	looking := &Client{hub: h, send: make(chan []byte, 16), id: "client-looking"}
+	watching := &Client{hub: h, send: make(chan []byte, 16), id: "client-watching"}
+	gala := &Client{hub: h, send: make(chan []byte, 16), id: "client-gala", eventID: "gala"}
+	for _, c := range []*Client{looking, watching, gala} {
+		addClient(h, c)
+	}
+	var published []shared.SeatFocus
+	h.onFocus = func(focus shared.SeatFocus) { published = append(published, focus) }
+	go h.run()
+
+	focus := func(seatID string) {
+		data, _ := json.Marshal(shared.FocusSeatPayload{SeatID: seatID})
+		looking.handleFocusSeat(&shared.ClientMessage{Type: shared.MessageTypeFocusSeat, Data: data})
+	}
+	received := func() focusMessage {
+		t.Helper()
+		select {
+		case raw := <-watching.send:
+			var msg struct {
+				Type string       `json:"type"`
+				Data focusMessage `json:"data"`
+			}
+			if err := json.Unmarshal(raw, &msg); err != nil || msg.Type != shared.MessageTypeFocusSeat {
+				t.Fatalf("watching client got %s, want FOCUS_SEAT", raw)
+			}
+			return msg.Data
+		case <-time.After(time.Second):
+			t.Fatal("watching client got no FOCUS_SEAT")
+			return focusMessage{}
+		}
+	}
+
+	focus("A1")
+	if got := received(); got != (focusMessage{EventID: shared.DefaultEventID, SeatID: "A1"}) {
+		t.Errorf("focus = %+v, want A1", got)
+	}
+
+	// Focus faster than the interval is dropped
+	focus("A2")
+	looking.focusedAt = time.Now().Add(-focusInterval)
+	focus("A2")
+	if got := received(); got != (focusMessage{EventID: shared.DefaultEventID, SeatID: "A2", PreviousSeatID: "A1"}) {
+		t.Errorf("moved focus = %+v, want A2 after A1", got)
+	}
+	for _, s := range h.shards {
+		s.do(func() {})
+	}
+	if len(looking.send) != 0 || len(gala.send) != 0 || len(watching.send) != 0 {
+		t.Errorf("sender, other event and watcher hold %d, %d and %d extra messages, want none",
+			len(looking.send), len(gala.send), len(watching.send))
+	}
+	if len(published) != 2 || published[1].SeatID != "A2" {
+		t.Errorf("published %+v, want A1 then A2 for the other edges", published)
+	}
+
+	looking.focusedAt = time.Time{}
+	focus("Z9")
+	raw := <-looking.send
+	var resp struct {
+		Type string            `json:"type"`
+		Data OperationResponse `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil || resp.Type != "FOCUS_SEAT_RESPONSE" || resp.Data.Success {
+		t.Errorf("focus on an unknown seat = %s, want a failed FOCUS_SEAT_RESPONSE", raw)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+
+	"concert-booking/shared"
+)
+
+// graphqlSchema is what /graphql serves: the venue as the edge caches it, and
+// seat updates as the hub broadcasts them to WebSocket clients
+var graphqlSchema = mustBuildGraphQLSchema()
+
+// errFellBehind ends a seatUpdated subscription whose watcher was dropped
+var errFellBehind = errors.New("fell behind the seat updates; subscribe again and reload the venue")
+
+// graphqlRequest is a GraphQL operation, as POSTed to /graphql or sent in a
+// graphql-transport-ws subscribe message
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphqlVenue is the venue query's result
+type graphqlVenue struct {
+	Seats        []shared.Seat
+	VenueVersion int64 // seat event sequence number the seats reflect; 0 when degraded
+	Degraded     bool  // served from the edge's cache while the booking service is down
+}
+
+// seatUpdate is one seat's transition in a stamped SEAT_UPDATE or
+// SEAT_UPDATE_BATCH, as seatUpdated sends it
+type seatUpdate struct {
+	EventID      string            `json:"event_id"`
+	BroadcastSeq int64             `json:"broadcast_seq"`
+	EventType    string            `json:"event_type"`
+	SeatID       string            `json:"seat_id"`
+	UserID       string            `json:"user_id"`
+	Status       shared.SeatStatus `json:"status"`
+	Version      int64             `json:"version"`
+	VenueVersion int64             `json:"venue_version"`
+	Timestamp    time.Time         `json:"timestamp"`
+	ExpiresAt    int64             `json:"expires_at"`
+	Seat         *shared.Seat      `json:"seat"`
+}
+
+// seatFilterArgs are the arguments venue and seatUpdated narrow seats by, as
+// a SUBSCRIBE filter does
+var seatFilterArgs = graphql.FieldConfigArgument{
+	"sections": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+	"rows":     &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+	"seatIds":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.ID))},
+}
+
+func mustBuildGraphQLSchema() graphql.Schema {
+	seatType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Seat",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"row":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"col":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"section":    &graphql.Field{Type: graphql.String},
+			"tier":       &graphql.Field{Type: graphql.String},
+			"priceCents": &graphql.Field{Type: graphql.Int},
+			"status":     &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: resolveStatus},
+			"heldBy":     &graphql.Field{Type: graphql.String},
+			"expiresAt":  &graphql.Field{Type: graphql.Int, Description: "When the hold ends, in Unix seconds"},
+			"version":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Event",
+		Fields: graphql.Fields{
+			"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name":         &graphql.Field{Type: graphql.String},
+			"organizer":    &graphql.Field{Type: graphql.String},
+			"imageUrl":     &graphql.Field{Type: graphql.String},
+			"currency":     &graphql.Field{Type: graphql.String},
+			"timezone":     &graphql.Field{Type: graphql.String},
+			"salesOpenAt":  &graphql.Field{Type: graphql.Int},
+			"salesCloseAt": &graphql.Field{Type: graphql.Int},
+			"cancelledAt":  &graphql.Field{Type: graphql.Int},
+			"saleState": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "upcoming, open or closed",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*shared.EventInfo).SaleState(time.Now()), nil
+				},
+			},
+		},
+	})
+	countsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SeatCounts",
+		Fields: graphql.Fields{
+			"total":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"available": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"held":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"booked":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+	venueType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Venue",
+		Fields: graphql.Fields{
+			"seats":        &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(seatType)))},
+			"venueVersion": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"degraded":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	seatUpdateType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SeatUpdate",
+		Fields: graphql.Fields{
+			"eventId":      &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"broadcastSeq": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"eventType":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"seatId":       &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"userId":       &graphql.Field{Type: graphql.String},
+			"status":       &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: resolveStatus},
+			"version":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"venueVersion": &graphql.Field{Type: graphql.Int},
+			"timestamp":    &graphql.Field{Type: graphql.DateTime},
+			"expiresAt":    &graphql.Field{Type: graphql.Int},
+			"seat":         &graphql.Field{Type: seatType},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"event": &graphql.Field{
+				Type: eventType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if event := eventInfo(); event != nil {
+						return event, nil
+					}
+					return nil, nil
+				},
+			},
+			"venue": &graphql.Field{
+				Type:    graphql.NewNonNull(venueType),
+				Args:    seatFilterArgs,
+				Resolve: resolveVenue,
+			},
+			"seat": &graphql.Field{
+				Type:    seatType,
+				Args:    graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: resolveSeat,
+			},
+			"availability": &graphql.Field{
+				Type: countsType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if counts, ok := venueCache.Counts(); ok {
+						return counts, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	updatedArgs := graphql.FieldConfigArgument{"eventId": &graphql.ArgumentConfig{Type: graphql.ID}}
+	for name, arg := range seatFilterArgs {
+		updatedArgs[name] = arg
+	}
+	subscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"seatUpdated": &graphql.Field{
+				Type:      graphql.NewNonNull(seatUpdateType),
+				Args:      updatedArgs,
+				Subscribe: subscribeSeatUpdated,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if err, ok := p.Source.(error); ok {
+						return nil, err
+					}
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Subscription: subscription})
+	if err != nil {
+		panic(fmt.Sprintf("graphql schema: %v", err))
+	}
+	return schema
+}
+
+// resolveStatus serves a seat status by its name, as the WebSocket protocol
+// sends it
+func resolveStatus(p graphql.ResolveParams) (interface{}, error) {
+	value, err := graphql.DefaultResolveFn(p)
+	if status, ok := value.(shared.SeatStatus); ok {
+		return status.String(), err
+	}
+	return value, err
+}
+
+// resolveVenue serves the venue from the edge's cache as VENUE_STATE does,
+// flagged degraded while the booking service is down
+func resolveVenue(p graphql.ResolveParams) (interface{}, error) {
+	filter, err := graphqlSeatFilter(p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	venue := graphqlVenue{}
+	seats, seq, err := venueSnapshot()
+	if errors.Is(err, errBookingUnavailable) {
+		cached, _, ok := venueCache.Snapshot()
+		if !ok {
+			return nil, errors.New(degradedMessage)
+		}
+		seats, venue.Degraded = cached, true
+	} else if err != nil {
+		slog.Error("Failed to get venue state", shared.LogKeyComponent, "graphql", shared.ErrAttr(err))
+		return nil, errors.New("Failed to load venue state")
+	}
+	venue.VenueVersion = seq
+
+	venue.Seats = make([]shared.Seat, 0, len(seats))
+	for _, seat := range seats {
+		if filter.matches([]shared.Seat{seat}) {
+			venue.Seats = append(venue.Seats, seat)
+		}
+	}
+	return venue, nil
+}
+
+// resolveSeat serves one seat as the booking service has it, or the edge's
+// cached copy while the booking service is down, as GET_SEAT does
+func resolveSeat(p graphql.ResolveParams) (interface{}, error) {
+	seatID, _ := p.Args["id"].(string)
+	seat, err := bookingClient.GetSeat(seatID)
+	if errors.Is(err, errBookingUnavailable) {
+		if cached, _, ok := venueCache.Seat(seatID); ok {
+			return cached, nil
+		}
+	}
+	if errors.Is(err, errSeatNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return seat, nil
+}
+
+// subscribeSeatUpdated watches the hub for the seat updates of an event, the
+// default one unless eventId is given, and sends those about seats in the
+// filter one seat at a time: a SEAT_UPDATE_BATCH comes as several, sharing
+// its broadcastSeq
+func subscribeSeatUpdated(p graphql.ResolveParams) (interface{}, error) {
+	filter, err := graphqlSeatFilter(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	eventID, _ := p.Args["eventId"].(string)
+	if eventID == "" {
+		eventID = shared.DefaultEventID
+	}
+	eventID, err = parseRoomEventID(eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hub
+	w := h.watchSeats(eventID)
+	updates := make(chan interface{})
+	go func() {
+		defer close(updates)
+		defer h.unwatchSeats(w)
+		send := func(update interface{}) bool {
+			select {
+			case updates <- update:
+				return true
+			case <-p.Context.Done():
+				return false
+			}
+		}
+		for {
+			select {
+			case <-p.Context.Done():
+				return
+			case message, ok := <-w.updates:
+				if !ok {
+					send(errFellBehind)
+					return
+				}
+				batch, err := decodeSeatUpdates(message)
+				if err != nil {
+					slog.Error("Failed to decode seat update", shared.LogKeyComponent, "graphql", shared.ErrAttr(err))
+					continue
+				}
+				for _, update := range batch {
+					if filter.matches(update.seats()) && !send(update) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return updates, nil
+}
+
+// graphqlSeatFilter builds the seat filter venue and seatUpdated are given,
+// checked as a SUBSCRIBE filter is; nil lets every seat through
+func graphqlSeatFilter(args map[string]interface{}) (*seatFilter, error) {
+	var payload shared.SeatFilterPayload
+	for _, section := range listArg(args, "sections") {
+		payload.Sections = append(payload.Sections, section.(string))
+	}
+	for _, row := range listArg(args, "rows") {
+		payload.Rows = append(payload.Rows, row.(int))
+	}
+	for _, seatID := range listArg(args, "seatIds") {
+		payload.SeatIDs = append(payload.SeatIDs, seatID.(string))
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	return parseSeatFilter(&payload)
+}
+
+// listArg returns a list argument, nil if it was not given
+func listArg(args map[string]interface{}, name string) []interface{} {
+	list, _ := args[name].([]interface{})
+	return list
+}
+
+// decodeSeatUpdates splits a stamped SEAT_UPDATE or SEAT_UPDATE_BATCH into
+// the seats it updates
+func decodeSeatUpdates(message []byte) ([]*seatUpdate, error) {
+	var msg struct {
+		Type string `json:"type"`
+		Data struct {
+			seatUpdate
+			Updates []*seatUpdate `json:"updates"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Type != shared.MessageTypeSeatUpdateBatch {
+		return []*seatUpdate{&msg.Data.seatUpdate}, nil
+	}
+	for _, update := range msg.Data.Updates {
+		update.EventID = msg.Data.EventID
+		update.BroadcastSeq = msg.Data.BroadcastSeq
+	}
+	return msg.Data.Updates, nil
+}
+
+// seats returns the seat an update is about, for filtering, or nil if it is
+// not known
+func (u *seatUpdate) seats() []shared.Seat {
+	if u.Seat != nil {
+		return []shared.Seat{*u.Seat}
+	}
+	if seat, _, ok := venueCache.Seat(u.SeatID); ok {
+		return []shared.Seat{seat}
+	}
+	return nil
+}
+
+// isSubscription reports whether the operation a request runs is a
+// subscription. Requests that do not parse are not; executing them reports why.
+func isSubscription(req graphqlRequest) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query)})})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if req.OperationName == "" || (op.Name != nil && op.Name.Value == req.OperationName) {
+			return op.Operation == ast.OperationTypeSubscription
+		}
+	}
+	return false
+}
+
+// handleGraphQL serves queries POSTed, or sent by GET, to /graphql, and
+// subscriptions to connections upgraded on it (see serveGraphQLWS). It takes
+// the same token or role as /ws.
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := connectionPermission(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		serveGraphQLWS(w, r)
+		return
+	}
+
+	var req graphqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				http.Error(w, "variables must be a JSON object", http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageSize)).Decode(&req); err != nil {
+			http.Error(w, "body must be a JSON object with a query", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "GraphQL requests are GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if isSubscription(req) {
+		http.Error(w, "subscriptions are served over a WebSocket with the graphql-transport-ws subprotocol", http.StatusBadRequest)
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        r.Context(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"concert-booking/shared"
+)
+
+func TestGraphQLQueriesServeTheCachedVenue(t *testing.T) {
+	newTestHarness(t)
+	venueCache.Replace([]shared.Seat{
+		{ID: "F1", Section: "floor", Status: shared.SeatHeld, HeldBy: "user-1", Version: 2},
+		{ID: "F2", Section: "floor", Status: shared.SeatAvailable},
+		{ID: "B1", Section: "balcony", Status: shared.SeatBooked},
+	}, 9)
+	venueCache.SetEvent(&shared.EventInfo{ID: shared.DefaultEventID, Name: "Spring Gala"})
+
+	body, _ := json.Marshal(graphqlRequest{
+		Query: `query Floor($sections: [String!]) {
+			event { name saleState }
+			availability { total held }
+			venue(sections: $sections) { venueVersion degraded seats { id status heldBy } }
+		}`,
+		Variables: map[string]interface{}{"sections": []string{"floor"}},
+	})
+	w := httptest.NewRecorder()
+	handleGraphQL(w, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /graphql = %d: %s", w.Code, w.Body)
+	}
+	want := `{"data":{"availability":{"held":1,"total":3},"event":{"name":"Spring Gala","saleState":"open"},` +
+		`"venue":{"degraded":false,"seats":[{"heldBy":"user-1","id":"F1","status":"held"},{"heldBy":"","id":"F2","status":"available"}],"venueVersion":9}}}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("POST /graphql =\n%s\nwant\n%s", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	handleGraphQL(w, httptest.NewRequest(http.MethodGet, "/graphql?query=subscription+%7BseatUpdated%7BseatId%7D%7D", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("subscription over GET = %d, want 400", w.Code)
+	}
+}
+
+func TestSeatUpdatedFollowsTheHub(t *testing.T) {
+	th := newTestHarness(t)
+	venueCache.Replace([]shared.Seat{{ID: "F1", Section: "floor"}, {ID: "B1", Section: "balcony"}}, 0)
+	previous := hub
+	hub = th.hub
+	t.Cleanup(func() { hub = previous })
+
+	srv := httptest.NewServer(http.HandlerFunc(handleGraphQL))
+	t.Cleanup(srv.Close)
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/graphql", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	send := func(msg string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() graphqlWSMessage {
+		t.Helper()
+		var msg graphqlWSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		return msg
+	}
+
+	send(`{"type": "connection_init"}`)
+	if msg := read(); msg.Type != "connection_ack" {
+		t.Fatalf("got %+v, want connection_ack", msg)
+	}
+
+	// A filter SUBSCRIBE would refuse ends the operation with an error
+	send(`{"id": "bad", "type": "subscribe", "payload": {"query": "subscription { seatUpdated(rows: [-1]) { seatId } }"}}`)
+	if msg := read(); msg.ID != "bad" || msg.Type != "error" || !strings.Contains(string(msg.Payload), "row numbers") {
+		t.Fatalf("got %s %s %s, want an error for bad", msg.ID, msg.Type, msg.Payload)
+	}
+
+	send(`{"id": "1", "type": "subscribe", "payload": {"query": "subscription { seatUpdated(sections: [\"floor\"]) { eventId broadcastSeq eventType seatId status } }"}}`)
+	eventually(t, func() bool {
+		th.hub.watchersMu.Lock()
+		defer th.hub.watchersMu.Unlock()
+		return len(th.hub.seatWatchers) == 1
+	}, "the subscription never watched the hub")
+
+	sendSeatEvent(th.hub, shared.SeatEvent{Type: "booked", SeatID: "B1", Status: shared.SeatBooked})
+	sendSeatEvent(th.hub, shared.SeatEvent{Type: shared.SeatEventReleasedBatch, Events: []shared.SeatEvent{
+		{Type: "released", SeatID: "B2", Seat: &shared.Seat{ID: "B2", Section: "balcony"}},
+		{Type: "released", SeatID: "F2", Seat: &shared.Seat{ID: "F2", Section: "floor"}},
+	}})
+	sendSeatEvent(th.hub, shared.SeatEvent{Type: "booked", SeatID: "F1", Status: shared.SeatBooked})
+
+	for _, want := range []string{
+		`{"data":{"seatUpdated":{"broadcastSeq":2,"eventId":"main","eventType":"released","seatId":"F2","status":"available"}}}`,
+		`{"data":{"seatUpdated":{"broadcastSeq":3,"eventId":"main","eventType":"booked","seatId":"F1","status":"booked"}}}`,
+	} {
+		msg := read()
+		if msg.ID != "1" || msg.Type != "next" || string(msg.Payload) != want {
+			t.Errorf("got %s %s %s, want next %s", msg.ID, msg.Type, msg.Payload, want)
+		}
+	}
+
+	// Queries run over the connection too
+	send(`{"id": "2", "type": "subscribe", "payload": {"query": "{ availability { total } }"}}`)
+	if msg := read(); msg.ID != "2" || msg.Type != "next" || string(msg.Payload) != `{"data":{"availability":{"total":2}}}` {
+		t.Errorf("got %s %s %s, want the availability", msg.ID, msg.Type, msg.Payload)
+	}
+	if msg := read(); msg.ID != "2" || msg.Type != "complete" {
+		t.Errorf("got %s %s, want complete", msg.ID, msg.Type)
+	}
+
+	send(`{"id": "1", "type": "complete"}`)
+	eventually(t, func() bool {
+		th.hub.watchersMu.Lock()
+		defer th.hub.watchersMu.Unlock()
+		return len(th.hub.seatWatchers) == 0
+	}, "the completed subscription still watches the hub")
+}
+
+func TestSeatWatchersThatFallBehindAreDropped(t *testing.T) {
+	h := newHub()
+	w := h.watchSeats(shared.DefaultEventID)
+	other := h.watchSeats("gala")
+
+	for i := 0; i <= seatWatcherBuffer; i++ {
+		h.notifySeatWatchers(broadcast{room: shared.DefaultEventID, message: []byte(`{}`)})
+	}
+	if h.seatWatchers[w] || !h.seatWatchers[other] {
+		t.Fatal("the watcher that fell behind is still fed, or the other one is not")
+	}
+	for range w.updates {
+	}
+	if len(other.updates) != 0 {
+		t.Errorf("another event's watcher got %d updates", len(other.updates))
+	}
+}
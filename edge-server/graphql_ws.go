@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+
+	"concert-booking/shared"
+)
+
+// graphqlWSProtocol is the subprotocol GraphQL subscriptions are served over,
+// that of the graphql-ws library Apollo Client and urql use
+const graphqlWSProtocol = "graphql-transport-ws"
+
+// Close codes graphql-transport-ws ends connections with
+const (
+	graphqlCloseBadMessage   = 4400
+	graphqlCloseUnauthorized = 4401
+	graphqlCloseSubprotocol  = 4406
+	graphqlCloseInitTimeout  = 4408
+	graphqlCloseDuplicateID  = 4409
+	graphqlCloseTooManyInits = 4429
+)
+
+// How long a GraphQL connection has to send connection_init
+const graphqlInitTimeout = 10 * time.Second
+
+var graphqlUpgrader = websocket.Upgrader{
+	Subprotocols: []string{graphqlWSProtocol},
+	CheckOrigin:  upgrader.CheckOrigin,
+}
+
+// graphqlWSMessage is a graphql-transport-ws message, either way
+type graphqlWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// graphqlConn is a connection to /graphql running operations, by the ID the
+// client gave each, until it completes them or leaves
+type graphqlConn struct {
+	conn *websocket.Conn
+
+	// Serializes writes, which operations make side by side
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	operations map[string]context.CancelFunc
+}
+
+// serveGraphQLWS speaks graphql-transport-ws on an upgraded /graphql request:
+// after connection_init is acknowledged, each subscribe runs an operation, a
+// subscription sending a next per seat update until either side completes it.
+// Connections count against the per-address limit, as /ws ones do.
+func serveGraphQLWS(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	admitted := hub.ipConns.acquire(ip)
+	conn, err := graphqlUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		if admitted {
+			hub.ipConns.release(ip)
+		}
+		slog.Warn("WebSocket upgrade failed", shared.LogKeyComponent, "graphql", shared.ErrAttr(err))
+		return
+	}
+	if !admitted {
+		slog.Warn("Refused connection over the per-address limit", shared.LogKeyComponent, "graphql", "remote_ip", ip)
+		refuseConnection(conn, "address")
+		return
+	}
+	defer hub.ipConns.release(ip)
+
+	c := &graphqlConn{conn: conn, operations: make(map[string]context.CancelFunc)}
+	if conn.Subprotocol() != graphqlWSProtocol {
+		c.close(graphqlCloseSubprotocol, "Subprotocol not acceptable")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer conn.Close()
+
+	var acked atomic.Bool
+	initTimer := time.AfterFunc(graphqlInitTimeout, func() {
+		if !acked.Load() {
+			c.close(graphqlCloseInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+	go c.keepAlive(ctx)
+
+	slog.Info("GraphQL client connected", "remote_ip", ip)
+	defer slog.Info("GraphQL client disconnected", "remote_ip", ip)
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg graphqlWSMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.close(graphqlCloseBadMessage, "Invalid message")
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if acked.Swap(true) {
+				c.close(graphqlCloseTooManyInits, "Too many initialisation requests")
+				return
+			}
+			c.send("", "connection_ack", nil)
+
+		case "ping":
+			c.send("", "pong", nil)
+
+		case "pong":
+
+		case "subscribe":
+			if !acked.Load() {
+				c.close(graphqlCloseUnauthorized, "Unauthorized")
+				return
+			}
+			var req graphqlRequest
+			if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil {
+				c.close(graphqlCloseBadMessage, "Invalid subscribe message")
+				return
+			}
+			if !c.start(ctx, msg.ID, req) {
+				c.close(graphqlCloseDuplicateID, "Subscriber for "+msg.ID+" already exists")
+				return
+			}
+
+		case "complete":
+			c.finish(msg.ID)
+
+		default:
+			c.close(graphqlCloseBadMessage, "Unknown message type "+msg.Type)
+			return
+		}
+	}
+}
+
+// start runs an operation under id, unless one already runs under it
+func (c *graphqlConn) start(parent context.Context, id string, req graphqlRequest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.operations[id]; ok {
+		return false
+	}
+	ctx, cancel := context.WithCancel(parent)
+	c.operations[id] = cancel
+	go c.run(ctx, id, req)
+	return true
+}
+
+// finish stops the operation under id, reporting whether it was running
+func (c *graphqlConn) finish(id string) bool {
+	c.mu.Lock()
+	cancel, ok := c.operations[id]
+	delete(c.operations, id)
+	c.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// run executes an operation, sending each result as a next. Results with
+// errors and no data end it with an error; otherwise it is completed once
+// done, unless the client completed it first.
+func (c *graphqlConn) run(ctx context.Context, id string, req graphqlRequest) {
+	params := graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        ctx,
+	}
+	var results chan *graphql.Result
+	if isSubscription(req) {
+		results = graphql.Subscribe(params)
+	} else {
+		results = make(chan *graphql.Result, 1)
+		results <- graphql.Do(params)
+		close(results)
+	}
+
+	failed := false
+	for result := range results {
+		// Results keep coming until the subscription sees it was stopped
+		if ctx.Err() != nil || failed {
+			continue
+		}
+		if result.Data == nil && len(result.Errors) > 0 {
+			failed = true
+			if c.finish(id) {
+				c.send(id, "error", result.Errors)
+			}
+			continue
+		}
+		c.send(id, "next", result)
+	}
+	if !failed && c.finish(id) {
+		c.send(id, "complete", nil)
+	}
+}
+
+// send writes a message; errors are left to the read loop to notice
+func (c *graphqlConn) send(id, msgType string, payload interface{}) {
+	msg := graphqlWSMessage{ID: id, Type: msgType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			slog.Error("Failed to marshal GraphQL result", shared.ErrAttr(err))
+			return
+		}
+		msg.Payload = data
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	c.conn.WriteJSON(msg)
+}
+
+// close ends the connection with a close frame saying why
+func (c *graphqlConn) close(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	c.conn.Close()
+}
+
+// keepAlive pings the client every pingPeriod until ctx is done, so a
+// connection that went away without closing stops being fed
+func (c *graphqlConn) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
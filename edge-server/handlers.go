@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"concert-booking/shared"
@@ -11,160 +13,507 @@ import (
 
 // Response types for client feedback
 type OperationResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+	Success bool             `json:"success"`
+	Code    shared.ErrorCode `json:"code,omitempty"` // why it failed
+	Message string           `json:"message"`
+	Data    interface{}      `json:"data,omitempty"`
 }
 
-func (c *Client) handleSubscribe(data map[string]interface{}) {
+func (c *Client) handleSubscribe(msg *shared.ClientMessage) {
+	var data shared.SubscribePayload
+	if !c.decodePayload(msg, "SUBSCRIBE_ACK", &data) {
+		return
+	}
+	userID := data.UserID
+	if userID == "" {
+		// A resumed session subscribes as it did before
+		userID = c.resumedUserID
+	}
+	if c.session != nil {
+		// Authenticated connections subscribe as their token's user
+		if userID != "" && userID != c.session.UserID {
+			c.sendFailure(msg, "SUBSCRIBE_ACK", shared.ErrorCodeForbidden, errUserMismatch.Error(), nil)
+			return
+		}
+		userID = c.session.UserID
+	}
+
+	if data.ProtocolVersion != 0 {
+		version, err := shared.NegotiateProtocolVersion(data.ProtocolVersion)
+		if err != nil {
+			c.sendFailure(msg, "SUBSCRIBE_ACK", shared.ErrorCodeUnsupportedVersion, err.Error(), nil)
+			return
+		}
+		c.protocolVersion.Store(int32(version))
+	}
+
+	filter, err := parseSeatFilter(data.Filter)
+	if err != nil {
+		c.sendFailure(msg, "SUBSCRIBE_ACK", shared.ErrorCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	c.filter.Store(filter)
+
+	if userID != "" && !c.countUser(userID) {
+		c.disconnectWith(tooManyConnections("user"))
+		return
+	}
+
 	// Extract user ID if provided
-	if userID, ok := data["user_id"].(string); ok && userID != "" {
-		c.userID = userID
-		c.lastActivity = time.Now()
-		log.Printf("[SUBSCRIBE] Client %s subscribed as user %s", c.id, c.userID)
+	if userID != "" {
+		c.hub.setUser(c, userID)
+		c.touch()
+		c.logger(context.Background()).Info("Client subscribed")
 	} else {
-		log.Printf("[SUBSCRIBE] Client %s subscribed without user ID", c.id)
+		c.logger(context.Background()).Info("Client subscribed without user ID")
 	}
 
 	// Send acknowledgment
-	c.sendMessage("SUBSCRIBE_ACK", OperationResponse{
+	ack := map[string]interface{}{
+		"client_id":  c.id,
+		"user_id":    c.userID,
+		"permission": c.permission.String(),
+
+		"protocol_version": c.protocolVersion.Load(),
+	}
+	if filter != nil {
+		ack["filter"] = filter.summary()
+	}
+	c.reply(msg, "SUBSCRIBE_ACK", OperationResponse{
 		Success: true,
 		Message: "Subscribed successfully",
-		Data: map[string]interface{}{
-			"client_id": c.id,
-			"user_id":   c.userID,
-		},
+		Data:    ack,
 	})
 
-	// Send current venue state
+	// Send current venue state, in chunks if the client can assemble them, or
+	// as a bitmask if it already has the layout
+	c.chunkedVenue.Store(data.Chunked)
+	if data.Format == shared.VenueFormatBitmask {
+		layout := data.Layout
+		c.bitmaskLayout.Store(&layout)
+	}
+	if data.Resumed {
+		// Missed seat updates were replayed; its seat map is current
+		c.subscribed.Store(true)
+		return
+	}
+	if data.VenueVersion != nil {
+		// A reconnecting client only needs what changed while it was away
+		c.syncVenue(msg, *data.VenueVersion)
+		return
+	}
+	c.subscribed.Store(true)
 	c.sendVenueState()
 }
 
-func (c *Client) handleSelectSeat(data map[string]interface{}) {
-	seatID, ok := data["seat_id"].(string)
-	if !ok || seatID == "" {
-		c.sendOperationResponse("SELECT_SEAT_RESPONSE", false, "seat_id is required", nil)
+func (c *Client) handleSelectSeat(msg *shared.ClientMessage) {
+	var data shared.SelectSeatPayload
+	if !c.decodePayload(msg, "SELECT_SEAT_RESPONSE", &data) {
 		return
 	}
+	seatID := data.SeatID
 
-	userID := c.userID
-	if uid, ok := data["user_id"].(string); ok && uid != "" {
-		userID = uid
+	userID, err := c.requestUser(data.UserID)
+	if err != nil {
+		c.sendSeatError(msg, "SELECT_SEAT_RESPONSE", err)
+		return
 	}
-	if userID == "" {
-		c.sendOperationResponse("SELECT_SEAT_RESPONSE", false, "user_id is required", nil)
+	if !c.passChallenge(msg, "SELECT_SEAT_RESPONSE", data.ChallengeToken) {
 		return
 	}
 
 	// Update activity
-	c.lastActivity = time.Now()
+	c.touch()
 
 	// Call booking service API
-	err := bookingClient.SelectSeat(seatID, userID)
+	ctx, span := c.startSeatSpan(shared.MessageTypeSelectSeat, seatID, userID)
+	err = bookingClient.SelectSeat(ctx, shared.SeatRequest{
+		SeatID:  seatID,
+		UserID:  userID,
+		Version: data.Version,
+
+		IdempotencyKey: idempotencyKey(userID, data.RequestID),
+	})
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("[ERROR] Failed to select seat %s for user %s: %v", seatID, userID, err)
-		c.sendOperationResponse("SELECT_SEAT_RESPONSE", false, err.Error(), nil)
+		c.seatLogger(ctx, seatID, userID).Error("Failed to select seat", shared.ErrAttr(err))
+		c.sendSeatError(msg, "SELECT_SEAT_RESPONSE", err)
 		return
 	}
 
 	// Success - send immediate confirmation
-	c.sendOperationResponse("SELECT_SEAT_RESPONSE", true, 
+	c.sendOperationResponse(msg, "SELECT_SEAT_RESPONSE", true, 
 		fmt.Sprintf("Seat %s selected successfully", seatID), 
 		map[string]string{"seat_id": seatID, "user_id": userID})
 	
-	log.Printf("[SELECT] Client %s (user %s) selected seat %s", c.id, userID, seatID)
+	c.seatLogger(ctx, seatID, userID).Info("Seat selected")
 }
 
-func (c *Client) handleBookSeat(data map[string]interface{}) {
-	seatID, ok := data["seat_id"].(string)
-	if !ok || seatID == "" {
-		c.sendOperationResponse("BOOK_SEAT_RESPONSE", false, "seat_id is required", nil)
+func (c *Client) handleBookSeat(msg *shared.ClientMessage) {
+	var data shared.SeatPayload
+	if !c.decodePayload(msg, "BOOK_SEAT_RESPONSE", &data) {
 		return
 	}
+	seatID := data.SeatID
 
-	userID := c.userID
-	if uid, ok := data["user_id"].(string); ok && uid != "" {
-		userID = uid
-	}
-	if userID == "" {
-		c.sendOperationResponse("BOOK_SEAT_RESPONSE", false, "user_id is required", nil)
+	userID, err := c.requestUser(data.UserID)
+	if err != nil {
+		c.sendSeatError(msg, "BOOK_SEAT_RESPONSE", err)
 		return
 	}
 
 	// Update activity
-	c.lastActivity = time.Now()
+	c.touch()
 
 	// Call booking service API
-	err := bookingClient.BookSeat(seatID, userID)
+	ctx, span := c.startSeatSpan(shared.MessageTypeBookSeat, seatID, userID)
+	err = bookingClient.BookSeat(ctx, shared.SeatRequest{
+		SeatID:  seatID,
+		UserID:  userID,
+		Version: data.Version,
+
+		IdempotencyKey: idempotencyKey(userID, data.RequestID),
+	})
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("[ERROR] Failed to book seat %s for user %s: %v", seatID, userID, err)
-		c.sendOperationResponse("BOOK_SEAT_RESPONSE", false, err.Error(), nil)
+		c.seatLogger(ctx, seatID, userID).Error("Failed to book seat", shared.ErrAttr(err))
+		c.sendSeatError(msg, "BOOK_SEAT_RESPONSE", err)
 		return
 	}
 
 	// Success - send immediate confirmation
-	c.sendOperationResponse("BOOK_SEAT_RESPONSE", true, 
+	c.sendOperationResponse(msg, "BOOK_SEAT_RESPONSE", true, 
 		fmt.Sprintf("Seat %s booked successfully", seatID), 
 		map[string]string{"seat_id": seatID, "user_id": userID})
 	
-	log.Printf("[BOOK] Client %s (user %s) booked seat %s", c.id, userID, seatID)
+	c.seatLogger(ctx, seatID, userID).Info("Seat booked")
 }
 
-func (c *Client) handleReleaseSeat(data map[string]interface{}) {
-	seatID, ok := data["seat_id"].(string)
-	if !ok || seatID == "" {
-		c.sendOperationResponse("RELEASE_SEAT_RESPONSE", false, "seat_id is required", nil)
+func (c *Client) handleReleaseSeat(msg *shared.ClientMessage) {
+	var data shared.SeatPayload
+	if !c.decodePayload(msg, "RELEASE_SEAT_RESPONSE", &data) {
 		return
 	}
+	seatID := data.SeatID
 
-	userID := c.userID
-	if uid, ok := data["user_id"].(string); ok && uid != "" {
-		userID = uid
-	}
-	if userID == "" {
-		c.sendOperationResponse("RELEASE_SEAT_RESPONSE", false, "user_id is required", nil)
+	userID, err := c.requestUser(data.UserID)
+	if err != nil {
+		c.sendSeatError(msg, "RELEASE_SEAT_RESPONSE", err)
 		return
 	}
 
 	// Update activity
-	c.lastActivity = time.Now()
+	c.touch()
 
 	// Call booking service API
-	err := bookingClient.ReleaseSeat(seatID, userID)
+	ctx, span := c.startSeatSpan(shared.MessageTypeReleaseSeat, seatID, userID)
+	err = bookingClient.ReleaseSeat(ctx, shared.SeatRequest{
+		SeatID:  seatID,
+		UserID:  userID,
+		Version: data.Version,
+
+		IdempotencyKey: idempotencyKey(userID, data.RequestID),
+	})
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("[ERROR] Failed to release seat %s for user %s: %v", seatID, userID, err)
-		c.sendOperationResponse("RELEASE_SEAT_RESPONSE", false, err.Error(), nil)
+		c.seatLogger(ctx, seatID, userID).Error("Failed to release seat", shared.ErrAttr(err))
+		c.sendSeatError(msg, "RELEASE_SEAT_RESPONSE", err)
 		return
 	}
 
 	// Success - send immediate confirmation
-	c.sendOperationResponse("RELEASE_SEAT_RESPONSE", true, 
+	c.sendOperationResponse(msg, "RELEASE_SEAT_RESPONSE", true, 
 		fmt.Sprintf("Seat %s released successfully", seatID), 
 		map[string]string{"seat_id": seatID, "user_id": userID})
 	
-	log.Printf("[RELEASE] Client %s (user %s) released seat %s", c.id, userID, seatID)
+	c.seatLogger(ctx, seatID, userID).Info("Seat released")
+}
+
+// handleHoldKeepalive is the holder's periodic ping for events whose holds
+// are kept alive on activity. The new expiry reaches the client as a renewed
+// SEAT_UPDATE.
+func (c *Client) handleHoldKeepalive(msg *shared.ClientMessage) {
+	var data shared.SeatPayload
+	if !c.decodePayload(msg, "HOLD_KEEPALIVE_RESPONSE", &data) {
+		return
+	}
+	seatID := data.SeatID
+
+	userID, err := c.requestUser(data.UserID)
+	if err != nil {
+		c.sendSeatError(msg, "HOLD_KEEPALIVE_RESPONSE", err)
+		return
+	}
+
+	// Update activity
+	c.touch()
+
+	ctx, span := c.startSeatSpan(shared.MessageTypeHoldKeepalive, seatID, userID)
+	err = bookingClient.KeepHold(ctx, shared.SeatRequest{SeatID: seatID, UserID: userID})
+	endSpan(span, err)
+	if err != nil {
+		c.seatLogger(ctx, seatID, userID).Warn("Failed to keep hold alive", shared.ErrAttr(err))
+		c.sendSeatError(msg, "HOLD_KEEPALIVE_RESPONSE", err)
+		return
+	}
+
+	c.sendOperationResponse(msg, "HOLD_KEEPALIVE_RESPONSE", true,
+		fmt.Sprintf("Hold on seat %s kept alive", seatID),
+		map[string]string{"seat_id": seatID, "user_id": userID})
+}
+
+// handleJoin adds the client to an event's room, so it also gets that
+// event's seat updates, each carrying event_id and numbered by that event's
+// broadcast_seq
+func (c *Client) handleJoin(msg *shared.ClientMessage) {
+	var data shared.RoomPayload
+	if !c.decodePayload(msg, "JOIN_RESPONSE", &data) {
+		return
+	}
+	eventID, err := parseRoomEventID(data.EventID)
+	if err != nil {
+		c.sendFailure(msg, "JOIN_RESPONSE", shared.ErrorCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	seq, err := c.hub.join(c, eventID)
+	if err != nil {
+		c.sendFailure(msg, "JOIN_RESPONSE", shared.ErrorCodeConflict, err.Error(), nil)
+		return
+	}
+	c.touch()
+	c.logger(context.Background()).Info("Client joined event", "event_id", eventID)
+
+	c.sendOperationResponse(msg, "JOIN_RESPONSE", true, fmt.Sprintf("Joined event %s", eventID),
+		map[string]interface{}{"event_id": eventID, "broadcast_seq": seq})
+}
+
+// handleLeave takes the client out of an event's room; it gets none of that
+// event's seat updates after LEAVE_RESPONSE, even for the event it connected for
+func (c *Client) handleLeave(msg *shared.ClientMessage) {
+	var data shared.RoomPayload
+	if !c.decodePayload(msg, "LEAVE_RESPONSE", &data) {
+		return
+	}
+	eventID, err := parseRoomEventID(data.EventID)
+	if err != nil {
+		c.sendFailure(msg, "LEAVE_RESPONSE", shared.ErrorCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if !c.hub.leave(c, eventID) {
+		c.sendFailure(msg, "LEAVE_RESPONSE", shared.ErrorCodeNotFound, fmt.Sprintf("Not in event %s", eventID), nil)
+		return
+	}
+	c.touch()
+	c.logger(context.Background()).Info("Client left event", "event_id", eventID)
+
+	c.sendOperationResponse(msg, "LEAVE_RESPONSE", true, fmt.Sprintf("Left event %s", eventID),
+		map[string]string{"event_id": eventID})
+}
+
+// handleGetSeat sends one seat as the booking service has it, so a client can
+// refresh a seat it suspects is stale without pulling the whole venue. While
+// the booking service is down the edge's cached copy is sent, flagged degraded.
+func (c *Client) handleGetSeat(msg *shared.ClientMessage) {
+	var data shared.GetSeatPayload
+	if !c.decodePayload(msg, "GET_SEAT_RESPONSE", &data) {
+		return
+	}
+	seatID := data.SeatID
+
+	seat, err := bookingClient.GetSeat(seatID)
+	if errors.Is(err, errBookingUnavailable) {
+		cached, updated, ok := venueCache.Seat(seatID)
+		if !ok {
+			c.sendSeatError(msg, "GET_SEAT_RESPONSE", err)
+			return
+		}
+		c.logger(context.Background()).Warn("Sent cached seat, booking service unavailable", "seat_id", seatID,
+			"age", time.Since(updated).Round(time.Second), shared.ErrAttr(err))
+		c.sendOperationResponse(msg, "GET_SEAT_RESPONSE", true, "Seat from cache", map[string]interface{}{"seat": cached, "degraded": true})
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, errSeatNotFound) {
+			c.logger(context.Background()).Error("Failed to get seat", "seat_id", seatID, shared.ErrAttr(err))
+		}
+		c.sendSeatError(msg, "GET_SEAT_RESPONSE", err)
+		return
+	}
+
+	c.sendOperationResponse(msg, "GET_SEAT_RESPONSE", true, "Seat found", map[string]interface{}{"seat": seat})
+}
+
+// handleTokenRefresh replaces the connection's session token before it
+// expires, so long sessions need not reconnect. The new token must be for the
+// same user; its role and expiry take effect immediately.
+func (c *Client) handleTokenRefresh(msg *shared.ClientMessage) {
+	if c.session == nil {
+		c.sendFailure(msg, "TOKEN_REFRESH_RESPONSE", shared.ErrorCodeInvalidRequest, "token authentication is not enabled", nil)
+		return
+	}
+
+	var data shared.TokenRefreshPayload
+	if !c.decodePayload(msg, "TOKEN_REFRESH_RESPONSE", &data) {
+		return
+	}
+	claims, err := shared.ParseSessionToken(authSecret, data.Token, time.Now())
+	if err != nil {
+		c.logger(context.Background()).Warn("Client sent an invalid token", shared.LogKeyComponent, "auth", shared.ErrAttr(err))
+		c.sendFailure(msg, "TOKEN_REFRESH_RESPONSE", shared.ErrorCodeUnauthorized, err.Error(), nil)
+		return
+	}
+	if claims.UserID != c.session.UserID {
+		c.logger(context.Background()).Warn("Client sent a token for another user", shared.LogKeyComponent, "auth", "token_user_id", claims.UserID)
+		c.sendFailure(msg, "TOKEN_REFRESH_RESPONSE", shared.ErrorCodeForbidden, "token is for a different user", nil)
+		return
+	}
+	permission, err := rolePermission(claims.Role)
+	if err != nil {
+		c.sendFailure(msg, "TOKEN_REFRESH_RESPONSE", shared.ErrorCodeUnauthorized, err.Error(), nil)
+		return
+	}
+
+	c.session = claims
+	c.permission = permission
+	c.touch()
+
+	c.sendOperationResponse(msg, "TOKEN_REFRESH_RESPONSE", true, "Token refreshed", map[string]interface{}{
+		"user_id":    claims.UserID,
+		"permission": permission.String(),
+		"expires_at": claims.ExpiresAt,
+	})
+	c.logger(context.Background()).Info("Client refreshed its token", shared.LogKeyComponent, "auth", "expires_at", claims.ExpiresAt)
 }
 
 func (c *Client) sendVenueState() {
-	// Get all seats from booking service
-	seats, err := bookingClient.GetAllSeats()
+	// Served from the edge's cache unless it may have missed seat events
+	seats, seq, err := venueSnapshot()
+	if errors.Is(err, errBookingUnavailable) {
+		c.sendCachedVenueState(err)
+		return
+	}
 	if err != nil {
-		log.Printf("[ERROR] Failed to get venue state for client %s: %v", c.id, err)
-		c.sendOperationResponse("VENUE_STATE_ERROR", false, "Failed to load venue state", nil)
+		c.logger(context.Background()).Error("Failed to get venue state", shared.ErrAttr(err))
+		c.sendMessage("VENUE_STATE_ERROR", OperationResponse{Success: false, Message: "Failed to load venue state"})
 		return
 	}
 
 	// Send venue state to client
-	c.sendMessage(shared.MessageTypeVenueState, shared.VenueState{Seats: seats})
-	log.Printf("[VENUE] Sent venue state to client %s (%d seats)", c.id, len(seats))
+	c.sendVenue(shared.VenueState{Seats: seats, Event: eventInfo(), Flags: currentFlags(), VenueVersion: seq})
+	c.logger(context.Background()).Info("Sent venue state", "seats", len(seats))
 }
 
+// sendCachedVenueState sends the edge's cached seat map, flagged degraded,
+// when the booking service cannot be reached
+func (c *Client) sendCachedVenueState(cause error) {
+	seats, updated, ok := venueCache.Snapshot()
+	if !ok {
+		c.logger(context.Background()).Error("Failed to get venue state and none is cached", shared.ErrAttr(cause))
+		c.sendMessage("VENUE_STATE_ERROR", OperationResponse{Success: false, Message: "Failed to load venue state"})
+		return
+	}
 
-// sendOperationResponse sends a structured response to the client
-func (c *Client) sendOperationResponse(msgType string, success bool, message string, data interface{}) {
-	c.sendMessage(msgType, OperationResponse{
+	event, _ := venueCache.Event()
+	c.sendVenue(shared.VenueState{Seats: seats, Event: event, Flags: currentFlags(), Degraded: true})
+	c.logger(context.Background()).Warn("Sent cached venue state, booking service unavailable", "seats", len(seats),
+		"age", time.Since(updated).Round(time.Second), shared.ErrAttr(cause))
+}
+
+
+// eventInfo returns the event's branding for VENUE_STATE, or nil if the
+// booking service cannot provide it; the seat map renders without it. It is
+// fetched once and then refreshed with the venue cache.
+func eventInfo() *shared.EventInfo {
+	if event, ok := venueCache.Event(); ok {
+		return event
+	}
+	return fetchEventInfo()
+}
+
+// fetchEventInfo gets the event's branding from the booking service and caches it
+func fetchEventInfo() *shared.EventInfo {
+	event, err := bookingClient.GetEventInfo()
+	if err != nil {
+		slog.Warn("Failed to get event metadata", shared.ErrAttr(err))
+		return nil
+	}
+	venueCache.SetEvent(event)
+	return event
+}
+
+var (
+	errUserMismatch = shared.NewError(shared.ErrorCodeForbidden, "user_id does not match the authenticated user")
+	errUserRequired = shared.NewError(shared.ErrorCodeInvalidRequest, "user_id is required")
+)
+
+// requestUser returns the user a seat message acts for: the user_id it names,
+// else the subscribed user. Authenticated connections may only act as the user
+// their token names.
+func (c *Client) requestUser(named string) (string, error) {
+	userID := c.userID
+	if named != "" {
+		userID = named
+	}
+
+	if c.session != nil {
+		if userID != "" && userID != c.session.UserID {
+			return "", errUserMismatch
+		}
+		return c.session.UserID, nil
+	}
+	if userID == "" {
+		return "", errUserRequired
+	}
+	return userID, nil
+}
+
+// idempotencyKey derives the booking service Idempotency-Key from the optional
+// request_id a client attaches to a retried message. It is scoped to the user so
+// clients may number their requests independently.
+func idempotencyKey(userID, requestID string) string {
+	if requestID == "" {
+		return ""
+	}
+	return "ws:" + userID + ":" + requestID
+}
+
+// decodePayload decodes the data of msg into p. If it does not decode or
+// validate, msg is answered with responseType saying which field is wrong.
+func (c *Client) decodePayload(msg *shared.ClientMessage, responseType string, p shared.Payload) bool {
+	if err := shared.DecodePayload(msg.Data, p); err != nil {
+		c.logger(context.Background()).Debug("Client sent an invalid message", "type", msg.Type, shared.ErrAttr(err))
+		c.sendFailure(msg, responseType, shared.ErrorCodeInvalidRequest, err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+// sendSeatError replies to a seat command that failed, with the error's code.
+// If the booking service could not be reached the reply says so, flagged
+// degraded, instead of passing on the transport error.
+func (c *Client) sendSeatError(req *shared.ClientMessage, msgType string, err error) {
+	if errors.Is(err, errBookingUnavailable) {
+		c.sendFailure(req, msgType, shared.ErrorCodeUnavailable, degradedMessage, map[string]bool{"degraded": true})
+		return
+	}
+	c.sendFailure(req, msgType, shared.CodeOf(err, shared.ErrorCodeInternal), err.Error(), nil)
+}
+
+// sendOperationResponse sends a structured response to req
+func (c *Client) sendOperationResponse(req *shared.ClientMessage, msgType string, success bool, message string, data interface{}) {
+	c.reply(req, msgType, OperationResponse{
 		Success: success,
 		Message: message,
 		Data:    data,
 	})
-}
\ No newline at end of file
+}
+
+// sendFailure answers req with a failed response saying why, as code and as
+// message
+func (c *Client) sendFailure(req *shared.ClientMessage, msgType string, code shared.ErrorCode, message string, data interface{}) {
+	c.reply(req, msgType, OperationResponse{
+		Code:    code,
+		Message: message,
+		Data:    data,
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// How long the edge waits after its last client leaves before hibernating,
+// unless EDGE_HIBERNATE_AFTER says otherwise
+const defaultHibernateAfter = time.Minute
+
+// Hibernator drops the edge's seat event subscription while nobody is connected,
+// so an idle edge costs the event bus nothing. The first client to connect
+// restores it; clients fetch the venue on SUBSCRIBE, so nothing missed while
+// hibernating needs replaying.
+type Hibernator struct {
+	// Opens the seat event subscription
+	subscribe func() (shared.Subscription, error)
+
+	// Called once the subscription is dropped, to discard state built from events
+	onSleep func()
+
+	// Grace period between the last client leaving and hibernating
+	after time.Duration
+
+	mu          sync.Mutex
+	sub         shared.Subscription
+	timer       *time.Timer
+	timerGen    int // identifies the armed timer, so a stale one that already fired does nothing
+	hibernating bool
+}
+
+// NewHibernator opens the subscription and returns a hibernator managing it
+func NewHibernator(subscribe func() (shared.Subscription, error), onSleep func(), after time.Duration) (*Hibernator, error) {
+	sub, err := subscribe()
+	if err != nil {
+		return nil, err
+	}
+	return &Hibernator{subscribe: subscribe, onSleep: onSleep, after: after, sub: sub}, nil
+}
+
+// hibernateAfterFromEnv reads EDGE_HIBERNATE_AFTER; 0 disables hibernation
+func hibernateAfterFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_HIBERNATE_AFTER")
+	if raw == "" {
+		return defaultHibernateAfter, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// ClientsChanged is told the client count whenever a client connects or leaves.
+// The last client leaving arms the hibernation timer; a client connecting
+// cancels it, or wakes the edge if it is already hibernating.
+func (h *Hibernator) ClientsChanged(clients int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+
+	if clients == 0 {
+		if h.after > 0 && !h.hibernating {
+			h.timerGen++
+			gen := h.timerGen
+			h.timer = time.AfterFunc(h.after, func() { h.hibernate(gen) })
+		}
+		return
+	}
+
+	if h.hibernating {
+		h.wake()
+	}
+}
+
+// Hibernating reports whether the subscription is currently dropped
+func (h *Hibernator) Hibernating() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hibernating
+}
+
+// hibernate drops the subscription, unless timer gen was cancelled after it fired
+func (h *Hibernator) hibernate(gen int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.timer == nil || h.timerGen != gen {
+		return
+	}
+	h.timer = nil
+
+	if err := h.sub.Unsubscribe(); err != nil {
+		slog.Warn("Failed to unsubscribe from seat events, staying awake", shared.ErrAttr(err))
+		return
+	}
+	h.sub = nil
+	h.hibernating = true
+	if h.onSleep != nil {
+		h.onSleep()
+	}
+	slog.Info("No clients, hibernating: unsubscribed from seat events", "idle", h.after)
+}
+
+// wake restores the subscription; h.mu must be held
+func (h *Hibernator) wake() {
+	sub, err := h.subscribe()
+	if err != nil {
+		// Stay hibernating so the next connection retries
+		slog.Error("Failed to resubscribe to seat events", shared.ErrAttr(err))
+		return
+	}
+	h.sub = sub
+	h.hibernating = false
+	slog.Info("Client connected, woke from hibernation")
+}
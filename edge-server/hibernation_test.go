@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+type countingSubscription struct {
+	active *atomic.Int32
+}
+
+func (s countingSubscription) Unsubscribe() error {
+	s.active.Add(-1)
+	return nil
+}
+
+func TestHibernatorUnsubscribesWhileIdleAndWakesOnConnect(t *testing.T) {
+	var active, opened atomic.Int32
+	var slept atomic.Int32
+	subscribe := func() (shared.Subscription, error) {
+		active.Add(1)
+		opened.Add(1)
+		return countingSubscription{active: &active}, nil
+	}
+
+	h, err := NewHibernator(subscribe, func() { slept.Add(1) }, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewHibernator: %v", err)
+	}
+
+	// A client arriving within the grace period keeps the subscription
+	h.ClientsChanged(0)
+	h.ClientsChanged(1)
+	time.Sleep(40 * time.Millisecond)
+	if h.Hibernating() || active.Load() != 1 {
+		t.Fatalf("hibernated although a client connected in time")
+	}
+
+	h.ClientsChanged(0)
+	waitFor(t, h.Hibernating)
+	if active.Load() != 0 || slept.Load() != 1 {
+		t.Fatalf("hibernating with %d subscriptions and %d sleep callbacks, want 0 and 1", active.Load(), slept.Load())
+	}
+
+	h.ClientsChanged(1)
+	if h.Hibernating() || active.Load() != 1 || opened.Load() != 2 {
+		t.Fatalf("after a client connected: hibernating=%v, %d active of %d opened", h.Hibernating(), active.Load(), opened.Load())
+	}
+}
+
+func TestHibernatorDisabledWithZeroGrace(t *testing.T) {
+	var active atomic.Int32
+	h, _ := NewHibernator(func() (shared.Subscription, error) {
+		active.Add(1)
+		return countingSubscription{active: &active}, nil
+	}, nil, 0)
+
+	h.ClientsChanged(0)
+	time.Sleep(20 * time.Millisecond)
+	if h.Hibernating() || active.Load() != 1 {
+		t.Fatal("hibernated with hibernation disabled")
+	}
+}
+
+func TestSequencerResetStartsFreshSequence(t *testing.T) {
+	applied := make(chan int64, 4)
+	s := NewEventSequencer(func(e shared.SeatEvent) { applied <- e.Seq }, nil)
+	go s.Run()
+
+	s.Enqueue(shared.SeatEvent{Seq: 5})
+	if seq := <-applied; seq != 5 {
+		t.Fatalf("applied %d, want 5", seq)
+	}
+
+	// Events 6-40 were never received while hibernating
+	s.Reset()
+	s.Enqueue(shared.SeatEvent{Seq: 41})
+	select {
+	case seq := <-applied:
+		if seq != 41 {
+			t.Fatalf("applied %d, want 41", seq)
+		}
+	case <-time.After(eventGapTimeout / 2):
+		t.Fatal("event after reset was held back as a gap")
+	}
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,180 +1,635 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"concert-booking/shared"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
+// Broadcast priorities. The hub drains queues strictly from highest to lowest, so
+// when broadcasts back up, transitions users act on overtake noisy intermediate ones.
+const (
+	PriorityHigh   = iota // booked, released, auto_released, allocated, returned
+	PriorityNormal        // anything not classified
+	PriorityLow           // held
+	numPriorities
+)
+
+// Capacity of each broadcast priority queue
+const broadcastQueueSize = 256
+
+// broadcast is a message queued for every client, or for those following
+// one event
+type broadcast struct {
+	message []byte
+
+	// The event whose room the broadcast goes to; "" sends it to every client
+	room string
+
+	// broadcast_seq a seat update was stamped with
+	seq int64
+
+	// A SEAT_UPDATE or SEAT_UPDATE_BATCH, encoded into message once it is
+	// stamped with its broadcast sequence number; nil for anything else
+	seatUpdate *shared.ServerMessage
+
+	// The seats a seat update is about, so clients that subscribed to part of
+	// the venue only get the updates for it; nil sends it to every client
+	seats []shared.Seat
+
+	// Sent instead of message to clients that asked for chunked venue state;
+	// nil for anything but VENUE_STATE
+	chunks [][]byte
+
+	// Sent instead to clients that asked for a bitmask and have layout drawn;
+	// nil for anything but VENUE_STATE
+	bitmask []byte
+	layout  string
+
+	// The client a broadcast came from, which does not get it back; nil for
+	// broadcasts from the edge itself
+	from *Client
+}
+
 // HubStats tracks statistics for the hub
 type HubStats struct {
 	TotalClients      int       `json:"total_clients"`
 	TotalMessages     int64     `json:"total_messages"`
+	DroppedMessages   int64     `json:"dropped_messages"`
+	FilteredMessages  int64     `json:"filtered_messages"` // seat updates left out by client filters
+	CoalescedUpdates  int64     `json:"coalesced_updates"` // seat updates superseded within a coalescing window
 	ConnectedAt       time.Time `json:"connected_at"`
 	LastBroadcastTime time.Time `json:"last_broadcast_time"`
+
+	// Connections and waiting room by event
+	Events map[string]shared.EventConnStats `json:"events,omitempty"`
+
+	// Runtime invariant violations, counted only with INVARIANT_CHECKS set
+	InvariantViolations int64 `json:"invariant_violations,omitempty"`
 }
 
 // Hub maintains the set of active clients and broadcasts messages to the clients
 type Hub struct {
-	// Registered clients
-	clients map[*Client]bool
-
-	// Inbound messages from the clients
-	broadcast chan []byte
+	// Registered clients, split among shards that each register, unregister
+	// and deliver broadcasts to their own
+	shards []*hubShard
 
-	// Register requests from the clients
-	register chan *Client
+	// Clients the shards deliver to
+	clientCount atomic.Int64
 
-	// Unregister requests from clients
-	unregister chan *Client
+	// Outbound broadcasts, one queue per priority
+	broadcastQueues [numPriorities]chan broadcast
 
 	// Statistics
-	stats HubStats
+	connectedAt   time.Time
+	messages      atomic.Int64
+	dropped       atomic.Int64
+	lastBroadcast atomic.Int64 // Unix nanoseconds
+
+	// Seat updates broadcast so far, for spotting clients whose seat map went
+	// stale while they were idle
+	seatEvents atomic.Int64
+
+	// Seat updates not sent to clients whose filter left them out
+	filtered atomic.Int64
+
+	// Seat updates left out of a coalesced batch for a later one to the same seat
+	coalesced atomic.Int64
+
+	// Clients by the event they follow, each event's seat updates numbered
+	// and kept for replay on their own
+	rooms map[string]*room
+
+	// Names this hub's broadcast sequences, which restart with the edge, in
+	// the last_event_id clients reconnect with
+	streamID string
+
+	// Seat updates each room keeps for replay to clients that reconnect with
+	// last_event_id; 0 disables replay
+	replaySize int
+
+	// Called when a user's first client subscribes on this edge (online) or
+	// their last client leaves (offline); nil when presence is not published
+	onPresence func(userID string, online bool)
+
+	// Connections per client address and per user; nil leaves them uncapped
+	ipConns   *connCounter
+	userConns *connCounter
+
+	// Called with each seat focus of a client here, to pass it to the other
+	// edges; nil without NATS
+	onFocus func(focus shared.SeatFocus)
+
+	// Called with each KICK_USER an admin connection here sends, to pass it to
+	// every edge, this one included; nil without NATS
+	onKick func(kick shared.KickUser)
+
+	// Called with the client count after every register and unregister; nil
+	// when the edge does not hibernate
+	onClientsChanged func(clients int)
+
+	// Serializes calls to onClientsChanged, which shards make side by side
+	clientsChangedMu sync.Mutex
+
+	// Connection caps by event, and each event's clients and waiting room
+	caps   eventCaps
+	events map[string]*eventSlots
+
+	// How long a client admitted to a capped event may be gone and still
+	// re-enter ahead of its waiting room
+	admissionGrace time.Duration
+
+	// Sessions clients can resume by session token, and how long after
+	// their connection goes
+	sessionsMu   sync.Mutex
+	sessions     map[string]*resumableSession
+	sessionGrace time.Duration
+
+	// Guards the user each client subscribed as, and how many clients each
+	// user has here
+	usersMu sync.RWMutex
+	users   map[string]int
+
+	// Close frame sent to every client, including any that still register,
+	// once the edge is shutting down; nil until then
+	restartFrame atomic.Pointer[[]byte]
+
+	// Readers of seat updates other than clients, by the event they watch
+	watchersMu   sync.Mutex
+	seatWatchers map[*seatWatcher]bool
 	
-	// Mutex for thread-safe operations
+	// Guards rooms and events, the index of what the shards hold that changes
+	// only as an event is first seen or its room empties. It is held only to
+	// look up or add to them, never while taking another lock.
 	mu sync.RWMutex
 }
 
 func newHub() *Hub {
-	return &Hub{
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
-		stats: HubStats{
-			ConnectedAt: time.Now(),
-		},
+	h := &Hub{
+		events:         make(map[string]*eventSlots),
+		rooms:          make(map[string]*room),
+		users:          make(map[string]int),
+		admissionGrace: defaultAdmissionGrace,
+		sessionGrace:   defaultSessionGrace,
+		streamID:       uuid.NewString()[:8],
+		replaySize:     defaultReplayBuffer,
+		connectedAt:    time.Now(),
+	}
+	for i := range h.broadcastQueues {
+		h.broadcastQueues[i] = make(chan broadcast, broadcastQueueSize)
 	}
+	// One shard per CPU the edge may use
+	h.shards = newHubShards(h, runtime.GOMAXPROCS(0))
+	return h
 }
 
+// run stamps queued broadcasts, highest priority first, and hands them to
+// the shards; clients come and go on their shards' loops
 func (h *Hub) run() {
 	for {
 		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.stats.TotalClients = len(h.clients)
-			h.mu.Unlock()
-			
-			log.Printf("Client registered: %s (total clients: %d)", client.id, h.stats.TotalClients)
-			
-			// Send welcome message to the new client
-			h.sendWelcomeMessage(client)
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				h.stats.TotalClients = len(h.clients)
-			}
-			h.mu.Unlock()
-			
-			log.Printf("Client unregistered: %s (total clients: %d)", client.id, h.stats.TotalClients)
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			clientCount := len(h.clients)
-			h.mu.RUnlock()
-			
-			// Update statistics
-			h.mu.Lock()
-			h.stats.TotalMessages++
-			h.stats.LastBroadcastTime = time.Now()
-			h.mu.Unlock()
-			
-			// Send message to all connected clients
-			h.broadcastToClients(message)
-			
-			log.Printf("Broadcasted message to %d clients (total broadcasts: %d)", 
-				clientCount, h.stats.TotalMessages)
+		case message := <-h.broadcastQueues[PriorityHigh]:
+			h.handleBroadcast(message)
+
+		case message := <-h.broadcastQueues[PriorityNormal]:
+			h.drainAbove(PriorityNormal)
+			h.handleBroadcast(message)
+
+		case message := <-h.broadcastQueues[PriorityLow]:
+			h.drainAbove(PriorityLow)
+			h.handleBroadcast(message)
 		}
 	}
 }
 
-func (h *Hub) broadcastMessage(message []byte) {
-	select {
-	case h.broadcast <- message:
-		// Message queued successfully
-	default:
-		// Broadcast channel is full
-		log.Printf("Warning: Broadcast channel full, dropping message")
+// addLocked lets a client in, registered or from the waiting room: it counts
+// against its event, and is sent broadcasts once its shard attaches it;
+// slots.mu must be held if the event is capped
+func (h *Hub) addLocked(slots *eventSlots, client *Client) {
+	slots.connected.Add(1)
+	if h.caps.capFor(client.eventID) > 0 {
+		slots.admitLocked(client, time.Now())
+	} else {
+		client.admission = ""
 	}
+	client.added.Store(true)
 }
 
-// broadcastToClients sends a message to all connected clients
-func (h *Hub) broadcastToClients(message []byte) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// welcome finishes letting in a client added to the hub. Only its shard's
+// loop calls it.
+func (h *Hub) welcome(s *hubShard, client *Client) {
+	if !h.attach(s, client) {
+		// It left before its shard got to it
+		return
+	}
+
+	// Looked at once attached, so a shutdown either finds the client or the
+	// client finds its frame
+	if restartFrame := h.restartFrame.Load(); restartFrame != nil {
+		client.disconnectWith(*restartFrame)
+	}
+
+	h.clientsChanged()
 	
-	for client := range h.clients {
+	slog.Info("Client registered", shared.LogKeyClientID, client.id, "total_clients", h.GetClientCount())
+
+	// Send welcome message to the new client, then any seat updates it
+	// missed while reconnecting. Both happen on the shard's loop, so no
+	// broadcast comes between them.
+	replay, from, replayed := h.replayFor(s, client)
+	h.sendWelcomeMessage(s, client, replay, from, replayed)
+	for _, message := range replay {
 		select {
 		case client.send <- message:
-			// Message sent successfully
 		default:
-			// Client's send channel is full, close it
-			log.Printf("Client %s send buffer full, disconnecting", client.id)
-			go func(c *Client) {
-				h.unregister <- c
-			}(client)
 		}
 	}
+	if client.lastEventID != "" {
+		slog.Info("Replayed missed seat updates", shared.LogKeyClientID, client.id, "last_event_id", client.lastEventID,
+			"replayed", replayed, "updates", len(replay))
+	}
 }
 
-// sendWelcomeMessage sends a welcome message to a newly connected client
-func (h *Hub) sendWelcomeMessage(client *Client) {
+// remove drops an unregistered client and gives its slot to the next client
+// waiting for the same event. Only the client's shard's loop calls it.
+func (h *Hub) remove(s *hubShard, client *Client) {
+	// It may have been let in from the waiting room and not attached yet
+	removed := client.added.CompareAndSwap(true, false)
+	if removed {
+		h.detach(s, client)
+		s.closeSend(client)
+		h.releaseSlot(client)
+		h.leaveSession(client)
+		h.dropUser(client)
+	}
+	h.clientsChanged()
+	
+	slog.Info("Client unregistered", shared.LogKeyClientID, client.id, "total_clients", h.GetClientCount())
+
+	if removed {
+		if next := h.admitWaiting(client.eventID); next != nil {
+			h.shardFor(next).admit(next)
+		}
+	}
+}
+
+// clientsChanged calls onClientsChanged with the client count, read under
+// clientsChangedMu so the last call carries the latest count whichever shard
+// makes it
+func (h *Hub) clientsChanged() {
+	if h.onClientsChanged == nil {
+		return
+	}
+	h.clientsChangedMu.Lock()
+	defer h.clientsChangedMu.Unlock()
+	h.onClientsChanged(h.GetClientCount())
+}
+
+// drainAbove delivers everything queued at a higher priority than p, so a lower
+// priority message never overtakes one that is already waiting
+func (h *Hub) drainAbove(p int) {
+	for higher := 0; higher < p; higher++ {
+		for {
+			select {
+			case message := <-h.broadcastQueues[higher]:
+				h.handleBroadcast(message)
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+// handleBroadcast sends one queued broadcast and updates statistics
+func (h *Hub) handleBroadcast(message broadcast) {
+	// Update statistics
+	total := h.messages.Add(1)
+	h.lastBroadcast.Store(time.Now().UnixNano())
+
+	if message.seatUpdate != nil {
+		r := h.room(message.room)
+		seq, stamped, err := stampSeatUpdate(r, message.room, message.seatUpdate)
+		if err != nil {
+			slog.Error("Failed to marshal seat update", shared.ErrAttr(err))
+			return
+		}
+		message.message = stamped
+		message.seq = seq
+		if r.replay != nil {
+			r.replay.add(seq, stamped)
+		}
+		h.notifySeatWatchers(message)
+	}
+	
+	// Send message to the clients it is for
+	h.broadcastToClients(message)
+	
+	slog.Debug("Broadcasted message", "clients", h.GetClientCount(), "total_broadcasts", total)
+}
+
+func (h *Hub) broadcastMessage(message []byte) {
+	h.broadcastWithPriority(message, PriorityNormal)
+}
+
+// broadcastWithPriority queues a message on the given priority queue
+func (h *Hub) broadcastWithPriority(message []byte, priority int) {
+	h.enqueue(broadcast{message: message}, priority)
+}
+
+// broadcastSeatUpdate queues a SEAT_UPDATE or SEAT_UPDATE_BATCH about an
+// event's seats, whose data must be a map, to be stamped with the event's
+// next broadcast sequence number as it goes out. It is sent to the clients in
+// the event's room whose filter takes in any of seats, or to all of them if
+// seats are not given.
+func (h *Hub) broadcastSeatUpdate(eventID string, msg shared.ServerMessage, priority int, seats ...shared.Seat) {
+	h.enqueue(broadcast{room: eventID, seatUpdate: &msg, seats: seats}, priority)
+}
+
+// stampSeatUpdate encodes a seat update with its event and the next broadcast
+// sequence number of the event's room. Only the hub's run loop calls it, so
+// numbers go out in order.
+func stampSeatUpdate(r *room, eventID string, msg *shared.ServerMessage) (int64, []byte, error) {
+	data, _ := msg.Data.(map[string]interface{})
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	seq := r.seq.Add(1)
+	data["broadcast_seq"] = seq
+	data["event_id"] = eventID
+	msg.Data = data
+	stamped, err := json.Marshal(msg)
+	return seq, stamped, err
+}
+
+// broadcastVenueState queues a VENUE_STATE, and the chunks that replace it
+// for chunked clients, ahead of the seat events that follow it. The venue the
+// edge caches is the default event's, so it goes to that event's room.
+func (h *Hub) broadcastVenueState(message []byte, chunks [][]byte, bitmask []byte, layout string) {
+	h.enqueue(broadcast{room: shared.DefaultEventID, message: message, chunks: chunks, bitmask: bitmask, layout: layout},
+		PriorityHigh)
+}
+
+// broadcastToRoom queues a message for the clients following an event
+func (h *Hub) broadcastToRoom(eventID string, message []byte, priority int) {
+	h.enqueue(broadcast{room: eventID, message: message}, priority)
+}
+
+func (h *Hub) enqueue(message broadcast, priority int) {
+	select {
+	case h.broadcastQueues[priority] <- message:
+		// Message queued successfully
+	default:
+		// Broadcast queue is full
+		h.dropped.Add(1)
+		if message.seatUpdate != nil {
+			// Dropped updates use up a number too, so clients see the gap
+			h.room(message.room).seq.Add(1)
+		}
+		slog.Warn("Broadcast queue full, dropping message", "priority", priority)
+	}
+}
+
+// sendWelcomeMessage sends a welcome message to a newly connected client,
+// with what the edge has cached about the event and its availability so the
+// client can show a landing view before asking for the venue. broadcast_seq
+// is the last of the client's event's seat updates its shard delivered. A
+// client that reconnected with last_event_id is told whether the seat updates
+// it missed, replay, follow.
+func (h *Hub) sendWelcomeMessage(s *hubShard, client *Client, replay [][]byte, from int64, replayed bool) {
+	now := time.Now()
+	data := map[string]interface{}{
+		"client_id":      client.id,
+		"total_clients":  h.GetClientCount(),
+		"server_time":    now.Unix(),
+		"server_time_ms": now.UnixMilli(),
+		"event_id":       client.eventID,
+		"broadcast_seq":  s.delivered[client.eventID],
+		"stream_id":      h.streamID,
+
+		"protocol_version":  client.protocolVersion.Load(),
+		"protocol_versions": protocolVersions{Min: shared.ProtocolVersionMin, Max: shared.ProtocolVersion},
+	}
+	if client.lastEventID != "" {
+		data["replay"] = welcomeReplay{Replayed: len(replay), Gone: !replayed}
+		if replayed {
+			// Replayed updates count on from the client's last one
+			data["broadcast_seq"] = from
+		}
+	}
+	if event, ok := venueCache.Event(); ok && event != nil {
+		data["event"] = event
+		data["sale"] = welcomeSale{State: event.SaleState(now), OpensAt: event.SalesOpenAt, ClosesAt: event.SalesCloseAt}
+	}
+	if counts, ok := venueCache.Counts(); ok {
+		data["availability"] = counts
+	}
+	if client.admission != "" {
+		data["admission_token"] = client.admission
+	}
+	if client.sessionToken != "" {
+		data["session_token"] = client.sessionToken
+	}
+	if challengeVerifier != nil {
+		data["challenge_required"] = true
+	}
 	welcome := map[string]interface{}{
 		"type": "WELCOME",
-		"data": map[string]interface{}{
-			"client_id":     client.id,
-			"total_clients": h.stats.TotalClients,
-			"server_time":   time.Now().Unix(),
-		},
+		"data": data,
 	}
 	
 	if welcomeJSON, err := json.Marshal(welcome); err == nil {
 		select {
 		case client.send <- welcomeJSON:
-			log.Printf("Sent welcome message to client %s", client.id)
+			slog.Debug("Sent welcome message", shared.LogKeyClientID, client.id)
 		default:
-			log.Printf("Failed to send welcome message to client %s", client.id)
+			slog.Warn("Failed to send welcome message", shared.LogKeyClientID, client.id)
+		}
+	}
+}
+
+// welcomeReplay tells a client that reconnected with last_event_id how many
+// missed seat updates follow WELCOME, or that they are gone and it must
+// fetch the venue again
+type welcomeReplay struct {
+	Replayed int  `json:"replayed"`
+	Gone     bool `json:"gone,omitempty"`
+}
+
+// welcomeSale is the sale state in WELCOME; clients count down to OpensAt
+// against server_time rather than their own clock
+type welcomeSale struct {
+	State    string `json:"state"` // upcoming, open or closed
+	OpensAt  int64  `json:"opens_at,omitempty"`
+	ClosesAt int64  `json:"closes_at,omitempty"`
+}
+
+// Shutdown disconnects every client with a close frame telling it the server
+// is restarting, after the messages already queued for it, and waits until
+// those writes finish or ctx is done
+func (h *Hub) Shutdown(ctx context.Context, reason string) error {
+	frame := websocket.FormatCloseMessage(websocket.CloseServiceRestart, reason)
+
+	h.restartFrame.Store(&frame)
+	clients := h.waitingClients()
+	h.eachClient(func(client *Client) {
+		clients = append(clients, client)
+	})
+
+	for _, client := range clients {
+		client.disconnectWith(frame)
+	}
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+
+	slog.Info("Disconnected all clients", "clients", len(clients))
+	return nil
 }
 
 // GetStats returns current hub statistics
 func (h *Hub) GetStats() HubStats {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return h.stats
+	stats := HubStats{
+		TotalClients:        h.GetClientCount(),
+		TotalMessages:       h.messages.Load(),
+		DroppedMessages:     h.dropped.Load(),
+		FilteredMessages:    h.filtered.Load(),
+		CoalescedUpdates:    h.coalesced.Load(),
+		ConnectedAt:         h.connectedAt,
+		Events:              h.eventStats(),
+		InvariantViolations: shared.InvariantViolations(),
+	}
+	if last := h.lastBroadcast.Load(); last != 0 {
+		stats.LastBroadcastTime = time.Unix(0, last)
+	}
+	return stats
+}
+
+// EventStats returns each event's connections and waiting room
+func (h *Hub) EventStats() map[string]shared.EventConnStats {
+	return h.eventStats()
 }
 
 // GetClientCount returns the current number of connected clients
 func (h *Hub) GetClientCount() int {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return len(h.clients)
+	return int(h.clientCount.Load())
 }
 
-// BroadcastToUser sends a message to clients with a specific user ID
+// UserIDs returns the distinct users with a client on this hub
+func (h *Hub) UserIDs() []string {
+	h.usersMu.RLock()
+	defer h.usersMu.RUnlock()
+
+	userIDs := make([]string, 0, len(h.users))
+	for userID := range h.users {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// setUser records the user a client subscribed as and publishes presence changes
+func (h *Hub) setUser(client *Client, userID string) {
+	h.usersMu.Lock()
+	if client.unregistered.Load() {
+		// It has gone, and stopped counting toward its user
+		h.usersMu.Unlock()
+		return
+	}
+	previous := client.userID
+	client.userID = userID
+	firstForUser := userID != "" && previous != userID && h.countUserLocked(userID, 1) == 1
+	lastForPrevious := previous != "" && previous != userID && h.countUserLocked(previous, -1) == 0
+	h.usersMu.Unlock()
+
+	if h.onPresence == nil {
+		return
+	}
+	if lastForPrevious {
+		h.onPresence(previous, false)
+	}
+	if firstForUser {
+		h.onPresence(userID, true)
+	}
+}
+
+// dropUser stops counting a client that has gone toward its user, and
+// publishes that the user went offline if it was their last client here. The
+// client must be unregistered first, so setUser cannot count it again.
+func (h *Hub) dropUser(client *Client) {
+	h.usersMu.Lock()
+	userID := client.userID
+	lastForUser := userID != "" && h.countUserLocked(userID, -1) == 0
+	h.usersMu.Unlock()
+
+	if lastForUser && h.onPresence != nil {
+		h.onPresence(userID, false)
+	}
+}
+
+// countUserLocked adds delta to the clients userID has here and returns how
+// many it has now; h.usersMu must be held
+func (h *Hub) countUserLocked(userID string, delta int) int {
+	n := h.users[userID] + delta
+	if n == 0 {
+		delete(h.users, userID)
+	} else {
+		h.users[userID] = n
+	}
+	return n
+}
+
+// BroadcastToUser sends a message to clients with a specific user ID on this
+// edge; messages published on users.<id>.msgs reach their clients on every edge
 func (h *Hub) BroadcastToUser(userID string, message []byte) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	h.usersMu.RLock()
+	defer h.usersMu.RUnlock()
 	
 	sent := 0
-	for client := range h.clients {
-		if client.userID == userID {
+	h.eachClient(func(client *Client) {
+		if client.userID == userID && client.canSend() {
 			select {
 			case client.send <- message:
 				sent++
 			default:
-				log.Printf("Failed to send message to client %s (user %s)", client.id, userID)
+				slog.Warn("Failed to send message to user", shared.LogKeyClientID, client.id, shared.LogKeyUserID, userID)
 			}
 		}
-	}
+	})
 	
 	if sent > 0 {
-		log.Printf("Sent message to %d clients for user %s", sent, userID)
+		slog.Debug("Sent message to user", shared.LogKeyUserID, userID, "clients", sent)
 	}
+}
+
+// BroadcastExcept sends a message to every client except those of the excluded users
+func (h *Hub) BroadcastExcept(excludeUsers map[string]bool, message []byte) int {
+	h.usersMu.RLock()
+	defer h.usersMu.RUnlock()
+
+	sent := 0
+	h.eachClient(func(client *Client) {
+		if excludeUsers[client.userID] || !client.canSend() {
+			return
+		}
+		select {
+		case client.send <- message:
+			sent++
+		default:
+			slog.Warn("Failed to send message to client", shared.LogKeyClientID, client.id)
+		}
+	})
+	return sent
 }
\ No newline at end of file
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sync"
+
+	"concert-booking/shared"
+)
+
+// hubShard is the share of a hub's clients, by client ID hash, that one loop
+// registers, unregisters and delivers broadcasts to. Shards run side by side,
+// so a storm of connections at a drop is spread over all of them rather than
+// queueing behind broadcasts on one loop. The shard owns its clients'
+// membership: only its loop changes who is in it and in its rooms.
+type hubShard struct {
+	register   chan *Client
+	unregister chan *Client
+
+	// Stamped broadcasts to deliver, in the order the hub's run loop sent them
+	broadcasts chan shardBroadcast
+
+	// Clients let in from their event's waiting room, to be welcomed
+	admitted chan *Client
+
+	// Work on the shard's clients that must not come between broadcasts
+	ops chan func()
+
+	// The clients the shard delivers to, and who of them is in each event's
+	// room. The loop holds mu while it changes them, or the rooms of one of
+	// its clients, or closes a client's send channel; anyone else reads them
+	// under mu.
+	mu      sync.RWMutex
+	clients map[*Client]bool
+	rooms   map[string]map[*Client]bool
+
+	// broadcast_seq of the last seat update of each event the shard delivered
+	delivered map[string]int64
+}
+
+// shardBroadcast is a broadcast for every shard, encoded once for all of them
+type shardBroadcast struct {
+	message broadcast
+	encoded *encodedBroadcast
+}
+
+// encodedBroadcast is what each kind of client is sent for a broadcast
+type encodedBroadcast struct {
+	whole, bitmask                           [][]byte
+	packedWhole, packedBitmask, packedChunks *packedMessages
+}
+
+// newHubShards returns n shards, each with its loop running
+func newHubShards(h *Hub, n int) []*hubShard {
+	shards := make([]*hubShard, n)
+	for i := range shards {
+		shards[i] = &hubShard{
+			register:   make(chan *Client),
+			unregister: make(chan *Client),
+			broadcasts: make(chan shardBroadcast, broadcastQueueSize),
+			admitted:   make(chan *Client),
+			ops:        make(chan func()),
+			clients:    make(map[*Client]bool),
+			rooms:      make(map[string]map[*Client]bool),
+			delivered:  make(map[string]int64),
+		}
+		go h.runShard(shards[i])
+	}
+	return shards
+}
+
+// shardFor returns the shard a client belongs to, by its ID
+func (h *Hub) shardFor(client *Client) *hubShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(client.id))
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
+}
+
+// registerClient hands a new client to its shard
+func (h *Hub) registerClient(client *Client) {
+	h.shardFor(client).register <- client
+}
+
+// unregisterClient hands a leaving client to its shard
+func (h *Hub) unregisterClient(client *Client) {
+	h.shardFor(client).unregister <- client
+}
+
+func (h *Hub) runShard(s *hubShard) {
+	for {
+		select {
+		case client := <-s.register:
+			if h.admitOrPark(client) {
+				continue
+			}
+			h.welcome(s, client)
+
+		case client := <-s.unregister:
+			if h.unpark(s, client) {
+				h.dropUser(client)
+				continue
+			}
+			h.remove(s, client)
+
+		case client := <-s.admitted:
+			h.welcome(s, client)
+
+		case op := <-s.ops:
+			op()
+
+		case b := <-s.broadcasts:
+			h.deliver(s, b.message, b.encoded)
+		}
+	}
+}
+
+// do runs op on the shard's loop, between broadcasts, and waits for it. It
+// must not be called from a shard's loop.
+func (s *hubShard) do(op func()) {
+	done := make(chan struct{})
+	s.ops <- func() {
+		op()
+		close(done)
+	}
+	<-done
+}
+
+// admit hands a client let in from the waiting room by another shard's loop
+// to this one, without the two loops waiting on each other
+func (s *hubShard) admit(client *Client) {
+	go func() { s.admitted <- client }()
+}
+
+// attach starts delivering to a client the hub let in, in its event's room,
+// unless it left again first. Only the shard's loop calls it.
+func (h *Hub) attach(s *hubShard, client *Client) bool {
+	if !client.added.Load() {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client] = true
+	h.clientCount.Add(1)
+	h.joinLocked(s, client, client.eventID)
+	return true
+}
+
+// detach stops delivering to a client and takes it out of its rooms. Only
+// the shard's loop calls it.
+func (h *Hub) detach(s *hubShard, client *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[client] {
+		delete(s.clients, client)
+		h.clientCount.Add(-1)
+	}
+	for eventID := range client.rooms {
+		h.leaveLocked(s, client, eventID)
+	}
+}
+
+// closeSend marks a client of the shard unregistered and closes its send
+// channel, under s.mu so nothing sending to it under s.mu finds it closed
+func (s *hubShard) closeSend(client *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client.unregistered.Store(true)
+	close(client.send)
+}
+
+// eachClient calls fn with every client the shards deliver to, holding each
+// shard's mu for reading while it goes through that shard's clients; fn may
+// send to them but not register them or move them between rooms
+func (h *Hub) eachClient(fn func(client *Client)) {
+	for _, s := range h.shards {
+		s.mu.RLock()
+		for client := range s.clients {
+			fn(client)
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// broadcastToClients queues a stamped broadcast on every shard, for each to
+// send to its clients in the broadcast's room, or to all of them. Shards
+// deliver in the order they were given broadcasts, so each client gets them
+// in order.
+func (h *Hub) broadcastToClients(message broadcast) {
+	whole := [][]byte{message.message}
+	bitmask := [][]byte{message.bitmask}
+	encoded := &encodedBroadcast{
+		whole:         whole,
+		bitmask:       bitmask,
+		packedWhole:   packLater(whole),
+		packedBitmask: packLater(bitmask),
+		packedChunks:  packLater(message.chunks),
+	}
+	for _, s := range h.shards {
+		s.broadcasts <- shardBroadcast{message: message, encoded: encoded}
+	}
+}
+
+// deliver sends a broadcast to the shard's clients it is for. Only the
+// shard's loop calls it.
+func (h *Hub) deliver(s *hubShard, message broadcast, encoded *encodedBroadcast) {
+	if message.seatUpdate != nil {
+		s.delivered[message.room] = message.seq
+	}
+
+	clients := s.clients
+	if message.room != "" {
+		clients = s.rooms[message.room]
+	}
+	for client := range clients {
+		if client == message.from {
+			continue
+		}
+		messages, packed := encoded.whole, encoded.packedWhole
+		if message.bitmask != nil && client.hasLayout(message.layout) {
+			messages, packed = encoded.bitmask, encoded.packedBitmask
+		} else if message.chunks != nil && client.chunkedVenue.Load() {
+			messages, packed = message.chunks, encoded.packedChunks
+		}
+		if client.msgpack {
+			messages = packed.get()
+		}
+		if !client.canSend() {
+			continue
+		}
+		if !client.wants(message) {
+			h.filtered.Add(1)
+			continue
+		}
+		for _, m := range messages {
+			select {
+			case client.send <- m:
+				// Message sent successfully
+				continue
+			default:
+			}
+			// Client's send channel is full, close it
+			slog.Warn("Client send buffer full, disconnecting", shared.LogKeyClientID, client.id)
+			go h.unregisterClient(client)
+			break
+		}
+	}
+}
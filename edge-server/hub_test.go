@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+// addClient puts client on h and its shard as registering it would, in its
+// event's room, without sending it a welcome
+func addClient(h *Hub, client *Client) {
+	if client.eventID == "" {
+		client.eventID = shared.DefaultEventID
+	}
+	h.addLocked(h.slots(client.eventID), client)
+	s := h.shardFor(client)
+	s.do(func() { h.attach(s, client) })
+}
+
+func TestHubDrainsHigherPrioritiesFirst(t *testing.T) {
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-priority"}
+	addClient(h, client)
+
+	// Queue everything before the hub starts so all queues back up at once
+	h.broadcastWithPriority([]byte("held-1"), PriorityLow)
+	h.broadcastWithPriority([]byte("other-1"), PriorityNormal)
+	h.broadcastWithPriority([]byte("booked-1"), PriorityHigh)
+	h.broadcastWithPriority([]byte("held-2"), PriorityLow)
+	h.broadcastWithPriority([]byte("released-1"), PriorityHigh)
+
+	go h.run()
+
+	want := []string{"booked-1", "released-1", "other-1", "held-1", "held-2"}
+	for i, w := range want {
+		select {
+		case got := <-client.send:
+			if string(got) != w {
+				t.Fatalf("message %d = %q, want %q", i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+}
+
+func TestBroadcastDropsWhenQueueFull(t *testing.T) {
+	h := newHub()
+
+	for i := 0; i < broadcastQueueSize+3; i++ {
+		h.broadcastWithPriority([]byte("held"), PriorityLow)
+	}
+
+	if got := h.GetStats().DroppedMessages; got != 3 {
+		t.Fatalf("DroppedMessages = %d, want 3", got)
+	}
+}
+
+func TestSeatUpdatesAreNumberedWithGapsWhereDropped(t *testing.T) {
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, broadcastQueueSize+8), id: "client-seq"}
+	addClient(h, client)
+
+	update := func(seatID string) shared.ServerMessage {
+		return shared.ServerMessage{Type: shared.MessageTypeSeatUpdate, Data: map[string]interface{}{"seat_id": seatID}}
+	}
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("A1"), PriorityLow)
+	for i := 1; i < broadcastQueueSize; i++ {
+		h.broadcastWithPriority([]byte("held"), PriorityLow)
+	}
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("A2"), PriorityLow) // dropped: the queue is full
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("A3"), PriorityHigh)
+	go h.run()
+
+	seqs := map[string]float64{}
+	for len(seqs) < 2 {
+		select {
+		case raw := <-client.send:
+			var msg shared.ServerMessage
+			if json.Unmarshal(raw, &msg) == nil && msg.Type == shared.MessageTypeSeatUpdate {
+				data := msg.Data.(map[string]interface{})
+				seqs[data["seat_id"].(string)] = data["broadcast_seq"].(float64)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for seat updates, got %v", seqs)
+		}
+	}
+
+	// Numbers follow delivery order, and the dropped update used up 1, so
+	// clients see it missing
+	if seqs["A3"] != 2 || seqs["A1"] != 3 {
+		t.Fatalf("broadcast_seq = %v, want A3 at 2 and A1 at 3", seqs)
+	}
+}
+
+func TestPresenceAnnouncedForFirstAndLastClientOfUser(t *testing.T) {
+	h := newHub()
+	events := make(chan string, 8)
+	h.onPresence = func(userID string, online bool) {
+		if online {
+			events <- "online:" + userID
+		} else {
+			events <- "offline:" + userID
+		}
+	}
+	go h.run()
+
+	first := &Client{hub: h, send: make(chan []byte, 16), id: "client-1"}
+	second := &Client{hub: h, send: make(chan []byte, 16), id: "client-2"}
+	h.registerClient(first)
+	h.registerClient(second)
+	h.setUser(first, "user-1")
+	h.setUser(second, "user-1")
+
+	h.unregisterClient(first)
+	h.unregisterClient(second)
+
+	want := []string{"online:user-1", "offline:user-1"}
+	for i, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Fatalf("presence event %d = %q, want %q", i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for presence event %d", i)
+		}
+	}
+	select {
+	case extra := <-events:
+		t.Fatalf("unexpected presence event %q", extra)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMarketingAnnouncementsSkipOptedOutUsers(t *testing.T) {
+	h := newHub()
+	clients := map[string]*Client{}
+	for _, userID := range []string{"alice", "bob", ""} {
+		client := &Client{hub: h, send: make(chan []byte, 16), id: "client-" + userID, userID: userID}
+		addClient(h, client)
+		clients[userID] = client
+	}
+	saved := hub
+	hub = h
+	t.Cleanup(func() { hub = saved })
+
+	announcementJSON, _ := json.Marshal(shared.Announcement{Message: "Presale opens Friday", Marketing: true, ExcludeUsers: []string{"bob"}})
+	handleAnnouncement(&nats.Msg{Data: announcementJSON})
+
+	for userID, client := range clients {
+		select {
+		case message := <-client.send:
+			if userID == "bob" {
+				t.Errorf("opted-out user got %s", message)
+			} else if !strings.Contains(string(message), `"type":"ANNOUNCEMENT"`) || !strings.Contains(string(message), "Presale opens Friday") {
+				t.Errorf("client %q got %s, want the announcement", userID, message)
+			}
+		default:
+			if userID != "bob" {
+				t.Errorf("client %q got no announcement", userID)
+			}
+		}
+	}
+}
+
+func TestShutdownFlushesQueuedMessagesThenAsksClientsToReconnect(t *testing.T) {
+	th := newTestHarness(t)
+	client, conn := th.connect("client-restart", 16)
+	eventually(t, func() bool { return len(conn.messages(t)) > 0 }, "no welcome message")
+
+	// Slow the connection down so the messages are still queued at shutdown
+	conn.mu.Lock()
+	conn.writeDelay = 20 * time.Millisecond
+	conn.mu.Unlock()
+	for _, msgType := range []string{"A", "B", "C"} {
+		client.sendMessage(msgType, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := th.hub.Shutdown(ctx, shutdownCloseReason); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	var got []string
+	for _, msg := range conn.messages(t) {
+		got = append(got, msg.Type)
+	}
+	if strings.Join(got, ",") != "WELCOME,A,B,C" {
+		t.Errorf("messages before close = %v, want WELCOME then A, B, C", got)
+	}
+
+	types, frames := conn.frames()
+	last := len(types) - 1
+	if types[last] != websocket.CloseMessage {
+		t.Fatalf("last frame type = %d, want close", types[last])
+	}
+	want := websocket.FormatCloseMessage(websocket.CloseServiceRestart, shutdownCloseReason)
+	if string(frames[last]) != string(want) {
+		t.Errorf("close frame = %q, want %q", frames[last], want)
+	}
+
+	// Connections that race the shutdown are turned away the same way
+	_, late := th.connect("client-late", 16)
+	eventually(t, late.isClosed, "client registered after shutdown was not disconnected")
+}
+
+// BenchmarkHubFanOut measures one seat update going out to every client:
+// stamping, encoding once, and a send per client
+func BenchmarkHubFanOut(b *testing.B) {
+	for _, clients := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			h := newHub()
+			all := make([]*Client, clients)
+			for i := range all {
+				all[i] = &Client{hub: h, send: make(chan []byte, 64), id: fmt.Sprintf("client-%d", i)}
+				addClient(h, all[i])
+			}
+			update := shared.ServerMessage{Type: shared.MessageTypeSeatUpdate, Data: map[string]interface{}{
+				"seat_id": "A1", "status": "held", "user_id": "user-1", "version": 2, "venue_version": 42,
+			}}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				msg := update
+				h.handleBroadcast(broadcast{seatUpdate: &msg})
+				if i%64 == 63 {
+					// Let the shards deliver, then stand in for the write
+					// pumps before the buffers fill
+					for _, client := range all {
+						for len(client.send) < 64 {
+							runtime.Gosched()
+						}
+					}
+					b.StopTimer()
+					for _, client := range all {
+						for len(client.send) > 0 {
+							<-client.send
+						}
+					}
+					b.StartTimer()
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*clients), "ns/delivery")
+		})
+	}
+}
+
+func TestBroadcastsReachEveryShardInOrder(t *testing.T) {
+	h := newHub()
+	h.shards = newHubShards(h, 4)
+	clients := make([]*Client, 64)
+	for i := range clients {
+		clients[i] = &Client{hub: h, send: make(chan []byte, 16), id: fmt.Sprintf("client-%d", i)}
+		addClient(h, clients[i])
+	}
+	for _, shard := range h.shards {
+		var n int
+		shard.do(func() { n = len(shard.clients) })
+		if n == 0 {
+			t.Fatal("a shard was left without clients")
+		}
+	}
+
+	for _, message := range []string{"first", "second", "third"} {
+		h.broadcastToClients(broadcast{message: []byte(message)})
+	}
+	for _, client := range clients {
+		for _, want := range []string{"first", "second", "third"} {
+			if got := <-client.send; string(got) != want {
+				t.Fatalf("%s got %q, want %q", client.id, got, want)
+			}
+		}
+	}
+}
+
+func TestShardsRegisterAndUnregisterSideBySide(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.shards = newHubShards(th.hub, 4)
+
+	clients := make([]*Client, 64)
+	var wg sync.WaitGroup
+	for i := range clients {
+		clients[i] = newClient(th.hub, newFakeConn(), fmt.Sprintf("client-%d", i), 16)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.hub.registerClient(clients[i])
+		}()
+	}
+	wg.Wait()
+	eventually(t, func() bool { return th.hub.GetClientCount() == len(clients) }, "clients were not all registered")
+	if viewers := th.hub.viewerCounts()[shared.DefaultEventID].Viewers; viewers != len(clients) {
+		t.Errorf("viewers = %d, want %d", viewers, len(clients))
+	}
+
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.hub.unregisterClient(client)
+		}()
+	}
+	wg.Wait()
+	eventually(t, func() bool { return th.hub.GetClientCount() == 0 }, "clients were not all unregistered")
+	if stats := th.hub.EventStats()[shared.DefaultEventID]; stats.Connected != 0 {
+		t.Errorf("connected = %d after everyone left, want 0", stats.Connected)
+	}
+	th.hub.mu.RLock()
+	_, kept := th.hub.rooms[shared.DefaultEventID]
+	th.hub.mu.RUnlock()
+	if kept {
+		t.Error("the event's room was kept after everyone left before any seat update")
+	}
+}
+
+func TestUserCountsFollowSubscribesAndDepartures(t *testing.T) {
+	h := newHub()
+	var presence []string
+	h.onPresence = func(userID string, online bool) {
+		presence = append(presence, fmt.Sprintf("%s:%v", userID, online))
+	}
+	first := &Client{hub: h, send: make(chan []byte, 16), id: "client-1"}
+	second := &Client{hub: h, send: make(chan []byte, 16), id: "client-2"}
+	addClient(h, first)
+	addClient(h, second)
+
+	h.setUser(first, "user-1")
+	h.setUser(second, "user-1")
+	h.setUser(first, "user-1")
+	h.setUser(first, "user-2")
+	if got := h.UserIDs(); len(got) != 2 {
+		t.Errorf("UserIDs = %v, want user-1 and user-2", got)
+	}
+
+	s := h.shardFor(second)
+	s.do(func() { h.remove(s, second) })
+	// A client that has gone no longer counts, even if it subscribes late
+	h.setUser(second, "user-3")
+	if got := h.UserIDs(); len(got) != 1 || got[0] != "user-2" {
+		t.Errorf("UserIDs = %v, want user-2", got)
+	}
+
+	want := []string{"user-1:true", "user-2:true", "user-1:false"}
+	if strings.Join(presence, " ") != strings.Join(want, " ") {
+		t.Errorf("presence = %v, want %v", presence, want)
+	}
+}
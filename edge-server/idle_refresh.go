@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// How long a subscribed client may sit idle while seat updates flow before the
+// edge resends its seat map, unless EDGE_IDLE_REFRESH_AFTER says otherwise
+const defaultIdleRefreshAfter = 2 * time.Minute
+
+// idleRefreshAfterFromEnv reads EDGE_IDLE_REFRESH_AFTER; 0 disables idle refreshes
+func idleRefreshAfterFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_IDLE_REFRESH_AFTER")
+	if raw == "" {
+		return defaultIdleRefreshAfter, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// startIdleRefresh periodically sends a VENUE_SNAPSHOT to clients that have
+// been idle for longer than after while seats kept changing. A backgrounded tab
+// or a dropped update can leave their map showing seats sold minutes ago; the
+// snapshot corrects it before they click one.
+func startIdleRefresh(h *Hub, after time.Duration) {
+	if after <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(after / 4)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.refreshIdleClients(now, after)
+		}
+	}()
+	slog.Info("Idle clients get a fresh seat map", "after", after)
+}
+
+// needsIdleRefresh reports whether the client has sat idle, and unrefreshed,
+// for longer than after while seat updates it has not acted on went out
+func (c *Client) needsIdleRefresh(now time.Time, after time.Duration) bool {
+	if !c.subscribed.Load() || c.hub.seatEvents.Load() == c.seenEvents.Load() {
+		return false
+	}
+	last := max(c.lastActivity.Load(), c.refreshedAt.Load())
+	return now.UnixNano()-last > int64(after)
+}
+
+// refreshIdleClients sends one venue snapshot to every client needing an idle
+// refresh and returns how many got it
+func (h *Hub) refreshIdleClients(now time.Time, after time.Duration) int {
+	stale := false
+	h.eachClient(func(client *Client) {
+		stale = stale || client.needsIdleRefresh(now, after)
+	})
+	if !stale {
+		return 0
+	}
+
+	// Count before fetching, so updates racing the fetch still count as unseen
+	events := h.seatEvents.Load()
+	seats, seq, err := venueSnapshot()
+	if err != nil {
+		slog.Warn("Failed to fetch venue for idle clients", shared.ErrAttr(err))
+		return 0
+	}
+	snapshotJSON, err := json.Marshal(shared.ServerMessage{
+		Type: shared.MessageTypeVenueSnapshot,
+		Data: compactVenueState(seats, seq, "idle"),
+	})
+	if err != nil {
+		slog.Error("Failed to marshal venue snapshot", shared.ErrAttr(err))
+		return 0
+	}
+
+	refreshed := 0
+	h.eachClient(func(client *Client) {
+		if !client.needsIdleRefresh(now, after) {
+			return
+		}
+		select {
+		case client.send <- snapshotJSON:
+			client.refreshedAt.Store(now.UnixNano())
+			client.seenEvents.Store(events)
+			refreshed++
+		default:
+			// Its buffer is full; try again on the next tick
+		}
+	})
+	slog.Debug("Refreshed idle clients", "clients", refreshed, "seq", seq)
+	return refreshed
+}
+
+// compactVenueState strips seats down to the fields that change
+func compactVenueState(seats []shared.Seat, seq int64, reason string) shared.CompactVenueState {
+	state := shared.CompactVenueState{Seq: seq, Reason: reason, Seats: make([]shared.CompactSeat, len(seats))}
+	for i, seat := range seats {
+		state.Seats[i] = shared.CompactSeat{
+			ID:        seat.ID,
+			Status:    seat.Status,
+			HeldBy:    seat.HeldBy,
+			ExpiresAt: seat.ExpiresAt,
+			Version:   seat.Version,
+		}
+	}
+	return state
+}
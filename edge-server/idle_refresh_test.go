@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestIdleClientsGetAVenueSnapshotOnceSeatsChange(t *testing.T) {
+	th := newTestHarness(t)
+	const after = time.Minute
+
+	subscribe := func(id string) *fakeConn {
+		_, conn := th.connect(id, 16)
+		conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": id})
+		eventually(t, func() bool {
+			_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+			return err == nil
+		}, "expected VENUE_STATE after SUBSCRIBE")
+		return conn
+	}
+	idle := subscribe("client-idle")
+	_, unsubscribed := th.connect("client-unsubscribed", 16)
+
+	later := time.Now().Add(after + time.Second)
+	if n := th.hub.refreshIdleClients(later, after); n != 0 {
+		t.Fatalf("refreshed %d clients with no seat updates since they acted, want 0", n)
+	}
+
+	th.hub.seatEvents.Add(3)
+	active := subscribe("client-active") // acted after the updates
+	if n := th.hub.refreshIdleClients(later, after); n != 1 {
+		t.Fatalf("refreshed %d clients, want only the idle subscribed one", n)
+	}
+	eventually(t, func() bool {
+		_, err := findMessage(idle.messages(t), shared.MessageTypeVenueSnapshot)
+		return err == nil
+	}, "idle client got no VENUE_SNAPSHOT")
+
+	msg, _ := findMessage(idle.messages(t), shared.MessageTypeVenueSnapshot)
+	raw, _ := json.Marshal(msg.Data)
+	var snapshot shared.CompactVenueState
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if snapshot.Reason != "idle" || len(snapshot.Seats) != 1 || snapshot.Seats[0].ID != "A1" {
+		t.Errorf("snapshot = %+v, want the idle refresh of A1", snapshot)
+	}
+	for _, conn := range []*fakeConn{active, unsubscribed} {
+		if _, err := findMessage(conn.messages(t), shared.MessageTypeVenueSnapshot); err == nil {
+			t.Error("a client that is active or never subscribed got a VENUE_SNAPSHOT")
+		}
+	}
+
+	// Once refreshed, a client waits for more updates and another idle period
+	if n := th.hub.refreshIdleClients(later, after); n != 0 {
+		t.Errorf("refreshed %d clients again straight away, want 0", n)
+	}
+	// By then the active client has gone idle too
+	th.hub.seatEvents.Add(1)
+	if n := th.hub.refreshIdleClients(later.Add(after/2), after); n != 1 {
+		t.Errorf("refreshed %d clients half an idle period later, want only the formerly active one", n)
+	}
+	if n := th.hub.refreshIdleClients(later.Add(after+time.Second), after); n != 1 {
+		t.Errorf("refreshed %d clients after another idle period, want only the first idle one", n)
+	}
+}
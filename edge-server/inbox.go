@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Seat events reach every edge through each instance's own subscription to
+// seats.*.*.*.*, never a queue group, since every edge must fan them out to its
+// clients. Messages for a single user likewise reach every edge on
+// users.<id>.msgs, and each delivers them to whichever of the user's clients
+// it hosts, so they arrive whatever edge the user is on. The edge's own inbox
+// takes them from booking services that still route by presence.
+
+// startInbox subscribes to messages for users and this edge's inbox, and
+// announces user presence
+func startInbox(nc *nats.Conn, edgeID string) error {
+	inbox := fmt.Sprintf(shared.NATSSubjectEdgeInbox, edgeID)
+	if _, err := nc.Subscribe(inbox, handleDirectedMessage); err != nil {
+		return err
+	}
+
+	if _, err := nc.Subscribe(shared.NATSTopicUserMsgs, handleDirectedMessage); err != nil {
+		return err
+	}
+
+	if _, err := nc.Subscribe(shared.NATSTopicAnnouncements, handleAnnouncement); err != nil {
+		return err
+	}
+
+	// A restarted router asks every edge to re-announce who it hosts
+	if _, err := nc.Subscribe(shared.NATSTopicPresenceSync, func(msg *nats.Msg) {
+		userIDs := hub.UserIDs()
+		for _, userID := range userIDs {
+			publishPresence(nc, edgeID, userID, true)
+		}
+		slog.Info("Re-announced users for presence sync", shared.LogKeyComponent, "nats", "users", len(userIDs))
+	}); err != nil {
+		return err
+	}
+
+	hub.onPresence = func(userID string, online bool) {
+		publishPresence(nc, edgeID, userID, online)
+	}
+
+	slog.Info("Subscribed to inbox", shared.LogKeyComponent, "nats", "subject", inbox, "users", shared.NATSTopicUserMsgs)
+	return nil
+}
+
+// handleDirectedMessage delivers a message for a user to their clients on this
+// edge, if it has any
+func handleDirectedMessage(msg *nats.Msg) {
+	var directed shared.DirectedMessage
+	if err := json.Unmarshal(msg.Data, &directed); err != nil {
+		slog.Error("Failed to parse inbox message", shared.ErrAttr(err))
+		return
+	}
+
+	wsMessageJSON, err := json.Marshal(shared.ServerMessage{Type: directed.Type, Data: directed.Data})
+	if err != nil {
+		slog.Error("Failed to marshal inbox message", shared.ErrAttr(err))
+		return
+	}
+
+	hub.BroadcastToUser(directed.UserID, wsMessageJSON)
+}
+
+// handleAnnouncement delivers an announcement to this edge's clients, leaving
+// out the users it excludes
+func handleAnnouncement(msg *nats.Msg) {
+	var announcement shared.Announcement
+	if err := json.Unmarshal(msg.Data, &announcement); err != nil {
+		slog.Error("Failed to parse announcement", shared.ErrAttr(err))
+		return
+	}
+
+	wsMessageJSON, err := json.Marshal(shared.ServerMessage{Type: shared.MessageTypeAnnouncement, Data: map[string]interface{}{
+		"message":   announcement.Message,
+		"marketing": announcement.Marketing,
+		"timestamp": announcement.Timestamp,
+	}})
+	if err != nil {
+		slog.Error("Failed to marshal announcement", shared.ErrAttr(err))
+		return
+	}
+
+	exclude := make(map[string]bool, len(announcement.ExcludeUsers))
+	for _, userID := range announcement.ExcludeUsers {
+		exclude[userID] = true
+	}
+	sent := hub.BroadcastExcept(exclude, wsMessageJSON)
+	slog.Info("Delivered announcement", "clients", sent, "marketing", announcement.Marketing)
+}
+
+func publishPresence(nc *nats.Conn, edgeID, userID string, online bool) {
+	presenceJSON, err := json.Marshal(shared.UserPresence{
+		UserID:    userID,
+		EdgeID:    edgeID,
+		Online:    online,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		slog.Error("Failed to marshal presence", shared.ErrAttr(err))
+		return
+	}
+	if err := nc.Publish(shared.NATSTopicUserPresence, presenceJSON); err != nil {
+		slog.Warn("Failed to publish presence", shared.LogKeyUserID, userID, shared.ErrAttr(err))
+	}
+}
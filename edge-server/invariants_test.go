@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestMessagesForAnUnregisteredClientAreDroppedAndReported(t *testing.T) {
+	th := newTestHarness(t)
+	client, _ := th.connect("client-gone", 8)
+	th.hub.unregisterClient(client)
+	eventually(t, func() bool { return !th.isRegistered(client) }, "client was not unregistered")
+
+	t.Setenv("INVARIANT_CHECKS", "true")
+	if err := shared.InitInvariantChecks("edge-server"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Unsetenv("INVARIANT_CHECKS")
+		shared.InitInvariantChecks("edge-server")
+	})
+
+	before := shared.InvariantViolations()
+	client.sendMessage(shared.MessageTypeSeatUpdate, nil) // would panic on the closed channel
+	if shared.InvariantViolations() != before+1 {
+		t.Error("a message queued after unregistering was not reported")
+	}
+	if stats := th.hub.GetStats(); stats.InvariantViolations == 0 {
+		t.Error("hub stats do not count invariant violations")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+// Reason kicked connections are closed with unless the operator gives one
+const defaultKickReason = "disconnected by an operator"
+
+// A close frame's reason may take 123 bytes, after the 2 of its code
+const maxCloseReason = 123
+
+// Kick disconnects every connection of userID on this edge, waiting ones
+// included, with close code CloseCodeKicked and reason, and returns how many
+// it disconnected. Connections belong to a user once they subscribed as it,
+// or from the start with a session token for it. Nothing stops the user from
+// connecting again.
+func (h *Hub) Kick(userID, reason string) int {
+	if reason == "" {
+		reason = defaultKickReason
+	}
+	if len(reason) > maxCloseReason {
+		reason = strings.ToValidUTF8(reason[:maxCloseReason], "")
+	}
+	frame := websocket.FormatCloseMessage(shared.CloseCodeKicked, reason)
+
+	var kicked []*Client
+	for _, client := range h.waitingClients() {
+		if client.sessionUserID == userID {
+			kicked = append(kicked, client)
+		}
+	}
+	h.usersMu.RLock()
+	h.eachClient(func(client *Client) {
+		if client.userID == userID || client.sessionUserID == userID {
+			kicked = append(kicked, client)
+		}
+	})
+	h.usersMu.RUnlock()
+
+	for _, client := range kicked {
+		client.disconnectWith(frame)
+	}
+	if len(kicked) > 0 {
+		slog.Info("Kicked user", shared.LogKeyUserID, userID, "connections", len(kicked), "reason", reason)
+	}
+	return len(kicked)
+}
+
+// handleKickUser disconnects a user's connections on every edge, or only on
+// this one without NATS
+func (c *Client) handleKickUser(msg *shared.ClientMessage) {
+	var data shared.KickUserPayload
+	if !c.decodePayload(msg, "KICK_USER_RESPONSE", &data) {
+		return
+	}
+	userID, reason := data.UserID, data.Reason
+	kick := shared.KickUser{UserID: userID, Reason: reason, Timestamp: time.Now()}
+
+	c.logger(context.Background()).Warn("Admin kicked user", shared.LogKeyComponent, "admin", "kicked_user_id", userID, "reason", reason)
+	if c.hub.onKick != nil {
+		c.hub.onKick(kick)
+		c.sendOperationResponse(msg, "KICK_USER_RESPONSE", true, fmt.Sprintf("Disconnecting %s on every edge", userID),
+			map[string]interface{}{"user_id": userID})
+		return
+	}
+	kicked := c.hub.Kick(userID, reason)
+	c.sendOperationResponse(msg, "KICK_USER_RESPONSE", true, fmt.Sprintf("Disconnected %d connections of %s", kicked, userID),
+		map[string]interface{}{"user_id": userID, "connections": kicked})
+}
+
+// startKicks applies kicks published by the booking service's admin API or
+// by admin connections on any edge, and publishes this edge's
+func startKicks(nc *nats.Conn) error {
+	if _, err := nc.Subscribe(shared.NATSTopicKickUser, func(msg *nats.Msg) {
+		var kick shared.KickUser
+		if err := json.Unmarshal(msg.Data, &kick); err != nil || kick.UserID == "" {
+			slog.Error("Failed to parse kick", shared.ErrAttr(err))
+			return
+		}
+		hub.Kick(kick.UserID, kick.Reason)
+	}); err != nil {
+		return err
+	}
+
+	hub.onKick = func(kick shared.KickUser) {
+		kickJSON, err := json.Marshal(kick)
+		if err != nil {
+			slog.Error("Failed to marshal kick", shared.ErrAttr(err))
+			return
+		}
+		if err := nc.Publish(shared.NATSTopicKickUser, kickJSON); err != nil {
+			slog.Warn("Failed to publish kick, kicking here only", shared.LogKeyUserID, kick.UserID, shared.ErrAttr(err))
+			hub.Kick(kick.UserID, kick.Reason)
+		}
+	}
+	return nil
+}
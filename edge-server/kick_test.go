@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeFrame returns the close frame the server ended conn with, or nil
+func closeFrame(conn *fakeConn) []byte {
+	types, frames := conn.frames()
+	for i, messageType := range types {
+		if messageType == websocket.CloseMessage {
+			return frames[i]
+		}
+	}
+	return nil
+}
+
+func TestKickUserClosesEveryConnectionOfTheUser(t *testing.T) {
+	th := newTestHarness(t)
+
+	// One connection is the user's by its token, the other by subscribing
+	_, tokenConn := th.connectWithToken("client-token", 16, tokenClaims{UserID: "bot-1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	_, subscribedConn := th.connect("client-subscribed", 16)
+	subscribedConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "bot-1"})
+	_, humanConn := th.connect("client-human", 16)
+	humanConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "human"})
+	eventually(t, func() bool {
+		_, bot := findMessage(subscribedConn.messages(t), "SUBSCRIBE_ACK")
+		_, human := findMessage(humanConn.messages(t), "SUBSCRIBE_ACK")
+		return bot == nil && human == nil
+	}, "clients did not subscribe")
+
+	_, buyerConn := th.connect("client-buyer", 16)
+	buyerConn.sendJSON(t, shared.MessageTypeKickUser, map[string]interface{}{"user_id": "bot-1"})
+	eventually(t, func() bool {
+		_, err := findMessage(buyerConn.messages(t), shared.MessageTypeError)
+		return err == nil
+	}, "a buyer's KICK_USER was not refused")
+
+	_, adminConn := th.connectAs("client-admin", 16, PermissionAdmin)
+	adminConn.sendJSON(t, shared.MessageTypeKickUser, map[string]interface{}{"user_id": "bot-1", "reason": "automated traffic"})
+	eventually(t, func() bool {
+		return closeFrame(tokenConn) != nil && closeFrame(subscribedConn) != nil
+	}, "the user's connections were not closed")
+
+	want := websocket.FormatCloseMessage(shared.CloseCodeKicked, "automated traffic")
+	for name, conn := range map[string]*fakeConn{"token": tokenConn, "subscribed": subscribedConn} {
+		if got := closeFrame(conn); string(got) != string(want) {
+			t.Errorf("%s connection closed with %q, want %q", name, got, want)
+		}
+	}
+	if closeFrame(humanConn) != nil {
+		t.Error("another user's connection was closed")
+	}
+	resp, err := findMessage(adminConn.messages(t), "KICK_USER_RESPONSE")
+	if err != nil {
+		t.Fatalf("no KICK_USER_RESPONSE: %v", err)
+	}
+	if data, _ := resp.Data.(map[string]interface{})["data"].(map[string]interface{}); data["connections"] != float64(2) {
+		t.Errorf("KICK_USER_RESPONSE = %v, want 2 connections", resp.Data)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"concert-booking/shared"
+)
+
+// logger returns ctx's logger tagged with the connection and, once it has
+// subscribed, its user
+func (c *Client) logger(ctx context.Context) *slog.Logger {
+	logger := shared.Logger(ctx).With(shared.LogKeyClientID, c.id)
+	if userID := c.user(); userID != "" {
+		logger = logger.With(shared.LogKeyUserID, userID)
+	}
+	return logger
+}
+
+// seatLogger returns ctx's logger tagged with the connection and the seat and
+// user a seat message is for
+func (c *Client) seatLogger(ctx context.Context, seatID, userID string) *slog.Logger {
+	return shared.Logger(ctx).With(shared.LogKeyClientID, c.id, shared.LogKeySeatID, seatID, shared.LogKeyUserID, userID)
+}
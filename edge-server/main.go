@@ -1,9 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +13,7 @@ import (
 
 	"concert-booking/shared"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/nats-io/nats.go"
 )
@@ -19,8 +21,13 @@ import (
 var (
 	natsConn       *nats.Conn
 	hub            *Hub
-	bookingClient  *BookingClient
+	bookingClient  BookingService
+	sequencer      *EventSequencer
+	coalescer      *seatCoalescer
+	edgeRegistry   *EdgeRegistry
 	upgrader = websocket.Upgrader{
+		// JSON unless the client offers only msgpack
+		Subprotocols: []string{shared.SubprotocolJSON, shared.SubprotocolMsgpack},
 		CheckOrigin: func(r *http.Request) bool {
 			// Allow connections from any origin for development
 			// In production, this should be more restrictive
@@ -38,55 +45,266 @@ func main() {
 		port = ":" + port
 	}
 
-	log.Printf("Starting edge server on port %s...", port)
+	if err := shared.InitLogging("edge-server"); err != nil {
+		shared.Fatal("Failed to set up logging", shared.ErrAttr(err))
+	}
+	if err := shared.InitInvariantChecks("edge-server"); err != nil {
+		shared.Fatal("Invalid invariant checks", shared.ErrAttr(err))
+	}
+	if err := shared.InitFaults(); err != nil {
+		shared.Fatal("Invalid fault injection", shared.ErrAttr(err))
+	}
+	slog.Info("Starting edge server...", "port", port)
+
+	// Trace seat messages through to the booking service when an OTLP endpoint is set
+	shutdownTracing, err := shared.InitTracing("edge-server")
+	if err != nil {
+		shared.Fatal("Failed to set up tracing", shared.ErrAttr(err))
+	}
 
-	// Connect to NATS
-	if err := connectNATS(); err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+	// Connect to NATS, which the Redis event bus does without
+	if shared.EventBusKind() != shared.EventBusRedis {
+		if err := connectNATS(); err != nil {
+			shared.Fatal("Failed to connect to NATS", shared.ErrAttr(err))
+		}
+		defer natsConn.Close()
+		slog.Info("Connected to NATS")
+		checkNATS(natsConn)
 	}
-	defer natsConn.Close()
-	log.Println("Connected to NATS")
+	subscribed := readiness.Gate("subscriptions")
+	venueFetched := readiness.Gate("venue")
 
 	// Initialize booking client
 	bookingServiceURL := os.Getenv("BOOKING_SERVICE_URL")
 	if bookingServiceURL == "" {
 		bookingServiceURL = "http://localhost:8080"
 	}
-	bookingClient = NewBookingClient(bookingServiceURL)
-	log.Printf("Booking client initialized with URL: %s", bookingServiceURL)
+	switch transport := os.Getenv("BOOKING_TRANSPORT"); transport {
+	case "nats":
+		if natsConn == nil {
+			shared.Fatal("BOOKING_TRANSPORT=nats requires NATS, which EVENT_BUS=redis does not connect")
+		}
+		bookingClient = NewNATSBookingClient(natsConn)
+		slog.Info("Booking client initialized over NATS request-reply")
+	case "", "http":
+		client := NewBookingClient(bookingServiceURL)
+		if client.retry, err = retryPolicyFromEnv(); err != nil {
+			shared.Fatal("Invalid booking client retry policy", shared.ErrAttr(err))
+		}
+		bookingClient = client
+		slog.Info("Booking client initialized", "url", bookingServiceURL, "attempts", client.retry.Attempts)
+	default:
+		shared.Fatal("Unknown BOOKING_TRANSPORT (want http or nats)", "transport", transport)
+	}
+
+	// Require signed session tokens when a secret is configured
+	authSecret = []byte(os.Getenv("EDGE_AUTH_SECRET"))
+	if len(authSecret) > 0 {
+		slog.Info("Token authentication enabled")
+	}
 
 	// Initialize hub
 	hub = newHub()
+	hub.caps, err = eventCapsFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid EDGE_EVENT_CONN_CAP", shared.ErrAttr(err))
+	}
+	if hub.admissionGrace, err = admissionGraceFromEnv(); err != nil {
+		shared.Fatal("Invalid EDGE_ADMISSION_GRACE", shared.ErrAttr(err))
+	}
+	if hub.sessionGrace, err = sessionGraceFromEnv(); err != nil {
+		shared.Fatal("Invalid EDGE_SESSION_GRACE", shared.ErrAttr(err))
+	}
+	replayBuffer, err := replayBufferFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid EDGE_REPLAY_BUFFER", shared.ErrAttr(err))
+	}
+	hub.replaySize = replayBuffer
+	if hub.ipConns, hub.userConns, err = connLimitsFromEnv(); err != nil {
+		shared.Fatal("Invalid connection limits", shared.ErrAttr(err))
+	}
+	clientIPHeader = os.Getenv("EDGE_CLIENT_IP_HEADER")
+	if challengeVerifier, err = challengeVerifierFromEnv(); err != nil {
+		shared.Fatal("Invalid bot challenge settings", shared.ErrAttr(err))
+	}
+	if challengeVerifier != nil {
+		slog.Info("Bot challenge required before selecting seats")
+	}
 	go hub.run()
-	log.Println("Hub initialized and running")
+	slog.Info("Hub initialized and running")
+
+	// Resend the seat map to clients left idle while seats changed
+	idleRefreshAfter, err := idleRefreshAfterFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid EDGE_IDLE_REFRESH_AFTER", shared.ErrAttr(err))
+	}
+	startIdleRefresh(hub, idleRefreshAfter)
+
+	// Keep a copy of the venue to serve while the booking service is down
+	if venueChunkSeats, err = venueChunkSeatsFromEnv(); err != nil {
+		shared.Fatal("Invalid EDGE_VENUE_CHUNK_SEATS", shared.ErrAttr(err))
+	}
+
+	// Start from the venue saved at the last shutdown, caught up below with the
+	// seats changed since
+	cacheFile, cacheMaxAge, err := venueCacheFileFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid edge cache settings", shared.ErrAttr(err))
+	}
+	if cacheFile != "" {
+		restoreVenueCache(cacheFile, cacheMaxAge)
+	}
+
+	venueRefresh, err := venueRefreshFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid EDGE_VENUE_REFRESH", shared.ErrAttr(err))
+	}
+	startVenueRefresh(venueRefresh)
+
+	// Seat events come over NATS unless EVENT_BUS selects another bus
+	eventBus, err := shared.OpenEventBus(natsConn, "edge-server")
+	if err != nil {
+		shared.Fatal("Failed to open event bus", shared.ErrAttr(err))
+	}
+	defer eventBus.Close()
+
+	// Apply seat events in sequence order outside the subscription callback,
+	// broadcasting them together when they come faster than the window
+	coalesceWindow, err := coalesceWindowFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid EDGE_COALESCE_WINDOW", shared.ErrAttr(err))
+	}
+	coalescer = newSeatCoalescer(hub, coalesceWindow)
+	sequencer = NewEventSequencer(broadcastSeatEvent, reconcileVenueState)
+	go sequencer.Run()
+
+	// Subscribe to seat events, dropping the subscription while nobody is connected
+	hibernateAfter, err := hibernateAfterFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid EDGE_HIBERNATE_AFTER", shared.ErrAttr(err))
+	}
+	hibernator, err := NewHibernator(func() (shared.Subscription, error) {
+		return subscribeToSeatEvents(eventBus)
+	}, func() {
+		// Seat events go unseen while hibernating
+		sequencer.Reset()
+		venueCache.Invalidate()
+	}, hibernateAfter)
+	if err != nil {
+		shared.Fatal("Failed to subscribe to seat events", shared.ErrAttr(err))
+	}
+	hub.onClientsChanged = hibernator.ClientsChanged
+	hibernator.ClientsChanged(0)
+	slog.Info("Subscribed to seat events")
+
+	// Announce this edge to clients and peers
+	edgeRegistry = NewEdgeRegistry(hub, os.Getenv("EDGE_PUBLIC_URL"), os.Getenv("EDGE_REGION"))
+	if natsConn != nil {
+		if err := edgeRegistry.Start(natsConn); err != nil {
+			shared.Fatal("Failed to start edge discovery", shared.ErrAttr(err))
+		}
+
+		// Receive messages addressed to users connected here
+		if err := startInbox(natsConn, edgeRegistry.id); err != nil {
+			shared.Fatal("Failed to subscribe to edge inbox", shared.ErrAttr(err))
+		}
 
-	// Subscribe to NATS events
-	if err := subscribeToNATS(); err != nil {
-		log.Fatalf("Failed to subscribe to NATS: %v", err)
+		// Show clients which seats others on any edge are looking at
+		if err := startSeatFocus(natsConn, edgeRegistry.id); err != nil {
+			shared.Fatal("Failed to subscribe to seat focus", shared.ErrAttr(err))
+		}
+
+		// Let ops disconnect users from the admin API or an admin connection
+		if err := startKicks(natsConn); err != nil {
+			shared.Fatal("Failed to subscribe to user kicks", shared.ErrAttr(err))
+		}
+
+		// Let ops trace single users or connections from the admin API
+		if err := startDebugTraces(natsConn); err != nil {
+			shared.Fatal("Failed to subscribe to debug traces", shared.ErrAttr(err))
+		}
+
+		// Follow event metadata and feature flags as the booking service changes them
+		if err := startConfigWatch(natsConn); err != nil {
+			slog.Warn("Config bucket unavailable, polling the booking service for event metadata", shared.ErrAttr(err))
+		}
+	} else {
+		slog.Warn("Running without NATS: discovery lists only this edge, and messages to individual users, debug traces, and kicks and seat focus from other edges are disabled")
 	}
-	log.Println("Subscribed to NATS seat events")
+
+	if focusInterval, err = focusIntervalFromEnv(); err != nil {
+		shared.Fatal("Invalid EDGE_FOCUS_INTERVAL", shared.ErrAttr(err))
+	}
+
+	// Tell each event's clients how many are viewing it, across the cluster
+	viewersInterval, err := viewersIntervalFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid EDGE_VIEWERS_INTERVAL", shared.ErrAttr(err))
+	}
+	go broadcastViewers(hub, edgeRegistry, viewersInterval)
+	subscribed()
+	warmVenueState(venueFetched)
 
 	// Setup HTTP routes
 	http.HandleFunc("/ws", handleWebSocket)
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/live", handleHealth)
+	http.HandleFunc("/ready", handleReady)
 	http.HandleFunc("/stats", handleStats)
+	http.HandleFunc("/edges", handleDiscovery)
+	http.HandleFunc("/debug/traces", handleDebugTraces)
+	http.HandleFunc("/graphql", handleGraphQL)
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown: leave the load balancer's rotation, stop
+	// accepting connections, then tell clients to reconnect elsewhere
+	drain, err := shared.ShutdownDrainFromEnv()
+	if err != nil {
+		shared.Fatal("Invalid shutdown drain", shared.ErrAttr(err))
+	}
+	server := &http.Server{Addr: port}
+	stopped := make(chan struct{})
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
-		log.Println("Shutting down edge server...")
-		os.Exit(0)
+		slog.Info("Shutting down edge server...", "drain", drain)
+		readiness.Drain()
+		time.Sleep(drain)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shared.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("HTTP requests still in flight at shutdown", shared.ErrAttr(err))
+		}
+		if err := hub.Shutdown(shutdownCtx, shutdownCloseReason); err != nil {
+			slog.Warn("Clients still being written to at shutdown", shared.ErrAttr(err))
+		}
+		if cacheFile != "" {
+			saveVenueCache(cacheFile)
+		}
+		if natsConn != nil {
+			if err := natsConn.FlushWithContext(shutdownCtx); err != nil {
+				slog.Warn("Failed to flush NATS publishes", shared.ErrAttr(err))
+			}
+		}
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Warn("Failed to flush traces", shared.ErrAttr(err))
+		}
+		close(stopped)
 	}()
 
 	// Start server
-	log.Printf("Edge server started on %s", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	slog.Info("Edge server started", "port", port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		shared.Fatal("Failed to start server", shared.ErrAttr(err))
 	}
+	<-stopped
+	slog.Info("Edge server stopped")
 }
 
+// Reason given in the close frame sent to clients when the edge shuts down
+const shutdownCloseReason = "server restarting, reconnect"
+
 func connectNATS() error {
 	var err error
 	
@@ -96,17 +314,23 @@ func connectNATS() error {
 		nats.MaxReconnects(-1), // Infinite reconnects
 		nats.ReconnectWait(2 * time.Second),
 		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
-			log.Printf("[NATS] Disconnected: %v", err)
+			slog.Warn("Disconnected", shared.LogKeyComponent, "nats", shared.ErrAttr(err))
+			// Seat events are missed until the reconnect resyncs the cache
+			venueCache.Invalidate()
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
-			log.Printf("[NATS] Reconnected to %s", nc.ConnectedUrl())
+			slog.Info("Reconnected", shared.LogKeyComponent, "nats", "url", nc.ConnectedUrl())
+			// Events published while disconnected are gone; catch clients up
+			if sequencer != nil {
+				sequencer.Resync()
+			}
 		}),
 		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
-			log.Printf("[NATS] Error: %v", err)
+			slog.Error("Connection error", shared.LogKeyComponent, "nats", shared.ErrAttr(err))
 		}),
 	}
 	
-	natsConn, err = nats.Connect(nats.DefaultURL, opts...)
+	natsConn, err = nats.Connect(shared.NATSURL(), opts...)
 	if err != nil {
 		return err
 	}
@@ -119,77 +343,209 @@ func connectNATS() error {
 	return nil
 }
 
-func subscribeToNATS() error {
+// subscribeToSeatEvents feeds every seat event on the bus to the sequencer
+func subscribeToSeatEvents(bus shared.EventBus) (shared.Subscription, error) {
 	// Subscribe to all seat events
-	subscription, err := natsConn.Subscribe(shared.NATSTopicAllSeats, func(msg *nats.Msg) {
-		// Parse the NATS event
+	sub, err := bus.Subscribe(shared.NATSTopicAllSeats, func(subject string, data []byte) {
+		// Parse the seat event
 		var seatEvent shared.SeatEvent
-		if err := json.Unmarshal(msg.Data, &seatEvent); err != nil {
-			log.Printf("[ERROR] Failed to parse NATS event: %v", err)
+		if err := shared.UnmarshalEvent(data, &seatEvent); err != nil {
+			slog.Error("Failed to parse seat event", shared.ErrAttr(err))
 			return
 		}
-		
-		// Convert to WebSocket message format
-		wsMessage := shared.ServerMessage{
-			Type: shared.MessageTypeSeatUpdate,
+		if seatEvent.EventID == "" {
+			seatEvent.EventID = shared.SubjectEventID(subject)
+		}
+
+		slog.Debug("Received seat event", "event_type", seatEvent.Type, shared.LogKeySeatID, seatEvent.SeatID,
+			"subject", subject, "seq", seatEvent.Seq)
+		sequencer.Enqueue(seatEvent)
+	})
+	
+	if err != nil {
+		return nil, err
+	}
+	
+	slog.Info("Subscribed to seat events", "subject", shared.NATSTopicAllSeats)
+	return sub, nil
+}
+
+// broadcastSeatEvent applies a seat event to the venue cache and hands it to
+// the coalescer to send to the clients following its event
+func broadcastSeatEvent(seatEvent shared.SeatEvent) {
+	for _, event := range seatEvent.Unbatch() {
+		venueCache.Apply(event)
+	}
+	hub.seatEvents.Add(1)
+	coalescer.add(seatEvent)
+
+	slog.Debug("Broadcasting seat event", "event_type", seatEvent.Type, shared.LogKeySeatID, seatEvent.SeatID,
+		"clients", hub.GetClientCount())
+}
+
+// sendSeatEvent converts a seat event to a SEAT_UPDATE, or a batch to one
+// SEAT_UPDATE_BATCH, and queues it on h for the event's room at the highest
+// priority of its transitions
+func sendSeatEvent(h *Hub, seatEvent shared.SeatEvent) {
+	// Convert to WebSocket message format
+	wsMessage := shared.ServerMessage{
+		Type: shared.MessageTypeSeatUpdate,
+		Data: seatUpdateData(seatEvent),
+	}
+	if len(seatEvent.Events) > 0 {
+		updates := make([]map[string]interface{}, len(seatEvent.Events))
+		for i, event := range seatEvent.Events {
+			updates[i] = seatUpdateData(event)
+		}
+		wsMessage = shared.ServerMessage{
+			Type: shared.MessageTypeSeatUpdateBatch,
 			Data: map[string]interface{}{
 				"event_type": seatEvent.Type,
-				"seat_id":    seatEvent.SeatID,
-				"user_id":    seatEvent.UserID,
-				"status":     seatEvent.Status,
-				"timestamp":  seatEvent.Timestamp,
-				"expires_at": seatEvent.ExpiresAt,
-				"seat":       seatEvent.Seat,
+				"updates":    updates,
 			},
 		}
-		
-		// Marshal to JSON for WebSocket
-		wsMessageJSON, err := json.Marshal(wsMessage)
-		if err != nil {
-			log.Printf("[ERROR] Failed to marshal WebSocket message: %v", err)
-			return
-		}
-		
-		// Broadcast to all connected clients
-		hub.broadcastMessage(wsMessageJSON)
-		
-		log.Printf("[NATS] Received %s event for seat %s on topic %s, broadcasting to %d clients", 
-			seatEvent.Type, seatEvent.SeatID, msg.Subject, hub.GetClientCount())
-	})
+	}
 	
+	priority := eventPriority(seatEvent.Type)
+	for _, event := range seatEvent.Events {
+		priority = min(priority, eventPriority(event.Type))
+	}
+	h.broadcastSeatUpdate(eventOf(seatEvent), wsMessage, priority, updatedSeats(seatEvent)...)
+}
+
+// seatUpdateData is the data of a SEAT_UPDATE for one seat event
+func seatUpdateData(seatEvent shared.SeatEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"event_type":    seatEvent.Type,
+		"seat_id":       seatEvent.SeatID,
+		"user_id":       seatEvent.UserID,
+		"status":        seatEvent.Status,
+		"version":       seatEvent.Version,
+		"venue_version": seatEvent.Seq,
+		"timestamp":     seatEvent.Timestamp,
+		"expires_at":    seatEvent.ExpiresAt,
+		"seat":          seatEvent.Seat,
+	}
+}
+
+// reconcileVenueState pushes a fresh VENUE_STATE to every client and returns the
+// event sequence number it reflects
+func reconcileVenueState() (int64, error) {
+	seats, seq, err := bookingClient.GetVenueSnapshot()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	venueCache.Replace(seats, seq)
+
+	state := shared.VenueState{Seats: seats, Event: eventInfo(), Flags: currentFlags(), Layout: shared.VenueLayoutID(seats), VenueVersion: seq}
+	stateJSON, err := json.Marshal(shared.ServerMessage{
+		Type: shared.MessageTypeVenueState,
+		Data: state,
+	})
+	if err != nil {
+		return 0, err
+	}
+	chunks, err := venueStateMessages(state)
+	if err != nil {
+		return 0, err
+	}
+	bitmaskJSON, err := json.Marshal(shared.ServerMessage{
+		Type: shared.MessageTypeVenueStateBitmask,
+		Data: venueBitmask(state),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// High priority so the snapshot is not overtaken by the events that follow it
+	hub.broadcastVenueState(stateJSON, chunks, bitmaskJSON, state.Layout)
+
+	slog.Info("Pushed corrected venue state", "seats", len(seats), "seq", seq, "clients", hub.GetClientCount())
+	return seq, nil
+}
+
+// eventPriority ranks seat events for the hub's broadcast queues
+func eventPriority(eventType string) int {
+	switch eventType {
+	case "booked", "released", "auto_released", "allocated", "returned", shared.SeatEventReleasedBatch:
+		return PriorityHigh
+	case "held", "renewed":
+		return PriorityLow
+	default:
+		return PriorityNormal
 	}
-	
-	log.Printf("[NATS] Subscribed to %s (subscription: %s)", shared.NATSTopicAllSeats, subscription.Subject)
-	return nil
 }
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	permission, session, err := connectionPermission(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Refuse connections over a limit with a close frame saying why, which a
+	// plain HTTP error would not reach browsers with
+	ip := clientIP(r)
+	protocolVersion, versionErr := queryProtocolVersion(r)
+	refused := ""
+	if versionErr != nil {
+		refused = "protocol"
+	} else if !hub.ipConns.acquire(ip) {
+		refused = "address"
+	} else if session != nil && !hub.userConns.acquire(session.UserID) {
+		hub.ipConns.release(ip)
+		refused = "user"
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		if refused == "" {
+			hub.ipConns.release(ip)
+			if session != nil {
+				hub.userConns.release(session.UserID)
+			}
+		}
+		slog.Warn("WebSocket upgrade failed", shared.ErrAttr(err))
+		return
+	}
+	if versionErr != nil {
+		slog.Warn("Refused connection with an unsupported protocol version", "remote_ip", ip, shared.ErrAttr(versionErr))
+		refuseProtocol(conn, versionErr)
+		return
+	}
+	if refused != "" {
+		slog.Warn("Refused connection over the per-"+refused+" limit", "remote_ip", ip)
+		refuseConnection(conn, refused)
 		return
 	}
 
 	// Create new client
-	client := &Client{
-		hub:          hub,
-		conn:         conn,
-		send:         make(chan []byte, 256),
-		id:           generateClientID(),
-		connectedAt:  time.Now(),
-		lastActivity: time.Now(),
+	client := newClient(hub, conn, generateClientID(), 256)
+	hub.startSession(client, r.URL.Query().Get("session"), time.Now())
+	client.msgpack = conn.Subprotocol() == shared.SubprotocolMsgpack
+	client.protocolVersion.Store(int32(protocolVersion))
+	client.permission = permission
+	client.session = session
+	if session != nil {
+		client.sessionUserID = session.UserID
+		client.countedUserID = session.UserID
+	}
+	client.remoteIP = ip
+	if eventID := r.URL.Query().Get("event"); eventID != "" {
+		client.eventID = eventID
 	}
+	client.admission = r.URL.Query().Get("admission")
+	client.lastEventID = r.URL.Query().Get("last_event_id")
 
 	// Register client with hub
-	client.hub.register <- client
+	client.hub.registerClient(client)
 
 	// Start client goroutines
 	go client.writePump()
 	go client.readPump()
 
-	log.Printf("New WebSocket client connected: %s", client.id)
+	slog.Info("WebSocket client connected", shared.LogKeyClientID, client.id, "permission", client.permission.String(),
+		"msgpack", client.msgpack, "protocol_version", protocolVersion)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -211,23 +567,7 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	w.Write(statsJSON)
 }
 
+// generateClientID returns a random, unguessable client ID
 func generateClientID() string {
-	// Simple ID generation for now
-	// In production, use UUID
-	return "client-" + generateRandomString(8)
+	return "client-" + uuid.NewString()
 }
-
-func generateRandomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[randInt(len(charset))]
-	}
-	return string(b)
-}
-
-func randInt(max int) int {
-	// Simple random for demo
-	// In production, use crypto/rand
-	return int(os.Getpid()+len(hub.clients)) % max
-}
\ No newline at end of file
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// How long to wait for the booking service to answer a command
+const natsCommandTimeout = 5 * time.Second
+
+// errBookingUnavailable wraps errors from a booking service that could not be
+// reached or answered that it is down, as opposed to one that refused a command
+var errBookingUnavailable = shared.NewError(shared.ErrorCodeUnavailable, "booking service unavailable")
+
+// errSeatNotFound is returned for a seat ID the booking service does not know
+var errSeatNotFound = shared.NewError(shared.ErrorCodeNotFound, "seat not found")
+
+// errVenueChangesGone is returned when the booking service no longer keeps the
+// seat changes asked for, and the whole venue must be fetched instead
+var errVenueChangesGone = errors.New("venue changes no longer kept")
+
+// BookingService is what the edge needs from the booking service
+type BookingService interface {
+	GetAllSeats() ([]shared.Seat, error)
+	GetVenueSnapshot() ([]shared.Seat, int64, error)
+	GetVenueChanges(since int64) ([]shared.Seat, int64, error)
+	GetEventInfo() (*shared.EventInfo, error)
+	GetSeat(seatID string) (*shared.Seat, error)
+	SelectSeat(ctx context.Context, req shared.SeatRequest) error
+	BookSeat(ctx context.Context, req shared.SeatRequest) error
+	ReleaseSeat(ctx context.Context, req shared.SeatRequest) error
+	KeepHold(ctx context.Context, req shared.SeatRequest) error
+}
+
+// NATSBookingClient talks to the booking service over NATS request-reply,
+// skipping the HTTP hop BookingClient makes
+type NATSBookingClient struct {
+	nc      *nats.Conn
+	timeout time.Duration
+}
+
+// NewNATSBookingClient creates a booking service client on an existing connection
+func NewNATSBookingClient(nc *nats.Conn) *NATSBookingClient {
+	return &NATSBookingClient{nc: nc, timeout: natsCommandTimeout}
+}
+
+// GetAllSeats fetches all seats from the booking service
+func (bc *NATSBookingClient) GetAllSeats() ([]shared.Seat, error) {
+	seats, _, err := bc.GetVenueSnapshot()
+	return seats, err
+}
+
+// GetVenueSnapshot fetches all seats along with the seat event sequence number
+// they reflect
+func (bc *NATSBookingClient) GetVenueSnapshot() ([]shared.Seat, int64, error) {
+	msg, err := bc.request(shared.NATSSubjectCmdSnapshot, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch seats: %w", err)
+	}
+
+	var reply shared.VenueSnapshotReply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode seats: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, 0, errors.New(reply.Error)
+	}
+	return reply.Seats, reply.Seq, nil
+}
+
+// GetVenueChanges fetches the seats changed after the event sequence number
+// since, along with the sequence number they reflect, or errVenueChangesGone
+func (bc *NATSBookingClient) GetVenueChanges(since int64) ([]shared.Seat, int64, error) {
+	msg, err := bc.request(shared.NATSSubjectCmdChanges, []byte(strconv.FormatInt(since, 10)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch seat changes: %w", err)
+	}
+
+	var reply shared.VenueChangesReply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode seat changes: %w", err)
+	}
+	if reply.Gone {
+		return nil, 0, errVenueChangesGone
+	}
+	if reply.Error != "" || reply.Changes == nil {
+		return nil, 0, fmt.Errorf("failed to fetch seat changes: %s", reply.Error)
+	}
+	return reply.Changes.Seats, reply.Changes.Seq, nil
+}
+
+// GetEventInfo fetches the event's organizer metadata
+func (bc *NATSBookingClient) GetEventInfo() (*shared.EventInfo, error) {
+	msg, err := bc.request(shared.NATSSubjectCmdEvent, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch event: %w", err)
+	}
+
+	var reply shared.EventInfoReply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Event, nil
+}
+
+// GetSeat fetches a single seat by ID, or errSeatNotFound
+func (bc *NATSBookingClient) GetSeat(seatID string) (*shared.Seat, error) {
+	msg, err := bc.request(shared.NATSSubjectCmdSeat, []byte(seatID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch seat: %w", err)
+	}
+
+	var reply shared.SeatReply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return nil, fmt.Errorf("failed to decode seat: %w", err)
+	}
+	if reply.NotFound {
+		return nil, errSeatNotFound
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Seat, nil
+}
+
+// SelectSeat attempts to select a seat for a user
+func (bc *NATSBookingClient) SelectSeat(ctx context.Context, req shared.SeatRequest) error {
+	return bc.command(ctx, shared.NATSSubjectCmdSelect, req)
+}
+
+// BookSeat attempts to book a seat for a user
+func (bc *NATSBookingClient) BookSeat(ctx context.Context, req shared.SeatRequest) error {
+	return bc.command(ctx, shared.NATSSubjectCmdBook, req)
+}
+
+// ReleaseSeat releases a seat held by a user
+func (bc *NATSBookingClient) ReleaseSeat(ctx context.Context, req shared.SeatRequest) error {
+	return bc.command(ctx, shared.NATSSubjectCmdRelease, req)
+}
+
+// KeepHold pings a hold kept alive on activity
+func (bc *NATSBookingClient) KeepHold(ctx context.Context, req shared.SeatRequest) error {
+	return bc.command(ctx, shared.NATSSubjectCmdKeepalive, req)
+}
+
+// command sends a seat command, with ctx's trace context and correlation ID in
+// its headers, and turns an error reply into an error
+func (bc *NATSBookingClient) command(ctx context.Context, subject string, req shared.SeatRequest) error {
+	data, err := json.Marshal(shared.SeatCommand{
+		SeatID:         req.SeatID,
+		UserID:         req.UserID,
+		Version:        req.Version,
+		IdempotencyKey: req.IdempotencyKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(msg.Header))
+	if id := shared.CorrelationID(ctx); id != "" {
+		msg.Header.Set(shared.HeaderCorrelationID, id)
+	}
+
+	msg, err = bc.requestMsg(msg)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	var reply shared.SeatCommandReply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return fmt.Errorf("failed to decode reply: %w", err)
+	}
+	if reply.Error != "" {
+		return shared.NewError(reply.Code, reply.Error)
+	}
+	return nil
+}
+
+func (bc *NATSBookingClient) request(subject string, data []byte) (*nats.Msg, error) {
+	return bc.requestMsg(&nats.Msg{Subject: subject, Data: data})
+}
+
+func (bc *NATSBookingClient) requestMsg(msg *nats.Msg) (*nats.Msg, error) {
+	reply, err := bc.nc.RequestMsg(msg, bc.timeout)
+	if errors.Is(err, nats.ErrNoResponders) {
+		return nil, errBookingUnavailable
+	}
+	if errors.Is(err, nats.ErrTimeout) || errors.Is(err, nats.ErrConnectionClosed) {
+		return nil, fmt.Errorf("%w: %w", errBookingUnavailable, err)
+	}
+	return reply, err
+}
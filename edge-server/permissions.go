@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Permission is what a connection is allowed to do. Each level includes the
+// ones below it. The zero value grants nothing.
+type Permission int
+
+const (
+	PermissionViewer Permission = iota + 1 // read venue state and updates
+	PermissionBuyer                        // also hold, book and release seats
+	PermissionAdmin                        // also operator-only actions
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionViewer:
+		return "viewer"
+	case PermissionBuyer:
+		return "buyer"
+	case PermissionAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// Allows reports whether p includes required
+func (p Permission) Allows(required Permission) bool {
+	return p >= required
+}
+
+// messageRoute pairs a client message handler with the permission it requires
+type messageRoute struct {
+	permission Permission
+	handle     func(c *Client, msg *shared.ClientMessage)
+}
+
+// messageRoutes is the WS dispatch table. Every message type must name the
+// permission it needs, so read-only connections cannot reach mutating handlers.
+var messageRoutes = map[string]messageRoute{
+	shared.MessageTypeSubscribe:     {PermissionViewer, (*Client).handleSubscribe},
+	shared.MessageTypeSelectSeat:    {PermissionBuyer, (*Client).handleSelectSeat},
+	shared.MessageTypeBookSeat:      {PermissionBuyer, (*Client).handleBookSeat},
+	shared.MessageTypeReleaseSeat:   {PermissionBuyer, (*Client).handleReleaseSeat},
+	shared.MessageTypeGetSeat:       {PermissionViewer, (*Client).handleGetSeat},
+	shared.MessageTypeTokenRefresh:  {PermissionViewer, (*Client).handleTokenRefresh},
+	shared.MessageTypeSync:          {PermissionViewer, (*Client).handleSync},
+	shared.MessageTypeHoldKeepalive: {PermissionBuyer, (*Client).handleHoldKeepalive},
+	shared.MessageTypeJoin:          {PermissionViewer, (*Client).handleJoin},
+	shared.MessageTypeLeave:         {PermissionViewer, (*Client).handleLeave},
+	shared.MessageTypeFocusSeat:     {PermissionBuyer, (*Client).handleFocusSeat},
+	shared.MessageTypeKickUser:      {PermissionAdmin, (*Client).handleKickUser},
+}
+
+// connectionPermission determines what a new connection may do. Without an
+// auth secret, connections are buyers unless they ask for less with
+// ?role=viewer (e.g. kiosk displays), and admin is refused. With one, the
+// connection must present a valid ?token and gets its identity and role from it.
+func connectionPermission(r *http.Request) (Permission, *tokenClaims, error) {
+	if len(authSecret) > 0 {
+		claims, err := shared.ParseSessionToken(authSecret, r.URL.Query().Get("token"), time.Now())
+		if err != nil {
+			return 0, nil, err
+		}
+		permission, err := rolePermission(claims.Role)
+		if err != nil {
+			return 0, nil, err
+		}
+		return permission, claims, nil
+	}
+
+	switch r.URL.Query().Get("role") {
+	case "", "buyer":
+		return PermissionBuyer, nil, nil
+	case "viewer":
+		return PermissionViewer, nil, nil
+	case "admin":
+		return 0, nil, errors.New("admin connections require authentication")
+	default:
+		return 0, nil, errors.New("unknown role")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestSeatMessagesRequireBuyer(t *testing.T) {
+	tests := []struct {
+		name       string
+		permission Permission
+		msgType    string
+		allowed    bool
+	}{
+		{"viewer selects", PermissionViewer, shared.MessageTypeSelectSeat, false},
+		{"viewer books", PermissionViewer, shared.MessageTypeBookSeat, false},
+		{"viewer releases", PermissionViewer, shared.MessageTypeReleaseSeat, false},
+		{"buyer selects", PermissionBuyer, shared.MessageTypeSelectSeat, true},
+		{"buyer books", PermissionBuyer, shared.MessageTypeBookSeat, true},
+		{"buyer releases", PermissionBuyer, shared.MessageTypeReleaseSeat, true},
+		{"unauthenticated selects", 0, shared.MessageTypeSelectSeat, false},
+		{"unauthenticated books", 0, shared.MessageTypeBookSeat, false},
+		{"unauthenticated releases", 0, shared.MessageTypeReleaseSeat, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newTestHarness(t)
+			called := make(chan struct{}, 1)
+			booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					select {
+					case called <- struct{}{}:
+					default:
+					}
+				}
+				w.Write([]byte(`{"message":"ok"}`))
+			}))
+			defer booking.Close()
+			bookingClient = NewBookingClient(booking.URL)
+
+			_, conn := th.connectAs(fmt.Sprintf("client-permission-%d", i), 16, tt.permission)
+			conn.sendJSON(t, tt.msgType, map[string]interface{}{"seat_id": "A1", "user_id": "user-1"})
+
+			if tt.allowed {
+				select {
+				case <-called:
+				case <-time.After(2 * time.Second):
+					t.Fatalf("booking service was not called for %s", tt.msgType)
+				}
+				eventually(t, func() bool {
+					_, err := findMessage(conn.messages(t), tt.msgType+"_RESPONSE")
+					return err == nil
+				}, "expected "+tt.msgType+"_RESPONSE")
+				if msg, err := findMessage(conn.messages(t), shared.MessageTypeError); err == nil {
+					t.Fatalf("unexpected ERROR %v", msg.Data)
+				}
+				return
+			}
+
+			eventually(t, func() bool {
+				_, err := findMessage(conn.messages(t), shared.MessageTypeError)
+				return err == nil
+			}, "expected ERROR for "+tt.msgType)
+
+			msg, _ := findMessage(conn.messages(t), shared.MessageTypeError)
+			data := msg.Data.(map[string]interface{})
+			if data["code"] != string(shared.ErrorCodeForbidden) {
+				t.Fatalf("code = %v, want %s", data["code"], shared.ErrorCodeForbidden)
+			}
+			if want := "Permission denied: " + tt.msgType + " requires buyer"; data["error"] != want {
+				t.Fatalf("error = %v, want %q", data["error"], want)
+			}
+			select {
+			case <-called:
+				t.Fatal("booking service was called without buyer permission")
+			default:
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// queryProtocolVersion negotiates the message format version to speak with a
+// connecting client from its ?protocol_version= parameter
+func queryProtocolVersion(r *http.Request) (int, error) {
+	requested := 0
+	if v := r.URL.Query().Get("protocol_version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, errors.New("protocol_version must be an integer")
+		}
+		requested = n
+	}
+	return shared.NegotiateProtocolVersion(requested)
+}
+
+// refuseProtocol ends a just upgraded connection that asked for a protocol
+// version the edge does not speak with CloseCodeUnsupportedProtocol
+func refuseProtocol(conn *websocket.Conn, err error) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(shared.CloseCodeUnsupportedProtocol, err.Error()), time.Now().Add(writeWait))
+	conn.Close()
+}
+
+// protocolVersions is the range of versions an edge speaks, in WELCOME
+type protocolVersions struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
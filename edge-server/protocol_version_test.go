@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectingNegotiatesTheProtocolVersion(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.ipConns = newConnCounter(10)
+	previous := hub
+	hub = th.hub
+	t.Cleanup(func() { hub = previous })
+
+	srv := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	t.Cleanup(srv.Close)
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws" + query
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial %s: %v", query, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		return conn
+	}
+	welcomeVersion := func(conn *websocket.Conn) float64 {
+		t.Helper()
+		for {
+			var msg shared.ServerMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("reading WELCOME: %v", err)
+			}
+			if msg.Type == "WELCOME" {
+				version, _ := msg.Data.(map[string]interface{})["protocol_version"].(float64)
+				return version
+			}
+		}
+	}
+
+	// Clients from before versioning speak the oldest version, and clients
+	// newer than the edge the newest it has
+	if got := welcomeVersion(dial("")); got != shared.ProtocolVersionMin {
+		t.Errorf("without protocol_version: WELCOME protocol_version = %v, want %d", got, shared.ProtocolVersionMin)
+	}
+	if got := welcomeVersion(dial("?protocol_version=99")); got != shared.ProtocolVersion {
+		t.Errorf("protocol_version=99: WELCOME protocol_version = %v, want %d", got, shared.ProtocolVersion)
+	}
+
+	for _, query := range []string{"?protocol_version=-1", "?protocol_version=v2"} {
+		_, _, err := dial(query).ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != shared.CloseCodeUnsupportedProtocol {
+			t.Errorf("%s: got %v, want close code %d", query, err, shared.CloseCodeUnsupportedProtocol)
+		}
+	}
+	th.hub.ipConns.mu.Lock()
+	defer th.hub.ipConns.mu.Unlock()
+	if n := th.hub.ipConns.counts["127.0.0.1"]; n != 2 {
+		t.Errorf("address has %d connections counted, want the 2 let in", n)
+	}
+}
+
+func TestSubscribeNegotiatesTheProtocolVersion(t *testing.T) {
+	th := newTestHarness(t)
+
+	_, conn := th.connect("client-newer", 16)
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1", "protocol_version": 99})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "SUBSCRIBE_ACK")
+		return err == nil
+	}, "no SUBSCRIBE_ACK")
+	msg, _ := findMessage(conn.messages(t), "SUBSCRIBE_ACK")
+	var ack struct {
+		Success bool `json:"success"`
+		Data    struct {
+			ProtocolVersion int `json:"protocol_version"`
+		} `json:"data"`
+	}
+	raw, _ := json.Marshal(msg.Data)
+	json.Unmarshal(raw, &ack)
+	if !ack.Success || ack.Data.ProtocolVersion != shared.ProtocolVersion {
+		t.Errorf("SUBSCRIBE_ACK = %s, want success at protocol_version %d", raw, shared.ProtocolVersion)
+	}
+
+	_, older := th.connect("client-older", 16)
+	older.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-2", "protocol_version": -1})
+	eventually(t, func() bool {
+		_, err := findMessage(older.messages(t), "SUBSCRIBE_ACK")
+		return err == nil
+	}, "no SUBSCRIBE_ACK")
+	msg, _ = findMessage(older.messages(t), "SUBSCRIBE_ACK")
+	if code := msg.Data.(map[string]interface{})["code"]; code != string(shared.ErrorCodeUnsupportedVersion) {
+		t.Errorf("SUBSCRIBE_ACK code = %v, want %s", code, shared.ErrorCodeUnsupportedVersion)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// How often the edge retries fetching the venue while it waits to become ready
+const venueWarmupRetry = 1 * time.Second
+
+// readiness gates /ready on NATS, the seat event and inbox subscriptions and
+// the booking service having served the venue once
+var readiness = shared.NewReadiness()
+
+var errNATSDisconnected = errors.New("disconnected")
+
+// checkNATS makes readiness follow the NATS connection
+func checkNATS(nc *nats.Conn) {
+	readiness.Check("nats", func() error {
+		if !nc.IsConnected() {
+			return errNATSDisconnected
+		}
+		return nil
+	})
+}
+
+// warmVenueState fetches the venue in the background until the booking service
+// answers, then opens the gate, so clients are not routed to an edge that
+// cannot yet send them VENUE_STATE. A venue cache restored from disk opens it
+// at once: those seats can be served while the cache catches up.
+func warmVenueState(open func()) {
+	if _, restored := venueCache.Stale(); restored {
+		open()
+	}
+	go func() {
+		for {
+			err := loadVenueCache()
+			if err == nil {
+				open()
+				return
+			}
+			slog.Warn("Waiting for the booking service to serve the venue", shared.ErrAttr(err))
+			time.Sleep(venueWarmupRetry)
+		}
+	}()
+}
+
+// handleReady reports whether the edge should be sent clients, with the state
+// of each check
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	report := readiness.Report()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestReadyWaitsForInitialVenueState(t *testing.T) {
+	newTestHarness(t)
+	saved := readiness
+	readiness = shared.NewReadiness()
+	t.Cleanup(func() { readiness = saved })
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		handleReady(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return w.Code
+	}
+
+	venueFetched := readiness.Gate("venue")
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("/ready before the venue is fetched = %d, want 503", code)
+	}
+
+	warmVenueState(venueFetched)
+	eventually(t, func() bool { return ready() == http.StatusOK }, "/ready never turned 200 after the venue was fetched")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Seat updates kept for replay to reconnecting clients, unless
+// EDGE_REPLAY_BUFFER says otherwise
+const defaultReplayBuffer = 1024
+
+// replayBufferFromEnv reads EDGE_REPLAY_BUFFER; 0 disables replay
+func replayBufferFromEnv() (int, error) {
+	raw := os.Getenv("EDGE_REPLAY_BUFFER")
+	if raw == "" {
+		return defaultReplayBuffer, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("want a number of seat updates, got %q", raw)
+	}
+	return n, nil
+}
+
+// replayBuffer is a ring of the latest seat updates the hub broadcast to a
+// room, by broadcast sequence number. The hub's run loop adds to it while
+// shards read it.
+type replayBuffer struct {
+	mu       sync.Mutex
+	seqs     []int64
+	messages [][]byte
+	next     int // where the next update goes
+	len      int
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{seqs: make([]int64, size), messages: make([][]byte, size)}
+}
+
+// add keeps a broadcast seat update, evicting the oldest if full
+func (rb *replayBuffer) add(seq int64, message []byte) {
+	if len(rb.seqs) == 0 {
+		return
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.seqs[rb.next], rb.messages[rb.next] = seq, message
+	rb.next = (rb.next + 1) % len(rb.seqs)
+	rb.len = min(rb.len+1, len(rb.seqs))
+}
+
+// since returns the updates broadcast after seq up to upTo, oldest first; ok
+// is false unless the buffer still holds every one of them
+func (rb *replayBuffer) since(seq, upTo int64) (messages [][]byte, ok bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	want := seq + 1
+	for i := 0; i < rb.len && want <= upTo; i++ {
+		j := (rb.next - rb.len + i + len(rb.seqs)) % len(rb.seqs)
+		switch {
+		case rb.seqs[j] < want:
+			continue
+		case rb.seqs[j] > want:
+			// Evicted, or dropped before it was broadcast
+			return nil, false
+		}
+		messages = append(messages, rb.messages[j])
+		want++
+	}
+	if rb.len > 0 && rb.seqs[(rb.next-1+len(rb.seqs))%len(rb.seqs)] < seq {
+		// Ahead of this edge: not an ID it gave out
+		return nil, false
+	}
+	return messages, rb.len > 0 || seq == 0
+}
+
+// parseLastEventID splits a client's last_event_id, "<stream_id>:<broadcast_seq>"
+func parseLastEventID(raw string) (stream string, seq int64, ok bool) {
+	stream, rawSeq, ok := strings.Cut(raw, ":")
+	if !ok || stream == "" {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseInt(rawSeq, 10, 64)
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return stream, seq, true
+}
+
+// replayFor returns the seat updates about its event a client reconnecting
+// with last_event_id missed, and where they start from; ok is false if they
+// cannot all be replayed, as when it was given out by another edge or they
+// were evicted. They run up to the last its shard delivered, which the rest
+// follow live. Only the client's shard's loop calls it.
+func (h *Hub) replayFor(s *hubShard, client *Client) (messages [][]byte, from int64, ok bool) {
+	stream, seq, ok := parseLastEventID(client.lastEventID)
+	if !ok || stream != h.streamID {
+		return nil, 0, false
+	}
+	r := h.room(client.eventID)
+	if r.replay == nil {
+		return nil, 0, false
+	}
+	messages, ok = r.replay.since(seq, s.delivered[client.eventID])
+	if !ok || len(messages) >= cap(client.send) {
+		return nil, 0, false
+	}
+	return messages, seq, true
+}
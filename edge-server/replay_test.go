@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestReplayBufferReturnsOnlyAnUnbrokenTail(t *testing.T) {
+	rb := newReplayBuffer(3)
+	for _, seq := range []int64{1, 2, 4, 5} { // 3 was dropped
+		rb.add(seq, []byte(strconv.FormatInt(seq, 10)))
+	}
+
+	if got, ok := rb.since(4, 5); !ok || len(got) != 1 || string(got[0]) != "5" {
+		t.Errorf("since(4) = %q, %v, want 5", got, ok)
+	}
+	if got, ok := rb.since(5, 5); !ok || len(got) != 0 {
+		t.Errorf("since(5) = %q, %v, want nothing to replay", got, ok)
+	}
+	if got, ok := rb.since(2, 2); !ok || len(got) != 0 {
+		t.Errorf("since(2) up to 2 = %q, %v, want nothing to replay yet", got, ok)
+	}
+	for _, seq := range []int64{1, 2, 6} {
+		if got, ok := rb.since(seq, 5); ok {
+			t.Errorf("since(%d) = %q, want it refused", seq, got)
+		}
+	}
+}
+
+func TestParseLastEventID(t *testing.T) {
+	if stream, seq, ok := parseLastEventID("ab12cd34:42"); !ok || stream != "ab12cd34" || seq != 42 {
+		t.Errorf("parseLastEventID = %q, %d, %v", stream, seq, ok)
+	}
+	for _, bad := range []string{"", "42", ":42", "ab12cd34:", "ab12cd34:-1"} {
+		if _, _, ok := parseLastEventID(bad); ok {
+			t.Errorf("parseLastEventID(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestReconnectingClientGetsTheMissedSeatUpdates(t *testing.T) {
+	h := newHub()
+	h.shards = newHubShards(h, 1)
+	go h.run()
+	update := func(seatID string) shared.ServerMessage {
+		return shared.ServerMessage{Type: shared.MessageTypeSeatUpdate, Data: map[string]interface{}{"seat_id": seatID}}
+	}
+	for _, seatID := range []string{"A1", "A2", "A3"} {
+		h.broadcastSeatUpdate(shared.DefaultEventID, update(seatID), PriorityHigh)
+	}
+	// Replay runs up to what the shard delivered
+	shard := h.shards[0]
+	for delivered := int64(0); delivered < 3; {
+		time.Sleep(time.Millisecond)
+		shard.do(func() { delivered = shard.delivered[shared.DefaultEventID] })
+	}
+
+	receive := func(c *Client) shared.ServerMessage {
+		t.Helper()
+		select {
+		case raw := <-c.send:
+			var msg shared.ServerMessage
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				t.Fatalf("unmarshal %s: %v", raw, err)
+			}
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a message")
+			return shared.ServerMessage{}
+		}
+	}
+
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-replay", eventID: shared.DefaultEventID, lastEventID: h.streamID + ":1"}
+	h.registerClient(client)
+	welcome := receive(client)
+	data, _ := welcome.Data.(map[string]interface{})
+	replay, _ := data["replay"].(map[string]interface{})
+	if welcome.Type != "WELCOME" || replay["replayed"] != float64(2) || data["broadcast_seq"] != float64(1) {
+		t.Fatalf("WELCOME = %v, want 2 updates replayed from 1", welcome.Data)
+	}
+	for _, want := range []string{"A2", "A3"} {
+		msg := receive(client)
+		if seatID := msg.Data.(map[string]interface{})["seat_id"]; seatID != want {
+			t.Fatalf("replayed %v, want %s", msg.Data, want)
+		}
+	}
+
+	other := &Client{hub: h, send: make(chan []byte, 16), id: "client-other-edge", eventID: shared.DefaultEventID, lastEventID: "elsewhere:1"}
+	h.registerClient(other)
+	data, _ = receive(other).Data.(map[string]interface{})
+	if replay, _ := data["replay"].(map[string]interface{}); replay["gone"] != true {
+		t.Errorf("WELCOME for another edge's event ID = %v, want replay gone", data)
+	}
+	if len(other.send) != 0 {
+		t.Error("updates were replayed for another edge's event ID")
+	}
+}
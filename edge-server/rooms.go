@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"concert-booking/shared"
+)
+
+// Most rooms one client may be in, counting the one for its own event
+const maxRoomsPerClient = 16
+
+// Longest event ID a client may JOIN
+const maxEventIDLength = 64
+
+var errTooManyRooms = fmt.Errorf("a client may follow at most %d events", maxRoomsPerClient)
+
+var errNotRegistered = errors.New("client is not registered")
+
+// room is the clients following one event: those that connected for it and
+// those that JOINed it. Its seat updates go to them alone, numbered and kept
+// for replay apart from every other event's. Which clients are in it is kept
+// by their shards.
+type room struct {
+	members atomic.Int64
+
+	// Last broadcast_seq given to one of the event's seat updates
+	seq atomic.Int64
+
+	// The event's recent seat updates, for clients reconnecting with
+	// last_event_id; nil disables replay
+	replay *replayBuffer
+}
+
+// roomLocked returns an event's room, creating it; h.mu must be held
+func (h *Hub) roomLocked(eventID string) *room {
+	r, ok := h.rooms[eventID]
+	if !ok {
+		r = &room{}
+		if h.replaySize > 0 {
+			r.replay = newReplayBuffer(h.replaySize)
+		}
+		h.rooms[eventID] = r
+	}
+	return r
+}
+
+// room returns an event's room, creating it
+func (h *Hub) room(eventID string) *room {
+	h.mu.RLock()
+	r, ok := h.rooms[eventID]
+	h.mu.RUnlock()
+	if ok {
+		return r
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.roomLocked(eventID)
+}
+
+// enterRoom counts a member into an event's room, creating it
+func (h *Hub) enterRoom(eventID string) {
+	// Counted under the read lock, so an emptied room cannot be dropped in
+	// between
+	h.mu.RLock()
+	r, ok := h.rooms[eventID]
+	if ok {
+		r.members.Add(1)
+	}
+	h.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.roomLocked(eventID).members.Add(1)
+}
+
+// exitRoom counts a member out of an event's room. A room left empty before
+// any seat update went out is dropped; one that numbered updates stays, so
+// its sequence carries on for clients that come back.
+func (h *Hub) exitRoom(eventID string) {
+	h.mu.RLock()
+	r := h.rooms[eventID]
+	empty := r.members.Add(-1) == 0 && r.seq.Load() == 0
+	h.mu.RUnlock()
+	if !empty {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rooms[eventID] == r && r.members.Load() == 0 && r.seq.Load() == 0 {
+		delete(h.rooms, eventID)
+	}
+}
+
+// joinLocked puts a client of shard s in an event's room; s.mu must be held
+// by the shard's loop
+func (h *Hub) joinLocked(s *hubShard, client *Client, eventID string) error {
+	if client.rooms[eventID] {
+		return nil
+	}
+	if len(client.rooms) >= maxRoomsPerClient {
+		return errTooManyRooms
+	}
+	if client.rooms == nil {
+		client.rooms = make(map[string]bool)
+	}
+	client.rooms[eventID] = true
+	clients, ok := s.rooms[eventID]
+	if !ok {
+		clients = make(map[*Client]bool)
+		s.rooms[eventID] = clients
+	}
+	clients[client] = true
+	h.enterRoom(eventID)
+	return nil
+}
+
+// leaveLocked takes a client of shard s out of an event's room, reporting
+// whether it was in it; s.mu must be held by the shard's loop
+func (h *Hub) leaveLocked(s *hubShard, client *Client, eventID string) bool {
+	if !client.rooms[eventID] {
+		return false
+	}
+	delete(client.rooms, eventID)
+	delete(s.rooms[eventID], client)
+	if len(s.rooms[eventID]) == 0 {
+		delete(s.rooms, eventID)
+	}
+	h.exitRoom(eventID)
+	return true
+}
+
+// join puts a registered client in an event's room and returns the last
+// broadcast_seq of the event its shard delivered, for the client to count
+// that event's seat updates from
+func (h *Hub) join(client *Client, eventID string) (int64, error) {
+	var seq int64
+	err := errNotRegistered
+	s := h.shardFor(client)
+	s.do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.clients[client] {
+			return
+		}
+		if err = h.joinLocked(s, client, eventID); err == nil {
+			seq = s.delivered[eventID]
+		}
+	})
+	return seq, err
+}
+
+// leave takes client out of an event's room, reporting whether it was in it
+func (h *Hub) leave(client *Client, eventID string) bool {
+	var left bool
+	s := h.shardFor(client)
+	s.do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		left = h.leaveLocked(s, client, eventID)
+	})
+	return left
+}
+
+// eventOf returns the room a seat event's updates go to
+func eventOf(seatEvent shared.SeatEvent) string {
+	if seatEvent.EventID == "" {
+		return shared.DefaultEventID
+	}
+	return seatEvent.EventID
+}
+
+// parseRoomEventID checks the event_id of a JOIN or LEAVE
+func parseRoomEventID(eventID string) (string, error) {
+	if len(eventID) > maxEventIDLength {
+		return "", fmt.Errorf("event_id may be at most %d characters", maxEventIDLength)
+	}
+	return eventID, nil
+}
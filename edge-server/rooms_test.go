@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestSeatUpdatesOnlyReachTheirEventsRoom(t *testing.T) {
+	h := newHub()
+	mainOnly := &Client{hub: h, send: make(chan []byte, 16), id: "client-main"}
+	galaOnly := &Client{hub: h, send: make(chan []byte, 16), id: "client-gala", eventID: "gala"}
+	both := &Client{hub: h, send: make(chan []byte, 16), id: "client-both"}
+	for _, c := range []*Client{mainOnly, galaOnly, both} {
+		addClient(h, c)
+	}
+	if _, err := h.join(both, "gala"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	update := func(seatID string) shared.ServerMessage {
+		return shared.ServerMessage{Type: shared.MessageTypeSeatUpdate, Data: map[string]interface{}{"seat_id": seatID}}
+	}
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("M1"), PriorityHigh)
+	h.broadcastSeatUpdate("gala", update("G1"), PriorityHigh)
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("M2"), PriorityHigh)
+	h.broadcastWithPriority([]byte(`{"type":"ANNOUNCEMENT","data":{"seat_id":"all"}}`), PriorityHigh)
+	go h.run()
+
+	received := func(c *Client, n int) []string {
+		t.Helper()
+		var got []string
+		for len(got) < n {
+			select {
+			case raw := <-c.send:
+				var msg shared.ServerMessage
+				if err := json.Unmarshal(raw, &msg); err != nil {
+					t.Fatalf("unmarshal %s: %v", raw, err)
+				}
+				data := msg.Data.(map[string]interface{})
+				if msg.Type == shared.MessageTypeSeatUpdate {
+					got = append(got, fmt.Sprintf("%s/%s#%v", data["event_id"], data["seat_id"], data["broadcast_seq"]))
+					continue
+				}
+				got = append(got, data["seat_id"].(string))
+			case <-time.After(time.Second):
+				t.Fatalf("%s got %v, want %d messages", c.id, got, n)
+			}
+		}
+		select {
+		case raw := <-c.send:
+			t.Fatalf("%s got %v and then %s", c.id, got, raw)
+		case <-time.After(20 * time.Millisecond):
+		}
+		return got
+	}
+	// Each event numbers its own updates
+	assertMessages(t, mainOnly.id, received(mainOnly, 3), "main/M1#1", "main/M2#2", "all")
+	assertMessages(t, galaOnly.id, received(galaOnly, 2), "gala/G1#1", "all")
+	assertMessages(t, both.id, received(both, 4), "main/M1#1", "gala/G1#1", "main/M2#2", "all")
+}
+
+func assertMessages(t *testing.T, clientID string, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s got %v, want %v", clientID, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s got %v, want %v", clientID, got, want)
+		}
+	}
+}
+
+func TestJoinAndLeaveAnEvent(t *testing.T) {
+	th := newTestHarness(t)
+	client, conn := th.connectAs("client-rooms", 16, PermissionViewer)
+
+	response := func(msgType string, n int) map[string]interface{} {
+		t.Helper()
+		var responses []shared.ServerMessage
+		eventually(t, func() bool {
+			responses = responses[:0]
+			for _, msg := range conn.messages(t) {
+				if msg.Type == msgType {
+					responses = append(responses, msg)
+				}
+			}
+			return len(responses) >= n
+		}, "expected "+msgType)
+		return responses[n-1].Data.(map[string]interface{})
+	}
+
+	conn.sendJSON(t, shared.MessageTypeJoin, map[string]interface{}{})
+	if r := response("JOIN_RESPONSE", 1); r["success"] != false {
+		t.Fatalf("JOIN without event_id = %v, want refused", r)
+	}
+
+	shard := th.hub.shardFor(client)
+	shard.do(func() { shard.delivered["gala"] = 7 })
+	conn.sendJSON(t, shared.MessageTypeJoin, map[string]interface{}{"event_id": "gala"})
+	r := response("JOIN_RESPONSE", 2)
+	data, _ := r["data"].(map[string]interface{})
+	if r["success"] != true || data["event_id"] != "gala" || data["broadcast_seq"] != float64(7) {
+		t.Fatalf("JOIN = %v, want gala joined at broadcast_seq 7", r)
+	}
+	var joined bool
+	shard.do(func() { joined = shard.rooms["gala"][client] })
+	if !joined {
+		t.Fatal("client is not in the gala room")
+	}
+
+	conn.sendJSON(t, shared.MessageTypeLeave, map[string]interface{}{"event_id": "gala"})
+	if r := response("LEAVE_RESPONSE", 1); r["success"] != true {
+		t.Fatalf("LEAVE = %v, want success", r)
+	}
+	conn.sendJSON(t, shared.MessageTypeLeave, map[string]interface{}{"event_id": "gala"})
+	if r := response("LEAVE_RESPONSE", 2); r["success"] != false {
+		t.Fatalf("second LEAVE = %v, want refused", r)
+	}
+}
+
+func TestRoomsWithoutSeatUpdatesAreDroppedWhenEmpty(t *testing.T) {
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-rooms"}
+	addClient(h, client)
+
+	for _, eventID := range []string{"quiet", "busy"} {
+		if _, err := h.join(client, eventID); err != nil {
+			t.Fatalf("join %s: %v", eventID, err)
+		}
+	}
+	h.room("busy").seq.Add(1)
+	h.leave(client, "quiet")
+	h.leave(client, "busy")
+
+	if _, ok := h.rooms["quiet"]; ok {
+		t.Error("an empty room that never broadcast was kept")
+	}
+	if _, ok := h.rooms["busy"]; !ok {
+		t.Error("an empty room was dropped along with its broadcast_seq")
+	}
+}
+
+func TestClientsFollowALimitedNumberOfEvents(t *testing.T) {
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-greedy"}
+	addClient(h, client)
+
+	for i := 1; i < maxRoomsPerClient; i++ {
+		if _, err := h.join(client, fmt.Sprintf("event-%d", i)); err != nil {
+			t.Fatalf("join %d: %v", i, err)
+		}
+	}
+	if _, err := h.join(client, "one-too-many"); err != errTooManyRooms {
+		t.Fatalf("join past the limit = %v, want %v", err, errTooManyRooms)
+	}
+	if _, err := h.join(client, shared.DefaultEventID); err != nil {
+		t.Errorf("joining a room the client is in = %v, want nil", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"concert-booking/shared"
+)
+
+// Most sections, rows and seat IDs one subscription filter may list in all
+const maxFilterEntries = 1000
+
+// seatFilter is the part of the venue a client subscribed to: a seat is in it
+// if its section, its row or its ID is listed. Seat updates about no seat in
+// it are not sent to the client.
+type seatFilter struct {
+	sections map[string]bool
+	rows     map[int]bool
+	seatIDs  map[string]bool
+}
+
+// parseSeatFilter builds the filter of a SUBSCRIBE. A filter listing
+// nothing is nil: the client gets every seat update.
+func parseSeatFilter(data *shared.SeatFilterPayload) (*seatFilter, error) {
+	if data == nil {
+		return nil, nil
+	}
+	entries := len(data.Sections) + len(data.Rows) + len(data.SeatIDs)
+	if entries == 0 {
+		return nil, nil
+	}
+	if entries > maxFilterEntries {
+		return nil, fmt.Errorf("filter may list at most %d sections, rows and seats", maxFilterEntries)
+	}
+
+	f := &seatFilter{sections: map[string]bool{}, rows: map[int]bool{}, seatIDs: map[string]bool{}}
+	for _, section := range data.Sections {
+		f.sections[section] = true
+	}
+	for _, row := range data.Rows {
+		f.rows[row] = true
+	}
+	for _, seatID := range data.SeatIDs {
+		f.seatIDs[seatID] = true
+	}
+	return f, nil
+}
+
+// matches reports whether any of seats is in the filter. A nil filter
+// matches everything, as does an update whose seats are not known.
+func (f *seatFilter) matches(seats []shared.Seat) bool {
+	if f == nil || seats == nil {
+		return true
+	}
+	for _, seat := range seats {
+		if f.seatIDs[seat.ID] || f.sections[seat.Section] || f.rows[seat.Row] {
+			return true
+		}
+	}
+	return false
+}
+
+// wants reports whether a client is sent a broadcast: anything but a seat
+// update, or a seat update about a seat in its filter
+func (c *Client) wants(message broadcast) bool {
+	return message.seatUpdate == nil || c.filter.Load().matches(message.seats)
+}
+
+// updatedSeats returns the seats a seat event is about, for routing it to
+// filtered clients, or nil if any of them is not known
+func updatedSeats(seatEvent shared.SeatEvent) []shared.Seat {
+	events := seatEvent.Unbatch()
+	seats := make([]shared.Seat, 0, len(events))
+	for _, event := range events {
+		if event.Seat != nil {
+			seats = append(seats, *event.Seat)
+			continue
+		}
+		seat, _, ok := venueCache.Seat(event.SeatID)
+		if !ok {
+			return nil
+		}
+		seats = append(seats, seat)
+	}
+	return seats
+}
+
+// summary counts what a filter lists, for SUBSCRIBE_ACK
+func (f *seatFilter) summary() map[string]int {
+	return map[string]int{"sections": len(f.sections), "rows": len(f.rows), "seat_ids": len(f.seatIDs)}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestParseSeatFilter(t *testing.T) {
+	filterOf := func(raw string) (*seatFilter, error) {
+		var data shared.SubscribePayload
+		if err := shared.DecodePayload(json.RawMessage(`{"filter": `+raw+`}`), &data); err != nil {
+			return nil, err
+		}
+		return parseSeatFilter(data.Filter)
+	}
+
+	f, err := filterOf(`{"sections": ["floor"], "rows": [0, 3], "seat_ids": ["K7"]}`)
+	if err != nil {
+		t.Fatalf("parseSeatFilter: %v", err)
+	}
+	for _, tc := range []struct {
+		seat shared.Seat
+		want bool
+	}{
+		{shared.Seat{ID: "F1", Section: "floor", Row: 5}, true},
+		{shared.Seat{ID: "D2", Section: "balcony", Row: 3}, true},
+		{shared.Seat{ID: "K7", Section: "balcony", Row: 10}, true},
+		{shared.Seat{ID: "K8", Section: "balcony", Row: 10}, false},
+	} {
+		if got := f.matches([]shared.Seat{tc.seat}); got != tc.want {
+			t.Errorf("matches(%+v) = %v, want %v", tc.seat, got, tc.want)
+		}
+	}
+	if !f.matches(nil) {
+		t.Error("an update about unknown seats was filtered out")
+	}
+
+	if f, err := filterOf(`{"sections": []}`); f != nil || err != nil {
+		t.Errorf("empty filter = %v, %v, want no filter", f, err)
+	}
+	seatIDs := make([]string, maxFilterEntries+1)
+	for i := range seatIDs {
+		seatIDs[i] = fmt.Sprintf("S%d", i)
+	}
+	tooLong, _ := json.Marshal(map[string][]string{"seat_ids": seatIDs})
+	for name, bad := range map[string]string{
+		"not an object": `"floor"`,
+		"not a list":    `{"sections": "floor"}`,
+		"bad row":       `{"rows": [1.5]}`,
+		"empty seat ID": `{"seat_ids": [""]}`,
+		"too long":      string(tooLong),
+	} {
+		if _, err := filterOf(bad); err == nil {
+			t.Errorf("%s: filter %s accepted", name, bad)
+		}
+	}
+}
+
+func TestSeatUpdatesOnlyReachClientsFilteringThemIn(t *testing.T) {
+	h := newHub()
+	everything := &Client{hub: h, send: make(chan []byte, 16), id: "client-all"}
+	floor := &Client{hub: h, send: make(chan []byte, 16), id: "client-floor"}
+	floor.filter.Store(&seatFilter{sections: map[string]bool{"floor": true}})
+	addClient(h, everything)
+	addClient(h, floor)
+
+	update := func(seatID string) shared.ServerMessage {
+		return shared.ServerMessage{Type: shared.MessageTypeSeatUpdate, Data: map[string]interface{}{"seat_id": seatID}}
+	}
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("B1"), PriorityHigh, shared.Seat{ID: "B1", Section: "balcony"})
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("F1"), PriorityHigh, shared.Seat{ID: "F1", Section: "floor"})
+	h.broadcastSeatUpdate(shared.DefaultEventID, update("X1"), PriorityHigh) // seat not known: everyone gets it
+	h.broadcastWithPriority([]byte("announcement"), PriorityHigh)
+	go h.run()
+
+	received := func(c *Client, n int) []string {
+		var got []string
+		for len(got) < n {
+			select {
+			case raw := <-c.send:
+				var msg shared.ServerMessage
+				if json.Unmarshal(raw, &msg) != nil {
+					got = append(got, string(raw))
+					continue
+				}
+				got = append(got, msg.Data.(map[string]interface{})["seat_id"].(string))
+			case <-time.After(time.Second):
+				t.Fatalf("%s got %v, want %d messages", c.id, got, n)
+			}
+		}
+		return got
+	}
+	if got := received(everything, 4); len(got) != 4 {
+		t.Fatalf("unfiltered client got %v", got)
+	}
+	if got := received(floor, 3); got[0] != "F1" || got[1] != "X1" || got[2] != "announcement" {
+		t.Fatalf("floor client got %v, want F1, X1 and the announcement", got)
+	}
+	if n := h.GetStats().FilteredMessages; n != 1 {
+		t.Errorf("FilteredMessages = %d, want 1", n)
+	}
+}
+
+func TestSubscribeWithAFilterAcknowledgesIt(t *testing.T) {
+	th := newTestHarness(t)
+	client, conn := th.connect("client-filter", 16)
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{
+		"user_id": "user-1",
+		"filter":  map[string]interface{}{"sections": []string{"floor", "balcony"}, "rows": []int{2}},
+	})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "SUBSCRIBE_ACK")
+		return err == nil
+	}, "expected SUBSCRIBE_ACK")
+
+	ack, _ := findMessage(conn.messages(t), "SUBSCRIBE_ACK")
+	data, _ := ack.Data.(map[string]interface{})["data"].(map[string]interface{})
+	filter, _ := data["filter"].(map[string]interface{})
+	if filter["sections"] != float64(2) || filter["rows"] != float64(1) {
+		t.Fatalf("SUBSCRIBE_ACK filter = %v, want 2 sections and 1 row", data["filter"])
+	}
+	if f := client.filter.Load(); f == nil || !f.sections["balcony"] || !f.rows[2] {
+		t.Fatalf("client filter = %+v", f)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "log/slog"
+
+// seatWatcherBuffer is how many seat updates a watcher may fall behind by
+// before it is dropped
+const seatWatcherBuffer = 256
+
+// seatWatcher reads an event's seat updates off the hub as they go out to its
+// room, stamped as clients get them, without being a client itself: GraphQL
+// subscriptions are fed this way. updates is closed when the watcher fell
+// behind and was dropped.
+type seatWatcher struct {
+	eventID string
+	updates chan []byte
+}
+
+// watchSeats starts handing the seat updates of an event to a new watcher
+func (h *Hub) watchSeats(eventID string) *seatWatcher {
+	w := &seatWatcher{eventID: eventID, updates: make(chan []byte, seatWatcherBuffer)}
+
+	h.watchersMu.Lock()
+	defer h.watchersMu.Unlock()
+	if h.seatWatchers == nil {
+		h.seatWatchers = make(map[*seatWatcher]bool)
+	}
+	h.seatWatchers[w] = true
+	return w
+}
+
+// unwatchSeats stops handing seat updates to w
+func (h *Hub) unwatchSeats(w *seatWatcher) {
+	h.watchersMu.Lock()
+	delete(h.seatWatchers, w)
+	h.watchersMu.Unlock()
+}
+
+// notifySeatWatchers hands a stamped seat update to the watchers of its
+// event, dropping those whose buffer is full. Only the hub's run loop calls
+// it, so watchers get updates in broadcast_seq order.
+func (h *Hub) notifySeatWatchers(message broadcast) {
+	h.watchersMu.Lock()
+	defer h.watchersMu.Unlock()
+	for w := range h.seatWatchers {
+		if w.eventID != message.room {
+			continue
+		}
+		select {
+		case w.updates <- message.message:
+		default:
+			slog.Warn("Dropped a seat watcher that fell behind", "event_id", w.eventID)
+			delete(h.seatWatchers, w)
+			close(w.updates)
+		}
+	}
+}
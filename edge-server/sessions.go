@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// How long a client may be disconnected and still resume its session, unless
+// EDGE_SESSION_GRACE says otherwise
+const defaultSessionGrace = 2 * time.Minute
+
+// resumableSession is what a client reconnecting with its session token gets
+// back: its client ID, the user it subscribed as and whether it passed the bot
+// challenge
+type resumableSession struct {
+	clientID        string
+	userID          string
+	challengePassed bool
+
+	// Zero while a connection holds the session, its grace period's end
+	// once that connection has gone
+	lapses time.Time
+}
+
+// sessionGraceFromEnv reads EDGE_SESSION_GRACE; 0 gives every connection a
+// fresh session
+func sessionGraceFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_SESSION_GRACE")
+	if raw == "" {
+		return defaultSessionGrace, nil
+	}
+	grace, err := time.ParseDuration(raw)
+	if err != nil || grace < 0 {
+		return 0, fmt.Errorf("want a duration, got %q", raw)
+	}
+	return grace, nil
+}
+
+// startSession gives a connecting client its session: the one token names,
+// if it was left within its grace period and no other connection resumed it,
+// or else a new one. A resumed session keeps its client ID.
+func (h *Hub) startSession(client *Client, token string, now time.Time) {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*resumableSession)
+	}
+	for t, session := range h.sessions {
+		if !session.lapses.IsZero() && now.After(session.lapses) {
+			delete(h.sessions, t)
+		}
+	}
+
+	if session, ok := h.sessions[token]; ok && !session.lapses.IsZero() {
+		session.lapses = time.Time{}
+		client.id = session.clientID
+		client.resumedUserID = session.userID
+		client.challengePassed.Store(session.challengePassed)
+		client.sessionToken = token
+		return
+	}
+	client.sessionToken = uuid.NewString()
+	h.sessions[client.sessionToken] = &resumableSession{clientID: client.id}
+}
+
+// leaveSession keeps a departing client's session for the grace period, with
+// what it should resume
+func (h *Hub) leaveSession(client *Client) {
+	userID := client.user()
+
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	session, ok := h.sessions[client.sessionToken]
+	if !ok {
+		return
+	}
+	if h.sessionGrace == 0 {
+		delete(h.sessions, client.sessionToken)
+		return
+	}
+	if userID != "" {
+		session.userID = userID
+	}
+	session.challengePassed = client.challengePassed.Load()
+	session.lapses = time.Now().Add(h.sessionGrace)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestReconnectingWithTheSessionTokenResumesTheSession(t *testing.T) {
+	th := newTestHarness(t)
+	connect := func(token string) (*Client, *fakeConn) {
+		t.Helper()
+		client := newClient(th.hub, newFakeConn(), generateClientID(), 16)
+		client.permission = PermissionBuyer
+		th.hub.startSession(client, token, time.Now())
+		return client, th.start(client)
+	}
+	welcomeToken := func(conn *fakeConn) string {
+		t.Helper()
+		var token string
+		eventually(t, func() bool {
+			welcome, err := findMessage(conn.messages(t), "WELCOME")
+			if err != nil {
+				return false
+			}
+			var data struct {
+				SessionToken string `json:"session_token"`
+			}
+			raw, _ := json.Marshal(welcome.Data)
+			json.Unmarshal(raw, &data)
+			token = data.SessionToken
+			return true
+		}, "no WELCOME")
+		return token
+	}
+	subscribedAs := func(conn *fakeConn, userID string) {
+		t.Helper()
+		eventually(t, func() bool {
+			ack, err := findMessage(conn.messages(t), "SUBSCRIBE_ACK")
+			if err != nil {
+				return false
+			}
+			raw, _ := json.Marshal(ack.Data)
+			var resp struct {
+				Data struct {
+					UserID string `json:"user_id"`
+				} `json:"data"`
+			}
+			json.Unmarshal(raw, &resp)
+			return resp.Data.UserID == userID
+		}, "not subscribed as "+userID)
+	}
+
+	first, firstConn := connect("")
+	token := welcomeToken(firstConn)
+	if token == "" || first.sessionToken != token {
+		t.Fatalf("WELCOME session_token = %q, want the client's %q", token, first.sessionToken)
+	}
+	first.challengePassed.Store(true)
+	firstConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1"})
+	subscribedAs(firstConn, "user-1")
+
+	// A session in use cannot be taken over
+	other, _ := connect(token)
+	if other.sessionToken == token || other.id == first.id || other.challengePassed.Load() {
+		t.Errorf("connection with a session in use got it: %s %s", other.id, other.sessionToken)
+	}
+
+	firstConn.Close()
+	eventually(t, func() bool { return !th.isRegistered(first) }, "first connection still registered")
+	second, secondConn := connect(token)
+	if second.id != first.id || second.sessionToken != token || !second.challengePassed.Load() {
+		t.Errorf("resumed client = %s %s challenge %v, want %s's session", second.id, second.sessionToken, second.challengePassed.Load(), first.id)
+	}
+	if welcomeToken(secondConn) != token {
+		t.Error("resumed session got a new token")
+	}
+	secondConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{})
+	subscribedAs(secondConn, "user-1")
+
+	// Once its grace period is over a session is gone
+	secondConn.Close()
+	eventually(t, func() bool { return !th.isRegistered(second) }, "second connection still registered")
+	late := newClient(th.hub, newFakeConn(), generateClientID(), 16)
+	th.hub.startSession(late, token, time.Now().Add(defaultSessionGrace+time.Second))
+	if late.sessionToken == token || late.id == first.id {
+		t.Errorf("client after the grace period resumed the session: %s", late.id)
+	}
+}
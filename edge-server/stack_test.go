@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+	"concert-booking/shared/fixtures"
+
+	"github.com/gorilla/websocket"
+)
+
+// testStack is this edge wired as main wires it, in process: seat events come
+// over an in-memory bus from the fixtures booking service, and browsers
+// connect over real WebSockets. It runs the whole path of the protocol, from
+// a browser's command to every browser's update, without Docker.
+type testStack struct {
+	t       *testing.T
+	url     string // WebSocket URL
+	bus     *fixtures.EventBus
+	booking *fixtures.BookingService
+}
+
+func newTestStack(t *testing.T, seats []shared.Seat) *testStack {
+	t.Helper()
+
+	// The booking service's seat events are lost on the way at the chance
+	// shared.SetFaults gives, as they may be on NATS
+	bus := fixtures.NewEventBus()
+	booking := fixtures.NewBookingService(t, shared.WithFaults(bus), seats)
+
+	prevHub, prevClient, prevCache, prevCoalescer, prevSequencer := hub, bookingClient, venueCache, coalescer, sequencer
+	client := NewBookingClient(booking.URL)
+	client.retry = fastRetries
+	bookingClient = client
+	venueCache = NewVenueCache()
+	hub = newHub()
+	go hub.run()
+	coalescer = newSeatCoalescer(hub, 0)
+	sequencer = NewEventSequencer(broadcastSeatEvent, reconcileVenueState)
+	sequenced := make(chan struct{})
+	go func() {
+		sequencer.Run()
+		close(sequenced)
+	}()
+
+	sub, err := subscribeToSeatEvents(bus)
+	if err != nil {
+		t.Fatalf("subscribe to seat events: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	t.Cleanup(func() {
+		srv.Close()
+		sub.Unsubscribe()
+		close(sequencer.incoming)
+		// Events it is still handling read the globals put back below
+		<-sequenced
+		hub, bookingClient, venueCache, coalescer, sequencer = prevHub, prevClient, prevCache, prevCoalescer, prevSequencer
+	})
+
+	return &testStack{t: t, url: "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws", bus: bus, booking: booking}
+}
+
+// stackBrowser is a browser connected to a testStack
+type stackBrowser struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	queued []shared.ServerMessage // read but not yet awaited
+	sent   int
+}
+
+// connect opens a browser's connection and subscribes it as userID, returning
+// once it has its seat map
+func (s *testStack) connect(userID string) *stackBrowser {
+	s.t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.url, nil)
+	if err != nil {
+		s.t.Fatalf("dial: %v", err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	b := &stackBrowser{t: s.t, conn: conn}
+	if ack := b.request(shared.MessageTypeSubscribe, map[string]interface{}{"user_id": userID}); !responseSucceeded(ack) {
+		s.t.Fatalf("subscribe as %s: %+v", userID, ack.Data)
+	}
+	b.await(shared.MessageTypeVenueState, nil)
+	return b
+}
+
+// request sends a command and returns its response
+func (b *stackBrowser) request(msgType string, data map[string]interface{}) shared.ServerMessage {
+	b.t.Helper()
+
+	b.sent++
+	requestID := msgType + "-" + strconv.Itoa(b.sent)
+	raw, _ := json.Marshal(data)
+	if err := b.conn.WriteJSON(shared.ClientMessage{Type: msgType, RequestID: requestID, Data: raw}); err != nil {
+		b.t.Fatalf("send %s: %v", msgType, err)
+	}
+	return b.await("", func(m shared.ServerMessage) bool { return m.RequestID == requestID })
+}
+
+// await returns the first message of msgType (any type if "") that match
+// accepts (any if nil), keeping the others for later calls
+func (b *stackBrowser) await(msgType string, match func(shared.ServerMessage) bool) shared.ServerMessage {
+	b.t.Helper()
+
+	accepts := func(m shared.ServerMessage) bool {
+		return (msgType == "" || m.Type == msgType) && (match == nil || match(m))
+	}
+	for i, m := range b.queued {
+		if accepts(m) {
+			b.queued = append(b.queued[:i], b.queued[i+1:]...)
+			return m
+		}
+	}
+	b.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, frame, err := b.conn.ReadMessage()
+		if err != nil {
+			b.t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		// Frames may batch several messages, one per line
+		var found *shared.ServerMessage
+		for _, line := range bytes.Split(frame, []byte{'\n'}) {
+			var m shared.ServerMessage
+			if err := json.Unmarshal(line, &m); err != nil {
+				b.t.Fatalf("edge wrote invalid JSON %q: %v", line, err)
+			}
+			if found == nil && accepts(m) {
+				found = &m
+				continue
+			}
+			b.queued = append(b.queued, m)
+		}
+		if found != nil {
+			return *found
+		}
+	}
+}
+
+// awaitSeat returns the next SEAT_UPDATE for seatID
+func (b *stackBrowser) awaitSeat(seatID string) map[string]interface{} {
+	b.t.Helper()
+	m := b.await(shared.MessageTypeSeatUpdate, func(m shared.ServerMessage) bool {
+		data, _ := m.Data.(map[string]interface{})
+		return data["seat_id"] == seatID
+	})
+	return m.Data.(map[string]interface{})
+}
+
+func responseSucceeded(m shared.ServerMessage) bool {
+	data, _ := m.Data.(map[string]interface{})
+	success, _ := data["success"].(bool)
+	return success
+}
+
+func TestStackCarriesASeatFromHoldToBookingToEveryBuyer(t *testing.T) {
+	stack := newTestStack(t, fixtures.Venue().Section("floor", 1, 2).Seats())
+	seatA, seatB := shared.GetSeatID(0, 0), shared.GetSeatID(0, 1)
+	alice, bob := stack.connect("alice"), stack.connect("bob")
+
+	if resp := alice.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seatA}); !responseSucceeded(resp) {
+		t.Fatalf("alice's select: %+v", resp.Data)
+	}
+	if update := bob.awaitSeat(seatA); update["status"] != shared.SeatHeld.String() || update["user_id"] != "alice" {
+		t.Fatalf("bob saw %+v, want alice's hold", update)
+	}
+
+	resp := bob.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seatA})
+	if data, _ := resp.Data.(map[string]interface{}); responseSucceeded(resp) || data["code"] != string(shared.ErrorCodeSeatHeldByOther) {
+		t.Fatalf("bob's select of a held seat: %+v", resp.Data)
+	}
+
+	if resp := alice.request(shared.MessageTypeBookSeat, map[string]interface{}{"seat_id": seatA}); !responseSucceeded(resp) {
+		t.Fatalf("alice's booking: %+v", resp.Data)
+	}
+	if update := bob.awaitSeat(seatA); update["status"] != shared.SeatBooked.String() {
+		t.Fatalf("bob saw %+v, want the booking", update)
+	}
+
+	// A hold running out reaches buyers as its release
+	if resp := bob.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seatB}); !responseSucceeded(resp) {
+		t.Fatalf("bob's select: %+v", resp.Data)
+	}
+	alice.awaitSeat(seatB)
+	if !stack.booking.Expire(seatB) {
+		t.Fatal("bob's hold was not there to expire")
+	}
+	if update := alice.awaitSeat(seatB); update["status"] != shared.SeatAvailable.String() || update["event_type"] != "auto_released" {
+		t.Fatalf("alice saw %+v, want the hold's release", update)
+	}
+
+	if seat := stack.booking.Seat(seatA); seat.Status != shared.SeatBooked || seat.HeldBy != "alice" {
+		t.Errorf("booked seat = %+v", seat)
+	}
+	if published := stack.bus.Published(shared.SeatSubjectFilter("", "", "", "")); len(published) != 4 {
+		t.Errorf("%d seat events published, want held, booked, held and auto_released", len(published))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"concert-booking/shared"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var wsTracer = otel.Tracer("concert-booking/edge-server")
+
+// startSeatSpan starts the root span of a seat message from c, under a new
+// correlation ID. The booking client forwards both, so the booking service's
+// work joins the trace and its logs carry the same ID.
+func (c *Client) startSeatSpan(msgType, seatID, userID string) (context.Context, trace.Span) {
+	ctx := shared.WithCorrelationID(context.Background(), shared.NewCorrelationID())
+	return wsTracer.Start(ctx, "ws "+msgType,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			shared.AttrSeatID.String(seatID),
+			shared.AttrUserID.String(userID),
+			shared.AttrClientID.String(c.id),
+		))
+}
+
+// endSpan ends span, marking it failed if err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
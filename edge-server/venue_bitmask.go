@@ -0,0 +1,18 @@
+package main
+
+import "concert-booking/shared"
+
+// hasLayout reports whether the client asked for venue state as a bitmask and
+// has layout drawn
+func (c *Client) hasLayout(layout string) bool {
+	known := c.bitmaskLayout.Load()
+	return known != nil && *known != "" && *known == layout
+}
+
+// venueBitmask packs state for VENUE_STATE_BITMASK
+func venueBitmask(state shared.VenueState) shared.BitmaskVenueState {
+	bitmask := shared.EncodeVenueBitmask(state.Seats)
+	bitmask.Event, bitmask.Flags, bitmask.Degraded = state.Event, state.Flags, state.Degraded
+	bitmask.VenueVersion = state.VenueVersion
+	return bitmask
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestBitmaskSubscribersGetTheLayoutOnceThenBitmasks(t *testing.T) {
+	th := newTestHarness(t)
+	_, first := th.connect("client-first", 64)
+
+	// Without the layout the client is sent it, with its ID
+	first.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1", "format": shared.VenueFormatBitmask})
+	var state shared.VenueState
+	eventually(t, func() bool {
+		m, err := findMessage(first.messages(t), shared.MessageTypeVenueState)
+		if err != nil {
+			return false
+		}
+		raw, _ := json.Marshal(m.Data)
+		return json.Unmarshal(raw, &state) == nil
+	}, "expected a VENUE_STATE")
+	if state.Layout != shared.VenueLayoutID(state.Seats) {
+		t.Fatalf("VENUE_STATE layout = %q, want the ID of its seats", state.Layout)
+	}
+
+	// Reconnecting with it, the client gets the bitmask instead
+	_, second := th.connect("client-second", 64)
+	second.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{
+		"user_id": "user-1", "format": shared.VenueFormatBitmask, "layout": state.Layout,
+	})
+	var bitmask shared.BitmaskVenueState
+	eventually(t, func() bool {
+		m, err := findMessage(second.messages(t), shared.MessageTypeVenueStateBitmask)
+		if err != nil {
+			return false
+		}
+		raw, _ := json.Marshal(m.Data)
+		return json.Unmarshal(raw, &bitmask) == nil
+	}, "expected a VENUE_STATE_BITMASK")
+	if _, err := findMessage(second.messages(t), shared.MessageTypeVenueState); err == nil {
+		t.Error("a client with the layout was also sent VENUE_STATE")
+	}
+	if seats, err := bitmask.Apply(state.Seats); err != nil || len(seats) != len(state.Seats) {
+		t.Errorf("Apply = %v, %v", seats, err)
+	}
+
+	// A corrected venue with the same layout is a bitmask for it too
+	corrected := shared.VenueState{Seats: []shared.Seat{{ID: "A1", Status: shared.SeatBooked}}}
+	corrected.Layout = shared.VenueLayoutID(corrected.Seats)
+	bitmaskJSON, _ := json.Marshal(shared.ServerMessage{Type: shared.MessageTypeVenueStateBitmask, Data: venueBitmask(corrected)})
+	before := len(second.messages(t))
+	th.hub.broadcastVenueState([]byte(`{"type":"VENUE_STATE","data":{"seats":[]}}`), nil, bitmaskJSON, corrected.Layout)
+	eventually(t, func() bool { return len(second.messages(t)) > before }, "expected the corrected venue")
+	if msgs := second.messages(t); msgs[len(msgs)-1].Type != shared.MessageTypeVenueStateBitmask {
+		t.Errorf("corrected venue sent to a bitmask client as %s", msgs[len(msgs)-1].Type)
+	}
+}
+
+func TestBitmaskSubscriberWithAStaleLayoutGetsVenueState(t *testing.T) {
+	th := newTestHarness(t)
+	_, conn := th.connect("client-stale", 64)
+
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{
+		"user_id": "user-1", "format": shared.VenueFormatBitmask, "layout": "stale",
+	})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+		return err == nil
+	}, "expected a VENUE_STATE")
+	if _, err := findMessage(conn.messages(t), shared.MessageTypeVenueStateBitmask); err == nil {
+		t.Error("a bitmask was sent for a layout the client does not have")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// How often the edge refreshes its venue cache from the booking service, unless
+// EDGE_VENUE_REFRESH says otherwise
+const defaultVenueRefresh = 30 * time.Second
+
+// Shown to clients whose seat command could not reach the booking service
+const degradedMessage = "Booking is temporarily unavailable, please try again shortly"
+
+// venueCache is the seat map VENUE_STATE is served from, and served read-only
+// while the booking service is down
+var venueCache = NewVenueCache()
+
+// VenueCache is the edge's own copy of the seat map, kept current from seat
+// events and periodic snapshots, so subscribing clients need not each fetch the
+// venue from the booking service, and can still be shown it while the booking
+// service is unreachable
+type VenueCache struct {
+	mu      sync.RWMutex
+	seats   []shared.Seat
+	index   map[string]int // seat ID -> position in seats
+	seq     int64          // latest seat event sequence number reflected
+	current bool           // false once seat events may have been missed
+	updated time.Time
+	counts  shared.SeatCounts
+
+	// When each seat last changed, as a seat event sequence number, for
+	// serving SYNC. Changes since base are all recorded; older ones are not.
+	changedAt map[string]int64
+	base      int64
+
+	event       *shared.EventInfo
+	eventLoaded bool
+}
+
+// NewVenueCache returns an empty cache
+func NewVenueCache() *VenueCache {
+	return &VenueCache{index: make(map[string]int), changedAt: make(map[string]int64)}
+}
+
+// Replace loads a snapshot of the venue taken at seq and marks the cache
+// current. Seats the cache already holds at a newer version, from events that
+// overtook the snapshot, are kept. It returns how many seats the snapshot
+// corrected, i.e. how far the cache had drifted.
+func (vc *VenueCache) Replace(seats []shared.Seat, seq int64) (corrected int) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	next := make([]shared.Seat, len(seats))
+	index := make(map[string]int, len(seats))
+	var counts shared.SeatCounts
+	var correctedIDs []string
+	sameLayout := len(seats) == len(vc.seats)
+	for i, seat := range seats {
+		if j, ok := vc.index[seat.ID]; ok {
+			if vc.seats[j].Version > seat.Version {
+				seat = vc.seats[j]
+			} else if vc.seats[j].Version < seat.Version {
+				corrected++
+				correctedIDs = append(correctedIDs, seat.ID)
+			}
+		} else {
+			sameLayout = false
+		}
+		next[i] = seat
+		index[seat.ID] = i
+		counts.Add(seat.Status)
+	}
+	vc.seats = next
+	vc.index = index
+	vc.counts = counts
+	vc.seq = max(vc.seq, seq)
+
+	// Seats added or removed cannot be sent as changes, so SYNC starts over;
+	// corrected seats are sent as changed at the snapshot
+	if !sameLayout {
+		vc.changedAt = make(map[string]int64)
+		vc.base = vc.seq
+	}
+	for _, id := range correctedIDs {
+		vc.changedAt[id] = vc.seq
+	}
+	vc.current = true
+	vc.updated = time.Now()
+	return corrected
+}
+
+// Invalidate marks the cache as no longer current, when the edge may miss
+// seat events. Fresh fails until the next Replace; the stale seats are still
+// served while the booking service is down.
+func (vc *VenueCache) Invalidate() {
+	vc.mu.Lock()
+	vc.current = false
+	vc.mu.Unlock()
+}
+
+// Apply updates the seat a seat event is about, unless the cache already holds
+// it at that version or newer. Events for seats not yet loaded are ignored; the
+// next snapshot brings them in.
+func (vc *VenueCache) Apply(event shared.SeatEvent) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	i, ok := vc.index[event.SeatID]
+	if !ok || vc.seats[i].Version >= event.Version {
+		return
+	}
+	previous := vc.seats[i].Status
+	if event.Seat != nil {
+		vc.seats[i] = *event.Seat
+	} else {
+		seat := &vc.seats[i]
+		seat.Status = event.Status
+		seat.HeldBy = ""
+		if event.Status == shared.SeatHeld {
+			seat.HeldBy = event.UserID
+		}
+		seat.ExpiresAt = event.ExpiresAt
+		seat.Version = event.Version
+	}
+	vc.counts.Move(previous, vc.seats[i].Status)
+	vc.changedAt[event.SeatID] = event.Seq
+	if event.Seq == 0 {
+		// Unsequenced events count as the next change
+		vc.changedAt[event.SeatID] = vc.seq + 1
+	}
+	shared.Invariant(vc.counts.Consistent(), "seat_counts_consistent", "counts", vc.counts, shared.LogKeySeatID, event.SeatID)
+	vc.seq = max(vc.seq, event.Seq)
+	vc.updated = time.Now()
+}
+
+// SetEvent remembers the event's branding to show with the cached seats
+func (vc *VenueCache) SetEvent(event *shared.EventInfo) {
+	vc.mu.Lock()
+	vc.event = event
+	vc.eventLoaded = true
+	vc.mu.Unlock()
+}
+
+// Event returns the last event branding the booking service served; ok is
+// false if it has not served any yet
+func (vc *VenueCache) Event() (event *shared.EventInfo, ok bool) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+	return vc.event, vc.eventLoaded
+}
+
+// Fresh returns the cached seats and the seat event sequence number they
+// reflect while the cache is current
+func (vc *VenueCache) Fresh() (seats []shared.Seat, seq int64, ok bool) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+
+	if !vc.current {
+		return nil, 0, false
+	}
+	return append([]shared.Seat(nil), vc.seats...), vc.seq, true
+}
+
+// Snapshot returns a copy of the cached seats and when they were last updated;
+// ok is false until a snapshot has been loaded
+func (vc *VenueCache) Snapshot() (seats []shared.Seat, updated time.Time, ok bool) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+
+	if vc.updated.IsZero() {
+		return nil, time.Time{}, false
+	}
+	return append([]shared.Seat(nil), vc.seats...), vc.updated, true
+}
+
+// Counts returns how many cached seats are in each status; ok is false until
+// a snapshot has been loaded
+func (vc *VenueCache) Counts() (counts shared.SeatCounts, ok bool) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+	return vc.counts, !vc.updated.IsZero()
+}
+
+// Seat returns a copy of one cached seat; ok is false if it is not cached
+func (vc *VenueCache) Seat(seatID string) (seat shared.Seat, updated time.Time, ok bool) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+
+	i, ok := vc.index[seatID]
+	if !ok {
+		return shared.Seat{}, time.Time{}, false
+	}
+	return vc.seats[i], vc.updated, true
+}
+
+// venueRefreshFromEnv reads EDGE_VENUE_REFRESH; 0 disables periodic refreshes
+func venueRefreshFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_VENUE_REFRESH")
+	if raw == "" {
+		return defaultVenueRefresh, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// startVenueRefresh reconciles the venue cache with the booking service every
+// interval. Seat events keep it current in between; the refresh bounds how
+// long an event lost without a sequence gap can leave it wrong.
+func startVenueRefresh(every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for range ticker.C {
+			seats, seq, err := bookingClient.GetVenueSnapshot()
+			if err != nil {
+				slog.Warn("Failed to refresh venue cache", shared.ErrAttr(err))
+				continue
+			}
+			if corrected := venueCache.Replace(seats, seq); corrected > 0 {
+				slog.Warn("Venue cache had drifted from the booking service", "seats", corrected, "seq", seq)
+			}
+			if !configWatched.Load() {
+				fetchEventInfo()
+			}
+		}
+	}()
+	slog.Info("Refreshing venue cache", "every", every)
+}
+
+// venueSnapshot returns the venue from the cache while it is current, else
+// fetches it from the booking service and loads it into the cache
+func venueSnapshot() ([]shared.Seat, int64, error) {
+	if seats, seq, ok := venueCache.Fresh(); ok {
+		return seats, seq, nil
+	}
+	seats, seq, err := bookingClient.GetVenueSnapshot()
+	if err != nil {
+		return nil, 0, err
+	}
+	venueCache.Replace(seats, seq)
+	return seats, seq, nil
+}
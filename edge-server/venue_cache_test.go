@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestVenueCacheKeepsTheNewestVersionOfEachSeat(t *testing.T) {
+	vc := NewVenueCache()
+	if _, _, ok := vc.Snapshot(); ok {
+		t.Fatal("an empty cache reported a snapshot")
+	}
+
+	vc.Replace([]shared.Seat{
+		{ID: "A1", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A2", Status: shared.SeatAvailable, Version: 1},
+	}, 10)
+	vc.Apply(shared.SeatEvent{Type: "held", SeatID: "A1", UserID: "alice", Status: shared.SeatHeld, Version: 2, ExpiresAt: 99})
+	vc.Apply(shared.SeatEvent{Type: "released", SeatID: "A1", Status: shared.SeatAvailable, Version: 2}) // stale
+	vc.Apply(shared.SeatEvent{Type: "held", SeatID: "Z9", UserID: "bob", Status: shared.SeatHeld, Version: 5})
+
+	// A snapshot fetched before the hold must not undo it
+	corrected := vc.Replace([]shared.Seat{
+		{ID: "A1", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A2", Status: shared.SeatBooked, Version: 3},
+	}, 12)
+	if corrected != 1 {
+		t.Errorf("snapshot corrected %d seats, want only A2", corrected)
+	}
+
+	seats, _, ok := vc.Snapshot()
+	if !ok || len(seats) != 2 {
+		t.Fatalf("snapshot = %+v, want A1 and A2", seats)
+	}
+	if a1 := seats[0]; a1.Status != shared.SeatHeld || a1.HeldBy != "alice" || a1.ExpiresAt != 99 || a1.Version != 2 {
+		t.Errorf("A1 = %+v, want held by alice at version 2", a1)
+	}
+	if a2 := seats[1]; a2.Status != shared.SeatBooked || a2.Version != 3 {
+		t.Errorf("A2 = %+v, want booked at version 3", a2)
+	}
+}
+
+func TestDegradedModeServesCachedVenueState(t *testing.T) {
+	th := newTestHarness(t)
+	venueCache.Replace([]shared.Seat{{ID: "A1", Status: shared.SeatBooked, Version: 4}}, 0)
+	venueCache.Invalidate() // so the edge asks the booking service first
+	th.booking.Close()
+
+	_, conn := th.connect("client-1", 16)
+	conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "alice"})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+		return err == nil
+	}, "expected a cached VENUE_STATE while the booking service is down")
+
+	msg, _ := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+	raw, _ := json.Marshal(msg.Data)
+	var state shared.VenueState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		t.Fatalf("decode venue state: %v", err)
+	}
+	if !state.Degraded || len(state.Seats) != 1 || state.Seats[0].Status != shared.SeatBooked {
+		t.Errorf("venue state = %+v, want the cached seats flagged degraded", state)
+	}
+
+	conn.sendJSON(t, shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": "A1"})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "SELECT_SEAT_RESPONSE")
+		return err == nil
+	}, "expected SELECT_SEAT_RESPONSE")
+
+	msg, _ = findMessage(conn.messages(t), "SELECT_SEAT_RESPONSE")
+	raw, _ = json.Marshal(msg.Data)
+	var resp OperationResponse
+	json.Unmarshal(raw, &resp)
+	data, _ := resp.Data.(map[string]interface{})
+	if resp.Success || resp.Message != degradedMessage || data["degraded"] != true {
+		t.Errorf("select response = %+v, want a degraded rejection", resp)
+	}
+}
+
+func TestVenueStateIsServedFromTheCacheWhileItIsCurrent(t *testing.T) {
+	th := newTestHarness(t)
+	var fetches atomic.Int32
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/seats" {
+			fetches.Add(1)
+			json.NewEncoder(w).Encode([]shared.Seat{{ID: "A1", Status: shared.SeatAvailable, Version: 1}})
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(booking.Close)
+	bookingClient = NewBookingClient(booking.URL)
+
+	subscribe := func(id string) shared.VenueState {
+		_, conn := th.connect(id, 16)
+		conn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": id})
+		eventually(t, func() bool {
+			_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+			return err == nil
+		}, "expected VENUE_STATE after SUBSCRIBE")
+		msg, _ := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+		raw, _ := json.Marshal(msg.Data)
+		var state shared.VenueState
+		json.Unmarshal(raw, &state)
+		return state
+	}
+
+	subscribe("client-1")
+	venueCache.Apply(shared.SeatEvent{Type: "held", SeatID: "A1", UserID: "alice", Status: shared.SeatHeld, Version: 2})
+	state := subscribe("client-2")
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched the venue %d times for two subscribers, want 1", n)
+	}
+	if seat := state.Seats[0]; seat.Status != shared.SeatHeld || seat.HeldBy != "alice" || state.Degraded {
+		t.Errorf("second subscriber got %+v, want A1 held by alice from the cache", state)
+	}
+
+	// Once seat events may have been missed, the next subscriber refetches
+	venueCache.Invalidate()
+	subscribe("client-3")
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetched the venue %d times after invalidation, want 2", n)
+	}
+}
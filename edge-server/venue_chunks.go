@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"concert-booking/shared"
+)
+
+// Most seats in one VENUE_STATE_CHUNK, unless EDGE_VENUE_CHUNK_SEATS says otherwise
+const defaultVenueChunkSeats = 1000
+
+// venueChunkSeats is the most seats sent in one VENUE_STATE_CHUNK
+var venueChunkSeats = defaultVenueChunkSeats
+
+// venueChunkSeatsFromEnv reads EDGE_VENUE_CHUNK_SEATS
+func venueChunkSeatsFromEnv() (int, error) {
+	raw := os.Getenv("EDGE_VENUE_CHUNK_SEATS")
+	if raw == "" {
+		return defaultVenueChunkSeats, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("want a positive number of seats, got %q", raw)
+	}
+	return n, nil
+}
+
+// chunkVenueState splits state into chunks of at most maxSeats seats, section
+// by section, so a client can draw each section as it arrives
+func chunkVenueState(state shared.VenueState, maxSeats int) []shared.VenueStateChunk {
+	seats := append([]shared.Seat(nil), state.Seats...)
+	sort.SliceStable(seats, func(i, j int) bool { return seats[i].Section < seats[j].Section })
+
+	var chunks []shared.VenueStateChunk
+	for start := 0; start < len(seats); {
+		section := seats[start].Section
+		end := start
+		for end < len(seats) && end-start < maxSeats && seats[end].Section == section {
+			end++
+		}
+		chunks = append(chunks, shared.VenueStateChunk{
+			VenueState: shared.VenueState{Seats: seats[start:end], Degraded: state.Degraded},
+			Section:    section,
+		})
+		start = end
+	}
+	if len(chunks) == 0 {
+		chunks = append(chunks, shared.VenueStateChunk{VenueState: shared.VenueState{Seats: []shared.Seat{}, Degraded: state.Degraded}})
+	}
+
+	chunks[0].Event, chunks[0].Flags, chunks[0].VenueVersion = state.Event, state.Flags, state.VenueVersion
+	for i := range chunks {
+		chunks[i].Chunk, chunks[i].Chunks = i, len(chunks)
+	}
+	return chunks
+}
+
+// venueStateMessages encodes state as the VENUE_STATE_CHUNK messages chunked
+// clients are sent
+func venueStateMessages(state shared.VenueState) ([][]byte, error) {
+	chunks := chunkVenueState(state, venueChunkSeats)
+	messages := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		data, err := json.Marshal(shared.ServerMessage{Type: shared.MessageTypeVenueStateChunk, Data: chunk})
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = data
+	}
+	return messages, nil
+}
+
+// sendVenue sends state as one VENUE_STATE, as a bitmask or in chunks if the
+// client asked for them when subscribing
+func (c *Client) sendVenue(state shared.VenueState) {
+	if state.Layout == "" {
+		state.Layout = shared.VenueLayoutID(state.Seats)
+	}
+	if c.hasLayout(state.Layout) {
+		c.sendMessage(shared.MessageTypeVenueStateBitmask, venueBitmask(state))
+		return
+	}
+	if wanted := c.bitmaskLayout.Load(); wanted != nil {
+		// It gets the layout now, and bitmasks from here on
+		c.bitmaskLayout.Store(&state.Layout)
+	}
+
+	if !c.chunkedVenue.Load() {
+		c.sendMessage(shared.MessageTypeVenueState, state)
+		return
+	}
+	for _, chunk := range chunkVenueState(state, venueChunkSeats) {
+		c.sendMessage(shared.MessageTypeVenueStateChunk, chunk)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"concert-booking/shared"
+	"concert-booking/shared/fixtures"
+)
+
+func TestChunkVenueStateSplitsBySectionAndSize(t *testing.T) {
+	state := shared.VenueState{
+		Seats: []shared.Seat{
+			{ID: "B1", Section: "balcony"}, {ID: "F1", Section: "floor"}, {ID: "B2", Section: "balcony"},
+			{ID: "F2", Section: "floor"}, {ID: "F3", Section: "floor"},
+		},
+		Event: &shared.EventInfo{Name: "Summer Tour"},
+	}
+
+	chunks := chunkVenueState(state, 2)
+	var got []string
+	for i, chunk := range chunks {
+		if chunk.Chunk != i || chunk.Chunks != 3 {
+			t.Errorf("chunk %d numbered %d of %d, want %d of 3", i, chunk.Chunk, chunk.Chunks, i)
+		}
+		if (chunk.Event != nil) != (i == 0) {
+			t.Errorf("chunk %d event = %v, want it on the first chunk only", i, chunk.Event)
+		}
+		for _, seat := range chunk.Seats {
+			if seat.Section != chunk.Section {
+				t.Errorf("chunk %d of section %s holds %s of %s", i, chunk.Section, seat.ID, seat.Section)
+			}
+			got = append(got, seat.ID)
+		}
+	}
+	if len(chunks) != 3 || len(got) != len(state.Seats) {
+		t.Fatalf("chunks = %+v, want balcony in one and floor in two", chunks)
+	}
+
+	if empty := chunkVenueState(shared.VenueState{}, 2); len(empty) != 1 || empty[0].Chunks != 1 {
+		t.Errorf("an empty venue = %+v, want one empty chunk", empty)
+	}
+}
+
+func TestChunkedSubscribersGetVenueStateInChunks(t *testing.T) {
+	th := newTestHarness(t)
+	_, chunked := th.connect("client-chunked", 64)
+	_, whole := th.connect("client-whole", 64)
+
+	chunked.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-1", "chunked": true})
+	whole.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "user-2"})
+	eventually(t, func() bool {
+		_, err1 := findMessage(chunked.messages(t), shared.MessageTypeVenueStateChunk)
+		_, err2 := findMessage(whole.messages(t), shared.MessageTypeVenueState)
+		return err1 == nil && err2 == nil
+	}, "expected a VENUE_STATE_CHUNK and a VENUE_STATE")
+	if _, err := findMessage(chunked.messages(t), shared.MessageTypeVenueState); err == nil {
+		t.Error("a chunked subscriber was sent a whole VENUE_STATE")
+	}
+
+	// A corrected venue pushed to everyone is chunked for chunked clients too
+	state := shared.VenueState{Seats: []shared.Seat{{ID: "A1", Status: shared.SeatBooked, Version: 2}}}
+	chunks, err := venueStateMessages(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, beforeWhole := len(chunked.messages(t)), len(whole.messages(t))
+	th.hub.broadcastVenueState([]byte(`{"type":"VENUE_STATE","data":{"seats":[]}}`), chunks, nil, "")
+	eventually(t, func() bool {
+		return len(chunked.messages(t)) > before && len(whole.messages(t)) > beforeWhole
+	}, "expected the corrected venue")
+	if msgs := chunked.messages(t); msgs[len(msgs)-1].Type != shared.MessageTypeVenueStateChunk {
+		t.Errorf("corrected venue sent to a chunked client as %s", msgs[len(msgs)-1].Type)
+	}
+	if msgs := whole.messages(t); msgs[len(msgs)-1].Type != shared.MessageTypeVenueState {
+		t.Errorf("corrected venue sent to a client as %s", msgs[len(msgs)-1].Type)
+	}
+}
+
+func TestWriteBatchStopsAtMaxBatchBytes(t *testing.T) {
+	conn := newFakeConn()
+	client := newClient(newHub(), conn, "client-large-batch", 8)
+	for _, id := range []string{"A", "B", "C"} {
+		client.sendMessage(id, strings.Repeat("x", 40*1024))
+	}
+
+	for len(client.send) > 0 {
+		if err := client.writeBatch(<-client.send); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, frames := conn.frames(); len(frames) != 2 || len(conn.messages(t)) != 3 {
+		t.Errorf("3 messages of 40KB written in %d frames, want 2", len(frames))
+	}
+}
+
+// BenchmarkVenueStateEncoding measures encoding a 10k-seat venue in each form
+// clients can ask for
+func BenchmarkVenueStateEncoding(b *testing.B) {
+	seats := fixtures.Venue().Section("floor", 100, 50).Tier("balcony", 3500).Section("balcony", 100, 50).Seats()
+	for i := 0; i < len(seats); i += 7 {
+		seats[i].Status, seats[i].HeldBy, seats[i].ExpiresAt = shared.SeatHeld, "user-1", 1699123486
+	}
+	state := shared.VenueState{Seats: seats, Layout: shared.VenueLayoutID(seats), VenueVersion: 42}
+
+	b.Run("full", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(shared.ServerMessage{Type: shared.MessageTypeVenueState, Data: state}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("chunks", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := venueStateMessages(state); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("bitmask", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(shared.ServerMessage{Type: shared.MessageTypeVenueStateBitmask, Data: venueBitmask(state)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Oldest saved venue cache an edge starts from, unless EDGE_CACHE_MAX_AGE says
+// otherwise; an older one is likely to be mostly out of date
+const defaultCacheMaxAge = 10 * time.Minute
+
+// savedVenue is the venue cache as written to EDGE_CACHE_FILE on shutdown
+type savedVenue struct {
+	Seats   []shared.Seat     `json:"seats"`
+	Seq     int64             `json:"seq"`
+	Event   *shared.EventInfo `json:"event,omitempty"`
+	SavedAt time.Time         `json:"saved_at"`
+}
+
+// venueCacheFileFromEnv reads EDGE_CACHE_FILE, where the venue cache is saved
+// across restarts ("" disables saving), and EDGE_CACHE_MAX_AGE
+func venueCacheFileFromEnv() (path string, maxAge time.Duration, err error) {
+	maxAge = defaultCacheMaxAge
+	if env := os.Getenv("EDGE_CACHE_MAX_AGE"); env != "" {
+		if maxAge, err = time.ParseDuration(env); err != nil || maxAge <= 0 {
+			return "", 0, fmt.Errorf("EDGE_CACHE_MAX_AGE must be a positive duration, got %q", env)
+		}
+	}
+	return os.Getenv("EDGE_CACHE_FILE"), maxAge, nil
+}
+
+// Save writes the cache to path, replacing the file in one step so a crash
+// mid-write leaves the previous one. Only a current cache is saved: one that
+// may have missed seat events does not know which sequence number it reflects.
+func (vc *VenueCache) Save(path string) error {
+	vc.mu.RLock()
+	if !vc.current {
+		vc.mu.RUnlock()
+		return errors.New("venue cache is not current")
+	}
+	saved := savedVenue{Seats: vc.seats, Seq: vc.seq, Event: vc.event, SavedAt: time.Now()}
+	data, err := json.Marshal(saved)
+	vc.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Restore loads a cache saved at path no longer than maxAge ago. The restored
+// seats are served while the booking service is down, but are not current
+// until CatchUp brings them up to date. ok is false if there was nothing to
+// restore.
+func (vc *VenueCache) Restore(path string, maxAge time.Duration) (saved savedVenue, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return saved, false, nil
+	}
+	if err != nil {
+		return saved, false, err
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return saved, false, fmt.Errorf("unreadable venue cache: %w", err)
+	}
+	if time.Since(saved.SavedAt) > maxAge || len(saved.Seats) == 0 {
+		return saved, false, nil
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.seats = saved.Seats
+	vc.index = make(map[string]int, len(saved.Seats))
+	vc.counts = shared.SeatCounts{}
+	for i, seat := range saved.Seats {
+		vc.index[seat.ID] = i
+		vc.counts.Add(seat.Status)
+	}
+	vc.seq = saved.Seq
+	vc.changedAt = make(map[string]int64)
+	vc.base = saved.Seq
+	vc.current = false
+	vc.updated = saved.SavedAt
+	if saved.Event != nil {
+		vc.event, vc.eventLoaded = saved.Event, true
+	}
+	return saved, true, nil
+}
+
+// Stale returns the sequence number of a cache that holds seats but may have
+// missed seat events since, such as one just restored; ok is false for a cache
+// that is current or empty
+func (vc *VenueCache) Stale() (seq int64, ok bool) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+	return vc.seq, !vc.current && !vc.updated.IsZero() && vc.seq > 0
+}
+
+// CatchUp applies the seats changed since the cache's sequence number, as of
+// seq, and marks the cache current. It reports false, changing nothing, if a
+// changed seat is not cached: the layout changed and only Replace will do.
+func (vc *VenueCache) CatchUp(changed []shared.Seat, seq int64) bool {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	for _, seat := range changed {
+		if _, ok := vc.index[seat.ID]; !ok {
+			return false
+		}
+	}
+	for _, seat := range changed {
+		i := vc.index[seat.ID]
+		if vc.seats[i].Version >= seat.Version {
+			continue
+		}
+		vc.counts.Move(vc.seats[i].Status, seat.Status)
+		vc.seats[i] = seat
+		vc.changedAt[seat.ID] = max(vc.seq, seq)
+	}
+	vc.seq = max(vc.seq, seq)
+	vc.current = true
+	vc.updated = time.Now()
+	return true
+}
+
+// restoreVenueCache loads the venue cache the edge saved when it last shut
+// down, if recent enough
+func restoreVenueCache(path string, maxAge time.Duration) {
+	saved, ok, err := venueCache.Restore(path, maxAge)
+	if err != nil {
+		slog.Warn("Failed to restore venue cache", "path", path, shared.ErrAttr(err))
+		return
+	}
+	if !ok {
+		slog.Info("No recent venue cache to restore", "path", path, "max_age", maxAge)
+		return
+	}
+	slog.Info("Restored venue cache", "path", path, "seats", len(saved.Seats), "seq", saved.Seq,
+		"age", time.Since(saved.SavedAt).Round(time.Second))
+}
+
+// saveVenueCache writes the venue cache to path for the next start
+func saveVenueCache(path string) {
+	if err := venueCache.Save(path); err != nil {
+		slog.Warn("Failed to save venue cache", "path", path, shared.ErrAttr(err))
+		return
+	}
+	slog.Info("Saved venue cache", "path", path)
+}
+
+// loadVenueCache brings the venue cache up to date with the booking service.
+// A stale cache, such as one restored from disk, fetches only the seats changed
+// since its sequence number; otherwise, or if the booking service no longer
+// keeps those changes, the whole venue is fetched.
+func loadVenueCache() error {
+	if since, ok := venueCache.Stale(); ok {
+		changed, seq, err := bookingClient.GetVenueChanges(since)
+		switch {
+		case err == nil:
+			if venueCache.CatchUp(changed, seq) {
+				slog.Info("Caught up venue cache", "changed", len(changed), "from_seq", since, "seq", seq)
+				return nil
+			}
+			slog.Info("Venue layout changed since the cache was saved, fetching the whole venue", "from_seq", since)
+		case errors.Is(err, errBookingUnavailable):
+			return err
+		default:
+			slog.Info("Venue cache too far behind to catch up, fetching the whole venue", "from_seq", since, shared.ErrAttr(err))
+		}
+	}
+
+	seats, seq, err := bookingClient.GetVenueSnapshot()
+	if err != nil {
+		return err
+	}
+	venueCache.Replace(seats, seq)
+	slog.Info("Fetched venue state", "seats", len(seats), "seq", seq)
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestRestoredVenueCacheCatchesUpWithOnlyTheChangedSeats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "venue.json")
+	saved := NewVenueCache()
+	saved.Replace([]shared.Seat{
+		{ID: "A1", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A2", Status: shared.SeatHeld, HeldBy: "alice", Version: 2},
+	}, 10)
+	saved.SetEvent(&shared.EventInfo{Name: "Encore"})
+	if err := saved.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	var snapshots atomic.Int32
+	gone := false
+	booking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/seats/changes":
+			if gone || r.URL.Query().Get("since") != "10" {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			json.NewEncoder(w).Encode(shared.VenueChanges{Since: 10, Seq: 12, Seats: []shared.Seat{
+				{ID: "A2", Status: shared.SeatBooked, Version: 4},
+			}})
+		case "/api/v1/seats":
+			snapshots.Add(1)
+			json.NewEncoder(w).Encode([]shared.Seat{{ID: "A1", Status: shared.SeatBooked, Version: 5}, {ID: "A2", Status: shared.SeatBooked, Version: 4}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(booking.Close)
+	bookingClient = NewBookingClient(booking.URL)
+
+	venueCache = NewVenueCache()
+	if _, ok, err := venueCache.Restore(path, time.Minute); !ok || err != nil {
+		t.Fatalf("Restore = %v, %v", ok, err)
+	}
+	if seq, stale := venueCache.Stale(); !stale || seq != 10 {
+		t.Errorf("restored cache Stale = %d, %v, want seq 10", seq, stale)
+	}
+	if _, _, ok := venueCache.Snapshot(); !ok {
+		t.Error("restored seats are not served while the cache catches up")
+	}
+	if event, ok := venueCache.Event(); !ok || event.Name != "Encore" {
+		t.Errorf("restored event = %+v, want the saved one", event)
+	}
+
+	if err := loadVenueCache(); err != nil {
+		t.Fatalf("loadVenueCache: %v", err)
+	}
+	seats, seq, ok := venueCache.Fresh()
+	if !ok || seq != 12 || snapshots.Load() != 0 {
+		t.Fatalf("caught up cache = seq %d, current %v after %d snapshots, want seq 12 without a snapshot", seq, ok, snapshots.Load())
+	}
+	if seats[1].Status != shared.SeatBooked || seats[1].Version != 4 {
+		t.Errorf("A2 = %+v, want the booked seat from the changes", seats[1])
+	}
+	if counts, _ := venueCache.Counts(); counts != (shared.SeatCounts{Total: 2, Available: 1, Booked: 1}) {
+		t.Errorf("counts = %+v, want one available and one booked", counts)
+	}
+
+	// Changes the booking service no longer keeps mean the whole venue
+	gone = true
+	venueCache = NewVenueCache()
+	venueCache.Restore(path, time.Minute)
+	if err := loadVenueCache(); err != nil {
+		t.Fatalf("loadVenueCache: %v", err)
+	}
+	if _, seq, ok := venueCache.Fresh(); !ok || snapshots.Load() != 1 {
+		t.Errorf("cache after gone changes = seq %d, current %v after %d snapshots, want one snapshot", seq, ok, snapshots.Load())
+	}
+}
+
+func TestOnlyRecentCurrentVenueCachesAreSavedAndRestored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "venue.json")
+	vc := NewVenueCache()
+	if _, ok, err := vc.Restore(path, time.Minute); ok || err != nil {
+		t.Errorf("Restore without a file = %v, %v, want nothing restored", ok, err)
+	}
+
+	vc.Replace([]shared.Seat{{ID: "A1", Version: 1}}, 3)
+	vc.Invalidate()
+	if err := vc.Save(path); err == nil {
+		t.Error("a cache that may have missed events was saved")
+	}
+
+	vc.Replace([]shared.Seat{{ID: "A1", Version: 1}}, 3)
+	if err := vc.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, ok, _ := NewVenueCache().Restore(path, time.Millisecond); ok {
+		t.Error("a cache older than the maximum age was restored")
+	}
+}
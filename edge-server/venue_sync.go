@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"concert-booking/shared"
+)
+
+// Changes returns the cached seats that changed after venue version since,
+// and the version they bring the client to. ok is false if the cache cannot
+// tell: it is not current, it was loaded after since, or since is ahead of it.
+func (vc *VenueCache) Changes(since int64) (changed []shared.Seat, version int64, ok bool) {
+	vc.mu.RLock()
+	defer vc.mu.RUnlock()
+
+	if !vc.current || since < vc.base || since > vc.seq {
+		return nil, 0, false
+	}
+	for _, seat := range vc.seats {
+		if vc.changedAt[seat.ID] > since {
+			changed = append(changed, seat)
+		}
+	}
+	return changed, vc.seq, true
+}
+
+// handleSync brings a reconnecting client's seat map up to date from the venue
+// version it last saw: only the seats changed since are sent, or the whole
+// venue if the edge no longer knows what changed
+func (c *Client) handleSync(msg *shared.ClientMessage) {
+	var data shared.SyncPayload
+	if !c.decodePayload(msg, "SYNC_RESPONSE", &data) {
+		return
+	}
+	c.syncVenue(msg, *data.VenueVersion)
+}
+
+// syncVenue answers msg with the changes since venue version since, falling
+// back to a full venue state
+func (c *Client) syncVenue(msg *shared.ClientMessage, since int64) {
+	c.subscribed.Store(true)
+	c.touch()
+
+	changed, version, ok := venueCache.Changes(since)
+	if !ok {
+		c.sendOperationResponse(msg, "SYNC_RESPONSE", true, "Changes unavailable, sending the full venue state",
+			shared.VenueDelta{FromVersion: since, Full: true})
+		c.sendVenueState()
+		c.logger(context.Background()).Info("Sent full venue state for sync", "from_version", since)
+		return
+	}
+
+	c.sendOperationResponse(msg, "SYNC_RESPONSE", true, fmt.Sprintf("%d seats changed", len(changed)),
+		shared.VenueDelta{FromVersion: since, VenueVersion: version, Seats: changed})
+	c.logger(context.Background()).Info("Sent venue changes for sync", "from_version", since, "venue_version", version,
+		"seats", len(changed))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestVenueCacheChangesSinceAVersion(t *testing.T) {
+	vc := NewVenueCache()
+	vc.Replace([]shared.Seat{
+		{ID: "A1", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A2", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A3", Status: shared.SeatAvailable, Version: 1},
+	}, 10)
+	vc.Apply(shared.SeatEvent{Seq: 11, SeatID: "A1", UserID: "alice", Status: shared.SeatHeld, Version: 2})
+	vc.Apply(shared.SeatEvent{Seq: 12, SeatID: "A2", Status: shared.SeatBooked, Version: 2})
+
+	changed, version, ok := vc.Changes(11)
+	if !ok || version != 12 || len(changed) != 1 || changed[0].ID != "A2" {
+		t.Errorf("Changes(11) = %+v, %d, %v, want A2 at version 12", changed, version, ok)
+	}
+	if changed, _, ok := vc.Changes(12); !ok || len(changed) != 0 {
+		t.Errorf("Changes(12) = %+v, %v, want nothing changed", changed, ok)
+	}
+
+	// A snapshot correcting a seat sends it as changed at the snapshot
+	vc.Replace([]shared.Seat{
+		{ID: "A1", Status: shared.SeatHeld, HeldBy: "alice", Version: 2},
+		{ID: "A2", Status: shared.SeatBooked, Version: 2},
+		{ID: "A3", Status: shared.SeatBooked, Version: 3},
+	}, 14)
+	if changed, version, ok := vc.Changes(12); !ok || version != 14 || len(changed) != 1 || changed[0].ID != "A3" {
+		t.Errorf("Changes(12) after a correction = %+v, %d, %v, want A3 at version 14", changed, version, ok)
+	}
+
+	for _, since := range []int64{9, 15} {
+		if _, _, ok := vc.Changes(since); ok {
+			t.Errorf("Changes(%d) outside the window succeeded", since)
+		}
+	}
+	vc.Invalidate()
+	if _, _, ok := vc.Changes(14); ok {
+		t.Error("Changes succeeded from a cache that may have missed events")
+	}
+}
+
+func TestVenueCacheChangesStartOverWhenTheLayoutChanges(t *testing.T) {
+	vc := NewVenueCache()
+	vc.Replace([]shared.Seat{{ID: "A1", Version: 1}}, 10)
+	vc.Replace([]shared.Seat{{ID: "A1", Version: 1}, {ID: "A2", Version: 1}}, 20)
+
+	if _, _, ok := vc.Changes(15); ok {
+		t.Error("Changes from before a layout change succeeded")
+	}
+	if changed, _, ok := vc.Changes(20); !ok || len(changed) != 0 {
+		t.Errorf("Changes(20) = %+v, %v, want nothing changed", changed, ok)
+	}
+}
+
+func TestSyncSendsOnlyTheChangedSeats(t *testing.T) {
+	th := newTestHarness(t)
+	venueCache.Replace([]shared.Seat{
+		{ID: "A1", Status: shared.SeatAvailable, Version: 1},
+		{ID: "A2", Status: shared.SeatAvailable, Version: 1},
+	}, 5)
+	venueCache.Apply(shared.SeatEvent{Seq: 6, SeatID: "A2", Status: shared.SeatBooked, Version: 2})
+
+	_, conn := th.connectAs("client-sync", 16, PermissionViewer)
+	conn.sendJSON(t, shared.MessageTypeSync, map[string]interface{}{"venue_version": 5})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), "SYNC_RESPONSE")
+		return err == nil
+	}, "expected SYNC_RESPONSE")
+
+	msg, _ := findMessage(conn.messages(t), "SYNC_RESPONSE")
+	data, _ := msg.Data.(map[string]interface{})["data"].(map[string]interface{})
+	seats, _ := data["seats"].([]interface{})
+	if data["venue_version"] != float64(6) || data["full"] != nil || len(seats) != 1 {
+		t.Fatalf("SYNC_RESPONSE = %v, want A2 at venue version 6", msg.Data)
+	}
+	if _, err := findMessage(conn.messages(t), shared.MessageTypeVenueState); err == nil {
+		t.Error("a full VENUE_STATE was sent along with the changes")
+	}
+}
+
+func TestSyncFromBeforeTheWindowSendsTheWholeVenue(t *testing.T) {
+	th := newTestHarness(t)
+	venueCache.Replace([]shared.Seat{{ID: "A1", Status: shared.SeatAvailable, Version: 1}}, 5)
+
+	_, conn := th.connectAs("client-sync-full", 16, PermissionViewer)
+	conn.sendJSON(t, shared.MessageTypeSync, map[string]interface{}{"venue_version": 2})
+	eventually(t, func() bool {
+		_, err := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+		return err == nil
+	}, "expected VENUE_STATE")
+
+	msg, _ := findMessage(conn.messages(t), "SYNC_RESPONSE")
+	if data, _ := msg.Data.(map[string]interface{})["data"].(map[string]interface{}); data["full"] != true {
+		t.Errorf("SYNC_RESPONSE = %v, want full set", msg.Data)
+	}
+	state, _ := findMessage(conn.messages(t), shared.MessageTypeVenueState)
+	if data, _ := state.Data.(map[string]interface{}); data["venue_version"] != float64(5) {
+		t.Errorf("VENUE_STATE = %v, want venue version 5", state.Data)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"os"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// How often each event's clients are told how many are viewing it, unless
+// EDGE_VIEWERS_INTERVAL says otherwise
+const defaultViewersInterval = 5 * time.Second
+
+// viewersIntervalFromEnv reads EDGE_VIEWERS_INTERVAL; 0 disables VIEWERS
+func viewersIntervalFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_VIEWERS_INTERVAL")
+	if raw == "" {
+		return defaultViewersInterval, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// viewersMessage is the data of a VIEWERS
+type viewersMessage struct {
+	EventID string `json:"event_id"`
+	shared.ViewerCounts
+}
+
+// viewerCounts counts the clients following each event on this edge, and the
+// sections that those which subscribed to part of their own event look at.
+// Clients in a waiting room are not counted until admitted.
+func (h *Hub) viewerCounts() map[string]shared.ViewerCounts {
+	counts := make(map[string]shared.ViewerCounts)
+	h.eachClient(func(client *Client) {
+		for eventID := range client.rooms {
+			c := counts[eventID]
+			c.Viewers++
+			if filter := client.filter.Load(); filter != nil && eventID == client.eventID {
+				for section := range filter.sections {
+					if c.Sections == nil {
+						c.Sections = make(map[string]int)
+					}
+					c.Sections[section]++
+				}
+			}
+			counts[eventID] = c
+		}
+	})
+	return counts
+}
+
+// viewers adds up the viewer counts of this edge and of every peer heard from
+// within shared.EdgeHeartbeatTimeout, so clients see the whole cluster's
+func (r *EdgeRegistry) viewers(now time.Time) map[string]shared.ViewerCounts {
+	total := r.hub.viewerCounts()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, peer := range r.peers {
+		if now.Sub(peer.LastSeen) > shared.EdgeHeartbeatTimeout {
+			continue
+		}
+		for eventID, counts := range peer.Viewers {
+			c := total[eventID]
+			c.Viewers += counts.Viewers
+			for section, n := range counts.Sections {
+				if c.Sections == nil {
+					c.Sections = make(map[string]int)
+				}
+				c.Sections[section] += n
+			}
+			total[eventID] = c
+		}
+	}
+	return total
+}
+
+// broadcastViewers sends VIEWERS to each event's clients every interval
+func broadcastViewers(h *Hub, r *EdgeRegistry, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	slog.Info("Broadcasting viewer counts", "interval", interval)
+
+	sent := make(map[string]shared.ViewerCounts)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sendViewers(h, r, sent, time.Now())
+	}
+}
+
+// sendViewers sends each event with clients on this edge its viewer counts
+// across the cluster, unless they are those it was last sent, as recorded in
+// sent
+func sendViewers(h *Hub, r *EdgeRegistry, sent map[string]shared.ViewerCounts, now time.Time) {
+	local := h.viewerCounts()
+	total := r.viewers(now)
+	for eventID := range sent {
+		if _, ok := local[eventID]; !ok {
+			// Nobody here to tell; whoever follows it next hears afresh
+			delete(sent, eventID)
+		}
+	}
+
+	for eventID := range local {
+		counts := total[eventID]
+		if last, ok := sent[eventID]; ok && last.Viewers == counts.Viewers && maps.Equal(last.Sections, counts.Sections) {
+			continue
+		}
+		message, err := json.Marshal(shared.ServerMessage{
+			Type: shared.MessageTypeViewers,
+			Data: viewersMessage{EventID: eventID, ViewerCounts: counts},
+		})
+		if err != nil {
+			slog.Error("Failed to marshal viewer counts", shared.ErrAttr(err))
+			continue
+		}
+		h.broadcastToRoom(eventID, message, PriorityLow)
+		sent[eventID] = counts
+	}
+}
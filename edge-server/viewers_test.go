@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+func TestViewersAreCountedAcrossTheCluster(t *testing.T) {
+	h := newHub()
+	floor := &Client{hub: h, send: make(chan []byte, 16), id: "client-floor"}
+	floor.filter.Store(&seatFilter{sections: map[string]bool{"floor": true}})
+	anywhere := &Client{hub: h, send: make(chan []byte, 16), id: "client-anywhere"}
+	gala := &Client{hub: h, send: make(chan []byte, 16), id: "client-gala", eventID: "gala"}
+	gala.filter.Store(&seatFilter{sections: map[string]bool{"box": true}})
+	for _, c := range []*Client{floor, anywhere, gala} {
+		addClient(h, c)
+	}
+	if _, err := h.join(floor, "gala"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	r := NewEdgeRegistry(h, "", "")
+	now := time.Now()
+	r.observe(shared.EdgeHeartbeat{ID: "peer", Viewers: map[string]shared.ViewerCounts{
+		shared.DefaultEventID: {Viewers: 10, Sections: map[string]int{"floor": 4}},
+	}}, now)
+	r.observe(shared.EdgeHeartbeat{ID: "silent", Viewers: map[string]shared.ViewerCounts{
+		shared.DefaultEventID: {Viewers: 100},
+	}}, now.Add(-shared.EdgeHeartbeatTimeout-time.Second))
+
+	viewers := r.viewers(now)
+	if c := viewers[shared.DefaultEventID]; c.Viewers != 12 || c.Sections["floor"] != 5 {
+		t.Errorf("main viewers = %+v, want 12 with 5 on the floor", c)
+	}
+	// A filter counts toward the client's own event only
+	if c := viewers["gala"]; c.Viewers != 2 || c.Sections["box"] != 1 || c.Sections["floor"] != 0 {
+		t.Errorf("gala viewers = %+v, want 2 with 1 in the box", c)
+	}
+}
+
+func TestViewersAreSentOnlyWhenTheyChange(t *testing.T) {
+	h := newHub()
+	client := &Client{hub: h, send: make(chan []byte, 16), id: "client-viewing"}
+	addClient(h, client)
+	r := NewEdgeRegistry(h, "", "")
+	go h.run()
+
+	sent := make(map[string]shared.ViewerCounts)
+	sendViewers(h, r, sent, time.Now())
+	raw := <-client.send
+	var msg struct {
+		Type string         `json:"type"`
+		Data viewersMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("unmarshal %s: %v", raw, err)
+	}
+	if msg.Type != shared.MessageTypeViewers || msg.Data.EventID != shared.DefaultEventID || msg.Data.Viewers != 1 {
+		t.Fatalf("got %s, want VIEWERS with 1 viewer of main", raw)
+	}
+
+	sendViewers(h, r, sent, time.Now())
+	r.observe(shared.EdgeHeartbeat{ID: "peer", Viewers: map[string]shared.ViewerCounts{shared.DefaultEventID: {Viewers: 2}}}, time.Now())
+	sendViewers(h, r, sent, time.Now())
+	if err := json.Unmarshal(<-client.send, &msg); err != nil || msg.Data.Viewers != 3 {
+		t.Fatalf("after a peer's viewers = %+v, %v, want 3 viewers and nothing unchanged before", msg.Data, err)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/google/uuid"
+)
+
+// How long a client admitted to a capped event may be gone and still re-enter
+// ahead of the waiting room, unless EDGE_ADMISSION_GRACE says otherwise
+const defaultAdmissionGrace = 2 * time.Minute
+
+// eventCaps limits how many clients of each event an edge admits at once, so
+// one busy event cannot take every connection slot. Zero means uncapped.
+type eventCaps struct {
+	byEvent    map[string]int
+	defaultCap int
+}
+
+// capFor returns the connection cap for an event
+func (ec eventCaps) capFor(eventID string) int {
+	if n, ok := ec.byEvent[eventID]; ok {
+		return n
+	}
+	return ec.defaultCap
+}
+
+// parseEventCaps reads caps like "500" (every event) or "main=2000,500"
+// (2000 for main, 500 for the rest)
+func parseEventCaps(raw string) (eventCaps, error) {
+	caps := eventCaps{byEvent: make(map[string]int)}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eventID, value, named := strings.Cut(part, "=")
+		if !named {
+			value = eventID
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return eventCaps{}, fmt.Errorf("invalid connection cap %q", part)
+		}
+		if named {
+			caps.byEvent[strings.TrimSpace(eventID)] = n
+		} else {
+			caps.defaultCap = n
+		}
+	}
+	return caps, nil
+}
+
+// eventCapsFromEnv reads EDGE_EVENT_CONN_CAP; unset leaves every event uncapped
+func eventCapsFromEnv() (eventCaps, error) {
+	return parseEventCaps(os.Getenv("EDGE_EVENT_CONN_CAP"))
+}
+
+// admissionGraceFromEnv reads EDGE_ADMISSION_GRACE; 0 sends every reconnecting
+// client to the back of the waiting room
+func admissionGraceFromEnv() (time.Duration, error) {
+	raw := os.Getenv("EDGE_ADMISSION_GRACE")
+	if raw == "" {
+		return defaultAdmissionGrace, nil
+	}
+	grace, err := time.ParseDuration(raw)
+	if err != nil || grace < 0 {
+		return 0, fmt.Errorf("want a duration, got %q", raw)
+	}
+	return grace, nil
+}
+
+// eventSlots is one event's share of the hub: its admitted clients, the
+// waiting room for overflow in arrival order, and fairness counters. Only a
+// capped event needs mu, so clients of uncapped ones come and go without a
+// lock.
+type eventSlots struct {
+	// Clients let in; changed under mu if the event is capped
+	connected atomic.Int64
+
+	// Guards the rest
+	mu sync.Mutex
+
+	waiting []*Client
+	stats   shared.EventConnStats
+
+	// Admission tokens of clients let in, by when they lapse: zero while the
+	// client is connected, its grace period's end once it has left
+	admissions map[string]time.Time
+
+	// How many clients at the front of waiting are returning ones
+	returning int
+}
+
+// redeemLocked reports whether token lets a client back in ahead of the
+// waiting room, and if so spends it; slots.mu must be held
+func (slots *eventSlots) redeemLocked(token string, now time.Time) bool {
+	lapses, ok := slots.admissions[token]
+	if token == "" || !ok || lapses.IsZero() {
+		return false
+	}
+	delete(slots.admissions, token)
+	return now.Before(lapses)
+}
+
+// admitLocked records that client was let in to a capped event, giving it an
+// admission token unless it came back with one; slots.mu must be held
+func (slots *eventSlots) admitLocked(client *Client, now time.Time) {
+	if slots.admissions == nil {
+		slots.admissions = make(map[string]time.Time)
+	}
+	for token, lapses := range slots.admissions {
+		if !lapses.IsZero() && now.After(lapses) {
+			delete(slots.admissions, token)
+		}
+	}
+	if !client.returning {
+		client.admission = uuid.NewString()
+	}
+	slots.admissions[client.admission] = time.Time{}
+}
+
+// slots returns the slots for an event, creating them
+func (h *Hub) slots(eventID string) *eventSlots {
+	h.mu.RLock()
+	slots, ok := h.events[eventID]
+	h.mu.RUnlock()
+	if ok {
+		return slots
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	slots, ok = h.events[eventID]
+	if !ok {
+		slots = &eventSlots{}
+		h.events[eventID] = slots
+	}
+	return slots
+}
+
+// admitOrPark adds a registering client to the hub, or puts it in its event's
+// waiting room if the event is at its cap, and reports whether it parked it.
+// Both happen under the event's lock, so shards registering at once cannot
+// both take the last slot. Clients wait in arrival order and are admitted as
+// the event's clients leave, except that clients returning with an admission
+// token within its grace period go ahead of new arrivals.
+func (h *Hub) admitOrPark(client *Client) bool {
+	slots := h.slots(client.eventID)
+	limit := h.caps.capFor(client.eventID)
+	if limit == 0 {
+		h.addLocked(slots, client)
+		return false
+	}
+
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	if h.restartFrame.Load() != nil {
+		h.addLocked(slots, client)
+		return false
+	}
+	client.returning = slots.redeemLocked(client.admission, time.Now())
+	if client.returning {
+		slots.stats.Readmitted++
+	}
+	if slots.connected.Load() < int64(limit) && (len(slots.waiting) == 0 || client.returning) {
+		h.addLocked(slots, client)
+		return false
+	}
+	client.waiting.Store(true)
+	client.queuedAt = time.Now()
+	slots.stats.Queued++
+	i := len(slots.waiting)
+	if client.returning {
+		i = slots.returning
+		slots.returning++
+	}
+	slots.waiting = append(slots.waiting[:i], append([]*Client{client}, slots.waiting[i:]...)...)
+
+	// It and everyone it went ahead of learn their place
+	slots.sendPositionsLocked(i)
+	slog.Info("Client sent to the waiting room", shared.LogKeyClientID, client.id, "event_id", client.eventID,
+		"position", i+1, "returning", client.returning)
+	return true
+}
+
+// unpark removes a client of shard s that left while still waiting and
+// reports whether it was waiting. Only the shard's loop calls it.
+func (h *Hub) unpark(s *hubShard, client *Client) bool {
+	// Only the client's own shard puts it in a waiting room, so one that is
+	// not waiting now will not be
+	if !client.waiting.Load() {
+		return false
+	}
+	slots := h.slots(client.eventID)
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	i := indexOf(slots.waiting, client)
+	if i < 0 {
+		return false
+	}
+	slots.waiting = append(slots.waiting[:i], slots.waiting[i+1:]...)
+	slots.stats.Abandoned++
+	if i < slots.returning {
+		// It may come back again, as after leaving the event
+		slots.returning--
+		slots.admissions[client.admission] = time.Now().Add(h.admissionGrace)
+	}
+	h.leaveSession(client)
+	client.waiting.Store(false)
+	s.closeSend(client)
+
+	// Everyone behind it moved up a place
+	slots.sendPositionsLocked(i)
+	slog.Info("Client left the waiting room", shared.LogKeyClientID, client.id, "event_id", client.eventID)
+	return true
+}
+
+// admitWaiting lets in the longest waiting client of an event if a slot is
+// free, adding it to the hub for its shard to welcome; it returns nil if
+// there is nobody to admit
+func (h *Hub) admitWaiting(eventID string) *Client {
+	// Only capped events keep a waiting room
+	limit := h.caps.capFor(eventID)
+	if limit == 0 {
+		return nil
+	}
+	slots := h.slots(eventID)
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	if len(slots.waiting) == 0 || slots.connected.Load() >= int64(limit) {
+		return nil
+	}
+	next := slots.waiting[0]
+	slots.waiting = slots.waiting[1:]
+	slots.returning = max(slots.returning-1, 0)
+	slots.stats.Admitted++
+	wait := time.Since(next.queuedAt)
+	slots.stats.LongestWaitMs = max(slots.stats.LongestWaitMs, wait.Milliseconds())
+	next.waiting.Store(false)
+	h.addLocked(slots, next)
+
+	slots.sendPositionsLocked(0)
+	slog.Info("Client admitted from the waiting room", shared.LogKeyClientID, next.id, "event_id", eventID, "waited", wait)
+	return next
+}
+
+// releaseSlot gives up the slot of a client the hub removed
+func (h *Hub) releaseSlot(client *Client) {
+	slots := h.slots(client.eventID)
+	if h.caps.capFor(client.eventID) == 0 {
+		slots.connected.Add(-1)
+		return
+	}
+
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	slots.connected.Add(-1)
+	if _, ok := slots.admissions[client.admission]; ok {
+		// Its admission holds for a while, should it reconnect
+		slots.admissions[client.admission] = time.Now().Add(h.admissionGrace)
+	}
+}
+
+// sendPositionsLocked tells the clients waiting from index from on their
+// places. An event's waiting clients belong to different shards, each of which
+// closes a waiting client's send channel under slots.mu, so positions are only
+// sent under it too; slots.mu must be held.
+func (slots *eventSlots) sendPositionsLocked(from int) {
+	for i := from; i < len(slots.waiting); i++ {
+		c := slots.waiting[i]
+		if c.unregistered.Load() || !c.waiting.Load() {
+			continue
+		}
+		c.sendWaitingRoom(i + 1)
+	}
+}
+
+// eachSlots calls fn with every event's slots, having let go of h.mu so fn
+// may take their locks
+func (h *Hub) eachSlots(fn func(eventID string, slots *eventSlots)) {
+	h.mu.RLock()
+	events := make(map[string]*eventSlots, len(h.events))
+	for eventID, slots := range h.events {
+		events[eventID] = slots
+	}
+	h.mu.RUnlock()
+
+	for eventID, slots := range events {
+		fn(eventID, slots)
+	}
+}
+
+// eventStats reports every event's connections
+func (h *Hub) eventStats() map[string]shared.EventConnStats {
+	var stats map[string]shared.EventConnStats
+	h.eachSlots(func(eventID string, slots *eventSlots) {
+		slots.mu.Lock()
+		s := slots.stats
+		s.Waiting = len(slots.waiting)
+		slots.mu.Unlock()
+		s.Connected = int(slots.connected.Load())
+		s.Cap = h.caps.capFor(eventID)
+		if stats == nil {
+			stats = make(map[string]shared.EventConnStats)
+		}
+		stats[eventID] = s
+	})
+	return stats
+}
+
+// waitingClients returns every client in a waiting room
+func (h *Hub) waitingClients() []*Client {
+	var waiting []*Client
+	h.eachSlots(func(_ string, slots *eventSlots) {
+		slots.mu.Lock()
+		waiting = append(waiting, slots.waiting...)
+		slots.mu.Unlock()
+	})
+	return waiting
+}
+
+// sendWaitingRoom tells a waiting client its place in the queue
+func (c *Client) sendWaitingRoom(position int) {
+	msg, err := json.Marshal(shared.ServerMessage{
+		Type: shared.MessageTypeWaitingRoom,
+		Data: map[string]interface{}{
+			"event_id": c.eventID,
+			"position": position,
+		},
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		// A newer position will follow
+	}
+}
+
+func indexOf(clients []*Client, client *Client) int {
+	for i, c := range clients {
+		if c == client {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestParseEventCaps(t *testing.T) {
+	caps, err := parseEventCaps("main=2, 5")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got := caps.capFor("main"); got != 2 {
+		t.Errorf("cap for main = %d, want 2", got)
+	}
+	if got := caps.capFor("other"); got != 5 {
+		t.Errorf("cap for other = %d, want 5", got)
+	}
+
+	if caps, _ := parseEventCaps(""); caps.capFor("main") != 0 {
+		t.Error("an empty setting capped main")
+	}
+	for _, bad := range []string{"main=lots", "-1"} {
+		if _, err := parseEventCaps(bad); err == nil {
+			t.Errorf("parseEventCaps(%q) succeeded", bad)
+		}
+	}
+}
+
+// waitingPosition returns the position in the last WAITING_ROOM message, or 0
+func waitingPosition(t *testing.T, conn *fakeConn) int {
+	t.Helper()
+	position := 0
+	for _, msg := range conn.messages(t) {
+		if msg.Type != shared.MessageTypeWaitingRoom {
+			continue
+		}
+		data, _ := msg.Data.(map[string]interface{})
+		p, _ := data["position"].(float64)
+		position = int(p)
+	}
+	return position
+}
+
+func TestEventCapSendsOverflowToTheWaitingRoomInOrder(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.caps = eventCaps{byEvent: map[string]int{shared.DefaultEventID: 1}}
+
+	first, firstConn := th.connect("client-1", 16)
+	_, secondConn := th.connect("client-2", 16)
+	_, thirdConn := th.connect("client-3", 16)
+	other := newClient(th.hub, newFakeConn(), "client-other", 16)
+	other.eventID = "other" // uncapped, so admitted at once
+	th.start(other)
+
+	eventually(t, func() bool {
+		return waitingPosition(t, secondConn) == 1 && waitingPosition(t, thirdConn) == 2
+	}, "overflow clients were not queued in arrival order")
+	if _, err := findMessage(secondConn.messages(t), "WELCOME"); err == nil {
+		t.Fatal("a waiting client got WELCOME")
+	}
+
+	// Waiting clients may not act until admitted
+	secondConn.sendJSON(t, shared.MessageTypeSubscribe, map[string]interface{}{"user_id": "bob"})
+	eventually(t, func() bool {
+		_, err := findMessage(secondConn.messages(t), shared.MessageTypeError)
+		return err == nil
+	}, "a waiting client's SUBSCRIBE was not refused")
+
+	// The first client leaving lets the longest waiting one in
+	firstConn.Close()
+	eventually(t, func() bool {
+		_, err := findMessage(secondConn.messages(t), "WELCOME")
+		return err == nil
+	}, "the first waiting client was not admitted when a slot freed")
+	eventually(t, func() bool { return waitingPosition(t, thirdConn) == 1 }, "the next client did not move up")
+	if th.isRegistered(first) {
+		t.Error("the client that left is still registered")
+	}
+	if !th.isRegistered(other) {
+		t.Error("a client of an uncapped event was not admitted")
+	}
+
+	thirdConn.Close()
+	eventually(t, func() bool {
+		return th.hub.EventStats()[shared.DefaultEventID].Abandoned == 1
+	}, "leaving the waiting room was not counted")
+
+	stats := th.hub.GetStats().Events[shared.DefaultEventID]
+	want := shared.EventConnStats{Connected: 1, Cap: 1, Waiting: 0, Queued: 2, Admitted: 1, Abandoned: 1}
+	stats.LongestWaitMs = 0
+	if stats != want {
+		raw, _ := json.Marshal(stats)
+		t.Errorf("event stats = %s, want %+v", raw, want)
+	}
+}
+
+func TestReturningClientsReenterAheadOfNewArrivals(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.caps = eventCaps{byEvent: map[string]int{shared.DefaultEventID: 1}}
+
+	_, firstConn := th.connect("client-1", 16)
+	eventually(t, func() bool {
+		_, err := findMessage(firstConn.messages(t), "WELCOME")
+		return err == nil
+	}, "expected WELCOME")
+	welcome, _ := findMessage(firstConn.messages(t), "WELCOME")
+	token, _ := welcome.Data.(map[string]interface{})["admission_token"].(string)
+	if token == "" {
+		t.Fatalf("WELCOME = %v, want an admission token", welcome.Data)
+	}
+
+	// The first client drops; the one waiting takes its slot and a newcomer queues
+	_, secondConn := th.connect("client-2", 16)
+	eventually(t, func() bool { return waitingPosition(t, secondConn) == 1 }, "the second client was not queued")
+	firstConn.Close()
+	eventually(t, func() bool {
+		_, err := findMessage(secondConn.messages(t), "WELCOME")
+		return err == nil
+	}, "the waiting client was not admitted")
+	_, thirdConn := th.connect("client-3", 16)
+	eventually(t, func() bool { return waitingPosition(t, thirdConn) == 1 }, "the newcomer was not queued")
+
+	// Back with its token, the first client goes ahead of the newcomer
+	returning := newClient(th.hub, newFakeConn(), "client-1b", 16)
+	returning.admission = token
+	returningConn := th.start(returning)
+	eventually(t, func() bool {
+		return waitingPosition(t, returningConn) == 1 && waitingPosition(t, thirdConn) == 2
+	}, "the returning client did not go ahead of the newcomer")
+
+	// A token is spent once
+	again := newClient(th.hub, newFakeConn(), "client-1c", 16)
+	again.admission = token
+	againConn := th.start(again)
+	eventually(t, func() bool { return waitingPosition(t, againConn) == 3 }, "a spent token was honored again")
+
+	secondConn.Close()
+	eventually(t, func() bool {
+		_, err := findMessage(returningConn.messages(t), "WELCOME")
+		return err == nil
+	}, "the returning client was not admitted first")
+	if stats := th.hub.EventStats()[shared.DefaultEventID]; stats.Readmitted != 1 {
+		t.Errorf("readmitted = %d, want 1", stats.Readmitted)
+	}
+
+	// Nobody is left waiting to be admitted after the test
+	thirdConn.Close()
+	againConn.Close()
+	eventually(t, func() bool { return th.hub.EventStats()[shared.DefaultEventID].Waiting == 0 }, "waiting clients did not leave")
+}
+
+func TestAdmissionTokensLapseAfterTheGracePeriod(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.caps = eventCaps{byEvent: map[string]int{shared.DefaultEventID: 1}}
+	th.hub.admissionGrace = 0
+
+	first, firstConn := th.connect("client-1", 16)
+	_, secondConn := th.connect("client-2", 16)
+	eventually(t, func() bool { return waitingPosition(t, secondConn) == 1 }, "the second client was not queued")
+	firstConn.Close()
+	eventually(t, func() bool { return !th.isRegistered(first) }, "the first client was not unregistered")
+	_, thirdConn := th.connect("client-3", 16)
+	eventually(t, func() bool { return waitingPosition(t, thirdConn) == 1 }, "the newcomer was not queued")
+
+	late := newClient(th.hub, newFakeConn(), "client-1b", 16)
+	late.admission = first.admission
+	lateConn := th.start(late)
+	eventually(t, func() bool { return waitingPosition(t, lateConn) == 2 }, "a lapsed token was honored")
+
+	thirdConn.Close()
+	lateConn.Close()
+	eventually(t, func() bool { return th.hub.EventStats()[shared.DefaultEventID].Waiting == 0 }, "waiting clients did not leave")
+}
+
+func TestWaitingRoomAcrossShards(t *testing.T) {
+	th := newTestHarness(t)
+	th.hub.shards = newHubShards(th.hub, 4)
+	th.hub.caps = eventCaps{byEvent: map[string]int{shared.DefaultEventID: 1}}
+
+	_, holderConn := th.connect("client-holder", 16)
+	waiting := make([]*Client, 32)
+	for i := range waiting {
+		waiting[i] = newClient(th.hub, newFakeConn(), fmt.Sprintf("client-%d", i), 4)
+		th.hub.registerClient(waiting[i])
+	}
+	eventually(t, func() bool { return th.hub.EventStats()[shared.DefaultEventID].Waiting == len(waiting) }, "clients were not queued")
+
+	// Waiting clients leave on their own shards while the others are told
+	// their new places, and the holder's slot goes to whoever is first
+	var wg sync.WaitGroup
+	for _, client := range waiting {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.hub.unregisterClient(client)
+		}()
+	}
+	holderConn.Close()
+	wg.Wait()
+
+	eventually(t, func() bool {
+		stats := th.hub.EventStats()[shared.DefaultEventID]
+		return stats.Waiting == 0 && stats.Admitted+stats.Abandoned == int64(len(waiting))
+	}, "waiting clients did not all leave or get in")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// isJSON reports whether a queued message is still JSON, as every message is
+// until a msgpack client's broadcast has been packed for it
+func isJSON(message []byte) bool {
+	return len(message) > 0 && message[0] == '{'
+}
+
+// writeMsgpackBatch is writeBatch for clients that negotiated the msgpack
+// subprotocol: message and what is queued behind it, up to maxBatchBytes, go
+// out as MessagePack documents back to back in one binary websocket message
+func (c *Client) writeMsgpackBatch(message []byte) error {
+	w, err := c.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	size := c.writePacked(w.Write, message)
+
+	n := len(c.send)
+	for i := 0; i < n && size < maxBatchBytes; i++ {
+		size += c.writePacked(w.Write, <-c.send)
+	}
+	return w.Close()
+}
+
+// writePacked writes one message as MessagePack and returns its size. A
+// message that cannot be converted is dropped.
+func (c *Client) writePacked(write func([]byte) (int, error), message []byte) int {
+	c.trace("->", message)
+	if isJSON(message) {
+		packed, err := shared.JSONToMsgpack(message)
+		if err != nil {
+			c.logger(context.Background()).Error("Failed to convert message to MessagePack", shared.ErrAttr(err))
+			return 0
+		}
+		message = packed
+	}
+	write(message)
+	return len(message)
+}
+
+// packedMessages converts a broadcast's messages to MessagePack the first time
+// a msgpack client needs them, once for all such clients on every shard
+type packedMessages struct {
+	messages [][]byte
+	packed   [][]byte
+	once     sync.Once
+}
+
+func packLater(messages [][]byte) *packedMessages {
+	return &packedMessages{messages: messages}
+}
+
+// get returns the messages as MessagePack, or as they are for any that cannot
+// be converted; writePacked tries those again and drops them
+func (p *packedMessages) get() [][]byte {
+	p.once.Do(func() {
+		p.packed = make([][]byte, len(p.messages))
+		for i, message := range p.messages {
+			packed, err := shared.JSONToMsgpack(message)
+			if err != nil {
+				packed = message
+			}
+			p.packed[i] = packed
+		}
+	})
+	return p.packed
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// msgpackMessages decodes the MessagePack documents of every binary frame the
+// server wrote, failing on any text frame
+func msgpackMessages(t *testing.T, conn *fakeConn) []map[string]interface{} {
+	t.Helper()
+	types, frames := conn.frames()
+
+	handle := &codec.MsgpackHandle{}
+	handle.RawToString = true
+
+	var msgs []map[string]interface{}
+	for i, frame := range frames {
+		if types[i] == websocket.TextMessage {
+			t.Fatalf("a msgpack client was written a text frame: %s", frame)
+		}
+		if types[i] != websocket.BinaryMessage {
+			continue
+		}
+		decoder := codec.NewDecoderBytes(frame, handle)
+		for decoder.NumBytesRead() < len(frame) {
+			var msg map[string]interface{}
+			if err := decoder.Decode(&msg); err != nil {
+				t.Fatalf("server wrote invalid MessagePack: %v", err)
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func TestMsgpackClientsExchangeBinaryFrames(t *testing.T) {
+	th := newTestHarness(t)
+	client := newClient(th.hub, newFakeConn(), "client-1", 16)
+	client.permission = PermissionBuyer
+	client.msgpack = true
+	conn := th.start(client)
+
+	subscribe, err := shared.JSONToMsgpack([]byte(`{"type": "SUBSCRIBE", "data": {"user_id": "alice"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.inbound <- fakeFrame{messageType: websocket.BinaryMessage, data: subscribe}
+
+	has := func(msgType string) func() bool {
+		return func() bool {
+			for _, msg := range msgpackMessages(t, conn) {
+				if msg["type"] == msgType {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	eventually(t, has(shared.MessageTypeVenueState), "expected VENUE_STATE after a binary SUBSCRIBE")
+
+	th.hub.broadcastWithPriority([]byte(`{"type":"SEAT_UPDATE","data":{"seat_id":"A1","status":"held","version":2}}`), PriorityHigh)
+	eventually(t, has(shared.MessageTypeSeatUpdate), "expected the broadcast SEAT_UPDATE")
+
+	for _, msg := range msgpackMessages(t, conn) {
+		if msg["type"] != shared.MessageTypeSeatUpdate {
+			continue
+		}
+		data, _ := msg["data"].(map[interface{}]interface{})
+		if data["seat_id"] != "A1" || data["version"] != int64(2) {
+			t.Errorf("SEAT_UPDATE data = %v, want seat A1 at version 2", data)
+		}
+	}
+
+	// Clients that did not negotiate msgpack still get JSON text frames
+	_, jsonConn := th.connect("client-2", 16)
+	eventually(t, func() bool {
+		_, err := findMessage(jsonConn.messages(t), "WELCOME")
+		return err == nil
+	}, "expected a JSON WELCOME")
+}
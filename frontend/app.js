@@ -1,3 +1,18 @@
+// Seat statuses as sent by the server
+const SeatStatus = {
+    AVAILABLE: 'available',
+    HELD: 'held',
+    BOOKED: 'booked'
+};
+
+// normalizeSeatStatus also accepts the integers (0/1/2) older servers sent
+function normalizeSeatStatus(status) {
+    if (typeof status === 'number') {
+        return [SeatStatus.AVAILABLE, SeatStatus.HELD, SeatStatus.BOOKED][status];
+    }
+    return status;
+}
+
 class SeatBookingApp {
     constructor() {
         this.ws = null;
@@ -15,12 +30,26 @@ class SeatBookingApp {
         this.reconnectAttempts = 0;
         this.reconnectDelay = 1000;
         this.maxReconnectAttempts = 10;
+        this.knownEdges = [];
         this.timers = {};
+        // Layout ID of the rendered seat map; on reconnect we only need statuses
+        this.layout = null;
+        // Lets us back in ahead of the waiting room after a dropped connection
+        this.admissionToken = null;
+        // Resumes our session (client ID, user) after a dropped connection
+        this.sessionToken = null;
+        // Last seat update number from the edge, and venue version we hold
+        this.broadcastSeq = null;
+        this.venueVersion = null;
+        this.streamId = null;
+        this.awaitingReplay = false;
+        // Set when the event keeps holds alive while we ping them
+        this.holdKeepalive = null;
+        this.lastKeepalive = null;
     }
     
     init() {
         this.updateUserDisplay();
-        this.renderSeatMap();
         this.connect();
         this.setupEventListeners();
     }
@@ -43,18 +72,31 @@ class SeatBookingApp {
     connect() {
         this.showMessage('Connecting to server...', 'info');
         
-        this.ws = new WebSocket(this.wsUrl);
+        // The newest message format this client speaks
+        const params = new URLSearchParams({protocol_version: '1'});
+        if (this.admissionToken) {
+            params.set('admission', this.admissionToken);
+        }
+        if (this.sessionToken) {
+            params.set('session', this.sessionToken);
+        }
+        // Ask the edge to replay the seat updates missed while disconnected
+        this.awaitingReplay = Boolean(this.streamId && this.broadcastSeq !== null && this.layout);
+        if (this.awaitingReplay) {
+            params.set('last_event_id', `${this.streamId}:${this.broadcastSeq}`);
+        }
+        this.ws = new WebSocket(`${this.wsUrl}?${params}`);
         
         this.ws.onopen = () => {
             console.log('WebSocket connected');
             this.reconnectAttempts = 0;
             this.updateConnectionStatus(true);
+            this.discoverEdges();
             
-            // Subscribe with user ID
-            this.send({
-                type: 'SUBSCRIBE',
-                data: { user_id: this.userId }
-            });
+            // When replaying, WELCOME tells us whether we still need the venue
+            if (!this.awaitingReplay) {
+                this.subscribe(false);
+            }
         };
         
         this.ws.onmessage = (event) => {
@@ -66,13 +108,32 @@ class SeatBookingApp {
             this.showMessage('Connection error occurred', 'error');
         };
         
-        this.ws.onclose = () => {
-            console.log('WebSocket disconnected');
+        this.ws.onclose = (event) => {
+            console.log('WebSocket disconnected', event.code, event.reason);
             this.updateConnectionStatus(false);
+            if (event.code === 1012) {
+                // The edge is restarting and asked us to go elsewhere
+                this.showMessage('Server restarting, reconnecting...', 'info');
+            }
             this.reconnect();
         };
     }
     
+    subscribe(resumed) {
+        // Subscribe with user ID, asking for a bitmask if the map is already drawn
+        const subscribe = { user_id: this.userId };
+        if (resumed) {
+            subscribe.resumed = true;
+        } else if (this.layout) {
+            subscribe.format = 'bitmask';
+            subscribe.layout = this.layout;
+        }
+        this.send({
+            type: 'SUBSCRIBE',
+            data: subscribe
+        });
+    }
+    
     reconnect() {
         if (this.reconnectAttempts >= this.maxReconnectAttempts) {
             this.showMessage('Failed to connect after multiple attempts', 'error');
@@ -82,6 +143,9 @@ class SeatBookingApp {
         this.reconnectAttempts++;
         const delay = Math.min(this.reconnectDelay * this.reconnectAttempts, 10000);
         
+        // Fail over to another known edge instead of waiting for this one to return
+        this.failoverEdge();
+        
         this.showMessage(`Reconnecting in ${delay/1000}s... (attempt ${this.reconnectAttempts})`, 'info');
         
         setTimeout(() => {
@@ -89,6 +153,28 @@ class SeatBookingApp {
         }, delay);
     }
     
+    discoverEdges() {
+        // The discovery endpoint lives next to /ws on every edge (and behind NGINX)
+        const discoveryUrl = this.wsUrl.replace(/^ws/, 'http').replace(/\/ws$/, '/edges');
+        fetch(discoveryUrl)
+            .then(response => response.json())
+            .then(data => {
+                this.knownEdges = data.edges || [];
+            })
+            .catch(error => console.log('Edge discovery unavailable:', error));
+    }
+    
+    failoverEdge() {
+        // Edges are listed best first; skip ones without a public URL or that just failed
+        const next = this.knownEdges.find(edge =>
+            edge.healthy && edge.public_url && edge.public_url !== this.wsUrl);
+        if (next) {
+            console.log(`Failing over to edge ${next.id} at ${next.public_url}`);
+            this.knownEdges = this.knownEdges.filter(edge => edge !== next);
+            this.wsUrl = next.public_url;
+        }
+    }
+    
     send(message) {
         if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(JSON.stringify(message));
@@ -98,48 +184,57 @@ class SeatBookingApp {
         }
     }
     
-    renderSeatMap() {
+    renderSeatMap(seatList) {
         const seatMap = document.getElementById('seat-map');
         seatMap.innerHTML = '';
+        this.seats = {};
         
-        // Create 10x10 grid (A1-J10)
-        for (let row = 0; row < 10; row++) {
-            for (let col = 0; col < 10; col++) {
-                const rowLetter = String.fromCharCode(65 + row); // A-J
-                const seatId = `${rowLetter}${col + 1}`;
-                
-                const seatElement = document.createElement('div');
-                seatElement.className = 'seat available';
-                seatElement.dataset.seatId = seatId;
-                seatElement.textContent = seatId;
-                
-                seatElement.addEventListener('click', () => {
-                    this.handleSeatClick(seatId);
-                });
-                
-                seatMap.appendChild(seatElement);
-                
-                // Store reference
-                this.seats[seatId] = {
-                    element: seatElement,
-                    status: 0,
-                    heldBy: null,
-                    expiresAt: null
-                };
+        // Size the grid from the venue layout sent by the server
+        const rows = seatList.reduce((max, s) => Math.max(max, s.row), 0) + 1;
+        const cols = seatList.reduce((max, s) => Math.max(max, s.col), 0) + 1;
+        seatMap.style.gridTemplateColumns = `repeat(${cols}, var(--seat-size))`;
+        seatMap.style.gridTemplateRows = `repeat(${rows}, var(--seat-size))`;
+        
+        seatList.forEach(seatData => {
+            const seatId = seatData.id;
+            
+            const seatElement = document.createElement('div');
+            seatElement.className = 'seat available';
+            seatElement.dataset.seatId = seatId;
+            seatElement.textContent = seatId;
+            seatElement.style.gridRow = seatData.row + 1;
+            seatElement.style.gridColumn = seatData.col + 1;
+            if (seatData.section) {
+                seatElement.title = `${seatId} (${seatData.section})`;
             }
-        }
+            
+            seatElement.addEventListener('click', () => {
+                this.handleSeatClick(seatId);
+            });
+            
+            seatMap.appendChild(seatElement);
+            
+            // Store reference
+            this.seats[seatId] = {
+                element: seatElement,
+                status: SeatStatus.AVAILABLE,
+                heldBy: null,
+                expiresAt: null
+            };
+        });
     }
     
     handleSeatClick(seatId) {
         const seat = this.seats[seatId];
+        if (!seat) return;
         
         // Check if seat is available or held by current user
-        if (seat.status === 2) {
+        if (seat.status === SeatStatus.BOOKED) {
             this.showMessage(`Seat ${seatId} is already booked`, 'error');
             return;
         }
         
-        if (seat.status === 1 && seat.heldBy !== this.userId) {
+        if (seat.status === SeatStatus.HELD && seat.heldBy !== this.userId) {
             this.showMessage(`Seat ${seatId} is held by another user`, 'error');
             return;
         }
@@ -178,10 +273,48 @@ class SeatBookingApp {
                     this.handleVenueState(message.data);
                     break;
                     
+                case 'VENUE_STATE_BITMASK':
+                    this.handleVenueBitmask(message.data);
+                    break;
+                    
                 case 'SEAT_UPDATE':
+                    this.checkBroadcastSeq(message.data.broadcast_seq);
                     this.handleSeatUpdate(message.data);
                     break;
                     
+                case 'SEAT_UPDATE_BATCH':
+                    // Releases grouped by the booking service, with one notice for all of
+                    // them, or updates the edge coalesced under load, applied quietly
+                    this.checkBroadcastSeq(message.data.broadcast_seq);
+                    message.data.updates.forEach(update => {
+                        update.seat && this.updateSeat(update.seat);
+                        this.venueVersion = Math.max(this.venueVersion || 0, update.venue_version || 0);
+                    });
+                    if (message.data.event_type === 'released_batch') {
+                        this.showMessage(`${message.data.updates.length} seats are now available`, 'success');
+                    }
+                    this.updateAvailableCount();
+                    break;
+                    
+                case 'VENUE_SNAPSHOT':
+                    // Compact state sent after we sat idle; the layout is unchanged
+                    message.data.seats.forEach(seatData => this.updateSeat(seatData));
+                    this.updateAvailableCount();
+                    break;
+                    
+                case 'SYNC_RESPONSE':
+                    // Only the seats changed since our venue version; a full VENUE_STATE follows otherwise
+                    if (message.data.success && !message.data.data.full) {
+                        (message.data.data.seats || []).forEach(seat => this.updateSeat(seat));
+                        this.venueVersion = message.data.data.venue_version;
+                        this.updateAvailableCount();
+                    }
+                    break;
+                    
+                case 'WAITING_ROOM':
+                    this.showMessage(`The event is busy - you are number ${message.data.position} in line`, 'info');
+                    break;
+                    
                 case 'SELECT_SEAT_RESPONSE':
                     this.handleSelectResponse(message.data);
                     break;
@@ -194,6 +327,45 @@ class SeatBookingApp {
                     this.handleReleaseResponse(message.data);
                     break;
                     
+                case 'HOLD_KEEPALIVE_RESPONSE':
+                    if (!message.data.success) {
+                        console.warn('Hold keepalive failed:', message.data.message);
+                    }
+                    break;
+                    
+                case 'HOLD_EXPIRING':
+                    this.showMessage(`Your hold on seat ${message.data.seat_id} expires soon - book it now to keep it`, 'error');
+                    break;
+                    
+                case 'BOOKING_CONFIRMED':
+                    this.showMessage(`Booking confirmed for seat ${message.data.seat_id} - ticket ${message.data.ticket_code}`, 'success');
+                    this.lastReceiptUrl = message.data.receipt_url;
+                    break;
+                    
+                case 'PAYMENT_PENDING':
+                    this.showMessage(`Processing payment for seat ${message.data.seat_id}...`, 'info');
+                    break;
+                    
+                case 'PAYMENT_SUCCEEDED':
+                    this.showMessage(`Payment received for seat ${message.data.seat_id}`, 'success');
+                    break;
+                    
+                case 'PAYMENT_FAILED':
+                    this.showMessage(`Payment for seat ${message.data.seat_id} failed${message.data.reason ? ': ' + message.data.reason : ''}`, 'error');
+                    break;
+                    
+                case 'EVENT_CANCELLED':
+                    this.showMessage(`This event has been cancelled${message.data.reason ? ': ' + message.data.reason : ''}. Your ${message.data.orders.length} ticket(s) will be refunded`, 'error');
+                    break;
+                    
+                case 'REFUND_SUCCEEDED':
+                    this.showMessage(`Refund issued for seat ${message.data.seat_id}`, 'success');
+                    break;
+                    
+                case 'REFUND_FAILED':
+                    this.showMessage(`Refund for seat ${message.data.seat_id} is delayed; we will retry it`, 'error');
+                    break;
+                    
                 case 'ERROR':
                     this.showMessage(message.data.error, 'error');
                     break;
@@ -209,9 +381,54 @@ class SeatBookingApp {
     handleWelcome(data) {
         console.log('Welcome message received:', data);
         this.showMessage(`Connected as ${data.client_id}`, 'success');
+        if (data.admission_token) {
+            this.admissionToken = data.admission_token;
+        }
+        if (data.session_token) {
+            this.sessionToken = data.session_token;
+        }
+        this.broadcastSeq = data.broadcast_seq;
+        this.streamId = data.stream_id;
+        if (this.awaitingReplay) {
+            // Missed updates follow this message unless they are gone
+            this.awaitingReplay = false;
+            this.subscribe(Boolean(data.replay && !data.replay.gone));
+        }
+
+        // Branding and sale state are known before the venue arrives
+        if (data.event) {
+            this.applyEventBranding(data.event);
+        }
+        if (data.sale && data.sale.state === 'cancelled') {
+            this.showMessage('This event has been cancelled', 'error');
+        } else if (data.sale && data.sale.state === 'upcoming') {
+            // Count against the server's clock, not the browser's
+            const minutes = Math.ceil((data.sale.opens_at - data.server_time) / 60);
+            this.showMessage(`Sales open in ${minutes} min`, 'info');
+        } else if (data.sale && data.sale.state === 'closed') {
+            this.showMessage('Sales for this event have closed', 'info');
+        } else if (data.availability) {
+            this.showMessage(`${data.availability.available} of ${data.availability.total} seats available`, 'info');
+        }
     }
     
     handleVenueState(data) {
+        this.venueVersion = data.venue_version || null;
+        if (data.event) {
+            this.applyEventBranding(data.event);
+        }
+
+        // Rebuild the map if the layout differs from what is rendered
+        const layoutChanged = data.seats.length !== Object.keys(this.seats).length ||
+            data.seats.some(seatData => !this.seats[seatData.id]);
+        if (layoutChanged) {
+            this.renderSeatMap(data.seats);
+        }
+        this.layout = data.layout || null;
+        if (data.degraded) {
+            this.showMessage('Booking is temporarily unavailable - seats shown may be out of date', 'error');
+        }
+        
         // Update all seats with current state
         data.seats.forEach(seatData => {
             this.updateSeat(seatData);
@@ -219,8 +436,56 @@ class SeatBookingApp {
         this.updateAvailableCount();
     }
     
+    // handleVenueBitmask applies statuses packed two bits a seat, in the order
+    // of our seats sorted by ID, with held seats' holders in a side table
+    handleVenueBitmask(data) {
+        if (data.event) {
+            this.applyEventBranding(data.event);
+        }
+        const ids = Object.keys(this.seats).sort();
+        const bits = atob(data.statuses);
+        const holds = {};
+        (data.holds || []).forEach(hold => { holds[hold.i] = hold; });
+        ids.forEach((id, i) => {
+            const status = (bits.charCodeAt(i >> 2) >> (6 - 2 * (i & 3))) & 3;
+            const hold = holds[i] || {};
+            this.updateSeat({ id, status, held_by: hold.by, expires_at: hold.exp });
+        });
+        if (data.degraded) {
+            this.showMessage('Booking is temporarily unavailable - seats shown may be out of date', 'error');
+        }
+        this.updateAvailableCount();
+    }
+    
+    applyEventBranding(event) {
+        this.holdKeepalive = event.hold_keepalive || null;
+        if (!event.name) return;
+        document.title = event.organizer ? `${event.name} - ${event.organizer}` : event.name;
+        const heading = document.querySelector('h1');
+        if (heading) heading.textContent = event.name;
+    }
+    
+    checkBroadcastSeq(seq) {
+        // Numbers skip where the edge dropped updates; resync rather than show a stale map
+        if (seq === undefined) {
+            return;
+        }
+        if (this.broadcastSeq !== null && seq > this.broadcastSeq + 1) {
+            console.warn(`Missed ${seq - this.broadcastSeq - 1} seat updates, resyncing`);
+            if (this.venueVersion) {
+                this.send({ type: 'SYNC', data: { venue_version: this.venueVersion } });
+            } else {
+                this.send({ type: 'SUBSCRIBE', data: { user_id: this.userId } });
+            }
+        }
+        this.broadcastSeq = Math.max(this.broadcastSeq || 0, seq);
+    }
+    
     handleSeatUpdate(data) {
         // Real-time seat update from NATS
+        if (data.venue_version) {
+            this.venueVersion = Math.max(this.venueVersion || 0, data.venue_version);
+        }
         const seat = data.seat;
         if (seat) {
             this.updateSeat(seat);
@@ -244,8 +509,9 @@ class SeatBookingApp {
             this.showMessage(data.message, 'success');
             this.showSelectedSeatInfo(seatId);
             
-            // Start timer for 30 seconds
-            this.startTimer(seatId, 30);
+            // Count down to the hold's expiry if its update already arrived, else
+            // from the default 30 seconds until it does
+            this.startTimer(seatId, this.holdSecondsLeft(seatId) ?? 30);
         } else {
             this.showMessage(data.message, 'error');
         }
@@ -277,33 +543,56 @@ class SeatBookingApp {
         const seat = this.seats[seatData.id];
         if (!seat) return;
         
+        // Updates can arrive out of order (e.g. booked overtakes held); never go back in version
+        if (seatData.version && seat.version && seatData.version < seat.version) return;
+        
         // Update internal state
-        seat.status = seatData.status;
+        seat.version = seatData.version || seat.version;
+        seat.status = normalizeSeatStatus(seatData.status);
         seat.heldBy = seatData.held_by || null;
         seat.expiresAt = seatData.expires_at || null;
         
         // Update visual state
         seat.element.className = 'seat';
         
-        switch (seatData.status) {
-            case 0: // Available
+        switch (seat.status) {
+            case SeatStatus.AVAILABLE:
                 seat.element.classList.add('available');
                 break;
-            case 1: // Held
+            case SeatStatus.HELD:
                 seat.element.classList.add('held');
                 if (seatData.held_by === this.userId) {
                     seat.element.classList.add('mine');
                 }
                 break;
-            case 2: // Booked
+            case SeatStatus.BOOKED:
                 seat.element.classList.add('booked');
                 break;
         }
+        
+        // Follow the server's expiry, which is shorter when it runs at demo speed
+        if (seatData.id === this.selectedSeat && this.timers[seatData.id]) {
+            const secondsLeft = this.holdSecondsLeft(seatData.id);
+            if (secondsLeft !== null) {
+                this.startTimer(seatData.id, secondsLeft);
+            }
+        }
+    }
+    
+    // holdSecondsLeft returns how long our hold on a seat has left, or null if
+    // we do not hold it
+    holdSecondsLeft(seatId) {
+        const seat = this.seats[seatId];
+        if (!seat || seat.status !== SeatStatus.HELD || seat.heldBy !== this.userId || !seat.expiresAt) {
+            return null;
+        }
+        return Math.max(0, Math.round(seat.expiresAt - Date.now() / 1000));
     }
     
     updateAvailableCount() {
-        const availableCount = Object.values(this.seats).filter(s => s.status === 0).length;
+        const availableCount = Object.values(this.seats).filter(s => s.status === SeatStatus.AVAILABLE).length;
         document.getElementById('count').textContent = availableCount;
+        document.getElementById('total').textContent = Object.keys(this.seats).length;
     }
     
     showSelectedSeatInfo(seatId) {
@@ -327,6 +616,7 @@ class SeatBookingApp {
             if (remaining > 0) {
                 timerElement.textContent = `Time remaining: ${remaining}s`;
                 remaining--;
+                this.keepHoldAlive(seatId);
             } else {
                 timerElement.textContent = 'Hold expired';
                 this.stopTimer(seatId);
@@ -339,6 +629,17 @@ class SeatBookingApp {
         this.timers[seatId] = setInterval(updateTimer, 1000);
     }
     
+    // keepHoldAlive pings our hold while the page is in view, for events that
+    // keep holds alive on activity rather than for a fixed time
+    keepHoldAlive(seatId) {
+        const keepalive = this.holdKeepalive;
+        if (!keepalive || document.hidden) return;
+        const now = Date.now();
+        if (this.lastKeepalive && now - this.lastKeepalive < keepalive.idle_seconds * 1000 / 3) return;
+        this.lastKeepalive = now;
+        this.send({ type: 'HOLD_KEEPALIVE', data: { seat_id: seatId, user_id: this.userId } });
+    }
+    
     stopTimer(seatId) {
         if (this.timers[seatId]) {
             clearInterval(this.timers[seatId]);
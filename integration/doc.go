@@ -0,0 +1,11 @@
+// Package integration runs the booking service and an edge server, built as
+// they ship, against Redis and NATS in containers, and drives whole scenarios
+// through them: buyers racing for one seat, a hold running out, a buyer
+// reconnecting while seats change. Each scenario checks what Redis holds at
+// the end and the seat events published on the way.
+//
+// The tests need Docker and are left out of go test ./... by the integration
+// build tag:
+//
+//	go test -tags integration ./integration
+package integration
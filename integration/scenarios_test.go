@@ -0,0 +1,164 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestOneBuyerWinsARaceForASeat(t *testing.T) {
+	s := startStack(t)
+	seatID, otherSeatID := shared.GetSeatID(0, 0), shared.GetSeatID(0, 1)
+
+	const buyers = 8
+	browsers := make([]*browser, buyers)
+	for i := range browsers {
+		browsers[i] = s.connect(fmt.Sprintf("buyer-%d", i))
+	}
+
+	var wg sync.WaitGroup
+	results := make([]shared.ServerMessage, buyers)
+	errs := make([]error, buyers)
+	for i, b := range browsers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = b.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seatID})
+		}()
+	}
+	wg.Wait()
+
+	winner := ""
+	for i, resp := range results {
+		if errs[i] != nil {
+			t.Fatalf("buyer-%d's select: %v", i, errs[i])
+		}
+		if succeeded(resp) {
+			if winner != "" {
+				t.Fatalf("buyer-%d and %s both hold %s", i, winner, seatID)
+			}
+			winner = fmt.Sprintf("buyer-%d", i)
+		}
+	}
+	if winner == "" {
+		t.Fatalf("no buyer holds %s: %+v", seatID, results)
+	}
+
+	if seat := s.seats()[seatID]; seat.Status != shared.SeatHeld || seat.HeldBy != winner {
+		t.Errorf("seat in Redis = %+v, want it held by %s", seat, winner)
+	}
+	if holder, err := s.redis.Get(context.Background(), fmt.Sprintf(shared.RedisKeySeatLock, seatID)).Result(); err != nil || holder != winner {
+		t.Errorf("seat lock held by %q (%v), want %s", holder, err, winner)
+	}
+
+	// Events are published in order, so once a later one is out no other
+	// event for the raced seat can follow
+	if _, err := s.post(shared.APIEndpointSelectSeat, shared.SeatRequest{SeatID: otherSeatID, UserID: winner}); err != nil {
+		t.Fatalf("select %s: %v", otherSeatID, err)
+	}
+	s.events.awaitSeat(otherSeatID, "held")
+	if events := s.events.forSeat(seatID); len(events) != 1 || events[0].Type != "held" || events[0].UserID != winner {
+		t.Errorf("events for %s = %+v, want one hold by %s", seatID, events, winner)
+	}
+}
+
+func TestHoldExpiresOnTheSimulatedClock(t *testing.T) {
+	s := startStack(t, "SIMULATED_CLOCK=true")
+	seatID := shared.GetSeatID(0, 0)
+	buyer := s.connect("buyer")
+
+	resp, err := buyer.request(shared.MessageTypeSelectSeat, map[string]interface{}{"seat_id": seatID})
+	if err != nil || !succeeded(resp) {
+		t.Fatalf("select: %v %+v", err, resp.Data)
+	}
+	s.events.awaitSeat(seatID, "held")
+
+	// Past the hold's end on the booking service's clock; Redis's has not moved
+	by := (shared.HoldDuration + time.Second).String()
+	if code, err := s.post(shared.APIPrefix+"/admin/clock/advance", map[string]string{"by": by}); err != nil || code != http.StatusOK {
+		t.Fatalf("advance the clock by %s: %d %v", by, code, err)
+	}
+	s.events.awaitSeat(seatID, "auto_released")
+
+	seats := s.seats()
+	if seat := seats[seatID]; seat.Status != shared.SeatAvailable || seat.HeldBy != "" {
+		t.Errorf("seat in Redis = %+v, want it released", seat)
+	}
+	ctx := context.Background()
+	if n, err := s.redis.Exists(ctx, fmt.Sprintf(shared.RedisKeySeatLock, seatID)).Result(); err != nil || n != 0 {
+		t.Errorf("seat lock still there (%v)", err)
+	}
+	if _, err := s.redis.ZScore(ctx, shared.RedisKeyHoldExpiry, seatID).Result(); err != redis.Nil {
+		t.Errorf("seat still in the hold expiry index (%v)", err)
+	}
+	if events := s.events.forSeat(seatID); len(events) != 2 {
+		t.Errorf("events for %s = %+v, want the hold and its release", seatID, events)
+	}
+	buyer.awaitSeats(seats)
+}
+
+func TestReconnectDuringABurstCatchesUp(t *testing.T) {
+	s := startStack(t)
+	watcher := s.connect("watcher")
+
+	// Every buyer holds a seat, then books it or lets it go: two events each
+	const buyers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, buyers)
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := shared.SeatRequest{SeatID: shared.GetSeatID(i/10, i%10), UserID: fmt.Sprintf("buyer-%d", i)}
+			next := shared.APIEndpointBookSeat
+			if i%2 == 1 {
+				next = shared.APIEndpointReleaseSeat
+			}
+			for _, path := range []string{shared.APIEndpointSelectSeat, next} {
+				if code, err := s.post(path, req); err != nil || code != http.StatusOK {
+					errs <- fmt.Errorf("%s %s for %s: %d %v", path, req.SeatID, req.UserID, code, err)
+					return
+				}
+			}
+		}()
+	}
+
+	// Drop the connection partway through and come back on a new one
+	s.events.await(buyers / 2)
+	watcher.conn.Close()
+	watcher = s.connect("watcher")
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	events := s.events.await(2 * buyers)
+
+	seats := s.seats()
+	for i := 0; i < buyers; i++ {
+		seat := seats[shared.GetSeatID(i/10, i%10)]
+		want := shared.SeatBooked
+		if i%2 == 1 {
+			want = shared.SeatAvailable
+		}
+		if seat.Status != want {
+			t.Errorf("buyer-%d's seat in Redis = %+v, want it %s", i, seat, want)
+		}
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq <= events[i-1].Seq {
+			t.Errorf("event %d has seq %d after %d", i, events[i].Seq, events[i-1].Seq)
+		}
+	}
+	watcher.awaitSeats(seats)
+}
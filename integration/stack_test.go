@@ -0,0 +1,470 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/nats-io/nats.go"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// How long a scenario waits for the stack to start or a change to arrive
+const settleTimeout = 30 * time.Second
+
+// The booking service's AUTH_SECRET, for the admin requests scenarios make
+const authSecret = "integration-secret"
+
+// binaries is the directory TestMain builds the services into
+var binaries string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "seat-integration")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, service := range []string{"booking-service", "edge-server"} {
+		build := exec.Command("go", "build", "-o", filepath.Join(dir, service), "concert-booking/"+service)
+		build.Stdout, build.Stderr = os.Stderr, os.Stderr
+		if err := build.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "build %s: %v\n", service, err)
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+	}
+	binaries = dir
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// stack is Redis and NATS in containers with a booking service and an edge
+// server running against them, torn down when the test ends
+type stack struct {
+	t          *testing.T
+	bookingURL string
+	edgeURL    string // WebSocket URL
+	redis      *redis.Client
+	events     *eventLog
+}
+
+// startStack starts a stack, passing env to the booking service on top of
+// what it needs to find Redis and NATS
+func startStack(t *testing.T, env ...string) *stack {
+	t.Helper()
+
+	redisAddr := startContainer(t, testcontainers.ContainerRequest{
+		Image:        "redis:7-alpine",
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   wait.ForListeningPort("6379/tcp"),
+	})
+	natsURL := "nats://" + startContainer(t, testcontainers.ContainerRequest{
+		Image:        "nats:2-alpine",
+		ExposedPorts: []string{"4222/tcp"},
+		WaitingFor:   wait.ForListeningPort("4222/tcp"),
+	})
+
+	s := &stack{t: t, redis: redis.NewClient(&redis.Options{Addr: redisAddr})}
+	t.Cleanup(func() { s.redis.Close() })
+	s.events = recordEvents(t, natsURL)
+
+	common := []string{"REDIS_URL=" + redisAddr, "NATS_URL=" + natsURL, "SHUTDOWN_DRAIN=0"}
+	bookingPort, edgePort := freePort(t), freePort(t)
+	s.bookingURL = "http://localhost:" + bookingPort
+	s.run("booking-service", append(append(common, "PORT="+bookingPort, "AUTH_SECRET="+authSecret), env...)...)
+	s.awaitReady(s.bookingURL)
+	s.run("edge-server", append(common, "PORT="+edgePort, "BOOKING_SERVICE_URL="+s.bookingURL)...)
+	s.awaitReady("http://localhost:" + edgePort)
+	s.edgeURL = "ws://localhost:" + edgePort + "/ws"
+	return s
+}
+
+// startContainer starts req and returns the host:port its first port is
+// published on
+func startContainer(t *testing.T, req testcontainers.ContainerRequest) string {
+	t.Helper()
+
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{ContainerRequest: req, Started: true})
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("start %s: %v", req.Image, err)
+	}
+	endpoint, err := container.Endpoint(ctx, "")
+	if err != nil {
+		t.Fatalf("%s endpoint: %v", req.Image, err)
+	}
+	return endpoint
+}
+
+// freePort returns a port nothing is listening on
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("find a free port: %v", err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+// run starts a built service, stopping it when the test ends and logging its
+// output if the test failed
+func (s *stack) run(service string, env ...string) {
+	s.t.Helper()
+
+	var output bytes.Buffer
+	cmd := exec.Command(filepath.Join(binaries, service))
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		s.t.Fatalf("start %s: %v", service, err)
+	}
+	s.t.Cleanup(func() {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(shared.ShutdownTimeout):
+			cmd.Process.Kill()
+			<-done
+		}
+		if s.t.Failed() {
+			s.t.Logf("%s output:\n%s", service, output.String())
+		}
+	})
+}
+
+// awaitReady waits for a service to answer /ready
+func (s *stack) awaitReady(baseURL string) {
+	s.t.Helper()
+
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		resp, err := http.Get(baseURL + "/ready")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("%s not ready after %s: %v", baseURL, settleTimeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// post sends body as JSON to the booking service as an admin, returning the
+// status code
+func (s *stack) post(path string, body interface{}) (int, error) {
+	raw, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, s.bookingURL+path, bytes.NewReader(raw))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token := shared.SignSessionToken([]byte(authSecret), shared.SessionClaims{
+		UserID:    "integration",
+		Role:      shared.RoleAdmin,
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// seats returns the seats as Redis holds them, by ID
+func (s *stack) seats() map[string]shared.Seat {
+	s.t.Helper()
+
+	raw, err := s.redis.HGetAll(context.Background(), shared.RedisKeyVenueSeats).Result()
+	if err != nil {
+		s.t.Fatalf("read seats from Redis: %v", err)
+	}
+	seats := make(map[string]shared.Seat, len(raw))
+	for id, data := range raw {
+		var seat shared.Seat
+		if err := json.Unmarshal([]byte(data), &seat); err != nil {
+			s.t.Fatalf("seat %s in Redis: %v", id, err)
+		}
+		seats[id] = seat
+	}
+	return seats
+}
+
+// eventLog records every seat event published on NATS, batches unpacked, in
+// the order they arrive
+type eventLog struct {
+	t      *testing.T
+	mu     sync.Mutex
+	events []shared.SeatEvent
+}
+
+func recordEvents(t *testing.T, natsURL string) *eventLog {
+	t.Helper()
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		t.Fatalf("connect to NATS: %v", err)
+	}
+	t.Cleanup(nc.Close)
+
+	log := &eventLog{t: t}
+	_, err = nc.Subscribe(shared.NATSTopicAllSeats, func(msg *nats.Msg) {
+		var event shared.SeatEvent
+		if err := shared.UnmarshalEvent(msg.Data, &event); err != nil {
+			t.Errorf("undecodable seat event on %s: %v", msg.Subject, err)
+			return
+		}
+		log.mu.Lock()
+		log.events = append(log.events, event.Unbatch()...)
+		log.mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("subscribe to seat events: %v", err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("subscribe to seat events: %v", err)
+	}
+	return log
+}
+
+// all returns the events recorded so far
+func (l *eventLog) all() []shared.SeatEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]shared.SeatEvent(nil), l.events...)
+}
+
+// forSeat returns the events recorded so far for seatID
+func (l *eventLog) forSeat(seatID string) []shared.SeatEvent {
+	var events []shared.SeatEvent
+	for _, event := range l.all() {
+		if event.SeatID == seatID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// await waits until n events have been recorded
+func (l *eventLog) await(n int) []shared.SeatEvent {
+	l.t.Helper()
+
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		events := l.all()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			l.t.Fatalf("%d seat events published, want %d", len(events), n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// awaitSeat waits for an event of eventType for seatID
+func (l *eventLog) awaitSeat(seatID, eventType string) shared.SeatEvent {
+	l.t.Helper()
+
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		for _, event := range l.forSeat(seatID) {
+			if event.Type == eventType {
+				return event
+			}
+		}
+		if time.Now().After(deadline) {
+			l.t.Fatalf("no %s event for %s; saw %+v", eventType, seatID, l.forSeat(seatID))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// browser is a buyer connected to the stack's edge, keeping the seat map it
+// is sent up to date as a client would
+type browser struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	seats  map[string]shared.Seat
+	queued []shared.ServerMessage // read but not yet returned
+	sent   int
+}
+
+// seatUpdate is the part of a SEAT_UPDATE a browser draws from
+type seatUpdate struct {
+	SeatID  string       `json:"seat_id"`
+	Version int64        `json:"version"`
+	Seat    *shared.Seat `json:"seat"`
+}
+
+// connect opens a buyer's connection and subscribes it as userID, returning
+// once it has its seat map
+func (s *stack) connect(userID string) *browser {
+	s.t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.edgeURL, nil)
+	if err != nil {
+		s.t.Fatalf("dial: %v", err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	b := &browser{t: s.t, conn: conn}
+	ack, err := b.request(shared.MessageTypeSubscribe, map[string]interface{}{"user_id": userID})
+	if err != nil || !succeeded(ack) {
+		s.t.Fatalf("subscribe as %s: %v %+v", userID, err, ack.Data)
+	}
+	for b.seats == nil {
+		if _, err := b.next(time.Now().Add(settleTimeout)); err != nil {
+			s.t.Fatalf("waiting for the seat map: %v", err)
+		}
+	}
+	return b
+}
+
+// request sends a command and returns its response. It may be called from
+// any goroutine, but only one at a time per browser.
+func (b *browser) request(msgType string, data map[string]interface{}) (shared.ServerMessage, error) {
+	b.sent++
+	requestID := msgType + "-" + strconv.Itoa(b.sent)
+	raw, _ := json.Marshal(data)
+	if err := b.conn.WriteJSON(shared.ClientMessage{Type: msgType, RequestID: requestID, Data: raw}); err != nil {
+		return shared.ServerMessage{}, err
+	}
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		m, err := b.next(deadline)
+		if err != nil || m.RequestID == requestID {
+			return m, err
+		}
+	}
+}
+
+// next returns the next message from the edge, drawing it on the seat map
+func (b *browser) next(deadline time.Time) (shared.ServerMessage, error) {
+	if len(b.queued) == 0 {
+		b.conn.SetReadDeadline(deadline)
+		_, frame, err := b.conn.ReadMessage()
+		if err != nil {
+			return shared.ServerMessage{}, err
+		}
+		// Frames may batch several messages, one per line
+		for _, line := range bytes.Split(frame, []byte{'\n'}) {
+			var m shared.ServerMessage
+			if err := json.Unmarshal(line, &m); err != nil {
+				return shared.ServerMessage{}, fmt.Errorf("edge wrote invalid JSON %q: %v", line, err)
+			}
+			b.queued = append(b.queued, m)
+		}
+	}
+	m := b.queued[0]
+	b.queued = b.queued[1:]
+	return m, b.draw(m)
+}
+
+// draw applies a seat map message; updates older than the seat they change
+// are stale and skipped
+func (b *browser) draw(m shared.ServerMessage) error {
+	raw, _ := json.Marshal(m.Data)
+	var updates []seatUpdate
+	switch m.Type {
+	case shared.MessageTypeVenueState:
+		var state shared.VenueState
+		if err := json.Unmarshal(raw, &state); err != nil {
+			return fmt.Errorf("VENUE_STATE: %v", err)
+		}
+		b.seats = make(map[string]shared.Seat, len(state.Seats))
+		for _, seat := range state.Seats {
+			b.seats[seat.ID] = seat
+		}
+		return nil
+	case shared.MessageTypeSeatUpdate:
+		var update seatUpdate
+		if err := json.Unmarshal(raw, &update); err != nil {
+			return fmt.Errorf("SEAT_UPDATE: %v", err)
+		}
+		updates = []seatUpdate{update}
+	case shared.MessageTypeSeatUpdateBatch:
+		var batch struct {
+			Updates []seatUpdate `json:"updates"`
+		}
+		if err := json.Unmarshal(raw, &batch); err != nil {
+			return fmt.Errorf("SEAT_UPDATE_BATCH: %v", err)
+		}
+		updates = batch.Updates
+	}
+	for _, update := range updates {
+		if update.Seat != nil && b.seats != nil && update.Version > b.seats[update.SeatID].Version {
+			b.seats[update.SeatID] = *update.Seat
+		}
+	}
+	return nil
+}
+
+// awaitSeats reads from the edge until the browser's seat map matches want
+func (b *browser) awaitSeats(want map[string]shared.Seat) {
+	b.t.Helper()
+
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		diff := seatMapDiff(b.seats, want)
+		if diff == "" {
+			return
+		}
+		if _, err := b.next(deadline); err != nil {
+			b.t.Fatalf("seat map never caught up: %s (%v)", diff, err)
+		}
+	}
+}
+
+// seatMapDiff describes the first seat got draws differently from want, or
+// returns "" if they agree
+func seatMapDiff(got, want map[string]shared.Seat) string {
+	if len(got) != len(want) {
+		return fmt.Sprintf("%d seats, want %d", len(got), len(want))
+	}
+	for id, w := range want {
+		g, ok := got[id]
+		if !ok {
+			return fmt.Sprintf("%s missing", id)
+		}
+		if g.Status != w.Status || g.HeldBy != w.HeldBy || g.Version != w.Version {
+			return fmt.Sprintf("%s is %s by %q at version %d, want %s by %q at version %d",
+				id, g.Status, g.HeldBy, g.Version, w.Status, w.HeldBy, w.Version)
+		}
+	}
+	return ""
+}
+
+func succeeded(m shared.ServerMessage) bool {
+	data, _ := m.Data.(map[string]interface{})
+	success, _ := data["success"].(bool)
+	return success
+}
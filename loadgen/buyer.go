@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"concert-booking/shared"
+)
+
+// message is a server message with its data left to decode by type
+type message struct {
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+// seatChange is the part of a SEAT_UPDATE, or an entry of a
+// SEAT_UPDATE_BATCH, a buyer keeps its venue with
+type seatChange struct {
+	SeatID string            `json:"seat_id"`
+	Status shared.SeatStatus `json:"status"`
+}
+
+// operationResult is the data of a *_RESPONSE message
+type operationResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// buyer is one simulated client. It connects and subscribes, then until the
+// run ends thinks, holds a seat its copy of the venue shows available, thinks
+// again and books or releases it, as people at an on-sale do.
+type buyer struct {
+	cfg    Config
+	userID string
+	rng    *rand.Rand
+	rec    *recorder
+	ws     *websocket.Conn
+
+	// Closed by the read loop when the connection ends
+	closed chan struct{}
+
+	mu         sync.Mutex
+	seats      map[string]shared.SeatStatus
+	venueReady chan struct{} // closed on the first VENUE_STATE
+	pending    map[string]chan message
+	requests   int
+}
+
+func newBuyer(cfg Config, n int, rec *recorder) *buyer {
+	return &buyer{
+		cfg:        cfg,
+		userID:     cfg.UserPrefix + "-" + strconv.Itoa(n),
+		rng:        rand.New(rand.NewPCG(cfg.Seed, uint64(n))),
+		rec:        rec,
+		closed:     make(chan struct{}),
+		seats:      make(map[string]shared.SeatStatus),
+		venueReady: make(chan struct{}),
+		pending:    make(map[string]chan message),
+	}
+}
+
+// run simulates the buyer until ctx is done, counting itself in active from
+// when it has its seat map until it leaves
+func (b *buyer) run(ctx context.Context, active *atomic.Int64) {
+	start := time.Now()
+	if err := b.connect(); err != nil {
+		if ctx.Err() == nil {
+			b.rec.record(opConnect, outcomeError, 0, err)
+		}
+		return
+	}
+	b.rec.record(opConnect, outcomeOK, time.Since(start), nil)
+	go b.readLoop()
+	defer func() {
+		b.ws.Close()
+		<-b.closed
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.ws.Close()
+		case <-b.closed:
+		}
+	}()
+
+	if !b.subscribe(ctx) {
+		return
+	}
+	active.Add(1)
+	defer active.Add(-1)
+
+	for b.think(ctx) {
+		seatID := b.pickSeat()
+		if seatID == "" {
+			// Sold out for now; releases may free seats up again
+			continue
+		}
+		if !b.seatCommand(ctx, opSelect, shared.MessageTypeSelectSeat, seatID) || !b.think(ctx) {
+			continue
+		}
+		if b.rng.Float64() < b.cfg.BookRatio {
+			b.seatCommand(ctx, opBook, shared.MessageTypeBookSeat, seatID)
+		} else {
+			b.seatCommand(ctx, opRelease, shared.MessageTypeReleaseSeat, seatID)
+		}
+	}
+}
+
+// connect dials the edge and waits for WELCOME. Time in the waiting room
+// counts against the timeout like any other wait.
+func (b *buyer) connect() error {
+	u, err := url.Parse(b.cfg.URL)
+	if err != nil {
+		return err
+	}
+	if len(b.cfg.AuthSecret) > 0 {
+		q := u.Query()
+		q.Set("token", shared.SignSessionToken(b.cfg.AuthSecret, shared.SessionClaims{
+			UserID:    b.userID,
+			Role:      shared.RoleBuyer,
+			ExpiresAt: time.Now().Add(b.cfg.Duration + time.Hour).Unix(),
+		}))
+		u.RawQuery = q.Encode()
+	}
+
+	dialer := websocket.Dialer{HandshakeTimeout: b.cfg.Timeout}
+	ws, _, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		return err
+	}
+	ws.SetReadDeadline(time.Now().Add(b.cfg.Timeout))
+	for {
+		_, frame, err := ws.ReadMessage()
+		if err != nil {
+			ws.Close()
+			return fmt.Errorf("waiting for WELCOME: %w", err)
+		}
+		var m message
+		if json.Unmarshal(bytes.SplitN(frame, []byte{'\n'}, 2)[0], &m) == nil && m.Type == "WELCOME" {
+			break
+		}
+	}
+	ws.SetReadDeadline(time.Time{})
+	b.ws = ws
+	return nil
+}
+
+// subscribe sends SUBSCRIBE and waits for the venue; the subscribe latency is
+// the time until the buyer can draw its seat map
+func (b *buyer) subscribe(ctx context.Context) bool {
+	start := time.Now()
+	m, err := b.request(shared.MessageTypeSubscribe, map[string]interface{}{"user_id": b.userID})
+	if err == nil {
+		err = expectSuccess(m, "SUBSCRIBE_ACK")
+	}
+	if err == nil {
+		select {
+		case <-b.venueReady:
+		case <-b.closed:
+			err = errors.New("connection closed before VENUE_STATE")
+		case <-time.After(b.cfg.Timeout):
+			err = fmt.Errorf("no VENUE_STATE within %s", b.cfg.Timeout)
+		}
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		b.rec.record(opSubscribe, outcomeError, 0, err)
+		return false
+	}
+	b.rec.record(opSubscribe, outcomeOK, time.Since(start), nil)
+	return true
+}
+
+// seatCommand selects, books or releases seatID, reporting whether the edge
+// said it succeeded
+func (b *buyer) seatCommand(ctx context.Context, op, msgType, seatID string) bool {
+	start := time.Now()
+	m, err := b.request(msgType, map[string]interface{}{"seat_id": seatID, "user_id": b.userID})
+	latency := time.Since(start)
+	if ctx.Err() != nil {
+		return false
+	}
+	if err == nil {
+		err = expectSuccess(m, msgType+"_RESPONSE")
+	}
+	var refused errRefused
+	switch {
+	case errors.As(err, &refused):
+		b.rec.record(op, outcomeRefused, latency, nil)
+		return false
+	case err != nil:
+		b.rec.record(op, outcomeError, latency, err)
+		return false
+	}
+	b.rec.record(op, outcomeOK, latency, nil)
+	return true
+}
+
+// request sends a client message and waits for the message answering it
+func (b *buyer) request(msgType string, data map[string]interface{}) (message, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return message{}, err
+	}
+	b.mu.Lock()
+	b.requests++
+	requestID := "lg-" + strconv.Itoa(b.requests)
+	answer := make(chan message, 1)
+	b.pending[requestID] = answer
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.pending, requestID)
+		b.mu.Unlock()
+	}()
+
+	b.ws.SetWriteDeadline(time.Now().Add(b.cfg.Timeout))
+	if err := b.ws.WriteJSON(shared.ClientMessage{Type: msgType, Data: raw, RequestID: requestID}); err != nil {
+		return message{}, err
+	}
+	select {
+	case m := <-answer:
+		return m, nil
+	case <-b.closed:
+		return message{}, fmt.Errorf("connection closed waiting for the answer to %s", msgType)
+	case <-time.After(b.cfg.Timeout):
+		return message{}, fmt.Errorf("no answer to %s within %s", msgType, b.cfg.Timeout)
+	}
+}
+
+// errRefused is an operation the edge answered with success false
+type errRefused string
+
+func (e errRefused) Error() string { return string(e) }
+
+// expectSuccess checks m is a msgType saying the operation succeeded
+func expectSuccess(m message, msgType string) error {
+	if m.Type == shared.MessageTypeError {
+		var data struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		json.Unmarshal(m.Data, &data)
+		if data.Code == "" {
+			return fmt.Errorf("ERROR %s", data.Error)
+		}
+		return fmt.Errorf("ERROR %s", data.Code)
+	}
+	if m.Type != msgType {
+		return fmt.Errorf("answered with %s, want %s", m.Type, msgType)
+	}
+	var result operationResult
+	if err := json.Unmarshal(m.Data, &result); err != nil {
+		return fmt.Errorf("%s data: %w", msgType, err)
+	}
+	if !result.Success {
+		return errRefused(result.Message)
+	}
+	return nil
+}
+
+// readLoop reads until the connection ends, keeping the buyer's venue
+// current and handing answers to the requests waiting for them
+func (b *buyer) readLoop() {
+	defer close(b.closed)
+	for {
+		_, frame, err := b.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		// A frame may carry several messages, newline separated
+		for _, data := range bytes.Split(frame, []byte{'\n'}) {
+			var m message
+			if json.Unmarshal(data, &m) != nil {
+				continue
+			}
+			b.handle(m)
+		}
+	}
+}
+
+func (b *buyer) handle(m message) {
+	switch m.Type {
+	case shared.MessageTypeVenueState:
+		var venue shared.VenueState
+		if json.Unmarshal(m.Data, &venue) != nil {
+			return
+		}
+		b.mu.Lock()
+		clear(b.seats)
+		for _, seat := range venue.Seats {
+			b.seats[seat.ID] = seat.Status
+		}
+		select {
+		case <-b.venueReady:
+		default:
+			close(b.venueReady)
+		}
+		b.mu.Unlock()
+
+	case shared.MessageTypeSeatUpdate:
+		var change seatChange
+		if json.Unmarshal(m.Data, &change) == nil {
+			b.applyChanges(change)
+		}
+
+	case shared.MessageTypeSeatUpdateBatch:
+		var batch struct {
+			Updates []seatChange `json:"updates"`
+		}
+		if json.Unmarshal(m.Data, &batch) == nil {
+			b.applyChanges(batch.Updates...)
+		}
+
+	default:
+		if m.RequestID == "" {
+			return
+		}
+		b.mu.Lock()
+		answer := b.pending[m.RequestID]
+		b.mu.Unlock()
+		if answer != nil {
+			select {
+			case answer <- m:
+			default:
+			}
+		}
+	}
+}
+
+func (b *buyer) applyChanges(changes ...seatChange) {
+	b.mu.Lock()
+	for _, change := range changes {
+		b.seats[change.SeatID] = change.Status
+	}
+	b.mu.Unlock()
+	b.rec.seatUpdates(len(changes))
+}
+
+// pickSeat returns a seat the buyer's venue shows available, chosen at
+// random, or "" if there is none
+func (b *buyer) pickSeat() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	picked, seen := "", 0
+	for id, status := range b.seats {
+		if status != shared.SeatAvailable {
+			continue
+		}
+		seen++
+		if b.rng.IntN(seen) == 0 {
+			picked = id
+		}
+	}
+	return picked
+}
+
+// think waits for a think time drawn around the configured mean, reporting
+// false if the run ended or the connection closed meanwhile
+func (b *buyer) think(ctx context.Context) bool {
+	timer := time.NewTimer(time.Duration(b.rng.ExpFloat64() * float64(b.cfg.Think)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-b.closed:
+		return false
+	}
+}
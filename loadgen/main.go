@@ -0,0 +1,252 @@
+// Command loadgen puts an edge server under the load of an on-sale: simulated
+// buyers arrive, subscribe, and keep holding seats and booking or releasing
+// them, while loadgen times every operation. Run it against a staging stack
+// to see how the hub, the booking service and its Redis scripts hold up
+// before real buyers arrive.
+//
+// Usage:
+//
+//	loadgen -url ws://localhost:8081/ws -clients 2000 -rate 200 -duration 2m [-think 2s] [-book 0.3] [-json]
+//
+// Buyers arrive -rate a second, at random as people do, until -clients have;
+// -rate 0 connects them all at once. Between operations each thinks for a
+// random time averaging -think. Of the seats a buyer holds, -book are booked
+// and the rest released. The report gives the count, outcome and latency
+// percentiles of each operation: a refused operation is one the edge answered
+// with success false, such as selecting a seat another buyer just took, and
+// an error is one it did not answer properly or in time.
+//
+// With -capacity, loadgen also cuts the run into one-second windows and
+// prints a capacity report in Markdown, or with -json as part of the JSON
+// report: the most connections per edge and seat commands a second per
+// booking service instance seen while seat commands kept the -slo p99 with
+// no errors, and with -redis, the Redis commands each seat command costs and
+// the headroom left against -redis-max-ops. -edges and -booking-instances say
+// how many instances the load was spread over.
+//
+//	loadgen -url ws://staging:3000/ws -clients 20000 -rate 500 -duration 5m -capacity \
+//		-edges 2 -booking-instances 3 -redis staging-redis:6379 > capacity.md
+//
+// Edges limit connections per address (EDGE_MAX_CONNS_PER_IP); raise the
+// limit on the edge under test. With EDGE_AUTH_SECRET set on the edge, pass
+// the same secret as -auth-secret for each buyer to get a token.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"concert-booking/capacity"
+)
+
+// Config is the load a run generates
+type Config struct {
+	URL        string
+	Clients    int
+	Rate       float64 // buyer arrivals a second; 0 for all at once
+	Duration   time.Duration
+	Think      time.Duration // mean time between a buyer's operations
+	BookRatio  float64       // share of held seats booked rather than released
+	UserPrefix string
+	AuthSecret []byte
+	Timeout    time.Duration // how long to wait for each answer
+	Seed       uint64
+
+	// Sample the run for a capacity report; nil not to
+	Capacity *capacity.Config
+}
+
+// Report is the outcome of a run
+type Report struct {
+	URL         string           `json:"url"`
+	Clients     int              `json:"clients"`    // buyers that arrived
+	Subscribed  int              `json:"subscribed"` // buyers that got their seat map
+	DurationSec float64          `json:"duration_sec"`
+	SeatUpdates int64            `json:"seat_updates"` // seat updates the buyers received in all
+	Operations  []OpSummary      `json:"operations"`
+	Errors      []ErrorCount     `json:"errors,omitempty"`
+	Capacity    *capacity.Report `json:"capacity,omitempty"`
+}
+
+// ErrorCount is how often one error happened
+type ErrorCount struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+}
+
+func main() {
+	cfg := Config{}
+	flag.StringVar(&cfg.URL, "url", "ws://localhost:8081/ws", "edge server WebSocket URL")
+	flag.IntVar(&cfg.Clients, "clients", 100, "number of buyers")
+	flag.Float64Var(&cfg.Rate, "rate", 10, "buyers arriving a second; 0 connects them all at once")
+	flag.DurationVar(&cfg.Duration, "duration", time.Minute, "how long to run")
+	flag.DurationVar(&cfg.Think, "think", 2*time.Second, "mean think time between a buyer's operations")
+	flag.Float64Var(&cfg.BookRatio, "book", 0.3, "share of held seats booked; the rest are released")
+	flag.StringVar(&cfg.UserPrefix, "user-prefix", "loadgen", "buyers are users PREFIX-0, PREFIX-1, ...")
+	authSecret := flag.String("auth-secret", "", "EDGE_AUTH_SECRET of the edge, to sign each buyer a token")
+	flag.DurationVar(&cfg.Timeout, "timeout", 5*time.Second, "how long to wait for each answer")
+	flag.Uint64Var(&cfg.Seed, "seed", 1, "seed for arrivals, think times and seat choices")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	withCapacity := flag.Bool("capacity", false, "print a capacity report, in Markdown unless -json")
+	capacityCfg := capacity.Config{}
+	flag.IntVar(&capacityCfg.Edges, "edges", 1, "edge servers behind -url, for -capacity")
+	flag.IntVar(&capacityCfg.BookingInstances, "booking-instances", 1, "booking service instances behind the edges, for -capacity")
+	flag.DurationVar(&capacityCfg.SLO, "slo", 250*time.Millisecond, "p99 seat command latency the load must keep to count as sustained, for -capacity")
+	flag.StringVar(&capacityCfg.RedisAddr, "redis", "", "address of the booking service's Redis, to sample its commands for -capacity")
+	flag.Float64Var(&capacityCfg.RedisMaxOps, "redis-max-ops", 100000, "commands a second the Redis deployment is rated for, for -capacity")
+	flag.Parse()
+	cfg.AuthSecret = []byte(*authSecret)
+	if *withCapacity {
+		cfg.Capacity = &capacityCfg
+	}
+
+	if cfg.Clients < 1 || cfg.Rate < 0 || cfg.BookRatio < 0 || cfg.BookRatio > 1 {
+		fmt.Fprintln(os.Stderr, "loadgen: -clients must be positive, -rate not negative and -book between 0 and 1")
+		os.Exit(2)
+	}
+	if capacityCfg.Edges < 1 || capacityCfg.BookingInstances < 1 {
+		fmt.Fprintln(os.Stderr, "loadgen: -edges and -booking-instances must be positive")
+		os.Exit(2)
+	}
+
+	result := Run(context.Background(), cfg)
+	if err := printReport(os.Stdout, result, *asJSON); err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		os.Exit(2)
+	}
+	if result.Subscribed == 0 {
+		os.Exit(1)
+	}
+}
+
+// Run generates cfg's load until cfg.Duration has passed or ctx is done
+func Run(ctx context.Context, cfg Config) *Report {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	rec := newRecorder()
+	arrivals := rand.New(rand.NewPCG(cfg.Seed, 0))
+	var active atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	var windows []capacity.Window
+	sampled := make(chan struct{})
+	if cfg.Capacity != nil {
+		s := &capacity.Sampler{
+			Cut:    rec.cutWindow,
+			Active: func() int { return int(active.Load()) },
+		}
+		if cfg.Capacity.RedisAddr != "" {
+			s.Redis = capacity.RedisCommandCounter(cfg.Capacity.RedisAddr)
+		}
+		go func() {
+			defer close(sampled)
+			windows = s.Sample(ctx, start)
+		}()
+	} else {
+		close(sampled)
+	}
+	clients := 0
+	for clients < cfg.Clients {
+		if clients > 0 && cfg.Rate > 0 {
+			gap := time.Duration(arrivals.ExpFloat64() / cfg.Rate * float64(time.Second))
+			select {
+			case <-ctx.Done():
+			case <-time.After(gap):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		b := newBuyer(cfg, clients, rec)
+		clients++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.run(ctx, &active)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	<-sampled
+
+	report := &Report{
+		URL:         cfg.URL,
+		Clients:     clients,
+		Subscribed:  rec.subscribed(),
+		DurationSec: elapsed.Seconds(),
+		SeatUpdates: rec.seatUpdateCount(),
+		Operations:  rec.summarize(elapsed),
+	}
+	for text, count := range rec.errors {
+		report.Errors = append(report.Errors, ErrorCount{Error: text, Count: count})
+	}
+	sort.Slice(report.Errors, func(i, j int) bool {
+		if report.Errors[i].Count != report.Errors[j].Count {
+			return report.Errors[i].Count > report.Errors[j].Count
+		}
+		return report.Errors[i].Error < report.Errors[j].Error
+	})
+	if cfg.Capacity != nil {
+		report.Capacity = capacity.Plan(*cfg.Capacity, windows)
+	}
+	return report
+}
+
+// printReport prints a report as a table, or the capacity report if there is
+// one, or either as JSON
+func printReport(w io.Writer, report *Report, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	if report.Capacity != nil {
+		printCapacityMarkdown(w, report)
+		return nil
+	}
+
+	fmt.Fprintf(w, "edge: %s\n", report.URL)
+	fmt.Fprintf(w, "%d buyers in %.1fs, %d subscribed, %d seat updates received\n", report.Clients, report.DurationSec,
+		report.Subscribed, report.SeatUpdates)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tCOUNT\tOK\tREFUSED\tERRORS\tPER SEC\tP50 MS\tP90 MS\tP99 MS\tMAX MS\t")
+	for _, s := range report.Operations {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n", s.Op, s.Count, s.OK, s.Refused, s.Errors, s.PerSec,
+			s.P50, s.P90, s.P99, s.Max)
+	}
+	tw.Flush()
+	for i, e := range report.Errors {
+		if i == 5 {
+			fmt.Fprintf(w, "... and %d other errors\n", len(report.Errors)-i)
+			break
+		}
+		fmt.Fprintf(w, "%6d  %s\n", e.Count, e.Error)
+	}
+	return nil
+}
+
+// printCapacityMarkdown writes the capacity report, with the run it was
+// computed from, as Markdown for a sizing document or pull request
+func printCapacityMarkdown(w io.Writer, report *Report) {
+	fmt.Fprintf(w, "# Capacity report\n\n%d buyers against `%s` for %.0fs. ", report.Clients, report.URL, report.DurationSec)
+	capacity.WriteMarkdown(w, report.Capacity)
+
+	fmt.Fprintf(w, "\n## Operations\n\n| Op | Count | OK | Refused | Errors | Per sec | p50 ms | p90 ms | p99 ms | Max ms |\n")
+	fmt.Fprintf(w, "|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, s := range report.Operations {
+		fmt.Fprintf(w, "| %s | %d | %d | %d | %d | %.1f | %.1f | %.1f | %.1f | %.1f |\n", s.Op, s.Count, s.OK, s.Refused, s.Errors,
+			s.PerSec, s.P50, s.P90, s.P99, s.Max)
+	}
+}
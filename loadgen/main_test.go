@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+
+	"concert-booking/capacity"
+	"concert-booking/shared"
+)
+
+// fakeEdge answers the messages loadgen sends for a venue of a few seats:
+// each hold, booking and release succeeds or fails as the booking service
+// would, and is broadcast to every connection
+type fakeEdge struct {
+	mu    sync.Mutex
+	seats map[string]shared.Seat
+	conns map[*websocket.Conn]*sync.Mutex
+}
+
+func newFakeEdge(t *testing.T, seatIDs ...string) string {
+	t.Helper()
+	edge := &fakeEdge{seats: make(map[string]shared.Seat), conns: make(map[*websocket.Conn]*sync.Mutex)}
+	for _, id := range seatIDs {
+		edge.seats[id] = shared.Seat{ID: id, Status: shared.SeatAvailable}
+	}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		edge.serve(ws)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func (e *fakeEdge) serve(ws *websocket.Conn) {
+	writeMu := &sync.Mutex{}
+	e.mu.Lock()
+	e.conns[ws] = writeMu
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.conns, ws)
+		e.mu.Unlock()
+		ws.Close()
+	}()
+	send := func(m shared.ServerMessage) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		ws.WriteJSON(m)
+	}
+
+	send(shared.ServerMessage{Type: "WELCOME", Data: map[string]interface{}{"client_id": "c"}})
+	for {
+		var msg shared.ClientMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		var req shared.SeatRequest
+		json.Unmarshal(msg.Data, &req)
+		if msg.Type == shared.MessageTypeSubscribe {
+			send(shared.ServerMessage{Type: "SUBSCRIBE_ACK", RequestID: msg.RequestID, Data: map[string]interface{}{"success": true}})
+			e.mu.Lock()
+			venue := shared.VenueState{}
+			for _, seat := range e.seats {
+				venue.Seats = append(venue.Seats, seat)
+			}
+			e.mu.Unlock()
+			send(shared.ServerMessage{Type: shared.MessageTypeVenueState, Data: venue})
+			continue
+		}
+
+		from, to := map[string]shared.SeatStatus{
+			shared.MessageTypeSelectSeat:  shared.SeatAvailable,
+			shared.MessageTypeBookSeat:    shared.SeatHeld,
+			shared.MessageTypeReleaseSeat: shared.SeatHeld,
+		}[msg.Type], map[string]shared.SeatStatus{
+			shared.MessageTypeSelectSeat:  shared.SeatHeld,
+			shared.MessageTypeBookSeat:    shared.SeatBooked,
+			shared.MessageTypeReleaseSeat: shared.SeatAvailable,
+		}[msg.Type]
+		e.mu.Lock()
+		seat := e.seats[req.SeatID]
+		ok := seat.Status == from && (from == shared.SeatAvailable || seat.HeldBy == req.UserID)
+		if ok {
+			seat.Status, seat.HeldBy = to, req.UserID
+			e.seats[req.SeatID] = seat
+		}
+		e.mu.Unlock()
+		send(shared.ServerMessage{Type: msg.Type + "_RESPONSE", RequestID: msg.RequestID, Data: map[string]interface{}{"success": ok}})
+		if ok {
+			e.broadcast(shared.ServerMessage{Type: shared.MessageTypeSeatUpdate, Data: map[string]interface{}{
+				"seat_id": req.SeatID, "status": to,
+			}})
+		}
+	}
+}
+
+func (e *fakeEdge) broadcast(m shared.ServerMessage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ws, writeMu := range e.conns {
+		writeMu.Lock()
+		ws.WriteJSON(m)
+		writeMu.Unlock()
+	}
+}
+
+func TestRunTimesEveryOperationOfCompetingBuyers(t *testing.T) {
+	url := newFakeEdge(t, "A1", "A2", "A3")
+	report := Run(context.Background(), Config{
+		URL:        url,
+		Clients:    6,
+		Rate:       200,
+		Duration:   time.Second,
+		Think:      5 * time.Millisecond,
+		BookRatio:  0.2,
+		UserPrefix: "lg",
+		Timeout:    time.Second,
+		Seed:       7,
+	})
+
+	if report.Clients != 6 || report.Subscribed != 6 {
+		t.Fatalf("%d buyers arrived and %d subscribed, want 6", report.Clients, report.Subscribed)
+	}
+	if len(report.Errors) > 0 {
+		t.Errorf("errors: %+v", report.Errors)
+	}
+	ops := map[string]OpSummary{}
+	for _, s := range report.Operations {
+		ops[s.Op] = s
+		if s.P50 > s.P90 || s.P90 > s.P99 || s.P99 > s.Max {
+			t.Errorf("%s percentiles out of order: %+v", s.Op, s)
+		}
+	}
+	if ops[opConnect].OK != 6 || ops[opSubscribe].OK != 6 {
+		t.Errorf("connect %+v, subscribe %+v; want 6 each", ops[opConnect], ops[opSubscribe])
+	}
+	if ops[opSelect].OK == 0 || ops[opBook].OK+ops[opRelease].OK == 0 {
+		t.Errorf("select %+v, book %+v, release %+v; want holds that were booked or released", ops[opSelect], ops[opBook],
+			ops[opRelease])
+	}
+	if report.SeatUpdates == 0 {
+		t.Error("no seat updates were received")
+	}
+
+	var out bytes.Buffer
+	printReport(&out, report, false)
+	if !strings.Contains(out.String(), "6 buyers in") || !strings.Contains(out.String(), "select") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
+
+func TestCapacityReportOfARun(t *testing.T) {
+	url := newFakeEdge(t, "A1", "A2", "A3", "A4", "A5", "A6")
+	mr := miniredis.RunT(t)
+	report := Run(context.Background(), Config{
+		URL:        url,
+		Clients:    4,
+		Duration:   2500 * time.Millisecond,
+		Think:      5 * time.Millisecond,
+		BookRatio:  0,
+		UserPrefix: "lg",
+		Timeout:    time.Second,
+		Seed:       3,
+		Capacity:   &capacity.Config{Edges: 1, BookingInstances: 1, SLO: time.Second, RedisAddr: mr.Addr(), RedisMaxOps: 1000},
+	})
+
+	c := report.Capacity
+	if c == nil || len(c.Windows) != 2 {
+		t.Fatalf("capacity = %+v, want two windows", c)
+	}
+	for _, w := range c.Windows {
+		if w.Active != 4 || w.SeatOps == 0 {
+			t.Errorf("window %+v, want 4 buyers and seat commands", w)
+		}
+	}
+	if c.MaxConnectionsPerEdge != 4 || c.BookingOpsPerSecPerInstance == 0 || !c.Unsaturated || c.RedisHeadroom != 0.999 {
+		t.Errorf("capacity %+v", c)
+	}
+
+	var out bytes.Buffer
+	printReport(&out, report, false)
+	if !strings.Contains(out.String(), "| Connections per edge | at least 4 |") || !strings.Contains(out.String(), "| select |") {
+		t.Errorf("Markdown report:\n%s", out.String())
+	}
+	out.Reset()
+	printReport(&out, report, true)
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded.Capacity.MaxConnectionsPerEdge != 4 {
+		t.Errorf("JSON report: %v\n%s", err, out.String())
+	}
+}
+
+func TestPercentileIsTheNearestRank(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 20; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{50: 10 * time.Millisecond, 90: 18 * time.Millisecond,
+		99: 20 * time.Millisecond, 100: 20 * time.Millisecond} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("p%v = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("p50 of nothing = %v", got)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operations loadgen times, in the order the report lists them
+const (
+	opConnect   = "connect"
+	opSubscribe = "subscribe"
+	opSelect    = "select"
+	opBook      = "book"
+	opRelease   = "release"
+)
+
+var operations = []string{opConnect, opSubscribe, opSelect, opBook, opRelease}
+
+// Outcomes of an operation: the edge answered success, it answered failure
+// (the seat was taken, which is expected when buyers compete), or there was
+// no proper answer at all
+const (
+	outcomeOK = iota
+	outcomeRefused
+	outcomeError
+)
+
+// recorder collects the latency and outcome of every operation the simulated
+// clients perform
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	counts    map[string]*[3]int
+	errors    map[string]int // error text by count, to show what went wrong
+	updates   int64          // seat updates the clients received
+
+	// Seat commands since the sampler last cut a window
+	windowLatencies []time.Duration
+	windowErrors    int
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		latencies: make(map[string][]time.Duration),
+		counts:    make(map[string]*[3]int),
+		errors:    make(map[string]int),
+	}
+}
+
+// record notes one operation. Latencies are kept for answered operations only.
+func (r *recorder) record(op string, outcome int, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := r.counts[op]
+	if counts == nil {
+		counts = new([3]int)
+		r.counts[op] = counts
+	}
+	counts[outcome]++
+	if outcome != outcomeError {
+		r.latencies[op] = append(r.latencies[op], latency)
+	} else if err != nil {
+		r.errors[op+": "+err.Error()]++
+	}
+
+	if op == opSelect || op == opBook || op == opRelease {
+		if outcome == outcomeError {
+			r.windowErrors++
+		} else {
+			r.windowLatencies = append(r.windowLatencies, latency)
+		}
+	}
+}
+
+// cutWindow returns the seat commands answered and failed since the last cut,
+// and the p99 latency of those answered
+func (r *recorder) cutWindow() (answered, errors int, p99 time.Duration) {
+	r.mu.Lock()
+	latencies := r.windowLatencies
+	errors = r.windowErrors
+	r.windowLatencies, r.windowErrors = nil, 0
+	r.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return len(latencies), errors, percentile(latencies, 99)
+}
+
+// subscribed is how many clients got their seat map
+func (r *recorder) subscribed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if counts := r.counts[opSubscribe]; counts != nil {
+		return counts[outcomeOK]
+	}
+	return 0
+}
+
+// seatUpdates notes n seat updates received by one client
+func (r *recorder) seatUpdates(n int) {
+	r.mu.Lock()
+	r.updates += int64(n)
+	r.mu.Unlock()
+}
+
+// seatUpdateCount is how many seat updates the clients received in all
+func (r *recorder) seatUpdateCount() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.updates
+}
+
+// OpSummary is what the report says about one operation. Latencies are in
+// milliseconds.
+type OpSummary struct {
+	Op      string  `json:"op"`
+	Count   int     `json:"count"`
+	OK      int     `json:"ok"`
+	Refused int     `json:"refused"`
+	Errors  int     `json:"errors"`
+	PerSec  float64 `json:"per_sec"`
+	P50     float64 `json:"p50_ms"`
+	P90     float64 `json:"p90_ms"`
+	P99     float64 `json:"p99_ms"`
+	Max     float64 `json:"max_ms"`
+}
+
+// summarize sums up every operation recorded over elapsed
+func (r *recorder) summarize(elapsed time.Duration) []OpSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summaries := make([]OpSummary, 0, len(operations))
+	for _, op := range operations {
+		counts := r.counts[op]
+		if counts == nil {
+			continue
+		}
+		s := OpSummary{Op: op, OK: counts[outcomeOK], Refused: counts[outcomeRefused], Errors: counts[outcomeError]}
+		s.Count = s.OK + s.Refused + s.Errors
+		if elapsed > 0 {
+			s.PerSec = float64(s.Count) / elapsed.Seconds()
+		}
+		latencies := r.latencies[op]
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		s.P50 = milliseconds(percentile(latencies, 50))
+		s.P90 = milliseconds(percentile(latencies, 90))
+		s.P99 = milliseconds(percentile(latencies, 99))
+		s.Max = milliseconds(percentile(latencies, 100))
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// percentile returns the p-th percentile of sorted by the nearest-rank
+// method, 0 if there are none
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,571 @@
+// Command protocol-test checks that an edge server speaks the WebSocket
+// protocol described in MESSAGE_FORMAT.md, for validating new edge versions
+// and, by reading the checks, third-party client implementations.
+//
+// Usage:
+//
+//	protocol-test -url ws://localhost:8081/ws [-user NAME] [-token TOKEN] [-mutate] [-json]
+//
+// By default only checks that change nothing are run. -mutate also holds and
+// releases one available seat to check that a retried SELECT_SEAT is answered
+// with its original result after reconnecting. The exit status is 1 if any
+// check fails.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config is what the suite connects with
+type Config struct {
+	URL     string
+	UserID  string
+	Token   string
+	Mutate  bool
+	Timeout time.Duration // how long to wait for each expected message
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // pass, fail or skip
+	Detail     string `json:"detail,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Check outcomes
+const (
+	statusPass = "pass"
+	statusFail = "fail"
+	statusSkip = "skip"
+)
+
+// errSkip marks a check that does not apply to this configuration
+type errSkip string
+
+func (e errSkip) Error() string { return string(e) }
+
+// check is one step of the compliance suite
+type check struct {
+	name string
+	run  func(cfg Config) error
+}
+
+// suite lists the checks in the order they run
+var suite = []check{
+	{"handshake", checkHandshake},
+	{"subscribe", checkSubscribe},
+	{"request correlation", checkCorrelation},
+	{"unknown message type", checkUnknownType},
+	{"malformed message", checkMalformed},
+	{"permission denied", checkPermission},
+	{"retry after reconnect", checkResume},
+	{"close handshake", checkClose},
+}
+
+func main() {
+	cfg := Config{}
+	flag.StringVar(&cfg.URL, "url", "ws://localhost:8081/ws", "edge server WebSocket URL")
+	flag.StringVar(&cfg.UserID, "user", "protocol-test", "user to subscribe as; must match -token's user")
+	flag.StringVar(&cfg.Token, "token", "", "token for edges running with EDGE_AUTH_SECRET")
+	flag.BoolVar(&cfg.Mutate, "mutate", false, "also run checks that hold and release a seat")
+	flag.DurationVar(&cfg.Timeout, "timeout", 5*time.Second, "how long to wait for each expected message")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	results := Run(cfg)
+	if err := report(os.Stdout, cfg, results, *asJSON); err != nil {
+		fmt.Fprintln(os.Stderr, "protocol-test:", err)
+		os.Exit(2)
+	}
+	if failed(results) {
+		os.Exit(1)
+	}
+}
+
+// Run runs every check of the suite against cfg.URL
+func Run(cfg Config) []Result {
+	results := make([]Result, 0, len(suite))
+	for _, c := range suite {
+		start := time.Now()
+		err := c.run(cfg)
+		result := Result{Name: c.name, Status: statusPass, DurationMs: time.Since(start).Milliseconds()}
+		var skip errSkip
+		switch {
+		case errors.As(err, &skip):
+			result.Status, result.Detail = statusSkip, skip.Error()
+		case err != nil:
+			result.Status, result.Detail = statusFail, err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == statusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// report prints results as a table, or as JSON
+func report(w io.Writer, cfg Config, results []Result, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{"url": cfg.URL, "passed": !failed(results), "checks": results})
+	}
+
+	fmt.Fprintf(w, "edge: %s\n", cfg.URL)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%dms\t%s\n", strings.ToUpper(r.Status), r.Name, r.DurationMs, r.Detail)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d passed, %d failed, %d skipped\n", counts[statusPass], counts[statusFail], counts[statusSkip])
+	return nil
+}
+
+// conn is a test connection that keeps what it has read, so checks can wait
+// for one message without losing the broadcasts that arrive around it
+type conn struct {
+	ws      *websocket.Conn
+	timeout time.Duration
+	seen    []shared.ServerMessage
+	welcome shared.ServerMessage
+}
+
+// dial connects, with extra query parameters, and waits for WELCOME. An edge
+// at its per-event cap may first send WAITING_ROOM; dial keeps waiting.
+func dial(cfg Config, query url.Values) (*conn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	if cfg.Token != "" {
+		q.Set("token", cfg.Token)
+	}
+	u.RawQuery = q.Encode()
+
+	dialer := websocket.Dialer{HandshakeTimeout: cfg.Timeout}
+	ws, _, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	c := &conn{ws: ws, timeout: cfg.Timeout}
+	welcome, err := c.await("WELCOME", func(m shared.ServerMessage) bool { return m.Type == "WELCOME" })
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	c.welcome = welcome
+	return c, nil
+}
+
+func (c *conn) close() {
+	c.ws.Close()
+}
+
+// send writes a client message
+func (c *conn) send(msgType, requestID string, data map[string]interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteJSON(shared.ClientMessage{Type: msgType, Data: raw, RequestID: requestID})
+}
+
+// await returns the first message, already read or still to come, that match
+// accepts; what describes it in the error if none arrives in time
+func (c *conn) await(what string, match func(shared.ServerMessage) bool) (shared.ServerMessage, error) {
+	for i, m := range c.seen {
+		if match(m) {
+			c.seen = append(c.seen[:i], c.seen[i+1:]...)
+			return m, nil
+		}
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	c.ws.SetReadDeadline(deadline)
+	defer c.ws.SetReadDeadline(time.Time{})
+	for {
+		_, frame, err := c.ws.ReadMessage()
+		if err != nil {
+			if time.Now().After(deadline) {
+				return shared.ServerMessage{}, fmt.Errorf("no %s within %s", what, c.timeout)
+			}
+			return shared.ServerMessage{}, fmt.Errorf("waiting for %s: %w", what, err)
+		}
+
+		// A frame may carry several messages, newline separated
+		var found *shared.ServerMessage
+		for _, data := range bytes.Split(frame, []byte{'\n'}) {
+			var m shared.ServerMessage
+			if err := json.Unmarshal(data, &m); err != nil {
+				return shared.ServerMessage{}, fmt.Errorf("server sent a message that is not JSON: %q", truncate(data))
+			}
+			if m.Type == "" {
+				return shared.ServerMessage{}, fmt.Errorf("server sent a message without a type: %q", truncate(data))
+			}
+			if found == nil && match(m) {
+				found = &m
+				continue
+			}
+			c.seen = append(c.seen, m)
+		}
+		if found != nil {
+			return *found, nil
+		}
+	}
+}
+
+// response waits for the message of msgType answering requestID
+func (c *conn) response(msgType, requestID string) (shared.ServerMessage, error) {
+	return c.await(msgType+" for "+requestID, func(m shared.ServerMessage) bool {
+		return m.RequestID == requestID && (m.Type == msgType || m.Type == shared.MessageTypeError)
+	})
+}
+
+// subscribe sends SUBSCRIBE and returns the ack
+func (c *conn) subscribe(userID, requestID string) (*operation, error) {
+	if err := c.send(shared.MessageTypeSubscribe, requestID, map[string]interface{}{"user_id": userID}); err != nil {
+		return nil, err
+	}
+	m, err := c.response("SUBSCRIBE_ACK", requestID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Type != "SUBSCRIBE_ACK" {
+		return nil, fmt.Errorf("SUBSCRIBE answered with %s: %v", m.Type, m.Data)
+	}
+	return decodeOperation(m)
+}
+
+// operation is the data of SUBSCRIBE_ACK and *_RESPONSE messages
+type operation struct {
+	Success bool                   `json:"success"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+func decodeOperation(m shared.ServerMessage) (*operation, error) {
+	var op operation
+	if err := remarshal(m.Data, &op); err != nil {
+		return nil, fmt.Errorf("%s data: %w", m.Type, err)
+	}
+	return &op, nil
+}
+
+// remarshal converts decoded JSON into a typed value
+func remarshal(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+func truncate(data []byte) string {
+	if len(data) > 80 {
+		return string(data[:80]) + "..."
+	}
+	return string(data)
+}
+
+// checkHandshake: the edge greets every connection with its client ID and time
+func checkHandshake(cfg Config) error {
+	c, err := dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	data, _ := c.welcome.Data.(map[string]interface{})
+	if id, _ := data["client_id"].(string); id == "" {
+		return fmt.Errorf("WELCOME has no client_id: %v", c.welcome.Data)
+	}
+	if _, ok := data["server_time"].(float64); !ok {
+		return fmt.Errorf("WELCOME has no numeric server_time: %v", c.welcome.Data)
+	}
+	if c.welcome.RequestID != "" {
+		return fmt.Errorf("unsolicited WELCOME carries request_id %q", c.welcome.RequestID)
+	}
+	return nil
+}
+
+// checkSubscribe: SUBSCRIBE is acknowledged for the WELCOME's client and
+// followed by the venue
+func checkSubscribe(cfg Config) error {
+	c, err := dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	ack, err := c.subscribe(cfg.UserID, "pt-subscribe")
+	if err != nil {
+		return err
+	}
+	if !ack.Success {
+		return fmt.Errorf("SUBSCRIBE failed: %s", ack.Message)
+	}
+	welcome, _ := c.welcome.Data.(map[string]interface{})
+	if ack.Data["client_id"] != welcome["client_id"] {
+		return fmt.Errorf("SUBSCRIBE_ACK client_id %v, WELCOME said %v", ack.Data["client_id"], welcome["client_id"])
+	}
+	if ack.Data["permission"] == nil {
+		return errors.New("SUBSCRIBE_ACK does not report the connection's permission")
+	}
+
+	state, err := c.await(shared.MessageTypeVenueState, func(m shared.ServerMessage) bool { return m.Type == shared.MessageTypeVenueState })
+	if err != nil {
+		return err
+	}
+	var venue shared.VenueState
+	if err := remarshal(state.Data, &venue); err != nil {
+		return fmt.Errorf("VENUE_STATE data: %w", err)
+	}
+	if venue.Seats == nil {
+		return errors.New("VENUE_STATE has no seats array")
+	}
+	return nil
+}
+
+// checkCorrelation: responses to requests in flight together carry their
+// request_id. Releasing a seat that does not exist changes nothing.
+func checkCorrelation(cfg Config) error {
+	c, err := dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if _, err := c.subscribe(cfg.UserID, "pt-subscribe"); err != nil {
+		return err
+	}
+
+	ids := []string{"pt-release-1", "pt-release-2", "pt-release-3"}
+	for _, id := range ids {
+		if err := c.send(shared.MessageTypeReleaseSeat, id, map[string]interface{}{"seat_id": "PT-NO-SUCH-SEAT"}); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		m, err := c.response("RELEASE_SEAT_RESPONSE", id)
+		if err != nil {
+			return err
+		}
+		op, err := decodeOperation(m)
+		if err != nil {
+			return err
+		}
+		if m.Type != shared.MessageTypeError && op.Success {
+			return fmt.Errorf("releasing a seat that does not exist succeeded (%s)", id)
+		}
+	}
+	return nil
+}
+
+// checkUnknownType: an unknown message type is answered with ERROR
+func checkUnknownType(cfg Config) error {
+	c, err := dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.send("PT_NO_SUCH_TYPE", "pt-unknown", nil); err != nil {
+		return err
+	}
+	m, err := c.response(shared.MessageTypeError, "pt-unknown")
+	if err != nil {
+		return err
+	}
+	return expectError(m, "Unknown message type")
+}
+
+// checkMalformed: a frame that is not a client message is answered with ERROR
+// and the connection stays usable
+func checkMalformed(cfg Config) error {
+	c, err := dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.ws.WriteMessage(websocket.TextMessage, []byte("{not json")); err != nil {
+		return err
+	}
+	m, err := c.await(shared.MessageTypeError, func(m shared.ServerMessage) bool { return m.Type == shared.MessageTypeError })
+	if err != nil {
+		return err
+	}
+	if err := expectError(m, "Invalid message format"); err != nil {
+		return err
+	}
+	if _, err := c.subscribe(cfg.UserID, "pt-after-malformed"); err != nil {
+		return fmt.Errorf("connection unusable after a malformed message: %w", err)
+	}
+	return nil
+}
+
+// checkPermission: a read-only connection may not book
+func checkPermission(cfg Config) error {
+	if cfg.Token != "" {
+		return errSkip("the token fixes the role; run without -token to check viewer connections")
+	}
+	c, err := dial(cfg, url.Values{"role": {"viewer"}})
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := c.send(shared.MessageTypeBookSeat, "pt-denied", map[string]interface{}{"seat_id": "PT-NO-SUCH-SEAT"}); err != nil {
+		return err
+	}
+	m, err := c.response(shared.MessageTypeError, "pt-denied")
+	if err != nil {
+		return err
+	}
+	return expectError(m, "Permission denied")
+}
+
+// checkResume: a SELECT_SEAT resent with the same data.request_id after
+// reconnecting returns the original result instead of "already held"
+func checkResume(cfg Config) error {
+	if !cfg.Mutate {
+		return errSkip("holds a seat; run with -mutate")
+	}
+
+	c, err := dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := c.subscribe(cfg.UserID, "pt-subscribe"); err != nil {
+		c.close()
+		return err
+	}
+	state, err := c.await(shared.MessageTypeVenueState, func(m shared.ServerMessage) bool { return m.Type == shared.MessageTypeVenueState })
+	if err != nil {
+		c.close()
+		return err
+	}
+	var venue shared.VenueState
+	remarshal(state.Data, &venue)
+	seatID := ""
+	for _, seat := range venue.Seats {
+		if seat.Status == shared.SeatAvailable {
+			seatID = seat.ID
+			break
+		}
+	}
+	if seatID == "" {
+		c.close()
+		return errSkip("no available seat to hold")
+	}
+
+	retryID := fmt.Sprintf("pt-resume-%d", time.Now().UnixNano())
+	selectSeat := map[string]interface{}{"seat_id": seatID, "user_id": cfg.UserID, "request_id": retryID}
+	first, err := selectOnce(c, "pt-select-1", selectSeat)
+	c.close()
+	if err != nil {
+		return err
+	}
+	if !first.Success {
+		return errSkip("could not hold " + seatID + ": " + first.Message)
+	}
+
+	c, err = dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if _, err := c.subscribe(cfg.UserID, "pt-resubscribe"); err != nil {
+		return err
+	}
+	retried, err := selectOnce(c, "pt-select-2", selectSeat)
+
+	// Give the seat back whatever happened
+	c.send(shared.MessageTypeReleaseSeat, "pt-cleanup", map[string]interface{}{"seat_id": seatID, "user_id": cfg.UserID})
+	c.response("RELEASE_SEAT_RESPONSE", "pt-cleanup")
+
+	if err != nil {
+		return err
+	}
+	if !retried.Success {
+		return fmt.Errorf("retried SELECT_SEAT of %s failed: %s", seatID, retried.Message)
+	}
+	return nil
+}
+
+func selectOnce(c *conn, requestID string, data map[string]interface{}) (*operation, error) {
+	if err := c.send(shared.MessageTypeSelectSeat, requestID, data); err != nil {
+		return nil, err
+	}
+	m, err := c.response("SELECT_SEAT_RESPONSE", requestID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Type == shared.MessageTypeError {
+		return nil, fmt.Errorf("SELECT_SEAT answered with ERROR: %v", m.Data)
+	}
+	return decodeOperation(m)
+}
+
+// checkClose: the edge answers a normal close with a normal close
+func checkClose(cfg Config) error {
+	c, err := dial(cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	frame := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "protocol-test done")
+	if err := c.ws.WriteMessage(websocket.CloseMessage, frame); err != nil {
+		return err
+	}
+	_, err = c.await("close frame", func(shared.ServerMessage) bool { return false })
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return fmt.Errorf("expected a close frame, got %v", err)
+	}
+	if closeErr.Code != websocket.CloseNormalClosure {
+		return fmt.Errorf("close code %d, want %d", closeErr.Code, websocket.CloseNormalClosure)
+	}
+	return nil
+}
+
+// expectError checks m is an ERROR whose text starts with prefix
+func expectError(m shared.ServerMessage, prefix string) error {
+	if m.Type != shared.MessageTypeError {
+		return fmt.Errorf("got %s, want ERROR", m.Type)
+	}
+	data, _ := m.Data.(map[string]interface{})
+	text, _ := data["error"].(string)
+	if !strings.HasPrefix(text, prefix) {
+		return fmt.Errorf("ERROR %q, want one starting %q", text, prefix)
+	}
+	return nil
+}
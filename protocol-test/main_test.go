@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeEdge greets each connection with one frame, then reads (and so answers
+// close frames) without ever replying
+func fakeEdge(t *testing.T, greeting string) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.WriteMessage(websocket.TextMessage, []byte(greeting))
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestAwaitReadsEveryMessageOfABatchedFrame(t *testing.T) {
+	url := fakeEdge(t, `{"type":"WELCOME","data":{"client_id":"c1","server_time":1}}`+"\n"+
+		`{"type":"VENUE_STATE","data":{"seats":[]}}`)
+
+	c, err := dial(Config{URL: url, Timeout: time.Second}, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.close()
+
+	m, err := c.await("VENUE_STATE", func(m shared.ServerMessage) bool { return m.Type == "VENUE_STATE" })
+	if err != nil || m.Type != "VENUE_STATE" {
+		t.Fatalf("VENUE_STATE sent with WELCOME was lost: %v", err)
+	}
+}
+
+func TestRunReportsEachCheck(t *testing.T) {
+	url := fakeEdge(t, `{"type":"WELCOME","data":{"client_id":"c1","server_time":1}}`)
+	cfg := Config{URL: url, UserID: "tester", Timeout: 50 * time.Millisecond}
+
+	results := Run(cfg)
+	status := map[string]string{}
+	for _, r := range results {
+		status[r.Name] = r.Status
+	}
+	want := map[string]string{
+		"handshake":             statusPass,
+		"subscribe":             statusFail, // never acknowledged
+		"retry after reconnect": statusSkip, // needs -mutate
+		"close handshake":       statusPass,
+	}
+	for name, s := range want {
+		if status[name] != s {
+			t.Errorf("%s = %q, want %q", name, status[name], s)
+		}
+	}
+	if !failed(results) {
+		t.Error("a run with failing checks is reported as passed")
+	}
+
+	var out bytes.Buffer
+	if err := report(&out, cfg, results, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "2 passed, 5 failed, 1 skipped") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Where the admin commands find the booking service unless -url says otherwise
+const defaultBookingURL = "http://localhost:8080"
+
+// changeReport is what the booking service's admin operations answer with:
+// the seats they changed, or would change with dry_run
+type changeReport struct {
+	DryRun          bool `json:"dry_run"`
+	SeatsAffected   int  `json:"seats_affected"`
+	HoldsBroken     int  `json:"holds_broken"`
+	BookingsCleared int  `json:"bookings_cleared"`
+	Changes         []struct {
+		SeatID     string            `json:"seat_id"`
+		FromStatus shared.SeatStatus `json:"from_status"`
+		ToStatus   shared.SeatStatus `json:"to_status"`
+		HeldBy     string            `json:"held_by"`
+	} `json:"changes"`
+}
+
+// edgeStats is the part of an edge's /stats the stats table shows
+type edgeStats struct {
+	TotalClients      int       `json:"total_clients"`
+	TotalMessages     int64     `json:"total_messages"`
+	DroppedMessages   int64     `json:"dropped_messages"`
+	FilteredMessages  int64     `json:"filtered_messages"`
+	CoalescedUpdates  int64     `json:"coalesced_updates"`
+	LastBroadcastTime time.Time `json:"last_broadcast_time"`
+}
+
+// runSeats lists the seats of a booking service, filtered as GET /seats filters them
+func runSeats(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("seats", flag.ContinueOnError)
+	base := fs.String("url", defaultBookingURL, "booking service base URL")
+	status := fs.String("status", "", "only seats in this status: available, held or booked")
+	section := fs.String("section", "", "only seats in this section")
+	tier := fs.String("tier", "", "only seats of this tier")
+	row := fs.String("row", "", "only seats in this row")
+	asJSON := fs.Bool("json", false, "print the seats as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	for name, value := range map[string]string{"status": *status, "section": *section, "tier": *tier, "row": *row} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	path := "/seats"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var seats []shared.Seat
+	if err := call(http.MethodGet, *base, path, nil, &seats); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(seats)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEAT\tSECTION\tROW\tCOL\tSTATUS\tHELD BY\tEXPIRES")
+	for _, seat := range seats {
+		expires := ""
+		if seat.ExpiresAt > 0 {
+			expires = time.Unix(seat.ExpiresAt, 0).Format(time.TimeOnly)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", seat.ID, seat.Section, seat.Row, seat.Col, seat.Status, seat.HeldBy, expires)
+	}
+	tw.Flush()
+	fmt.Fprintf(stdout, "%d seats\n", len(seats))
+	return nil
+}
+
+// runSetSeats sets the seats named on the command line to status through the
+// bulk admin API: release makes them available, breaking holds and bookings,
+// and block books them so they are off sale
+func runSetSeats(name string, status shared.SeatStatus, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	base := fs.String("url", defaultBookingURL, "booking service base URL")
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%s: name the seats to %s", name, name)
+	}
+
+	body := map[string]interface{}{"seat_ids": fs.Args(), "status": status}
+	var report changeReport
+	if err := call(http.MethodPost, *base, "/admin/seats/bulk"+dryRunQuery(*dryRun), body, &report); err != nil {
+		return err
+	}
+	printReport(stdout, report)
+	return nil
+}
+
+// runReset returns every held and booked seat to available. It asks for -yes,
+// as it breaks every hold and booking there is.
+func runReset(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	base := fs.String("url", defaultBookingURL, "booking service base URL")
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	yes := fs.Bool("yes", false, "reset the venue; without it only -dry-run is allowed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*yes && !*dryRun {
+		return errors.New("reset: this releases every held and booked seat; pass -yes to go ahead, or -dry-run to see what it would change")
+	}
+
+	var report changeReport
+	if err := call(http.MethodPost, *base, "/admin/venue/reset"+dryRunQuery(*dryRun), nil, &report); err != nil {
+		return err
+	}
+	printReport(stdout, report)
+	return nil
+}
+
+// runStats prints the /stats of each edge given with -edge
+func runStats(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	var edges []string
+	fs.Func("edge", "edge server base URL, e.g. http://localhost:3001; repeat for several", func(edge string) error {
+		edges = append(edges, strings.TrimSuffix(edge, "/"))
+		return nil
+	})
+	asJSON := fs.Bool("json", false, "print each edge's /stats in full, by edge")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(edges) == 0 {
+		return errors.New("stats: -edge is required")
+	}
+
+	raw := make(map[string]json.RawMessage, len(edges))
+	for _, edge := range edges {
+		resp, err := httpClient.Get(edge + "/stats")
+		if err != nil {
+			return fmt.Errorf("stats: %w", err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("stats: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("stats from %s: status %d", edge, resp.StatusCode)
+		}
+		raw[edge] = data
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(raw)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "EDGE\tCLIENTS\tMESSAGES\tDROPPED\tFILTERED\tCOALESCED\tLAST BROADCAST")
+	for _, edge := range edges {
+		var stats edgeStats
+		if err := json.Unmarshal(raw[edge], &stats); err != nil {
+			return fmt.Errorf("stats from %s: %w", edge, err)
+		}
+		last := "never"
+		if !stats.LastBroadcastTime.IsZero() {
+			last = stats.LastBroadcastTime.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", edge, stats.TotalClients, stats.TotalMessages, stats.DroppedMessages,
+			stats.FilteredMessages, stats.CoalescedUpdates, last)
+	}
+	return tw.Flush()
+}
+
+// printReport lists the seats an admin operation changed and sums them up
+func printReport(stdout io.Writer, report changeReport) {
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, change := range report.Changes {
+		fmt.Fprintf(tw, "%s\t%s -> %s\t%s\n", change.SeatID, change.FromStatus, change.ToStatus, change.HeldBy)
+	}
+	tw.Flush()
+
+	verb := "changed"
+	if report.DryRun {
+		verb = "would change (dry run)"
+	}
+	fmt.Fprintf(stdout, "%d seats %s: %d holds broken, %d bookings cleared\n", report.SeatsAffected, verb, report.HoldsBroken,
+		report.BookingsCleared)
+}
+
+func dryRunQuery(dryRun bool) string {
+	if dryRun {
+		return "?dry_run=true"
+	}
+	return ""
+}
+
+// call sends a request to a booking service API path and decodes the JSON
+// reply into out. Error replies come back as errors carrying their message
+// and code.
+func call(method, baseURL, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(baseURL, "/")+shared.APIPrefix+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	authorize(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure shared.ErrorResponse
+		if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("%s %s: %s (%s)", method, path, failure.Error, failure.Code)
+		}
+		return fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"concert-booking/shared"
+)
+
+func TestReleaseAndResetThroughTheAdminAPI(t *testing.T) {
+	t.Setenv("AUTH_SECRET", "test-secret")
+	var requests []string
+	var bulk map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if claims, err := shared.ParseSessionToken([]byte("test-secret"), token, time.Now()); err != nil || claims.Role != shared.RoleAdmin {
+			t.Errorf("%s %s without an admin token: %v", r.Method, r.URL.Path, err)
+		}
+		switch r.URL.Path {
+		case "/api/v1/admin/seats/bulk":
+			json.NewDecoder(r.Body).Decode(&bulk)
+			w.Write([]byte(`{"dry_run":true,"seats_affected":1,"holds_broken":1,
+				"changes":[{"seat_id":"A1","from_status":"held","to_status":"available","held_by":"user-1"}]}`))
+		case "/api/v1/admin/venue/reset":
+			w.Write([]byte(`{"seats_affected":3,"holds_broken":1,"bookings_cleared":2}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Seat not found","code":"SEAT_NOT_FOUND"}`))
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := runSetSeats("release", shared.SeatAvailable, []string{"-url", server.URL, "-dry-run", "A1", "A2"}, &out); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if bulk["status"] != "available" || len(bulk["seat_ids"].([]interface{})) != 2 {
+		t.Errorf("bulk request = %v, want A1 and A2 made available", bulk)
+	}
+	if !strings.Contains(out.String(), "A1  held -> available  user-1") || !strings.Contains(out.String(), "1 seats would change (dry run)") {
+		t.Errorf("release output:\n%s", out.String())
+	}
+
+	if err := runReset([]string{"-url", server.URL}, &out); err == nil {
+		t.Fatal("reset without -yes went ahead")
+	}
+	out.Reset()
+	if err := runReset([]string{"-url", server.URL, "-yes"}, &out); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if want := "3 seats changed: 1 holds broken, 2 bookings cleared\n"; out.String() != want {
+		t.Errorf("reset output = %q, want %q", out.String(), want)
+	}
+	want := []string{"POST /api/v1/admin/seats/bulk?dry_run=true", "POST /api/v1/admin/venue/reset"}
+	if strings.Join(requests, ", ") != strings.Join(want, ", ") {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+
+	err := runSeats([]string{"-url", server.URL + "/nowhere"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "Seat not found (SEAT_NOT_FOUND)") {
+		t.Errorf("error reply = %v, want its message and code", err)
+	}
+}
+
+func TestSeatsAndStatsTables(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/seats":
+			if r.URL.Query().Get("status") != "held" {
+				t.Errorf("GET /seats query = %q, want status=held", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode([]shared.Seat{{ID: "F1", Section: "floor", Row: 1, Col: 1, Status: shared.SeatHeld, HeldBy: "user-1"}})
+		case "/stats":
+			w.Write([]byte(`{"total_clients":12,"total_messages":340,"dropped_messages":2,"rooms":{"main":12}}`))
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := runSeats([]string{"-url", server.URL, "-status", "held"}, &out); err != nil {
+		t.Fatalf("seats: %v", err)
+	}
+	if !strings.Contains(out.String(), "F1    floor    1    1    held    user-1") || !strings.HasSuffix(out.String(), "1 seats\n") {
+		t.Errorf("seats output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runStats([]string{"-edge", server.URL, "-edge", server.URL + "/"}, &out); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 || !strings.Contains(lines[1], "12       340       2") ||
+		!strings.HasSuffix(lines[1], "never") {
+		t.Errorf("stats output:\n%s", out.String())
+	}
+	if err := runStats(nil, &out); err == nil {
+		t.Error("stats without -edge succeeded")
+	}
+}
+
+func TestTailFiltersBatchedReleasesBySection(t *testing.T) {
+	mr := miniredis.RunT(t)
+	bus := shared.NewRedisEventBus(redis.NewClient(&redis.Options{Addr: mr.Addr()}), shared.RedisKeyEventStream, "test")
+	defer bus.Close()
+
+	var out syncBuffer
+	done := make(chan struct{})
+	finished := make(chan error)
+	go func() {
+		finished <- tailEvents(bus, tailFilter{section: "floor"}, &out, false, done)
+	}()
+
+	publish := func(subject string, event shared.SeatEvent) {
+		data, _ := json.Marshal(event)
+		bus.Publish(subject, data)
+		bus.Flush(time.Second)
+	}
+	// Give the subscription time to start before publishing
+	time.Sleep(100 * time.Millisecond)
+	publish(shared.SeatSubject("main", "balcony", "B1", "booked"), shared.SeatEvent{Type: "booked", SeatID: "B1", Status: shared.SeatBooked})
+	publish(shared.SeatBatchSubject("main"), shared.SeatEvent{Type: shared.SeatEventReleasedBatch, Events: []shared.SeatEvent{
+		{Type: "released", SeatID: "B2", Status: shared.SeatAvailable, Seat: &shared.Seat{ID: "B2", Section: "balcony"}},
+		{Type: "released", SeatID: "F2", Status: shared.SeatAvailable, Seat: &shared.Seat{ID: "F2", Section: "floor"}},
+	}})
+	publish(shared.SeatSubject("main", "floor", "F1", "booked"), shared.SeatEvent{Type: "booked", SeatID: "F1", Status: shared.SeatBooked, UserID: "user-1"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Count(out.String(), "\n") < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	if err := <-finished; err != nil {
+		t.Fatalf("tail: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "main  F2  released -> available  section floor") ||
+		!strings.Contains(lines[1], "main  F1  booked -> booked  user user-1") {
+		t.Errorf("tail output:\n%s", out.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to read while tail writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,246 @@
+// Command seatctl inspects, compares and administers seatMoot deployments.
+//
+// Usage:
+//
+//	seatctl snapshot -from http://staging:8080 [-o staging.json]
+//	seatctl diff -from http://staging:8080 -to http://prod:8080 [-state] [-json]
+//	seatctl diff -from venue.json -to http://prod:8080
+//	seatctl seats [-url URL] [-status held] [-section floor] [-json]
+//	seatctl release [-url URL] [-dry-run] A1 A2
+//	seatctl block [-url URL] [-dry-run] A1 A2
+//	seatctl reset [-url URL] -dry-run | -yes
+//	seatctl tail [-nats URL] [-event main] [-section floor] [-seat A1] [-json]
+//	seatctl stats -edge http://localhost:3001 [-edge ...] [-json]
+//
+// A source is either a booking service base URL or a snapshot file written by
+// seatctl snapshot (a plain seat array from GET /api/seats also works). diff
+// exits with status 1 when the venues differ, so it can gate a promotion.
+//
+// The admin commands talk to the booking service at -url, by default
+// http://localhost:8080. release and block go through the bulk seat API, and
+// tail reads whichever event bus EVENT_BUS selects. Requests to the admin API
+// carry an admin session token signed with AUTH_SECRET, the booking service's
+// secret.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"concert-booking/shared"
+)
+
+const usage = `usage:
+  seatctl snapshot -from SOURCE [-o FILE]
+  seatctl diff -from SOURCE -to SOURCE [-state] [-json]
+  seatctl seats [-url URL] [-status STATUS] [-section S] [-tier T] [-row R] [-json]
+  seatctl release [-url URL] [-dry-run] SEAT...
+  seatctl block [-url URL] [-dry-run] SEAT...
+  seatctl reset [-url URL] (-dry-run | -yes)
+  seatctl tail [-nats URL] [-event ID] [-section S] [-seat ID] [-json]
+  seatctl stats -edge URL [-edge URL...] [-json]
+
+SOURCE is a booking service base URL (http://host:8080) or a snapshot file.
+URL defaults to http://localhost:8080. Set AUTH_SECRET to the booking
+service's to call its admin API.`
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	var differ bool
+	switch os.Args[1] {
+	case "snapshot":
+		err = runSnapshot(os.Args[2:], os.Stdout)
+	case "diff":
+		differ, err = runDiff(os.Args[2:], os.Stdout)
+	case "seats":
+		err = runSeats(os.Args[2:], os.Stdout)
+	case "release":
+		err = runSetSeats("release", shared.SeatAvailable, os.Args[2:], os.Stdout)
+	case "block":
+		err = runSetSeats("block", shared.SeatBooked, os.Args[2:], os.Stdout)
+	case "reset":
+		err = runReset(os.Args[2:], os.Stdout)
+	case "tail":
+		err = runTail(os.Args[2:], os.Stdout)
+	case "stats":
+		err = runStats(os.Args[2:], os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Println(usage)
+		return
+	default:
+		err = fmt.Errorf("unknown command %q\n%s", os.Args[1], usage)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "seatctl:", err)
+		os.Exit(2)
+	}
+	if differ {
+		os.Exit(1)
+	}
+}
+
+func runSnapshot(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	from := fs.String("from", "", "booking service base URL or snapshot file")
+	out := fs.String("o", "", "write the snapshot to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return errors.New("snapshot: -from is required")
+	}
+
+	snapshot, err := loadSnapshot(*from)
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snapshot)
+}
+
+// runDiff prints the discrepancies between two sources and reports whether there were any
+func runDiff(args []string, stdout io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	from := fs.String("from", "", "booking service base URL or snapshot file")
+	to := fs.String("to", "", "booking service base URL or snapshot file")
+	state := fs.Bool("state", false, "also compare seat status and holders, not just layout")
+	asJSON := fs.Bool("json", false, "print the diff as JSON")
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+	if *from == "" || *to == "" {
+		return false, errors.New("diff: -from and -to are required")
+	}
+
+	fromSnapshot, err := loadSnapshot(*from)
+	if err != nil {
+		return false, err
+	}
+	toSnapshot, err := loadSnapshot(*to)
+	if err != nil {
+		return false, err
+	}
+
+	diff := shared.DiffVenues(fromSnapshot.Seats, toSnapshot.Seats, *state)
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return !diff.Identical(), enc.Encode(diff)
+	}
+
+	fmt.Fprintf(stdout, "from: %s (%d seats, seq %d)\n", *from, diff.FromSeats, fromSnapshot.Seq)
+	fmt.Fprintf(stdout, "to:   %s (%d seats, seq %d)\n", *to, diff.ToSeats, toSnapshot.Seq)
+	if diff.Identical() {
+		fmt.Fprintln(stdout, "no discrepancies")
+		return false, nil
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, seat := range diff.Seats {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", seat.SeatID, strings.ReplaceAll(seat.Kind, "_", " "), describeFields(seat.Fields))
+	}
+	tw.Flush()
+	fmt.Fprintf(stdout, "%d discrepancies\n", len(diff.Seats))
+	return true, nil
+}
+
+func describeFields(fields []shared.FieldDiff) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = fmt.Sprintf("%s: %v -> %v", f.Field, f.From, f.To)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loadSnapshot reads a venue from a booking service URL or a snapshot file
+func loadSnapshot(source string) (*shared.VenueSnapshot, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return fetchSnapshot(source)
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshot(data)
+}
+
+func fetchSnapshot(baseURL string) (*shared.VenueSnapshot, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(baseURL, "/")+shared.APIPrefix+"/admin/venue/snapshot", nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetch snapshot: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch snapshot from %s: status %d: %s", baseURL, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return decodeSnapshot(data)
+}
+
+// authorize signs req with an admin session token when AUTH_SECRET is set,
+// the booking service's secret; its admin API refuses requests without one
+func authorize(req *http.Request) {
+	secret := os.Getenv("AUTH_SECRET")
+	if secret == "" {
+		return
+	}
+	token := shared.SignSessionToken([]byte(secret), shared.SessionClaims{
+		UserID:    "seatctl",
+		Role:      shared.RoleAdmin,
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// decodeSnapshot accepts a VenueSnapshot or a bare seat array
+func decodeSnapshot(data []byte) (*shared.VenueSnapshot, error) {
+	var snapshot shared.VenueSnapshot
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &snapshot.Seats); err != nil {
+			return nil, fmt.Errorf("decode seats: %w", err)
+		}
+		return &snapshot, nil
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"concert-booking/shared"
+)
+
+func TestDiffBetweenLiveServiceAndFile(t *testing.T) {
+	live := shared.VenueSnapshot{Seq: 7, Seats: []shared.Seat{
+		{ID: "A1", Tier: "vip", PriceCents: 5000},
+		{ID: "A2", Tier: "standard", PriceCents: 3000, Status: shared.SeatBooked},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/admin/venue/snapshot" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(live)
+	}))
+	defer server.Close()
+
+	// A bare seat array, as GET /api/seats returns
+	file := filepath.Join(t.TempDir(), "seats.json")
+	os.WriteFile(file, []byte(`[{"id":"A1","tier":"vip","price_cents":4500},{"id":"A2","tier":"standard","price_cents":3000}]`), 0o644)
+
+	var out bytes.Buffer
+	differ, err := runDiff([]string{"-from", file, "-to", server.URL}, &out)
+	if err != nil {
+		t.Fatalf("runDiff: %v", err)
+	}
+	if !differ || !strings.Contains(out.String(), "price_cents: 4500 -> 5000") || !strings.Contains(out.String(), "1 discrepancies") {
+		t.Fatalf("layout diff output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if _, err := runDiff([]string{"-from", file, "-to", server.URL, "-state", "-json"}, &out); err != nil {
+		t.Fatalf("runDiff: %v", err)
+	}
+	var diff shared.VenueDiff
+	if err := json.Unmarshal(out.Bytes(), &diff); err != nil {
+		t.Fatalf("decode JSON diff: %v", err)
+	}
+	if len(diff.Seats) != 2 || diff.Seats[1].Fields[0].Field != "status" {
+		t.Fatalf("state diff = %+v, want A1 price and A2 status", diff.Seats)
+	}
+}
+
+func TestDiffOfIdenticalSnapshotsSucceeds(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "venue.json")
+	os.WriteFile(file, []byte(`{"seats":[{"id":"A1"}],"seq":3}`), 0o644)
+
+	var out bytes.Buffer
+	differ, err := runDiff([]string{"-from", file, "-to", file, "-state"}, &out)
+	if err != nil || differ {
+		t.Fatalf("runDiff = %v, %v; want no differences\n%s", differ, err, out.String())
+	}
+}
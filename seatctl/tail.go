@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	"concert-booking/shared"
+)
+
+// runTail prints seat events from the event bus EVENT_BUS selects as they are
+// published, until interrupted
+func runTail(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	natsURL := fs.String("nats", nats.DefaultURL, "NATS server, when EVENT_BUS is nats")
+	eventID := fs.String("event", "", "only this event's seats")
+	section := fs.String("section", "", "only seats in this section")
+	seatID := fs.String("seat", "", "only this seat")
+	asJSON := fs.Bool("json", false, "print each seat event as a line of JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var nc *nats.Conn
+	if shared.EventBusKind() == shared.EventBusNATS {
+		var err error
+		if nc, err = nats.Connect(*natsURL, nats.Name("seatctl")); err != nil {
+			return fmt.Errorf("tail: %w", err)
+		}
+		defer nc.Close()
+	}
+	bus, err := shared.OpenEventBus(nc, "seatctl")
+	if err != nil {
+		return fmt.Errorf("tail: %w", err)
+	}
+	defer bus.Close()
+
+	filter := tailFilter{eventID: *eventID, section: *section, seatID: *seatID}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		<-stop
+		close(done)
+	}()
+	return tailEvents(bus, filter, stdout, *asJSON, done)
+}
+
+// tailFilter is the seat events tail prints; empty fields match anything
+type tailFilter struct {
+	eventID, section, seatID string
+}
+
+// matches reports whether a seat event of section is one to print
+func (f tailFilter) matches(e shared.SeatEvent, section string) bool {
+	return (f.eventID == "" || f.eventID == e.EventID) && (f.section == "" || f.section == section) &&
+		(f.seatID == "" || f.seatID == e.SeatID)
+}
+
+// tailEvents prints the seat events published on bus that filter matches
+// until done is closed, the releases of a batch one by one. Batches are
+// published under the event alone, so filtering happens here rather than by
+// subject.
+func tailEvents(bus shared.EventBus, filter tailFilter, stdout io.Writer, asJSON bool, done <-chan struct{}) error {
+	type published struct {
+		subject string
+		data    []byte
+	}
+	events := make(chan published, 1024)
+	sub, err := bus.Subscribe(shared.NATSTopicAllSeats, func(subject string, data []byte) {
+		select {
+		case events <- published{subject, data}:
+		default:
+			fmt.Fprintln(os.Stderr, "seatctl: tail: falling behind, dropped a seat event on", subject)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("tail: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	enc := json.NewEncoder(stdout)
+	for {
+		select {
+		case <-done:
+			return nil
+		case p := <-events:
+			var event shared.SeatEvent
+			if err := shared.UnmarshalEvent(p.data, &event); err != nil {
+				fmt.Fprintf(stdout, "%s: unreadable seat event: %v\n", p.subject, err)
+				continue
+			}
+			if event.EventID == "" {
+				event.EventID = shared.SubjectEventID(p.subject)
+			}
+			for _, e := range event.Unbatch() {
+				if e.EventID == "" {
+					e.EventID = event.EventID
+				}
+				section := subjectSection(p.subject)
+				if e.Seat != nil {
+					section = e.Seat.Section
+				}
+				if !filter.matches(e, section) {
+					continue
+				}
+				if asJSON {
+					if err := enc.Encode(e); err != nil {
+						return err
+					}
+					continue
+				}
+				fmt.Fprintln(stdout, describeEvent(e))
+			}
+		}
+	}
+}
+
+// subjectSection is the section of a seat transition's subject, "" for a
+// batch's
+func subjectSection(subject string) string {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) != 5 || tokens[2] == "_" {
+		return ""
+	}
+	return tokens[2]
+}
+
+// describeEvent is a seat event as tail prints it
+func describeEvent(e shared.SeatEvent) string {
+	line := fmt.Sprintf("%s  seq %-6d %s  %s  %s -> %s", e.Timestamp.Local().Format("15:04:05.000"), e.Seq, e.EventID, e.SeatID,
+		e.Type, e.Status)
+	if e.Seat != nil && e.Seat.Section != "" {
+		line += "  section " + e.Seat.Section
+	}
+	if e.UserID != "" {
+		line += "  user " + e.UserID
+	}
+	if e.Actor != "" {
+		line += "  by " + e.Actor
+	}
+	if e.Source != "" {
+		line += "  via " + e.Source
+	}
+	return line
+}
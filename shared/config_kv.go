@@ -0,0 +1,56 @@
+package shared
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/nats-io/nats.go"
+)
+
+// The booking service publishes event metadata and feature flags to this NATS
+// KV bucket whenever they change. Edges watch it and serve reads from memory,
+// so changes reach every edge within milliseconds without polling.
+const (
+	ConfigBucket   = "seat-config"
+	ConfigKeyFlags = "flags" // FeatureFlags
+)
+
+// ConfigKeyEvent returns the config bucket key holding an event's EventInfo
+func ConfigKeyEvent(eventID string) string {
+	return "event." + subjectToken(eventID)
+}
+
+// FeatureFlags maps flag names to whether they are on. Flags that are not set
+// are off.
+type FeatureFlags map[string]bool
+
+// FlagSalesPaused, while on, stops new holds so operators can pause an on-sale
+const FlagSalesPaused = "sales_paused"
+
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidateFlagName checks a flag name is lower-case letters, digits, - and _
+func ValidateFlagName(name string) error {
+	if !flagNamePattern.MatchString(name) {
+		return errors.New("flag names are 1-64 lower-case letters, digits, - and _")
+	}
+	return nil
+}
+
+// OpenConfigBucket returns the config bucket, creating it if needed. It fails
+// if the NATS server does not have JetStream enabled.
+func OpenConfigBucket(nc *nats.Conn) (nats.KeyValue, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	kv, err := js.KeyValue(ConfigBucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      ConfigBucket,
+			Description: "Event metadata and feature flags",
+			History:     1,
+		})
+	}
+	return kv, err
+}
@@ -1,19 +1,82 @@
 package shared
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 // Redis key patterns
 const (
 	RedisKeyVenueSeats = "venue:seats"
 	RedisKeySeatLock   = "seat:%s:lock" // formatted with seat ID
+	RedisKeyHoldExpiry = "venue:hold_expiry" // sorted set of seat IDs scored by hold expiry
+	RedisKeyWebhooks   = "webhooks"          // hash of webhook ID to registration
+	RedisKeyWebhookEvents = "webhooks:events"  // stream of events for the webhooks, with IDs 0-<webhook sequence number>
+	RedisKeyWebhookSeq    = "webhooks:seq"     // counter stamping webhook events with a sequence number
+	RedisKeyWebhookSeen   = "webhooks:seen:%d" // formatted with a seat event's sequence number; set once it is in the webhook stream
+	RedisKeyWebhookLease  = "webhooks:lease"   // instance delivering the webhooks, while its lease lasts
+	RedisKeyEventSeq   = "venue:event_seq"   // counter stamping seat events with a sequence number
+	RedisKeyOutbox     = "venue:outbox"      // stream of seat events awaiting publication to NATS
+	RedisKeyIdempotency = "idempotency:%s"   // formatted with the client's Idempotency-Key
+	RedisKeyOrders      = "orders"             // hash of order ID to order
+	RedisKeyFulfillment = "orders:fulfillment" // list of order IDs awaiting fulfillment
+	RedisKeyAnalytics   = "analytics:bookings" // hash of booking counters
+	RedisKeyEventStream = "events:seats"       // seat events when EVENT_BUS=redis
+	RedisKeySeatHistory = "seat:%s:history"    // formatted with seat ID; stream of the seat's transitions
+	RedisKeyEventInfo   = "event:%s:info"      // formatted with event ID; organizer and event metadata
+	RedisKeyDriftReport = "drift:last_report"  // report of the latest Redis/Postgres reconciliation
+	RedisKeyAuditLog    = "audit:log"          // stream of every seat transition, for audit searches
+	RedisKeyAuditUser   = "audit:user:%s"      // formatted with user ID; audit log entry IDs scored by time
+	RedisKeyPayments    = "orders:payments"    // hash of order ID to its latest payment status
+	RedisKeyFeatureFlags = "config:flags"      // hash of feature flag name to "1" or "0"
+	RedisKeyRefunds      = "orders:refunds"    // hash of order ID to its refund status
+	RedisKeyRefundQueue  = "orders:refund_queue" // list of order IDs awaiting a refund
+	RedisKeyCancellation = "event:%s:cancellation" // formatted with event ID; hash of cancellation details and refund counters
+	RedisKeyNotificationPrefs = "user:%s:notifications" // formatted with user ID; the user's NotificationPrefs
+	RedisKeyMarketingOptOut   = "notifications:marketing_opt_out" // set of users who opted out of marketing announcements
+	RedisKeyResaleBlocks      = "resale:blocks"        // hash of resale block ID to block
+	RedisKeyResaleSold        = "resale:block:%s:sold" // formatted with block ID; set of the seats its partner sold
+	RedisKeyHoldChurn         = "user:%s:hold_churn"   // formatted with user ID; holds the user gave up in the current churn window
+	RedisKeyHoldPenalty       = "user:%s:hold_penalty" // formatted with user ID; the user's HoldPenalty while it lasts
+	RedisKeyUserBan           = "user:%s:ban"          // formatted with user ID; the user's UserBan while it lasts
 )
 
 // NATS topics
 const (
-	NATSTopicSeatHeld     = "seats.held"
-	NATSTopicSeatReleased = "seats.released"
-	NATSTopicSeatBooked   = "seats.booked"
-	NATSTopicAllSeats     = "seats.>"
+	NATSTopicAllSeats     = "seats.*.*.*.*" // every SeatSubject, but not seats.cmd.*
+	NATSSubjectCmdSelect   = "seats.cmd.select"   // request-reply: SeatCommand -> SeatCommandReply
+	NATSSubjectCmdBook     = "seats.cmd.book"
+	NATSSubjectCmdRelease  = "seats.cmd.release"
+	NATSSubjectCmdKeepalive = "seats.cmd.keepalive" // request-reply: SeatCommand -> SeatCommandReply with expires_at
+	NATSSubjectCmdSnapshot = "seats.cmd.snapshot" // request-reply: empty -> VenueSnapshotReply
+	NATSSubjectCmdEvent    = "seats.cmd.event"    // request-reply: empty -> EventInfoReply
+	NATSSubjectCmdSeat     = "seats.cmd.seat"     // request-reply: seat ID -> SeatReply
+	NATSSubjectCmdChanges  = "seats.cmd.changes"  // request-reply: sequence number -> VenueChangesReply
+	NATSQueueBookingService = "booking-service"   // queue group shared by booking-service instances
+	NATSTopicEdgeHeartbeat = "edges.heartbeat"
+	NATSTopicUserPresence  = "users.presence"
+	NATSTopicPresenceSync  = "users.presence.sync" // asks edges to re-announce their users
+	NATSSubjectEdgeInbox   = "edges.%s.inbox"      // formatted with edge ID; messages for users on that edge
+	NATSTopicUserMsgs      = "users.*.msgs"        // every UserMsgsSubject: DirectedMessages, which every edge receives
+	NATSTopicDebugTrace    = "edges.debug.trace"   // DebugTrace: turns message tracing on or off for a user or client
+	NATSTopicAnnouncements = "edges.announcements" // Announcement: a message for every connected client
+	NATSTopicSeatFocus     = "edges.focus"         // SeatFocus: a client looking at a seat, for its event's clients on every edge
+	NATSTopicKickUser      = "edges.kick"          // KickUser: disconnects every connection of a user
+)
+
+// WebSocket close codes edges use besides the standard ones, from the range
+// kept for applications
+const (
+	CloseCodeKicked              = 4001 // an operator disconnected the user
+	CloseCodeUnsupportedProtocol = 4026 // the client asked for a protocol_version the edge does not speak
+	CloseCodeTooManyConnections  = 4029 // the address or user is at its connection limit
+)
+
+// Event bus defaults
+const (
+	DefaultRedisAddr    = "localhost:6379"
+	DefaultKafkaBrokers = "localhost:9092"
+	DefaultKafkaTopic   = "seat-events" // every seat event, keyed by its subject
 )
 
 // Timeouts and durations
@@ -24,6 +87,11 @@ const (
 	WebSocketWriteTimeout = 10 * time.Second
 	WebSocketPongWait   = 60 * time.Second
 	WebSocketPingPeriod = (WebSocketPongWait * 9) / 10
+	StatusCacheTTL      = 5 * time.Second
+	EdgeHeartbeatInterval = 5 * time.Second
+	EdgeHeartbeatTimeout  = 3 * EdgeHeartbeatInterval
+	IdempotencyKeyTTL     = 24 * time.Hour
+	HoldExpiryWarning     = 10 * time.Second // how long before expiry holders are warned
 )
 
 // Venue configuration
@@ -31,6 +99,7 @@ const (
 	VenueRows = 10
 	VenueCols = 10
 	TotalSeats = VenueRows * VenueCols
+	DefaultVenueTemplate = "grid"
 )
 
 // Server configuration
@@ -39,18 +108,41 @@ const (
 	DefaultEdgePort    = ":3000"
 )
 
-// API endpoints
+// API endpoints. The booking service still serves every route under
+// APIPrefixLegacy, with a Deprecation header.
 const (
-	APIEndpointSeats       = "/api/seats"
-	APIEndpointSelectSeat  = "/api/seats/select"
-	APIEndpointBookSeat    = "/api/seats/book"
-	APIEndpointReleaseSeat = "/api/seats/release"
+	APIPrefix              = "/api/v1"
+	APIPrefixLegacy        = "/api"
+	APIEndpointSeats       = APIPrefix + "/seats"
+	APIEndpointSelectSeat  = APIPrefix + "/seats/select"
+	APIEndpointBookSeat    = APIPrefix + "/seats/book"
+	APIEndpointReleaseSeat = APIPrefix + "/seats/release"
 	APIEndpointHealth      = "/health"
+	APIEndpointStatus      = "/status"
 	WebSocketEndpoint      = "/ws"
 )
 
-// GetSeatID generates a seat ID from row and column
+// HeaderIdempotencyKey lets clients retry seat mutations safely; retries with the
+// same key get the original response instead of being applied again
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// HeaderEventSeq carries the seat event sequence number a seat listing reflects
+const HeaderEventSeq = "X-Event-Seq"
+
+// HeaderNextCursor carries the ?cursor of the next page of a paged seat listing
+const HeaderNextCursor = "X-Next-Cursor"
+
+// GetSeatID generates a seat ID from row and column (A1, A2, ... J10)
 func GetSeatID(row, col int) string {
-	rowLetter := string(rune('A' + row))
-	return rowLetter + string(rune('1' + col))
+	return GetRowLabel(row) + strconv.Itoa(col+1)
+}
+
+// GetRowLabel converts a zero-based row index to a spreadsheet-style label (A..Z, AA, AB, ...)
+func GetRowLabel(row int) string {
+	label := ""
+	for row >= 0 {
+		label = string(rune('A'+row%26)) + label
+		row = row/26 - 1
+	}
+	return label
 }
\ No newline at end of file
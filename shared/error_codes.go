@@ -0,0 +1,100 @@
+package shared
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorCode says what went wrong in a form clients can switch on; the error
+// message next to it is for people and may change
+type ErrorCode string
+
+// Error codes of ErrorResponse, SeatCommandReply and the failed responses to
+// WebSocket messages
+const (
+	// The request itself
+	ErrorCodeInvalidRequest     ErrorCode = "INVALID_REQUEST"
+	ErrorCodeUnknownMessageType ErrorCode = "UNKNOWN_MESSAGE_TYPE"
+	ErrorCodeUnsupportedVersion ErrorCode = "UNSUPPORTED_PROTOCOL_VERSION"
+	ErrorCodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	ErrorCodeTokenExpired       ErrorCode = "TOKEN_EXPIRED"
+	ErrorCodeForbidden          ErrorCode = "FORBIDDEN"
+	ErrorCodeNotFound           ErrorCode = "NOT_FOUND"
+	ErrorCodeConflict           ErrorCode = "CONFLICT"
+	ErrorCodeGone               ErrorCode = "GONE"
+	ErrorCodeRateLimited        ErrorCode = "RATE_LIMITED"
+	ErrorCodeIdempotencyReused  ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	ErrorCodeIdempotencyPending ErrorCode = "IDEMPOTENCY_KEY_PENDING"
+
+	// Seats and holds
+	ErrorCodeSeatHeldByOther  ErrorCode = "SEAT_HELD_BY_OTHER"
+	ErrorCodeSeatHeldByYou    ErrorCode = "SEAT_HELD_BY_YOU"
+	ErrorCodeSeatBooked       ErrorCode = "SEAT_BOOKED"
+	ErrorCodeSeatNotHeld      ErrorCode = "SEAT_NOT_HELD"
+	ErrorCodeNotHolder        ErrorCode = "NOT_HOLDER"
+	ErrorCodeHoldExpired      ErrorCode = "HOLD_EXPIRED"
+	ErrorCodeSeatVersionStale ErrorCode = "SEAT_VERSION_STALE"
+	ErrorCodeKeepaliveOff     ErrorCode = "HOLD_KEEPALIVE_OFF"
+	ErrorCodeHoldCooldown     ErrorCode = "HOLD_COOLDOWN"
+	ErrorCodeUserBanned       ErrorCode = "USER_BANNED"
+	ErrorCodeEventCancelled   ErrorCode = "EVENT_CANCELLED"
+	ErrorCodeSalesPaused      ErrorCode = "SALES_PAUSED"
+
+	// The connection
+	ErrorCodeChallengeRequired ErrorCode = "CHALLENGE_REQUIRED"
+	ErrorCodeWaiting           ErrorCode = "WAITING_FOR_SLOT"
+
+	// The service
+	ErrorCodeUnavailable ErrorCode = "UNAVAILABLE"
+	ErrorCodeInternal    ErrorCode = "INTERNAL"
+)
+
+// Error is an error carrying its ErrorCode. Its message is the error's text.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError returns an error with code and message; errors.Is matches only
+// the error returned, so it can be a sentinel
+func NewError(code ErrorCode, message string) error {
+	return &Error{Code: code, Message: message}
+}
+
+// CodeOf returns the code of the first *Error in err's chain, or fallback if
+// there is none or it has no code
+func CodeOf(err error, fallback ErrorCode) ErrorCode {
+	var coded *Error
+	if errors.As(err, &coded) && coded.Code != "" {
+		return coded.Code
+	}
+	return fallback
+}
+
+// ErrorCodeForStatus is the code for an HTTP error status whose error says no
+// more
+func ErrorCodeForStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrorCodeInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusGone:
+		return ErrorCodeGone
+	case http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrorCodeUnavailable
+	}
+	return ErrorCodeInternal
+}
@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+)
+
+// EventBus carries seat events between services. Subjects follow NATS syntax on
+// every backend, so publishers and subscribers do not depend on which one is
+// configured.
+type EventBus interface {
+	// Publish sends data on subject. Delivery may be buffered until Flush.
+	Publish(subject string, data []byte) error
+
+	// Flush waits until everything published so far has been accepted by the bus
+	Flush(timeout time.Duration) error
+
+	// Subscribe delivers every message whose subject matches pattern (NATS
+	// wildcards) to handler. Each subscription receives every message; there
+	// is no load balancing between subscribers.
+	Subscribe(pattern string, handler func(subject string, data []byte)) (Subscription, error)
+
+	Close() error
+}
+
+// Subscription is an active EventBus subscription
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Event bus backends selectable with EVENT_BUS
+const (
+	EventBusNATS  = "nats"
+	EventBusKafka = "kafka"
+	EventBusRedis = "redis"
+)
+
+// EventBusKind returns the backend selected by EVENT_BUS, NATS by default
+func EventBusKind() string {
+	if kind := os.Getenv("EVENT_BUS"); kind != "" {
+		return kind
+	}
+	return EventBusNATS
+}
+
+// RedisAddr returns the Redis server at REDIS_URL, DefaultRedisAddr by default
+func RedisAddr() string {
+	if addr := os.Getenv("REDIS_URL"); addr != "" {
+		return addr
+	}
+	return DefaultRedisAddr
+}
+
+// NATSURL returns the NATS server at NATS_URL, nats://localhost:4222 by default
+func NATSURL() string {
+	if url := os.Getenv("NATS_URL"); url != "" {
+		return url
+	}
+	return nats.DefaultURL
+}
+
+// OpenEventBus returns the event bus selected by EVENT_BUS: NATS on nc, Kafka
+// using KAFKA_BROKERS and KAFKA_TOPIC, or a Redis stream at REDIS_URL. Only the
+// NATS bus uses nc, which may be nil otherwise. clientID identifies this
+// process to the bus.
+func OpenEventBus(nc *nats.Conn, clientID string) (EventBus, error) {
+	switch kind := EventBusKind(); kind {
+	case EventBusNATS:
+		if nc == nil {
+			return nil, errors.New("the NATS event bus needs a NATS connection")
+		}
+		return NewNATSEventBus(nc), nil
+	case EventBusKafka:
+		brokers := os.Getenv("KAFKA_BROKERS")
+		if brokers == "" {
+			brokers = DefaultKafkaBrokers
+		}
+		topic := os.Getenv("KAFKA_TOPIC")
+		if topic == "" {
+			topic = DefaultKafkaTopic
+		}
+		return NewKafkaEventBus(strings.Split(brokers, ","), topic), nil
+	case EventBusRedis:
+		return NewRedisEventBus(redis.NewClient(&redis.Options{Addr: RedisAddr()}), RedisKeyEventStream, clientID), nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BUS %q (want nats, kafka or redis)", kind)
+	}
+}
+
+// natsEventBus is an EventBus on a NATS connection
+type natsEventBus struct {
+	nc *nats.Conn
+}
+
+// NewNATSEventBus returns an EventBus that publishes and subscribes on nc. Close
+// leaves nc open for its other users.
+func NewNATSEventBus(nc *nats.Conn) EventBus {
+	return &natsEventBus{nc: nc}
+}
+
+func (b *natsEventBus) Publish(subject string, data []byte) error {
+	return b.nc.Publish(subject, data)
+}
+
+func (b *natsEventBus) Flush(timeout time.Duration) error {
+	return b.nc.FlushTimeout(timeout)
+}
+
+func (b *natsEventBus) Subscribe(pattern string, handler func(subject string, data []byte)) (Subscription, error) {
+	return b.nc.Subscribe(pattern, func(msg *nats.Msg) {
+		handler(msg.Subject, msg.Data)
+	})
+}
+
+func (b *natsEventBus) Close() error {
+	return nil
+}
@@ -0,0 +1,252 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// Most messages kept for the next Flush, counting a batch being written,
+	// before Publish refuses more
+	maxPendingKafkaMessages = 100000
+
+	// Header carrying a message's subject
+	kafkaSubjectHeader = "subject"
+)
+
+// ErrBusBacklog is returned by Publish while the bus holds as many unflushed
+// messages as it will keep
+var ErrBusBacklog = errors.New("event bus backlog is full")
+
+// kafkaWriter is the part of a kafka.Writer the bus writes through
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaEventBus is an EventBus on a single Kafka topic. Kafka has no subject
+// hierarchy, so each message carries its subject in a header and subscribers
+// filter on it. Messages are keyed by seat (kafkaKey), so a seat's transitions
+// stay in one partition and in order.
+type kafkaEventBus struct {
+	brokers []string
+	topic   string
+	writer  kafkaWriter
+
+	mu       sync.Mutex
+	pending  []kafka.Message
+	inFlight int // messages of the batch Flush is writing
+	subs     []*kafkaSubscription
+}
+
+// NewKafkaEventBus returns an EventBus on topic. Published messages are buffered
+// and written when Flush is called.
+func NewKafkaEventBus(brokers []string, topic string) EventBus {
+	return &kafkaEventBus{
+		brokers: brokers,
+		topic:   topic,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Topic:                  topic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+	}
+}
+
+// kafkaKey returns the partition key of subject: a seat subject without its
+// action, seats.<event>.<section>.<seatID>, so every transition of a seat lands
+// in the same partition. A release batch spans seats and is keyed
+// seats.<event>._._, so it is not ordered against their other transitions;
+// consumers order those by sequence number. Other subjects are their own key.
+func kafkaKey(subject string) string {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) == 5 && tokens[0] == "seats" {
+		return strings.Join(tokens[:4], ".")
+	}
+	return subject
+}
+
+// kafkaSubject returns the subject a message was published on
+func kafkaSubject(msg kafka.Message) string {
+	for _, header := range msg.Headers {
+		if header.Key == kafkaSubjectHeader {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (b *kafkaEventBus) Publish(subject string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending)+b.inFlight >= maxPendingKafkaMessages {
+		return ErrBusBacklog
+	}
+	b.pending = append(b.pending, kafka.Message{
+		Key:     []byte(kafkaKey(subject)),
+		Value:   data,
+		Headers: []kafka.Header{{Key: kafkaSubjectHeader, Value: []byte(subject)}},
+	})
+	return nil
+}
+
+func (b *kafkaEventBus) Flush(timeout time.Duration) error {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.inFlight = len(batch)
+	b.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := b.writer.WriteMessages(ctx, batch...)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlight = 0
+	if err != nil {
+		// Keep the batch, in order, ahead of anything published since
+		b.pending = append(batch, b.pending...)
+		return err
+	}
+	return nil
+}
+
+// Subscribe reads every partition of the topic from its newest offset, so every
+// subscriber sees every message written after it subscribed and nothing is left
+// on the brokers when it goes. Partitions added to the topic later are not read.
+func (b *kafkaEventBus) Subscribe(pattern string, handler func(subject string, data []byte)) (Subscription, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &kafkaSubscription{cancel: cancel}
+
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	go sub.run(ctx, b.brokers, b.topic, kafkaFilter(pattern, handler))
+	return sub, nil
+}
+
+// kafkaFilter passes handler the messages whose subject matches pattern
+func kafkaFilter(pattern string, handler func(subject string, data []byte)) func(kafka.Message) {
+	return func(msg kafka.Message) {
+		if subject := kafkaSubject(msg); SubjectMatches(pattern, subject) {
+			handler(subject, msg.Value)
+		}
+	}
+}
+
+func (b *kafkaEventBus) Close() error {
+	b.mu.Lock()
+	subs := b.subs
+	b.subs = nil
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	return b.writer.Close()
+}
+
+type kafkaSubscription struct {
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	readers []*kafka.Reader
+	closed  bool
+}
+
+// run looks up the topic's partitions, waiting for the topic to be created if
+// need be, and reads each of them until the subscription is closed
+func (s *kafkaSubscription) run(ctx context.Context, brokers []string, topic string, deliver func(kafka.Message)) {
+	var partitions []kafka.Partition
+	for {
+		var err error
+		partitions, err = lookupPartitions(ctx, brokers, topic)
+		if err == nil && len(partitions) > 0 {
+			break
+		}
+		if err == nil {
+			err = fmt.Errorf("topic %s has no partitions yet", topic)
+		}
+		slog.Warn("Event bus partition lookup failed, retrying", LogKeyComponent, "kafka", "topic", topic, ErrAttr(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for _, partition := range partitions {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   brokers,
+			Topic:     topic,
+			Partition: partition.ID,
+		})
+		if err := reader.SetOffset(kafka.LastOffset); err != nil {
+			slog.Error("Event bus could not start at the newest offset", LogKeyComponent, "kafka", "topic", topic, "partition", partition.ID, ErrAttr(err))
+		}
+		s.readers = append(s.readers, reader)
+		go readPartition(reader, topic, deliver)
+	}
+}
+
+// lookupPartitions asks the brokers in turn for the topic's partitions
+func lookupPartitions(ctx context.Context, brokers []string, topic string) ([]kafka.Partition, error) {
+	var err error
+	for _, broker := range brokers {
+		var partitions []kafka.Partition
+		if partitions, err = kafka.LookupPartitions(ctx, "tcp", broker, topic); err == nil {
+			return partitions, nil
+		}
+	}
+	return nil, err
+}
+
+func readPartition(reader *kafka.Reader, topic string, deliver func(kafka.Message)) {
+	for {
+		msg, err := reader.ReadMessage(context.Background())
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			slog.Error("Event bus read failed", LogKeyComponent, "kafka", "topic", topic, ErrAttr(err))
+			time.Sleep(time.Second)
+			continue
+		}
+		deliver(msg)
+	}
+}
+
+func (s *kafkaSubscription) Unsubscribe() error {
+	s.cancel()
+
+	s.mu.Lock()
+	readers := s.readers
+	s.readers = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	for _, reader := range readers {
+		reader.Close()
+	}
+	return nil
+}
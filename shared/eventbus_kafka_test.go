@@ -0,0 +1,114 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafkaWriter keeps the messages written to it, failing while err is set
+type fakeKafkaWriter struct {
+	written []kafka.Message
+	err     error
+}
+
+func (w *fakeKafkaWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error { return nil }
+
+func TestKafkaEventBusKeysBySeatAndFiltersBySubject(t *testing.T) {
+	writer := &fakeKafkaWriter{}
+	bus := &kafkaEventBus{writer: writer}
+
+	held := SeatSubject(DefaultEventID, "101", "101-A1", "held")
+	booked := SeatSubject(DefaultEventID, "101", "101-A1", "booked")
+	for i, subject := range []string{held, booked, SeatBatchSubject(DefaultEventID), NATSSubjectCmdSelect} {
+		bus.Publish(subject, []byte{byte('1' + i)})
+	}
+	if err := bus.Flush(time.Second); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// A seat's transitions share a key, so they land in one partition in order
+	var keys []string
+	for _, msg := range writer.written {
+		keys = append(keys, string(msg.Key))
+	}
+	want := []string{"seats.main.101.101-A1", "seats.main.101.101-A1", "seats.main._._", NATSSubjectCmdSelect}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+
+	var got []string
+	for _, pattern := range []string{NATSTopicAllSeats, "seats.*.*.*.booked"} {
+		deliver := kafkaFilter(pattern, func(subject string, data []byte) {
+			got = append(got, pattern+" "+subject+" "+string(data))
+		})
+		for _, msg := range writer.written {
+			deliver(msg)
+		}
+	}
+	want = []string{
+		NATSTopicAllSeats + " " + held + " 1",
+		NATSTopicAllSeats + " " + booked + " 2",
+		NATSTopicAllSeats + " " + SeatBatchSubject(DefaultEventID) + " 3",
+		"seats.*.*.*.booked " + booked + " 2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestKafkaEventBusRequeuesAFailedFlushInOrder(t *testing.T) {
+	writer := &fakeKafkaWriter{err: errors.New("kafka: broker unavailable")}
+	bus := &kafkaEventBus{writer: writer}
+
+	bus.Publish("seats.main.101.101-A1.held", []byte("1"))
+	bus.Publish("seats.main.101.101-A2.held", []byte("2"))
+	if err := bus.Flush(time.Second); err == nil {
+		t.Fatal("Flush succeeded with the broker down")
+	}
+	bus.Publish("seats.main.101.101-A1.booked", []byte("3"))
+
+	writer.err = nil
+	if err := bus.Flush(time.Second); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	var values []string
+	for _, msg := range writer.written {
+		values = append(values, string(msg.Value))
+	}
+	if !reflect.DeepEqual(values, []string{"1", "2", "3"}) {
+		t.Errorf("written %v, want the failed batch ahead of what followed", values)
+	}
+	if len(bus.pending) != 0 || bus.inFlight != 0 {
+		t.Errorf("%d pending and %d in flight after a successful flush", len(bus.pending), bus.inFlight)
+	}
+}
+
+func TestKafkaEventBusRefusesPublishesBeyondItsBacklog(t *testing.T) {
+	bus := &kafkaEventBus{writer: &fakeKafkaWriter{err: errors.New("kafka: broker unavailable")}}
+
+	for i := 0; i < maxPendingKafkaMessages; i++ {
+		if err := bus.Publish("seats.main.101.101-A1.held", nil); err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+	}
+	if err := bus.Publish("seats.main.101.101-A1.held", nil); err != ErrBusBacklog {
+		t.Fatalf("publish beyond the backlog: got %v, want ErrBusBacklog", err)
+	}
+	bus.Flush(time.Second)
+	if err := bus.Publish("seats.main.101.101-A1.held", nil); err != ErrBusBacklog {
+		t.Fatalf("publish after a failed flush: got %v, want ErrBusBacklog", err)
+	}
+}
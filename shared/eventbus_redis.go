@@ -0,0 +1,166 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// Approximate number of events the stream keeps for slow subscribers
+	redisEventStreamMaxLen = 100000
+
+	// Entries read per round trip and how long a read waits for them
+	redisEventReadCount = 100
+	redisEventReadBlock = 1 * time.Second
+)
+
+// redisEventBus is an EventBus on a Redis stream, for deployments that run Redis
+// but not NATS. Each entry holds a subject and its data; every subscription reads
+// the stream through a consumer group of its own and filters subjects by pattern.
+type redisEventBus struct {
+	client   *redis.Client
+	stream   string
+	clientID string
+
+	mu      sync.Mutex
+	pending []*redis.XAddArgs
+	subs    []*redisSubscription
+}
+
+// NewRedisEventBus returns an EventBus on stream. Published events are buffered
+// and appended when Flush is called. Close closes client.
+func NewRedisEventBus(client *redis.Client, stream, clientID string) EventBus {
+	return &redisEventBus{client: client, stream: stream, clientID: clientID}
+}
+
+func (b *redisEventBus) Publish(subject string, data []byte) error {
+	b.mu.Lock()
+	b.pending = append(b.pending, &redis.XAddArgs{
+		Stream: b.stream,
+		MaxLen: redisEventStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"subject": subject, "data": data},
+	})
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *redisEventBus) Flush(timeout time.Duration) error {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	pipe := b.client.Pipeline()
+	for _, args := range batch {
+		pipe.XAdd(ctx, args)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		// Keep the batch, in order, ahead of anything published since. Entries
+		// the pipeline did append before failing will be delivered twice.
+		b.mu.Lock()
+		b.pending = append(batch, b.pending...)
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Subscribe creates a consumer group for this subscription positioned at the
+// end of the stream, so it sees events appended from now on
+func (b *redisEventBus) Subscribe(pattern string, handler func(subject string, data []byte)) (Subscription, error) {
+	group := b.clientID + "-" + uuid.NewString()
+	if err := b.client.XGroupCreateMkStream(context.Background(), b.stream, group, "$").Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &redisSubscription{bus: b, group: group, cancel: cancel}
+
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	go sub.run(ctx, pattern, handler)
+	return sub, nil
+}
+
+func (b *redisEventBus) Close() error {
+	b.mu.Lock()
+	subs := b.subs
+	b.subs = nil
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	return b.client.Close()
+}
+
+type redisSubscription struct {
+	bus    *redisEventBus
+	group  string
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (s *redisSubscription) run(ctx context.Context, pattern string, handler func(subject string, data []byte)) {
+	for {
+		streams, err := s.bus.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.bus.clientID,
+			Streams:  []string{s.bus.stream, ">"},
+			Count:    redisEventReadCount,
+			Block:    redisEventReadBlock,
+		}).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			slog.Error("Event bus read failed", LogKeyComponent, "redis", "stream", s.bus.stream, ErrAttr(err))
+			time.Sleep(time.Second)
+			continue
+		}
+
+		messages := streams[0].Messages
+		ids := make([]string, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+			subject, _ := msg.Values["subject"].(string)
+			data, _ := msg.Values["data"].(string)
+			if SubjectMatches(pattern, subject) {
+				handler(subject, []byte(data))
+			}
+		}
+
+		// The group belongs to this subscription alone, so nothing else could
+		// take over unacknowledged entries; acknowledge to keep its PEL small
+		if err := s.bus.client.XAck(ctx, s.bus.stream, s.group, ids...).Err(); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Warn("Failed to acknowledge events", LogKeyComponent, "redis", "count", len(ids), ErrAttr(err))
+		}
+	}
+}
+
+// Unsubscribe stops reading and deletes the subscription's consumer group
+func (s *redisSubscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		s.cancel()
+		err = s.bus.client.XGroupDestroy(context.Background(), s.bus.stream, s.group).Err()
+	})
+	return err
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisEventBusDeliversToEverySubscriber(t *testing.T) {
+	mr := miniredis.RunT(t)
+	bus := NewRedisEventBus(redis.NewClient(&redis.Options{Addr: mr.Addr()}), RedisKeyEventStream, "test")
+	t.Cleanup(func() { bus.Close() })
+
+	all := make(chan string, 10)
+	booked := make(chan string, 10)
+	if _, err := bus.Subscribe(NATSTopicAllSeats, func(subject string, data []byte) { all <- string(data) }); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	sub, err := bus.Subscribe("seats.*.*.*.booked", func(subject string, data []byte) { booked <- string(data) })
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	bus.Publish(SeatSubject(DefaultEventID, "101", "101-A1", "held"), []byte("1"))
+	bus.Publish(SeatSubject(DefaultEventID, "101", "101-A1", "booked"), []byte("2"))
+	bus.Publish(NATSSubjectCmdSelect, []byte("not an event"))
+	if err := bus.Flush(time.Second); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	for _, want := range []string{"1", "2"} {
+		select {
+		case got := <-all:
+			if got != want {
+				t.Fatalf("seats subscriber got %q, want %q", got, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("seats subscriber never got %q", want)
+		}
+	}
+	select {
+	case got := <-booked:
+		if got != "2" {
+			t.Fatalf("booked subscriber got %q, want 2", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("booked subscriber got nothing")
+	}
+
+	select {
+	case got := <-all:
+		t.Fatalf("unexpected delivery %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	group := sub.(*redisSubscription).group
+	if err := redis.NewClient(&redis.Options{Addr: mr.Addr()}).XPending(context.Background(), RedisKeyEventStream, group).Err(); err == nil {
+		t.Fatalf("consumer group %s still exists after Unsubscribe", group)
+	}
+}
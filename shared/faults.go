@@ -0,0 +1,155 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How long a delayed Redis command waits unless CHAOS_REDIS_DELAY says
+const DefaultChaosRedisDelay = 2 * time.Second
+
+// Faults are the chances, from 0 to 1, of failures injected on purpose to
+// run the paths happy-path testing never reaches: seat events that never
+// arrive make edges reconcile, slow Redis makes edges retry and serve a
+// degraded seat map, dead connections make clients reconnect and resume.
+// All are 0 unless CHAOS_* variables set them; never set those in production.
+type Faults struct {
+	DropPublish float64       // CHAOS_DROP_PUBLISH: an event bus publish is dropped
+	DelayRedis  float64       // CHAOS_DELAY_REDIS: a Redis command is delayed
+	RedisDelay  time.Duration // CHAOS_REDIS_DELAY: for this long
+	KillConn    float64       // CHAOS_KILL_WS: a WebSocket connection dies, per message sent on it
+}
+
+// Fault is a kind of failure Faults injects
+type Fault int
+
+const (
+	FaultDropPublish Fault = iota
+	FaultDelayRedis
+	FaultKillConn
+)
+
+var faults atomic.Pointer[Faults]
+
+// InitFaults injects the faults set by CHAOS_* variables
+func InitFaults() error {
+	var f Faults
+	for _, p := range []struct {
+		name  string
+		value *float64
+	}{
+		{"CHAOS_DROP_PUBLISH", &f.DropPublish},
+		{"CHAOS_DELAY_REDIS", &f.DelayRedis},
+		{"CHAOS_KILL_WS", &f.KillConn},
+	} {
+		raw := os.Getenv(p.name)
+		if raw == "" {
+			continue
+		}
+		chance, err := strconv.ParseFloat(raw, 64)
+		if err != nil || chance < 0 || chance > 1 {
+			return fmt.Errorf("%s must be a probability from 0 to 1, got %q", p.name, raw)
+		}
+		*p.value = chance
+	}
+	f.RedisDelay = DefaultChaosRedisDelay
+	if raw := os.Getenv("CHAOS_REDIS_DELAY"); raw != "" {
+		delay, err := time.ParseDuration(raw)
+		if err != nil || delay <= 0 {
+			return fmt.Errorf("CHAOS_REDIS_DELAY must be a positive duration, got %q", raw)
+		}
+		f.RedisDelay = delay
+	}
+
+	SetFaults(f)
+	if f.DropPublish > 0 || f.DelayRedis > 0 || f.KillConn > 0 {
+		slog.Warn("Fault injection enabled", "drop_publish", f.DropPublish,
+			"delay_redis", f.DelayRedis, "redis_delay", f.RedisDelay, "kill_ws", f.KillConn)
+	}
+	return nil
+}
+
+// SetFaults replaces the faults injected
+func SetFaults(f Faults) {
+	faults.Store(&f)
+}
+
+// InjectFault reports whether to fail now with kind, at its configured chance
+func InjectFault(kind Fault) bool {
+	f := faults.Load()
+	if f == nil {
+		return false
+	}
+	var chance float64
+	switch kind {
+	case FaultDropPublish:
+		chance = f.DropPublish
+	case FaultDelayRedis:
+		chance = f.DelayRedis
+	case FaultKillConn:
+		chance = f.KillConn
+	}
+	return chance > 0 && rand.Float64() < chance
+}
+
+// faultyEventBus drops publishes at the CHAOS_DROP_PUBLISH chance, reporting
+// them sent as a publish lost on the way would be
+type faultyEventBus struct {
+	EventBus
+}
+
+// WithFaults wraps bus to inject FaultDropPublish
+func WithFaults(bus EventBus) EventBus {
+	return faultyEventBus{bus}
+}
+
+func (b faultyEventBus) Publish(subject string, data []byte) error {
+	if InjectFault(FaultDropPublish) {
+		slog.Debug("Injected fault: dropped publish", "subject", subject)
+		return nil
+	}
+	return b.EventBus.Publish(subject, data)
+}
+
+// RedisFaultHook delays Redis commands at the CHAOS_DELAY_REDIS chance, or
+// until the command's context is done
+type RedisFaultHook struct{}
+
+func (RedisFaultHook) delay(ctx context.Context, name string) error {
+	if !InjectFault(FaultDelayRedis) {
+		return nil
+	}
+	slog.Debug("Injected fault: delayed Redis", "command", name)
+	timer := time.NewTimer(faults.Load().RedisDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h RedisFaultHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h.delay(ctx, cmd.Name())
+}
+
+func (RedisFaultHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h RedisFaultHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, h.delay(ctx, "pipeline")
+}
+
+func (RedisFaultHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
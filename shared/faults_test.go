@@ -0,0 +1,81 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestInitFaultsReadsChanceVariables(t *testing.T) {
+	t.Cleanup(func() { SetFaults(Faults{}) })
+
+	t.Setenv("CHAOS_DROP_PUBLISH", "0.25")
+	t.Setenv("CHAOS_KILL_WS", "1")
+	if err := InitFaults(); err != nil {
+		t.Fatal(err)
+	}
+	if f := *faults.Load(); f != (Faults{DropPublish: 0.25, KillConn: 1, RedisDelay: DefaultChaosRedisDelay}) {
+		t.Errorf("faults = %+v", f)
+	}
+	if !InjectFault(FaultKillConn) || InjectFault(FaultDelayRedis) {
+		t.Error("a certain fault was not injected, or one that is off was")
+	}
+
+	for name, value := range map[string]string{
+		"CHAOS_DELAY_REDIS":  "1.5",
+		"CHAOS_KILL_WS":      "often",
+		"CHAOS_REDIS_DELAY":  "-1s",
+		"CHAOS_DROP_PUBLISH": "-0.1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if err := InitFaults(); err == nil {
+				t.Errorf("%s=%s was accepted", name, value)
+			}
+		})
+	}
+}
+
+// countingBus counts the publishes that reach it
+type countingBus struct {
+	EventBus
+	published int
+}
+
+func (b *countingBus) Publish(subject string, data []byte) error {
+	b.published++
+	return nil
+}
+
+func TestFaultsDropPublishesAndDelayRedis(t *testing.T) {
+	t.Cleanup(func() { SetFaults(Faults{}) })
+
+	bus := &countingBus{}
+	faulty := WithFaults(bus)
+	faulty.Publish("seats.main.floor.A1.held", nil)
+	SetFaults(Faults{DropPublish: 1})
+	if err := faulty.Publish("seats.main.floor.A1.booked", nil); err != nil || bus.published != 1 {
+		t.Fatalf("dropped publish returned %v with %d publishes through, want it lost silently", err, bus.published)
+	}
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	client.AddHook(RedisFaultHook{})
+	SetFaults(Faults{DelayRedis: 1, RedisDelay: 50 * time.Millisecond})
+	start := time.Now()
+	if err := client.Ping(context.Background()).Err(); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("delayed PING = %v after %s, want it answered after 50ms", err, time.Since(start))
+	}
+
+	// A command gives up when its context is done
+	SetFaults(Faults{DelayRedis: 1, RedisDelay: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("PING delayed past its deadline = %v", err)
+	}
+}
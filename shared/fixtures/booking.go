@@ -0,0 +1,218 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// BookingService stands in for the booking service's seat API when testing
+// its clients, such as the edge server, in process: the booking service is a
+// main package and cannot be linked into their tests. Seats are held, booked
+// and released by the booking service's rules, and every transition is
+// published on the bus as its outbox relay would, numbered in sequence.
+// Holds last until Expire ends them.
+type BookingService struct {
+	// URL is the base URL the API is served on
+	URL string
+
+	bus shared.EventBus
+
+	mu    sync.Mutex
+	seats map[string]shared.Seat
+	order []string // seat IDs in venue order
+	seq   int64
+}
+
+// NewBookingService serves seats until the test ends, publishing their
+// transitions on bus
+func NewBookingService(t testing.TB, bus shared.EventBus, seats []shared.Seat) *BookingService {
+	t.Helper()
+
+	s := &BookingService{bus: bus, seats: make(map[string]shared.Seat, len(seats))}
+	for _, seat := range seats {
+		s.seats[seat.ID] = seat
+		s.order = append(s.order, seat.ID)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+shared.APIEndpointHealth, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET "+shared.APIEndpointSeats, s.handleSeats)
+	mux.HandleFunc("GET "+shared.APIEndpointSeats+"/changes", func(w http.ResponseWriter, r *http.Request) {
+		// No change log is kept, so clients fall back to the full listing
+		writeError(w, http.StatusGone, shared.ErrorCodeGone, "seat changes are not kept")
+	})
+	mux.HandleFunc("GET "+shared.APIEndpointSeats+"/{id}", s.handleSeat)
+	mux.HandleFunc("POST "+shared.APIEndpointSelectSeat, s.command(s.selectSeat))
+	mux.HandleFunc("POST "+shared.APIEndpointBookSeat, s.command(s.bookSeat))
+	mux.HandleFunc("POST "+shared.APIEndpointReleaseSeat, s.command(s.releaseSeat))
+	mux.HandleFunc("POST "+shared.APIEndpointSeats+"/keepalive", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusConflict, shared.ErrorCodeKeepaliveOff, "holds are not kept alive for this event")
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	s.URL = server.URL
+	return s
+}
+
+// Seat returns a seat as it stands
+func (s *BookingService) Seat(seatID string) shared.Seat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seats[seatID]
+}
+
+// Expire ends a seat's hold as the booking service's timers do when it runs
+// out, reporting false if the seat was not held
+func (s *BookingService) Expire(seatID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seat, ok := s.seats[seatID]
+	if !ok || seat.Status != shared.SeatHeld {
+		return false
+	}
+	holder := seat.HeldBy
+	seat.Status, seat.HeldBy, seat.ExpiresAt, seat.HeldAt = shared.SeatAvailable, "", 0, 0
+	s.transition(seat, "auto_released", holder, "expiry")
+	return true
+}
+
+func (s *BookingService) handleSeats(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	seats := make([]shared.Seat, 0, len(s.order))
+	for _, id := range s.order {
+		seats = append(seats, s.seats[id])
+	}
+	seq := s.seq
+	s.mu.Unlock()
+
+	w.Header().Set(shared.HeaderEventSeq, strconv.FormatInt(seq, 10))
+	writeJSON(w, http.StatusOK, seats)
+}
+
+func (s *BookingService) handleSeat(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	seat, ok := s.seats[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, shared.ErrorCodeNotFound, "seat not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, seat)
+}
+
+// command serves a seat command: apply changes the seat the request names, or
+// refuses with the error the booking service would answer
+func (s *BookingService) command(apply func(seat *shared.Seat, userID string) *shared.Error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req shared.SeatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SeatID == "" || req.UserID == "" {
+			writeError(w, http.StatusBadRequest, shared.ErrorCodeInvalidRequest, "Invalid request")
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		seat, ok := s.seats[req.SeatID]
+		if !ok {
+			writeError(w, http.StatusNotFound, shared.ErrorCodeNotFound, "seat not found")
+			return
+		}
+		if req.Version != 0 && seat.Version != req.Version {
+			writeError(w, http.StatusConflict, shared.ErrorCodeSeatVersionStale, "seat has changed since that version")
+			return
+		}
+		if err := apply(&seat, req.UserID); err != nil {
+			writeError(w, http.StatusConflict, err.Code, err.Message)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+	}
+}
+
+func (s *BookingService) selectSeat(seat *shared.Seat, userID string) *shared.Error {
+	switch {
+	case seat.Status == shared.SeatBooked:
+		return &shared.Error{Code: shared.ErrorCodeSeatBooked, Message: "seat is already booked"}
+	case seat.Status == shared.SeatHeld && seat.HeldBy == userID:
+		return &shared.Error{Code: shared.ErrorCodeSeatHeldByYou, Message: "you already hold this seat"}
+	case seat.Status == shared.SeatHeld:
+		return &shared.Error{Code: shared.ErrorCodeSeatHeldByOther, Message: "seat is already held by another user"}
+	}
+	now := time.Now()
+	seat.Status, seat.HeldBy = shared.SeatHeld, userID
+	seat.HeldAt, seat.ExpiresAt = now.Unix(), now.Add(shared.HoldDuration).Unix()
+	s.transition(*seat, "held", userID, "api")
+	return nil
+}
+
+func (s *BookingService) bookSeat(seat *shared.Seat, userID string) *shared.Error {
+	if err := holdOf(seat, userID); err != nil {
+		return err
+	}
+	seat.Status, seat.ExpiresAt = shared.SeatBooked, 0
+	s.transition(*seat, "booked", userID, "api")
+	return nil
+}
+
+func (s *BookingService) releaseSeat(seat *shared.Seat, userID string) *shared.Error {
+	if err := holdOf(seat, userID); err != nil {
+		return err
+	}
+	seat.Status, seat.HeldBy, seat.ExpiresAt, seat.HeldAt = shared.SeatAvailable, "", 0, 0
+	s.transition(*seat, "released", userID, "api")
+	return nil
+}
+
+// holdOf refuses a command on a seat userID does not hold
+func holdOf(seat *shared.Seat, userID string) *shared.Error {
+	if seat.Status != shared.SeatHeld {
+		return &shared.Error{Code: shared.ErrorCodeSeatNotHeld, Message: "seat is not held"}
+	}
+	if seat.HeldBy != userID {
+		return &shared.Error{Code: shared.ErrorCodeNotHolder, Message: "seat is not held by you"}
+	}
+	return nil
+}
+
+// transition stores seat at its next version and publishes the event for it.
+// s.mu must be held, so events are published in sequence order.
+func (s *BookingService) transition(seat shared.Seat, eventType, userID, source string) {
+	seat.Version++
+	s.seats[seat.ID] = seat
+	s.seq++
+
+	event, _ := json.Marshal(shared.SeatEvent{
+		Type:      eventType,
+		Seq:       s.seq,
+		SeatID:    seat.ID,
+		UserID:    userID,
+		Source:    source,
+		Status:    seat.Status,
+		Version:   seat.Version,
+		Timestamp: time.Now(),
+		ExpiresAt: seat.ExpiresAt,
+		Seat:      &seat,
+	})
+	s.bus.Publish(shared.SeatSubject(shared.DefaultEventID, seat.Section, seat.ID, eventType), event)
+	s.bus.Flush(time.Second)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code shared.ErrorCode, message string) {
+	writeJSON(w, status, shared.ErrorResponse{Code: code, Error: message})
+}
@@ -0,0 +1,113 @@
+package fixtures
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"concert-booking/shared"
+)
+
+var errBusClosed = errors.New("event bus closed")
+
+// EventBus is an in-memory shared.EventBus. Publish hands each message to
+// every matching subscription before it returns, so a test sees its effect
+// without waiting; messages from concurrent publishers may reach a handler
+// in either order, as they may on NATS. Every message is also kept for
+// Published.
+type EventBus struct {
+	mu        sync.Mutex
+	subs      []*busSubscription
+	published []Message
+	closed    bool
+}
+
+// Message is a message published on an EventBus
+type Message struct {
+	Subject string
+	Data    []byte
+}
+
+type busSubscription struct {
+	bus     *EventBus
+	pattern string
+	handler func(subject string, data []byte)
+}
+
+// NewEventBus returns an empty EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (b *EventBus) Publish(subject string, data []byte) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errBusClosed
+	}
+	data = append([]byte(nil), data...)
+	b.published = append(b.published, Message{Subject: subject, Data: data})
+	var handlers []func(subject string, data []byte)
+	for _, sub := range b.subs {
+		if shared.SubjectMatches(sub.pattern, subject) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(subject, data)
+	}
+	return nil
+}
+
+// Flush has nothing to wait for: messages are delivered as they are published
+func (b *EventBus) Flush(timeout time.Duration) error {
+	return nil
+}
+
+func (b *EventBus) Subscribe(pattern string, handler func(subject string, data []byte)) (shared.Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errBusClosed
+	}
+	sub := &busSubscription{bus: b, pattern: pattern, handler: handler}
+	b.subs = append(b.subs, sub)
+	return sub, nil
+}
+
+func (s *busSubscription) Unsubscribe() error {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (b *EventBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.subs = nil
+	return nil
+}
+
+// Published returns the messages published so far whose subject matches
+// pattern (NATS wildcards), in the order they were published
+func (b *EventBus) Published(pattern string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var messages []Message
+	for _, m := range b.published {
+		if shared.SubjectMatches(pattern, m.Subject) {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,34 @@
+package fixtures
+
+import "testing"
+
+func TestEventBusDeliversToMatchingSubscriptions(t *testing.T) {
+	bus := NewEventBus()
+	var sections, bookings []string
+	sectionSub, _ := bus.Subscribe("seats.main.floor.>", func(subject string, data []byte) {
+		sections = append(sections, string(data))
+	})
+	bus.Subscribe("seats.*.*.*.booked", func(subject string, data []byte) {
+		bookings = append(bookings, subject)
+	})
+
+	bus.Publish("seats.main.floor.A1.held", []byte("1"))
+	bus.Publish("seats.main.balcony.K1.booked", []byte("2"))
+	sectionSub.Unsubscribe()
+	bus.Publish("seats.main.floor.A1.booked", []byte("3"))
+
+	if len(sections) != 1 || sections[0] != "1" {
+		t.Errorf("floor subscription got %v, want [1]", sections)
+	}
+	if len(bookings) != 2 || bookings[1] != "seats.main.floor.A1.booked" {
+		t.Errorf("booking subscription got %v", bookings)
+	}
+	if published := bus.Published("seats.*.floor.>"); len(published) != 2 || string(published[1].Data) != "3" {
+		t.Errorf("published on the floor: %+v", published)
+	}
+
+	bus.Close()
+	if err := bus.Publish("seats.main.floor.A2.held", nil); err == nil {
+		t.Error("published on a closed bus")
+	}
+}
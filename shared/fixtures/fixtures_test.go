@@ -0,0 +1,123 @@
+package fixtures
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// memStore is the least a Store has to do
+type memStore struct {
+	seats map[string]shared.Seat
+	locks map[string]string
+	holds map[string]time.Time
+	sent  []string
+}
+
+func newMemStore(seats []shared.Seat) *memStore {
+	s := &memStore{seats: make(map[string]shared.Seat), locks: make(map[string]string), holds: make(map[string]time.Time)}
+	for _, seat := range seats {
+		s.seats[seat.ID] = seat
+	}
+	return s
+}
+
+func (s *memStore) GetSeat(seatID string) (*shared.Seat, error) {
+	seat, ok := s.seats[seatID]
+	if !ok {
+		return nil, errors.New("seat not found")
+	}
+	return &seat, nil
+}
+
+func (s *memStore) UpdateSeat(seat *shared.Seat, expectedVersion int64, topic string, event []byte) error {
+	if s.seats[seat.ID].Version != expectedVersion {
+		return errors.New("stale")
+	}
+	s.seats[seat.ID] = *seat
+	s.sent = append(s.sent, topic)
+	return nil
+}
+
+func (s *memStore) AcquireLock(seatID, holder string, ttl time.Duration) (bool, error) {
+	if _, ok := s.locks[seatID]; ok {
+		return false, nil
+	}
+	s.locks[seatID] = holder
+	return true, nil
+}
+
+func (s *memStore) IndexHold(seatID string, expiresAt time.Time) error {
+	s.holds[seatID] = expiresAt
+	return nil
+}
+
+func TestVenueLaysOutSectionsAndTiers(t *testing.T) {
+	seats := Venue().
+		Tier("premium", 9000).Section("floor", 1, 2).
+		Tier("upper", 6000).Section("balcony", 2, 1).
+		Seats()
+
+	want := []struct {
+		id, section, tier string
+		price             int64
+	}{
+		{"A1", "floor", "premium", 9000},
+		{"A2", "floor", "premium", 9000},
+		{"B1", "balcony", "upper", 6000},
+		{"C1", "balcony", "upper", 6000},
+	}
+	if len(seats) != len(want) {
+		t.Fatalf("got %d seats, want %d", len(seats), len(want))
+	}
+	for i, w := range want {
+		seat := seats[i]
+		if seat.ID != w.id || seat.Section != w.section || seat.Tier != w.tier || seat.PriceCents != w.price ||
+			seat.Status != shared.SeatAvailable || seat.Version != 1 {
+			t.Errorf("seat %d = %+v, want %s in %s at %s", i, seat, w.id, w.section, w.tier)
+		}
+	}
+}
+
+func TestScenarioWritesTransitions(t *testing.T) {
+	store := newMemStore(Venue().Grid(1, 3).Seats())
+	expiresAt := time.Now().Add(time.Minute)
+	expired := time.Now().Add(-time.Minute)
+
+	var ordered []string
+	scenario := On(store).
+		WithOrders(func(seat shared.Seat, userID string) (string, error) {
+			ordered = append(ordered, seat.ID)
+			return "order-" + seat.ID, nil
+		}).
+		Hold("A1", "alice", expiresAt).
+		Hold("A2", "bob", expired).
+		Book("A3", "carol").
+		MustApply(t)
+
+	a1 := store.seats["A1"]
+	if a1.Status != shared.SeatHeld || a1.HeldBy != "alice" || a1.ExpiresAt != expiresAt.Unix() || a1.Version != 2 {
+		t.Errorf("A1 = %+v, want held by alice until the given expiry", a1)
+	}
+	if store.locks["A1"] != "alice" || !store.holds["A1"].Equal(expiresAt) {
+		t.Error("A1's hold was not locked and indexed")
+	}
+	if _, locked := store.locks["A2"]; locked {
+		t.Error("an already expired hold was locked")
+	}
+	if a3 := store.seats["A3"]; a3.Status != shared.SeatBooked || a3.HeldBy != "carol" {
+		t.Errorf("A3 = %+v, want booked by carol", a3)
+	}
+	if scenario.OrderID("A3") != "order-A3" || len(ordered) != 1 {
+		t.Errorf("orders = %v, want one for A3", ordered)
+	}
+	if want := "seats.main.main.A3.booked"; store.sent[2] != want {
+		t.Errorf("booking published on %q, want %q", store.sent[2], want)
+	}
+
+	if err := On(store).Hold("A1", "dave", expiresAt).Apply(); err == nil {
+		t.Error("held a seat that is already locked")
+	}
+}
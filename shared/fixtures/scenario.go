@@ -0,0 +1,150 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"concert-booking/shared"
+)
+
+// Store is the part of the booking service's SeatStore fixtures write through
+type Store interface {
+	GetSeat(seatID string) (*shared.Seat, error)
+	UpdateSeat(seat *shared.Seat, expectedVersion int64, topic string, event []byte) error
+	AcquireLock(seatID, holder string, ttl time.Duration) (bool, error)
+	IndexHold(seatID string, expiresAt time.Time) error
+}
+
+// OrderFunc records the order for a seat a scenario booked and returns its ID
+type OrderFunc func(seat shared.Seat, userID string) (orderID string, err error)
+
+// Scenario puts seats of an existing venue into the states a test needs. Each
+// change is a real seat transition, with the event and history entry the
+// booking service would record for it.
+type Scenario struct {
+	store    Store
+	orders   OrderFunc
+	steps    []func() error
+	orderIDs map[string]string
+}
+
+// On starts a scenario writing to store
+func On(store Store) *Scenario {
+	return &Scenario{store: store, orderIDs: make(map[string]string)}
+}
+
+// WithOrders makes Book record an order for each seat it books
+func (s *Scenario) WithOrders(create OrderFunc) *Scenario {
+	s.orders = create
+	return s
+}
+
+// Hold has userID hold a seat until expiresAt. A hold that has already expired
+// is left unlocked, as it would be once its lock's TTL ran out, so it is what
+// the expiry sweep picks up.
+func (s *Scenario) Hold(seatID, userID string, expiresAt time.Time) *Scenario {
+	s.steps = append(s.steps, func() error {
+		if ttl := time.Until(expiresAt); ttl > 0 {
+			ok, err := s.store.AcquireLock(seatID, userID, ttl)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("seat %s is already locked", seatID)
+			}
+		}
+		err := s.transition(seatID, userID, "held", func(seat *shared.Seat) {
+			seat.Status = shared.SeatHeld
+			seat.HeldBy = userID
+			seat.ExpiresAt = expiresAt.Unix()
+			seat.HeldAt = time.Now().Unix()
+		})
+		if err != nil {
+			return err
+		}
+		return s.store.IndexHold(seatID, expiresAt)
+	})
+	return s
+}
+
+// Book books a seat for userID, recording its order if WithOrders was given
+func (s *Scenario) Book(seatID, userID string) *Scenario {
+	s.steps = append(s.steps, func() error {
+		var booked shared.Seat
+		err := s.transition(seatID, userID, "booked", func(seat *shared.Seat) {
+			seat.Status = shared.SeatBooked
+			seat.HeldBy = userID
+			seat.ExpiresAt = 0
+			seat.HeldAt = 0
+			booked = *seat
+		})
+		if err != nil || s.orders == nil {
+			return err
+		}
+		orderID, err := s.orders(booked, userID)
+		if err != nil {
+			return fmt.Errorf("order for seat %s: %w", seatID, err)
+		}
+		s.orderIDs[seatID] = orderID
+		return nil
+	})
+	return s
+}
+
+// Apply makes the changes in the order they were added, stopping at the
+// first that fails
+func (s *Scenario) Apply() error {
+	for _, step := range s.steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	s.steps = nil
+	return nil
+}
+
+// MustApply is Apply, failing the test on error
+func (s *Scenario) MustApply(tb testing.TB) *Scenario {
+	tb.Helper()
+	if err := s.Apply(); err != nil {
+		tb.Fatalf("apply fixtures: %v", err)
+	}
+	return s
+}
+
+// OrderID returns the ID of the order recorded when the scenario booked a seat
+func (s *Scenario) OrderID(seatID string) string {
+	return s.orderIDs[seatID]
+}
+
+// transition changes a seat and writes it with the matching seat event
+func (s *Scenario) transition(seatID, userID, eventType string, change func(*shared.Seat)) error {
+	seat, err := s.store.GetSeat(seatID)
+	if err != nil {
+		return fmt.Errorf("seat %s: %w", seatID, err)
+	}
+	expected := seat.Version
+	change(seat)
+	seat.Version = expected + 1
+
+	event, err := json.Marshal(shared.SeatEvent{
+		Type:      eventType,
+		SeatID:    seat.ID,
+		UserID:    userID,
+		Status:    seat.Status,
+		Version:   seat.Version,
+		Timestamp: time.Now(),
+		ExpiresAt: seat.ExpiresAt,
+		Seat:      seat,
+	})
+	if err != nil {
+		return err
+	}
+	topic := shared.SeatSubject(shared.DefaultEventID, seat.Section, seat.ID, eventType)
+	if err := s.store.UpdateSeat(seat, expected, topic, event); err != nil {
+		return fmt.Errorf("seat %s: %w", seatID, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often one invariant may be posted to INVARIANT_ALERT_URL; further
+// violations in the meantime are only logged
+const invariantAlertInterval = time.Minute
+
+// invariantChecker holds the runtime invariant checks. They catch the
+// concurrency bugs race tests miss: a seat held by one user under another's
+// lock, a count gone negative, a message queued for a client already
+// unregistered. They are off unless INVARIANT_CHECKS is set, which suits
+// staging; some checks cost a Redis round trip.
+type invariantChecker struct {
+	enabled    atomic.Bool
+	violations atomic.Int64
+
+	service  string
+	alertURL string
+	client   *http.Client
+
+	mu          sync.Mutex
+	lastAlerted map[string]time.Time
+}
+
+var invariants = &invariantChecker{lastAlerted: make(map[string]time.Time)}
+
+// InvariantAlert is what INVARIANT_ALERT_URL is sent for a violation
+type InvariantAlert struct {
+	Service   string                 `json:"service"`
+	Invariant string                 `json:"invariant"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Time      time.Time              `json:"time"`
+}
+
+// InitInvariantChecks turns the checks on when INVARIANT_CHECKS is true.
+// INVARIANT_ALERT_URL, if set, is POSTed an InvariantAlert for violations, at
+// most once a minute per invariant.
+func InitInvariantChecks(service string) error {
+	enabled := false
+	if env := os.Getenv("INVARIANT_CHECKS"); env != "" {
+		var err error
+		if enabled, err = strconv.ParseBool(env); err != nil {
+			return fmt.Errorf("invalid INVARIANT_CHECKS %q", env)
+		}
+	}
+	alertURL := os.Getenv("INVARIANT_ALERT_URL")
+	if alertURL != "" {
+		u, err := url.Parse(alertURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("INVARIANT_ALERT_URL must be an absolute http(s) URL, got %q", alertURL)
+		}
+	}
+
+	invariants.mu.Lock()
+	invariants.service = service
+	invariants.alertURL = alertURL
+	invariants.client = &http.Client{Timeout: 5 * time.Second}
+	invariants.mu.Unlock()
+	invariants.enabled.Store(enabled)
+	if enabled {
+		slog.Warn("Runtime invariant checks enabled", "alerts", alertURL != "")
+	}
+	return nil
+}
+
+// InvariantChecksEnabled reports whether invariants are checked, for checks
+// that cost something to evaluate
+func InvariantChecksEnabled() bool {
+	return invariants.enabled.Load()
+}
+
+// Invariant records a violation of the named invariant if ok is false and
+// checks are on, with attrs (key-value pairs or slog.Attrs) describing it. It
+// returns ok, so callers can also refuse to go on.
+func Invariant(ok bool, name string, attrs ...any) bool {
+	if ok || !invariants.enabled.Load() {
+		return ok
+	}
+	invariants.violated(name, attrs, time.Now())
+	return ok
+}
+
+// InvariantViolations counts the violations recorded since the process started
+func InvariantViolations() int64 {
+	return invariants.violations.Load()
+}
+
+func (inv *invariantChecker) violated(name string, attrs []any, now time.Time) {
+	inv.violations.Add(1)
+	slog.Error("Invariant violated", append([]any{"invariant", name}, attrs...)...)
+
+	inv.mu.Lock()
+	alertURL, service, client := inv.alertURL, inv.service, inv.client
+	throttled := now.Sub(inv.lastAlerted[name]) < invariantAlertInterval
+	if alertURL != "" && !throttled {
+		inv.lastAlerted[name] = now
+	}
+	inv.mu.Unlock()
+	if alertURL == "" || throttled {
+		return
+	}
+
+	alert := InvariantAlert{Service: service, Invariant: name, Details: attrMap(attrs), Time: now}
+	go func() {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			slog.Warn("Failed to encode invariant alert", ErrAttr(err))
+			return
+		}
+		resp, err := client.Post(alertURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Warn("Failed to send invariant alert", "invariant", name, ErrAttr(err))
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+// attrMap turns slog-style attributes into a map for an alert
+func attrMap(attrs []any) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	details := make(map[string]interface{}, len(attrs))
+	for i := 0; i < len(attrs); i++ {
+		switch attr := attrs[i].(type) {
+		case slog.Attr:
+			details[attr.Key] = attr.Value.String()
+		case string:
+			if i+1 < len(attrs) {
+				details[attr] = fmt.Sprint(attrs[i+1])
+				i++
+			}
+		}
+	}
+	return details
+}
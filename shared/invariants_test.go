@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInvariantViolationsAreCountedAndAlertedOnce(t *testing.T) {
+	var alerts atomic.Int32
+	got := make(chan InvariantAlert, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert InvariantAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts.Add(1)
+		got <- alert
+	}))
+	defer server.Close()
+
+	// Off by default: the result is passed through, but nothing is recorded
+	if Invariant(false, "checks_off") || InvariantViolations() != 0 {
+		t.Fatal("a violation was recorded with checks off")
+	}
+
+	t.Setenv("INVARIANT_CHECKS", "true")
+	t.Setenv("INVARIANT_ALERT_URL", server.URL)
+	if err := InitInvariantChecks("test"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Unsetenv("INVARIANT_CHECKS")
+		InitInvariantChecks("test")
+	})
+
+	before := InvariantViolations()
+	if !Invariant(true, "holds") {
+		t.Error("a holding invariant returned false")
+	}
+	for i := 0; i < 3; i++ {
+		if Invariant(false, "counts_negative", LogKeySeatID, "A1", "available", -1) {
+			t.Error("a violated invariant returned true")
+		}
+	}
+	if n := InvariantViolations() - before; n != 3 {
+		t.Errorf("violations = %d, want 3", n)
+	}
+
+	select {
+	case alert := <-got:
+		if alert.Service != "test" || alert.Invariant != "counts_negative" || alert.Details[LogKeySeatID] != "A1" {
+			t.Errorf("alert = %+v", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert was sent")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := alerts.Load(); n != 1 {
+		t.Errorf("%d alerts sent for one invariant within a minute, want 1", n)
+	}
+
+	t.Setenv("INVARIANT_CHECKS", "sometimes")
+	if err := InitInvariantChecks("test"); err == nil {
+		t.Error("an invalid INVARIANT_CHECKS was accepted")
+	}
+}
+
+func TestSeatCountsConsistent(t *testing.T) {
+	var counts SeatCounts
+	counts.Add(SeatAvailable)
+	counts.Add(SeatHeld)
+	if !counts.Consistent() {
+		t.Errorf("%+v is not consistent", counts)
+	}
+	counts.Move(SeatBooked, SeatAvailable) // a seat that was never counted as booked
+	if counts.Consistent() {
+		t.Errorf("%+v is consistent", counts)
+	}
+}
@@ -0,0 +1,102 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys of structured log records, so both services' logs can be
+// queried the same way
+const (
+	LogKeyService       = "service"
+	LogKeyComponent     = "component"
+	LogKeyClientID      = "client_id"
+	LogKeyUserID        = "user_id"
+	LogKeySeatID        = "seat_id"
+	LogKeyCorrelationID = "correlation_id"
+	LogKeyTraceID       = "trace_id"
+	LogKeyError         = "error"
+)
+
+// HeaderCorrelationID carries a request's correlation ID from the edge to the
+// booking service, as an HTTP header or a NATS message header
+const HeaderCorrelationID = "X-Request-ID"
+
+// InitLogging makes slog's default logger, and through it the log package,
+// write one JSON object per line to stderr, tagged with service. LOG_LEVEL
+// (debug, info, warn or error; default info) sets the threshold and
+// LOG_FORMAT=text switches to key=value lines for reading in a terminal.
+func InitLogging(service string) error {
+	var level slog.Level
+	if env := os.Getenv("LOG_LEVEL"); env != "" {
+		if err := level.UnmarshalText([]byte(env)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q", env)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q (want json or text)", format)
+	}
+
+	slog.SetDefault(slog.New(handler).With(LogKeyService, service))
+	return nil
+}
+
+// Fatal logs msg at error level and exits
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// ErrAttr is the attribute of a failed operation's error
+func ErrAttr(err error) slog.Attr {
+	return slog.Any(LogKeyError, err)
+}
+
+type correlationKey struct{}
+
+// NewCorrelationID returns a random ID for a request entering the system
+func NewCorrelationID() string {
+	return uuid.NewString()
+}
+
+// WithCorrelationID returns ctx carrying a request's correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by ctx, or ""
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// Logger returns the default logger tagged with ctx's correlation ID and, when
+// ctx is traced, its trace ID, so a request's log lines can be found on both
+// services and next to its spans
+func Logger(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := CorrelationID(ctx); id != "" {
+		logger = logger.With(LogKeyCorrelationID, id)
+	}
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		logger = logger.With(LogKeyTraceID, spanCtx.TraceID().String())
+	}
+	return logger
+}
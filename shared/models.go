@@ -1,22 +1,23 @@
 package shared
 
-import "time"
-
-// Seat statuses
-const (
-	SeatAvailable = 0
-	SeatHeld      = 1
-	SeatBooked    = 2
+import (
+	"encoding/json"
+	"time"
 )
 
 // Seat represents a single seat in the venue
 type Seat struct {
-	ID        string `json:"id"`
-	Row       int    `json:"row"`
-	Col       int    `json:"col"`
-	Status    int    `json:"status"`
-	HeldBy    string `json:"held_by,omitempty"`
-	ExpiresAt int64  `json:"expires_at,omitempty"`
+	ID         string     `json:"id"`
+	Row        int        `json:"row"`
+	Col        int        `json:"col"`
+	Section    string     `json:"section,omitempty"`
+	Tier       string     `json:"tier,omitempty"`
+	PriceCents int64      `json:"price_cents,omitempty"`
+	Status     SeatStatus `json:"status"`
+	HeldBy     string     `json:"held_by,omitempty"`
+	ExpiresAt  int64      `json:"expires_at,omitempty"`
+	HeldAt     int64      `json:"held_at,omitempty"` // when the current hold was taken, Unix seconds
+	Version    int64      `json:"version"`           // bumped on every transition
 }
 
 // Message types for WebSocket communication
@@ -29,43 +30,362 @@ const (
 	MessageTypeVenueState  = "VENUE_STATE"
 	MessageTypeSubscribe   = "SUBSCRIBE"
 	MessageTypeError       = "ERROR"
+	MessageTypeHoldExpiring = "HOLD_EXPIRING"
+	MessageTypeBookingConfirmed = "BOOKING_CONFIRMED"
+	MessageTypeTokenRefresh     = "TOKEN_REFRESH"
+	MessageTypePaymentPending   = "PAYMENT_PENDING"
+	MessageTypePaymentSucceeded = "PAYMENT_SUCCEEDED"
+	MessageTypePaymentFailed    = "PAYMENT_FAILED"
+	MessageTypeVenueSnapshot    = "VENUE_SNAPSHOT"
+	MessageTypeWaitingRoom      = "WAITING_ROOM"
+	MessageTypeSeatUpdateBatch  = "SEAT_UPDATE_BATCH"
+	MessageTypeGetSeat          = "GET_SEAT"
+	MessageTypeVenueStateChunk  = "VENUE_STATE_CHUNK"
+	MessageTypeVenueStateBitmask = "VENUE_STATE_BITMASK"
+	MessageTypeEventCancelled   = "EVENT_CANCELLED"
+	MessageTypeRefundSucceeded  = "REFUND_SUCCEEDED"
+	MessageTypeRefundFailed     = "REFUND_FAILED"
+	MessageTypeAnnouncement     = "ANNOUNCEMENT"
+	MessageTypeSync             = "SYNC"
+	MessageTypeHoldKeepalive    = "HOLD_KEEPALIVE"
+	MessageTypeJoin             = "JOIN"
+	MessageTypeLeave            = "LEAVE"
+	MessageTypeViewers          = "VIEWERS"
+	MessageTypeFocusSeat        = "FOCUS_SEAT"
+	MessageTypeKickUser         = "KICK_USER"
 )
 
 // ClientMessage represents a message from the browser to the server
 type ClientMessage struct {
-	Type string                 `json:"type"`
-	Data map[string]interface{} `json:"data"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"` // decoded with DecodePayload into the type's payload
+
+	// RequestID is echoed in the response so clients can match responses to
+	// requests
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // ServerMessage represents a message from the server to the browser
 type ServerMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+
+	// RequestID echoes the request_id of the client message this responds to
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // SeatRequest represents a request to select, book, or release a seat
 type SeatRequest struct {
-	SeatID string `json:"seat_id"`
-	UserID string `json:"user_id"`
+	SeatID  string `json:"seat_id"`
+	UserID  string `json:"user_id"`
+	Version int64  `json:"version,omitempty"` // optional: reject if the seat has moved past this version
+
+	IdempotencyKey string `json:"-"` // sent as the Idempotency-Key header
+}
+
+// SeatBatchGetRequest asks for several seats at once
+type SeatBatchGetRequest struct {
+	SeatIDs []string `json:"seat_ids"`
+}
+
+// SeatBatchGetResponse holds the seats found, in the order asked for, and the
+// IDs that matched no seat
+type SeatBatchGetResponse struct {
+	Seats    []Seat   `json:"seats"`
+	NotFound []string `json:"not_found,omitempty"`
+	Seq      int64    `json:"seq"` // seat event sequence number the seats reflect
 }
 
 // SeatEvent represents an event for NATS pub/sub
 type SeatEvent struct {
-	Type      string    `json:"type"`      // held, released, booked, auto_released
-	SeatID    string    `json:"seat_id"`
-	UserID    string    `json:"user_id"`
-	Status    int       `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-	ExpiresAt int64     `json:"expires_at,omitempty"`
-	Seat      *Seat     `json:"seat,omitempty"` // Full seat data for venue state updates
+	Type      string     `json:"type"`          // held, released, booked, auto_released, allocated, returned, released_batch
+	Seq       int64      `json:"seq,omitempty"` // venue-wide event sequence number
+	SeatID    string     `json:"seat_id"`
+	UserID    string     `json:"user_id"`
+	Actor     string     `json:"actor,omitempty"`  // who caused the transition, when not UserID
+	Source    string     `json:"source,omitempty"` // how it came in: api, nats, expiry, admin, drift or restore
+	Status    SeatStatus `json:"status"`
+	Version   int64      `json:"version"` // seat version after this transition
+	Timestamp time.Time  `json:"timestamp"`
+	ExpiresAt int64      `json:"expires_at,omitempty"`
+	Seat      *Seat      `json:"seat,omitempty"` // Full seat data for venue state updates
+
+	// EventID is the event the seat belongs to; consumers read it from the
+	// subject the event came on when it is not set
+	EventID string `json:"event_id,omitempty"`
+
+	// Notification is the message of a user_notification event, for users
+	// who prefer their notifications by webhook
+	Notification *DirectedMessage `json:"notification,omitempty"`
+
+	// Events are the releases a released_batch event groups, in sequence
+	// order; its Seq is that of the first
+	Events []SeatEvent `json:"events,omitempty"`
 }
 
 // VenueState represents the complete state of all seats
 type VenueState struct {
-	Seats []Seat `json:"seats"`
+	Seats []Seat     `json:"seats"`
+	Event *EventInfo `json:"event,omitempty"` // branding for the seat map, if configured
+
+	// Flags are the feature flags that are set, for clients to gate features on
+	Flags FeatureFlags `json:"flags,omitempty"`
+
+	// Degraded marks seats served from the edge's cache while the booking
+	// service is unreachable; seat commands fail until it is back
+	Degraded bool `json:"degraded,omitempty"`
+
+	// Layout is VenueLayoutID of the seats, for clients to ask for
+	// VENUE_STATE_BITMASK with next time
+	Layout string `json:"layout,omitempty"`
+
+	// VenueVersion is the seat event sequence number the seats reflect, for
+	// clients to SYNC from after reconnecting; omitted when degraded
+	VenueVersion int64 `json:"venue_version,omitempty"`
+}
+
+// VenueDelta is the data of a SYNC response: the seats changed after
+// FromVersion, which bring the client to VenueVersion. Full is set instead
+// when the edge no longer knows what changed and sends a VENUE_STATE next.
+type VenueDelta struct {
+	FromVersion  int64  `json:"from_version"`
+	VenueVersion int64  `json:"venue_version,omitempty"`
+	Seats        []Seat `json:"seats,omitempty"`
+	Full         bool   `json:"full,omitempty"`
+}
+
+// VenueStateChunk is the data of VENUE_STATE_CHUNK, one part of a VENUE_STATE
+// split for clients that subscribed with chunked set. Each chunk holds seats of
+// one section; the first also carries the event and flags. The state is
+// complete once chunks 0 to Chunks-1 have arrived.
+type VenueStateChunk struct {
+	VenueState
+	Section string `json:"section"`
+	Chunk   int    `json:"chunk"`
+	Chunks  int    `json:"chunks"`
+}
+
+// CompactVenueState is the data of VENUE_SNAPSHOT, a compact VENUE_STATE: only
+// the changing fields of every seat, for clients that already have the layout
+type CompactVenueState struct {
+	Seq    int64         `json:"seq"`    // seat event sequence number the snapshot reflects
+	Reason string        `json:"reason"` // why it was sent, e.g. idle
+	Seats  []CompactSeat `json:"seats"`
+}
+
+// CompactSeat is a seat's state without its layout
+type CompactSeat struct {
+	ID        string     `json:"id"`
+	Status    SeatStatus `json:"status"`
+	HeldBy    string     `json:"held_by,omitempty"`
+	ExpiresAt int64      `json:"expires_at,omitempty"`
+	Version   int64      `json:"version"`
+}
+
+// EventInfo is the organizer and event metadata client apps use to brand the
+// seat map
+type EventInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Organizer string `json:"organizer,omitempty"`
+	ImageURL  string `json:"image_url,omitempty"`
+	Currency  string `json:"currency"` // ISO 4217 code seat prices are in
+	Timezone  string `json:"timezone"` // IANA zone the event takes place in
+
+	// The sales window, in Unix seconds; 0 leaves that end open
+	SalesOpenAt  int64 `json:"sales_open_at,omitempty"`
+	SalesCloseAt int64 `json:"sales_close_at,omitempty"`
+
+	// CancelledAt is when the event was called off, in Unix seconds; 0 while it is on
+	CancelledAt int64 `json:"cancelled_at,omitempty"`
+
+	// Fees is what is charged on top of each ticket's price at checkout
+	Fees *FeeSchedule `json:"fees,omitempty"`
+
+	// HoldKeepalive, when set, replaces the fixed hold duration: holds last as
+	// long as their holder keeps pinging, up to a hard maximum
+	HoldKeepalive *HoldKeepalive `json:"hold_keepalive,omitempty"`
+}
+
+// HoldKeepalive configures holds kept alive by their holder's activity
+type HoldKeepalive struct {
+	IdleSeconds int64 `json:"idle_seconds"` // how long a hold outlives the holder's last ping
+	MaxSeconds  int64 `json:"max_seconds"`  // how long a hold can last in all, from when it was taken
+}
+
+// FeeSchedule configures the fees and taxes added to every ticket. Rates are
+// in basis points: 1000 is 10%.
+type FeeSchedule struct {
+	FacilityFeeCents int64     `json:"facility_fee_cents,omitempty"` // flat, per ticket
+	ServiceChargeBps int64     `json:"service_charge_bps,omitempty"` // share of the ticket price
+	Taxes            []TaxRate `json:"taxes,omitempty"`              // each on the ticket price plus fees
+}
+
+// TaxRate is one tax levied on tickets, e.g. VAT
+type TaxRate struct {
+	Name    string `json:"name"`
+	RateBps int64  `json:"rate_bps"`
 }
 
 // ErrorResponse represents an error message
 type ErrorResponse struct {
-	Error string `json:"error"`
-}
\ No newline at end of file
+	Code  ErrorCode `json:"code,omitempty"`
+	Error string    `json:"error"`
+}
+
+// EdgeHeartbeat is periodically published by every edge server so edges (and the
+// clients asking them) can discover each other
+type EdgeHeartbeat struct {
+	ID          string    `json:"id"`
+	PublicURL   string    `json:"public_url,omitempty"` // WebSocket URL clients should connect to
+	Region      string    `json:"region"`
+	Clients     int       `json:"clients"`
+	NATSHealthy bool      `json:"nats_healthy"`
+	Timestamp   time.Time `json:"timestamp"`
+
+	Events map[string]EventConnStats `json:"events,omitempty"` // connections by event
+
+	Viewers map[string]ViewerCounts `json:"viewers,omitempty"` // clients following each event
+}
+
+// ViewerCounts is how many clients follow an event, and how many of those that
+// subscribed to part of its venue look at each section
+type ViewerCounts struct {
+	Viewers  int            `json:"viewers"`
+	Sections map[string]int `json:"sections,omitempty"`
+}
+
+// EventConnStats is one event's share of an edge's connections, with the
+// fairness counters of its waiting room
+type EventConnStats struct {
+	Connected     int   `json:"connected"`
+	Cap           int   `json:"cap,omitempty"`   // 0 when uncapped
+	Waiting       int   `json:"waiting"`         // in the waiting room now
+	Queued        int64 `json:"queued"`          // sent to the waiting room so far
+	Admitted      int64 `json:"admitted"`        // let in from the waiting room so far
+	Abandoned     int64 `json:"abandoned"`       // left the waiting room before a slot freed
+	Readmitted    int64 `json:"readmitted"`      // came back with an admission token and went first
+	LongestWaitMs int64 `json:"longest_wait_ms"` // longest wait of anyone admitted
+}
+
+// UserPresence announces that a user connected to or left an edge server
+type UserPresence struct {
+	UserID    string    `json:"user_id"`
+	EdgeID    string    `json:"edge_id"`
+	Online    bool      `json:"online"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DirectedMessage is a WebSocket message for one user, sent to the inbox of each
+// edge hosting them
+type DirectedMessage struct {
+	UserID string      `json:"user_id"`
+	Type   string      `json:"type"`
+	Data   interface{} `json:"data"`
+}
+
+// Notification channels a user can prefer for messages meant only for them
+const (
+	NotificationChannelWebSocket = "websocket" // pushed to their connected clients
+	NotificationChannelWebhook   = "webhook"   // sent to the webhooks as user_notification events
+)
+
+// NotificationPrefs are one user's notification preferences
+type NotificationPrefs struct {
+	HoldExpiryWarnings bool   `json:"hold_expiry_warnings"` // HOLD_EXPIRING before a hold lapses
+	Marketing          bool   `json:"marketing"`            // marketing announcements
+	Channel            string `json:"channel"`              // websocket or webhook
+}
+
+// DefaultNotificationPrefs are the preferences of users who never set any
+func DefaultNotificationPrefs() NotificationPrefs {
+	return NotificationPrefs{HoldExpiryWarnings: true, Marketing: true, Channel: NotificationChannelWebSocket}
+}
+
+// Announcement is a message for every connected client, published to every
+// edge. Marketing announcements name the users who opted out of them.
+type Announcement struct {
+	Message      string    `json:"message"`
+	Marketing    bool      `json:"marketing,omitempty"`
+	ExcludeUsers []string  `json:"exclude_users,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// SeatFocus is a client looking at a seat, published to every edge so the
+// event's other clients can show it. It never touches the seat itself.
+type SeatFocus struct {
+	EdgeID         string `json:"edge_id"` // the edge the client is on, which has already delivered it
+	EventID        string `json:"event_id"`
+	SeatID         string `json:"seat_id"`                    // "" once the client looks away
+	PreviousSeatID string `json:"previous_seat_id,omitempty"` // the seat it looked at before, if any
+}
+
+// DebugTrace asks every edge to log the full message traffic of one user's
+// connections, or of one connection, until Until. A zero Until stops tracing.
+type DebugTrace struct {
+	UserID   string    `json:"user_id,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	Until    time.Time `json:"until"`
+}
+
+// KickUser asks every edge to disconnect all of a user's connections, with
+// close code CloseCodeKicked and Reason
+type KickUser struct {
+	UserID    string    `json:"user_id"`
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SeatCommand is the request payload of the seats.cmd.select/book/release subjects
+type SeatCommand struct {
+	SeatID         string `json:"seat_id"`
+	UserID         string `json:"user_id"`
+	Version        int64  `json:"version,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// SeatCommandReply answers a SeatCommand; Error is set if the command failed
+type SeatCommandReply struct {
+	Message string `json:"message,omitempty"`
+	OrderID   string    `json:"order_id,omitempty"`
+	ExpiresAt int64     `json:"expires_at,omitempty"` // of a hold kept alive, Unix seconds
+	Error     string    `json:"error,omitempty"`
+	Code      ErrorCode `json:"code,omitempty"` // set with Error
+}
+
+// VenueSnapshotReply answers seats.cmd.snapshot with every seat and the event
+// sequence number they reflect
+type VenueSnapshotReply struct {
+	Seats []Seat `json:"seats,omitempty"`
+	Seq   int64  `json:"seq"`
+	Error string `json:"error,omitempty"`
+}
+
+// VenueChanges is the response of GET /api/seats/changes: the seats changed
+// after the sequence number Since, as they were at Seq
+type VenueChanges struct {
+	Since int64  `json:"since"`
+	Seq   int64  `json:"seq"`
+	Seats []Seat `json:"seats"`
+}
+
+// VenueChangesReply answers seats.cmd.changes; Gone is set when the changes
+// are no longer kept and the whole venue must be fetched instead
+type VenueChangesReply struct {
+	Changes *VenueChanges `json:"changes,omitempty"`
+	Gone    bool          `json:"gone,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// EventInfoReply answers seats.cmd.event with the event's metadata
+type EventInfoReply struct {
+	Event *EventInfo `json:"event,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// SeatReply answers seats.cmd.seat with one seat
+type SeatReply struct {
+	Seat     *Seat  `json:"seat,omitempty"`
+	NotFound bool   `json:"not_found,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
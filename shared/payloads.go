@@ -0,0 +1,245 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Payload is the data of a client message of one type. Validate reports
+// what is missing or out of range, naming the field.
+type Payload interface {
+	Validate() error
+}
+
+// DecodePayload strictly decodes the data of a client message into p and
+// validates it. Unknown fields and values of the wrong type are errors naming
+// the field, rather than being dropped or read as zero values; missing data
+// decodes as an empty object.
+func DecodePayload(data json.RawMessage, p Payload) error {
+	if len(bytes.TrimSpace(data)) == 0 || bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		data = json.RawMessage("{}")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return payloadError(err)
+	}
+	return p.Validate()
+}
+
+// payloadError rewords a decoding error for the client
+func payloadError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return errors.New("data must be an object")
+		}
+		return fmt.Errorf("%s must be %s, got %s", fieldName(typeErr.Field), jsonKind(typeErr.Type), typeErr.Value)
+	}
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		return errors.New(strings.TrimPrefix(msg, "json: "))
+	}
+	return fmt.Errorf("data is not valid JSON: %w", err)
+}
+
+// fieldName is the dotted path of a field, without the list indexes some Go
+// versions add: "filter.rows" for a bad entry of filter.rows
+func fieldName(path string) string {
+	parts := strings.Split(path, ".")
+	named := parts[:0]
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			named = append(named, part)
+		}
+	}
+	return strings.Join(named, ".")
+}
+
+// jsonKind describes what JSON a Go type decodes from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Ptr:
+		return jsonKind(t.Elem())
+	default:
+		return "an object"
+	}
+}
+
+// SubscribePayload is the data of a SUBSCRIBE
+type SubscribePayload struct {
+	UserID string             `json:"user_id,omitempty"`
+	Filter *SeatFilterPayload `json:"filter,omitempty"`
+
+	// How VENUE_STATE is sent: in chunks, or as a bitmask over a layout the
+	// client already has
+	Chunked bool   `json:"chunked,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Layout  string `json:"layout,omitempty"`
+
+	// Set by a reconnecting client whose missed seat updates were replayed,
+	// or to the venue version it last saw to get only what changed since
+	Resumed      bool   `json:"resumed,omitempty"`
+	VenueVersion *int64 `json:"venue_version,omitempty"`
+
+	// The newest message format the client speaks, if it did not say when
+	// connecting (see NegotiateProtocolVersion)
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+}
+
+func (p *SubscribePayload) Validate() error {
+	if p.Format != "" && p.Format != VenueFormatBitmask {
+		return fmt.Errorf("format must be %q, got %q", VenueFormatBitmask, p.Format)
+	}
+	if p.VenueVersion != nil && *p.VenueVersion < 0 {
+		return errors.New("venue_version must not be negative")
+	}
+	if p.Filter != nil {
+		return p.Filter.Validate()
+	}
+	return nil
+}
+
+// SeatFilterPayload limits the seat updates a subscriber gets to the listed
+// sections, rows (zero-based, as in Seat.Row) and seats
+type SeatFilterPayload struct {
+	Sections []string `json:"sections,omitempty"`
+	Rows     []int    `json:"rows,omitempty"`
+	SeatIDs  []string `json:"seat_ids,omitempty"`
+}
+
+func (p *SeatFilterPayload) Validate() error {
+	for _, row := range p.Rows {
+		if row < 0 {
+			return fmt.Errorf("filter.rows must be row numbers, got %d", row)
+		}
+	}
+	for _, s := range p.Sections {
+		if s == "" {
+			return errors.New("filter.sections must not list an empty section")
+		}
+	}
+	for _, s := range p.SeatIDs {
+		if s == "" {
+			return errors.New("filter.seat_ids must not list an empty seat ID")
+		}
+	}
+	return nil
+}
+
+// SeatPayload is the data of a BOOK_SEAT, RELEASE_SEAT or HOLD_KEEPALIVE.
+// UserID may be left out to act as the subscribed user.
+type SeatPayload struct {
+	SeatID  string `json:"seat_id"`
+	UserID  string `json:"user_id,omitempty"`
+	Version int64  `json:"version,omitempty"` // the seat version the client last saw
+
+	// RequestID is attached to a retried message so the booking service
+	// applies it once
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (p *SeatPayload) Validate() error {
+	if p.SeatID == "" {
+		return errors.New("seat_id is required")
+	}
+	if p.Version < 0 {
+		return errors.New("version must not be negative")
+	}
+	return nil
+}
+
+// SelectSeatPayload is the data of a SELECT_SEAT
+type SelectSeatPayload struct {
+	SeatPayload
+	ChallengeToken string `json:"challenge_token,omitempty"` // for the connection's first SELECT_SEAT
+}
+
+// GetSeatPayload is the data of a GET_SEAT
+type GetSeatPayload struct {
+	SeatID string `json:"seat_id"`
+}
+
+func (p *GetSeatPayload) Validate() error {
+	if p.SeatID == "" {
+		return errors.New("seat_id is required")
+	}
+	return nil
+}
+
+// TokenRefreshPayload is the data of a TOKEN_REFRESH
+type TokenRefreshPayload struct {
+	Token string `json:"token"`
+}
+
+func (p *TokenRefreshPayload) Validate() error {
+	if p.Token == "" {
+		return errors.New("token is required")
+	}
+	return nil
+}
+
+// SyncPayload is the data of a SYNC
+type SyncPayload struct {
+	VenueVersion *int64 `json:"venue_version"`
+}
+
+func (p *SyncPayload) Validate() error {
+	if p.VenueVersion == nil {
+		return errors.New("venue_version is required")
+	}
+	if *p.VenueVersion < 0 {
+		return errors.New("venue_version must not be negative")
+	}
+	return nil
+}
+
+// RoomPayload is the data of a JOIN or LEAVE
+type RoomPayload struct {
+	EventID string `json:"event_id"`
+}
+
+func (p *RoomPayload) Validate() error {
+	if p.EventID == "" {
+		return errors.New("event_id is required")
+	}
+	return nil
+}
+
+// FocusSeatPayload is the data of a FOCUS_SEAT; an empty SeatID clears the
+// client's focus
+type FocusSeatPayload struct {
+	SeatID string `json:"seat_id"`
+}
+
+func (p *FocusSeatPayload) Validate() error {
+	return nil
+}
+
+// KickUserPayload is the data of a KICK_USER
+type KickUserPayload struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (p *KickUserPayload) Validate() error {
+	if p.UserID == "" {
+		return errors.New("user_id is required")
+	}
+	return nil
+}
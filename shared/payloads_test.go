@@ -0,0 +1,46 @@
+package shared
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodePayloadNamesTheOffendingField(t *testing.T) {
+	for _, tc := range []struct {
+		data    string
+		payload Payload
+		want    string
+	}{
+		{`{"seat_id": 5}`, &SelectSeatPayload{}, "seat_id must be a string, got number"},
+		{`{"seat_id": "A1", "version": 1.5}`, &SeatPayload{}, "version must be an integer, got number 1.5"},
+		{`{"seat_id": "A1", "seatId": "A2"}`, &SeatPayload{}, `unknown field "seatId"`},
+		{`{"user_id": "alice"}`, &SeatPayload{}, "seat_id is required"},
+		{`{"filter": {"rows": ["front"]}}`, &SubscribePayload{}, "filter.rows must be an integer, got string"},
+		{`{"format": "png"}`, &SubscribePayload{}, `format must be "bitmask", got "png"`},
+		{`{"venue_version": -1}`, &SyncPayload{}, "venue_version must not be negative"},
+		{`["A1"]`, &GetSeatPayload{}, "data must be an object"},
+		{``, &KickUserPayload{}, "user_id is required"},
+	} {
+		err := DecodePayload(json.RawMessage(tc.data), tc.payload)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%T from %s = %v, want %q", tc.payload, tc.data, err, tc.want)
+		}
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	var seat SelectSeatPayload
+	err := DecodePayload(json.RawMessage(`{"seat_id": "A1", "user_id": "alice", "version": 3, "request_id": "r1", "challenge_token": "solved"}`), &seat)
+	if err != nil {
+		t.Fatalf("DecodePayload: %v", err)
+	}
+	want := SelectSeatPayload{SeatPayload: SeatPayload{SeatID: "A1", UserID: "alice", Version: 3, RequestID: "r1"}, ChallengeToken: "solved"}
+	if seat != want {
+		t.Errorf("decoded %+v, want %+v", seat, want)
+	}
+
+	var focus FocusSeatPayload
+	if err := DecodePayload(nil, &focus); err != nil || focus.SeatID != "" {
+		t.Errorf("FOCUS_SEAT without data = %+v, %v, want no focus", focus, err)
+	}
+}
@@ -0,0 +1,29 @@
+package shared
+
+import "fmt"
+
+// Versions of the WebSocket message format edges speak. A change clients
+// written for an older version would misread gets a new ProtocolVersion, and
+// edges keep speaking the older ones, down to ProtocolVersionMin, until their
+// clients are gone.
+const (
+	ProtocolVersionMin = 1
+	ProtocolVersion    = 1
+)
+
+// NegotiateProtocolVersion returns the version to speak with a client that
+// asked for requested, the newest it knows. Clients that ask for none were
+// written before versioning and get the oldest version; clients newer than
+// the edge get the newest it speaks. Only a client older than
+// ProtocolVersionMin cannot be spoken to.
+func NegotiateProtocolVersion(requested int) (int, error) {
+	switch {
+	case requested == 0:
+		return ProtocolVersionMin, nil
+	case requested < ProtocolVersionMin:
+		return 0, fmt.Errorf("protocol_version %d is not supported; versions %d to %d are", requested, ProtocolVersionMin, ProtocolVersion)
+	case requested > ProtocolVersion:
+		return ProtocolVersion, nil
+	}
+	return requested, nil
+}
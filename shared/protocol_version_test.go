@@ -0,0 +1,23 @@
+package shared
+
+import "testing"
+
+func TestNegotiateProtocolVersion(t *testing.T) {
+	tests := []struct {
+		requested int
+		want      int
+		ok        bool
+	}{
+		{0, ProtocolVersionMin, true},
+		{ProtocolVersionMin, ProtocolVersionMin, true},
+		{ProtocolVersion, ProtocolVersion, true},
+		{ProtocolVersion + 1, ProtocolVersion, true},
+		{-1, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := NegotiateProtocolVersion(tt.requested)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("NegotiateProtocolVersion(%d) = %d, %v; want %d, ok %v", tt.requested, got, err, tt.want, tt.ok)
+		}
+	}
+}
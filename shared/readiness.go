@@ -0,0 +1,91 @@
+package shared
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	errNotYet   = errors.New("not yet")
+	errDraining = errors.New("shutting down")
+)
+
+// Readiness decides whether a service should be sent traffic. Unlike liveness,
+// which only says the process is running, a service is ready once every
+// startup gate has opened and while every check passes.
+type Readiness struct {
+	mu     sync.Mutex
+	names  []string
+	checks map[string]func() error
+}
+
+// ReadinessReport is the body of a service's /ready response
+type ReadinessReport struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"` // check name -> "ok" or why it failed
+}
+
+// NewReadiness returns a Readiness with no checks, which is ready
+func NewReadiness() *Readiness {
+	return &Readiness{checks: make(map[string]func() error)}
+}
+
+// Check adds a condition evaluated on every readiness probe, such as a
+// dependency still being connected
+func (r *Readiness) Check(name string, check func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.checks[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.checks[name] = check
+}
+
+// Gate adds a check that fails until the returned function is called, for a
+// startup step such as a subscription that only has to happen once
+func (r *Readiness) Gate(name string) (open func()) {
+	var mu sync.Mutex
+	opened := false
+	r.Check(name, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !opened {
+			return errNotYet
+		}
+		return nil
+	})
+	return func() {
+		mu.Lock()
+		opened = true
+		mu.Unlock()
+	}
+}
+
+// Drain makes the service unready for good, so load balancers stop sending it
+// traffic while it shuts down
+func (r *Readiness) Drain() {
+	r.Check("shutdown", func() error { return errDraining })
+}
+
+// Report runs every check
+func (r *Readiness) Report() ReadinessReport {
+	r.mu.Lock()
+	names := append([]string(nil), r.names...)
+	checks := make([]func() error, len(names))
+	for i, name := range names {
+		checks[i] = r.checks[name]
+	}
+	r.mu.Unlock()
+
+	report := ReadinessReport{Ready: true, Checks: make(map[string]string, len(names))}
+	for i, name := range names {
+		if err := checks[i](); err != nil {
+			report.Ready = false
+			report.Checks[name] = err.Error()
+			continue
+		}
+		report.Checks[name] = "ok"
+	}
+	return report
+}
@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadinessWaitsForGatesAndChecks(t *testing.T) {
+	r := NewReadiness()
+	if report := r.Report(); !report.Ready {
+		t.Fatalf("readiness with no checks = %+v, want ready", report)
+	}
+
+	open := r.Gate("subscriptions")
+	var depErr error
+	r.Check("redis", func() error { return depErr })
+
+	report := r.Report()
+	if report.Ready || report.Checks["subscriptions"] != "not yet" || report.Checks["redis"] != "ok" {
+		t.Fatalf("before the gate opens = %+v, want not ready on subscriptions", report)
+	}
+
+	open()
+	if report := r.Report(); !report.Ready {
+		t.Fatalf("after the gate opens = %+v, want ready", report)
+	}
+
+	depErr = errors.New("connection refused")
+	report = r.Report()
+	if report.Ready || report.Checks["redis"] != "connection refused" || report.Checks["subscriptions"] != "ok" {
+		t.Fatalf("with redis down = %+v, want not ready on redis", report)
+	}
+}
+
+func TestDrainedReadinessStaysUnready(t *testing.T) {
+	r := NewReadiness()
+	r.Drain()
+
+	report := r.Report()
+	if report.Ready || report.Checks["shutdown"] != "shutting down" {
+		t.Fatalf("after Drain = %+v, want not ready on shutdown", report)
+	}
+}
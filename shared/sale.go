@@ -0,0 +1,26 @@
+package shared
+
+import "time"
+
+// Sale states, from EventInfo.SaleState
+const (
+	SaleUpcoming  = "upcoming" // before SalesOpenAt
+	SaleOpen      = "open"
+	SaleClosed    = "closed"    // from SalesCloseAt on
+	SaleCancelled = "cancelled" // the event was called off
+)
+
+// SaleState reports whether tickets are on sale at now. An event without a
+// sales window is always open.
+func (e *EventInfo) SaleState(now time.Time) string {
+	switch {
+	case e.CancelledAt != 0:
+		return SaleCancelled
+	case e.SalesOpenAt != 0 && now.Unix() < e.SalesOpenAt:
+		return SaleUpcoming
+	case e.SalesCloseAt != 0 && now.Unix() >= e.SalesCloseAt:
+		return SaleClosed
+	default:
+		return SaleOpen
+	}
+}
@@ -0,0 +1,32 @@
+package shared
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaleState(t *testing.T) {
+	opens := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	event := EventInfo{SalesOpenAt: opens.Unix(), SalesCloseAt: opens.Add(48 * time.Hour).Unix()}
+
+	for _, tt := range []struct {
+		at   time.Time
+		want string
+	}{
+		{opens.Add(-time.Second), SaleUpcoming},
+		{opens, SaleOpen},
+		{opens.Add(48*time.Hour - time.Second), SaleOpen},
+		{opens.Add(48 * time.Hour), SaleClosed},
+	} {
+		if got := event.SaleState(tt.at); got != tt.want {
+			t.Errorf("SaleState(%s) = %q, want %q", tt.at, got, tt.want)
+		}
+	}
+	if got := (&EventInfo{}).SaleState(opens); got != SaleOpen {
+		t.Errorf("without a sales window SaleState = %q, want open", got)
+	}
+	event.CancelledAt = opens.Unix()
+	if got := event.SaleState(opens.Add(time.Hour)); got != SaleCancelled {
+		t.Errorf("after cancellation SaleState = %q, want cancelled", got)
+	}
+}
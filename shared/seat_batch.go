@@ -0,0 +1,33 @@
+package shared
+
+// SeatEventReleasedBatch is the type of an event grouping releases that
+// happened within the booking service's release batching window
+const SeatEventReleasedBatch = "released_batch"
+
+// SeatEventCoalesced is the type of a batch an edge makes of the seat events
+// that arrived within its coalescing window, keeping the latest for each seat
+const SeatEventCoalesced = "coalesced"
+
+// SeatBatchSubject returns the subject released_batch events for an event are
+// published on. It matches NATSTopicAllSeats but no section or seat filter.
+func SeatBatchSubject(eventID string) string {
+	return SeatSubject(eventID, "", "", SeatEventReleasedBatch)
+}
+
+// LastSeq returns the sequence number of the last transition e carries: its
+// own for a single event, that of its last member for a batch
+func (e SeatEvent) LastSeq() int64 {
+	if len(e.Events) > 0 {
+		return e.Events[len(e.Events)-1].Seq
+	}
+	return e.Seq
+}
+
+// Unbatch returns the individual transitions in e, for consumers that handle
+// seats one at a time
+func (e SeatEvent) Unbatch() []SeatEvent {
+	if len(e.Events) == 0 {
+		return []SeatEvent{e}
+	}
+	return e.Events
+}
@@ -0,0 +1,117 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SeatStatus is where a seat is in its lifecycle. It is sent as a string
+// ("available", "held", "booked"); the integers older clients and stored seats
+// use are still accepted when decoding.
+type SeatStatus int
+
+// Seat statuses
+const (
+	SeatAvailable SeatStatus = 0
+	SeatHeld      SeatStatus = 1
+	SeatBooked    SeatStatus = 2
+)
+
+var seatStatusNames = map[SeatStatus]string{
+	SeatAvailable: "available",
+	SeatHeld:      "held",
+	SeatBooked:    "booked",
+}
+
+// String returns the status name, or "unknown(n)" for an out-of-range value
+func (s SeatStatus) String() string {
+	if name, ok := seatStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// Valid reports whether s is one of the known statuses
+func (s SeatStatus) Valid() bool {
+	_, ok := seatStatusNames[s]
+	return ok
+}
+
+// ParseSeatStatus returns the status with the given name
+func ParseSeatStatus(name string) (SeatStatus, error) {
+	for status, statusName := range seatStatusNames {
+		if statusName == name {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown seat status %q", name)
+}
+
+// MarshalJSON encodes the status as its name
+func (s SeatStatus) MarshalJSON() ([]byte, error) {
+	if !s.Valid() {
+		return nil, fmt.Errorf("invalid seat status %d", int(s))
+	}
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accepts a status name or its legacy integer value
+func (s *SeatStatus) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		status, err := ParseSeatStatus(name)
+		if err != nil {
+			return err
+		}
+		*s = status
+		return nil
+	}
+
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("seat status must be a string or integer: %s", data)
+	}
+	status := SeatStatus(n)
+	if !status.Valid() {
+		return fmt.Errorf("unknown seat status %d", n)
+	}
+	*s = status
+	return nil
+}
+
+// SeatCounts is how many seats are in each status
+type SeatCounts struct {
+	Total     int `json:"total"`
+	Available int `json:"available"`
+	Held      int `json:"held"`
+	Booked    int `json:"booked"`
+}
+
+// Add counts one more seat in status
+func (c *SeatCounts) Add(status SeatStatus) {
+	c.Total++
+	c.adjust(status, 1)
+}
+
+// Move counts a seat that went from one status to another
+func (c *SeatCounts) Move(from, to SeatStatus) {
+	c.adjust(from, -1)
+	c.adjust(to, 1)
+}
+
+// Consistent reports whether no count is negative and the statuses add up to
+// Total
+func (c SeatCounts) Consistent() bool {
+	return c.Available >= 0 && c.Held >= 0 && c.Booked >= 0 && c.Available+c.Held+c.Booked == c.Total
+}
+
+func (c *SeatCounts) adjust(status SeatStatus, by int) {
+	switch status {
+	case SeatAvailable:
+		c.Available += by
+	case SeatHeld:
+		c.Held += by
+	case SeatBooked:
+		c.Booked += by
+	}
+}
@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSeatStatusMarshalsAsString(t *testing.T) {
+	seatJSON, err := json.Marshal(Seat{ID: "A1", Status: SeatHeld})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var fields map[string]interface{}
+	json.Unmarshal(seatJSON, &fields)
+	if fields["status"] != "held" {
+		t.Fatalf("status encoded as %v, want \"held\"", fields["status"])
+	}
+
+	if _, err := json.Marshal(SeatStatus(7)); err == nil {
+		t.Error("out-of-range status marshaled without error")
+	}
+}
+
+func TestSeatStatusDecodesNamesAndLegacyIntegers(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SeatStatus
+		wantErr bool
+	}{
+		{in: `"available"`, want: SeatAvailable},
+		{in: `"held"`, want: SeatHeld},
+		{in: `"booked"`, want: SeatBooked},
+		{in: `0`, want: SeatAvailable},
+		{in: `1`, want: SeatHeld},
+		{in: `2`, want: SeatBooked},
+		{in: `"sold"`, wantErr: true},
+		{in: `3`, wantErr: true},
+		{in: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		var seat Seat
+		err := json.Unmarshal([]byte(`{"id":"A1","status":`+tt.in+`}`), &seat)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: decoded as %s, want error", tt.in, seat.Status)
+			}
+			continue
+		}
+		if err != nil || seat.Status != tt.want {
+			t.Errorf("%s: got %s, %v; want %s", tt.in, seat.Status, err, tt.want)
+		}
+	}
+}
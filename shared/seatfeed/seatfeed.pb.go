@@ -0,0 +1,311 @@
+// Seat feed for server-side integrators: the seat transitions the booking
+// service publishes, without the edges' WebSocket protocol.
+//
+// Regenerate seatfeed.pb.go and seatfeed_grpc.pb.go after changing this file:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative seatfeed.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.3
+// 	protoc        (unknown)
+// source: seatfeed.proto
+
+package seatfeed
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type WatchSeatEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The event whose seats to watch; every event if empty
+	EventId string `protobuf:"bytes,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	// Sections to watch; every section if empty
+	Sections      []string `protobuf:"bytes,2,rep,name=sections,proto3" json:"sections,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchSeatEventsRequest) Reset() {
+	*x = WatchSeatEventsRequest{}
+	mi := &file_seatfeed_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchSeatEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchSeatEventsRequest) ProtoMessage() {}
+
+func (x *WatchSeatEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_seatfeed_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchSeatEventsRequest.ProtoReflect.Descriptor instead.
+func (*WatchSeatEventsRequest) Descriptor() ([]byte, []int) {
+	return file_seatfeed_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *WatchSeatEventsRequest) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+func (x *WatchSeatEventsRequest) GetSections() []string {
+	if x != nil {
+		return x.Sections
+	}
+	return nil
+}
+
+// SeatEvent is one seat transition. Releases the booking service batches are
+// sent one by one.
+type SeatEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EventId       string                 `protobuf:"bytes,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	Seq           int64                  `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`  // venue-wide sequence number, as in GET /api/v1/seats/changes
+	Type          string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"` // held, renewed, released, booked, auto_released, allocated or returned
+	SeatId        string                 `protobuf:"bytes,4,opt,name=seat_id,json=seatId,proto3" json:"seat_id,omitempty"`
+	Section       string                 `protobuf:"bytes,5,opt,name=section,proto3" json:"section,omitempty"`
+	Status        string                 `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"` // available, held or booked
+	UserId        string                 `protobuf:"bytes,7,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Actor         string                 `protobuf:"bytes,8,opt,name=actor,proto3" json:"actor,omitempty"`                                  // who caused the transition, when not user_id
+	Source        string                 `protobuf:"bytes,9,opt,name=source,proto3" json:"source,omitempty"`                                // api, nats, expiry, admin, drift, restore or resale
+	Version       int64                  `protobuf:"varint,10,opt,name=version,proto3" json:"version,omitempty"`                            // seat version after the transition
+	TimestampMs   int64                  `protobuf:"varint,11,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"` // Unix milliseconds
+	ExpiresAt     int64                  `protobuf:"varint,12,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`       // Unix seconds a hold lapses at; 0 for other transitions
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SeatEvent) Reset() {
+	*x = SeatEvent{}
+	mi := &file_seatfeed_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SeatEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SeatEvent) ProtoMessage() {}
+
+func (x *SeatEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_seatfeed_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SeatEvent.ProtoReflect.Descriptor instead.
+func (*SeatEvent) Descriptor() ([]byte, []int) {
+	return file_seatfeed_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SeatEvent) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *SeatEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetSeatId() string {
+	if x != nil {
+		return x.SeatId
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetSection() string {
+	if x != nil {
+		return x.Section
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetActor() string {
+	if x != nil {
+		return x.Actor
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *SeatEvent) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *SeatEvent) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *SeatEvent) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+var File_seatfeed_proto protoreflect.FileDescriptor
+
+var file_seatfeed_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x73, 0x65, 0x61, 0x74, 0x66, 0x65, 0x65, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x14, 0x73, 0x65, 0x61, 0x74, 0x6d, 0x6f, 0x6f, 0x74, 0x2e, 0x73, 0x65, 0x61, 0x74, 0x66,
+	0x65, 0x65, 0x64, 0x2e, 0x76, 0x31, 0x22, 0x4f, 0x0a, 0x16, 0x57, 0x61, 0x74, 0x63, 0x68, 0x53,
+	0x65, 0x61, 0x74, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x19, 0x0a, 0x08, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x07, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x73,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x73,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x22, 0xba, 0x02, 0x0a, 0x09, 0x53, 0x65, 0x61, 0x74,
+	0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x5f, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x49, 0x64,
+	0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x73,
+	0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x73, 0x65, 0x61, 0x74, 0x5f, 0x69,
+	0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x65, 0x61, 0x74, 0x49, 0x64, 0x12,
+	0x18, 0x0a, 0x07, 0x73, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x07, 0x73, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x63,
+	0x74, 0x6f, 0x72, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x61, 0x63, 0x74, 0x6f, 0x72,
+	0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69,
+	0x6f, 0x6e, 0x12, 0x21, 0x0a, 0x0c, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x5f,
+	0x6d, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x4d, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73,
+	0x5f, 0x61, 0x74, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x65, 0x78, 0x70, 0x69, 0x72,
+	0x65, 0x73, 0x41, 0x74, 0x32, 0x6e, 0x0a, 0x08, 0x53, 0x65, 0x61, 0x74, 0x46, 0x65, 0x65, 0x64,
+	0x12, 0x62, 0x0a, 0x0f, 0x57, 0x61, 0x74, 0x63, 0x68, 0x53, 0x65, 0x61, 0x74, 0x45, 0x76, 0x65,
+	0x6e, 0x74, 0x73, 0x12, 0x2c, 0x2e, 0x73, 0x65, 0x61, 0x74, 0x6d, 0x6f, 0x6f, 0x74, 0x2e, 0x73,
+	0x65, 0x61, 0x74, 0x66, 0x65, 0x65, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68,
+	0x53, 0x65, 0x61, 0x74, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x1f, 0x2e, 0x73, 0x65, 0x61, 0x74, 0x6d, 0x6f, 0x6f, 0x74, 0x2e, 0x73, 0x65, 0x61,
+	0x74, 0x66, 0x65, 0x65, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x61, 0x74, 0x45, 0x76, 0x65,
+	0x6e, 0x74, 0x30, 0x01, 0x42, 0x21, 0x5a, 0x1f, 0x63, 0x6f, 0x6e, 0x63, 0x65, 0x72, 0x74, 0x2d,
+	0x62, 0x6f, 0x6f, 0x6b, 0x69, 0x6e, 0x67, 0x2f, 0x73, 0x68, 0x61, 0x72, 0x65, 0x64, 0x2f, 0x73,
+	0x65, 0x61, 0x74, 0x66, 0x65, 0x65, 0x64, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_seatfeed_proto_rawDescOnce sync.Once
+	file_seatfeed_proto_rawDescData = file_seatfeed_proto_rawDesc
+)
+
+func file_seatfeed_proto_rawDescGZIP() []byte {
+	file_seatfeed_proto_rawDescOnce.Do(func() {
+		file_seatfeed_proto_rawDescData = protoimpl.X.CompressGZIP(file_seatfeed_proto_rawDescData)
+	})
+	return file_seatfeed_proto_rawDescData
+}
+
+var file_seatfeed_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_seatfeed_proto_goTypes = []any{
+	(*WatchSeatEventsRequest)(nil), // 0: seatmoot.seatfeed.v1.WatchSeatEventsRequest
+	(*SeatEvent)(nil),              // 1: seatmoot.seatfeed.v1.SeatEvent
+}
+var file_seatfeed_proto_depIdxs = []int32{
+	0, // 0: seatmoot.seatfeed.v1.SeatFeed.WatchSeatEvents:input_type -> seatmoot.seatfeed.v1.WatchSeatEventsRequest
+	1, // 1: seatmoot.seatfeed.v1.SeatFeed.WatchSeatEvents:output_type -> seatmoot.seatfeed.v1.SeatEvent
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_seatfeed_proto_init() }
+func file_seatfeed_proto_init() {
+	if File_seatfeed_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_seatfeed_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_seatfeed_proto_goTypes,
+		DependencyIndexes: file_seatfeed_proto_depIdxs,
+		MessageInfos:      file_seatfeed_proto_msgTypes,
+	}.Build()
+	File_seatfeed_proto = out.File
+	file_seatfeed_proto_rawDesc = nil
+	file_seatfeed_proto_goTypes = nil
+	file_seatfeed_proto_depIdxs = nil
+}
@@ -0,0 +1,140 @@
+// Seat feed for server-side integrators: the seat transitions the booking
+// service publishes, without the edges' WebSocket protocol.
+//
+// Regenerate seatfeed.pb.go and seatfeed_grpc.pb.go after changing this file:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative seatfeed.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: seatfeed.proto
+
+package seatfeed
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SeatFeed_WatchSeatEvents_FullMethodName = "/seatmoot.seatfeed.v1.SeatFeed/WatchSeatEvents"
+)
+
+// SeatFeedClient is the client API for SeatFeed service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SeatFeedClient interface {
+	// WatchSeatEvents streams seat transitions as they are published, from the
+	// time of the call on. A watcher that falls too far behind is ended with
+	// RESOURCE_EXHAUSTED and should call again, fetching the seats it missed
+	// from GET /api/v1/seats/changes?since=<the last seq it got>.
+	WatchSeatEvents(ctx context.Context, in *WatchSeatEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SeatEvent], error)
+}
+
+type seatFeedClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSeatFeedClient(cc grpc.ClientConnInterface) SeatFeedClient {
+	return &seatFeedClient{cc}
+}
+
+func (c *seatFeedClient) WatchSeatEvents(ctx context.Context, in *WatchSeatEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SeatEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SeatFeed_ServiceDesc.Streams[0], SeatFeed_WatchSeatEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchSeatEventsRequest, SeatEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SeatFeed_WatchSeatEventsClient = grpc.ServerStreamingClient[SeatEvent]
+
+// SeatFeedServer is the server API for SeatFeed service.
+// All implementations must embed UnimplementedSeatFeedServer
+// for forward compatibility.
+type SeatFeedServer interface {
+	// WatchSeatEvents streams seat transitions as they are published, from the
+	// time of the call on. A watcher that falls too far behind is ended with
+	// RESOURCE_EXHAUSTED and should call again, fetching the seats it missed
+	// from GET /api/v1/seats/changes?since=<the last seq it got>.
+	WatchSeatEvents(*WatchSeatEventsRequest, grpc.ServerStreamingServer[SeatEvent]) error
+	mustEmbedUnimplementedSeatFeedServer()
+}
+
+// UnimplementedSeatFeedServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSeatFeedServer struct{}
+
+func (UnimplementedSeatFeedServer) WatchSeatEvents(*WatchSeatEventsRequest, grpc.ServerStreamingServer[SeatEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchSeatEvents not implemented")
+}
+func (UnimplementedSeatFeedServer) mustEmbedUnimplementedSeatFeedServer() {}
+func (UnimplementedSeatFeedServer) testEmbeddedByValue()                  {}
+
+// UnsafeSeatFeedServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SeatFeedServer will
+// result in compilation errors.
+type UnsafeSeatFeedServer interface {
+	mustEmbedUnimplementedSeatFeedServer()
+}
+
+func RegisterSeatFeedServer(s grpc.ServiceRegistrar, srv SeatFeedServer) {
+	// If the following call pancis, it indicates UnimplementedSeatFeedServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SeatFeed_ServiceDesc, srv)
+}
+
+func _SeatFeed_WatchSeatEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchSeatEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SeatFeedServer).WatchSeatEvents(m, &grpc.GenericServerStream[WatchSeatEventsRequest, SeatEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SeatFeed_WatchSeatEventsServer = grpc.ServerStreamingServer[SeatEvent]
+
+// SeatFeed_ServiceDesc is the grpc.ServiceDesc for SeatFeed service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SeatFeed_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "seatmoot.seatfeed.v1.SeatFeed",
+	HandlerType: (*SeatFeedServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchSeatEvents",
+			Handler:       _SeatFeed_WatchSeatEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "seatfeed.proto",
+}
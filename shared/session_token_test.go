@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSessionToken(t *testing.T) {
+	secret := []byte("test-secret")
+	now := time.Now()
+	token := SignSessionToken(secret, SessionClaims{UserID: "user-1", Role: "viewer", ExpiresAt: now.Add(time.Hour).Unix()})
+
+	claims, err := ParseSessionToken(secret, token, now)
+	if err != nil {
+		t.Fatalf("ParseSessionToken: %v", err)
+	}
+	if claims.UserID != "user-1" || claims.Role != "viewer" {
+		t.Fatalf("claims = %+v", claims)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	forged := SignSessionToken(secret, SessionClaims{UserID: "user-2", ExpiresAt: now.Add(time.Hour).Unix()})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		want  error
+	}{
+		{"missing", "", now, ErrTokenMissing},
+		{"no signature", payload, now, ErrTokenMalformed},
+		{"other secret", SignSessionToken([]byte("other"), *claims), now, ErrTokenSignature},
+		{"swapped payload", forgedPayload + "." + signature, now, ErrTokenSignature},
+		{"expired", token, now.Add(2 * time.Hour), ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		if _, err := ParseSessionToken(secret, tt.token, tt.now); err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
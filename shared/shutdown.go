@@ -0,0 +1,30 @@
+package shared
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// How long a service keeps serving after SIGTERM, marked unready, so load
+	// balancers stop routing to it before it stops accepting connections
+	DefaultShutdownDrain = 5 * time.Second
+
+	// How long a service then waits for in-flight requests, WebSocket writes
+	// and NATS publishes before exiting anyway
+	ShutdownTimeout = 15 * time.Second
+)
+
+// ShutdownDrainFromEnv reads SHUTDOWN_DRAIN, a duration such as 10s or 0
+func ShutdownDrainFromEnv() (time.Duration, error) {
+	env := os.Getenv("SHUTDOWN_DRAIN")
+	if env == "" {
+		return DefaultShutdownDrain, nil
+	}
+	drain, err := time.ParseDuration(env)
+	if err != nil || drain < 0 {
+		return 0, fmt.Errorf("SHUTDOWN_DRAIN must be a non-negative duration, got %q", env)
+	}
+	return drain, nil
+}
@@ -0,0 +1,27 @@
+package shared
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainFromEnv(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultShutdownDrain, false},
+		{"0", 0, false},
+		{"30s", 30 * time.Second, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("SHUTDOWN_DRAIN", tt.env)
+		got, err := ShutdownDrainFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("SHUTDOWN_DRAIN=%q: got %v, %v; want %v, error %v", tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package shared
+
+import "strings"
+
+// DefaultEventID names the event whose seats this deployment sells
+const DefaultEventID = "main"
+
+// SeatSubject returns the NATS subject a seat transition is published on:
+// seats.<event>.<section>.<seatID>.<action>. Consumers can subscribe to just the
+// slice they need, e.g. seats.main.101.> for one section or seats.*.*.*.booked
+// for every booking.
+func SeatSubject(eventID, section, seatID, action string) string {
+	return "seats." + subjectToken(eventID) + "." + subjectToken(section) + "." +
+		subjectToken(seatID) + "." + subjectToken(action)
+}
+
+// SubjectEventID returns the event of a seat subject built by SeatSubject or
+// SeatBatchSubject, or "" if subject is not one
+func SubjectEventID(subject string) string {
+	tokens := strings.SplitN(subject, ".", 3)
+	if len(tokens) < 3 || tokens[0] != "seats" || tokens[1] == "_" {
+		return ""
+	}
+	return tokens[1]
+}
+
+// UserMsgsSubject returns the NATS subject messages for one user are
+// published on, users.<userID>.msgs. Every edge subscribes to all of them, so
+// a message reaches the user whichever edge their clients are on.
+func UserMsgsSubject(userID string) string {
+	return "users." + subjectToken(userID) + ".msgs"
+}
+
+// SeatSubjectFilter returns a subscription subject matching seat transitions;
+// empty arguments match anything
+func SeatSubjectFilter(eventID, section, seatID, action string) string {
+	parts := []string{eventID, section, seatID, action}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = "*"
+		} else {
+			parts[i] = subjectToken(part)
+		}
+	}
+	return "seats." + strings.Join(parts, ".")
+}
+
+// subjectToken makes s safe to use as a single NATS subject token
+func subjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// SubjectMatches reports whether subject matches pattern using NATS wildcards:
+// "*" matches one token and a trailing ">" matches one or more
+func SubjectMatches(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" && i == len(patternTokens)-1 {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(subjectTokens) == len(patternTokens)
+}
@@ -0,0 +1,60 @@
+package shared
+
+import "testing"
+
+func TestSubjectMatches(t *testing.T) {
+	subject := SeatSubject(DefaultEventID, "101", "101-A1", "held")
+
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{subject, true},
+		{NATSTopicAllSeats, true},
+		{"seats.>", true},
+		{"seats.main.101.>", true},
+		{"seats.*.*.*.held", true},
+		{"seats.*.*.*.booked", false},
+		{"seats.main.102.>", false},
+		{"seats.*.*.*", false},
+		{"seats.*.*.*.*.*", false},
+		{"seats.main.101.101-A1.held.>", false},
+	}
+	for _, tt := range tests {
+		if got := SubjectMatches(tt.pattern, subject); got != tt.want {
+			t.Errorf("SubjectMatches(%q, %q) = %v, want %v", tt.pattern, subject, got, tt.want)
+		}
+	}
+
+	if SubjectMatches(NATSTopicAllSeats, NATSSubjectCmdSelect) {
+		t.Errorf("%s must not match seat commands", NATSTopicAllSeats)
+	}
+}
+
+func TestSubjectEventID(t *testing.T) {
+	for subject, want := range map[string]string{
+		SeatSubject("spring-gala", "101", "101-A1", "held"): "spring-gala",
+		SeatBatchSubject(DefaultEventID):                    DefaultEventID,
+		SeatSubject("", "101", "101-A1", "held"):            "",
+		"announcements":                                     "",
+	} {
+		if got := SubjectEventID(subject); got != want {
+			t.Errorf("SubjectEventID(%q) = %q, want %q", subject, got, want)
+		}
+	}
+}
+
+func TestUserMsgsSubject(t *testing.T) {
+	subject := UserMsgsSubject("user.123")
+	if subject != "users.user_123.msgs" {
+		t.Errorf("UserMsgsSubject = %q, want the user ID as one token", subject)
+	}
+	if !SubjectMatches(NATSTopicUserMsgs, subject) {
+		t.Errorf("%s does not match %s", NATSTopicUserMsgs, subject)
+	}
+	for _, other := range []string{NATSTopicUserPresence, NATSTopicPresenceSync} {
+		if SubjectMatches(NATSTopicUserMsgs, other) {
+			t.Errorf("%s must not match %s", NATSTopicUserMsgs, other)
+		}
+	}
+}
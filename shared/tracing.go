@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Span attributes shared by both services
+const (
+	AttrSeatID   = attribute.Key("seat.id")
+	AttrUserID   = attribute.Key("user.id")
+	AttrClientID = attribute.Key("client.id")
+)
+
+// InitTracing installs W3C trace context propagation and, when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, exports spans over OTLP/HTTP as service.
+// Without an endpoint spans are not recorded, but trace context still passes
+// through so a downstream service can pick it up. The returned function
+// flushes pending spans on shutdown.
+func InitTracing(service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads the endpoint, headers and TLS settings from the
+	// standard OTEL_EXPORTER_OTLP_* variables
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(sdkresource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// InjectTraceparent returns ctx's trace context as a traceparent value, or ""
+// if ctx carries none
+func InjectTraceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// ExtractTraceparent returns ctx carrying the trace context of a traceparent
+// value produced by InjectTraceparent
+func ExtractTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
@@ -0,0 +1,94 @@
+package shared
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// VenueFormatBitmask is the SUBSCRIBE format asking for VENUE_STATE_BITMASK
+const VenueFormatBitmask = "bitmask"
+
+// BitmaskVenueState is the data of VENUE_STATE_BITMASK, a VENUE_STATE for
+// clients that already have the seat layout: two bits of status per seat, plus
+// a side table for the holds. Seats are in layout order, their IDs sorted
+// bytewise, and the state applies only to the layout it names.
+type BitmaskVenueState struct {
+	Layout   string        `json:"layout"`
+	Seats    int           `json:"seats"`
+	Statuses string        `json:"statuses"` // base64, four seats a byte, the first in the high bits
+	Holds    []BitmaskHold `json:"holds,omitempty"`
+	Event    *EventInfo    `json:"event,omitempty"`
+	Flags    FeatureFlags  `json:"flags,omitempty"`
+	Degraded bool          `json:"degraded,omitempty"`
+
+	VenueVersion int64 `json:"venue_version,omitempty"`
+}
+
+// BitmaskHold is the holder of a held seat in a BitmaskVenueState
+type BitmaskHold struct {
+	Index     int    `json:"i"` // the seat's position in layout order
+	HeldBy    string `json:"by"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// VenueLayoutID fingerprints what a client needs to draw the venue: every
+// seat's ID, position, section, tier and price. Venues with the same layout
+// have the same ID on every edge.
+func VenueLayoutID(seats []Seat) string {
+	ordered := layoutOrder(seats)
+	h := fnv.New64a()
+	for _, seat := range ordered {
+		fmt.Fprintf(h, "%s|%d|%d|%s|%s|%d\n", seat.ID, seat.Row, seat.Col, seat.Section, seat.Tier, seat.PriceCents)
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// EncodeVenueBitmask packs the status of seats into a BitmaskVenueState
+func EncodeVenueBitmask(seats []Seat) BitmaskVenueState {
+	ordered := layoutOrder(seats)
+	bits := make([]byte, (len(ordered)+3)/4)
+	state := BitmaskVenueState{Layout: VenueLayoutID(ordered), Seats: len(ordered)}
+	for i, seat := range ordered {
+		bits[i/4] |= byte(seat.Status&3) << (6 - 2*(i%4))
+		if seat.Status == SeatHeld {
+			state.Holds = append(state.Holds, BitmaskHold{Index: i, HeldBy: seat.HeldBy, ExpiresAt: seat.ExpiresAt})
+		}
+	}
+	state.Statuses = base64.StdEncoding.EncodeToString(bits)
+	return state
+}
+
+// Apply sets the status and holder of each seat in layout from the bitmask.
+// layout must be the seats the bitmask was encoded from, in any order.
+func (b *BitmaskVenueState) Apply(layout []Seat) ([]Seat, error) {
+	if id := VenueLayoutID(layout); id != b.Layout {
+		return nil, fmt.Errorf("bitmask is for layout %s, have %s", b.Layout, id)
+	}
+	bits, err := base64.StdEncoding.DecodeString(b.Statuses)
+	if err != nil || len(bits) != (b.Seats+3)/4 || b.Seats != len(layout) {
+		return nil, fmt.Errorf("malformed bitmask for %d seats", len(layout))
+	}
+
+	seats := layoutOrder(layout)
+	for i := range seats {
+		seats[i].Status = SeatStatus(bits[i/4]>>(6-2*(i%4))) & 3
+		seats[i].HeldBy, seats[i].ExpiresAt = "", 0
+	}
+	for _, hold := range b.Holds {
+		if hold.Index < 0 || hold.Index >= len(seats) {
+			return nil, fmt.Errorf("hold for seat %d of %d", hold.Index, len(seats))
+		}
+		seats[hold.Index].HeldBy, seats[hold.Index].ExpiresAt = hold.HeldBy, hold.ExpiresAt
+	}
+	return seats, nil
+}
+
+// layoutOrder returns a copy of seats sorted by ID
+func layoutOrder(seats []Seat) []Seat {
+	ordered := append([]Seat(nil), seats...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].ID < ordered[j].ID })
+	return ordered
+}
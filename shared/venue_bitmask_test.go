@@ -0,0 +1,85 @@
+package shared
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestVenueBitmaskRoundTrip(t *testing.T) {
+	var seats []Seat
+	for row := 0; row < VenueRows; row++ {
+		for col := 0; col < VenueCols; col++ {
+			seats = append(seats, Seat{ID: GetSeatID(row, col), Row: row, Col: col, PriceCents: 5000})
+		}
+	}
+	seats[3].Status = SeatBooked
+	seats[42].Status, seats[42].HeldBy, seats[42].ExpiresAt = SeatHeld, "alice", 1699123486
+	seats[99].Status = SeatBooked
+
+	bitmask := EncodeVenueBitmask(seats)
+	encoded, _ := json.Marshal(bitmask)
+	full, _ := json.Marshal(VenueState{Seats: seats})
+	if len(encoded) > len(full)/10 {
+		t.Errorf("bitmask is %d bytes, want a tenth of the %d bytes of VENUE_STATE", len(encoded), len(full))
+	}
+
+	layout := make([]Seat, len(seats))
+	for i, seat := range seats {
+		layout[len(seats)-1-i] = Seat{ID: seat.ID, Row: seat.Row, Col: seat.Col, PriceCents: seat.PriceCents}
+	}
+	got, err := bitmask.Apply(layout)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := layoutOrder(seats)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded seats differ from the encoded ones")
+	}
+
+	layout[0].PriceCents = 9000
+	if _, err := bitmask.Apply(layout); err == nil {
+		t.Error("a bitmask was applied to a different layout")
+	}
+}
+
+func TestVenueLayoutIDIgnoresState(t *testing.T) {
+	seats := []Seat{{ID: "A1"}, {ID: "A2", Section: "floor"}}
+	id := VenueLayoutID(seats)
+	seats[0].Status, seats[0].HeldBy, seats[0].Version = SeatHeld, "bob", 7
+	if VenueLayoutID([]Seat{seats[1], seats[0]}) != id {
+		t.Error("layout ID changed with seat state or order")
+	}
+	seats[1].Section = "balcony"
+	if VenueLayoutID(seats) == id {
+		t.Error("layout ID did not change with a seat's section")
+	}
+}
+
+// benchVenue lays out seats in rows of 50, every tenth held and every third booked
+func benchVenue(seats int) []Seat {
+	venue := make([]Seat, seats)
+	for i := range venue {
+		venue[i] = Seat{ID: GetSeatID(i/50, i%50), Row: i / 50, Col: i % 50, PriceCents: 5000, Version: 1}
+		switch {
+		case i%10 == 0:
+			venue[i].Status, venue[i].HeldBy, venue[i].ExpiresAt = SeatHeld, "user-"+strconv.Itoa(i), 1699123486
+		case i%3 == 0:
+			venue[i].Status = SeatBooked
+		}
+	}
+	return venue
+}
+
+func BenchmarkEncodeVenueBitmask(b *testing.B) {
+	for _, size := range []int{1000, 10000} {
+		seats := benchVenue(size)
+		b.Run("seats="+strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				EncodeVenueBitmask(seats)
+			}
+		})
+	}
+}
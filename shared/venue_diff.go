@@ -0,0 +1,108 @@
+package shared
+
+import (
+	"sort"
+	"time"
+)
+
+// VenueSnapshot is every seat of a deployment at one moment, as exported by
+// GET /api/admin/venue/snapshot and saved by seatctl
+type VenueSnapshot struct {
+	Seats   []Seat     `json:"seats"`
+	Seq     int64      `json:"seq"` // seat event sequence number the seats reflect
+	TakenAt time.Time  `json:"taken_at"`
+	Event   *EventInfo `json:"event,omitempty"`
+}
+
+// Kinds of seat discrepancy
+const (
+	SeatDiffOnlyInFrom = "only_in_from"
+	SeatDiffOnlyInTo   = "only_in_to"
+	SeatDiffChanged    = "changed"
+)
+
+// FieldDiff is one field that differs between two versions of a seat
+type FieldDiff struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// SeatDiff is one seat that differs between two venues
+type SeatDiff struct {
+	SeatID string      `json:"seat_id"`
+	Kind   string      `json:"kind"`
+	Fields []FieldDiff `json:"fields,omitempty"`
+}
+
+// VenueDiff reports how one venue differs from another
+type VenueDiff struct {
+	FromSeats    int        `json:"from_seats"`
+	ToSeats      int        `json:"to_seats"`
+	IncludeState bool       `json:"include_state"`
+	Seats        []SeatDiff `json:"seats"`
+}
+
+// Identical reports whether no discrepancies were found
+func (d *VenueDiff) Identical() bool {
+	return len(d.Seats) == 0
+}
+
+// DiffVenues compares two seat lists by seat ID. Layout (position, section, tier
+// and price) is always compared; with includeState, so are status and holder.
+// Versions and expiry times are never compared since they differ between any
+// two deployments. Discrepancies are sorted by seat ID.
+func DiffVenues(from, to []Seat, includeState bool) *VenueDiff {
+	diff := &VenueDiff{
+		FromSeats:    len(from),
+		ToSeats:      len(to),
+		IncludeState: includeState,
+		Seats:        []SeatDiff{},
+	}
+
+	toByID := make(map[string]Seat, len(to))
+	for _, seat := range to {
+		toByID[seat.ID] = seat
+	}
+
+	seen := make(map[string]bool, len(from))
+	for _, a := range from {
+		seen[a.ID] = true
+		b, ok := toByID[a.ID]
+		if !ok {
+			diff.Seats = append(diff.Seats, SeatDiff{SeatID: a.ID, Kind: SeatDiffOnlyInFrom})
+			continue
+		}
+		if fields := diffSeat(a, b, includeState); len(fields) > 0 {
+			diff.Seats = append(diff.Seats, SeatDiff{SeatID: a.ID, Kind: SeatDiffChanged, Fields: fields})
+		}
+	}
+	for _, b := range to {
+		if !seen[b.ID] {
+			diff.Seats = append(diff.Seats, SeatDiff{SeatID: b.ID, Kind: SeatDiffOnlyInTo})
+		}
+	}
+
+	sort.Slice(diff.Seats, func(i, j int) bool { return diff.Seats[i].SeatID < diff.Seats[j].SeatID })
+	return diff
+}
+
+func diffSeat(a, b Seat, includeState bool) []FieldDiff {
+	var fields []FieldDiff
+	add := func(field string, from, to interface{}) {
+		if from != to {
+			fields = append(fields, FieldDiff{Field: field, From: from, To: to})
+		}
+	}
+
+	add("row", a.Row, b.Row)
+	add("col", a.Col, b.Col)
+	add("section", a.Section, b.Section)
+	add("tier", a.Tier, b.Tier)
+	add("price_cents", a.PriceCents, b.PriceCents)
+	if includeState {
+		add("status", a.Status, b.Status)
+		add("held_by", a.HeldBy, b.HeldBy)
+	}
+	return fields
+}
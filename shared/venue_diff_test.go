@@ -0,0 +1,49 @@
+package shared
+
+import "testing"
+
+func TestDiffVenues(t *testing.T) {
+	from := []Seat{
+		{ID: "A1", Row: 0, Col: 0, Tier: "vip", PriceCents: 5000, Status: SeatBooked, HeldBy: "u1", Version: 3},
+		{ID: "A2", Row: 0, Col: 1, Tier: "vip", PriceCents: 5000},
+		{ID: "A3", Row: 0, Col: 2},
+	}
+	to := []Seat{
+		{ID: "A4", Row: 0, Col: 3},
+		{ID: "A2", Row: 0, Col: 1, Tier: "standard", PriceCents: 3000},
+		{ID: "A1", Row: 0, Col: 0, Tier: "vip", PriceCents: 5000, Version: 1},
+	}
+
+	layout := DiffVenues(from, to, false)
+	want := []SeatDiff{
+		{SeatID: "A2", Kind: SeatDiffChanged, Fields: []FieldDiff{
+			{Field: "tier", From: "vip", To: "standard"},
+			{Field: "price_cents", From: int64(5000), To: int64(3000)},
+		}},
+		{SeatID: "A3", Kind: SeatDiffOnlyInFrom},
+		{SeatID: "A4", Kind: SeatDiffOnlyInTo},
+	}
+	if len(layout.Seats) != len(want) {
+		t.Fatalf("layout diff = %+v, want %+v", layout.Seats, want)
+	}
+	for i := range want {
+		got := layout.Seats[i]
+		if got.SeatID != want[i].SeatID || got.Kind != want[i].Kind || len(got.Fields) != len(want[i].Fields) {
+			t.Fatalf("diff[%d] = %+v, want %+v", i, got, want[i])
+		}
+		for j := range want[i].Fields {
+			if got.Fields[j] != want[i].Fields[j] {
+				t.Errorf("diff[%d].Fields[%d] = %+v, want %+v", i, j, got.Fields[j], want[i].Fields[j])
+			}
+		}
+	}
+
+	state := DiffVenues(from, to, true)
+	if len(state.Seats) != 4 || state.Seats[0].SeatID != "A1" || len(state.Seats[0].Fields) != 2 {
+		t.Fatalf("state diff = %+v, want A1 status and held_by reported first", state.Seats)
+	}
+
+	if !DiffVenues(from, from, true).Identical() {
+		t.Fatal("a venue differs from itself")
+	}
+}
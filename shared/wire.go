@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Wire formats of WebSocket frames and event bus payloads. JSON is the
+// default; MessagePack carries the same documents, with the same keys, in
+// fewer bytes.
+const (
+	WireFormatJSON    = "json"
+	WireFormatMsgpack = "msgpack"
+)
+
+// WebSocket subprotocols a client may ask for in Sec-WebSocket-Protocol. A
+// client asking for neither gets JSON text frames.
+const (
+	SubprotocolJSON    = "seatmoot.json"
+	SubprotocolMsgpack = "seatmoot.msgpack"
+)
+
+// msgpackHandle encodes with the current MessagePack spec (str8 and bin
+// types) and decodes strings as strings and maps as JSON objects decode. Struct
+// fields are keyed by their json tags.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}()
+
+// MarshalMsgpack encodes v as MessagePack
+func MarshalMsgpack(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+	return data, err
+}
+
+// UnmarshalMsgpack decodes MessagePack data into v
+func UnmarshalMsgpack(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+// JSONToMsgpack re-encodes a JSON document as MessagePack. Whole numbers stay
+// integers rather than becoming floats.
+func JSONToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return MarshalMsgpack(jsonNumbers(doc))
+}
+
+// MsgpackToJSON re-encodes a MessagePack document as JSON
+func MsgpackToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := UnmarshalMsgpack(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// jsonNumbers replaces the json.Numbers in a decoded document with int64s, or
+// float64s where they have a fraction or do not fit
+func jsonNumbers(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = jsonNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonNumbers(value)
+		}
+	}
+	return doc
+}
+
+// EventBusFormatFromEnv reads EVENT_BUS_FORMAT, the format seat events are
+// published in: json (default) or msgpack
+func EventBusFormatFromEnv() (string, error) {
+	switch format := os.Getenv("EVENT_BUS_FORMAT"); format {
+	case "", WireFormatJSON:
+		return WireFormatJSON, nil
+	case WireFormatMsgpack:
+		return WireFormatMsgpack, nil
+	default:
+		return "", fmt.Errorf("EVENT_BUS_FORMAT must be %s or %s, got %q", WireFormatJSON, WireFormatMsgpack, format)
+	}
+}
+
+// MarshalEvent encodes an event bus payload in format
+func MarshalEvent(v interface{}, format string) ([]byte, error) {
+	if format == WireFormatMsgpack {
+		return MarshalMsgpack(v)
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalEvent decodes an event bus payload in either format, so publishers
+// can switch formats without their subscribers. Payloads are objects: in JSON
+// they start with '{', which no MessagePack map does.
+func UnmarshalEvent(data []byte, v interface{}) error {
+	if len(data) > 0 && data[0] != '{' {
+		return UnmarshalMsgpack(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSeatEventsDecodeFromEitherWireFormat(t *testing.T) {
+	seat := &Seat{ID: "A1", Status: SeatHeld, HeldBy: "alice", ExpiresAt: 1699123486, Version: 3, PriceCents: 5000}
+	event := SeatEvent{
+		Type: SeatEventReleasedBatch, Seq: 41, Timestamp: time.UnixMilli(1699123456789).UTC(),
+		Events: []SeatEvent{{Type: "held", SeatID: "A1", UserID: "alice", Status: SeatHeld, Version: 3, Seq: 41, Seat: seat}},
+	}
+
+	for _, format := range []string{WireFormatJSON, WireFormatMsgpack} {
+		data, err := MarshalEvent(event, format)
+		if err != nil {
+			t.Fatalf("%s: MarshalEvent: %v", format, err)
+		}
+		var decoded SeatEvent
+		if err := UnmarshalEvent(data, &decoded); err != nil {
+			t.Fatalf("%s: UnmarshalEvent: %v", format, err)
+		}
+		decoded.Timestamp = decoded.Timestamp.UTC()
+		if !reflect.DeepEqual(decoded, event) {
+			t.Errorf("%s: decoded %+v, want %+v", format, decoded, event)
+		}
+	}
+
+	jsonData, _ := MarshalEvent(event, WireFormatJSON)
+	packed, _ := MarshalEvent(event, WireFormatMsgpack)
+	if len(packed) >= len(jsonData) {
+		t.Errorf("MessagePack event is %d bytes, JSON %d", len(packed), len(jsonData))
+	}
+}
+
+func TestJSONMessagesSurviveAMsgpackRoundTrip(t *testing.T) {
+	message := []byte(`{"type":"SEAT_UPDATE","data":{"seat_id":"A1","status":"held","version":3,"expires_at":1699123486,"price":49.5,"seat":null,"tags":["vip"]}}`)
+
+	packed, err := JSONToMsgpack(message)
+	if err != nil {
+		t.Fatalf("JSONToMsgpack: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := UnmarshalMsgpack(packed, &doc); err != nil {
+		t.Fatalf("UnmarshalMsgpack: %v", err)
+	}
+	data := doc["data"].(map[string]interface{})
+	if _, ok := data["version"].(int64); !ok {
+		t.Errorf("version decoded as %T, want a whole number", data["version"])
+	}
+
+	back, err := MsgpackToJSON(packed)
+	if err != nil {
+		t.Fatalf("MsgpackToJSON: %v", err)
+	}
+	var want, got interface{}
+	json.Unmarshal(message, &want)
+	json.Unmarshal(back, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %s, want %s", back, message)
+	}
+}
+
+// benchSeatEvent is a held event as the relay publishes it, with the seat
+func benchSeatEvent() SeatEvent {
+	seat := &Seat{ID: "AB12", Row: 27, Col: 11, Section: "floor", Tier: "vip", PriceCents: 12500,
+		Status: SeatHeld, HeldBy: "user_8f3k2l9qa", ExpiresAt: 1699123486, HeldAt: 1699123456, Version: 7}
+	return SeatEvent{Type: "held", Seq: 48213, SeatID: seat.ID, UserID: seat.HeldBy, Status: SeatHeld,
+		Version: seat.Version, Timestamp: time.UnixMilli(1699123456789).UTC(), ExpiresAt: seat.ExpiresAt, Seat: seat}
+}
+
+func BenchmarkMarshalEvent(b *testing.B) {
+	event := benchSeatEvent()
+	for _, format := range []string{WireFormatJSON, WireFormatMsgpack} {
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := MarshalEvent(event, format); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalEvent(b *testing.B) {
+	for _, format := range []string{WireFormatJSON, WireFormatMsgpack} {
+		data, err := MarshalEvent(benchSeatEvent(), format)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				var event SeatEvent
+				if err := UnmarshalEvent(data, &event); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -108,7 +108,7 @@ echo ""
 echo "📌 Access Points:"
 echo "  • Frontend: http://localhost (via NGINX)"
 echo "  • Direct Frontend: http://localhost:8000 (Python server)"
-echo "  • API: http://localhost/api/seats"
+echo "  • API: http://localhost/api/v1/seats"
 echo "  • Health: http://localhost/health"
 echo "  • Stats: http://localhost/stats"
 echo "  • NGINX Health: http://localhost/nginx-health"
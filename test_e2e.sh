@@ -21,15 +21,15 @@ echo ""
 
 # Step 1: Check seat is available
 echo "1. Checking seat availability..."
-SEATS=$(curl -s "$API_URL/api/seats")
-SEAT_STATUS=$(echo "$SEATS" | grep -o "\"id\":\"$SEAT_ID\"[^}]*" | grep -o '"status":[0-9]' | cut -d':' -f2)
+SEATS=$(curl -s "$API_URL/api/v1/seats")
+SEAT_STATUS=$(echo "$SEATS" | grep -o "\"id\":\"$SEAT_ID\"[^}]*" | grep -o '"status":"[a-z]*"' | cut -d'"' -f4)
 
-if [ "$SEAT_STATUS" == "0" ]; then
+if [ "$SEAT_STATUS" == "available" ]; then
     echo -e "   ${GREEN}✓${NC} Seat $SEAT_ID is available"
-elif [ "$SEAT_STATUS" == "1" ]; then
+elif [ "$SEAT_STATUS" == "held" ]; then
     echo -e "   ${YELLOW}⚠${NC} Seat $SEAT_ID is held, waiting for release..."
     sleep 31
-elif [ "$SEAT_STATUS" == "2" ]; then
+elif [ "$SEAT_STATUS" == "booked" ]; then
     echo -e "   ${RED}✗${NC} Seat $SEAT_ID is already booked, choosing different seat"
     SEAT_ID="E5"
     echo "   Using seat $SEAT_ID instead"
@@ -38,7 +38,7 @@ fi
 # Step 2: Select (hold) the seat
 echo ""
 echo "2. Selecting seat $SEAT_ID..."
-SELECT_RESPONSE=$(curl -s -X POST "$API_URL/api/seats/select" \
+SELECT_RESPONSE=$(curl -s -X POST "$API_URL/api/v1/seats/select" \
     -H "Content-Type: application/json" \
     -d "{\"seat_id\":\"$SEAT_ID\",\"user_id\":\"$USER_ID\"}")
 
@@ -55,7 +55,7 @@ fi
 echo ""
 echo "3. Verifying seat is held..."
 sleep 1
-SEATS=$(curl -s "$API_URL/api/seats")
+SEATS=$(curl -s "$API_URL/api/v1/seats")
 SEAT_INFO=$(echo "$SEATS" | grep -o "\"id\":\"$SEAT_ID\"[^}]*")
 HELD_BY=$(echo "$SEAT_INFO" | grep -o '"held_by":"[^"]*"' | cut -d'"' -f4)
 
@@ -68,7 +68,7 @@ fi
 # Step 4: Book the seat
 echo ""
 echo "4. Booking seat $SEAT_ID..."
-BOOK_RESPONSE=$(curl -s -X POST "$API_URL/api/seats/book" \
+BOOK_RESPONSE=$(curl -s -X POST "$API_URL/api/v1/seats/book" \
     -H "Content-Type: application/json" \
     -d "{\"seat_id\":\"$SEAT_ID\",\"user_id\":\"$USER_ID\"}")
 
@@ -84,10 +84,10 @@ fi
 echo ""
 echo "5. Verifying seat is booked..."
 sleep 1
-SEATS=$(curl -s "$API_URL/api/seats")
-SEAT_STATUS=$(echo "$SEATS" | grep -o "\"id\":\"$SEAT_ID\"[^}]*" | grep -o '"status":[0-9]' | cut -d':' -f2)
+SEATS=$(curl -s "$API_URL/api/v1/seats")
+SEAT_STATUS=$(echo "$SEATS" | grep -o "\"id\":\"$SEAT_ID\"[^}]*" | grep -o '"status":"[a-z]*"' | cut -d'"' -f4)
 
-if [ "$SEAT_STATUS" == "2" ]; then
+if [ "$SEAT_STATUS" == "booked" ]; then
     echo -e "   ${GREEN}✓${NC} Seat is permanently booked"
 else
     echo -e "   ${RED}✗${NC} Seat booking verification failed (status: $SEAT_STATUS)"
@@ -96,7 +96,7 @@ fi
 # Step 6: Try to select already booked seat (should fail)
 echo ""
 echo "6. Testing conflict - trying to select booked seat..."
-CONFLICT_RESPONSE=$(curl -s -X POST "$API_URL/api/seats/select" \
+CONFLICT_RESPONSE=$(curl -s -X POST "$API_URL/api/v1/seats/select" \
     -H "Content-Type: application/json" \
     -d "{\"seat_id\":\"$SEAT_ID\",\"user_id\":\"another-user\"}")
 
@@ -111,17 +111,17 @@ echo ""
 echo "7. Testing auto-release of held seats..."
 TEMP_SEAT="F6"
 echo "   Selecting seat $TEMP_SEAT..."
-curl -s -X POST "$API_URL/api/seats/select" \
+curl -s -X POST "$API_URL/api/v1/seats/select" \
     -H "Content-Type: application/json" \
     -d "{\"seat_id\":\"$TEMP_SEAT\",\"user_id\":\"temp-user\"}" > /dev/null
 
 echo "   Waiting 32 seconds for auto-release..."
 sleep 32
 
-SEATS=$(curl -s "$API_URL/api/seats")
-TEMP_STATUS=$(echo "$SEATS" | grep -o "\"id\":\"$TEMP_SEAT\"[^}]*" | grep -o '"status":[0-9]' | cut -d':' -f2)
+SEATS=$(curl -s "$API_URL/api/v1/seats")
+TEMP_STATUS=$(echo "$SEATS" | grep -o "\"id\":\"$TEMP_SEAT\"[^}]*" | grep -o '"status":"[a-z]*"' | cut -d'"' -f4)
 
-if [ "$TEMP_STATUS" == "0" ]; then
+if [ "$TEMP_STATUS" == "available" ]; then
     echo -e "   ${GREEN}✓${NC} Seat auto-released after timeout"
 else
     echo -e "   ${YELLOW}⚠${NC} Auto-release may not have triggered yet"
@@ -103,8 +103,8 @@ else
 fi
 
 # Test 2: Get all seats
-echo -n "Testing GET /api/seats... "
-SEATS_RESPONSE=$(curl -s http://localhost:8080/api/seats)
+echo -n "Testing GET /api/v1/seats... "
+SEATS_RESPONSE=$(curl -s http://localhost:8080/api/v1/seats)
 if echo "$SEATS_RESPONSE" | grep -q "A1"; then
     echo -e "${GREEN}✓${NC}"
 else
@@ -113,13 +113,13 @@ fi
 
 # Test 3: Select a seat
 echo -n "Testing seat selection... "
-SELECT_RESPONSE=$(curl -s -X POST http://localhost:8080/api/seats/select \
+SELECT_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/seats/select \
     -H "Content-Type: application/json" \
     -d '{"seat_id":"A1","user_id":"test-user-1"}')
     
 if echo "$SELECT_RESPONSE" | grep -q "error"; then
     # Might already be selected, try another seat
-    SELECT_RESPONSE=$(curl -s -X POST http://localhost:8080/api/seats/select \
+    SELECT_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/seats/select \
         -H "Content-Type: application/json" \
         -d '{"seat_id":"B2","user_id":"test-user-1"}')
 fi
@@ -133,7 +133,7 @@ fi
 
 # Test 4: Book a seat
 echo -n "Testing seat booking... "
-BOOK_RESPONSE=$(curl -s -X POST http://localhost:8080/api/seats/book \
+BOOK_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/seats/book \
     -H "Content-Type: application/json" \
     -d '{"seat_id":"C3","user_id":"test-user-2"}')
     
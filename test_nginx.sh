@@ -32,13 +32,13 @@ echo ""
 echo "2. Testing API through NGINX:"
 echo "-----------------------------"
 
-echo -n "  • GET /api/seats: "
-SEATS=$(curl -s http://localhost/api/seats)
+echo -n "  • GET /api/v1/seats: "
+SEATS=$(curl -s http://localhost/api/v1/seats)
 if echo "$SEATS" | grep -q "A1"; then
     echo -e "${GREEN}✅ OK${NC}"
-    AVAILABLE=$(echo "$SEATS" | grep -o '"status":0' | wc -l | tr -d ' ')
-    HELD=$(echo "$SEATS" | grep -o '"status":1' | wc -l | tr -d ' ')
-    BOOKED=$(echo "$SEATS" | grep -o '"status":2' | wc -l | tr -d ' ')
+    AVAILABLE=$(echo "$SEATS" | grep -o '"status":"available"' | wc -l | tr -d ' ')
+    HELD=$(echo "$SEATS" | grep -o '"status":"held"' | wc -l | tr -d ' ')
+    BOOKED=$(echo "$SEATS" | grep -o '"status":"booked"' | wc -l | tr -d ' ')
     echo "      Available: $AVAILABLE, Held: $HELD, Booked: $BOOKED"
 else
     echo "❌ Failed"
@@ -31,7 +31,7 @@ echo ""
 
 # Select a seat
 echo "🎬 Selecting seat $SEAT_ID..."
-RESPONSE=$(curl -s -X POST http://localhost:8080/api/seats/select \
+RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/seats/select \
     -H "Content-Type: application/json" \
     -d "{\"seat_id\":\"$SEAT_ID\",\"user_id\":\"$USER_ID\"}")
 
@@ -48,7 +48,7 @@ sleep 2
 # Book the seat to trigger another event
 echo ""
 echo "📚 Booking seat $SEAT_ID..."
-RESPONSE=$(curl -s -X POST http://localhost:8080/api/seats/book \
+RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/seats/book \
     -H "Content-Type: application/json" \
     -d "{\"seat_id\":\"$SEAT_ID\",\"user_id\":\"$USER_ID\"}")
 